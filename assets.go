@@ -0,0 +1,86 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+
+	"github.com/gin-gonic/gin/render"
+)
+
+// Templates and widget assets are compiled into the binary, so a container
+// image without the source tree still serves pages. Uploads stay on disk.
+//
+//go:embed templates static/js static/css
+var embeddedAssets embed.FS
+
+const templatePattern = "templates/*/*.html"
+
+// Assets the server cannot work without, checked by --check-assets
+var requiredTemplates = []string{"chat.html", "prechat.html", "error.html"}
+var requiredStatic = []string{"static/js/jevi-chat-widget.js", "static/css/jevi-widget.css"}
+
+// fallbackPage is rendered when a handler asks for a template that does not exist
+var fallbackPage = template.Must(template.New("fallback").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Jevi Chat</title></head>
+<body style="font-family:sans-serif;text-align:center;padding:40px">
+<p>{{if .error}}{{.error}}{{else}}This page is temporarily unavailable. Please try again later.{{end}}</p>
+</body></html>`))
+
+// assetFS prefers templates and assets on disk, so edits show up without a
+// rebuild, and falls back to the embedded copies when they are missing
+func assetFS() (fs.FS, string) {
+	if info, err := os.Stat("templates"); err == nil && info.IsDir() {
+		return os.DirFS("."), "disk"
+	}
+	return embeddedAssets, "embedded"
+}
+
+// loadTemplates parses every template. Unlike LoadHTMLGlob it returns an
+// error instead of panicking.
+func loadTemplates(assets fs.FS) (*template.Template, error) {
+	return template.ParseFS(assets, templatePattern)
+}
+
+// fallbackRender resolves templates by base name, the way LoadHTMLGlob
+// names them, and renders fallbackPage for any name it cannot find
+type fallbackRender struct {
+	templates *template.Template
+}
+
+func (r fallbackRender) Instance(name string, data any) render.Render {
+	if r.templates != nil {
+		if r.templates.Lookup(name) != nil {
+			return render.HTML{Template: r.templates, Name: name, Data: data}
+		}
+		if base := path.Base(name); r.templates.Lookup(base) != nil {
+			return render.HTML{Template: r.templates, Name: base, Data: data}
+		}
+	}
+	log.Printf("⚠️ Template %q not found, rendering fallback page", name)
+	return render.HTML{Template: fallbackPage, Name: "fallback", Data: data}
+}
+
+// checkAssets validates that every required template and widget asset is
+// present and parses, for use as a container health check
+func checkAssets(assets fs.FS) error {
+	templates, err := loadTemplates(assets)
+	if err != nil {
+		return fmt.Errorf("templates: %v", err)
+	}
+	for _, name := range requiredTemplates {
+		if templates.Lookup(name) == nil {
+			return fmt.Errorf("missing template %s", name)
+		}
+	}
+	for _, name := range requiredStatic {
+		if _, err := fs.Stat(assets, name); err != nil {
+			return fmt.Errorf("missing asset %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MessageRequest is one visitor message
+type MessageRequest struct {
+	Message   string `json:"message"`
+	SessionID string `json:"session_id,omitempty"` // empty starts a new session
+	UserToken string `json:"user_token,omitempty"` // signed-in chat user, if any
+
+	// HistoryToken is the one returned with the session's last reply. It
+	// is required to continue a session that is not bound to UserToken's
+	// chat user.
+	HistoryToken string `json:"history_token,omitempty"`
+
+	// ClientMessageID makes a resend safe: a message the server already
+	// answered is replied to from the stored answer instead of twice
+	ClientMessageID string `json:"client_message_id,omitempty"`
+}
+
+// Usage is the project's quota position as reported with a reply
+type Usage struct {
+	MonthlyUsage     int `json:"monthly_usage"`
+	MonthlyLimit     int `json:"monthly_limit"`
+	MonthlyRemaining int `json:"monthly_remaining"`
+}
+
+// Citation is a document passage an answer drew on. Offset and Length are
+// in characters within the page's extracted text.
+type Citation struct {
+	FileID     string `json:"file_id"`
+	FileName   string `json:"file_name"`
+	Page       int    `json:"page"`
+	Offset     int    `json:"offset"`
+	Length     int    `json:"length"`
+	Snippet    string `json:"snippet"`
+	PreviewURL string `json:"preview_url"` // admin page preview at the passage
+}
+
+// Reply is the answer to a message
+type Reply struct {
+	Response  string `json:"response"`
+	SessionID string `json:"session_id"`
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+	Usage     Usage  `json:"usage_info"`
+	Duplicate bool   `json:"duplicate"` // answered from an earlier send of the same ClientMessageID
+
+	Citations []Citation `json:"citations"`
+
+	// HistoryToken reads this session's history with History
+	HistoryToken string `json:"history_token"`
+}
+
+// SendMessage sends a message and waits for the whole answer. A project
+// that is out of quota yields an *APIError for which IsQuotaExceeded is
+// true.
+func (c *Client) SendMessage(ctx context.Context, req MessageRequest) (*Reply, error) {
+	var raw json.RawMessage
+	if err := c.do(ctx, http.MethodPost, c.projectPath("/message"), req, &raw); err != nil {
+		return nil, err
+	}
+	var reply Reply
+	var limit errorBody
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return nil, fmt.Errorf("jevi: decoding reply: %w", err)
+	}
+	if json.Unmarshal(raw, &limit) == nil && limit.Code != "" {
+		apiErr := &APIError{StatusCode: http.StatusOK, Message: reply.Response}
+		apiErr.fromBody(limit)
+		return nil, apiErr
+	}
+	return &reply, nil
+}
+
+// Session is a chat session started with CreateSession
+type Session struct {
+	ID string `json:"session_id"`
+
+	// HistoryToken continues the session in the first MessageRequest
+	HistoryToken string `json:"history_token"`
+}
+
+// CreateSession starts a chat session, bound to the chat user if userToken
+// is set
+func (c *Client) CreateSession(ctx context.Context, userToken string) (*Session, error) {
+	var session Session
+	req := map[string]string{"user_token": userToken}
+	if err := c.do(ctx, http.MethodPost, c.projectPath("/session"), req, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// StreamResult summarizes a streamed answer
+type StreamResult struct {
+	SessionID    string
+	Status       string // success, or incomplete if generation stopped early
+	Text         string // the full answer
+	InputTokens  int
+	OutputTokens int
+	DryRun       bool
+	Citations    []Citation
+	HistoryToken string // reads this session's history with History
+}
+
+// Stream sends a message and calls onChunk with each piece of the answer as
+// it arrives. Returning an error from onChunk stops the stream. Limit
+// errors are reported like SendMessage's.
+func (c *Client) Stream(ctx context.Context, req MessageRequest, onChunk func(text string) error) (*StreamResult, error) {
+	resp, err := c.send(ctx, http.MethodPost, c.projectPath("/message/stream"), req, "text/event-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &StreamResult{SessionID: req.SessionID}
+	var text strings.Builder
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		case !strings.HasPrefix(line, "data:"):
+			continue
+		}
+		data := []byte(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+
+		switch event {
+		case "chunk":
+			var chunk struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return nil, fmt.Errorf("jevi: malformed chunk: %w", err)
+			}
+			text.WriteString(chunk.Text)
+			if onChunk != nil {
+				if err := onChunk(chunk.Text); err != nil {
+					return nil, err
+				}
+			}
+		case "error":
+			var body errorBody
+			json.Unmarshal(data, &body)
+			return nil, &APIError{StatusCode: resp.StatusCode, Code: "stream_error", Message: body.Message}
+		case "done":
+			var done struct {
+				errorBody
+				SessionID string     `json:"session_id"`
+				DryRun    bool       `json:"dry_run"`
+				Citations []Citation `json:"citations"`
+				History   string     `json:"history_token"`
+				UsageInfo struct {
+					InputTokens  int `json:"input_tokens"`
+					OutputTokens int `json:"output_tokens"`
+				} `json:"usage_info"`
+			}
+			if err := json.Unmarshal(data, &done); err != nil {
+				return nil, fmt.Errorf("jevi: malformed done event: %w", err)
+			}
+			if done.errorBody.Code != "" {
+				apiErr := &APIError{StatusCode: resp.StatusCode, Message: text.String()}
+				apiErr.fromBody(done.errorBody)
+				return nil, apiErr
+			}
+			result.SessionID = done.SessionID
+			result.Status = done.Status
+			result.DryRun = done.DryRun
+			result.Citations = done.Citations
+			result.HistoryToken = done.History
+			result.InputTokens = done.UsageInfo.InputTokens
+			result.OutputTokens = done.UsageInfo.OutputTokens
+			result.Text = text.String()
+			return result, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("jevi: stream ended before the answer was complete")
+}
+
+// HistoryMessage is one stored exchange
+type HistoryMessage struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	Message   string    `json:"message"`
+	Response  string    `json:"response"`
+	Timestamp time.Time `json:"timestamp"`
+	UserName  string    `json:"user_name,omitempty"`
+	Rating    int       `json:"rating,omitempty"`
+	DryRun    bool      `json:"dry_run,omitempty"`
+}
+
+// History is a page of chat history, newest first
+type History struct {
+	Messages   []HistoryMessage `json:"messages"`
+	TotalCount int64            `json:"total_count"`
+}
+
+// History returns the latest messages of the session a history token was
+// issued for. Tokens come with every reply and expire after a day.
+func (c *Client) History(ctx context.Context, historyToken string) (*History, error) {
+	path := "/user/chat/" + c.projectID + "/history?token=" + url.QueryEscape(historyToken)
+	var history History
+	if err := c.do(ctx, http.MethodGet, path, nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// Overage is the project's pay-as-you-go allowance beyond the monthly limit
+type Overage struct {
+	Enabled   bool    `json:"enabled"`
+	Used      int     `json:"used"`
+	Cap       int     `json:"cap"`
+	Remaining int     `json:"remaining"`
+	Rate      float64 `json:"rate"`
+	Cost      float64 `json:"cost"`
+}
+
+// ProjectUsage is the project's Gemini usage in the current billing period
+type ProjectUsage struct {
+	MonthlyUsage     int       `json:"monthly_usage"`
+	MonthlyLimit     int       `json:"monthly_limit"`
+	MonthlyRemaining int       `json:"monthly_remaining"`
+	PeriodStart      time.Time `json:"period_start"`
+	ResetsAt         time.Time `json:"resets_at"`
+	Overage          Overage   `json:"overage"`
+}
+
+// Usage returns the project's usage. It requires WithSigningKey.
+func (c *Client) Usage(ctx context.Context) (*ProjectUsage, error) {
+	if c.signingSecret == "" {
+		return nil, fmt.Errorf("jevi: Usage requires a signing key")
+	}
+	var usage ProjectUsage
+	if err := c.do(ctx, http.MethodGet, c.projectPath("/usage"), nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// RateLimit is the caller's budget for one limiter
+type RateLimit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"-"`
+}
+
+func (r *RateLimit) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Limit     int   `json:"limit"`
+		Remaining int   `json:"remaining"`
+		Reset     int64 `json:"reset"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	r.Limit, r.Remaining, r.Reset = raw.Limit, raw.Remaining, time.Unix(raw.Reset, 0)
+	return nil
+}
+
+// RateLimitStatus returns the caller's remaining budget per limiter (chat,
+// auth, general) without using any of it
+func (c *Client) RateLimitStatus(ctx context.Context) (map[string]RateLimit, error) {
+	var body struct {
+		Limits map[string]RateLimit `json:"limits"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/rate-limit/status", nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Limits, nil
+}
@@ -0,0 +1,252 @@
+// Package client is a Go client for the Jevi Chat public API: sending
+// widget messages (whole or streamed), reading chat history, and checking
+// usage and rate limits.
+//
+// It is its own module and depends only on the standard library, so it can
+// be imported without pulling in the server:
+//
+//	go get github.com/mansuri-sabit/geminiback/client
+//
+//	c := client.New("https://chat.example.com", projectID,
+//		client.WithSigningKey(os.Getenv("JEVI_TOKEN"), os.Getenv("JEVI_SECRET")))
+//	reply, err := c.SendMessage(ctx, client.MessageRequest{Message: "Hi"})
+//	if client.IsQuotaExceeded(err) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names shared with the server
+const (
+	headerSigningToken = "X-Jevi-Token"
+	headerTimestamp    = "X-Jevi-Timestamp"
+	headerNonce        = "X-Jevi-Nonce"
+	headerSignature    = "X-Jevi-Signature"
+	headerDryRun       = "X-Jevi-Dry-Run"
+	headerAPIKey       = "X-Jevi-Key"
+)
+
+// Client calls the API for one project. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	projectID  string
+	httpClient *http.Client
+
+	signingToken  string
+	signingSecret string
+	dryRunKey     string
+	apiKey        string
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client (30s timeout). Streaming
+// calls are bounded by their context, so a client without a timeout is
+// better suited to long streams.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithSigningKey signs every request with the project's signing key pair.
+// Required for projects with signing enabled and for Usage.
+func WithSigningKey(token, secret string) Option {
+	return func(c *Client) { c.signingToken, c.signingSecret = token, secret }
+}
+
+// WithDryRunKey sends the project's dry-run key, so messages are answered
+// with a canned reply and use no quota
+func WithDryRunKey(key string) Option {
+	return func(c *Client) { c.dryRunKey = key }
+}
+
+// WithAPIKey sends one of the project's API keys, required once the
+// project has issued one. A project that restricts its widget to an
+// allowlist of sites only takes server-side calls made with a secret key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithRetries sets how many times a failed request is retried (default 3)
+// and the backoff bounds between attempts. Rate-limited requests wait for
+// the server's Retry-After instead, capped at max.
+func WithRetries(n int, min, max time.Duration) Option {
+	return func(c *Client) { c.maxRetries, c.minBackoff, c.maxBackoff = n, min, max }
+}
+
+// New returns a client for projectID on the server at baseURL
+func New(baseURL, projectID string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		projectID:  projectID,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		minBackoff: 500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// do sends a request, retrying as allowed, and decodes a JSON response into
+// out. Non-2xx responses are returned as *APIError.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("jevi: decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+// send performs the request with retries and returns a 2xx response whose
+// body the caller must close
+func (c *Client) send(ctx context.Context, method, path string, body interface{}, accept string) (*http.Response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("jevi: encoding request: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, method, path, payload, accept)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var apiErr *APIError
+		if err == nil {
+			apiErr = decodeAPIError(resp)
+			resp.Body.Close()
+			err = apiErr
+		}
+		if attempt >= c.maxRetries || !c.retryable(method, apiErr, err) {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt)
+		if apiErr != nil && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+			if wait > c.maxBackoff {
+				return nil, err
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", accept)
+	if c.dryRunKey != "" {
+		req.Header.Set(headerDryRun, c.dryRunKey)
+	}
+	if c.apiKey != "" {
+		req.Header.Set(headerAPIKey, c.apiKey)
+	}
+	if c.signingSecret != "" {
+		if err := c.sign(req, path, payload); err != nil {
+			return nil, err
+		}
+	}
+	return c.httpClient.Do(req)
+}
+
+// sign adds the request signature headers. Each attempt gets a fresh nonce,
+// since the server refuses a nonce it has seen.
+func (c *Client) sign(req *http.Request, path string, payload []byte) error {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return fmt.Errorf("jevi: generating nonce: %w", err)
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	bodyHash := sha256.Sum256(payload)
+	signed := c.signingToken + "\n" + timestamp + "\n" + nonce + "\n" + req.Method + "\n" + path + "\n" + hex.EncodeToString(bodyHash[:])
+	mac := hmac.New(sha256.New, []byte(c.signingSecret))
+	mac.Write([]byte(signed))
+
+	req.Header.Set(headerSigningToken, c.signingToken)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerSignature, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// retryable reports whether a failed attempt may be repeated. Messages are
+// not idempotent, so POSTs are only retried when the server certainly did
+// not process them: rate limits and 503s.
+func (c *Client) retryable(method string, apiErr *APIError, err error) bool {
+	if apiErr == nil {
+		// Transport error; only safe to repeat for reads
+		return method == http.MethodGet && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return method == http.MethodGet
+	}
+	return false
+}
+
+// backoff doubles from minBackoff, capped at maxBackoff
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << uint(attempt)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d
+}
+
+func (c *Client) projectPath(suffix string) string {
+	return "/embed/" + c.projectID + suffix
+}
+
+func readAll(r io.Reader) []byte {
+	b, _ := io.ReadAll(io.LimitReader(r, 1<<20))
+	return b
+}
@@ -0,0 +1,295 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testProject = "64b7f0c2a1b2c3d4e5f60718"
+	testToken   = "tok_test"
+	testSecret  = "secret_test"
+)
+
+// verifySignature checks a request the way the server does and returns
+// its nonce
+func verifySignature(t *testing.T, r *http.Request, body []byte) string {
+	t.Helper()
+	if got := r.Header.Get(headerSigningToken); got != testToken {
+		t.Errorf("signing token = %q, want %q", got, testToken)
+	}
+	timestamp, nonce := r.Header.Get(headerTimestamp), r.Header.Get(headerNonce)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > time.Minute {
+		t.Errorf("timestamp %q is not the current time", timestamp)
+	}
+	if nonce == "" {
+		t.Error("request has no nonce")
+	}
+
+	bodyHash := sha256.Sum256(body)
+	signed := testToken + "\n" + timestamp + "\n" + nonce + "\n" + r.Method + "\n" + r.URL.Path + "\n" + hex.EncodeToString(bodyHash[:])
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(signed))
+	if got, want := r.Header.Get(headerSignature), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	return nonce
+}
+
+func TestSendMessageSignsRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/embed/"+testProject+"/message" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		verifySignature(t, r, body)
+		if got := r.Header.Get(headerAPIKey); got != "pk_test" {
+			t.Errorf("API key = %q, want pk_test", got)
+		}
+
+		var req MessageRequest
+		if err := json.Unmarshal(body, &req); err != nil || req.Message != "Hi" {
+			t.Errorf("request body = %s", body)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"response":   "Hello!",
+			"session_id": "s1",
+			"usage_info": map[string]int{"monthly_usage": 3, "monthly_limit": 100, "monthly_remaining": 97},
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, testProject, WithSigningKey(testToken, testSecret), WithAPIKey("pk_test"))
+	reply, err := c.SendMessage(context.Background(), MessageRequest{Message: "Hi"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if reply.Response != "Hello!" || reply.SessionID != "s1" || reply.Usage.MonthlyRemaining != 97 {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestSignatureIgnoresQueryString(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery == "" {
+			t.Error("query string was dropped")
+		}
+		verifySignature(t, r, nil)
+		json.NewEncoder(w).Encode(map[string]interface{}{"messages": []interface{}{}})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, testProject, WithSigningKey(testToken, testSecret))
+	if _, err := c.History(context.Background(), "history-token"); err != nil {
+		t.Fatalf("History: %v", err)
+	}
+}
+
+func TestRetriesUseFreshNonces(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		nonces []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		nonces = append(nonces, verifySignature(t, r, body))
+		attempt := len(nonces)
+		mu.Unlock()
+		if attempt < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "busy"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"response": "ok"})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, testProject, WithSigningKey(testToken, testSecret), WithRetries(3, time.Millisecond, 10*time.Millisecond))
+	if _, err := c.SendMessage(context.Background(), MessageRequest{Message: "Hi"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if len(nonces) != 3 {
+		t.Fatalf("server saw %d attempts, want 3", len(nonces))
+	}
+	if nonces[0] == nonces[1] || nonces[1] == nonces[2] || nonces[0] == nonces[2] {
+		t.Errorf("retries reused a nonce: %v", nonces)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   string
+		get      bool
+		retries  int
+		attempts int
+	}{
+		{name: "POST retried on 429", status: http.StatusTooManyRequests, retries: 2, attempts: 3},
+		{name: "POST retried on 503", status: http.StatusServiceUnavailable, retries: 2, attempts: 3},
+		{name: "POST not retried on 500", status: http.StatusInternalServerError, retries: 2, attempts: 1},
+		{name: "POST not retried on 400", status: http.StatusBadRequest, retries: 2, attempts: 1},
+		{name: "GET retried on 502", status: http.StatusBadGateway, get: true, retries: 2, attempts: 3},
+		{name: "GET not retried on 404", status: http.StatusNotFound, get: true, retries: 2, attempts: 1},
+		{name: "Retry-After beyond max backoff", status: http.StatusTooManyRequests, header: "60", retries: 2, attempts: 1},
+		{name: "retries disabled", status: http.StatusServiceUnavailable, retries: 0, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(tt.status)
+				json.NewEncoder(w).Encode(map[string]string{"error": "nope"})
+			}))
+			defer srv.Close()
+
+			c := New(srv.URL, testProject, WithSigningKey(testToken, testSecret),
+				WithRetries(tt.retries, time.Millisecond, 10*time.Millisecond))
+			var err error
+			if tt.get {
+				_, err = c.Usage(context.Background())
+			} else {
+				_, err = c.SendMessage(context.Background(), MessageRequest{Message: "Hi"})
+			}
+
+			apiErr, ok := err.(*APIError)
+			if !ok || apiErr.StatusCode != tt.status {
+				t.Fatalf("err = %v, want an APIError with status %d", err, tt.status)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("server saw %d attempts, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextEnds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	c := New(srv.URL, testProject, WithRetries(5, time.Second, time.Second))
+	if _, err := c.SendMessage(ctx, MessageRequest{Message: "Hi"}); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/embed/"+testProject+"/usage" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		verifySignature(t, r, nil)
+		io.WriteString(w, `{
+			"monthly_usage": 120, "monthly_limit": 100, "monthly_remaining": 0,
+			"period_start": "2026-10-01T00:00:00Z", "resets_at": "2026-11-01T00:00:00Z",
+			"overage": {"enabled": true, "used": 20, "cap": 50, "remaining": 30, "rate": 0.01, "cost": 0.2}
+		}`)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, testProject, WithSigningKey(testToken, testSecret))
+	usage, err := c.Usage(context.Background())
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if usage.MonthlyUsage != 120 || usage.MonthlyRemaining != 0 || !usage.ResetsAt.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("usage = %+v", usage)
+	}
+	if o := usage.Overage; !o.Enabled || o.Used != 20 || o.Remaining != 30 || o.Cost != 0.2 {
+		t.Errorf("overage = %+v", o)
+	}
+}
+
+func TestUsageRequiresSigningKey(t *testing.T) {
+	c := New("http://127.0.0.1:0", testProject)
+	if _, err := c.Usage(context.Background()); err == nil {
+		t.Error("Usage without a signing key succeeded")
+	}
+}
+
+func TestQuotaExceededReply(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The widget route answers limit errors with 200 and a canned reply
+		io.WriteString(w, `{
+			"response": "This project has reached its monthly limit.",
+			"code": "monthly_limit_exceeded",
+			"limit_info": {"code": "monthly_limit_exceeded", "limit_type": "monthly", "limit": 100, "usage": 100, "remaining": 0}
+		}`)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, testProject)
+	_, err := c.SendMessage(context.Background(), MessageRequest{Message: "Hi"})
+	if !IsQuotaExceeded(err) {
+		t.Fatalf("err = %v, want a quota error", err)
+	}
+	if apiErr := err.(*APIError); apiErr.Limit == nil || apiErr.Limit.Limit != 100 {
+		t.Errorf("limit info = %+v", apiErr.Limit)
+	}
+}
+
+func TestRateLimitStatus(t *testing.T) {
+	reset := time.Now().Add(time.Minute).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/rate-limit/status" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"limits": map[string]interface{}{
+				"chat": map[string]int64{"limit": 30, "remaining": 29, "reset": reset},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	limits, err := New(srv.URL, testProject).RateLimitStatus(context.Background())
+	if err != nil {
+		t.Fatalf("RateLimitStatus: %v", err)
+	}
+	chat := limits["chat"]
+	if chat.Limit != 30 || chat.Remaining != 29 || chat.Reset.Unix() != reset {
+		t.Errorf("chat limit = %+v", chat)
+	}
+}
+
+func TestCreateSessionReturnsHistoryToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/embed/"+testProject+"/session" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "session_id": "s1", "history_token": "h1"})
+	}))
+	defer srv.Close()
+
+	session, err := New(srv.URL, testProject).CreateSession(context.Background(), "")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if session.ID != "s1" || session.HistoryToken != "h1" {
+		t.Errorf("session = %+v", session)
+	}
+}
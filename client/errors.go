@@ -0,0 +1,100 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error codes the server sends with limit errors
+const (
+	CodeMonthlyLimitExceeded = "monthly_limit_exceeded"
+	CodeOverageCapReached    = "overage_cap_reached"
+	CodeRateLimited          = "rate_limited"
+)
+
+// LimitInfo is the structured detail sent with a limit error
+type LimitInfo struct {
+	Code       string `json:"code"`
+	LimitType  string `json:"limit_type"`
+	Limit      int    `json:"limit"`
+	Usage      int    `json:"usage"`
+	Remaining  int    `json:"remaining"`
+	ResetsAt   string `json:"resets_at"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// APIError is an error reported by the server. Limit errors are returned as
+// APIErrors even when the server answers them with 200 and a canned reply.
+type APIError struct {
+	StatusCode int
+	Code       string // machine-readable code, when the server sent one
+	Message    string
+	Limit      *LimitInfo
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("jevi: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("jevi: %s (%d)", e.Message, e.StatusCode)
+}
+
+// IsRateLimited reports whether err is a rate limit refusal
+func IsRateLimited(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Code == CodeRateLimited || apiErr.StatusCode == http.StatusTooManyRequests)
+}
+
+// IsQuotaExceeded reports whether err means the project has used up its
+// monthly limit (and overage allowance, if any)
+func IsQuotaExceeded(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.Code == CodeMonthlyLimitExceeded || apiErr.Code == CodeOverageCapReached)
+}
+
+// errorBody covers the error shapes the server uses
+type errorBody struct {
+	Error     string     `json:"error"`
+	Message   string     `json:"message"`
+	Details   string     `json:"details"`
+	Code      string     `json:"code"`
+	Status    string     `json:"status"`
+	LimitInfo *LimitInfo `json:"limit_info"`
+}
+
+func decodeAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+
+	var body errorBody
+	if json.Unmarshal(readAll(resp.Body), &body) == nil {
+		apiErr.fromBody(body)
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return apiErr
+}
+
+func (e *APIError) fromBody(body errorBody) {
+	switch {
+	case body.Error != "" && body.Details != "":
+		e.Message = body.Error + ": " + body.Details
+	case body.Error != "":
+		e.Message = body.Error
+	case body.Message != "":
+		e.Message = body.Message
+	}
+	e.Code = body.Code
+	if e.Code == "" {
+		e.Code = body.Status
+	}
+	e.Limit = body.LimitInfo
+	if e.Limit != nil && e.Limit.RetryAfter > 0 {
+		e.RetryAfter = time.Duration(e.Limit.RetryAfter) * time.Second
+	}
+}
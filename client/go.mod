@@ -0,0 +1,3 @@
+module github.com/mansuri-sabit/geminiback/client
+
+go 1.23.0
@@ -0,0 +1,379 @@
+// Command jevictl runs common operations tasks against the Jevi Chat
+// database using the same configuration as the server (MONGODB_URI and
+// friends, read from the environment or .env).
+//
+//	jevictl create-admin -email ops@example.com -username ops [-super]
+//	jevictl rotate-keys -project <id> [-moderation]
+//	jevictl migrate
+//	jevictl reset-usage -project <id>
+//	jevictl export-project -project <id> [-out file.json] [-redact pii|external]
+//	jevictl cleanup [-orphans]
+//	jevictl tenants [-top 20]
+//	jevictl move-tenant -project <id> -db <name|main> [-settle 70s]
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+	"jevi-chat/utils"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"create-admin", "create an admin user (password is read from stdin)", createAdmin},
+	{"rotate-keys", "issue a new widget signing key pair or moderation secret", rotateKeys},
+	{"migrate", "create missing indexes and backfill legacy project IDs", migrate},
+	{"reset-usage", "reset a project's monthly Gemini usage", resetUsage},
+	{"export-project", "write a project and its data as JSON", exportProject},
+	{"cleanup", "expire notifications, archive cold chats, optionally clean orphans", cleanup},
+	{"tenants", "list projects by chat storage and the database they use", tenants},
+	{"move-tenant", "move a project's chats to a dedicated database or back to main", moveTenant},
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+
+	godotenv.Load()
+	config.LoadManagedSecrets()
+	config.InitMongoDB()
+	defer config.Client.Disconnect(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if err := cmd.run(ctx, os.Args[2:]); err != nil {
+		log.Printf("❌ %s: %v", cmd.name, err)
+		cancel()
+		config.Client.Disconnect(context.Background())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: jevictl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", c.name, c.summary)
+	}
+}
+
+// audit records a CLI action in the same log as dashboard actions
+func audit(ctx context.Context, action, targetType, targetID string, projectID primitive.ObjectID, details map[string]interface{}) {
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "unknown"
+	}
+	entry := models.AuditLog{
+		ActorID:    "jevictl:" + actor,
+		ActorRole:  "cli",
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		ProjectID:  projectID,
+		Details:    details,
+		CreatedAt:  time.Now(),
+	}
+	if _, err := config.GetAuditLogsCollection().InsertOne(ctx, entry); err != nil {
+		log.Printf("⚠️ Failed to write audit log (%s): %v", action, err)
+	}
+}
+
+func projectFlag(fs *flag.FlagSet) *string {
+	return fs.String("project", "", "project ID")
+}
+
+func parseProject(id string) (primitive.ObjectID, error) {
+	if id == "" {
+		return primitive.NilObjectID, fmt.Errorf("-project is required")
+	}
+	return models.ParseProjectID(id)
+}
+
+func createAdmin(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := fs.String("email", "", "email address")
+	username := fs.String("username", "", "display name (defaults to the email)")
+	super := fs.Bool("super", false, "create a super-admin (platform operator)")
+	fs.Parse(args)
+
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+	if *username == "" {
+		*username = *email
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	role := models.RoleAdmin
+	if *super {
+		role = models.RoleSuperAdmin
+	}
+	now := time.Now()
+	user := models.User{
+		Username:  *username,
+		Email:     *email,
+		Password:  string(hashed),
+		IsActive:  true,
+		Role:      role,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := repository.CreateUser(ctx, &user); err != nil {
+		return err
+	}
+
+	audit(ctx, models.AuditActionUserCreate, "user", user.ID.Hex(), primitive.NilObjectID, map[string]interface{}{
+		"email": user.Email,
+		"role":  role,
+	})
+	log.Printf("✅ Created %s %s (%s)", role, user.Email, user.ID.Hex())
+	return nil
+}
+
+func rotateKeys(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	id := projectFlag(fs)
+	moderation := fs.Bool("moderation", false, "rotate the moderation webhook secret instead of the signing keys")
+	fs.Parse(args)
+
+	projectID, err := parseProject(*id)
+	if err != nil {
+		return err
+	}
+
+	if *moderation {
+		secret, err := repository.RotateModerationSecret(ctx, projectID)
+		if err != nil {
+			return err
+		}
+		audit(ctx, models.AuditActionModerationUpdate, "project", projectID.Hex(), projectID, map[string]interface{}{
+			"secret_rotated": true,
+		})
+		fmt.Printf("webhook_secret=%s\n", secret)
+		return nil
+	}
+
+	token, secret, err := repository.RotateSigningKeys(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	audit(ctx, models.AuditActionSigningUpdate, "project", projectID.Hex(), projectID, map[string]interface{}{
+		"enabled":    true,
+		"key_issued": true,
+	})
+	fmt.Printf("signing_public_token=%s\nsigning_secret=%s\n", token, secret)
+	return nil
+}
+
+func migrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Parse(args)
+
+	created, err := config.CreateMissingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	for collection, names := range created {
+		log.Printf("📈 %s: created %v", collection, names)
+	}
+
+	converted, skipped, err := config.BackfillProjectIDs(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("✅ Migrations complete: %d project IDs converted, %d skipped", converted, skipped)
+	return nil
+}
+
+func resetUsage(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reset-usage", flag.ExitOnError)
+	id := projectFlag(fs)
+	fs.Parse(args)
+
+	projectID, err := parseProject(*id)
+	if err != nil {
+		return err
+	}
+	if err := repository.ResetMonthlyUsage(ctx, projectID); err != nil {
+		return err
+	}
+
+	audit(ctx, models.AuditActionUsageReset, "project", projectID.Hex(), projectID, nil)
+	log.Printf("✅ Monthly usage reset for project %s", projectID.Hex())
+	return nil
+}
+
+func exportProject(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export-project", flag.ExitOnError)
+	id := projectFlag(fs)
+	out := fs.String("out", "", "output file (default stdout)")
+	redact := fs.String("redact", utils.RedactNone, "redaction profile: "+strings.Join(utils.RedactionProfileNames(), ", "))
+	fs.Parse(args)
+
+	projectID, err := parseProject(*id)
+	if err != nil {
+		return err
+	}
+	profile, ok := utils.LookupRedactionProfile(*redact)
+	if !ok {
+		return fmt.Errorf("unknown redaction profile %q", *redact)
+	}
+	export, err := repository.ExportProject(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	export.Redact(profile)
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return err
+	}
+	log.Printf("✅ Exported %d messages, %d chat users, %d sessions", len(export.Messages), len(export.ChatUsers), len(export.Sessions))
+	return nil
+}
+
+func cleanup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	orphans := fs.Bool("orphans", false, "also delete data whose project no longer exists")
+	fs.Parse(args)
+
+	// Archival writes to object storage
+	config.InitStorage()
+	if err := config.CleanupExpiredData(); err != nil {
+		return err
+	}
+
+	if *orphans {
+		removed, err := config.CleanOrphans(ctx)
+		if err != nil {
+			return err
+		}
+		audit(ctx, models.AuditActionOrphanRepair, "database", "orphans", primitive.NilObjectID, map[string]interface{}{
+			"removed": removed,
+		})
+		log.Printf("🧹 Removed orphaned data: %v", removed)
+	}
+
+	log.Println("✅ Cleanup complete")
+	return nil
+}
+
+func tenants(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tenants", flag.ExitOnError)
+	top := fs.Int("top", 20, "number of projects to list")
+	fs.Parse(args)
+
+	stats, err := config.GetTenantStorageStats(ctx)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].MessageBytes > stats[j].MessageBytes })
+	if *top > 0 && len(stats) > *top {
+		stats = stats[:*top]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tNAME\tDATABASE\tMESSAGES\tMESSAGE MB")
+	for _, s := range stats {
+		database := s.Database
+		if database == "" {
+			database = config.DB.Name() + " (main)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.1f\n", s.ProjectID, s.ProjectName, database, s.MessageCount, float64(s.MessageBytes)/1024/1024)
+	}
+	return w.Flush()
+}
+
+func moveTenant(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("move-tenant", flag.ExitOnError)
+	id := projectFlag(fs)
+	db := fs.String("db", "", `target database, or "main" for the main database`)
+	settle := fs.Duration("settle", config.TenantRoutesRefresh()+10*time.Second,
+		"wait after switching for every server to pick up the new route")
+	fs.Parse(args)
+
+	projectID, err := parseProject(*id)
+	if err != nil {
+		return err
+	}
+	if *db == "" {
+		return fmt.Errorf("-db is required")
+	}
+	target := *db
+	if target == "main" {
+		target = ""
+	}
+
+	copied, err := config.MoveTenant(ctx, projectID, target, *settle)
+	if err != nil {
+		return err
+	}
+	audit(ctx, models.AuditActionTenantMove, "project", projectID.Hex(), projectID, map[string]interface{}{
+		"database": *db,
+		"copied":   copied,
+	})
+	log.Printf("✅ Moved project %s to %s: %v", projectID.Hex(), *db, copied)
+	return nil
+}
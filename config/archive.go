@@ -0,0 +1,347 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// archiveBatchSize bounds how many messages go into a single archive object
+const archiveBatchSize = 5000
+
+func GetChatArchivesCollection() *mongo.Collection {
+	return GetCollection("chat_archives")
+}
+
+// ArchiveColdChatHistory exports chat messages older than each project's
+// retention window to gzip-compressed NDJSON in object storage and removes
+// them from chat_messages. Projects under legal hold are skipped entirely.
+func ArchiveColdChatHistory(ctx context.Context) (int, error) {
+	if Storage == nil {
+		return 0, fmt.Errorf("object storage not initialized")
+	}
+
+	defaultDays := parseInt("CHAT_RETENTION_DAYS", 180)
+
+	// Load per-project retention settings
+	cursor, err := GetProjectsCollection().Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+		"chat_retention_days": 1,
+		"legal_hold":          1,
+	}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to load projects: %v", err)
+	}
+	var projects []struct {
+		ID            primitive.ObjectID `bson:"_id"`
+		RetentionDays int                `bson:"chat_retention_days"`
+		LegalHold     bool               `bson:"legal_hold"`
+	}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return 0, fmt.Errorf("failed to decode projects: %v", err)
+	}
+
+	retention := make(map[primitive.ObjectID]int, len(projects))
+	held := make(map[primitive.ObjectID]bool)
+	for _, p := range projects {
+		if p.LegalHold {
+			held[p.ID] = true
+			continue
+		}
+		retention[p.ID] = p.RetentionDays
+	}
+
+	// Messages of deleted projects still follow the default retention
+	projectIDs, err := distinctAcrossTenants(ctx, "chat_messages", "project_id", bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list projects with messages: %v", err)
+	}
+
+	total := 0
+	for _, raw := range projectIDs {
+		projectID, ok := raw.(primitive.ObjectID)
+		if !ok || held[projectID] {
+			continue
+		}
+
+		days := retention[projectID]
+		if days <= 0 {
+			days = defaultDays
+		}
+		cutoff := time.Now().AddDate(0, 0, -days)
+
+		for {
+			n, err := archiveChatBatch(ctx, projectID, cutoff)
+			if err != nil {
+				return total, fmt.Errorf("project %s: %v", projectID.Hex(), err)
+			}
+			total += n
+			if n < archiveBatchSize {
+				break
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// archiveChatBatch archives up to archiveBatchSize of the oldest messages
+// before cutoff. Messages are only deleted after the archive object and its
+// manifest have been written. Restored messages keep their original
+// timestamps but are exempt, so a restore is not undone by the next cycle.
+func archiveChatBatch(ctx context.Context, projectID primitive.ObjectID, cutoff time.Time) (int, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetLimit(archiveBatchSize)
+
+	cursor, err := GetChatMessagesCollectionFor(projectID).Find(ctx, bson.M{
+		"project_id":  projectID,
+		"timestamp":   bson.M{"$lt": cutoff},
+		"restored_at": bson.M{"$exists": false},
+	}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	var ids []interface{}
+	var from, to time.Time
+
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode message: %v", err)
+		}
+		line = append(line, '\n')
+		if _, err := gz.Write(line); err != nil {
+			return 0, fmt.Errorf("failed to compress archive: %v", err)
+		}
+
+		ids = append(ids, cursor.Current.Lookup("_id").ObjectID())
+		if ts, ok := cursor.Current.Lookup("timestamp").TimeOK(); ok {
+			if from.IsZero() {
+				from = ts
+			}
+			to = ts
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	archiveID := primitive.NewObjectID()
+	key := fmt.Sprintf("archives/chat_messages/%s/%s.ndjson.gz", projectID.Hex(), archiveID.Hex())
+	size := int64(buf.Len())
+	if err := Storage.Put(ctx, key, &buf, size, "application/gzip"); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %v", err)
+	}
+
+	_, err = GetChatArchivesCollection().InsertOne(ctx, bson.M{
+		"_id":           archiveID,
+		"project_id":    projectID,
+		"storage_key":   key,
+		"message_count": len(ids),
+		"size_bytes":    size,
+		"from":          from,
+		"to":            to,
+		"created_at":    time.Now(),
+	})
+	if err != nil {
+		Storage.Delete(ctx, key)
+		return 0, fmt.Errorf("failed to record archive: %v", err)
+	}
+
+	if _, err := GetChatMessagesCollectionFor(projectID).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return 0, fmt.Errorf("failed to remove archived messages: %v", err)
+	}
+
+	log.Printf("📦 Archived %d chat messages for project %s (%s)", len(ids), projectID.Hex(), key)
+	return len(ids), nil
+}
+
+// RestoreChatArchive re-imports an archived batch into chat_messages.
+// Messages that already exist are left untouched, so restore is repeatable.
+// Restored messages are marked with restored_at, which keeps the archiver
+// from moving them straight back to storage.
+func RestoreChatArchive(ctx context.Context, archiveID primitive.ObjectID) (int, error) {
+	var archive struct {
+		ProjectID  primitive.ObjectID `bson:"project_id"`
+		StorageKey string             `bson:"storage_key"`
+	}
+	if err := GetChatArchivesCollection().FindOne(ctx, bson.M{"_id": archiveID}).Decode(&archive); err != nil {
+		return 0, fmt.Errorf("archive not found: %v", err)
+	}
+
+	rc, err := Storage.Get(ctx, archive.StorageKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer rc.Close()
+
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive: %v", err)
+	}
+	defer gz.Close()
+
+	now := time.Now()
+	var docs []interface{}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+			return 0, fmt.Errorf("corrupt archive line: %v", err)
+		}
+		docs = append(docs, append(doc, bson.E{Key: "restored_at", Value: now}))
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read archive: %v", err)
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	restored := len(docs)
+	_, err = GetChatMessagesCollectionFor(archive.ProjectID).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		bwe, ok := err.(mongo.BulkWriteException)
+		if !ok || !mongo.IsDuplicateKeyError(err) {
+			return 0, fmt.Errorf("failed to restore messages: %v", err)
+		}
+		restored -= len(bwe.WriteErrors)
+	}
+
+	GetChatArchivesCollection().UpdateOne(ctx, bson.M{"_id": archiveID}, bson.M{
+		"$set": bson.M{"restored_at": now},
+	})
+
+	return restored, nil
+}
+
+// PurgeArchivedMessages removes a chat user's messages from the project's
+// archives, for an erasure request. Each archive holding any is rewritten
+// without them, and one left empty is deleted with its record. It returns
+// how many messages were removed.
+func PurgeArchivedMessages(ctx context.Context, projectID, userID primitive.ObjectID) (int, error) {
+	cursor, err := GetChatArchivesCollection().Find(ctx, bson.M{"project_id": projectID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list archives: %v", err)
+	}
+	var archives []struct {
+		ID         primitive.ObjectID `bson:"_id"`
+		StorageKey string             `bson:"storage_key"`
+	}
+	if err := cursor.All(ctx, &archives); err != nil {
+		return 0, fmt.Errorf("failed to decode archives: %v", err)
+	}
+	if len(archives) > 0 && Storage == nil {
+		return 0, fmt.Errorf("object storage not initialized")
+	}
+
+	total := 0
+	for _, archive := range archives {
+		n, err := purgeArchive(ctx, archive.ID, archive.StorageKey, userID)
+		if err != nil {
+			return total, fmt.Errorf("archive %s: %v", archive.ID.Hex(), err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// purgeArchive drops userID's messages from one archive object
+func purgeArchive(ctx context.Context, archiveID primitive.ObjectID, key string, userID primitive.ObjectID) (int, error) {
+	rc, err := Storage.Get(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer rc.Close()
+	gzr, err := gzip.NewReader(rc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive: %v", err)
+	}
+	defer gzr.Close()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	removed, kept := 0, 0
+	var from, to time.Time
+
+	scanner := bufio.NewScanner(gzr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			UserID    primitive.ObjectID `bson:"user_id"`
+			Timestamp time.Time          `bson:"timestamp"`
+		}
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &msg); err != nil {
+			return 0, fmt.Errorf("corrupt archive line: %v", err)
+		}
+		if msg.UserID == userID {
+			removed++
+			continue
+		}
+		if _, err := gz.Write(scanner.Bytes()); err != nil {
+			return 0, fmt.Errorf("failed to compress archive: %v", err)
+		}
+		if _, err := gz.Write([]byte{'\n'}); err != nil {
+			return 0, fmt.Errorf("failed to compress archive: %v", err)
+		}
+		kept++
+		if from.IsZero() {
+			from = msg.Timestamp
+		}
+		to = msg.Timestamp
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read archive: %v", err)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if kept == 0 {
+		if _, err := GetChatArchivesCollection().DeleteOne(ctx, bson.M{"_id": archiveID}); err != nil {
+			return 0, fmt.Errorf("failed to remove archive record: %v", err)
+		}
+		if err := Storage.Delete(ctx, key); err != nil {
+			log.Printf("⚠️ Failed to delete emptied archive %s: %v", key, err)
+		}
+		return removed, nil
+	}
+
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	size := int64(buf.Len())
+	if err := Storage.Put(ctx, key, &buf, size, "application/gzip"); err != nil {
+		return 0, fmt.Errorf("failed to rewrite archive: %v", err)
+	}
+	_, err = GetChatArchivesCollection().UpdateOne(ctx, bson.M{"_id": archiveID}, bson.M{"$set": bson.M{
+		"message_count": kept,
+		"size_bytes":    size,
+		"from":          from,
+		"to":            to,
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update archive record: %v", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChaosConfig drives fault injection in staging, to check that retries,
+// key health tracking and fail-open paths behave as intended. Percentages
+// are 0-100 and apply per request (latency) or per operation (Gemini, Mongo).
+//
+//	CHAOS_ENABLED=true             turn the layer on (ignored when APP_ENV=production)
+//	CHAOS_LATENCY=800ms            delay added to affected requests
+//	CHAOS_LATENCY_PERCENT=20       share of requests delayed
+//	CHAOS_GEMINI_FAILURE_PERCENT=5 share of Gemini calls that fail
+//	CHAOS_MONGO_ERROR_PERCENT=2    share of collection operations that fail
+//	CHAOS_PATHS=/embed,/chat       only delay requests under these prefixes
+type ChaosConfig struct {
+	Latency              time.Duration
+	LatencyPercent       float64
+	GeminiFailurePercent float64
+	MongoErrorPercent    float64
+	Paths                []string
+}
+
+// Chaos is nil unless fault injection is enabled and armed
+var Chaos *ChaosConfig
+
+// ErrChaosGemini is returned in place of a Gemini client when a failure is injected
+var ErrChaosGemini = fmt.Errorf("chaos: injected Gemini failure")
+
+// chaosDB points at an address nothing listens on, so operations on its
+// collections fail the same way they would during a real Mongo outage
+var chaosDB *mongo.Database
+
+// InitChaos reads the CHAOS_* settings. Call it once startup is done so
+// injected Mongo errors cannot break index setup or seeding.
+func InitChaos() {
+	if !parseBool("CHAOS_ENABLED", false) {
+		return
+	}
+	if strings.EqualFold(os.Getenv("APP_ENV"), "production") {
+		log.Println("⚠️ CHAOS_ENABLED is ignored when APP_ENV=production")
+		return
+	}
+
+	cfg := &ChaosConfig{
+		Latency:              parseDuration("CHAOS_LATENCY", "500ms"),
+		LatencyPercent:       parsePercent("CHAOS_LATENCY_PERCENT"),
+		GeminiFailurePercent: parsePercent("CHAOS_GEMINI_FAILURE_PERCENT"),
+		MongoErrorPercent:    parsePercent("CHAOS_MONGO_ERROR_PERCENT"),
+	}
+	for _, p := range strings.Split(os.Getenv("CHAOS_PATHS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.Paths = append(cfg.Paths, p)
+		}
+	}
+
+	if cfg.MongoErrorPercent > 0 {
+		client, err := mongo.Connect(context.Background(), options.Client().
+			ApplyURI("mongodb://127.0.0.1:1").
+			SetServerSelectionTimeout(100*time.Millisecond).
+			SetConnectTimeout(100*time.Millisecond))
+		if err != nil {
+			log.Printf("⚠️ Chaos: Mongo fault injection unavailable: %v", err)
+			cfg.MongoErrorPercent = 0
+		} else {
+			chaosDB = client.Database("chaos")
+		}
+	}
+
+	Chaos = cfg
+	log.Printf("💥 Chaos enabled: latency %v on %.1f%% of requests, Gemini failures %.1f%%, Mongo errors %.1f%%, paths %v",
+		cfg.Latency, cfg.LatencyPercent, cfg.GeminiFailurePercent, cfg.MongoErrorPercent, cfg.Paths)
+}
+
+// ChaosApplies reports whether requests to path may be delayed
+func ChaosApplies(path string) bool {
+	if Chaos == nil {
+		return false
+	}
+	if len(Chaos.Paths) == 0 {
+		return true
+	}
+	for _, p := range Chaos.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// chaosRoll returns true for roughly percent out of every hundred calls
+func chaosRoll(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// ChaosDelay reports whether a request should be delayed
+func ChaosDelay() bool {
+	return Chaos != nil && chaosRoll(Chaos.LatencyPercent)
+}
+
+func chaosGeminiFailure() bool {
+	return Chaos != nil && chaosRoll(Chaos.GeminiFailurePercent)
+}
+
+// chaosCollection returns a collection that cannot reach a server, when a
+// Mongo error is to be injected
+func chaosCollection(name string) *mongo.Collection {
+	if Chaos == nil || chaosDB == nil || !chaosRoll(Chaos.MongoErrorPercent) {
+		return nil
+	}
+	return chaosDB.Collection(name)
+}
+
+func parsePercent(key string) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	if v > 100 {
+		return 100
+	}
+	return v
+}
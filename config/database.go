@@ -9,17 +9,37 @@ import (
     "time"
     
     "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
+    "go.mongodb.org/mongo-driver/mongo/readpref"
+    "golang.org/x/crypto/bcrypt"
+    "jevi-chat/models"
 )
 
 var (
     DB     *mongo.Database
     Client *mongo.Client
+
+    // ReadOnly is set for analytics deployments, which read from a replica
+    // (MONGODB_READ_URI, or MONGODB_URI preferring secondaries) and never
+    // write. Set it before InitMongoDB.
+    ReadOnly bool
 )
 
+// mongoReadURI is the replica URI a read-only deployment connects to, if set
+func mongoReadURI() string {
+    if !ReadOnly {
+        return ""
+    }
+    return os.Getenv("MONGODB_READ_URI")
+}
+
 func InitMongoDB() {
     uri := os.Getenv("MONGODB_URI")
+    if readURI := mongoReadURI(); readURI != "" {
+        uri = readURI
+    }
     if uri == "" {
         log.Fatal("❌ MONGODB_URI not set in environment")
     }
@@ -31,14 +51,7 @@ func InitMongoDB() {
     ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
     defer cancel()
     
-    // Enhanced client options
-    clientOptions := options.Client().ApplyURI(uri)
-    clientOptions.SetMaxPoolSize(10)
-    clientOptions.SetMinPoolSize(1)
-    clientOptions.SetMaxConnIdleTime(30 * time.Second)
-    clientOptions.SetServerSelectionTimeout(10 * time.Second)
-    
-    client, err := mongo.Connect(ctx, clientOptions)
+    client, err := mongo.Connect(ctx, mongoClientOptions(uri))
     if err != nil {
         log.Fatalf("❌ Failed to connect to MongoDB: %v", err)
     }
@@ -60,10 +73,42 @@ func InitMongoDB() {
     
     log.Printf("✅ Connected to MongoDB successfully (Database: %s)", dbName)
     
+    if err := LoadTenantRoutes(ctx); err != nil {
+        log.Printf("⚠️ Failed to load tenant routes: %v", err)
+    }
+    
+    // Index builds and backfills are left to the deployments that write
+    if ReadOnly {
+        log.Println("📖 Read-only mode: skipping index setup and migrations")
+        return
+    }
+    
     // Verify collections and setup indexes
     if err := verifyCollections(ctx); err != nil {
         log.Printf("⚠️ Warning during collection verification: %v", err)
     }
+    
+    // Convert legacy string project IDs without delaying startup
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+        defer cancel()
+        if _, _, err := BackfillProjectIDs(ctx); err != nil {
+            log.Printf("⚠️ project_id backfill failed: %v", err)
+        }
+    }()
+}
+
+// mongoClientOptions are the connection settings for a MongoDB URI
+func mongoClientOptions(uri string) *options.ClientOptions {
+    clientOptions := options.Client().ApplyURI(uri)
+    clientOptions.SetMaxPoolSize(10)
+    clientOptions.SetMinPoolSize(1)
+    clientOptions.SetMaxConnIdleTime(30 * time.Second)
+    clientOptions.SetServerSelectionTimeout(10 * time.Second)
+    if ReadOnly {
+        clientOptions.SetReadPreference(readpref.SecondaryPreferred())
+    }
+    return clientOptions
 }
 
 func testConnection(ctx context.Context, client *mongo.Client) error {
@@ -103,10 +148,35 @@ func verifyCollections(ctx context.Context) error {
     requiredCollections := []string{
         "projects", 
         "chat_messages", 
+        "chat_sessions",
         "chat_users", 
         "gemini_usage_logs",
+        "gemini_usage_daily",
+        "chat_archives",
+        "audit_logs",
         "notifications", // ✅ Added notifications collection
         "users",         // ✅ Added users collection
+        "user_sessions",
+        "request_nonces",
+        "project_blocks",
+        "leads",
+        "products",
+        "library_documents",
+        "document_pages",
+        "document_chunks",
+        "chunk_embeddings",
+        "instruction_revisions",
+        "chat_user_tokens",
+        "user_deletions",
+        "upload_batches",
+        "message_redactions",
+        "chat_events",
+        "analytics_share_links",
+        "welcome_events",
+        "webhooks",
+        "webhook_deliveries",
+        "transcript_imports",
+        "api_key_usage",
     }
     
     // List existing collections
@@ -135,164 +205,6 @@ func verifyCollections(ctx context.Context) error {
     return setupIndexes(ctx)
 }
 
-// ✅ COMPLETE: setupIndexes function with all collections
-func setupIndexes(ctx context.Context) error {
-    // Projects collection indexes
-    projectsCol := DB.Collection("projects")
-    _, err := projectsCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
-        {
-            Keys: bson.D{{"name", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"is_active", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"gemini_enabled", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"created_at", -1}},
-            Options: options.Index().SetBackground(true),
-        },
-    })
-    if err != nil {
-        log.Printf("⚠️ Failed to create projects indexes: %v", err)
-    }
-    
-    // Chat messages collection indexes
-    chatCol := DB.Collection("chat_messages")
-    _, err = chatCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
-        {
-            Keys: bson.D{{"project_id", 1}, {"session_id", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"timestamp", -1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"project_id", 1}, {"timestamp", -1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"user_id", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-    })
-    if err != nil {
-        log.Printf("⚠️ Failed to create chat_messages indexes: %v", err)
-    }
-    
-    // Chat users collection indexes
-    chatUsersCol := DB.Collection("chat_users")
-    _, err = chatUsersCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
-        {
-            Keys: bson.D{{"project_id", 1}, {"email", 1}},
-            Options: options.Index().SetBackground(true).SetUnique(true),
-        },
-        {
-            Keys: bson.D{{"email", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"is_active", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-    })
-    if err != nil {
-        log.Printf("⚠️ Failed to create chat_users indexes: %v", err)
-    }
-    
-    // Users collection indexes
-    usersCol := DB.Collection("users")
-    _, err = usersCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
-        {
-            Keys: bson.D{{"email", 1}},
-            Options: options.Index().SetBackground(true).SetUnique(true),
-        },
-        {
-            Keys: bson.D{{"username", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"is_active", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"role", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-    })
-    if err != nil {
-        log.Printf("⚠️ Failed to create users indexes: %v", err)
-    }
-    
-    // Gemini usage logs collection indexes
-    geminiCol := DB.Collection("gemini_usage_logs")
-    _, err = geminiCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
-        {
-            Keys: bson.D{{"project_id", 1}, {"timestamp", -1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"timestamp", -1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"user_ip", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"success", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-    })
-    if err != nil {
-        log.Printf("⚠️ Failed to create gemini_usage_logs indexes: %v", err)
-    }
-    
-    // ✅ NOTIFICATIONS: Notification collection indexes
-    notificationsCol := DB.Collection("notifications")
-    _, err = notificationsCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
-        {
-            Keys: bson.D{{"project_id", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"user_id", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"created_at", -1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"expires_at", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"is_read", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"type", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-        {
-            Keys: bson.D{{"project_id", 1}, {"type", 1}},
-            Options: options.Index().SetBackground(true),
-        },
-    })
-    if err != nil {
-        log.Printf("⚠️ Failed to create notifications indexes: %v", err)
-    }
-    
-    log.Println("📈 Database indexes setup completed successfully")
-    return nil
-}
-
 func GetCollection(collectionName string) *mongo.Collection {
     if DB == nil {
         log.Fatal("❌ Database not initialized. Call InitMongoDB() first.")
@@ -301,6 +213,10 @@ func GetCollection(collectionName string) *mongo.Collection {
     if collectionName == "" {
         log.Fatal("❌ Collection name cannot be empty")
     }
+
+    if broken := chaosCollection(collectionName); broken != nil {
+        return broken
+    }
     
     return DB.Collection(collectionName)
 }
@@ -310,10 +226,17 @@ func GetProjectsCollection() *mongo.Collection {
     return GetCollection("projects")
 }
 
+// GetChatMessagesCollection and GetChatSessionsCollection are the main
+// database's collections; a project's own are GetChatMessagesCollectionFor
+// and GetChatSessionsCollectionFor
 func GetChatMessagesCollection() *mongo.Collection {
     return GetCollection("chat_messages")
 }
 
+func GetChatSessionsCollection() *mongo.Collection {
+    return GetCollection("chat_sessions")
+}
+
 func GetChatUsersCollection() *mongo.Collection {
     return GetCollection("chat_users")
 }
@@ -322,10 +245,102 @@ func GetUsersCollection() *mongo.Collection {
     return GetCollection("users")
 }
 
+func GetUserSessionsCollection() *mongo.Collection {
+    return GetCollection("user_sessions")
+}
+
+func GetUserDeletionsCollection() *mongo.Collection {
+    return GetCollection("user_deletions")
+}
+
 func GetGeminiUsageLogsCollection() *mongo.Collection {
     return GetCollection("gemini_usage_logs")
 }
 
+func GetGeminiUsageDailyCollection() *mongo.Collection {
+    return GetCollection("gemini_usage_daily")
+}
+
+func GetOverageChargesCollection() *mongo.Collection {
+    return GetCollection("overage_charges")
+}
+
+func GetProjectBlocksCollection() *mongo.Collection {
+    return GetCollection("project_blocks")
+}
+
+func GetProductsCollection() *mongo.Collection {
+    return GetCollection("products")
+}
+
+func GetLeadsCollection() *mongo.Collection {
+    return GetCollection("leads")
+}
+
+func GetLibraryDocumentsCollection() *mongo.Collection {
+    return GetCollection("library_documents")
+}
+
+func GetDocumentPagesCollection() *mongo.Collection {
+    return GetCollection("document_pages")
+}
+
+func GetDocumentChunksCollection() *mongo.Collection {
+    return GetCollection("document_chunks")
+}
+
+func GetUploadBatchesCollection() *mongo.Collection {
+    return GetCollection("upload_batches")
+}
+
+func GetMessageRedactionsCollection() *mongo.Collection {
+    return GetCollection("message_redactions")
+}
+
+func GetChunkEmbeddingsCollection() *mongo.Collection {
+    return GetCollection("chunk_embeddings")
+}
+
+func GetInstructionRevisionsCollection() *mongo.Collection {
+    return GetCollection("instruction_revisions")
+}
+
+func GetChatUserTokensCollection() *mongo.Collection {
+    return GetCollection("chat_user_tokens")
+}
+
+func GetAuditLogsCollection() *mongo.Collection {
+    return GetCollection("audit_logs")
+}
+
+func GetChatEventsCollection() *mongo.Collection {
+    return GetCollection("chat_events")
+}
+
+func GetAnalyticsShareLinksCollection() *mongo.Collection {
+    return GetCollection("analytics_share_links")
+}
+
+func GetWelcomeEventsCollection() *mongo.Collection {
+    return GetCollection("welcome_events")
+}
+
+func GetWebhooksCollection() *mongo.Collection {
+    return GetCollection("webhooks")
+}
+
+func GetWebhookDeliveriesCollection() *mongo.Collection {
+    return GetCollection("webhook_deliveries")
+}
+
+func GetTranscriptImportsCollection() *mongo.Collection {
+    return GetCollection("transcript_imports")
+}
+
+func GetAPIKeyUsageCollection() *mongo.Collection {
+    return GetCollection("api_key_usage")
+}
+
 // ✅ NEW: Notification collection convenience function
 func GetNotificationsCollection() *mongo.Collection {
     return GetCollection("notifications")
@@ -373,11 +388,13 @@ func GetDatabaseStats() map[string]interface{} {
         "chat_users", 
         "users",
         "gemini_usage_logs", 
+        "gemini_usage_daily",
+        "audit_logs",
         "notifications", // ✅ Added notifications
     }
     
     for _, colName := range collections {
-        count, err := GetCollection(colName).CountDocuments(ctx, bson.M{})
+        count, err := CountTenantDocuments(ctx, colName, bson.M{})
         if err != nil {
             stats[colName] = "error"
         } else {
@@ -413,7 +430,7 @@ func GetDetailedDatabaseStats() map[string]interface{} {
     
     // Recent messages (last 24 hours)
     yesterday := time.Now().Add(-24 * time.Hour)
-    recentMessages, _ := GetChatMessagesCollection().CountDocuments(ctx, bson.M{
+    recentMessages, _ := CountTenantDocuments(ctx, "chat_messages", bson.M{
         "timestamp": bson.M{"$gte": yesterday},
     })
     stats["recent_messages_24h"] = recentMessages
@@ -433,6 +450,26 @@ func GetDetailedDatabaseStats() map[string]interface{} {
     })
     stats["gemini_usage_today"] = geminiUsageToday
     
+    // Per-tenant storage estimates for quota reporting
+    tenants, err := GetTenantStorageStats(ctx)
+    if err != nil {
+        stats["tenants_error"] = err.Error()
+    } else {
+        overQuota := 0
+        for _, t := range tenants {
+            if t.QuotaPercentage >= 100 {
+                overQuota++
+            }
+        }
+        stats["tenants"] = tenants
+        stats["tenants_over_quota"] = overQuota
+    }
+    
+    // Rows still waiting for the project_id backfill
+    if legacy, err := CountLegacyProjectIDs(ctx); err == nil {
+        stats["legacy_project_ids"] = legacy
+    }
+    
     return stats
 }
 
@@ -442,7 +479,7 @@ func CleanupExpiredData() error {
         return fmt.Errorf("database not initialized")
     }
     
-    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute) // archival can take a while on large tenants
     defer cancel()
     
     // Cleanup expired notifications
@@ -455,21 +492,35 @@ func CleanupExpiredData() error {
         log.Printf("🧹 Cleaned up %d expired notifications", result.DeletedCount)
     }
     
-    // Cleanup old chat messages (older than 6 months)
-    sixMonthsAgo := time.Now().AddDate(0, -6, 0)
-    result, err = GetChatMessagesCollection().DeleteMany(ctx, bson.M{
-        "timestamp": bson.M{"$lt": sixMonthsAgo},
-    })
+    // Archive cold chat history to object storage (per-project retention)
+    archived, err := ArchiveColdChatHistory(ctx)
     if err != nil {
-        log.Printf("⚠️ Failed to cleanup old chat messages: %v", err)
+        log.Printf("⚠️ Failed to archive old chat messages: %v", err)
     } else {
-        log.Printf("🧹 Cleaned up %d old chat messages", result.DeletedCount)
+        log.Printf("📦 Archived %d old chat messages", archived)
+    }
+    
+    // Cleanup old usage logs (older than 3 months). The cutoff is aligned to
+    // a UTC day boundary so every purged day is rolled up exactly once.
+    threeMonthsAgo := time.Now().UTC().AddDate(0, -3, 0).Truncate(24 * time.Hour)
+    rolledUp, err := RollupGeminiUsageLogs(ctx, threeMonthsAgo)
+    if err != nil {
+        // Never purge logs that could not be aggregated
+        log.Printf("⚠️ Skipping usage log cleanup, rollup failed: %v", err)
+        return nil
+    }
+    log.Printf("📊 Rolled up %d daily usage aggregates", rolledUp)
+    
+    // Logs of projects under legal hold are kept regardless of age
+    heldProjects, err := LegalHoldProjectIDs(ctx)
+    if err != nil {
+        log.Printf("⚠️ Skipping usage log cleanup, legal hold lookup failed: %v", err)
+        return nil
     }
     
-    // Cleanup old usage logs (older than 3 months)
-    threeMonthsAgo := time.Now().AddDate(0, -3, 0)
     result, err = GetGeminiUsageLogsCollection().DeleteMany(ctx, bson.M{
-        "timestamp": bson.M{"$lt": threeMonthsAgo},
+        "timestamp":  bson.M{"$lt": threeMonthsAgo},
+        "project_id": bson.M{"$nin": heldProjects},
     })
     if err != nil {
         log.Printf("⚠️ Failed to cleanup old usage logs: %v", err)
@@ -480,6 +531,101 @@ func CleanupExpiredData() error {
     return nil
 }
 
+// LegalHoldProjectIDs returns the IDs of all projects under legal hold.
+// Retention and deletion jobs must exclude data belonging to these projects.
+func LegalHoldProjectIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+    ids := []primitive.ObjectID{}
+    values, err := GetProjectsCollection().Distinct(ctx, "_id", bson.M{"legal_hold": true})
+    if err != nil {
+        return nil, err
+    }
+    for _, v := range values {
+        if id, ok := v.(primitive.ObjectID); ok {
+            ids = append(ids, id)
+        }
+    }
+    return ids, nil
+}
+
+// IsProjectOnLegalHold reports whether deletions for the project are blocked
+func IsProjectOnLegalHold(ctx context.Context, projectID primitive.ObjectID) bool {
+    count, err := GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": projectID, "legal_hold": true})
+    // Fail closed: if we cannot tell, treat the project as held
+    return err != nil || count > 0
+}
+
+// RollupGeminiUsageLogs aggregates every usage log older than cutoff into
+// per-project daily documents in gemini_usage_daily. Rollups are upserted with
+// the full totals for the day, so re-running before the purge is idempotent.
+func RollupGeminiUsageLogs(ctx context.Context, cutoff time.Time) (int, error) {
+    pipeline := []bson.M{
+        {"$match": bson.M{"timestamp": bson.M{"$lt": cutoff}}},
+        {"$group": bson.M{
+            "_id": bson.M{
+                "project_id": "$project_id",
+                "date": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$timestamp"}},
+            },
+            "requests":      bson.M{"$sum": 1},
+            "success_count": bson.M{"$sum": bson.M{"$cond": bson.A{"$success", 1, 0}}},
+            "overage":       bson.M{"$sum": bson.M{"$cond": bson.A{"$overage", 1, 0}}},
+            "tokens_used":   bson.M{"$sum": "$tokens_used"},
+            "input_tokens":  bson.M{"$sum": "$input_tokens"},
+            "output_tokens": bson.M{"$sum": "$output_tokens"},
+            "cost":          bson.M{"$sum": "$estimated_cost"},
+            "avg_response":  bson.M{"$avg": "$response_time_ms"},
+        }},
+    }
+    
+    cursor, err := GetGeminiUsageLogsCollection().Aggregate(ctx, pipeline)
+    if err != nil {
+        return 0, fmt.Errorf("usage log aggregation failed: %v", err)
+    }
+    defer cursor.Close(ctx)
+    
+    var rows []struct {
+        ID struct {
+            ProjectID interface{} `bson:"project_id"`
+            Date      string      `bson:"date"`
+        } `bson:"_id"`
+        Requests     int64   `bson:"requests"`
+        SuccessCount int64   `bson:"success_count"`
+        Overage      int64   `bson:"overage"`
+        TokensUsed   int64   `bson:"tokens_used"`
+        InputTokens  int64   `bson:"input_tokens"`
+        OutputTokens int64   `bson:"output_tokens"`
+        Cost         float64 `bson:"cost"`
+        AvgResponse  float64 `bson:"avg_response"`
+    }
+    if err := cursor.All(ctx, &rows); err != nil {
+        return 0, fmt.Errorf("failed to decode usage aggregates: %v", err)
+    }
+    
+    dailyCol := GetGeminiUsageDailyCollection()
+    for _, row := range rows {
+        _, err := dailyCol.UpdateOne(ctx,
+            bson.M{"project_id": row.ID.ProjectID, "date": row.ID.Date},
+            bson.M{"$set": bson.M{
+                "requests":             row.Requests,
+                "success_count":        row.SuccessCount,
+                "failed_count":         row.Requests - row.SuccessCount,
+                "overage_requests":     row.Overage,
+                "tokens_used":          row.TokensUsed,
+                "input_tokens":         row.InputTokens,
+                "output_tokens":        row.OutputTokens,
+                "estimated_cost":       row.Cost,
+                "avg_response_time_ms": row.AvgResponse,
+                "updated_at":           time.Now(),
+            }},
+            options.Update().SetUpsert(true),
+        )
+        if err != nil {
+            return 0, fmt.Errorf("failed to upsert daily usage for %s: %v", row.ID.Date, err)
+        }
+    }
+    
+    return len(rows), nil
+}
+
 // ✅ NEW: Database maintenance function
 func PerformMaintenance() error {
     log.Println("🔧 Starting database maintenance...")
@@ -490,6 +636,16 @@ func PerformMaintenance() error {
         return err
     }
     
+    orphanCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+    if _, _, err := BackfillProjectIDs(orphanCtx); err != nil {
+        log.Printf("⚠️ project_id backfill failed: %v", err)
+    }
+    if _, err := MoveLibraryToStorage(orphanCtx); err != nil {
+        log.Printf("⚠️ Library move to object storage failed: %v", err)
+    }
+    checkOrphans(orphanCtx)
+    cancel()
+    
     // Get stats before and after
     stats := GetDetailedDatabaseStats()
     log.Printf("📊 Maintenance completed. Database stats: %+v", stats)
@@ -523,22 +679,54 @@ func CloseMongoDB() {
     }
 }
 
-// ✅ NEW: Initialize database with default data if needed
+// InitializeDefaultData seeds a super-admin from ADMIN_EMAIL and
+// ADMIN_PASSWORD when the database has no admin yet, so a fresh deployment
+// is usable immediately. The account must change its password on first login.
 func InitializeDefaultData() error {
     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
     
-    // Check if admin user exists, create if not
     usersCol := GetUsersCollection()
     adminEmail := os.Getenv("ADMIN_EMAIL")
-    if adminEmail != "" {
-        count, _ := usersCol.CountDocuments(ctx, bson.M{"email": adminEmail})
-        if count == 0 {
-            log.Printf("🔧 Creating default admin user: %s", adminEmail)
-            // Note: Actual user creation should be handled by your auth system
-        }
+    adminPassword := os.Getenv("ADMIN_PASSWORD")
+    if adminEmail == "" || adminPassword == "" {
+        log.Println("ℹ️ ADMIN_EMAIL/ADMIN_PASSWORD not set, skipping admin seed")
+        return nil
+    }
+    
+    admins, err := usersCol.CountDocuments(ctx, bson.M{"role": bson.M{"$in": bson.A{models.RoleAdmin, models.RoleSuperAdmin}}})
+    if err != nil {
+        return fmt.Errorf("failed to count admins: %v", err)
+    }
+    if admins > 0 {
+        return nil
+    }
+    
+    hashed, err := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
+    if err != nil {
+        return fmt.Errorf("failed to hash admin password: %v", err)
+    }
+    
+    // The env-configured admin is the platform operator
+    now := time.Now()
+    _, err = usersCol.InsertOne(ctx, models.User{
+        Username:           "admin",
+        Email:              adminEmail,
+        Password:           string(hashed),
+        IsActive:           true,
+        Role:               models.RoleSuperAdmin,
+        MustChangePassword: true,
+        CreatedAt:          now,
+        UpdatedAt:          now,
+    })
+    if mongo.IsDuplicateKeyError(err) {
+        log.Printf("⚠️ %s already belongs to a non-admin user, not seeding an admin", adminEmail)
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to create admin: %v", err)
     }
     
-    log.Println("✅ Default data initialization completed")
+    log.Printf("🔧 Created default admin user: %s (password change required)", adminEmail)
     return nil
 }
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// EventBusConfig controls publishing the chat event log to a customer's
+// data platform
+type EventBusConfig struct {
+	// Driver is "nats", "kafka" or empty to not publish
+	Driver    string
+	Interval  time.Duration
+	BatchSize int
+
+	// NATS JetStream: events go to <NATSSubject>.<event type>
+	NATSURL     string
+	NATSSubject string
+	NATSStream  string // created over NATSSubject.> if it does not exist
+	NATSCreds   string
+
+	// Kafka: every event goes to KafkaTopic, keyed by project
+	KafkaBrokers  []string
+	KafkaTopic    string
+	KafkaUsername string
+	KafkaPassword string
+	KafkaTLS      bool
+}
+
+var EventBusSettings *EventBusConfig
+
+// BusMessage is one event ready to publish. ID is stable across retries so
+// consumers, and JetStream's duplicate window, can drop redeliveries.
+type BusMessage struct {
+	ID      string
+	Type    string
+	Key     string
+	Payload []byte
+	Headers map[string]string
+}
+
+// InitEventBusConfig reads the EVENT_BUS_* settings:
+//
+//	EVENT_BUS_DRIVER          nats | kafka (unset: no publishing)
+//	EVENT_BUS_INTERVAL        default 10s
+//	EVENT_BUS_BATCH_SIZE      default 500
+//	EVENT_BUS_NATS_URL        nats://host:4222
+//	EVENT_BUS_NATS_SUBJECT    default jevi.chat
+//	EVENT_BUS_NATS_STREAM     stream to create if missing (optional)
+//	EVENT_BUS_NATS_CREDS      path to a .creds file (optional)
+//	EVENT_BUS_KAFKA_BROKERS   "host1:9092,host2:9092"
+//	EVENT_BUS_KAFKA_TOPIC     default jevi.chat.events
+//	EVENT_BUS_KAFKA_USERNAME  SASL/PLAIN user (optional)
+//	EVENT_BUS_KAFKA_PASSWORD  SASL/PLAIN password
+//	EVENT_BUS_KAFKA_TLS       true to connect over TLS
+func InitEventBusConfig() error {
+	settings, err := LoadEventBusConfig()
+	if err != nil {
+		return err
+	}
+	EventBusSettings = settings
+	return nil
+}
+
+// LoadEventBusConfig parses and validates the EVENT_BUS_* settings without
+// applying them
+func LoadEventBusConfig() (*EventBusConfig, error) {
+	settings := &EventBusConfig{
+		Driver:        strings.ToLower(os.Getenv("EVENT_BUS_DRIVER")),
+		Interval:      parseDuration("EVENT_BUS_INTERVAL", "10s"),
+		BatchSize:     parseInt("EVENT_BUS_BATCH_SIZE", 500),
+		NATSURL:       os.Getenv("EVENT_BUS_NATS_URL"),
+		NATSSubject:   strings.TrimSuffix(os.Getenv("EVENT_BUS_NATS_SUBJECT"), "."),
+		NATSStream:    os.Getenv("EVENT_BUS_NATS_STREAM"),
+		NATSCreds:     os.Getenv("EVENT_BUS_NATS_CREDS"),
+		KafkaBrokers:  splitList(os.Getenv("EVENT_BUS_KAFKA_BROKERS")),
+		KafkaTopic:    os.Getenv("EVENT_BUS_KAFKA_TOPIC"),
+		KafkaUsername: os.Getenv("EVENT_BUS_KAFKA_USERNAME"),
+		KafkaPassword: os.Getenv("EVENT_BUS_KAFKA_PASSWORD"),
+		KafkaTLS:      parseBool("EVENT_BUS_KAFKA_TLS", false),
+	}
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = 500
+	}
+	if settings.NATSSubject == "" {
+		settings.NATSSubject = "jevi.chat"
+	}
+	if settings.KafkaTopic == "" {
+		settings.KafkaTopic = "jevi.chat.events"
+	}
+
+	switch settings.Driver {
+	case "":
+	case "nats":
+		if settings.NATSURL == "" {
+			return nil, fmt.Errorf("EVENT_BUS_NATS_URL is required when EVENT_BUS_DRIVER=nats")
+		}
+	case "kafka":
+		if len(settings.KafkaBrokers) == 0 {
+			return nil, fmt.Errorf("EVENT_BUS_KAFKA_BROKERS is required when EVENT_BUS_DRIVER=kafka")
+		}
+	default:
+		return nil, fmt.Errorf("EVENT_BUS_DRIVER must be nats or kafka, got %q", settings.Driver)
+	}
+	return settings, nil
+}
+
+var (
+	busMu     sync.Mutex
+	natsConn  *nats.Conn
+	kafkaConn *kafka.Writer
+)
+
+// PublishBusMessages publishes a batch with the configured driver and
+// returns once the broker has acknowledged every message, or with an error
+// so the caller can retry the batch from the same place
+func PublishBusMessages(ctx context.Context, messages []BusMessage) error {
+	if EventBusSettings == nil || EventBusSettings.Driver == "" || len(messages) == 0 {
+		return nil
+	}
+	busMu.Lock()
+	defer busMu.Unlock()
+
+	switch EventBusSettings.Driver {
+	case "nats":
+		return publishNATS(ctx, messages)
+	default:
+		return publishKafka(ctx, messages)
+	}
+}
+
+func publishNATS(ctx context.Context, messages []BusMessage) error {
+	if natsConn == nil || natsConn.IsClosed() {
+		opts := []nats.Option{nats.Name("jevi-chat"), nats.MaxReconnects(-1)}
+		if EventBusSettings.NATSCreds != "" {
+			opts = append(opts, nats.UserCredentials(EventBusSettings.NATSCreds))
+		}
+		nc, err := nats.Connect(EventBusSettings.NATSURL, opts...)
+		if err != nil {
+			return fmt.Errorf("NATS unreachable: %v", err)
+		}
+		natsConn = nc
+	}
+	js, err := natsConn.JetStream()
+	if err != nil {
+		return err
+	}
+
+	if stream := EventBusSettings.NATSStream; stream != "" {
+		if _, err := js.StreamInfo(stream, nats.Context(ctx)); errors.Is(err, nats.ErrStreamNotFound) {
+			_, err = js.AddStream(&nats.StreamConfig{
+				Name:     stream,
+				Subjects: []string{EventBusSettings.NATSSubject + ".>"},
+				Storage:  nats.FileStorage,
+			}, nats.Context(ctx))
+			if err != nil {
+				return fmt.Errorf("failed to create stream %s: %v", stream, err)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for _, m := range messages {
+		msg := nats.NewMsg(EventBusSettings.NATSSubject + "." + m.Type)
+		msg.Data = m.Payload
+		msg.Header.Set(nats.MsgIdHdr, m.ID)
+		for name, value := range m.Headers {
+			msg.Header.Set(name, value)
+		}
+		if _, err := js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+			return fmt.Errorf("NATS publish of %s failed: %v", m.ID, err)
+		}
+	}
+	return nil
+}
+
+func publishKafka(ctx context.Context, messages []BusMessage) error {
+	if kafkaConn == nil {
+		transport := &kafka.Transport{}
+		if EventBusSettings.KafkaUsername != "" {
+			transport.SASL = plain.Mechanism{
+				Username: EventBusSettings.KafkaUsername,
+				Password: EventBusSettings.KafkaPassword,
+			}
+		}
+		if EventBusSettings.KafkaTLS {
+			transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		kafkaConn = &kafka.Writer{
+			Addr:         kafka.TCP(EventBusSettings.KafkaBrokers...),
+			Topic:        EventBusSettings.KafkaTopic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchSize:    EventBusSettings.BatchSize,
+			BatchTimeout: 50 * time.Millisecond,
+			Transport:    transport,
+		}
+	}
+
+	batch := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		headers := []kafka.Header{{Key: "event_id", Value: []byte(m.ID)}, {Key: "event_type", Value: []byte(m.Type)}}
+		for name, value := range m.Headers {
+			headers = append(headers, kafka.Header{Key: name, Value: []byte(value)})
+		}
+		batch[i] = kafka.Message{Key: []byte(m.Key), Value: m.Payload, Headers: headers}
+	}
+	if err := kafkaConn.WriteMessages(ctx, batch...); err != nil {
+		return fmt.Errorf("Kafka publish failed: %v", err)
+	}
+	return nil
+}
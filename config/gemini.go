@@ -2,40 +2,326 @@ package config
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
 )
 
-var GeminiClient *genai.Client
+// Gemini key health states
+const (
+	GeminiStatusUnknown = "unknown"
+	GeminiStatusHealthy = "healthy"
+	GeminiStatusFailing = "failing"
+)
+
+// GeminiKeyHealth is what the server has observed about one API key. Keys
+// are never reported, only their last four characters.
+type GeminiKeyHealth struct {
+	KeyHint       string    `json:"key_hint"`
+	Status        string    `json:"status"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastCheckedAt time.Time `json:"last_checked_at,omitempty"`
+	LastSuccessAt time.Time `json:"last_success_at,omitempty"`
+}
+
+type geminiEntry struct {
+	client   *genai.Client
+	health   GeminiKeyHealth
+	lastUsed time.Time
+}
+
+const (
+	// geminiIdleTimeout is how long a key that is not used keeps its client
+	geminiIdleTimeout = 30 * time.Minute
+	// geminiCloseGrace lets calls still holding an evicted client finish
+	geminiCloseGrace = 5 * time.Minute
+)
+
+var (
+	geminiMu      sync.Mutex
+	geminiClients = map[string]*geminiEntry{}
+	geminiSweptAt time.Time
+
+	// DefaultGeminiKey is the deployment-wide key from GEMINI_API_KEY. It
+	// may be empty; projects bring their own keys.
+	DefaultGeminiKey string
+)
 
-// ✅ Initialize Gemini client once
+// InitGemini reads the deployment key and warms its client up in the
+// background. A missing or broken key is logged, not fatal, so dashboards
+// keep working while AI is misconfigured.
 func InitGemini() {
-	apiKey := os.Getenv("GEMINI_API_KEY")
+	previous := DefaultGeminiKey
+	DefaultGeminiKey = os.Getenv("GEMINI_API_KEY")
+	if previous != DefaultGeminiKey {
+		ForgetGeminiKey(previous)
+	}
+	if DefaultGeminiKey == "" {
+		log.Println("⚠️ GEMINI_API_KEY not set; only projects with their own key can use AI")
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if health := WarmUpGemini(ctx, DefaultGeminiKey); health.Status != GeminiStatusHealthy {
+			log.Printf("⚠️ Gemini warm-up failed: %s", health.LastError)
+			return
+		}
+		log.Println("✅ Gemini client initialized successfully")
+	}()
+}
+
+// GeminiClientFor returns the shared client for an API key, creating it on
+// first use. Callers must not Close it. Failed creations are not cached, so
+// the next call retries.
+func GeminiClientFor(ctx context.Context, apiKey string) (*genai.Client, error) {
 	if apiKey == "" {
-		log.Fatal("GEMINI_API_KEY not set in environment")
+		return nil, fmt.Errorf("no Gemini API key configured")
 	}
 
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	geminiMu.Lock()
+	now := time.Now()
+	evictIdleGeminiClients(now)
+	entry := geminiEntryFor(apiKey)
+	entry.lastUsed = now
+	if chaosGeminiFailure() {
+		entry.health.Status = GeminiStatusFailing
+		entry.health.LastError = ErrChaosGemini.Error()
+		entry.health.LastCheckedAt = now
+		geminiMu.Unlock()
+		return nil, ErrChaosGemini
+	}
+	if client := entry.client; client != nil {
+		geminiMu.Unlock()
+		return client, nil
+	}
+	geminiMu.Unlock()
+
+	// Created without the lock, so a slow dial does not hold up other keys.
+	// The client outlives this request, so it must not inherit its deadline.
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey))
+
+	geminiMu.Lock()
+	defer geminiMu.Unlock()
+
+	// The entry may have been evicted while the client was created
+	entry = geminiEntryFor(apiKey)
 	if err != nil {
-		log.Fatal("❌ Failed to initialize Gemini client:", err)
+		entry.health.Status = GeminiStatusFailing
+		entry.health.LastError = err.Error()
+		entry.health.LastCheckedAt = time.Now()
+		return nil, fmt.Errorf("failed to create Gemini client: %v", err)
+	}
+	if entry.client != nil {
+		// Another caller created one first
+		client.Close()
+		return entry.client, nil
+	}
+	entry.client = client
+	return client, nil
+}
+
+// ForgetGeminiKey drops the cached client for a key that was rotated out.
+// The deployment key is kept.
+func ForgetGeminiKey(apiKey string) {
+	if apiKey == "" || apiKey == DefaultGeminiKey {
+		return
 	}
 
-	GeminiClient = client
-	log.Println("✅ Gemini client initialized successfully")
+	geminiMu.Lock()
+	defer geminiMu.Unlock()
+
+	id := geminiKeyID(apiKey)
+	if entry, ok := geminiClients[id]; ok {
+		delete(geminiClients, id)
+		retireGeminiClient(entry.client)
+	}
+}
+
+// ReportGeminiResult records the outcome of a call made with apiKey
+func ReportGeminiResult(apiKey string, err error) {
+	if apiKey == "" {
+		return
+	}
+
+	geminiMu.Lock()
+	defer geminiMu.Unlock()
+
+	entry := geminiEntryFor(apiKey)
+	now := time.Now()
+	entry.health.LastCheckedAt = now
+	if err != nil {
+		entry.health.Status = GeminiStatusFailing
+		entry.health.LastError = err.Error()
+		return
+	}
+	entry.health.Status = GeminiStatusHealthy
+	entry.health.LastError = ""
+	entry.health.LastSuccessAt = now
+}
+
+// WarmUpGemini creates the client for apiKey and verifies the key with a
+// cheap model lookup
+func WarmUpGemini(ctx context.Context, apiKey string) GeminiKeyHealth {
+	client, err := GeminiClientFor(ctx, apiKey)
+	if err == nil {
+		_, err = client.GenerativeModel("gemini-2.0-flash").Info(ctx)
+		ReportGeminiResult(apiKey, err)
+	}
+	return GeminiHealthFor(apiKey)
+}
+
+// GeminiHealthFor returns what is known about apiKey without calling Gemini
+func GeminiHealthFor(apiKey string) GeminiKeyHealth {
+	geminiMu.Lock()
+	defer geminiMu.Unlock()
+	if apiKey == "" {
+		return GeminiKeyHealth{Status: GeminiStatusUnknown, LastError: "no API key configured"}
+	}
+	return geminiEntryFor(apiKey).health
+}
+
+// GeminiStatus summarizes the deployment key for the health endpoint
+func GeminiStatus() string {
+	if DefaultGeminiKey == "" {
+		return "not configured"
+	}
+	return GeminiHealthFor(DefaultGeminiKey).Status
+}
+
+// geminiEntryFor must be called with geminiMu held
+func geminiEntryFor(apiKey string) *geminiEntry {
+	id := geminiKeyID(apiKey)
+	entry, ok := geminiClients[id]
+	if !ok {
+		hint := apiKey
+		if len(hint) > 4 {
+			hint = "…" + hint[len(hint)-4:]
+		}
+		entry = &geminiEntry{
+			health:   GeminiKeyHealth{KeyHint: hint, Status: GeminiStatusUnknown},
+			lastUsed: time.Now(),
+		}
+		geminiClients[id] = entry
+	}
+	return entry
+}
+
+func geminiKeyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// evictIdleGeminiClients drops the keys other than the deployment key that
+// have not been used for geminiIdleTimeout. It sweeps at most once a minute
+// and must be called with geminiMu held.
+func evictIdleGeminiClients(now time.Time) {
+	if now.Sub(geminiSweptAt) < time.Minute {
+		return
+	}
+	geminiSweptAt = now
+
+	keep := ""
+	if DefaultGeminiKey != "" {
+		keep = geminiKeyID(DefaultGeminiKey)
+	}
+	for id, entry := range geminiClients {
+		if id != keep && now.Sub(entry.lastUsed) > geminiIdleTimeout {
+			delete(geminiClients, id)
+			retireGeminiClient(entry.client)
+		}
+	}
+}
+
+// retireGeminiClient closes an evicted client once calls that already hold
+// it have had time to finish
+func retireGeminiClient(client *genai.Client) {
+	if client != nil {
+		time.AfterFunc(geminiCloseGrace, func() { client.Close() })
+	}
+}
+
+// CloseGeminiClients releases every cached client on shutdown
+func CloseGeminiClients() {
+	geminiMu.Lock()
+	defer geminiMu.Unlock()
+	for _, entry := range geminiClients {
+		if entry.client != nil {
+			entry.client.Close()
+			entry.client = nil
+		}
+	}
+}
+
+// embedBatchSize is the most texts Gemini embeds in one request
+const embedBatchSize = 100
+
+// EmbeddingModel is the Gemini model passages and questions are embedded
+// with (GEMINI_EMBEDDING_MODEL, default text-embedding-004). Vectors from
+// different models cannot be compared.
+func EmbeddingModel() string {
+	if name := os.Getenv("GEMINI_EMBEDDING_MODEL"); name != "" {
+		return name
+	}
+	return "text-embedding-004"
+}
+
+// EmbedTexts embeds texts with apiKey, in order. Questions are embedded as
+// retrieval queries and everything else as documents to retrieve.
+func EmbedTexts(ctx context.Context, apiKey string, texts []string, query bool) ([][]float32, error) {
+	client, err := GeminiClientFor(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	model := client.EmbeddingModel(EmbeddingModel())
+	model.TaskType = genai.TaskTypeRetrievalDocument
+	if query {
+		model.TaskType = genai.TaskTypeRetrievalQuery
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch := model.NewBatch()
+		for _, text := range texts[start:end] {
+			batch.AddContent(genai.Text(text))
+		}
+		resp, err := model.BatchEmbedContents(ctx, batch)
+		ReportGeminiResult(apiKey, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed texts: %v", err)
+		}
+		if len(resp.Embeddings) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", end-start, len(resp.Embeddings))
+		}
+		for _, e := range resp.Embeddings {
+			vectors = append(vectors, e.Values)
+		}
+	}
+	return vectors, nil
 }
 
 // ✅ Main function: Ask Gemini & return cleaned response
 func GenerateResponse(userPrompt string, pdfContext string) (string, error) {
 	ctx := context.Background()
-	model := GeminiClient.GenerativeModel("gemini-2.0-flash")
+	client, err := GeminiClientFor(ctx, DefaultGeminiKey)
+	if err != nil {
+		return "", err
+	}
+	model := client.GenerativeModel("gemini-2.0-flash")
 
 	// Set model behavior
 	model.SetTemperature(0.85)
@@ -70,6 +356,7 @@ Context: %s
 
 	// Request Gemini to generate content
 	resp, err := model.GenerateContent(ctx, genai.Text(fullPrompt))
+	ReportGeminiResult(DefaultGeminiKey, err)
 	if err != nil {
 		log.Printf("❌ Gemini content generation failed: %v", err)
 		return "", fmt.Errorf("failed to generate content: %v", err)
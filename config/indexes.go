@@ -0,0 +1,436 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexSpec describes an index the application relies on. A non-zero TTL
+// makes MongoDB expire documents that long after the (single) key's date;
+// Partial limits the index to documents matching the filter.
+type IndexSpec struct {
+	Keys    bson.D
+	Unique  bool
+	TTL     time.Duration
+	Partial bson.M
+}
+
+// Name returns MongoDB's default index name for the key pattern
+func (s IndexSpec) Name() string {
+	parts := make([]string, 0, len(s.Keys))
+	for _, k := range s.Keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index()
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.TTL > 0 {
+		opts.SetExpireAfterSeconds(int32(s.TTL / time.Second))
+	}
+	if s.Partial != nil {
+		opts.SetPartialFilterExpression(s.Partial)
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+type collectionIndexes struct {
+	Collection string
+	Indexes    []IndexSpec
+}
+
+func asc(field string) bson.E  { return bson.E{Key: field, Value: 1} }
+func desc(field string) bson.E { return bson.E{Key: field, Value: -1} }
+
+// expectedIndexes is the single source of truth for indexes created at
+// startup, reported by GetIndexReport and repaired by CreateMissingIndexes
+var expectedIndexes = []collectionIndexes{
+	{"projects", []IndexSpec{
+		{Keys: bson.D{asc("name")}},
+		{Keys: bson.D{asc("is_active")}},
+		{Keys: bson.D{asc("gemini_enabled")}},
+		{Keys: bson.D{desc("created_at")}},
+		{Keys: bson.D{asc("library_docs.document_id")}},
+	}},
+	{"chat_messages", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("session_id")}},
+		{Keys: bson.D{desc("timestamp")}},
+		{Keys: bson.D{asc("project_id"), desc("timestamp")}},
+		{Keys: bson.D{asc("user_id")}},
+		{Keys: bson.D{asc("project_id"), asc("citations.file_id")}},
+		{Keys: bson.D{asc("project_id"), asc("client_message_id")}, Unique: true,
+			Partial: bson.M{"client_message_id": bson.M{"$type": "string"}}},
+		{Keys: bson.D{asc("project_id"), asc("import_batch_id")},
+			Partial: bson.M{"import_batch_id": bson.M{"$exists": true}}},
+	}},
+	{"chat_sessions", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("session_id")}, Unique: true},
+		{Keys: bson.D{asc("user_id")}},
+		{Keys: bson.D{asc("project_id"), asc("user_id"), desc("last_seen_at")}},
+		{Keys: bson.D{asc("project_id"), asc("import_batch_id")},
+			Partial: bson.M{"import_batch_id": bson.M{"$exists": true}}},
+	}},
+	{"chat_users", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("email")}, Unique: true},
+		{Keys: bson.D{asc("email")}},
+		{Keys: bson.D{asc("is_active")}},
+	}},
+	{"users", []IndexSpec{
+		{Keys: bson.D{asc("email")}, Unique: true},
+		{Keys: bson.D{asc("username")}},
+		{Keys: bson.D{asc("is_active")}},
+		{Keys: bson.D{asc("role")}},
+	}},
+	{"request_nonces", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("nonce")}, Unique: true},
+		{Keys: bson.D{asc("created_at")}, TTL: 15 * time.Minute},
+	}},
+	{"user_sessions", []IndexSpec{
+		{Keys: bson.D{asc("user_id"), asc("fingerprint")}, Unique: true},
+		{Keys: bson.D{desc("last_seen_at")}},
+	}},
+	{"user_deletions", []IndexSpec{
+		{Keys: bson.D{asc("status"), asc("heartbeat_at")}},
+		{Keys: bson.D{asc("user_id")}},
+	}},
+	{"gemini_usage_logs", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), desc("timestamp")}},
+		{Keys: bson.D{desc("timestamp")}},
+		{Keys: bson.D{asc("user_ip")}},
+		{Keys: bson.D{asc("success")}},
+	}},
+	{"gemini_usage_daily", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("date")}, Unique: true},
+		{Keys: bson.D{desc("date")}},
+	}},
+	{"overage_charges", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), desc("period_end")}},
+		{Keys: bson.D{asc("billed")}},
+	}},
+	{"chat_archives", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+	}},
+	{"project_blocks", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+		{Keys: bson.D{asc("project_id"), asc("chat_user_id")}},
+		{Keys: bson.D{asc("project_id"), asc("email")}},
+		{Keys: bson.D{asc("project_id"), asc("ip_address")}},
+	}},
+	{"products", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("sku")}, Unique: true},
+		{Keys: bson.D{asc("project_id"), asc("category")}},
+	}},
+	{"document_pages", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("file_id"), asc("page")}, Unique: true},
+	}},
+	{"document_chunks", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("file_id"), asc("page"), asc("offset")}},
+	}},
+	{"upload_batches", []IndexSpec{
+		{Keys: bson.D{asc("status"), asc("created_at")}},
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+	}},
+	{"message_redactions", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("message_id")}},
+	}},
+	{"chunk_embeddings", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("file_id")}},
+		{Keys: bson.D{asc("library_document_id")}},
+	}},
+	{"instruction_revisions", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("revision")}, Unique: true},
+	}},
+	{"chat_user_tokens", []IndexSpec{
+		{Keys: bson.D{asc("token_hash")}, Unique: true},
+		{Keys: bson.D{asc("project_id"), asc("user_id")}},
+		{Keys: bson.D{asc("expires_at")}, TTL: 30 * 24 * time.Hour},
+	}},
+	{"library_documents", []IndexSpec{
+		{Keys: bson.D{desc("uploaded_at")}},
+		{Keys: bson.D{asc("owner_id"), desc("uploaded_at")}},
+		{Keys: bson.D{asc("owner_id"), asc("content_hash")}},
+	}},
+	{"leads", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+		{Keys: bson.D{asc("project_id"), asc("email")}},
+	}},
+	{"slo_metrics", []IndexSpec{
+		{Keys: bson.D{asc("group"), asc("bucket")}, Unique: true},
+		{Keys: bson.D{asc("bucket")}, TTL: 90 * 24 * time.Hour},
+	}},
+	{"audit_logs", []IndexSpec{
+		{Keys: bson.D{desc("created_at")}},
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+		{Keys: bson.D{asc("action")}},
+	}},
+	{"analytics_share_links", []IndexSpec{
+		{Keys: bson.D{asc("token_hash")}, Unique: true},
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+	}},
+	{"welcome_events", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+		{Keys: bson.D{asc("created_at")}, TTL: 180 * 24 * time.Hour},
+	}},
+	{"webhooks", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("events")}},
+	}},
+	{"webhook_deliveries", []IndexSpec{
+		{Keys: bson.D{asc("webhook_id"), desc("created_at")}},
+		{Keys: bson.D{asc("status"), asc("next_attempt_at")}},
+		{Keys: bson.D{asc("created_at")}, TTL: 30 * 24 * time.Hour},
+	}},
+	{"transcript_imports", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), desc("created_at")}},
+	}},
+	{"api_key_usage", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("key_id"), asc("hour")}, Unique: true},
+		{Keys: bson.D{asc("hour")}, TTL: 90 * 24 * time.Hour},
+	}},
+	{"chat_events", []IndexSpec{
+		{Keys: bson.D{asc("project_id"), asc("_id")}},
+		{Keys: bson.D{asc("type"), asc("_id")}},
+	}},
+	{"notifications", []IndexSpec{
+		{Keys: bson.D{asc("project_id")}},
+		{Keys: bson.D{asc("user_id")}},
+		{Keys: bson.D{desc("created_at")}},
+		{Keys: bson.D{asc("expires_at")}},
+		{Keys: bson.D{asc("is_read")}},
+		{Keys: bson.D{asc("type")}},
+		{Keys: bson.D{asc("project_id"), asc("type")}},
+		{Keys: bson.D{asc("is_archived"), desc("_id")}},
+	}},
+}
+
+// setupIndexes creates every expected index. Since MongoDB 4.2 all index
+// builds are non-blocking, so no background option is needed.
+// ProjectScopedCollections lists the collections whose documents belong to
+// a project, recognized by an expected index on project_id
+func ProjectScopedCollections() []string {
+	var names []string
+	for _, col := range expectedIndexes {
+	indexes:
+		for _, idx := range col.Indexes {
+			for _, k := range idx.Keys {
+				if k.Key == "project_id" {
+					names = append(names, col.Collection)
+					break indexes
+				}
+			}
+		}
+	}
+	return names
+}
+
+func setupIndexes(ctx context.Context) error {
+	for _, col := range expectedIndexes {
+		models := make([]mongo.IndexModel, 0, len(col.Indexes))
+		for _, spec := range col.Indexes {
+			models = append(models, spec.model())
+		}
+		if _, err := DB.Collection(col.Collection).Indexes().CreateMany(ctx, models); err != nil {
+			log.Printf("⚠️ Failed to create %s indexes: %v", col.Collection, err)
+		}
+	}
+	for _, db := range TenantDatabases()[1:] {
+		if err := ensureTenantIndexes(ctx, db); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	}
+
+	log.Println("📈 Database indexes setup completed successfully")
+	return nil
+}
+
+// IndexStatus is the state of one expected index
+type IndexStatus struct {
+	Name    string `json:"name"`
+	Keys    bson.D `json:"keys"`
+	Unique  bool   `json:"unique"`
+	Present bool   `json:"present"`
+	// UniqueMismatch is set when the index exists but its unique flag differs
+	UniqueMismatch bool `json:"unique_mismatch,omitempty"`
+}
+
+// CollectionIndexReport compares actual and expected indexes for a collection
+type CollectionIndexReport struct {
+	Collection string        `json:"collection"`
+	Expected   []IndexStatus `json:"expected"`
+	Missing    []string      `json:"missing"`
+	Extra      []string      `json:"extra"`
+}
+
+// IndexBuild is an index build currently running on the server
+type IndexBuild struct {
+	Namespace   string  `json:"namespace"`
+	Message     string  `json:"message"`
+	Done        float64 `json:"done"`
+	Total       float64 `json:"total"`
+	SecsRunning int64   `json:"secs_running"`
+}
+
+type existingIndex struct {
+	Name   string `bson:"name"`
+	Key    bson.D `bson:"key"`
+	Unique bool   `bson:"unique"`
+}
+
+// keySignature normalizes a key pattern so numeric types don't affect equality
+func keySignature(keys bson.D) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		var v string
+		switch n := k.Value.(type) {
+		case int32:
+			v = fmt.Sprint(int64(n))
+		case int64:
+			v = fmt.Sprint(n)
+		case int:
+			v = fmt.Sprint(int64(n))
+		case float64:
+			v = fmt.Sprint(int64(n))
+		default:
+			v = fmt.Sprint(n)
+		}
+		parts = append(parts, k.Key+":"+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func listIndexes(ctx context.Context, collection string) ([]existingIndex, error) {
+	cursor, err := DB.Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []existingIndex
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+// GetIndexReport lists actual indexes against the expected set
+func GetIndexReport(ctx context.Context) ([]CollectionIndexReport, error) {
+	reports := make([]CollectionIndexReport, 0, len(expectedIndexes))
+
+	for _, col := range expectedIndexes {
+		existing, err := listIndexes(ctx, col.Collection)
+		if err != nil {
+			// A collection that doesn't exist yet simply has no indexes
+			if cmdErr, ok := err.(mongo.CommandError); !ok || cmdErr.Code != 26 {
+				return nil, fmt.Errorf("failed to list %s indexes: %v", col.Collection, err)
+			}
+		}
+
+		bySignature := make(map[string]existingIndex, len(existing))
+		for _, idx := range existing {
+			bySignature[keySignature(idx.Key)] = idx
+		}
+
+		report := CollectionIndexReport{Collection: col.Collection, Missing: []string{}, Extra: []string{}}
+		expectedSigs := make(map[string]bool, len(col.Indexes))
+		for _, spec := range col.Indexes {
+			sig := keySignature(spec.Keys)
+			expectedSigs[sig] = true
+
+			status := IndexStatus{Name: spec.Name(), Keys: spec.Keys, Unique: spec.Unique}
+			if idx, ok := bySignature[sig]; ok {
+				status.Present = true
+				status.UniqueMismatch = idx.Unique != spec.Unique
+			} else {
+				report.Missing = append(report.Missing, spec.Name())
+			}
+			report.Expected = append(report.Expected, status)
+		}
+
+		for _, idx := range existing {
+			if idx.Name == "_id_" || expectedSigs[keySignature(idx.Key)] {
+				continue
+			}
+			report.Extra = append(report.Extra, idx.Name)
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// CreateMissingIndexes builds every expected index that is not present and
+// returns the names of the indexes it created, keyed by collection
+func CreateMissingIndexes(ctx context.Context) (map[string][]string, error) {
+	reports, err := GetIndexReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	created := make(map[string][]string)
+	for i, report := range reports {
+		if len(report.Missing) == 0 {
+			continue
+		}
+
+		var models []mongo.IndexModel
+		for j, status := range report.Expected {
+			if !status.Present {
+				models = append(models, expectedIndexes[i].Indexes[j].model())
+			}
+		}
+
+		names, err := DB.Collection(expectedIndexes[i].Collection).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return created, fmt.Errorf("failed to create %s indexes: %v", report.Collection, err)
+		}
+		created[report.Collection] = names
+		log.Printf("📈 Created missing %s indexes: %v", report.Collection, names)
+	}
+
+	return created, nil
+}
+
+// GetIndexBuilds reports index builds in progress via currentOp. This needs
+// the inprog privilege, which some hosted tiers do not grant.
+func GetIndexBuilds(ctx context.Context) ([]IndexBuild, error) {
+	var result struct {
+		InProg []struct {
+			Namespace   string `bson:"ns"`
+			Message     string `bson:"msg"`
+			SecsRunning int64  `bson:"secs_running"`
+			Progress    struct {
+				Done  float64 `bson:"done"`
+				Total float64 `bson:"total"`
+			} `bson:"progress"`
+		} `bson:"inprog"`
+	}
+
+	cmd := bson.D{
+		{Key: "currentOp", Value: true},
+		{Key: "command.createIndexes", Value: bson.M{"$exists": true}},
+	}
+	if err := Client.Database("admin").RunCommand(ctx, cmd).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	builds := make([]IndexBuild, 0, len(result.InProg))
+	for _, op := range result.InProg {
+		builds = append(builds, IndexBuild{
+			Namespace:   op.Namespace,
+			Message:     op.Message,
+			Done:        op.Progress.Done,
+			Total:       op.Progress.Total,
+			SecsRunning: op.SecsRunning,
+		})
+	}
+	return builds, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InstanceID names this replica in job leases and /health
+var InstanceID = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+// AcquireLease claims the named periodic job for ttl. It returns false while
+// another replica holds an unexpired lease, so background work runs once per
+// cluster rather than once per replica. The holder may renew its own lease.
+func AcquireLease(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lte": now}},
+			bson.M{"holder": InstanceID},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"holder":      InstanceID,
+		"acquired_at": now,
+		"expires_at":  now.Add(ttl),
+	}}
+
+	_, err := GetCollection("job_leases").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lease exists and belongs to someone else
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailEnabled reports whether SMTP delivery is configured
+func EmailEnabled() bool {
+	return NotificationSettings != nil &&
+		NotificationSettings.SMTPHost != "" &&
+		NotificationSettings.SMTPFromEmail != ""
+}
+
+// SendEmail delivers a plain-text message through the configured SMTP server
+func SendEmail(to, subject, body string) error {
+	return sendMail(to, subject, "text/plain; charset=UTF-8", []byte(body))
+}
+
+// SendHTMLEmail delivers a message with an HTML body and a plain-text
+// alternative for clients that don't show HTML
+func SendHTMLEmail(to, subject, textBody, htmlBody string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	parts := []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", textBody},
+		{"text/html; charset=UTF-8", htmlBody},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return err
+		}
+		w.Write([]byte(p.content))
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return sendMail(to, subject, "multipart/alternative; boundary="+mw.Boundary(), body.Bytes())
+}
+
+// sendMail sends one message with the given body content type
+func sendMail(to, subject, contentType string, body []byte) error {
+	if !EmailEnabled() {
+		return fmt.Errorf("email is not configured")
+	}
+	s := NotificationSettings
+
+	from := s.SMTPFromEmail
+	if s.SMTPFromName != "" {
+		from = fmt.Sprintf("%s <%s>", s.SMTPFromName, s.SMTPFromEmail)
+	}
+
+	// Header injection guard: addresses and subject are single-line
+	for _, v := range []string{to, subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid email header value")
+		}
+	}
+
+	header := strings.Join([]string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: " + contentType,
+		"",
+		"",
+	}, "\r\n")
+	msg := append([]byte(header), body...)
+
+	var auth smtp.Auth
+	if s.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.SMTPUsername, s.SMTPPassword, s.SMTPHost)
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.SMTPHost, s.SMTPPort)
+	return smtp.SendMail(addr, auth, s.SMTPFromEmail, []string{to}, msg)
+}
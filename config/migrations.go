@@ -0,0 +1,153 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/models"
+)
+
+// projectScopedCollections hold a project_id that must be an ObjectID.
+// chat_users historically stored it as a hex string.
+var projectScopedCollections = []string{
+	"chat_users",
+	"chat_messages",
+	"chat_sessions",
+	"gemini_usage_logs",
+	"gemini_usage_daily",
+	"chat_archives",
+	"notifications",
+}
+
+// legacyProjectIDFilter matches project_id values stored as hex strings
+var legacyProjectIDFilter = bson.M{"project_id": bson.M{"$type": "string"}}
+
+// BackfillProjectIDs converts string project_id values to ObjectIDs. Rows
+// that would collide with an existing row on a unique index (a chat user
+// registered twice, once per format) are left as-is and reported.
+func BackfillProjectIDs(ctx context.Context) (converted int64, skipped int64, err error) {
+	for _, name := range projectScopedCollections {
+		collection := DB.Collection(name)
+
+		cursor, err := collection.Find(ctx, legacyProjectIDFilter, options.Find().SetProjection(bson.M{"project_id": 1}))
+		if err != nil {
+			return converted, skipped, fmt.Errorf("failed to scan %s: %v", name, err)
+		}
+
+		for cursor.Next(ctx) {
+			var row struct {
+				ID        interface{} `bson:"_id"`
+				ProjectID string      `bson:"project_id"`
+			}
+			if err := cursor.Decode(&row); err != nil {
+				cursor.Close(ctx)
+				return converted, skipped, err
+			}
+
+			objID, err := primitive.ObjectIDFromHex(row.ProjectID)
+			if err != nil {
+				log.Printf("⚠️ %s %v has unparseable project_id %q", name, row.ID, row.ProjectID)
+				skipped++
+				continue
+			}
+
+			_, err = collection.UpdateOne(ctx,
+				bson.M{"_id": row.ID, "project_id": row.ProjectID},
+				bson.M{"$set": bson.M{"project_id": objID}},
+			)
+			if mongo.IsDuplicateKeyError(err) {
+				log.Printf("⚠️ %s %v duplicates an existing row for project %s, left unconverted", name, row.ID, row.ProjectID)
+				skipped++
+				continue
+			}
+			if err != nil {
+				cursor.Close(ctx)
+				return converted, skipped, fmt.Errorf("failed to convert %s %v: %v", name, row.ID, err)
+			}
+			converted++
+		}
+		if err := cursor.Err(); err != nil {
+			cursor.Close(ctx)
+			return converted, skipped, err
+		}
+		cursor.Close(ctx)
+	}
+
+	if converted > 0 || skipped > 0 {
+		log.Printf("🔄 project_id backfill: %d converted, %d skipped", converted, skipped)
+	}
+	return converted, skipped, nil
+}
+
+// CountLegacyProjectIDs reports remaining string project_id values per collection
+func CountLegacyProjectIDs(ctx context.Context) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, name := range projectScopedCollections {
+		n, err := DB.Collection(name).CountDocuments(ctx, legacyProjectIDFilter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s: %v", name, err)
+		}
+		if n > 0 {
+			counts[name] = n
+		}
+	}
+	return counts, nil
+}
+
+// MoveLibraryToStorage copies library documents uploaded before object
+// storage from the public uploads directory into Storage and removes the
+// local copies. Documents whose file is gone keep their extracted content.
+func MoveLibraryToStorage(ctx context.Context) (int, error) {
+	if Storage == nil {
+		return 0, nil
+	}
+	collection := GetLibraryDocumentsCollection()
+	cursor, err := collection.Find(ctx, bson.M{
+		"file_path":   bson.M{"$exists": true, "$ne": ""},
+		"storage_key": bson.M{"$in": bson.A{nil, ""}},
+	}, options.Find().SetProjection(bson.M{"content": 0}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list library documents: %v", err)
+	}
+	var docs []models.LibraryDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, fmt.Errorf("failed to decode library documents: %v", err)
+	}
+
+	moved := 0
+	for _, doc := range docs {
+		update := bson.M{"$unset": bson.M{"file_path": ""}}
+		if f, err := os.Open(doc.FilePath); err == nil {
+			key := doc.ObjectKey()
+			info, err := f.Stat()
+			if err == nil {
+				err = Storage.Put(ctx, key, f, info.Size(), "application/pdf")
+			}
+			f.Close()
+			if err != nil {
+				log.Printf("⚠️ Failed to move library document %s to storage: %v", doc.ID.Hex(), err)
+				continue
+			}
+			update["$set"] = bson.M{"storage_key": key}
+		} else if !os.IsNotExist(err) {
+			log.Printf("⚠️ Failed to open library document %s: %v", doc.ID.Hex(), err)
+			continue
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, update); err != nil {
+			return moved, fmt.Errorf("failed to update library document %s: %v", doc.ID.Hex(), err)
+		}
+		os.Remove(doc.FilePath)
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("📚 Moved %d library documents to object storage", moved)
+	}
+	return moved, nil
+}
@@ -0,0 +1,454 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/models"
+)
+
+// uploadsDir is where UploadPDF stored project files before object storage
+const uploadsDir = "./static/uploads"
+
+// uploadsPrefix is where project files are kept in object storage
+const uploadsPrefix = "uploads/"
+
+// orphanObjectGrace spares recently written objects, which may belong to an
+// upload whose record is not saved yet
+const orphanObjectGrace = time.Hour
+
+// OrphanReport counts data that references projects or chat users which no
+// longer exist
+type OrphanReport struct {
+	Messages          int64    `json:"messages"`
+	Sessions          int64    `json:"sessions"`
+	DanglingUserLinks int64    `json:"dangling_user_links"`
+	ChatUsers         int64    `json:"chat_users"`
+	UsageLogs         int64    `json:"usage_logs"`
+	UsageDaily        int64    `json:"usage_daily"`
+	Notifications     int64    `json:"notifications"`
+	Archives          int64    `json:"archives"`
+	Files             int      `json:"files"`
+	FileBytes         int64    `json:"file_bytes"`
+	MissingProjectIDs []string `json:"missing_project_ids"`
+	Total             int64    `json:"total"`
+}
+
+// orphanScope holds the live ID sets every orphan query is built from
+type orphanScope struct {
+	// projectIDValues adds the legacy hex form, for rows not yet backfilled
+	projectIDValues bson.A
+	deadUserIDs     []primitive.ObjectID
+	orphanFiles     []string
+	orphanObjects   []StoredObject
+}
+
+// referencedFiles collects the local paths and storage keys that records
+// still point to
+type referencedFiles struct {
+	paths map[string]bool
+	keys  map[string]bool
+}
+
+func (r referencedFiles) add(path, key string) {
+	if path != "" {
+		r.paths[filepath.Clean(path)] = true
+	}
+	if key != "" {
+		r.keys[key] = true
+	}
+}
+
+func loadOrphanScope(ctx context.Context) (*orphanScope, error) {
+	cursor, err := GetProjectsCollection().Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+		"_id":                   1,
+		"pdf_files.file_path":   1,
+		"pdf_files.storage_key": 1,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load projects: %v", err)
+	}
+	var projects []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		PDFFiles []storedFileRef    `bson:"pdf_files"`
+	}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, fmt.Errorf("failed to decode projects: %v", err)
+	}
+
+	scope := &orphanScope{
+		projectIDValues: make(bson.A, 0, 2*len(projects)),
+	}
+	referenced := referencedFiles{paths: map[string]bool{}, keys: map[string]bool{}}
+	for _, p := range projects {
+		scope.projectIDValues = append(scope.projectIDValues, p.ID, p.ID.Hex())
+		for _, f := range p.PDFFiles {
+			referenced.add(f.FilePath, f.StorageKey)
+		}
+	}
+	// Queued batch files are not on the project until they are processed
+	if err := referenceBatchFiles(ctx, referenced); err != nil {
+		return nil, err
+	}
+
+	// Chat messages keep a user_id after the chat user is deleted
+	linked, err := distinctAcrossTenants(ctx, "chat_messages", "user_id", bson.M{"user_id": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list linked users: %v", err)
+	}
+	if len(linked) > 0 {
+		live, err := GetChatUsersCollection().Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": linked}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list chat users: %v", err)
+		}
+		alive := make(map[primitive.ObjectID]bool, len(live))
+		for _, id := range live {
+			if oid, ok := id.(primitive.ObjectID); ok {
+				alive[oid] = true
+			}
+		}
+		for _, id := range linked {
+			if oid, ok := id.(primitive.ObjectID); ok && !oid.IsZero() && !alive[oid] {
+				scope.deadUserIDs = append(scope.deadUserIDs, oid)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(uploadsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read uploads: %v", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		path := filepath.Join(uploadsDir, e.Name())
+		if !referenced.paths[filepath.Clean(path)] {
+			scope.orphanFiles = append(scope.orphanFiles, path)
+		}
+	}
+
+	if Storage != nil {
+		objects, err := Storage.List(ctx, uploadsPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stored uploads: %v", err)
+		}
+		cutoff := time.Now().Add(-orphanObjectGrace)
+		for _, obj := range objects {
+			if !referenced.keys[obj.Key] && obj.Modified.Before(cutoff) {
+				scope.orphanObjects = append(scope.orphanObjects, obj)
+			}
+		}
+	}
+
+	return scope, nil
+}
+
+// storedFileRef is the location of a file a record points to
+type storedFileRef struct {
+	FilePath   string `bson:"file_path"`
+	StorageKey string `bson:"storage_key"`
+}
+
+// referenceBatchFiles adds the files of upload batches to referenced
+func referenceBatchFiles(ctx context.Context, referenced referencedFiles) error {
+	for _, col := range AllTenantCollections("upload_batches") {
+		cursor, err := col.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+			"files.file_path":   1,
+			"files.storage_key": 1,
+		}))
+		if err != nil {
+			return fmt.Errorf("failed to load upload batches: %v", err)
+		}
+		var batches []struct {
+			Files []storedFileRef `bson:"files"`
+		}
+		if err := cursor.All(ctx, &batches); err != nil {
+			return fmt.Errorf("failed to decode upload batches: %v", err)
+		}
+		for _, b := range batches {
+			for _, f := range b.Files {
+				referenced.add(f.FilePath, f.StorageKey)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *orphanScope) projectFilter() bson.M {
+	return bson.M{"project_id": bson.M{"$nin": s.projectIDValues}}
+}
+
+// DetectOrphans counts orphaned records without changing anything
+func DetectOrphans(ctx context.Context) (*OrphanReport, error) {
+	scope, err := loadOrphanScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OrphanReport{MissingProjectIDs: []string{}}
+	counts := []struct {
+		collection string
+		filter     bson.M
+		count      *int64
+	}{
+		{"chat_messages", scope.projectFilter(), &report.Messages},
+		{"chat_users", scope.projectFilter(), &report.ChatUsers},
+		{"gemini_usage_logs", scope.projectFilter(), &report.UsageLogs},
+		{"gemini_usage_daily", scope.projectFilter(), &report.UsageDaily},
+		// Platform-wide notifications have no project
+		{"notifications", bson.M{"project_id": bson.M{"$exists": true, "$nin": scope.projectIDValues}}, &report.Notifications},
+		{"chat_archives", scope.projectFilter(), &report.Archives},
+	}
+	for _, c := range counts {
+		n, err := CountTenantDocuments(ctx, c.collection, c.filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s orphans: %v", c.collection, err)
+		}
+		*c.count = n
+	}
+
+	sessions, err := distinctAcrossTenants(ctx, "chat_messages", "session_id", scope.projectFilter())
+	if err != nil {
+		return nil, fmt.Errorf("failed to count orphaned sessions: %v", err)
+	}
+	report.Sessions = int64(len(sessions))
+
+	if len(scope.deadUserIDs) > 0 {
+		report.DanglingUserLinks, err = CountTenantDocuments(ctx, "chat_messages", bson.M{
+			"user_id": bson.M{"$in": scope.deadUserIDs},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count dangling user links: %v", err)
+		}
+	}
+
+	missing, err := distinctAcrossTenants(ctx, "chat_messages", "project_id", scope.projectFilter())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list missing projects: %v", err)
+	}
+	for _, id := range missing {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			report.MissingProjectIDs = append(report.MissingProjectIDs, oid.Hex())
+		}
+	}
+
+	for _, path := range scope.orphanFiles {
+		if info, err := os.Stat(path); err == nil {
+			report.Files++
+			report.FileBytes += info.Size()
+		}
+	}
+	for _, obj := range scope.orphanObjects {
+		report.Files++
+		report.FileBytes += obj.Size
+	}
+
+	report.Total = report.Messages + report.DanglingUserLinks + report.ChatUsers + report.UsageLogs +
+		report.UsageDaily + report.Notifications + report.Archives + int64(report.Files)
+	return report, nil
+}
+
+// CleanOrphans deletes records of deleted projects, removes unreferenced
+// upload files and unlinks messages from deleted chat users. The messages
+// themselves are kept as anonymous history.
+func CleanOrphans(ctx context.Context) (map[string]int64, error) {
+	scope, err := loadOrphanScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]int64)
+
+	// Archive objects go before their manifests so nothing is left unreachable
+	cursor, err := GetChatArchivesCollection().Find(ctx, scope.projectFilter())
+	if err != nil {
+		return removed, fmt.Errorf("failed to list orphaned archives: %v", err)
+	}
+	var archives []struct {
+		StorageKey string `bson:"storage_key"`
+	}
+	if err := cursor.All(ctx, &archives); err != nil {
+		return removed, fmt.Errorf("failed to decode orphaned archives: %v", err)
+	}
+	for _, a := range archives {
+		if err := Storage.Delete(ctx, a.StorageKey); err != nil {
+			log.Printf("⚠️ Failed to remove orphaned archive %s: %v", a.StorageKey, err)
+		}
+	}
+
+	deletes := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{"chat_messages", scope.projectFilter()},
+		{"chat_sessions", scope.projectFilter()},
+		{"chat_users", scope.projectFilter()},
+		{"gemini_usage_logs", scope.projectFilter()},
+		{"gemini_usage_daily", scope.projectFilter()},
+		{"notifications", bson.M{"project_id": bson.M{"$exists": true, "$nin": scope.projectIDValues}}},
+		{"chat_archives", scope.projectFilter()},
+		{"project_blocks", scope.projectFilter()},
+		{"leads", scope.projectFilter()},
+		{"products", scope.projectFilter()},
+		{"document_pages", scope.projectFilter()},
+		{"document_chunks", scope.projectFilter()},
+		{"upload_batches", scope.projectFilter()},
+		{"message_redactions", scope.projectFilter()},
+		// Library passages have no project
+		{"chunk_embeddings", bson.M{"project_id": bson.M{"$exists": true, "$nin": scope.projectIDValues}}},
+		{"instruction_revisions", scope.projectFilter()},
+		{"chat_user_tokens", scope.projectFilter()},
+	}
+	for _, d := range deletes {
+		for _, col := range AllTenantCollections(d.collection) {
+			res, err := col.DeleteMany(ctx, d.filter)
+			if err != nil {
+				return removed, fmt.Errorf("failed to clean %s: %v", d.collection, err)
+			}
+			removed[d.collection] += res.DeletedCount
+		}
+	}
+
+	if len(scope.deadUserIDs) > 0 {
+		for _, col := range AllTenantCollections("chat_messages") {
+			res, err := col.UpdateMany(ctx,
+				bson.M{"user_id": bson.M{"$in": scope.deadUserIDs}},
+				bson.M{"$unset": bson.M{"user_id": ""}},
+			)
+			if err != nil {
+				return removed, fmt.Errorf("failed to unlink deleted users: %v", err)
+			}
+			removed["dangling_user_links"] += res.ModifiedCount
+		}
+	}
+
+	for _, path := range scope.orphanFiles {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ Failed to remove orphaned file %s: %v", path, err)
+			continue
+		}
+		removed["files"]++
+	}
+	for _, obj := range scope.orphanObjects {
+		if err := Storage.Delete(ctx, obj.Key); err != nil {
+			log.Printf("⚠️ Failed to remove orphaned object %s: %v", obj.Key, err)
+			continue
+		}
+		removed["files"]++
+	}
+
+	log.Printf("🧹 Orphan cleanup completed: %v", removed)
+	return removed, nil
+}
+
+// RelinkOrphans moves data of a deleted project onto an existing one, for
+// when a project was deleted and recreated under a new ID
+func RelinkOrphans(ctx context.Context, fromID, toID primitive.ObjectID) (map[string]int64, error) {
+	if n, err := GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": fromID}); err != nil {
+		return nil, err
+	} else if n > 0 {
+		return nil, fmt.Errorf("project %s still exists", fromID.Hex())
+	}
+	if n, err := GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": toID}); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, fmt.Errorf("target project %s not found", toID.Hex())
+	}
+
+	moved := make(map[string]int64)
+	for _, name := range []string{"chat_messages", "chat_sessions", "chat_users", "gemini_usage_logs", "notifications", "chat_archives", "project_blocks", "leads"} {
+		for _, col := range AllTenantCollections(name) {
+			res, err := col.UpdateMany(ctx,
+				bson.M{"project_id": models.ProjectIDMatch(fromID)},
+				bson.M{"$set": bson.M{"project_id": toID}},
+			)
+			if err != nil {
+				return moved, fmt.Errorf("failed to relink %s: %v", name, err)
+			}
+			moved[name] += res.ModifiedCount
+		}
+	}
+	// Relinked conversations follow the target into its tenant database
+	if err := gatherTenantData(ctx, toID); err != nil {
+		return moved, err
+	}
+
+	// Daily rollups are unique per project and date, so days the target
+	// already has are merged into its rollup instead of moved
+	cursor, err := GetGeminiUsageDailyCollection().Find(ctx, bson.M{"project_id": fromID})
+	if err != nil {
+		return moved, fmt.Errorf("failed to list rollups: %v", err)
+	}
+	var days []models.GeminiUsageDaily
+	if err := cursor.All(ctx, &days); err != nil {
+		return moved, fmt.Errorf("failed to decode rollups: %v", err)
+	}
+	for _, day := range days {
+		_, err := GetGeminiUsageDailyCollection().UpdateOne(ctx,
+			bson.M{"_id": day.ID},
+			bson.M{"$set": bson.M{"project_id": toID}},
+		)
+		if err == nil {
+			moved["gemini_usage_daily"]++
+			continue
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return moved, fmt.Errorf("failed to relink rollup %s: %v", day.Date, err)
+		}
+
+		_, err = GetGeminiUsageDailyCollection().UpdateOne(ctx,
+			bson.M{"project_id": toID, "date": day.Date},
+			bson.M{
+				"$inc": bson.M{
+					"requests":       day.Requests,
+					"success_count":  day.SuccessCount,
+					"failed_count":   day.FailedCount,
+					"tokens_used":    day.TokensUsed,
+					"input_tokens":   day.InputTokens,
+					"output_tokens":  day.OutputTokens,
+					"estimated_cost": day.EstimatedCost,
+				},
+				"$set": bson.M{"updated_at": time.Now()},
+			},
+		)
+		if err != nil {
+			return moved, fmt.Errorf("failed to merge rollup %s: %v", day.Date, err)
+		}
+		GetGeminiUsageDailyCollection().DeleteOne(ctx, bson.M{"_id": day.ID})
+		moved["gemini_usage_daily"]++
+	}
+
+	log.Printf("🔗 Relinked orphans of %s to %s: %v", fromID.Hex(), toID.Hex(), moved)
+	return moved, nil
+}
+
+// checkOrphans runs during maintenance; it only reports unless
+// ORPHAN_AUTO_CLEAN is enabled
+func checkOrphans(ctx context.Context) {
+	report, err := DetectOrphans(ctx)
+	if err != nil {
+		log.Printf("⚠️ Orphan detection failed: %v", err)
+		return
+	}
+	if report.Total == 0 {
+		return
+	}
+
+	log.Printf("🔍 Found %d orphaned records (messages: %d, chat users: %d, usage logs: %d, files: %d)",
+		report.Total, report.Messages, report.ChatUsers, report.UsageLogs, report.Files)
+
+	if parseBool("ORPHAN_AUTO_CLEAN", false) {
+		if _, err := CleanOrphans(ctx); err != nil {
+			log.Printf("⚠️ Orphan cleanup failed: %v", err)
+		}
+	}
+}
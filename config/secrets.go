@@ -0,0 +1,93 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix marks values written by EncryptSecret, so a future key or
+// cipher change can tell the formats apart
+const encryptedPrefix = "enc:v1:"
+
+// ErrSecretsKeyMissing is returned when SECRETS_ENCRYPTION_KEY is not set
+var ErrSecretsKeyMissing = errors.New("SECRETS_ENCRYPTION_KEY is not configured")
+
+var secretsAEAD cipher.AEAD
+
+// InitSecrets loads the key used to encrypt third-party credentials at rest
+// from SECRETS_ENCRYPTION_KEY (32 bytes, hex or base64). Without it the
+// server runs, but integrations that store credentials cannot be set up.
+func InitSecrets() {
+	raw := strings.TrimSpace(os.Getenv("SECRETS_ENCRYPTION_KEY"))
+	if raw == "" {
+		log.Println("⚠️ SECRETS_ENCRYPTION_KEY not set; integrations with stored credentials are disabled")
+		return
+	}
+
+	key, err := decodeSecretsKey(raw)
+	if err != nil {
+		log.Fatalf("❌ Invalid SECRETS_ENCRYPTION_KEY: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalf("❌ Invalid SECRETS_ENCRYPTION_KEY: %v", err)
+	}
+	if secretsAEAD, err = cipher.NewGCM(block); err != nil {
+		log.Fatalf("❌ Failed to initialize secrets encryption: %v", err)
+	}
+	log.Println("🔐 Secrets encryption initialized")
+}
+
+func decodeSecretsKey(raw string) ([]byte, error) {
+	if key, err := hex.DecodeString(raw); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(raw); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("must be 32 bytes, hex or base64 encoded")
+}
+
+// EncryptSecret seals a credential with AES-256-GCM for storage
+func EncryptSecret(plaintext string) (string, error) {
+	if secretsAEAD == nil {
+		return "", ErrSecretsKeyMissing
+	}
+	nonce := make([]byte, secretsAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := secretsAEAD.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret opens a value written by EncryptSecret
+func DecryptSecret(value string) (string, error) {
+	if secretsAEAD == nil {
+		return "", ErrSecretsKeyMissing
+	}
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return "", fmt.Errorf("not an encrypted secret")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted secret: %v", err)
+	}
+	n := secretsAEAD.NonceSize()
+	if len(sealed) < n {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	plain, err := secretsAEAD.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+	return string(plain), nil
+}
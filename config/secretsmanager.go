@@ -0,0 +1,415 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// managedSecrets are the variables a secrets manager may supply. Values
+// from the manager override the environment and .env.
+var managedSecrets = []string{
+	"MONGODB_URI",
+	"GEMINI_API_KEY",
+	"SMTP_HOST",
+	"SMTP_PORT",
+	"SMTP_USERNAME",
+	"SMTP_PASSWORD",
+	"SMTP_FROM_EMAIL",
+}
+
+// secretsSource reads the managed secrets from one secrets manager. The
+// secret holds a JSON object keyed by variable name.
+type secretsSource interface {
+	name() string
+	fetch(ctx context.Context) (map[string]string, error)
+}
+
+var (
+	activeSecretsSource secretsSource
+	loadedSecrets       map[string]string
+
+	secretsHTTPClient = &http.Client{Timeout: 15 * time.Second}
+)
+
+// LoadManagedSecrets reads runtime credentials from the secrets manager
+// named by SECRETS_PROVIDER (vault, aws or gcp) into the environment, so it
+// must run before the services that read them start. SECRETS_NAME is the
+// secret: a Vault API path such as "secret/data/jevi", an AWS secret ID,
+// or a GCP name such as "projects/p/secrets/jevi". Without SECRETS_PROVIDER
+// the environment is used as is; a configured manager that cannot be read
+// stops the server.
+func LoadManagedSecrets() {
+	src, err := newSecretsSource(strings.ToLower(strings.TrimSpace(os.Getenv("SECRETS_PROVIDER"))))
+	if err != nil {
+		log.Fatalf("❌ Invalid secrets manager configuration: %v", err)
+	}
+	if src == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	values, err := src.fetch(ctx)
+	if err != nil {
+		log.Fatalf("❌ Failed to load secrets from %s: %v", src.name(), err)
+	}
+	for key, value := range values {
+		os.Setenv(key, value)
+	}
+	activeSecretsSource = src
+	loadedSecrets = values
+	log.Printf("🔐 Loaded %d secrets from %s", len(values), src.name())
+}
+
+// StartSecretsRefresh re-reads the secrets manager every
+// SECRETS_REFRESH_INTERVAL (default 15m, 0 disables) and applies rotated
+// values: MongoDB reconnects, Gemini and SMTP pick up the new credentials.
+func StartSecretsRefresh() {
+	if activeSecretsSource == nil {
+		return
+	}
+	interval := parseDuration("SECRETS_REFRESH_INTERVAL", "15m")
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := refreshSecrets(); err != nil {
+			log.Printf("⚠️ Failed to refresh secrets from %s: %v", activeSecretsSource.name(), err)
+		}
+	}
+}
+
+func refreshSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	values, err := activeSecretsSource.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed := map[string]bool{}
+	for key, value := range values {
+		if loadedSecrets[key] != value {
+			changed[key] = true
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	// A read-only deployment on its own replica URI keeps that connection
+	if changed["MONGODB_URI"] && mongoReadURI() == "" {
+		if err := reconnectMongoDB(values["MONGODB_URI"]); err != nil {
+			// Keep the working connection; the next refresh tries again
+			log.Printf("⚠️ Rotated MONGODB_URI does not connect, keeping the current connection: %v", err)
+			delete(changed, "MONGODB_URI")
+			values["MONGODB_URI"] = loadedSecrets["MONGODB_URI"]
+		}
+	}
+	for key := range changed {
+		os.Setenv(key, values[key])
+	}
+	loadedSecrets = values
+
+	if changed["GEMINI_API_KEY"] {
+		InitGemini()
+	}
+	for key := range changed {
+		if strings.HasPrefix(key, "SMTP_") {
+			InitNotificationConfig()
+			break
+		}
+	}
+
+	var names []string
+	for key := range changed {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		log.Printf("🔄 Applied rotated secrets: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// reconnectMongoDB switches to a new connection for a rotated URI once it
+// answers, and closes the old one after in-flight requests had time to end
+func reconnectMongoDB(uri string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, mongoClientOptions(uri))
+	if err != nil {
+		return err
+	}
+	if err := testConnection(ctx, client); err != nil {
+		client.Disconnect(context.Background())
+		return err
+	}
+
+	old := Client
+	Client = client
+	DB = client.Database(DB.Name())
+	log.Printf("✅ Reconnected to MongoDB with rotated credentials: %s", hideSensitiveInfo(uri))
+
+	go func() {
+		time.Sleep(time.Minute)
+		if err := old.Disconnect(context.Background()); err != nil {
+			log.Printf("⚠️ Failed to close previous MongoDB connection: %v", err)
+		}
+	}()
+	return nil
+}
+
+func newSecretsSource(provider string) (secretsSource, error) {
+	if provider == "" {
+		return nil, nil
+	}
+	secret := strings.TrimSpace(os.Getenv("SECRETS_NAME"))
+	if secret == "" {
+		return nil, fmt.Errorf("SECRETS_NAME is required with SECRETS_PROVIDER=%s", provider)
+	}
+
+	switch provider {
+	case "vault":
+		addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+		token := os.Getenv("VAULT_TOKEN")
+		if addr == "" || token == "" {
+			return nil, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN are required")
+		}
+		return &vaultSecrets{addr: addr, token: token, namespace: os.Getenv("VAULT_NAMESPACE"), path: strings.Trim(secret, "/")}, nil
+	case "aws":
+		s := &awsSecrets{
+			secretID:     secret,
+			region:       os.Getenv("AWS_REGION"),
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if s.region == "" || s.accessKey == "" || s.secretKey == "" {
+			return nil, fmt.Errorf("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+		}
+		return s, nil
+	case "gcp":
+		return &gcpSecrets{secret: strings.Trim(secret, "/")}, nil
+	}
+	return nil, fmt.Errorf("unknown SECRETS_PROVIDER %q (use vault, aws or gcp)", provider)
+}
+
+// secretValues decodes a secret's JSON object and keeps the managed keys.
+// Numbers and booleans are accepted, so SMTP_PORT may be stored as 587.
+func secretValues(raw []byte) (map[string]string, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("secret is not a JSON object: %v", err)
+	}
+	values := map[string]string{}
+	for _, key := range managedSecrets {
+		switch v := fields[key].(type) {
+		case string:
+			if v != "" {
+				values[key] = v
+			}
+		case json.Number, bool:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// doSecretsRequest sends a request and returns the body of a 2xx response
+func doSecretsRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// vaultSecrets reads a Vault KV secret over the HTTP API. KV v2 paths
+// include "data/", as in "secret/data/jevi".
+type vaultSecrets struct {
+	addr, token, namespace, path string
+}
+
+func (v *vaultSecrets) name() string { return "Vault" }
+
+func (v *vaultSecrets) fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	body, err := doSecretsRequest(secretsHTTPClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	// KV v2 wraps the fields in data.data, next to the version metadata
+	var v2 struct {
+		Data     json.RawMessage `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if json.Unmarshal(resp.Data, &v2) == nil && len(v2.Data) > 0 && len(v2.Metadata) > 0 {
+		return secretValues(v2.Data)
+	}
+	return secretValues(resp.Data)
+}
+
+// awsSecrets reads an AWS Secrets Manager secret with static credentials
+type awsSecrets struct {
+	secretID, region, accessKey, secretKey, sessionToken string
+}
+
+func (a *awsSecrets) name() string { return "AWS Secrets Manager" }
+
+func (a *awsSecrets) fetch(ctx context.Context) (map[string]string, error) {
+	payload, _ := json.Marshal(map[string]string{"SecretId": a.secretID})
+	endpoint := fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", url.PathEscape(a.region))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, payload, a.region, "secretsmanager", a.accessKey, a.secretKey, a.sessionToken, time.Now().UTC())
+
+	body, err := doSecretsRequest(secretsHTTPClient, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	return secretValues([]byte(resp.SecretString))
+}
+
+// signAWSRequest adds an AWS Signature Version 4 to a request whose path is
+// "/" and that has no query string
+func signAWSRequest(req *http.Request, payload []byte, region, service, accessKey, secretKey, sessionToken string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method, "/", "", canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// gcpSecrets reads the latest version of a GCP Secret Manager secret with
+// application default credentials
+type gcpSecrets struct {
+	secret string
+}
+
+func (g *gcpSecrets) name() string { return "GCP Secret Manager" }
+
+func (g *gcpSecrets) fetch(ctx context.Context) (map[string]string, error) {
+	ts, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, ts)
+	client.Timeout = secretsHTTPClient.Timeout
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"https://secretmanager.googleapis.com/v1/"+g.secret+"/versions/latest:access", nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := doSecretsRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return nil, err
+	}
+	return secretValues(raw)
+}
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"jevi-chat/utils"
+)
+
+// SIEMConfig controls how audit logs and security notifications are shaped
+// for SIEM ingestion and, optionally, forwarded as they are written
+type SIEMConfig struct {
+	// Forwarder is "syslog", "http" or empty to only serve the export
+	// endpoints
+	Forwarder     string
+	SyslogAddress string // udp://host:514 or tcp://host:601
+	HTTPURL       string
+	HTTPHeaders   map[string]string
+	HTTPFormat    string // ndjson or json (an array per batch)
+	Interval      time.Duration
+	BatchSize     int
+
+	// Notification types that count as security events
+	NotificationTypes []string
+
+	// FieldMap renames event fields on the way out. Dotted targets nest,
+	// so created_at=@timestamp and action=event.action both work; a target
+	// of "-" drops the field.
+	FieldMap map[string]string
+}
+
+var SIEMSettings *SIEMConfig
+
+// siemClient posts batches to the HTTP collector. Only public addresses are
+// dialled and redirects are not followed, so the collector URL cannot reach
+// into our own network.
+var siemClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:           utils.DialPublic,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// InitSIEMConfig reads the SIEM_* settings:
+//
+//	SIEM_FORWARD            syslog | http (unset: no forwarding)
+//	SIEM_SYSLOG_ADDR        udp://host:514 or tcp://host:601
+//	SIEM_HTTP_URL           collector endpoint, e.g. Splunk HEC raw or Datadog logs intake
+//	SIEM_HTTP_HEADERS       "DD-API-KEY: abc, X-Other: v"
+//	SIEM_HTTP_FORMAT        ndjson (default) or json
+//	SIEM_FORWARD_INTERVAL   default 30s
+//	SIEM_BATCH_SIZE         default 500
+//	SIEM_NOTIFICATION_TYPES default "security"
+//	SIEM_FIELD_MAP          "created_at=@timestamp,actor_id=user.id,ip_address=-"
+func InitSIEMConfig() error {
+	settings, err := LoadSIEMConfig()
+	if err != nil {
+		return err
+	}
+	SIEMSettings = settings
+	return nil
+}
+
+// LoadSIEMConfig parses and validates the SIEM_* settings without applying
+// them
+func LoadSIEMConfig() (*SIEMConfig, error) {
+	settings := &SIEMConfig{
+		Forwarder:         strings.ToLower(os.Getenv("SIEM_FORWARD")),
+		SyslogAddress:     os.Getenv("SIEM_SYSLOG_ADDR"),
+		HTTPURL:           os.Getenv("SIEM_HTTP_URL"),
+		HTTPHeaders:       map[string]string{},
+		HTTPFormat:        strings.ToLower(os.Getenv("SIEM_HTTP_FORMAT")),
+		Interval:          parseDuration("SIEM_FORWARD_INTERVAL", "30s"),
+		BatchSize:         parseInt("SIEM_BATCH_SIZE", 500),
+		NotificationTypes: []string{"security"},
+		FieldMap:          map[string]string{},
+	}
+	if settings.HTTPFormat == "" {
+		settings.HTTPFormat = "ndjson"
+	}
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = 500
+	}
+
+	if types := os.Getenv("SIEM_NOTIFICATION_TYPES"); types != "" {
+		settings.NotificationTypes = splitList(types)
+	}
+
+	for _, h := range splitList(os.Getenv("SIEM_HTTP_HEADERS")) {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("SIEM_HTTP_HEADERS: %q is not \"Name: value\"", h)
+		}
+		settings.HTTPHeaders[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	for _, m := range splitList(os.Getenv("SIEM_FIELD_MAP")) {
+		from, to, ok := strings.Cut(m, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("SIEM_FIELD_MAP: %q is not \"field=target\"", m)
+		}
+		settings.FieldMap[from] = to
+	}
+
+	switch settings.Forwarder {
+	case "":
+	case "syslog":
+		u, err := url.Parse(settings.SyslogAddress)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("SIEM_SYSLOG_ADDR must look like udp://host:514 or tcp://host:601")
+		}
+	case "http":
+		if settings.HTTPURL == "" {
+			return nil, fmt.Errorf("SIEM_HTTP_URL is required when SIEM_FORWARD=http")
+		}
+		if settings.HTTPFormat != "ndjson" && settings.HTTPFormat != "json" {
+			return nil, fmt.Errorf("SIEM_HTTP_FORMAT must be ndjson or json")
+		}
+	default:
+		return nil, fmt.Errorf("SIEM_FORWARD must be syslog or http, got %q", settings.Forwarder)
+	}
+
+	return settings, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SIEMEvent turns a stored record into the event sent to the SIEM: its JSON
+// form tagged with event_type, with the field map applied
+func SIEMEvent(eventType string, record interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	fields["event_type"] = eventType
+	fields["source"] = "jevi-chat"
+
+	if SIEMSettings == nil || len(SIEMSettings.FieldMap) == 0 {
+		return fields, nil
+	}
+
+	event := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		target, mapped := SIEMSettings.FieldMap[name]
+		if !mapped {
+			target = name
+		}
+		if target == "-" {
+			continue
+		}
+		setPath(event, target, value)
+	}
+	return event, nil
+}
+
+// setPath stores value under a dotted path, creating nested objects
+func setPath(event map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := event[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			event[part] = next
+		}
+		event = next
+	}
+	event[parts[len(parts)-1]] = value
+}
+
+// ForwardSIEMEvents delivers a batch with the configured forwarder. Either
+// the whole batch is accepted or an error is returned, so the caller can
+// retry it from the same place.
+func ForwardSIEMEvents(ctx context.Context, events []map[string]interface{}) error {
+	if SIEMSettings == nil || SIEMSettings.Forwarder == "" || len(events) == 0 {
+		return nil
+	}
+	switch SIEMSettings.Forwarder {
+	case "syslog":
+		return forwardSyslog(ctx, events)
+	default:
+		return forwardHTTP(ctx, events)
+	}
+}
+
+func forwardHTTP(ctx context.Context, events []map[string]interface{}) error {
+	var body bytes.Buffer
+	contentType := "application/x-ndjson"
+	if SIEMSettings.HTTPFormat == "json" {
+		contentType = "application/json"
+		if err := json.NewEncoder(&body).Encode(events); err != nil {
+			return err
+		}
+	} else {
+		enc := json.NewEncoder(&body)
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, SIEMSettings.HTTPURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range SIEMSettings.HTTPHeaders {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := siemClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("SIEM collector unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SIEM collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// forwardSyslog sends one RFC 5424 message per event with the JSON event as
+// the message body. Over TCP messages are octet-counted (RFC 6587).
+func forwardSyslog(ctx context.Context, events []map[string]interface{}) error {
+	u, _ := url.Parse(SIEMSettings.SyslogAddress)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, u.Scheme, u.Host)
+	if err != nil {
+		return fmt.Errorf("syslog server unreachable: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msgID, _ := e["event_type"].(string)
+		if msgID == "" {
+			msgID = "-"
+		}
+		// facility security/authorization (10), severity notice (5)
+		msg := fmt.Sprintf("<%d>1 %s %s jevi-chat - %s - %s",
+			10*8+5, time.Now().UTC().Format(time.RFC3339), host, msgID, payload)
+		if u.Scheme == "tcp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return fmt.Errorf("failed to write to syslog: %v", err)
+		}
+	}
+	return nil
+}
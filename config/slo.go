@@ -0,0 +1,257 @@
+package config
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SLO is the service level objective for one route group. A request is
+// available unless it ends in a 5xx, and fast if it finishes within
+// LatencyThreshold.
+type SLO struct {
+	Group              string        `json:"group"`
+	Description        string        `json:"description"`
+	AvailabilityTarget float64       `json:"availability_target"`
+	LatencyTarget      float64       `json:"latency_target"`
+	LatencyThreshold   time.Duration `json:"-"`
+}
+
+// SLOs covers every route group that is measured. Chat replies include the
+// deliberate 4s delay and the Gemini call, hence the looser thresholds.
+var SLOs = []SLO{
+	{Group: "chat", Description: "Widget and public chat messages", AvailabilityTarget: 0.995, LatencyTarget: 0.95, LatencyThreshold: 10 * time.Second},
+	{Group: "chat_stream", Description: "Streamed widget replies", AvailabilityTarget: 0.995, LatencyTarget: 0.95, LatencyThreshold: 30 * time.Second},
+	{Group: "widget", Description: "Widget pages, auth and sessions", AvailabilityTarget: 0.999, LatencyTarget: 0.95, LatencyThreshold: time.Second},
+	{Group: "auth", Description: "Dashboard login and registration", AvailabilityTarget: 0.999, LatencyTarget: 0.95, LatencyThreshold: time.Second},
+	{Group: "api", Description: "Dashboard API", AvailabilityTarget: 0.995, LatencyTarget: 0.95, LatencyThreshold: time.Second},
+	{Group: "admin", Description: "Admin panel", AvailabilityTarget: 0.99, LatencyTarget: 0.90, LatencyThreshold: 2 * time.Second},
+	{Group: "dashboard", Description: "User dashboard", AvailabilityTarget: 0.995, LatencyTarget: 0.95, LatencyThreshold: 2 * time.Second},
+}
+
+// RouteGroup maps a request path to its SLO group, or "" for paths that are
+// not measured (health checks, static assets)
+func RouteGroup(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/message/stream"):
+		return "chat_stream"
+	case strings.HasPrefix(path, "/embed/") && strings.HasSuffix(path, "/message"),
+		strings.HasPrefix(path, "/chat/") && strings.HasSuffix(path, "/message"):
+		return "chat"
+	case strings.HasPrefix(path, "/embed/health"):
+		return ""
+	case strings.HasPrefix(path, "/embed/"), strings.HasPrefix(path, "/chat/"):
+		return "widget"
+	case path == "/login", path == "/register", path == "/api/login", path == "/api/register":
+		return "auth"
+	case strings.HasPrefix(path, "/api/"):
+		return "api"
+	case strings.HasPrefix(path, "/admin"):
+		return "admin"
+	case strings.HasPrefix(path, "/user"), strings.HasPrefix(path, "/project/"):
+		return "dashboard"
+	}
+	return ""
+}
+
+// sloRequeueWindow is how long counts that failed to flush are retried
+const sloRequeueWindow = 24 * time.Hour
+
+type sloKey struct {
+	group  string
+	bucket time.Time
+}
+
+type sloCounts struct {
+	total, errors, slow int64
+}
+
+var (
+	sloMu      sync.Mutex
+	sloPending = map[sloKey]*sloCounts{}
+	sloLimits  = func() map[string]time.Duration {
+		m := make(map[string]time.Duration, len(SLOs))
+		for _, s := range SLOs {
+			m[s.Group] = s.LatencyThreshold
+		}
+		return m
+	}()
+)
+
+// RecordRequest counts a finished request. Counts are kept per minute in
+// memory and added to slo_metrics by the flusher, so every replica
+// contributes to the same totals.
+func RecordRequest(group string, status int, elapsed time.Duration) {
+	threshold, ok := sloLimits[group]
+	if !ok {
+		return
+	}
+	key := sloKey{group: group, bucket: time.Now().UTC().Truncate(time.Minute)}
+
+	sloMu.Lock()
+	defer sloMu.Unlock()
+	counts := sloPending[key]
+	if counts == nil {
+		counts = &sloCounts{}
+		sloPending[key] = counts
+	}
+	counts.total++
+	if status >= 500 {
+		counts.errors++
+	}
+	if elapsed > threshold {
+		counts.slow++
+	}
+}
+
+// StartSLOFlusher writes recorded counts to MongoDB every minute
+func StartSLOFlusher() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := flushSLOMetrics(); err != nil {
+			log.Printf("⚠️ Failed to flush SLO metrics: %v", err)
+		}
+	}
+}
+
+func flushSLOMetrics() error {
+	sloMu.Lock()
+	pending := sloPending
+	sloPending = map[sloKey]*sloCounts{}
+	sloMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := GetCollection("slo_metrics")
+	var firstErr error
+	failed := map[sloKey]*sloCounts{}
+	for key, counts := range pending {
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"group": key.group, "bucket": key.bucket},
+			bson.M{"$inc": bson.M{"total": counts.total, "errors": counts.errors, "slow": counts.slow}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed[key] = counts
+		}
+	}
+	requeueSLOCounts(failed)
+	return firstErr
+}
+
+// requeueSLOCounts merges counts that failed to flush back into the pending
+// ones, to be retried with the next flush. Counts older than
+// sloRequeueWindow are dropped, so a long outage cannot grow the map
+// without bound.
+func requeueSLOCounts(failed map[sloKey]*sloCounts) {
+	if len(failed) == 0 {
+		return
+	}
+	oldest := time.Now().UTC().Add(-sloRequeueWindow)
+
+	sloMu.Lock()
+	defer sloMu.Unlock()
+	for key, counts := range failed {
+		if key.bucket.Before(oldest) {
+			continue
+		}
+		pending := sloPending[key]
+		if pending == nil {
+			sloPending[key] = counts
+			continue
+		}
+		pending.total += counts.total
+		pending.errors += counts.errors
+		pending.slow += counts.slow
+	}
+}
+
+// SLOStatus is an SLO with its measured compliance. Budget figures are the
+// share of the error budget left: 1 is untouched, 0 or below is exhausted.
+type SLOStatus struct {
+	SLO
+	LatencyThresholdMs          int64   `json:"latency_threshold_ms"`
+	Total                       int64   `json:"total"`
+	Errors                      int64   `json:"errors"`
+	Slow                        int64   `json:"slow"`
+	Availability                float64 `json:"availability"`
+	LatencyCompliance           float64 `json:"latency_compliance"`
+	AvailabilityBudgetRemaining float64 `json:"availability_budget_remaining"`
+	LatencyBudgetRemaining      float64 `json:"latency_budget_remaining"`
+	Met                         bool    `json:"met"`
+}
+
+// SLOReport computes compliance for every SLO from counts recorded since
+// the given time. Groups without traffic count as met.
+func SLOReport(ctx context.Context, since time.Time) ([]SLOStatus, error) {
+	cursor, err := GetCollection("slo_metrics").Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"bucket": bson.M{"$gte": since}}},
+		bson.M{"$group": bson.M{
+			"_id":    "$group",
+			"total":  bson.M{"$sum": "$total"},
+			"errors": bson.M{"$sum": "$errors"},
+			"slow":   bson.M{"$sum": "$slow"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Group  string `bson:"_id"`
+		Total  int64  `bson:"total"`
+		Errors int64  `bson:"errors"`
+		Slow   int64  `bson:"slow"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	byGroup := make(map[string]sloCounts, len(rows))
+	for _, r := range rows {
+		byGroup[r.Group] = sloCounts{total: r.Total, errors: r.Errors, slow: r.Slow}
+	}
+
+	report := make([]SLOStatus, 0, len(SLOs))
+	for _, slo := range SLOs {
+		counts := byGroup[slo.Group]
+		status := SLOStatus{
+			SLO:                         slo,
+			LatencyThresholdMs:          slo.LatencyThreshold.Milliseconds(),
+			Total:                       counts.total,
+			Errors:                      counts.errors,
+			Slow:                        counts.slow,
+			Availability:                1,
+			LatencyCompliance:           1,
+			AvailabilityBudgetRemaining: budgetRemaining(counts.errors, counts.total, slo.AvailabilityTarget),
+			LatencyBudgetRemaining:      budgetRemaining(counts.slow, counts.total, slo.LatencyTarget),
+		}
+		if counts.total > 0 {
+			status.Availability = 1 - float64(counts.errors)/float64(counts.total)
+			status.LatencyCompliance = 1 - float64(counts.slow)/float64(counts.total)
+		}
+		status.Met = status.Availability >= slo.AvailabilityTarget && status.LatencyCompliance >= slo.LatencyTarget
+		report = append(report, status)
+	}
+	return report, nil
+}
+
+// budgetRemaining is the share of allowed bad requests not yet used
+func budgetRemaining(bad, total int64, target float64) float64 {
+	allowed := (1 - target) * float64(total)
+	if allowed <= 0 {
+		if bad == 0 {
+			return 1
+		}
+		return 0
+	}
+	return 1 - float64(bad)/allowed
+}
@@ -0,0 +1,358 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ObjectStorage is a minimal blob store used for archives and uploaded files.
+// Keys are slash-separated paths relative to the storage root.
+type ObjectStorage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose keys start with prefix
+	List(ctx context.Context, prefix string) ([]StoredObject, error)
+	Driver() string
+}
+
+// StoredObject is an entry returned by ObjectStorage.List
+type StoredObject struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+var Storage ObjectStorage
+
+// InitStorage selects the storage backend from STORAGE_DRIVER ("local",
+// "gridfs" or "s3"). Only S3 and GridFS are shared between instances and
+// survive a redeploy on hosts without a persistent disk. GridFS needs the
+// database to be connected first.
+func InitStorage() {
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+	if driver == "" {
+		driver = "local"
+	}
+
+	switch driver {
+	case "s3":
+		s, err := newS3Storage()
+		if err != nil {
+			log.Fatalf("❌ Failed to initialize S3 storage: %v", err)
+		}
+		Storage = s
+	case "gridfs":
+		bucket := os.Getenv("GRIDFS_BUCKET")
+		if bucket == "" {
+			bucket = "storage"
+		}
+		Storage = &gridFSStorage{bucket: bucket}
+	default:
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "./data"
+		}
+		Storage = &localStorage{root: dir}
+	}
+
+	log.Printf("🗄️ Object storage initialized (driver: %s)", Storage.Driver())
+}
+
+// ===== LOCAL DISK =====
+
+type localStorage struct {
+	root string
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid storage key: %q", key)
+	}
+	return filepath.Join(s.root, clean), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temp file first so readers never see a partial object
+	tmp := p + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	// Only the directory holding the prefix needs walking
+	start := filepath.Join(s.root, filepath.FromSlash(prefix[:strings.LastIndex(prefix, "/")+1]))
+	var objects []StoredObject
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, StoredObject{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (s *localStorage) Driver() string {
+	return "local"
+}
+
+// ===== S3-COMPATIBLE =====
+
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Storage() (*s3Storage, error) {
+	return newS3StorageFromEnv("S3_")
+}
+
+// newS3StorageFromEnv reads <env>ENDPOINT, <env>BUCKET, <env>ACCESS_KEY,
+// <env>SECRET_KEY, <env>USE_SSL, <env>REGION and <env>PREFIX
+func newS3StorageFromEnv(env string) (*s3Storage, error) {
+	endpoint := os.Getenv(env + "ENDPOINT")
+	bucket := os.Getenv(env + "BUCKET")
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("%sENDPOINT and %sBUCKET are required", env, env)
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv(env+"ACCESS_KEY"), os.Getenv(env+"SECRET_KEY"), ""),
+		Secure: parseBool(env+"USE_SSL", true),
+		Region: os.Getenv(env + "REGION"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Storage{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(os.Getenv(env+"PREFIX"), "/"),
+	}, nil
+}
+
+func (s *s3Storage) key(key string) string {
+	key = strings.TrimLeft(key, "/")
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(key), r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat surfaces a missing key before the caller reads
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.key(key), minio.RemoveObjectOptions{})
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.key(prefix), Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		key := obj.Key
+		if s.prefix != "" {
+			key = strings.TrimPrefix(key, s.prefix+"/")
+		}
+		objects = append(objects, StoredObject{Key: key, Size: obj.Size, Modified: obj.LastModified})
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Driver() string {
+	return "s3"
+}
+
+// ===== GRIDFS =====
+
+// gridFSStorage keeps objects in a GridFS bucket of the main database,
+// named by their key
+type gridFSStorage struct {
+	bucket string
+}
+
+// open returns the bucket with the context's deadline, if any; GridFS
+// takes deadlines rather than contexts
+func (s *gridFSStorage) open(ctx context.Context) (*gridfs.Bucket, error) {
+	b, err := gridfs.NewBucket(DB, options.GridFSBucket().SetName(s.bucket))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		b.SetWriteDeadline(deadline)
+		b.SetReadDeadline(deadline)
+	}
+	return b, nil
+}
+
+func (s *gridFSStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	b, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	key = strings.TrimLeft(key, "/")
+	id, err := b.UploadFromStream(key, r, options.GridFSUpload().SetMetadata(bson.M{"content_type": contentType}))
+	if err != nil {
+		return err
+	}
+	// Writing a key again replaces it: older revisions are dropped
+	return s.deleteFiles(ctx, b, bson.M{"filename": key, "_id": bson.M{"$ne": id}})
+}
+
+func (s *gridFSStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	b, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return b.OpenDownloadStreamByName(strings.TrimLeft(key, "/"))
+}
+
+func (s *gridFSStorage) Delete(ctx context.Context, key string) error {
+	b, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	return s.deleteFiles(ctx, b, bson.M{"filename": strings.TrimLeft(key, "/")})
+}
+
+func (s *gridFSStorage) deleteFiles(ctx context.Context, b *gridfs.Bucket, filter bson.M) error {
+	cursor, err := b.FindContext(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var files []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := b.DeleteContext(ctx, f.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *gridFSStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	b, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := b.FindContext(ctx, bson.M{"filename": bson.M{"$regex": "^" + regexp.QuoteMeta(strings.TrimLeft(prefix, "/"))}})
+	if err != nil {
+		return nil, err
+	}
+	var files []struct {
+		Filename   string    `bson:"filename"`
+		Length     int64     `bson:"length"`
+		UploadDate time.Time `bson:"uploadDate"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	objects := make([]StoredObject, 0, len(files))
+	for _, f := range files {
+		objects = append(objects, StoredObject{Key: f.Filename, Size: f.Length, Modified: f.UploadDate})
+	}
+	return objects, nil
+}
+
+func (s *gridFSStorage) Driver() string {
+	return "gridfs"
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TenantStorageStats is an estimate of how much storage one project consumes
+type TenantStorageStats struct {
+	ProjectID       string  `json:"project_id"`
+	ProjectName     string  `json:"project_name"`
+	Database        string  `json:"database"`
+	MessageCount    int64   `json:"message_count"`
+	MessageBytes    int64   `json:"message_bytes"`
+	UsageLogCount   int64   `json:"usage_log_count"`
+	UsageLogBytes   int64   `json:"usage_log_bytes"`
+	ChatUserCount   int64   `json:"chat_user_count"`
+	ChatUserBytes   int64   `json:"chat_user_bytes"`
+	ProjectBytes    int64   `json:"project_bytes"`
+	FileBytes       int64   `json:"file_bytes"`
+	ArchiveBytes    int64   `json:"archive_bytes"`
+	TotalBytes      int64   `json:"total_bytes"`
+	QuotaBytes      int64   `json:"quota_bytes"`
+	QuotaPercentage float64 `json:"quota_percentage"`
+}
+
+type sizeRow struct {
+	ID    interface{} `bson:"_id"`
+	Count int64       `bson:"count"`
+	Bytes int64       `bson:"bytes"`
+}
+
+// sumSizeByProject groups a collection by project_id, returning document
+// counts and BSON byte sizes ($bsonSize requires MongoDB 4.4+)
+func sumSizeByProject(ctx context.Context, col *mongo.Collection) (map[string]sizeRow, error) {
+	cursor, err := col.Aggregate(ctx, []bson.M{
+		{"$group": bson.M{
+			"_id":   "$project_id",
+			"count": bson.M{"$sum": 1},
+			"bytes": bson.M{"$sum": bson.M{"$bsonSize": "$$ROOT"}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []sizeRow
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]sizeRow, len(rows))
+	for _, row := range rows {
+		// Rows not yet backfilled still carry a hex string project_id
+		switch id := row.ID.(type) {
+		case primitive.ObjectID:
+			out[id.Hex()] = row
+		case string:
+			out[id] = row
+		}
+	}
+	return out, nil
+}
+
+// GetTenantStorageStats estimates per-project storage across all collections,
+// uploaded files and archives, sorted by total size (largest first)
+func GetTenantStorageStats(ctx context.Context) ([]TenantStorageStats, error) {
+	defaultQuota := int64(parseInt("TENANT_STORAGE_QUOTA_MB", 500)) * 1024 * 1024
+
+	cursor, err := GetProjectsCollection().Aggregate(ctx, []bson.M{
+		{"$project": bson.M{
+			"name":             1,
+			"storage_quota_mb": 1,
+			"database":         1,
+			"doc_bytes":        bson.M{"$bsonSize": "$$ROOT"},
+			"file_bytes":       bson.M{"$sum": bson.M{"$ifNull": bson.A{"$pdf_files.file_size", bson.A{}}}},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to size projects: %v", err)
+	}
+	var projects []struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Name      string             `bson:"name"`
+		QuotaMB   int64              `bson:"storage_quota_mb"`
+		Database  string             `bson:"database"`
+		DocBytes  int64              `bson:"doc_bytes"`
+		FileBytes int64              `bson:"file_bytes"`
+	}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, fmt.Errorf("failed to decode project sizes: %v", err)
+	}
+
+	// A project's messages may sit in a dedicated tenant database
+	messages := make(map[string]sizeRow)
+	for _, col := range AllTenantCollections("chat_messages") {
+		rows, err := sumSizeByProject(ctx, col)
+		if err != nil {
+			return nil, fmt.Errorf("failed to size chat_messages in %s: %v", col.Database().Name(), err)
+		}
+		for id, row := range rows {
+			sum := messages[id]
+			sum.Count += row.Count
+			sum.Bytes += row.Bytes
+			messages[id] = sum
+		}
+	}
+	usageLogs, err := sumSizeByProject(ctx, GetGeminiUsageLogsCollection())
+	if err != nil {
+		return nil, fmt.Errorf("failed to size gemini_usage_logs: %v", err)
+	}
+	chatUsers, err := sumSizeByProject(ctx, GetChatUsersCollection())
+	if err != nil {
+		return nil, fmt.Errorf("failed to size chat_users: %v", err)
+	}
+
+	archiveCursor, err := GetChatArchivesCollection().Aggregate(ctx, []bson.M{
+		{"$group": bson.M{"_id": "$project_id", "count": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": "$size_bytes"}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to size chat_archives: %v", err)
+	}
+	var archiveRows []sizeRow
+	if err := archiveCursor.All(ctx, &archiveRows); err != nil {
+		return nil, fmt.Errorf("failed to decode archive sizes: %v", err)
+	}
+	archives := make(map[string]int64, len(archiveRows))
+	for _, row := range archiveRows {
+		if id, ok := row.ID.(primitive.ObjectID); ok {
+			archives[id.Hex()] = row.Bytes
+		}
+	}
+
+	stats := make([]TenantStorageStats, 0, len(projects))
+	for _, p := range projects {
+		id := p.ID.Hex()
+		s := TenantStorageStats{
+			ProjectID:     id,
+			ProjectName:   p.Name,
+			Database:      p.Database,
+			MessageCount:  messages[id].Count,
+			MessageBytes:  messages[id].Bytes,
+			UsageLogCount: usageLogs[id].Count,
+			UsageLogBytes: usageLogs[id].Bytes,
+			ChatUserCount: chatUsers[id].Count,
+			ChatUserBytes: chatUsers[id].Bytes,
+			ProjectBytes:  p.DocBytes,
+			FileBytes:     p.FileBytes,
+			ArchiveBytes:  archives[id],
+			QuotaBytes:    defaultQuota,
+		}
+		if p.QuotaMB > 0 {
+			s.QuotaBytes = p.QuotaMB * 1024 * 1024
+		}
+		s.TotalBytes = s.MessageBytes + s.UsageLogBytes + s.ChatUserBytes + s.ProjectBytes + s.FileBytes + s.ArchiveBytes
+		if s.QuotaBytes > 0 {
+			s.QuotaPercentage = float64(s.TotalBytes) / float64(s.QuotaBytes) * 100
+		}
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].TotalBytes > stats[j].TotalBytes })
+	return stats, nil
+}
@@ -0,0 +1,363 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/models"
+)
+
+// tenantCollections can live in a project's dedicated database on the same
+// cluster, so one busy tenant's conversations and their indexes do not slow
+// everyone else down. On a sharded cluster each database can also be given
+// its own primary shard. All other collections stay in the main database.
+var tenantCollections = []string{"chat_messages", "chat_sessions"}
+
+var tenantDatabasePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,63}$`)
+
+const tenantCopyBatchSize = 1000
+
+var (
+	tenantRoutesMu sync.RWMutex
+	// tenantRoutes maps projects with a dedicated database to its name
+	tenantRoutes = map[primitive.ObjectID]string{}
+)
+
+func isTenantCollection(name string) bool {
+	for _, c := range tenantCollections {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantCollection returns a project's collection, from the project's
+// dedicated database if it has one
+func TenantCollection(projectID primitive.ObjectID, name string) *mongo.Collection {
+	db := tenantDatabase(projectID)
+	if db == DB || !isTenantCollection(name) {
+		return GetCollection(name)
+	}
+	if broken := chaosCollection(name); broken != nil {
+		return broken
+	}
+	return db.Collection(name)
+}
+
+// tenantDatabase returns the database holding a project's routed collections
+func tenantDatabase(projectID primitive.ObjectID) *mongo.Database {
+	tenantRoutesMu.RLock()
+	database := tenantRoutes[projectID]
+	tenantRoutesMu.RUnlock()
+	if database == "" {
+		return DB
+	}
+	return Client.Database(database)
+}
+
+func GetChatMessagesCollectionFor(projectID primitive.ObjectID) *mongo.Collection {
+	return TenantCollection(projectID, "chat_messages")
+}
+
+func GetChatSessionsCollectionFor(projectID primitive.ObjectID) *mongo.Collection {
+	return TenantCollection(projectID, "chat_sessions")
+}
+
+// TenantDatabases returns the main database followed by every dedicated
+// tenant database, for work that spans all projects
+func TenantDatabases() []*mongo.Database {
+	tenantRoutesMu.RLock()
+	seen := map[string]bool{}
+	for _, name := range tenantRoutes {
+		seen[name] = true
+	}
+	tenantRoutesMu.RUnlock()
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dbs := []*mongo.Database{DB}
+	for _, name := range names {
+		dbs = append(dbs, Client.Database(name))
+	}
+	return dbs
+}
+
+// AllTenantCollections returns a collection from the main database and, for
+// routed collections, from every dedicated tenant database as well
+func AllTenantCollections(name string) []*mongo.Collection {
+	if !isTenantCollection(name) {
+		return []*mongo.Collection{GetCollection(name)}
+	}
+	var cols []*mongo.Collection
+	for _, db := range TenantDatabases() {
+		cols = append(cols, db.Collection(name))
+	}
+	return cols
+}
+
+// CountTenantDocuments counts matching documents of a collection across all
+// tenant databases
+func CountTenantDocuments(ctx context.Context, name string, filter interface{}) (int64, error) {
+	var total int64
+	for _, col := range AllTenantCollections(name) {
+		n, err := col.CountDocuments(ctx, filter)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// distinctAcrossTenants returns the distinct values of a field in a
+// collection across all tenant databases
+func distinctAcrossTenants(ctx context.Context, name, field string, filter interface{}) ([]interface{}, error) {
+	var values []interface{}
+	seen := map[interface{}]bool{}
+	for _, col := range AllTenantCollections(name) {
+		found, err := col.Distinct(ctx, field, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range found {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return values, nil
+}
+
+// LoadTenantRoutes reads which projects have a dedicated database
+func LoadTenantRoutes(ctx context.Context) error {
+	cursor, err := GetProjectsCollection().Find(ctx,
+		bson.M{"database": bson.M{"$exists": true, "$ne": ""}},
+		options.Find().SetProjection(bson.M{"database": 1}))
+	if err != nil {
+		return err
+	}
+	var projects []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		Database string             `bson:"database"`
+	}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return err
+	}
+
+	routes := make(map[primitive.ObjectID]string, len(projects))
+	for _, p := range projects {
+		routes[p.ID] = p.Database
+	}
+	tenantRoutesMu.Lock()
+	tenantRoutes = routes
+	tenantRoutesMu.Unlock()
+	return nil
+}
+
+// TenantRoutesRefresh is how often every instance reloads tenant routes
+// (TENANT_ROUTES_REFRESH, default 1m)
+func TenantRoutesRefresh() time.Duration {
+	return parseDuration("TENANT_ROUTES_REFRESH", "1m")
+}
+
+// StartTenantRoutesRefresh keeps the routes current, so all instances follow
+// a tenant that was moved to another database
+func StartTenantRoutesRefresh() {
+	interval := TenantRoutesRefresh()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := LoadTenantRoutes(ctx); err != nil {
+			log.Printf("⚠️ Failed to reload tenant routes: %v", err)
+		}
+		cancel()
+	}
+}
+
+// ensureTenantIndexes creates the routed collections' indexes in a database
+func ensureTenantIndexes(ctx context.Context, db *mongo.Database) error {
+	for _, col := range expectedIndexes {
+		if !isTenantCollection(col.Collection) {
+			continue
+		}
+		models := make([]mongo.IndexModel, 0, len(col.Indexes))
+		for _, spec := range col.Indexes {
+			models = append(models, spec.model())
+		}
+		if _, err := db.Collection(col.Collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("failed to create %s indexes in %s: %v", col.Collection, db.Name(), err)
+		}
+	}
+	return nil
+}
+
+// MoveTenant moves a project's conversations to the named database, or back
+// to the main database when database is empty or the main database's name.
+//
+// The data is copied, the route switched, and after settle (long enough for
+// every instance to reload its routes) a second pass copies what was still
+// written to the old database before it is cleared there. Run it when the
+// project is quiet: session updates that land in the old database during
+// the settle window are not carried over.
+func MoveTenant(ctx context.Context, projectID primitive.ObjectID, database string, settle time.Duration) (map[string]int64, error) {
+	if database == DB.Name() {
+		database = ""
+	}
+	if database != "" {
+		if !tenantDatabasePattern.MatchString(database) {
+			return nil, fmt.Errorf("invalid database name %q", database)
+		}
+		switch database {
+		case "admin", "local", "config":
+			return nil, fmt.Errorf("database %q is reserved", database)
+		}
+	}
+
+	var project struct {
+		Database string `bson:"database"`
+	}
+	if err := GetProjectsCollection().FindOne(ctx, bson.M{"_id": projectID}).Decode(&project); err != nil {
+		return nil, fmt.Errorf("project not found: %v", err)
+	}
+	if project.Database == database {
+		return nil, fmt.Errorf("project %s is already in that database", projectID.Hex())
+	}
+
+	from, to := DB, DB
+	if project.Database != "" {
+		from = Client.Database(project.Database)
+	}
+	if database != "" {
+		to = Client.Database(database)
+		if err := ensureTenantIndexes(ctx, to); err != nil {
+			return nil, err
+		}
+	}
+
+	copied := make(map[string]int64)
+	if err := copyTenantData(ctx, projectID, from, to, copied); err != nil {
+		return copied, err
+	}
+
+	update := bson.M{"$set": bson.M{"database": database, "updated_at": time.Now()}}
+	if database == "" {
+		update = bson.M{"$unset": bson.M{"database": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	if _, err := GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": projectID}, update); err != nil {
+		return copied, fmt.Errorf("failed to switch route: %v", err)
+	}
+	if err := LoadTenantRoutes(ctx); err != nil {
+		return copied, fmt.Errorf("failed to reload routes: %v", err)
+	}
+	log.Printf("🔀 Routed project %s to %s, waiting %s for other instances", projectID.Hex(), to.Name(), settle)
+
+	select {
+	case <-time.After(settle):
+	case <-ctx.Done():
+		return copied, ctx.Err()
+	}
+
+	if err := copyTenantData(ctx, projectID, from, to, copied); err != nil {
+		return copied, err
+	}
+	filter := bson.M{"project_id": models.ProjectIDMatch(projectID)}
+	for _, name := range tenantCollections {
+		if _, err := from.Collection(name).DeleteMany(ctx, filter); err != nil {
+			return copied, fmt.Errorf("failed to clear %s in %s: %v", name, from.Name(), err)
+		}
+	}
+
+	log.Printf("✅ Moved project %s from %s to %s: %v", projectID.Hex(), from.Name(), to.Name(), copied)
+	return copied, nil
+}
+
+// copyTenantData copies a project's routed documents between databases,
+// skipping documents the target already has, and adds the number copied
+// per collection to copied
+func copyTenantData(ctx context.Context, projectID primitive.ObjectID, from, to *mongo.Database, copied map[string]int64) error {
+	filter := bson.M{"project_id": models.ProjectIDMatch(projectID)}
+	for _, name := range tenantCollections {
+		cursor, err := from.Collection(name).Find(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		batch := make([]interface{}, 0, tenantCopyBatchSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			n := int64(len(batch))
+			_, err := to.Collection(name).InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+			if err != nil {
+				bwe, ok := err.(mongo.BulkWriteException)
+				if !ok || !mongo.IsDuplicateKeyError(err) {
+					return fmt.Errorf("failed to copy %s: %v", name, err)
+				}
+				n -= int64(len(bwe.WriteErrors))
+			}
+			copied[name] += n
+			batch = batch[:0]
+			return nil
+		}
+
+		for cursor.Next(ctx) {
+			batch = append(batch, bson.Raw(append([]byte(nil), cursor.Current...)))
+			if len(batch) == tenantCopyBatchSize {
+				if err := flush(); err != nil {
+					cursor.Close(ctx)
+					return err
+				}
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gatherTenantData moves whatever a project has in the other tenant
+// databases into the one it is routed to, such as relinked orphans
+func gatherTenantData(ctx context.Context, projectID primitive.ObjectID) error {
+	target := tenantDatabase(projectID)
+	filter := bson.M{"project_id": models.ProjectIDMatch(projectID)}
+	for _, db := range TenantDatabases() {
+		if db.Name() == target.Name() {
+			continue
+		}
+		if err := copyTenantData(ctx, projectID, db, target, map[string]int64{}); err != nil {
+			return err
+		}
+		for _, name := range tenantCollections {
+			if _, err := db.Collection(name).DeleteMany(ctx, filter); err != nil {
+				return fmt.Errorf("failed to clear %s in %s: %v", name, db.Name(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// WarehouseConfig controls the daily aggregate export to a customer's data
+// warehouse or data lake
+type WarehouseConfig struct {
+	// Driver is "bigquery", "s3" or empty to not export
+	Driver   string
+	Interval time.Duration
+	// BackfillDays is how far back the first export of a table starts
+	BackfillDays int
+
+	BigQueryProject     string
+	BigQueryDataset     string
+	BigQueryLocation    string
+	BigQueryCredentials string // service account JSON; unset uses the default credentials
+}
+
+var WarehouseSettings *WarehouseConfig
+
+// WarehouseColumn is a column of an exported table. Type is a BigQuery
+// type: STRING, INTEGER, FLOAT, BOOLEAN, DATE or TIMESTAMP.
+type WarehouseColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// WarehouseTable is an exported table, partitioned by its date column.
+// Columns are only ever added; Version goes up whenever they change.
+type WarehouseTable struct {
+	Name    string            `json:"table"`
+	Version int               `json:"version"`
+	Columns []WarehouseColumn `json:"columns"`
+}
+
+// InitWarehouseConfig reads the WAREHOUSE_* settings:
+//
+//	WAREHOUSE_DRIVER                bigquery | s3 (unset: no export)
+//	WAREHOUSE_INTERVAL              default 1h
+//	WAREHOUSE_BACKFILL_DAYS         default 30
+//	WAREHOUSE_BIGQUERY_PROJECT      GCP project of the dataset
+//	WAREHOUSE_BIGQUERY_DATASET      dataset the tables are created in
+//	WAREHOUSE_BIGQUERY_LOCATION     dataset location, e.g. US or EU (optional)
+//	WAREHOUSE_BIGQUERY_CREDENTIALS  service account JSON (optional)
+//	WAREHOUSE_S3_ENDPOINT, _BUCKET, _ACCESS_KEY, _SECRET_KEY, _USE_SSL,
+//	_REGION, _PREFIX                the data lake bucket, as for S3_*
+func InitWarehouseConfig() error {
+	settings, err := LoadWarehouseConfig()
+	if err != nil {
+		return err
+	}
+	WarehouseSettings = settings
+	return nil
+}
+
+// LoadWarehouseConfig parses and validates the WAREHOUSE_* settings without
+// applying them
+func LoadWarehouseConfig() (*WarehouseConfig, error) {
+	settings := &WarehouseConfig{
+		Driver:              strings.ToLower(os.Getenv("WAREHOUSE_DRIVER")),
+		Interval:            parseDuration("WAREHOUSE_INTERVAL", "1h"),
+		BackfillDays:        parseInt("WAREHOUSE_BACKFILL_DAYS", 30),
+		BigQueryProject:     os.Getenv("WAREHOUSE_BIGQUERY_PROJECT"),
+		BigQueryDataset:     os.Getenv("WAREHOUSE_BIGQUERY_DATASET"),
+		BigQueryLocation:    os.Getenv("WAREHOUSE_BIGQUERY_LOCATION"),
+		BigQueryCredentials: os.Getenv("WAREHOUSE_BIGQUERY_CREDENTIALS"),
+	}
+	if settings.BackfillDays < 1 {
+		settings.BackfillDays = 1
+	}
+
+	switch settings.Driver {
+	case "":
+	case "bigquery":
+		if settings.BigQueryProject == "" || settings.BigQueryDataset == "" {
+			return nil, fmt.Errorf("WAREHOUSE_BIGQUERY_PROJECT and WAREHOUSE_BIGQUERY_DATASET are required when WAREHOUSE_DRIVER=bigquery")
+		}
+	case "s3":
+		if os.Getenv("WAREHOUSE_S3_ENDPOINT") == "" || os.Getenv("WAREHOUSE_S3_BUCKET") == "" {
+			return nil, fmt.Errorf("WAREHOUSE_S3_ENDPOINT and WAREHOUSE_S3_BUCKET are required when WAREHOUSE_DRIVER=s3")
+		}
+	default:
+		return nil, fmt.Errorf("WAREHOUSE_DRIVER must be bigquery or s3, got %q", settings.Driver)
+	}
+	return settings, nil
+}
+
+// warehouseSink is where exported tables are written
+type warehouseSink interface {
+	// EnsureTable creates the table or adds the columns it is missing
+	EnsureTable(ctx context.Context, table WarehouseTable) error
+	// WritePartition replaces the table's rows for day
+	WritePartition(ctx context.Context, table WarehouseTable, day string, rows []map[string]interface{}) error
+}
+
+var (
+	sinkMu sync.Mutex
+	sink   warehouseSink
+)
+
+func warehouse(ctx context.Context) (warehouseSink, error) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	if sink != nil {
+		return sink, nil
+	}
+	if WarehouseSettings == nil || WarehouseSettings.Driver == "" {
+		return nil, errors.New("no warehouse is configured")
+	}
+
+	switch WarehouseSettings.Driver {
+	case "bigquery":
+		opts := []option.ClientOption{option.WithScopes(bigquery.BigqueryScope)}
+		if WarehouseSettings.BigQueryCredentials != "" {
+			opts = append(opts, option.WithCredentialsJSON([]byte(WarehouseSettings.BigQueryCredentials)))
+		}
+		svc, err := bigquery.NewService(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		sink = &bigQuerySink{svc: svc, project: WarehouseSettings.BigQueryProject, dataset: WarehouseSettings.BigQueryDataset, location: WarehouseSettings.BigQueryLocation}
+	default:
+		lake, err := newS3StorageFromEnv("WAREHOUSE_S3_")
+		if err != nil {
+			return nil, err
+		}
+		sink = &lakeSink{store: lake}
+	}
+	return sink, nil
+}
+
+// EnsureWarehouseTable brings the table's schema in the warehouse up to date
+func EnsureWarehouseTable(ctx context.Context, table WarehouseTable) error {
+	s, err := warehouse(ctx)
+	if err != nil {
+		return err
+	}
+	return s.EnsureTable(ctx, table)
+}
+
+// WriteWarehousePartition replaces the table's rows for day (YYYY-MM-DD),
+// so exporting a day again never duplicates it
+func WriteWarehousePartition(ctx context.Context, table WarehouseTable, day string, rows []map[string]interface{}) error {
+	s, err := warehouse(ctx)
+	if err != nil {
+		return err
+	}
+	return s.WritePartition(ctx, table, day, rows)
+}
+
+func encodeNDJSON(rows []map[string]interface{}) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return nil, err
+		}
+	}
+	return &buf, nil
+}
+
+// ===== BIGQUERY =====
+
+// bigQuerySink loads each day into its partition of a date-partitioned table
+type bigQuerySink struct {
+	svc      *bigquery.Service
+	project  string
+	dataset  string
+	location string
+}
+
+func (b *bigQuerySink) schema(table WarehouseTable) *bigquery.TableSchema {
+	fields := make([]*bigquery.TableFieldSchema, len(table.Columns))
+	for i, col := range table.Columns {
+		fields[i] = &bigquery.TableFieldSchema{Name: col.Name, Type: col.Type, Mode: "NULLABLE"}
+	}
+	return &bigquery.TableSchema{Fields: fields}
+}
+
+func (b *bigQuerySink) EnsureTable(ctx context.Context, table WarehouseTable) error {
+	existing, err := b.svc.Tables.Get(b.project, b.dataset, table.Name).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		_, err = b.svc.Tables.Insert(b.project, b.dataset, &bigquery.Table{
+			TableReference:   &bigquery.TableReference{ProjectId: b.project, DatasetId: b.dataset, TableId: table.Name},
+			Schema:           b.schema(table),
+			TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: "date"},
+			Labels:           map[string]string{"source": "jevi-chat", "schema_version": fmt.Sprint(table.Version)},
+		}).Context(ctx).Do()
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	have := map[string]string{}
+	if existing.Schema != nil {
+		for _, f := range existing.Schema.Fields {
+			have[f.Name] = f.Type
+		}
+	}
+	missing := false
+	for _, col := range table.Columns {
+		typ, ok := have[col.Name]
+		if !ok {
+			missing = true
+			continue
+		}
+		if typ != col.Type {
+			return fmt.Errorf("column %s.%s is %s in BigQuery but exported as %s", table.Name, col.Name, typ, col.Type)
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	// Keep columns that are only in BigQuery: patching replaces the schema
+	schema := existing.Schema
+	if schema == nil {
+		schema = &bigquery.TableSchema{}
+	}
+	for _, col := range table.Columns {
+		if _, ok := have[col.Name]; !ok {
+			schema.Fields = append(schema.Fields, &bigquery.TableFieldSchema{Name: col.Name, Type: col.Type, Mode: "NULLABLE"})
+		}
+	}
+	labels := existing.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels["schema_version"] = fmt.Sprint(table.Version)
+	_, err = b.svc.Tables.Patch(b.project, b.dataset, table.Name, &bigquery.Table{Schema: schema, Labels: labels}).Context(ctx).Do()
+	return err
+}
+
+func (b *bigQuerySink) WritePartition(ctx context.Context, table WarehouseTable, day string, rows []map[string]interface{}) error {
+	body, err := encodeNDJSON(rows)
+	if err != nil {
+		return err
+	}
+
+	job, err := b.svc.Jobs.Insert(b.project, &bigquery.Job{
+		JobReference: &bigquery.JobReference{ProjectId: b.project, Location: b.location},
+		Configuration: &bigquery.JobConfiguration{Load: &bigquery.JobConfigurationLoad{
+			DestinationTable: &bigquery.TableReference{
+				ProjectId: b.project,
+				DatasetId: b.dataset,
+				TableId:   table.Name + "$" + strings.ReplaceAll(day, "-", ""),
+			},
+			Schema:           b.schema(table),
+			SourceFormat:     "NEWLINE_DELIMITED_JSON",
+			WriteDisposition: "WRITE_TRUNCATE",
+		}},
+	}).Media(body, googleapi.ContentType("application/octet-stream")).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	// Load jobs run asynchronously; the partition is only written once done
+	for job.Status == nil || job.Status.State != "DONE" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+		job, err = b.svc.Jobs.Get(b.project, job.JobReference.JobId).Location(job.JobReference.Location).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+	}
+	if job.Status.ErrorResult != nil {
+		return fmt.Errorf("BigQuery load of %s for %s failed: %s", table.Name, day, job.Status.ErrorResult.Message)
+	}
+	return nil
+}
+
+// ===== S3 DATA LAKE =====
+
+// lakeSink writes Hive-style partitions of gzip-compressed NDJSON:
+// <table>/date=YYYY-MM-DD/part-0.ndjson.gz, with the table's current schema
+// in <table>/_schema.json and every version kept under <table>/_schema/
+type lakeSink struct {
+	store *s3Storage
+}
+
+func (l *lakeSink) EnsureTable(ctx context.Context, table WarehouseTable) error {
+	schema, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{
+		fmt.Sprintf("%s/_schema/v%d.json", table.Name, table.Version),
+		table.Name + "/_schema.json",
+	} {
+		if err := l.store.Put(ctx, key, bytes.NewReader(schema), int64(len(schema)), "application/json"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *lakeSink) WritePartition(ctx context.Context, table WarehouseTable, day string, rows []map[string]interface{}) error {
+	body, err := encodeNDJSON(rows)
+	if err != nil {
+		return err
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(body.Bytes()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/date=%s/part-0.ndjson.gz", table.Name, day)
+	return l.store.Put(ctx, key, &gz, int64(gz.Len()), "application/x-ndjson")
+}
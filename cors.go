@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"jevi-chat/handlers"
+	"jevi-chat/middleware"
+)
+
+// defaultDashboardOrigins are used when DASHBOARD_ORIGINS is unset
+var defaultDashboardOrigins = []string{
+	"https://troikafrontend.onrender.com",
+	"http://localhost:3000",
+	"http://127.0.0.1:3000",
+	"http://localhost:3001",
+	"http://127.0.0.1:3001",
+	"http://localhost:8081",
+}
+
+// embedPrefixes are the routes the widget calls from customer sites
+var embedPrefixes = []string{
+	"/embed",
+	"/chat",
+	"/widget.js",
+	"/widget.css",
+	"/static",
+	"/health",
+	"/cors-test",
+	"/api/rate-limit/status",
+}
+
+// CORS policies per route group:
+//
+//   - Widget routes (embedPrefixes) are called from any customer site, so
+//     they accept every origin, or only EMBED_ALLOWED_ORIGINS when set.
+//     They never carry cookies.
+//   - Everything else serves the dashboard and only accepts its origins:
+//     DASHBOARD_ORIGINS (default: the hosted dashboard and local dev
+//     servers) plus CORS_ALLOWED_ORIGINS, with credentials.
+//
+// Both variables are comma-separated.
+func corsMiddlewareConfig() ([]middleware.CORSGroup, cors.Config) {
+	methods := []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "HEAD"}
+	headers := []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "X-CSRF-Token", "Cache-Control"}
+	exposed := []string{"Content-Length", "Content-Type", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"}
+
+	dashboardOrigins := splitOrigins(os.Getenv("DASHBOARD_ORIGINS"))
+	if len(dashboardOrigins) == 0 {
+		dashboardOrigins = defaultDashboardOrigins
+	}
+	dashboardOrigins = append(dashboardOrigins, splitOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))...)
+
+	dashboard := cors.Config{
+		AllowOrigins:     dashboardOrigins,
+		AllowMethods:     methods,
+		AllowHeaders:     headers,
+		ExposeHeaders:    exposed,
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}
+
+	embed := cors.Config{
+		AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "HEAD"},
+		AllowHeaders: append(headers,
+			middleware.HeaderSigningToken, middleware.HeaderTimestamp, middleware.HeaderNonce, middleware.HeaderSignature, middleware.HeaderAPIKey, handlers.HeaderDryRun, handlers.HeaderUserToken, handlers.HeaderHistoryToken),
+		ExposeHeaders: exposed,
+		MaxAge:        12 * time.Hour,
+	}
+	if origins := splitOrigins(os.Getenv("EMBED_ALLOWED_ORIGINS")); len(origins) > 0 && origins[0] != "*" {
+		embed.AllowOrigins = origins
+	} else {
+		embed.AllowAllOrigins = true
+	}
+
+	groups := []middleware.CORSGroup{
+		{Prefixes: embedPrefixes, Config: embed},
+	}
+	return groups, dashboard
+}
+
+func splitOrigins(value string) []string {
+	var origins []string
+	for _, o := range strings.Split(value, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, strings.TrimSuffix(o, "/"))
+		}
+	}
+	return origins
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// maxAckIDs bounds how many messages one ack request can reconcile
+const maxAckIDs = 100
+
+// Widgets send UUIDs; anything similarly short and URL-safe is accepted
+var clientMessageIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+func validClientMessageID(id string) bool {
+	return clientMessageIDPattern.MatchString(id)
+}
+
+// findAcknowledged returns the stored exchange for a client message ID, or
+// nil if the server has not answered it
+func findAcknowledged(ctx context.Context, projectID primitive.ObjectID, clientMessageID string) (*models.ChatMessage, error) {
+	var message models.ChatMessage
+	err := config.GetChatMessagesCollectionFor(projectID).FindOne(ctx, bson.M{
+		"project_id":        projectID,
+		"client_message_id": clientMessageID,
+	}).Decode(&message)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetMessageAcks - GET /chat/:projectId/ack?ids=a,b reports which of the
+// widget's queued client message IDs the server has answered, so messages
+// sent while briefly offline can be reconciled without sending them twice.
+// Unknown IDs were never received and are safe to resend.
+func GetMessageAcks(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if !validClientMessageID(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client message ID", "id": id})
+			return
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids is required"})
+		return
+	}
+	if len(ids) > maxAckIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many ids", "max": maxAckIDs})
+		return
+	}
+
+	cursor, err := config.GetChatMessagesCollectionFor(objID).Find(context.Background(), bson.M{
+		"project_id":        objID,
+		"client_message_id": bson.M{"$in": ids},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load acknowledgements"})
+		return
+	}
+	var messages []models.ChatMessage
+	if err := cursor.All(context.Background(), &messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load acknowledgements"})
+		return
+	}
+
+	byID := make(map[string]models.ChatMessage, len(messages))
+	for _, m := range messages {
+		byID[m.ClientMessageID] = m
+	}
+
+	acks := make([]gin.H, 0, len(ids))
+	acknowledged := 0
+	for _, id := range ids {
+		m, ok := byID[id]
+		if !ok {
+			acks = append(acks, gin.H{"client_message_id": id, "status": "unknown"})
+			continue
+		}
+		acknowledged++
+		acks = append(acks, gin.H{
+			"client_message_id": id,
+			"status":            "acknowledged",
+			"message_id":        m.ID.Hex(),
+			"session_id":        m.SessionID,
+			"response":          m.Response,
+			"timestamp":         m.Timestamp.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":   objID.Hex(),
+		"acks":         acks,
+		"acknowledged": acknowledged,
+		"unknown":      len(ids) - acknowledged,
+	})
+}
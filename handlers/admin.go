@@ -8,14 +8,18 @@ import (
     "fmt"
     "io/ioutil"
     "net/http"
+    "net/mail"
     "strings"
     "time"
 
     "github.com/gin-gonic/gin"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
     "jevi-chat/config"
     "jevi-chat/models"
+    "jevi-chat/repository"
 )
 
 // In handlers/admin.go
@@ -27,16 +31,25 @@ func AdminDashboard(c *gin.Context) {
         "active_users": 0,
     }
     
+    // Tenant admins only see their own projects counted
+    userFilter, projectFilter := bson.M{}, bson.M{}
+    if !c.GetBool("is_super_admin") {
+        userObjID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+        userFilter["_id"] = userObjID
+        projectFilter["user_id"] = userObjID
+    }
+    
     // Get actual stats from database
     if userCollection := config.DB.Collection("users"); userCollection != nil {
-        userCount, _ := userCollection.CountDocuments(context.Background(), bson.M{})
-        activeUserCount, _ := userCollection.CountDocuments(context.Background(), bson.M{"is_active": true})
+        userCount, _ := userCollection.CountDocuments(context.Background(), userFilter)
+        userFilter["is_active"] = true
+        activeUserCount, _ := userCollection.CountDocuments(context.Background(), userFilter)
         stats["total_users"] = userCount
         stats["active_users"] = activeUserCount
     }
     
     if projectCollection := config.DB.Collection("projects"); projectCollection != nil {
-        projectCount, _ := projectCollection.CountDocuments(context.Background(), bson.M{})
+        projectCount, _ := projectCollection.CountDocuments(context.Background(), projectFilter)
         stats["total_projects"] = projectCount
     }
     
@@ -115,6 +128,16 @@ func CreateProject(c *gin.Context) {
     
     fmt.Printf("Parsed project: %+v\n", project)
     
+    // Tenant admins own what they create; super-admins may assign an owner
+    if !c.GetBool("is_super_admin") {
+        ownerID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+        if err != nil {
+            c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+            return
+        }
+        project.UserID = ownerID
+    }
+    
     // Initialize all required fields based on your struct
     project.ID = primitive.NewObjectID()
     project.IsActive = true
@@ -139,7 +162,7 @@ func CreateProject(c *gin.Context) {
     
     // Initialize arrays to prevent null values
     if project.PDFFiles == nil {
-        project.PDFFiles = []models.PDFFile{}
+        project.PDFFiles = []models.SourceFile{}
     }
     
     // Initialize analytics fields
@@ -200,15 +223,90 @@ func UpdateProject(c *gin.Context) {
         return
     }
     
-    var updateData bson.M
-    if err := c.ShouldBindJSON(&updateData); err != nil {
+    // Only these fields are edited here; the rest have dedicated endpoints
+    // that validate, audit or meter them
+    var input struct {
+        Name              *string `json:"name"`
+        Description       *string `json:"description"`
+        Category          *string `json:"category"`
+        WelcomeMessage    *string `json:"welcome_message"`
+        GeminiAPIKey      *string `json:"gemini_api_key"`
+        GeminiModel       *string `json:"gemini_model"`
+        ChatRetentionDays *int    `json:"chat_retention_days"`
+
+        // Platform operators only: moving a project between tenants and
+        // its storage quota
+        UserID            *string `json:"user_id"`
+        StorageQuotaMB    *int    `json:"storage_quota_mb"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update data"})
         return
     }
+    if (input.UserID != nil || input.StorageQuotaMB != nil) && !c.GetBool("is_super_admin") {
+        c.JSON(http.StatusForbidden, gin.H{"error": "Super-admin privileges required to change the owner or storage quota"})
+        return
+    }
     
-    updateData["updated_at"] = time.Now()
+    updateData := bson.M{"updated_at": time.Now()}
+    if input.Name != nil {
+        name := strings.TrimSpace(*input.Name)
+        if name == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Project name is required"})
+            return
+        }
+        updateData["name"] = name
+    }
+    if input.Description != nil {
+        updateData["description"] = *input.Description
+    }
+    if input.Category != nil {
+        updateData["category"] = *input.Category
+    }
+    if input.WelcomeMessage != nil {
+        updateData["welcome_message"] = *input.WelcomeMessage
+    }
+    if input.GeminiAPIKey != nil {
+        updateData["gemini_api_key"] = strings.TrimSpace(*input.GeminiAPIKey)
+    }
+    if input.GeminiModel != nil {
+        updateData["gemini_model"] = *input.GeminiModel
+    }
+    if input.ChatRetentionDays != nil {
+        if *input.ChatRetentionDays < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "chat_retention_days cannot be negative"})
+            return
+        }
+        updateData["chat_retention_days"] = *input.ChatRetentionDays
+    }
+    if input.UserID != nil {
+        ownerID, err := primitive.ObjectIDFromHex(*input.UserID)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+            return
+        }
+        updateData["user_id"] = ownerID
+    }
+    if input.StorageQuotaMB != nil {
+        if *input.StorageQuotaMB < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "storage_quota_mb cannot be negative"})
+            return
+        }
+        updateData["storage_quota_mb"] = *input.StorageQuotaMB
+    }
     
     collection := config.DB.Collection("projects")
+
+    // A rotated Gemini key's cached client is dropped once the update lands
+    var previous struct {
+        GeminiAPIKey string `bson:"gemini_api_key"`
+    }
+    newKey, rotatesKey := updateData["gemini_api_key"].(string)
+    if rotatesKey {
+        collection.FindOne(context.Background(), bson.M{"_id": objID},
+            options.FindOne().SetProjection(bson.M{"gemini_api_key": 1})).Decode(&previous)
+    }
+
     _, err = collection.UpdateOne(
         context.Background(),
         bson.M{"_id": objID},
@@ -219,6 +317,9 @@ func UpdateProject(c *gin.Context) {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
         return
     }
+    if rotatesKey && previous.GeminiAPIKey != newKey {
+        config.ForgetGeminiKey(previous.GeminiAPIKey)
+    }
     
     c.JSON(http.StatusOK, gin.H{
         "message": "Project updated successfully",
@@ -234,16 +335,29 @@ func DeleteProject(c *gin.Context) {
         return
     }
     
-    collection := config.DB.Collection("projects")
-    _, err = collection.DeleteOne(context.Background(), bson.M{"_id": objID})
+    if config.IsProjectOnLegalHold(context.Background(), objID) {
+        c.JSON(http.StatusConflict, gin.H{"error": "Project is under legal hold and cannot be deleted"})
+        return
+    }
+    
+    // Cascade to messages, chat users, usage, notifications and files
+    deleted, err := repository.DeleteProject(context.Background(), objID)
+    if err == repository.ErrProjectNotFound {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+        return
+    }
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "Failed to delete project",
+            "details": err.Error(),
+        })
         return
     }
     
     c.JSON(http.StatusOK, gin.H{
         "message": "Project deleted successfully",
         "project_id": projectID,
+        "deleted": deleted,
     })
 }
 
@@ -342,6 +456,9 @@ func GetUserDetails(c *gin.Context) {
     })
 }
 
+// UpdateUser - PUT /admin/users/:id changes a user's username and email.
+// Only super-admins may change the role; tenant admins reach this for their
+// own account only.
 func UpdateUser(c *gin.Context) {
     userID := c.Param("id")
     objID, err := primitive.ObjectIDFromHex(userID)
@@ -350,27 +467,76 @@ func UpdateUser(c *gin.Context) {
         return
     }
     
-    var updateData bson.M
-    if err := c.ShouldBindJSON(&updateData); err != nil {
+    var input struct {
+        Username *string `json:"username"`
+        Email    *string `json:"email"`
+        Role     *string `json:"role"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update data"})
         return
     }
     
-    updateData["updated_at"] = time.Now()
-    delete(updateData, "password") // Don't allow password updates through this endpoint
+    if input.Username != nil {
+        name := strings.TrimSpace(*input.Username)
+        if len(name) < 2 || len(name) > 50 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be 2-50 characters"})
+            return
+        }
+        input.Username = &name
+    }
+    if input.Email != nil {
+        addr, err := mail.ParseAddress(strings.TrimSpace(*input.Email))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+            return
+        }
+        input.Email = &addr.Address
+    }
     
-    collection := config.DB.Collection("users")
-    _, err = collection.UpdateOne(
-        context.Background(),
-        bson.M{"_id": objID},
-        bson.M{"$set": updateData},
-    )
+    // Only platform operators may change roles (prevents self-promotion)
+    if input.Role != nil {
+        if !c.GetBool("is_super_admin") {
+            c.JSON(http.StatusForbidden, gin.H{"error": "Super-admin privileges required to change roles"})
+            return
+        }
+        switch *input.Role {
+        case models.RoleUser, models.RoleAdmin, models.RoleSuperAdmin:
+        default:
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+            return
+        }
+    }
     
+    user, err := repository.UpdateUserAccount(context.Background(), objID, repository.AccountUpdate{
+        Username: input.Username,
+        Email:    input.Email,
+        Role:     input.Role,
+    })
+    if err == repository.ErrUserNotFound {
+        c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+        return
+    }
+    if err == repository.ErrEmailTaken {
+        c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
+        return
+    }
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
         return
     }
     
+    if input.Role != nil {
+        recordAudit(c, models.AuditActionRoleChange, "user", userID, primitive.NilObjectID, map[string]interface{}{
+            "role": *input.Role,
+        })
+        notifySecurityEvent(*user, "role_change",
+            map[string]interface{}{
+                "role": *input.Role,
+            },
+            *input.Role)
+    }
+    
     c.JSON(http.StatusOK, gin.H{
         "message": "User updated successfully",
         "user_id": userID,
@@ -385,16 +551,44 @@ func DeleteUser(c *gin.Context) {
         return
     }
     
-    collection := config.DB.Collection("users")
-    _, err = collection.DeleteOne(context.Background(), bson.M{"_id": objID})
+    user, err := repository.GetUser(context.Background(), objID)
+    if err == repository.ErrUserNotFound {
+        c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+        return
+    }
     if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+        return
+    }
+    
+    policy := repository.DeletionPolicyFor(user)
+    job, err := repository.StartUserDeletion(context.Background(), user, policy, c.GetString("user_id"))
+    if err == repository.ErrDeletionInProgress {
+        c.JSON(http.StatusConflict, gin.H{"error": "User deletion already in progress"})
+        return
+    }
+    if job == nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
         return
     }
+    if err != nil {
+        // The deletion is queued and removes the account regardless
+        fmt.Printf("User deletion %s: %v\n", job.ID.Hex(), err)
+    }
     
-    c.JSON(http.StatusOK, gin.H{
-        "message": "User deleted successfully",
-        "user_id": userID,
+    recordAudit(c, models.AuditActionUserDelete, "user", userID, primitive.NilObjectID, map[string]interface{}{
+        "deletion_id": job.ID.Hex(),
+        "email":       user.Email,
+        "policy":      policy,
+    })
+    go ProcessUserDeletions()
+    
+    c.JSON(http.StatusAccepted, gin.H{
+        "message":     "User deletion started",
+        "user_id":     userID,
+        "deletion_id": job.ID.Hex(),
+        "policy":      policy,
+        "status":      job.Status,
     })
 }
 
@@ -506,10 +700,8 @@ func getAdminStats() map[string]interface{} {
     }
     
     // Get message count
-    if messageCollection := config.DB.Collection("chat_messages"); messageCollection != nil {
-        messageCount, _ := messageCollection.CountDocuments(context.Background(), bson.M{})
-        stats["total_messages"] = messageCount
-    }
+    messageCount, _ := config.CountTenantDocuments(context.Background(), "chat_messages", bson.M{})
+    stats["total_messages"] = messageCount
     
     return stats
 }
@@ -711,10 +903,41 @@ func ToggleGeminiStatus(c *gin.Context) {
             "monthly": project.GeminiUsageMonth,
     
             "monthly_limit": project.GeminiMonthlyLimit,
+            "overage": overageInfo(&project),
         },
     })
 }
 
+// GetGeminiHealth - Report the project's Gemini key health. With
+// warmup=true the key is checked against Gemini first.
+func GetGeminiHealth(c *gin.Context) {
+    objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+        return
+    }
+
+    var project models.Project
+    if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+        return
+    }
+
+    health := config.GeminiHealthFor(project.GeminiAPIKey)
+    if c.Query("warmup") == "true" && project.GeminiAPIKey != "" {
+        ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+        defer cancel()
+        health = config.WarmUpGemini(ctx, project.GeminiAPIKey)
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "success":        true,
+        "project_id":     objID.Hex(),
+        "gemini_enabled": project.GeminiEnabled,
+        "health":         health,
+    })
+}
+
 // Enhanced GetGeminiAnalytics with detailed tracking
 func GetGeminiAnalytics(c *gin.Context) {
     projectID := c.Param("id")
@@ -724,6 +947,12 @@ func GetGeminiAnalytics(c *gin.Context) {
         return
     }
 
+    loc, err := analyticsLocation(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
     // Get project details
     collection := config.DB.Collection("projects")
     var project models.Project
@@ -736,19 +965,20 @@ func GetGeminiAnalytics(c *gin.Context) {
     // Get usage logs for analytics
     logsCollection := config.DB.Collection("gemini_usage_logs")
     
-    // Get today's successful requests
-    today := time.Now().Truncate(24 * time.Hour)
+    // Get today's successful requests ("today" in the viewer's timezone)
+    today := startOfDay(time.Now(), loc)
     todayCount, _ := logsCollection.CountDocuments(context.Background(), bson.M{
         "project_id": objID,
         "timestamp": bson.M{"$gte": today},
         "success": true,
     })
 
-    // Get this month's successful requests
-    thisMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.UTC)
+    // Get successful requests in the current billing period, which is what
+    // the monthly limit counts
+    periodStart, periodEnd := project.BillingPeriod(time.Now())
     monthCount, _ := logsCollection.CountDocuments(context.Background(), bson.M{
         "project_id": objID,
-        "timestamp": bson.M{"$gte": thisMonth},
+        "timestamp": bson.M{"$gte": periodStart},
         "success": true,
     })
 
@@ -768,12 +998,23 @@ func GetGeminiAnalytics(c *gin.Context) {
                 "count": monthCount,
                 "limit": project.GeminiMonthlyLimit,
                 "remaining": project.GeminiMonthlyLimit - int(monthCount),
-        
+                "period_start": periodStart,
+                "period_end": periodEnd,
             },
+            "overage": overageInfo(&project),
             "total_questions": project.TotalQuestions,
             "last_used": project.LastUsed,
         },
+        "timezone": loc.String(),
+    }
+
+    daily, err := dailySeries(context.Background(), logsCollection, bson.M{"project_id": objID, "success": true},
+        "timestamp", seriesDays(c, 30), loc, map[string]string{"tokens": "tokens_used", "cost": "estimated_cost"})
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load daily usage"})
+        return
     }
+    analytics["daily"] = daily
 
     c.JSON(http.StatusOK, gin.H{
         "success": true,
@@ -867,6 +1108,9 @@ func GetProjectsWithLimits(c *gin.Context) {
             "monthly_usage": project.GeminiUsageMonth,
             "monthly_limit": project.GeminiMonthlyLimit,
             "usage_percentage": usagePercentage,
+            "billing_cycle_day": project.CycleDay(),
+            "resets_at": getNextMonthlyReset(&project),
+            "overage": overageInfo(&project),
             "last_used": project.LastUsed,
             "created_at": project.CreatedAt,
         }
@@ -943,31 +1187,422 @@ func ResetMonthlyUsage(c *gin.Context) {
         return
     }
 
-    collection := config.DB.Collection("projects")
+    err = repository.ResetMonthlyUsage(context.Background(), objID)
+    if err == repository.ErrProjectNotFound {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset monthly usage"})
+        return
+    }
+
+    recordAudit(c, models.AuditActionUsageReset, "project", projectID, objID, nil)
+
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "message": "Monthly usage counter reset successfully",
+        "reset_date": time.Now(),
+    })
+}
+
+
+
+// SetBillingCycleDay - PUT /admin/projects/:id/billing-cycle moves the day
+// of the month the project's usage resets on. If the new day has passed
+// since the last reset, the next reset run starts a fresh period.
+func SetBillingCycleDay(c *gin.Context) {
+    projectID := c.Param("id")
+    objID, err := primitive.ObjectIDFromHex(projectID)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+        return
+    }
+
+    var input struct {
+        Day int `json:"billing_cycle_day" binding:"required,min=1,max=31"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "billing_cycle_day must be between 1 and 31"})
+        return
+    }
+
+    var project models.Project
+    err = config.GetProjectsCollection().FindOneAndUpdate(context.Background(),
+        bson.M{"_id": objID},
+        bson.M{"$set": bson.M{"billing_cycle_day": input.Day, "updated_at": time.Now()}},
+        options.FindOneAndUpdate().SetReturnDocument(options.After),
+    ).Decode(&project)
+    if err == mongo.ErrNoDocuments {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update billing cycle"})
+        return
+    }
+
+    recordAudit(c, models.AuditActionBillingCycle, "project", projectID, objID, gin.H{"billing_cycle_day": input.Day})
+
+    periodStart, periodEnd := project.BillingPeriod(time.Now())
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "billing_cycle_day": input.Day,
+        "period_start": periodStart,
+        "period_end": periodEnd,
+    })
+}
+
+// GetGeminiDailyUsage - Historical per-day usage rollups for a project.
+// These survive the usage log purge, so they cover the full billing history.
+func GetGeminiDailyUsage(c *gin.Context) {
+    projectID := c.Param("id")
+    objID, err := primitive.ObjectIDFromHex(projectID)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+        return
+    }
+
+    // Dates are YYYY-MM-DD strings, so lexical comparison matches date order
+    filter := bson.M{"project_id": objID}
+    dateFilter := bson.M{}
+    if from := c.Query("from"); from != "" {
+        dateFilter["$gte"] = from
+    }
+    if to := c.Query("to"); to != "" {
+        dateFilter["$lte"] = to
+    }
+    if len(dateFilter) > 0 {
+        filter["date"] = dateFilter
+    }
+
+    opts := options.Find().SetSort(bson.D{{"date", 1}})
+    cursor, err := config.GetGeminiUsageDailyCollection().Find(context.Background(), filter, opts)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch daily usage"})
+        return
+    }
+    defer cursor.Close(context.Background())
+
+    var days []models.GeminiUsageDaily
+    if err := cursor.All(context.Background(), &days); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse daily usage"})
+        return
+    }
+    if days == nil {
+        days = []models.GeminiUsageDaily{}
+    }
+
+    var totalRequests, totalTokens int64
+    var totalCost float64
+    for _, day := range days {
+        totalRequests += day.Requests
+        totalTokens += day.TokensUsed
+        totalCost += day.EstimatedCost
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "success":    true,
+        "project_id": projectID,
+        "days":       days,
+        "totals": gin.H{
+            "requests":       totalRequests,
+            "tokens_used":    totalTokens,
+            "estimated_cost": totalCost,
+        },
+    })
+}
+
+// GetChatArchives - List archived chat history batches for a project
+func GetChatArchives(c *gin.Context) {
+    projectID := c.Param("id")
+    objID, err := primitive.ObjectIDFromHex(projectID)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+        return
+    }
+
+    opts := options.Find().SetSort(bson.D{{"created_at", -1}})
+    cursor, err := config.GetChatArchivesCollection().Find(context.Background(), bson.M{"project_id": objID}, opts)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch archives"})
+        return
+    }
+    defer cursor.Close(context.Background())
+
+    var archives []models.ChatArchive
+    if err := cursor.All(context.Background(), &archives); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse archives"})
+        return
+    }
+    if archives == nil {
+        archives = []models.ChatArchive{}
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "success":    true,
+        "project_id": projectID,
+        "archives":   archives,
+        "count":      len(archives),
+    })
+}
+
+// RestoreChatArchive - Re-import an archived batch back into chat history
+func RestoreChatArchive(c *gin.Context) {
+    archiveID, err := primitive.ObjectIDFromHex(c.Param("archiveId"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid archive ID"})
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+    defer cancel()
+
+    restored, err := config.RestoreChatArchive(ctx, archiveID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "Failed to restore archive",
+            "details": err.Error(),
+        })
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "success":           true,
+        "message":           "Archive restored successfully",
+        "archive_id":        archiveID.Hex(),
+        "messages_restored": restored,
+    })
+}
+
+// SetLegalHold - Place or lift a legal hold on a project (super-admin only).
+// While held, retention jobs and data deletion skip the project entirely.
+func SetLegalHold(c *gin.Context) {
+    projectID := c.Param("id")
+    objID, err := primitive.ObjectIDFromHex(projectID)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+        return
+    }
+
+    var input struct {
+        Enabled *bool  `json:"enabled" binding:"required"`
+        Reason  string `json:"reason"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+        return
+    }
+    if *input.Enabled && strings.TrimSpace(input.Reason) == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to place a legal hold"})
+        return
+    }
+
     update := bson.M{
         "$set": bson.M{
-            "gemini_usage_month": 0,
-            "last_monthly_reset": time.Now(),
-            "updated_at": time.Now(),
+            "legal_hold":        *input.Enabled,
+            "legal_hold_reason": input.Reason,
+            "legal_hold_set_by": c.GetString("user_id"),
+            "legal_hold_set_at": time.Now(),
+            "updated_at":        time.Now(),
         },
     }
 
-    _, err = collection.UpdateOne(
-        context.Background(),
-        bson.M{"_id": objID},
-        update,
-    )
+    collection := config.DB.Collection("projects")
+    result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, update)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update legal hold"})
+        return
+    }
+    if result.MatchedCount == 0 {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+        return
+    }
+
+    action := models.AuditActionLegalHoldCleared
+    if *input.Enabled {
+        action = models.AuditActionLegalHoldSet
+    }
+    recordAudit(c, action, "project", projectID, objID, map[string]interface{}{
+        "reason": input.Reason,
+    })
 
+    c.JSON(http.StatusOK, gin.H{
+        "success":    true,
+        "project_id": projectID,
+        "legal_hold": *input.Enabled,
+    })
+}
+
+// GetTenantStorageStats - Per-project storage consumption for quota reporting
+func GetTenantStorageStats(c *gin.Context) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+
+    tenants, err := config.GetTenantStorageStats(ctx)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset monthly usage"})
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "Failed to compute tenant storage",
+            "details": err.Error(),
+        })
         return
     }
 
+    var totalBytes int64
+    overQuota := []string{}
+    for _, t := range tenants {
+        totalBytes += t.TotalBytes
+        if t.QuotaPercentage >= 100 {
+            overQuota = append(overQuota, t.ProjectID)
+        }
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "success":     true,
+        "tenants":     tenants,
+        "count":       len(tenants),
+        "total_bytes": totalBytes,
+        "over_quota":  overQuota,
+    })
+}
+
+// GetDatabaseIndexes - Actual vs expected indexes plus builds in progress
+func GetDatabaseIndexes(c *gin.Context) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    reports, err := config.GetIndexReport(ctx)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "Failed to inspect indexes",
+            "details": err.Error(),
+        })
+        return
+    }
+
+    missing := 0
+    for _, r := range reports {
+        missing += len(r.Missing)
+    }
+
+    response := gin.H{
+        "success":       true,
+        "collections":   reports,
+        "missing_count": missing,
+        "healthy":       missing == 0,
+    }
+
+    if builds, err := config.GetIndexBuilds(ctx); err != nil {
+        response["builds_error"] = err.Error()
+    } else {
+        response["builds_in_progress"] = builds
+    }
+
+    c.JSON(http.StatusOK, response)
+}
+
+// RepairDatabaseIndexes - Create any expected indexes that are missing
+func RepairDatabaseIndexes(c *gin.Context) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+    defer cancel()
+
+    created, err := config.CreateMissingIndexes(ctx)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "Failed to create missing indexes",
+            "details": err.Error(),
+            "created": created,
+        })
+        return
+    }
+
+    if len(created) > 0 {
+        recordAudit(c, models.AuditActionIndexRepair, "database", config.DB.Name(), primitive.NilObjectID, map[string]interface{}{
+            "created": created,
+        })
+    }
+
     c.JSON(http.StatusOK, gin.H{
         "success": true,
-        "message": "Monthly usage counter reset successfully",
-        "reset_date": time.Now(),
+        "message": "Index repair completed",
+        "created": created,
     })
 }
 
+// GetOrphanedData - Count records that reference deleted projects or users
+func GetOrphanedData(c *gin.Context) {
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+    defer cancel()
+
+    report, err := config.DetectOrphans(ctx)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "Failed to detect orphaned data",
+            "details": err.Error(),
+        })
+        return
+    }
 
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "orphans": report,
+    })
+}
+
+// RepairOrphanedData - Clean orphans, or relink a deleted project's data
+// to an existing project
+func RepairOrphanedData(c *gin.Context) {
+    var req struct {
+        Mode          string `json:"mode" binding:"required,oneof=clean relink"`
+        FromProjectID string `json:"from_project_id"`
+        ToProjectID   string `json:"to_project_id"`
+    }
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   "Invalid request data",
+            "details": err.Error(),
+        })
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+    defer cancel()
+
+    var result map[string]int64
+    var err error
+    targetID := primitive.NilObjectID
+
+    if req.Mode == "relink" {
+        fromID, fromErr := primitive.ObjectIDFromHex(req.FromProjectID)
+        toID, toErr := primitive.ObjectIDFromHex(req.ToProjectID)
+        if fromErr != nil || toErr != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "from_project_id and to_project_id must be valid project IDs"})
+            return
+        }
+        targetID = toID
+        result, err = config.RelinkOrphans(ctx, fromID, toID)
+    } else {
+        result, err = config.CleanOrphans(ctx)
+    }
+
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "Orphan repair failed",
+            "details": err.Error(),
+            "result":  result,
+        })
+        return
+    }
+
+    recordAudit(c, models.AuditActionOrphanRepair, "database", config.DB.Name(), targetID, map[string]interface{}{
+        "mode":            req.Mode,
+        "from_project_id": req.FromProjectID,
+        "result":          result,
+    })
+
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "mode":    req.Mode,
+        "result":  result,
+    })
+}
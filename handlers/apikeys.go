@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	// apiKeyTouchInterval is how stale a key's last-used time may get, so
+	// busy keys do not cost a write per request
+	apiKeyTouchInterval = time.Minute
+
+	// A key's hour is unusual when it has at least apiKeyAnomalyMinRequests
+	// requests and apiKeyAnomalyFactor times its usual hourly volume, or
+	// when at least apiKeyAnomalyErrorRate of them fail, well above its
+	// usual error rate. The usual is taken over apiKeyAnomalyBaseline, or
+	// the key's life if shorter; keys younger than apiKeyAnomalyMinAge are
+	// only checked for errors.
+	apiKeyAnomalyMinRequests = 100
+	apiKeyAnomalyFactor      = 10
+	apiKeyAnomalyErrorRate   = 0.5
+	apiKeyAnomalyBaseline    = 7 * 24 * time.Hour
+	apiKeyAnomalyMinAge      = 24 * time.Hour
+	// apiKeyAnomalyQuiet is how long after an alert a key raises no other
+	apiKeyAnomalyQuiet = 24 * time.Hour
+)
+
+// apiKeyUsageKey is one key's usage in one hour
+type apiKeyUsageKey struct {
+	projectID primitive.ObjectID
+	keyID     string
+	hour      time.Time
+}
+
+var (
+	// apiKeyLimiters holds one limiter per requests-per-minute setting,
+	// counting each key separately
+	apiKeyLimitersMu sync.Mutex
+	apiKeyLimiters   = map[int]rateLimiter{}
+
+	// apiKeyTouched is when each key's last use was last written
+	apiKeyTouchedMu sync.Mutex
+	apiKeyTouched   = map[string]time.Time{}
+
+	// apiKeyPending holds usage counted since the last flush
+	apiKeyPendingMu sync.Mutex
+	apiKeyPending   = map[apiKeyUsageKey]*repository.APIKeyUsage{}
+)
+
+func apiKeyLimiter(perMinute int) rateLimiter {
+	apiKeyLimitersMu.Lock()
+	defer apiKeyLimitersMu.Unlock()
+	limiter, ok := apiKeyLimiters[perMinute]
+	if !ok {
+		limiter = newRateLimiter(rateLimitStore, "apikey:"+strconv.Itoa(perMinute), time.Minute, perMinute)
+		apiKeyLimiters[perMinute] = limiter
+	}
+	return limiter
+}
+
+// APIKeyUsage applies the rate limit of the API key a request was
+// authenticated with, on top of the route's own limits, keeps the key's
+// last-used time and counts the request and its outcome for the key's
+// usage analytics. It runs after ProjectAPIKey or APIKeyAuth; requests made
+// without a key pass through.
+func APIKeyUsage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get("project_api_key")
+		if !ok {
+			c.Next()
+			return
+		}
+		key := value.(models.ProjectAPIKey)
+		value, _ = c.Get("api_key_project_id")
+		projectID := value.(primitive.ObjectID)
+
+		if key.RateLimit > 0 {
+			st := apiKeyLimiter(key.RateLimit).Take(key.ID)
+			if !st.Allowed {
+				respondRateLimited(c, st, "api_key", "This API key's rate limit was exceeded. Please wait before trying again.")
+				recordAPIKeyRequest(projectID, key.ID, http.StatusTooManyRequests)
+				return
+			}
+			setRateLimitHeaders(c, st)
+		}
+
+		touchAPIKey(projectID, key.ID, c.ClientIP())
+		c.Next()
+		recordAPIKeyRequest(projectID, key.ID, c.Writer.Status())
+	}
+}
+
+// recordAPIKeyRequest counts a finished request. Counts are kept per hour
+// in memory and added to api_key_usage by FlushAPIKeyUsage, so every
+// replica contributes to the same totals.
+func recordAPIKeyRequest(projectID primitive.ObjectID, keyID string, status int) {
+	k := apiKeyUsageKey{projectID: projectID, keyID: keyID, hour: time.Now().UTC().Truncate(time.Hour)}
+
+	apiKeyPendingMu.Lock()
+	defer apiKeyPendingMu.Unlock()
+	usage := apiKeyPending[k]
+	if usage == nil {
+		usage = &repository.APIKeyUsage{}
+		apiKeyPending[k] = usage
+	}
+	usage.Requests++
+	if status >= 400 {
+		usage.Errors++
+	}
+	if status == http.StatusTooManyRequests {
+		usage.RateLimited++
+	}
+}
+
+// FlushAPIKeyUsage writes the usage counted since the last flush. Counts
+// that fail to be written are kept for the next one.
+func FlushAPIKeyUsage() error {
+	apiKeyPendingMu.Lock()
+	pending := apiKeyPending
+	apiKeyPending = map[apiKeyUsageKey]*repository.APIKeyUsage{}
+	apiKeyPendingMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var firstErr error
+	for k, usage := range pending {
+		err := repository.AddAPIKeyUsage(ctx, k.projectID, k.keyID, k.hour, *usage)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		apiKeyPendingMu.Lock()
+		if kept := apiKeyPending[k]; kept != nil {
+			*kept = kept.Add(*usage)
+		} else {
+			apiKeyPending[k] = usage
+		}
+		apiKeyPendingMu.Unlock()
+	}
+	return firstErr
+}
+
+// touchAPIKey records a key's use, at most once per apiKeyTouchInterval
+func touchAPIKey(projectID primitive.ObjectID, keyID, ip string) {
+	now := time.Now()
+	apiKeyTouchedMu.Lock()
+	if now.Sub(apiKeyTouched[keyID]) < apiKeyTouchInterval {
+		apiKeyTouchedMu.Unlock()
+		return
+	}
+	apiKeyTouched[keyID] = now
+	apiKeyTouchedMu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := repository.TouchProjectAPIKey(ctx, projectID, keyID, ip); err != nil {
+			log.Printf("⚠️ Failed to record use of API key %s: %v", keyID, err)
+		}
+	}()
+}
+
+// CheckAPIKeyAnomalies compares each API key's usage in the last complete
+// hour with its usual usage, and alerts the project owner when the volume
+// or error rate jumped, as happens when a key leaks. The notification
+// carries a one-click revoke action.
+func CheckAPIKeyAnomalies() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	usage, err := repository.APIKeyUsageAgainstBaseline(ctx, hour, apiKeyAnomalyBaseline)
+	if err != nil {
+		return err
+	}
+
+	projects := map[primitive.ObjectID]*models.Project{}
+	for _, u := range usage {
+		project, ok := projects[u.ProjectID]
+		if !ok {
+			var p models.Project
+			err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": u.ProjectID},
+				options.FindOne().SetProjection(bson.M{"name": 1, "api_keys": 1}),
+			).Decode(&p)
+			if err == nil {
+				project = &p
+			}
+			projects[u.ProjectID] = project
+		}
+		if project == nil {
+			continue
+		}
+		var key *models.ProjectAPIKey
+		for i := range project.APIKeys {
+			if project.APIKeys[i].ID == u.KeyID {
+				key = &project.APIKeys[i]
+			}
+		}
+		if key == nil || !key.Active() || time.Since(key.AnomalyAlertedAt) < apiKeyAnomalyQuiet {
+			continue
+		}
+
+		usual, unusual := apiKeyUsageUnusual(u, hour.Sub(key.CreatedAt))
+		if !unusual {
+			continue
+		}
+		alertAPIKeyAnomaly(ctx, project, key, u.Hour, usual)
+		if err := repository.MarkAPIKeyAnomalyAlerted(ctx, u.ProjectID, u.KeyID, time.Now()); err != nil {
+			log.Printf("⚠️ Failed to record alert for API key %s: %v", u.KeyID, err)
+		}
+	}
+	return nil
+}
+
+// apiKeyUsageUnusual reports whether a key's hour stands out from the
+// hours before it, and its usual hourly volume. age is how long the key
+// had existed when the hour began.
+func apiKeyUsageUnusual(u repository.APIKeyHourUsage, age time.Duration) (float64, bool) {
+	window := min(age, apiKeyAnomalyBaseline)
+	usual := 0.0
+	if hours := window.Hours(); hours >= 1 {
+		usual = float64(u.Baseline.Requests) / math.Floor(hours)
+	}
+
+	if age >= apiKeyAnomalyMinAge && u.Hour.Requests >= apiKeyAnomalyMinRequests &&
+		float64(u.Hour.Requests) >= apiKeyAnomalyFactor*max(usual, 1) {
+		return usual, true
+	}
+	// Half the requests failing is only news for a key that usually works
+	if u.Hour.Requests >= apiKeyAnomalyMinRequests/2 && u.Hour.ErrorRate() >= apiKeyAnomalyErrorRate &&
+		u.Hour.ErrorRate() >= u.Baseline.ErrorRate()+0.25 {
+		return usual, true
+	}
+	return usual, false
+}
+
+// alertAPIKeyAnomaly raises a security notification for the project's
+// owner, or the key's creator, and emails them
+func alertAPIKeyAnomaly(ctx context.Context, project *models.Project, key *models.ProjectAPIKey, hour repository.APIKeyUsage, usual float64) {
+	var owner models.User
+	var ref struct {
+		UserID primitive.ObjectID `bson:"user_id"`
+	}
+	config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": project.ID},
+		options.FindOne().SetProjection(bson.M{"user_id": 1})).Decode(&ref)
+	ownerID := ref.UserID
+	if ownerID.IsZero() {
+		ownerID, _ = primitive.ObjectIDFromHex(key.CreatedBy)
+	}
+	if !ownerID.IsZero() {
+		config.GetUsersCollection().FindOne(ctx, bson.M{"_id": ownerID}).Decode(&owner)
+	}
+
+	errorPercent := int(math.Round(hour.ErrorRate() * 100))
+	notifyProjectSecurityEvent(project.ID, owner, "api_key_anomaly", map[string]interface{}{
+		"key_id":         key.ID,
+		"key_name":       key.Name,
+		"requests":       hour.Requests,
+		"usual_requests": math.Round(usual),
+		"error_rate":     hour.ErrorRate(),
+		"rate_limited":   hour.RateLimited,
+		"action": map[string]interface{}{
+			"label":  "Revoke key",
+			"method": http.MethodDelete,
+			"url":    fmt.Sprintf("/admin/projects/%s/api-keys/%s", project.ID.Hex(), key.ID),
+		},
+	}, key.Name, project.Name, hour.Requests, int64(math.Round(usual)), errorPercent)
+}
+
+// GetAPIKeyUsage - GET /admin/projects/:id/api-keys/:keyId/usage reports a
+// key's request volume and error rate over the last days (default 7, at
+// most 90), by day, or by hour with granularity=hour
+func GetAPIKeyUsage(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	keyID := c.Param("keyId")
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 1 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 90"})
+		return
+	}
+	bucket := 24 * time.Hour
+	switch c.DefaultQuery("granularity", "day") {
+	case "day":
+	case "hour":
+		bucket = time.Hour
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be day or hour"})
+		return
+	}
+
+	n, err := config.GetProjectsCollection().CountDocuments(context.Background(), bson.M{"_id": objID, "api_keys.id": keyID})
+	if err != nil || n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	series, err := repository.APIKeyUsageSeries(context.Background(), objID, keyID, since, bucket)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load API key usage"})
+		return
+	}
+	var total repository.APIKeyUsage
+	for _, b := range series {
+		total = total.Add(b.APIKeyUsage)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"key_id":      keyID,
+		"since":       since,
+		"granularity": c.DefaultQuery("granularity", "day"),
+		"total":       total,
+		"error_rate":  total.ErrorRate(),
+		"series":      series,
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// recordAudit - Append an entry to the audit log for the acting admin.
+// Audit failures are logged but never block the action itself.
+func recordAudit(c *gin.Context, action, targetType, targetID string, projectID primitive.ObjectID, details map[string]interface{}) {
+	entry := models.AuditLog{
+		ActorID:    c.GetString("user_id"),
+		ActorRole:  c.GetString("role"),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		ProjectID:  projectID,
+		Details:    details,
+		IPAddress:  c.ClientIP(),
+		CreatedAt:  time.Now(),
+	}
+
+	if _, err := config.GetAuditLogsCollection().InsertOne(context.Background(), entry); err != nil {
+		fmt.Printf("Failed to write audit log (%s): %v\n", action, err)
+	}
+}
+
+// recordJobAudit - Append an entry for work a background job finished on
+// behalf of an admin, who is recorded as the actor
+func recordJobAudit(action, actorID, targetType, targetID string, details map[string]interface{}) {
+	entry := models.AuditLog{
+		ActorID:    actorID,
+		ActorRole:  "system",
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		CreatedAt:  time.Now(),
+	}
+
+	if _, err := config.GetAuditLogsCollection().InsertOne(context.Background(), entry); err != nil {
+		fmt.Printf("Failed to write audit log (%s): %v\n", action, err)
+	}
+}
+
+// GetAuditLogs - List audit log entries, newest first
+func GetAuditLogs(c *gin.Context) {
+	filter := bson.M{}
+	if action := c.Query("action"); action != "" {
+		filter["action"] = action
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		objID, err := primitive.ObjectIDFromHex(projectID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+		filter["project_id"] = objID
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := config.GetAuditLogsCollection().Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit logs"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var entries []models.AuditLog
+	if err := cursor.All(context.Background(), &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse audit logs"})
+		return
+	}
+	if entries == nil {
+		entries = []models.AuditLog{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"logs":    entries,
+		"count":   len(entries),
+	})
+}
@@ -13,6 +13,7 @@ import (
     "golang.org/x/crypto/bcrypt"
     "jevi-chat/config"
     "jevi-chat/models"
+    "jevi-chat/repository"
 )
 
 func RegisterPage(c *gin.Context) {
@@ -46,30 +47,24 @@ func Register(c *gin.Context) {
     }
     user.Password = string(hashedPassword)
     user.IsActive = true
-    user.Role = "user"
+    user.Role = models.RoleUser
     user.CreatedAt = time.Now()
     user.UpdatedAt = time.Now()
     
-    // Check if user already exists
-    collection := config.DB.Collection("users")
-    var existingUser models.User
-    err = collection.FindOne(context.Background(), bson.M{"email": user.Email}).Decode(&existingUser)
-    if err == nil {
+    // Check for an existing account and insert atomically
+    err = repository.CreateUser(context.Background(), &user)
+    if err == repository.ErrEmailTaken {
         c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
         return
     }
-    
-    // Insert user
-    result, err := collection.InsertOne(context.Background(), user)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
         return
     }
     
-    user.ID = result.InsertedID.(primitive.ObjectID)
-    
     // Generate JWT token
-    token := generateJWT(user.ID.Hex(), false)
+    token := generateJWT(user.ID.Hex(), user.Role, false)
+    recordUserSession(c, user)
     
     c.SetCookie("token", token, 3600*24, "/", "", false, true)
     
@@ -104,8 +99,11 @@ func Login(c *gin.Context) {
     adminEmail := os.Getenv("ADMIN_EMAIL")
     adminPassword := os.Getenv("ADMIN_PASSWORD")
 
-    if loginData.Email == adminEmail && loginData.Password == adminPassword {
-        token := generateJWT("admin", true)
+    // Once the env admin has been seeded as a real account, it signs in
+    // like everyone else so its forced password change cannot be skipped
+    if adminEmail != "" && loginData.Email == adminEmail && loginData.Password == adminPassword && !envAdminSeeded(adminEmail) {
+        // The env-configured admin is the platform operator
+        token := generateJWT("admin", models.RoleSuperAdmin, false)
         c.SetCookie("token", token, 3600*24, "/", "", false, true)
 
         c.JSON(http.StatusOK, gin.H{
@@ -130,22 +128,49 @@ func Login(c *gin.Context) {
         return
     }
 
-    if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginData.Password)); err != nil {
+    ok, rehash := verifyPassword(loginData.Password, user.Password)
+    if !ok {
         c.JSON(http.StatusUnauthorized, gin.H{
             "success": false,
             "error": "Invalid credentials",
         })
         return
     }
+    if rehash {
+        upgradePasswordHash(collection, user.ID, user.Password, loginData.Password)
+    }
 
-    token := generateJWT(user.ID.Hex(), false)
+    token := generateJWT(user.ID.Hex(), user.Role, user.MustChangePassword)
     c.SetCookie("token", token, 3600*24, "/", "", false, true)
 
+    // Remember the device and warn the user about unfamiliar ones
+    recordUserSession(c, user)
+
+    redirect := "/user/dashboard"
+    if user.HasAdminAccess() {
+        redirect = "/admin/dashboard"
+    }
+    if user.MustChangePassword {
+        c.JSON(http.StatusOK, gin.H{
+            "success": true,
+            "message": "Password change required",
+            "token": token,
+            "must_change_password": true,
+            "change_password_url": "/user/password",
+            "user": gin.H{
+                "id": user.ID.Hex(),
+                "username": user.Username,
+                "email": user.Email,
+            },
+        })
+        return
+    }
+
     c.JSON(http.StatusOK, gin.H{
         "success": true,
         "message": "Login successful",
         "token": token,
-        "redirect": "/user/dashboard",
+        "redirect": redirect,
         "user": gin.H{
             "id": user.ID.Hex(),
             "username": user.Username,
@@ -201,13 +226,23 @@ func Logout(c *gin.Context) {
     c.Redirect(http.StatusFound, "/login")
 }
 
-func generateJWT(userID string, isAdmin bool) string {
+// generateJWT issues a session token. is_admin/is_super_admin are derived
+// from the role so middleware can authorize without a database lookup, and
+// must_change_password confines the session to changing the password.
+// is_admin lets tenant admins into the admin routes; TenantScope keeps them
+// to their own projects, and only is_super_admin crosses tenants.
+func generateJWT(userID, role string, mustChangePassword bool) string {
     claims := jwt.MapClaims{
         "user_id": userID,
-        "is_admin": isAdmin,
+        "role": role,
+        "is_admin": role == models.RoleAdmin || role == models.RoleSuperAdmin,
+        "is_super_admin": role == models.RoleSuperAdmin,
         "exp": time.Now().Add(time.Hour * 24).Unix(),
         "iat": time.Now().Unix(),
     }
+    if mustChangePassword {
+        claims["must_change_password"] = true
+    }
     
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
     tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
@@ -218,17 +253,66 @@ func generateJWT(userID string, isAdmin bool) string {
 }
 
 
-func GetUserProfile(c *gin.Context) {
-    userID := c.GetString("user_id")
-    c.JSON(http.StatusOK, gin.H{"user_id": userID})
-}
-
-func UpdateUserProfile(c *gin.Context) {
-    userID := c.GetString("user_id")
-    c.JSON(http.StatusOK, gin.H{"message": "Profile updated", "user_id": userID})
+// envAdminSeeded reports whether the ADMIN_EMAIL account exists in the database
+func envAdminSeeded(email string) bool {
+    n, err := config.GetUsersCollection().CountDocuments(context.Background(), bson.M{"email": email})
+    return err == nil && n > 0
 }
 
 func GetUserProjects(c *gin.Context) {
     userID := c.GetString("user_id")
     c.JSON(http.StatusOK, gin.H{"projects": []string{}, "user_id": userID})
 }
+
+// ImpersonateUser - Issue a short-lived session for another user (super-admin only)
+func ImpersonateUser(c *gin.Context) {
+    targetID := c.Param("id")
+    objID, err := primitive.ObjectIDFromHex(targetID)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+    
+    var user models.User
+    err = config.DB.Collection("users").FindOne(context.Background(), bson.M{"_id": objID}).Decode(&user)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+        return
+    }
+    
+    // Never hand out another operator's privileges
+    if user.IsSuperAdmin() {
+        c.JSON(http.StatusForbidden, gin.H{"error": "Cannot impersonate a super-admin"})
+        return
+    }
+    
+    claims := jwt.MapClaims{
+        "user_id": user.ID.Hex(),
+        "role": user.Role,
+        "is_admin": user.HasAdminAccess(),
+        "is_super_admin": false,
+        "impersonated_by": c.GetString("user_id"),
+        "exp": time.Now().Add(time.Hour).Unix(),
+        "iat": time.Now().Unix(),
+    }
+    token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET")))
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+        return
+    }
+    
+    recordAudit(c, models.AuditActionImpersonate, "user", targetID, primitive.NilObjectID, map[string]interface{}{
+        "email": user.Email,
+    })
+    
+    c.JSON(http.StatusOK, gin.H{
+        "success": true,
+        "token": token,
+        "expires_in": 3600,
+        "user": gin.H{
+            "id": user.ID.Hex(),
+            "email": user.Email,
+            "role": user.Role,
+        },
+    })
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+const blockedReply = "Sorry, you're no longer able to chat here. Please contact the site owner if you think this is a mistake."
+
+// findActiveBlock returns the block in force for a visitor, or nil. Empty
+// identifiers are ignored, so anonymous visitors are matched on IP only.
+func findActiveBlock(ctx context.Context, projectID, chatUserID primitive.ObjectID, email, ip string) (*models.ProjectBlock, error) {
+	match := bson.A{}
+	if !chatUserID.IsZero() {
+		match = append(match, bson.M{"chat_user_id": chatUserID})
+	}
+	if email = normalizeEmail(email); email != "" {
+		match = append(match, bson.M{"email": email})
+	}
+	if ip != "" {
+		match = append(match, bson.M{"ip_address": ip})
+	}
+	if len(match) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var block models.ProjectBlock
+	err := config.GetProjectBlocksCollection().FindOne(ctx, bson.M{
+		"project_id": projectID,
+		"revoked_at": bson.M{"$exists": false},
+		"$and": bson.A{
+			bson.M{"$or": match},
+			bson.M{"$or": unexpiredBlock(now)},
+		},
+	}).Decode(&block)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// isBlocked reports whether a visitor may not use the widget. Lookup errors
+// fail open so a database hiccup doesn't take every chat down.
+func isBlocked(projectID, chatUserID primitive.ObjectID, email, ip string) bool {
+	block, err := findActiveBlock(context.Background(), projectID, chatUserID, email, ip)
+	if err != nil {
+		log.Printf("⚠️ Block lookup failed for project %s: %v", projectID.Hex(), err)
+		return false
+	}
+	return block != nil
+}
+
+func unexpiredBlock(now time.Time) bson.A {
+	return bson.A{
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"expires_at": bson.M{"$gt": now}},
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CreateProjectBlock - POST /admin/projects/:id/blocks blocks a chat user,
+// email or IP address from the project's widget, optionally until expires_at
+func CreateProjectBlock(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		ChatUserID string     `json:"chat_user_id"`
+		Email      string     `json:"email" binding:"omitempty,email"`
+		IPAddress  string     `json:"ip_address"`
+		Reason     string     `json:"reason"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	block := models.ProjectBlock{
+		ProjectID: objID,
+		Email:     normalizeEmail(input.Email),
+		IPAddress: strings.TrimSpace(input.IPAddress),
+		Reason:    strings.TrimSpace(input.Reason),
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: time.Now(),
+		ExpiresAt: input.ExpiresAt,
+	}
+	if input.ChatUserID != "" {
+		block.ChatUserID, err = primitive.ObjectIDFromHex(input.ChatUserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat user ID"})
+			return
+		}
+	}
+	if block.IPAddress != "" && net.ParseIP(block.IPAddress) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+		return
+	}
+	if block.ChatUserID.IsZero() && block.Email == "" && block.IPAddress == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One of chat_user_id, email or ip_address is required"})
+		return
+	}
+	if block.ExpiresAt != nil && !block.ExpiresAt.After(block.CreatedAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	if n, err := config.GetProjectsCollection().CountDocuments(context.Background(), bson.M{"_id": objID}); err != nil || n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if !block.ChatUserID.IsZero() {
+		n, err := config.GetChatUsersCollection().CountDocuments(context.Background(), bson.M{
+			"_id":        block.ChatUserID,
+			"project_id": models.ProjectIDMatch(objID),
+		})
+		if err != nil || n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat user not found in this project"})
+			return
+		}
+	}
+
+	result, err := config.GetProjectBlocksCollection().InsertOne(context.Background(), block)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create block"})
+		return
+	}
+	block.ID = result.InsertedID.(primitive.ObjectID)
+
+	details := map[string]interface{}{
+		"email":      block.Email,
+		"ip_address": block.IPAddress,
+		"reason":     block.Reason,
+	}
+	if !block.ChatUserID.IsZero() {
+		details["chat_user_id"] = block.ChatUserID.Hex()
+	}
+	if block.ExpiresAt != nil {
+		details["expires_at"] = block.ExpiresAt
+	}
+	recordAudit(c, models.AuditActionBlockCreate, "project_block", block.ID.Hex(), objID, details)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"block":   block,
+	})
+}
+
+// GetProjectBlocks - GET /admin/projects/:id/blocks lists blocks, newest
+// first. Expired and revoked blocks are included with all=true.
+func GetProjectBlocks(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	filter := bson.M{"project_id": objID}
+	if c.Query("all") != "true" {
+		filter["revoked_at"] = bson.M{"$exists": false}
+		filter["$or"] = unexpiredBlock(time.Now())
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := config.GetProjectBlocksCollection().Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blocks"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var blocks []models.ProjectBlock
+	if err := cursor.All(context.Background(), &blocks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse blocks"})
+		return
+	}
+
+	now := time.Now()
+	result := make([]gin.H, 0, len(blocks))
+	for i := range blocks {
+		result = append(result, gin.H{
+			"block":  blocks[i],
+			"active": blocks[i].IsActive(now),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"blocks":  result,
+		"count":   len(result),
+	})
+}
+
+// RevokeProjectBlock - DELETE /admin/projects/:id/blocks/:blockId lifts a
+// block. The record is kept, marked revoked, for the audit trail.
+func RevokeProjectBlock(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	blockID, err := primitive.ObjectIDFromHex(c.Param("blockId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid block ID"})
+		return
+	}
+
+	result, err := config.GetProjectBlocksCollection().UpdateOne(context.Background(),
+		bson.M{"_id": blockID, "project_id": objID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"revoked_at": time.Now(),
+			"revoked_by": c.GetString("user_id"),
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke block"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Block not found or already revoked"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionBlockRevoke, "project_block", blockID.Hex(), objID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Block revoked",
+	})
+}
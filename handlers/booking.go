@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/generative-ai-go/genai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+	"jevi-chat/utils"
+)
+
+const (
+	// maxToolRounds bounds how many times the model may call tools for one
+	// message before it has to answer
+	maxToolRounds = 3
+
+	maxOfferedSlots   = 8
+	maxSlotSearchDays = 7
+
+	// bookingHistoryTurns is how many earlier exchanges of the session are
+	// given to the model, so a booking can span several messages
+	bookingHistoryTurns = 6
+)
+
+// bookingTools are the functions the model can call when the project has a
+// booking integration
+var bookingTools = []*genai.Tool{{
+	FunctionDeclarations: []*genai.FunctionDeclaration{
+		{
+			Name:        "find_available_slots",
+			Description: "Lists open appointment times. Call this before offering times to the visitor.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"from_date": {Type: genai.TypeString, Description: "First day to search, YYYY-MM-DD. Defaults to today."},
+					"days":      {Type: genai.TypeInteger, Description: "How many days to search, 1-7. Defaults to 7."},
+				},
+			},
+		},
+		{
+			Name:        "book_appointment",
+			Description: "Books one of the times returned by find_available_slots. Only call this once the visitor has chosen a time and given their name and email.",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"start_time": {Type: genai.TypeString, Description: "The chosen slot's start_time, exactly as returned"},
+					"name":       {Type: genai.TypeString, Description: "The visitor's name"},
+					"email":      {Type: genai.TypeString, Description: "The visitor's email address"},
+					"notes":      {Type: genai.TypeString, Description: "What the visitor wants to discuss, if they said"},
+				},
+				Required: []string{"start_time", "name", "email"},
+			},
+		},
+	},
+}}
+
+// bookingToolRunner executes the model's tool calls for one message
+type bookingToolRunner struct {
+	project   models.Project
+	provider  bookingProvider
+	loc       *time.Location
+	sessionID string
+}
+
+func (r *bookingToolRunner) run(ctx context.Context, call genai.FunctionCall) map[string]any {
+	var result map[string]any
+	var err error
+	switch call.Name {
+	case "find_available_slots":
+		result, err = r.findSlots(ctx, call.Args)
+	case "book_appointment":
+		result, err = r.book(ctx, call.Args)
+	default:
+		err = fmt.Errorf("unknown function %s", call.Name)
+	}
+	if err != nil {
+		if err != errSlotUnavailable {
+			log.Printf("⚠️ Booking tool %s failed for project %s: %v", call.Name, r.project.ID.Hex(), err)
+		}
+		return map[string]any{"error": err.Error()}
+	}
+	return result
+}
+
+func (r *bookingToolRunner) findSlots(ctx context.Context, args map[string]any) (map[string]any, error) {
+	now := time.Now()
+	from := now
+	if s, _ := args["from_date"].(string); s != "" {
+		day, err := time.ParseInLocation("2006-01-02", s, r.loc)
+		if err != nil {
+			return nil, fmt.Errorf("from_date must be YYYY-MM-DD")
+		}
+		if day.After(from) {
+			from = day
+		}
+	}
+	days := maxSlotSearchDays
+	if d, ok := args["days"].(float64); ok && d >= 1 && d < maxSlotSearchDays {
+		days = int(d)
+	}
+
+	slots, err := r.provider.availableSlots(ctx, from, from.AddDate(0, 0, days))
+	if err != nil {
+		return nil, err
+	}
+	if len(slots) > maxOfferedSlots {
+		slots = slots[:maxOfferedSlots]
+	}
+	offered := make([]any, 0, len(slots))
+	for _, s := range slots {
+		offered = append(offered, map[string]any{
+			"start_time": s.Start.UTC().Format(time.RFC3339),
+			"label":      s.Start.In(r.loc).Format("Monday, January 2 at 3:04 PM MST"),
+		})
+	}
+	return map[string]any{"slots": offered, "timezone": r.loc.String()}, nil
+}
+
+func (r *bookingToolRunner) book(ctx context.Context, args map[string]any) (map[string]any, error) {
+	startArg, _ := args["start_time"].(string)
+	name, _ := args["name"].(string)
+	email, _ := args["email"].(string)
+	notes, _ := args["notes"].(string)
+
+	start, err := time.Parse(time.RFC3339, startArg)
+	if err != nil {
+		return nil, fmt.Errorf("start_time must be one of the slots returned by find_available_slots")
+	}
+	name = strings.TrimSpace(name)
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if name == "" || err != nil {
+		return nil, fmt.Errorf("a name and a valid email address are required")
+	}
+
+	booked, err := r.provider.book(ctx, start, bookingRequest{
+		Name:      name,
+		Email:     addr.Address,
+		Notes:     notes,
+		SessionID: r.sessionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	recordLead(models.Lead{
+		ProjectID:     r.project.ID,
+		SessionID:     r.sessionID,
+		Name:          name,
+		Email:         addr.Address,
+		Source:        models.LeadSourceBooking,
+		Status:        booked.Status,
+		Provider:      r.project.Booking.Provider,
+		AppointmentAt: start,
+		BookingURL:    booked.URL,
+		ExternalID:    booked.ExternalID,
+		Notes:         notes,
+	})
+
+	result := map[string]any{
+		"status": booked.Status,
+		"time":   start.In(r.loc).Format("Monday, January 2 at 3:04 PM MST"),
+	}
+	if booked.Status == models.LeadStatusLinkSent {
+		// Calendly bookings are finished by the visitor
+		result["booking_link"] = booked.URL
+		result["instructions"] = "Give the visitor this link to confirm the booking"
+	}
+	return result, nil
+}
+
+// recordLead stores a lead and tells the project owner about it
+func recordLead(lead models.Lead) {
+	lead.CreatedAt = time.Now()
+	if _, err := config.GetLeadsCollection().InsertOne(context.Background(), lead); err != nil {
+		log.Printf("⚠️ Failed to record lead for project %s: %v", lead.ProjectID.Hex(), err)
+		return
+	}
+
+	title := "New appointment booked"
+	if lead.Status == models.LeadStatusLinkSent {
+		title = "Appointment link sent"
+	}
+	err := CreateNotification(lead.ProjectID, primitive.NilObjectID, models.NotificationTypeInfo, title,
+		fmt.Sprintf("%s <%s> chose %s.", lead.Name, lead.Email, lead.AppointmentAt.UTC().Format(time.RFC1123)),
+		map[string]interface{}{
+			"lead_email":     lead.Email,
+			"session_id":     lead.SessionID,
+			"status":         lead.Status,
+			"auto_generated": true,
+		})
+	if err != nil {
+		log.Printf("⚠️ Failed to create lead notification for project %s: %v", lead.ProjectID.Hex(), err)
+	}
+}
+
+// generateBookingResponse answers a message with the booking tools
+// available. Earlier exchanges of the session are included so the visitor
+// can pick a time and give their details over several messages.
+func generateBookingResponse(project models.Project, userMessage, sessionID string, user models.ChatUser) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+	defer cancel()
+
+	credentials, err := config.DecryptSecret(project.Booking.EncryptedCredentials)
+	if err != nil {
+		return "", fmt.Errorf("booking credentials unavailable: %v", err)
+	}
+	provider, err := newBookingProvider(ctx, project.Booking, credentials)
+	if err != nil {
+		return "", err
+	}
+	loc := bookingLocation(project.Booking)
+	runner := &bookingToolRunner{project: project, provider: provider, loc: loc, sessionID: sessionID}
+
+	client, err := config.GeminiClientFor(ctx, project.GeminiAPIKey)
+	if err != nil {
+		return "", err
+	}
+	modelName := project.GeminiModel
+	if modelName == "" {
+		modelName = "gemini-2.0-flash"
+	}
+	model := client.GenerativeModel(modelName)
+	model.SetTemperature(0.6)
+	model.Tools = bookingTools
+
+	chat := model.StartChat()
+	chat.History = bookingHistory(ctx, project.ID, sessionID)
+
+	var visitor string
+	if user.Name != "" {
+		visitor = fmt.Sprintf("\nThe visitor is signed in as %s <%s>; use these details when booking.", user.Name, user.Email)
+	}
+	prompt := buildSupportPrompt(project.Name, project.Instructions, project.PDFContent, userMessage) + fmt.Sprintf(`
+
+APPOINTMENTS:
+You can offer and book appointments with the find_available_slots and book_appointment tools.
+It is now %s (%s). Offer a few times in the visitor's words, never invent times,
+and ask for their name and email before booking.%s`,
+		time.Now().In(loc).Format("Monday, January 2 2006, 3:04 PM"), loc.String(), visitor)
+
+	resp, err := chat.SendMessage(ctx, genai.Text(prompt))
+	config.ReportGeminiResult(project.GeminiAPIKey, err)
+	for round := 0; err == nil && round < maxToolRounds; round++ {
+		if len(resp.Candidates) == 0 {
+			break
+		}
+		calls := resp.Candidates[0].FunctionCalls()
+		if len(calls) == 0 {
+			break
+		}
+		parts := make([]genai.Part, 0, len(calls))
+		for _, call := range calls {
+			parts = append(parts, genai.FunctionResponse{Name: call.Name, Response: runner.run(ctx, call)})
+		}
+		resp, err = chat.SendMessage(ctx, parts...)
+		config.ReportGeminiResult(project.GeminiAPIKey, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate content: %v", err)
+	}
+
+	var text strings.Builder
+	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if t, ok := part.(genai.Text); ok {
+				text.WriteString(string(t))
+			}
+		}
+	}
+	if text.Len() == 0 {
+		return "I'm sorry, I couldn't generate a response at the moment. Please try again.", nil
+	}
+	return text.String(), nil
+}
+
+// streamBookingReply answers a streamed message for a project with booking
+// tools. Tool calls need the model's whole turn, so the answer arrives as a
+// single chunk.
+func streamBookingReply(c *gin.Context, msg *widgetMessage) {
+	project := msg.Project
+	response, err := generateMeteredResponse(project, msg.Message, msg.SessionID, msg.ClientIP, msg.ChatUser)
+	if err == repository.ErrQuotaExceeded {
+		go emitLimitCrossed(project, msg.SessionID, project.GeminiMonthlyLimit)
+		c.SSEvent("chunk", gin.H{"text": "Your limit has expired."})
+		info := quotaLimitInfo(&project)
+		c.SSEvent("done", gin.H{
+			"session_id": msg.SessionID,
+			"status":     "monthly_limit_exceeded",
+			"code":       info.Code,
+			"limit_info": info,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️ Booking reply failed for project %s: %v", project.ID.Hex(), err)
+		c.SSEvent("error", gin.H{"message": "I'm having trouble answering just now. Please try again later."})
+		return
+	}
+
+	msg.save(response)
+	c.SSEvent("chunk", gin.H{"text": response})
+	c.SSEvent("done", gin.H{"session_id": msg.SessionID, "status": "success"})
+}
+
+// bookingHistory returns the session's latest exchanges as chat history
+func bookingHistory(ctx context.Context, projectID primitive.ObjectID, sessionID string) []*genai.Content {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(bookingHistoryTurns)
+	cursor, err := config.GetChatMessagesCollectionFor(projectID).Find(ctx, bson.M{
+		"project_id": projectID,
+		"session_id": sessionID,
+		"tombstone":  bson.M{"$exists": false},
+	}, opts)
+	if err != nil {
+		return nil
+	}
+	var messages []models.ChatMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil
+	}
+
+	var history []*genai.Content
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		if m.Message == "" || m.Response == "" {
+			continue
+		}
+		history = append(history,
+			&genai.Content{Role: "user", Parts: []genai.Part{genai.Text(m.Message)}},
+			&genai.Content{Role: "model", Parts: []genai.Part{genai.Text(m.Response)}},
+		)
+	}
+	return history
+}
+
+// SetBookingIntegration - PUT /admin/projects/:id/booking connects the
+// calendar the bot offers appointments from. An empty provider turns
+// booking off. Credentials may be omitted to keep the stored ones.
+func SetBookingIntegration(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Provider         string `json:"provider" binding:"omitempty,oneof=calendly google_calendar"`
+		Credentials      string `json:"credentials"` // Calendly access token or Google service account JSON
+		EventTypeURI     string `json:"event_type_uri"`
+		CalendarID       string `json:"calendar_id"`
+		SlotMinutes      int    `json:"slot_minutes" binding:"omitempty,min=10,max=240"`
+		WorkdayStartHour int    `json:"workday_start_hour" binding:"min=0,max=23"`
+		WorkdayEndHour   int    `json:"workday_end_hour" binding:"min=0,max=24"`
+		Timezone         string `json:"timezone"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	collection := config.GetProjectsCollection()
+	var project models.Project
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	if input.Provider == "" {
+		_, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+			"$unset": bson.M{"booking": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking settings"})
+			return
+		}
+		recordAudit(c, models.AuditActionBookingUpdate, "project", projectID, objID, map[string]interface{}{
+			"provider": "",
+		})
+		c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "booking": nil})
+		return
+	}
+
+	switch input.Provider {
+	case models.BookingCalendly:
+		if !strings.HasPrefix(input.EventTypeURI, calendlyAPI+"/event_types/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "event_type_uri must be a Calendly event type URI"})
+			return
+		}
+	case models.BookingGoogleCalendar:
+		if input.CalendarID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "calendar_id is required for Google Calendar"})
+			return
+		}
+		if input.WorkdayEndHour != 0 && input.WorkdayEndHour <= input.WorkdayStartHour {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "workday_end_hour must be after workday_start_hour"})
+			return
+		}
+	}
+	if input.Timezone != "" {
+		if _, err := time.LoadLocation(input.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone"})
+			return
+		}
+	}
+
+	integration := models.BookingIntegration{
+		Provider:         input.Provider,
+		EventTypeURI:     input.EventTypeURI,
+		CalendarID:       input.CalendarID,
+		SlotMinutes:      input.SlotMinutes,
+		WorkdayStartHour: input.WorkdayStartHour,
+		WorkdayEndHour:   input.WorkdayEndHour,
+		Timezone:         input.Timezone,
+		UpdatedAt:        time.Now(),
+	}
+	if input.Credentials != "" && input.Provider == models.BookingGoogleCalendar {
+		if err := checkServiceAccount(input.Credentials); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	switch {
+	case input.Credentials != "":
+		integration.EncryptedCredentials, err = config.EncryptSecret(input.Credentials)
+		if err == config.ErrSecretsKeyMissing {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Credential encryption is not configured on this server"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store credentials"})
+			return
+		}
+	case project.Booking != nil && project.Booking.Provider == input.Provider:
+		integration.EncryptedCredentials = project.Booking.EncryptedCredentials
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credentials are required"})
+		return
+	}
+
+	_, err = collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"booking": integration, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update booking settings"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionBookingUpdate, "project", projectID, objID, map[string]interface{}{
+		"provider":            input.Provider,
+		"credentials_changed": input.Credentials != "",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "booking": integration})
+}
+
+// GetProjectLeads - GET /admin/projects/:id/leads lists leads captured in
+// chat, newest first, optionally only ?status=booked or link_sent. ?redact=
+// applies an export redaction profile to the leads' details.
+func GetProjectLeads(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	profile, ok := redactionProfile(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"project_id": objID}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(500)
+	cursor, err := config.GetLeadsCollection().Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch leads"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	leads := []models.Lead{}
+	if err := cursor.All(context.Background(), &leads); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse leads"})
+		return
+	}
+	if r := utils.NewRedactor(profile); r.Enabled() {
+		for i := range leads {
+			r.AddNames(leads[i].Name)
+		}
+		for i := range leads {
+			leads[i].Name = r.Name(leads[i].Name)
+			leads[i].Email = r.Email(leads[i].Email)
+			leads[i].Notes = r.Text(leads[i].Notes)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"leads":   leads,
+		"count":   len(leads),
+	})
+}
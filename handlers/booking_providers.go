@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+	"jevi-chat/models"
+)
+
+var errSlotUnavailable = errors.New("that time is no longer available")
+
+// bookingSlot is a bookable appointment start. URL is set when the visitor
+// books through a link instead of the bot booking for them.
+type bookingSlot struct {
+	Start time.Time
+	URL   string
+}
+
+// bookingRequest is who an appointment is for
+type bookingRequest struct {
+	Name      string
+	Email     string
+	Notes     string
+	SessionID string
+}
+
+// bookingResult is the outcome of a booking: either booked outright or a
+// link the visitor follows to confirm
+type bookingResult struct {
+	Status     string // models.LeadStatusBooked or models.LeadStatusLinkSent
+	URL        string
+	ExternalID string
+}
+
+type bookingProvider interface {
+	availableSlots(ctx context.Context, from, to time.Time) ([]bookingSlot, error)
+	book(ctx context.Context, start time.Time, req bookingRequest) (bookingResult, error)
+}
+
+// newBookingProvider returns the client for a project's integration.
+// credentials is the decrypted token or service account JSON.
+func newBookingProvider(ctx context.Context, cfg *models.BookingIntegration, credentials string) (bookingProvider, error) {
+	switch cfg.Provider {
+	case models.BookingCalendly:
+		return &calendlyProvider{token: credentials, eventType: cfg.EventTypeURI}, nil
+	case models.BookingGoogleCalendar:
+		if err := checkServiceAccount(credentials); err != nil {
+			return nil, err
+		}
+		svc, err := calendar.NewService(ctx, option.WithCredentialsJSON([]byte(credentials)),
+			option.WithScopes(calendar.CalendarEventsScope, calendar.CalendarReadonlyScope))
+		if err != nil {
+			return nil, err
+		}
+		return &googleCalendarProvider{svc: svc, cfg: cfg, loc: bookingLocation(cfg)}, nil
+	}
+	return nil, fmt.Errorf("unknown booking provider %q", cfg.Provider)
+}
+
+// googleTokenURL is the only token endpoint a service account may name.
+// The auth library posts to the credentials' token_uri, so a customer's
+// JSON could otherwise send requests into our own network.
+const googleTokenURL = "https://oauth2.googleapis.com/token"
+
+// checkServiceAccount rejects service account JSON that is malformed or
+// names a token endpoint other than Google's
+func checkServiceAccount(credentials string) error {
+	var account struct {
+		Type     string `json:"type"`
+		TokenURI string `json:"token_uri"`
+	}
+	if err := json.Unmarshal([]byte(credentials), &account); err != nil {
+		return errors.New("credentials must be a service account JSON key")
+	}
+	if account.Type != "service_account" {
+		return errors.New("credentials must be a service account JSON key")
+	}
+	if account.TokenURI != "" && account.TokenURI != googleTokenURL {
+		return fmt.Errorf("service account token_uri must be %s", googleTokenURL)
+	}
+	return nil
+}
+
+// bookingLocation is the timezone slots are offered in
+func bookingLocation(cfg *models.BookingIntegration) *time.Location {
+	if cfg.Timezone != "" {
+		if loc, err := time.LoadLocation(cfg.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// ===== CALENDLY =====
+
+const calendlyAPI = "https://api.calendly.com"
+
+// calendlyProvider offers an event type's open times. Calendly has no API
+// to book on someone's behalf, so booking hands out the slot's link.
+type calendlyProvider struct {
+	token     string
+	eventType string
+}
+
+func (p *calendlyProvider) availableSlots(ctx context.Context, from, to time.Time) ([]bookingSlot, error) {
+	q := url.Values{}
+	q.Set("event_type", p.eventType)
+	q.Set("start_time", from.UTC().Format(time.RFC3339))
+	q.Set("end_time", to.UTC().Format(time.RFC3339))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, calendlyAPI+"/event_type_available_times?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	resp, err := integrationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+		return nil, fmt.Errorf("calendly returned %d: %s", resp.StatusCode, body)
+	}
+
+	var out struct {
+		Collection []struct {
+			Status        string    `json:"status"`
+			StartTime     time.Time `json:"start_time"`
+			SchedulingURL string    `json:"scheduling_url"`
+		} `json:"collection"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid calendly response: %v", err)
+	}
+	var slots []bookingSlot
+	for _, s := range out.Collection {
+		if s.Status == "available" {
+			slots = append(slots, bookingSlot{Start: s.StartTime, URL: s.SchedulingURL})
+		}
+	}
+	return slots, nil
+}
+
+func (p *calendlyProvider) book(ctx context.Context, start time.Time, req bookingRequest) (bookingResult, error) {
+	slots, err := p.availableSlots(ctx, start, start.Add(time.Hour))
+	if err != nil {
+		return bookingResult{}, err
+	}
+	for _, s := range slots {
+		if s.Start.Equal(start) {
+			link, err := url.Parse(s.URL)
+			if err != nil {
+				return bookingResult{}, err
+			}
+			// Calendly pre-fills the invitee form from these
+			q := link.Query()
+			q.Set("name", req.Name)
+			q.Set("email", req.Email)
+			link.RawQuery = q.Encode()
+			return bookingResult{Status: models.LeadStatusLinkSent, URL: link.String()}, nil
+		}
+	}
+	return bookingResult{}, errSlotUnavailable
+}
+
+// ===== GOOGLE CALENDAR =====
+
+// googleCalendarProvider offers the free slots of one calendar within the
+// configured working hours on weekdays, and books them as events. The
+// calendar must be shared with the service account.
+type googleCalendarProvider struct {
+	svc *calendar.Service
+	cfg *models.BookingIntegration
+	loc *time.Location
+}
+
+func (p *googleCalendarProvider) slotLength() time.Duration {
+	if p.cfg.SlotMinutes > 0 {
+		return time.Duration(p.cfg.SlotMinutes) * time.Minute
+	}
+	return 30 * time.Minute
+}
+
+func (p *googleCalendarProvider) workday() (start, end int) {
+	start, end = p.cfg.WorkdayStartHour, p.cfg.WorkdayEndHour
+	if start == 0 && end == 0 {
+		return 9, 17
+	}
+	return start, end
+}
+
+func (p *googleCalendarProvider) busy(ctx context.Context, from, to time.Time) ([]*calendar.TimePeriod, error) {
+	resp, err := p.svc.Freebusy.Query(&calendar.FreeBusyRequest{
+		TimeMin: from.UTC().Format(time.RFC3339),
+		TimeMax: to.UTC().Format(time.RFC3339),
+		Items:   []*calendar.FreeBusyRequestItem{{Id: p.cfg.CalendarID}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	cal, ok := resp.Calendars[p.cfg.CalendarID]
+	if !ok {
+		return nil, fmt.Errorf("calendar %s not found", p.cfg.CalendarID)
+	}
+	if len(cal.Errors) > 0 {
+		return nil, fmt.Errorf("calendar %s: %s", p.cfg.CalendarID, cal.Errors[0].Reason)
+	}
+	return cal.Busy, nil
+}
+
+func (p *googleCalendarProvider) availableSlots(ctx context.Context, from, to time.Time) ([]bookingSlot, error) {
+	busy, err := p.busy(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	length := p.slotLength()
+	startHour, endHour := p.workday()
+
+	var slots []bookingSlot
+	day := from.In(p.loc)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, p.loc)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		closing := day.Add(time.Duration(endHour) * time.Hour)
+		for t := day.Add(time.Duration(startHour) * time.Hour); !t.Add(length).After(closing); t = t.Add(length) {
+			if t.Before(from) || !t.Before(to) || overlapsBusy(busy, t, t.Add(length)) {
+				continue
+			}
+			slots = append(slots, bookingSlot{Start: t})
+		}
+	}
+	return slots, nil
+}
+
+func overlapsBusy(busy []*calendar.TimePeriod, start, end time.Time) bool {
+	for _, b := range busy {
+		bs, err1 := time.Parse(time.RFC3339, b.Start)
+		be, err2 := time.Parse(time.RFC3339, b.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if start.Before(be) && bs.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *googleCalendarProvider) book(ctx context.Context, start time.Time, req bookingRequest) (bookingResult, error) {
+	end := start.Add(p.slotLength())
+	slots, err := p.availableSlots(ctx, start, end)
+	if err != nil {
+		return bookingResult{}, err
+	}
+	if len(slots) == 0 || !slots[0].Start.Equal(start) {
+		return bookingResult{}, errSlotUnavailable
+	}
+
+	// Service accounts cannot invite attendees without domain-wide
+	// delegation, so the visitor's details go in the description
+	event, err := p.svc.Events.Insert(p.cfg.CalendarID, &calendar.Event{
+		Summary:     "Appointment with " + req.Name,
+		Description: fmt.Sprintf("Booked from chat.\nName: %s\nEmail: %s\nSession: %s\n\n%s", req.Name, req.Email, req.SessionID, req.Notes),
+		Start:       &calendar.EventDateTime{DateTime: start.In(p.loc).Format(time.RFC3339), TimeZone: p.loc.String()},
+		End:         &calendar.EventDateTime{DateTime: end.In(p.loc).Format(time.RFC3339), TimeZone: p.loc.String()},
+	}).Context(ctx).Do()
+	if err != nil {
+		return bookingResult{}, err
+	}
+	return bookingResult{Status: models.LeadStatusBooked, URL: event.HtmlLink, ExternalID: event.Id}, nil
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	maxCatalogFileSize = 20 * 1024 * 1024
+	maxCatalogProducts = 50000
+
+	// catalogMatches is how many products are put in front of the model
+	// for one question
+	catalogMatches = 5
+)
+
+// Column names accepted for each product field, lowercased
+var catalogColumns = map[string][]string{
+	"sku":          {"sku", "id", "product_id", "item_id"},
+	"name":         {"name", "title", "product_name"},
+	"description":  {"description", "details"},
+	"category":     {"category", "type", "product_type"},
+	"price":        {"price", "amount"},
+	"currency":     {"currency"},
+	"availability": {"availability", "stock_status", "in_stock"},
+	"quantity":     {"quantity", "stock", "inventory"},
+	"url":          {"url", "link", "product_url"},
+}
+
+// catalogRow is one product as uploaded, before validation
+type catalogRow map[string]string
+
+func (r catalogRow) get(field string) string {
+	for _, col := range catalogColumns[field] {
+		if v, ok := r[col]; ok && v != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// parseCatalogCSV reads a CSV catalog with a header row
+func parseCatalogCSV(r io.Reader) ([]catalogRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header row: %v", err)
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	}
+
+	var rows []catalogRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := catalogRow{}
+		for i, v := range record {
+			if i < len(header) {
+				row[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+		if len(rows) > maxCatalogProducts {
+			return nil, fmt.Errorf("catalogs are limited to %d products", maxCatalogProducts)
+		}
+	}
+	return rows, nil
+}
+
+// parseCatalogJSON reads a JSON array of products, or an object with a
+// "products" array
+func parseCatalogJSON(r io.Reader) ([]catalogRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		var wrapped struct {
+			Products []map[string]interface{} `json:"products"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("expected an array of products: %v", err)
+		}
+		items = wrapped.Products
+	}
+	if len(items) > maxCatalogProducts {
+		return nil, fmt.Errorf("catalogs are limited to %d products", maxCatalogProducts)
+	}
+
+	rows := make([]catalogRow, 0, len(items))
+	for _, item := range items {
+		row := catalogRow{}
+		for k, v := range item {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				row[strings.ToLower(k)] = v
+			case float64:
+				row[strings.ToLower(k)] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				row[strings.ToLower(k)] = fmt.Sprint(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// productFromRow validates one row. Rows without a SKU, name or price are
+// rejected with the reason.
+func productFromRow(row catalogRow) (models.Product, error) {
+	p := models.Product{
+		SKU:         row.get("sku"),
+		Name:        row.get("name"),
+		Description: row.get("description"),
+		Category:    row.get("category"),
+		Currency:    strings.ToUpper(row.get("currency")),
+		URL:         row.get("url"),
+	}
+	if p.SKU == "" || p.Name == "" {
+		return p, fmt.Errorf("sku and name are required")
+	}
+
+	priceText := strings.NewReplacer("$", "", "€", "", "£", "", "₹", "", ",", "").Replace(row.get("price"))
+	price, err := strconv.ParseFloat(strings.TrimSpace(priceText), 64)
+	if err != nil || price < 0 {
+		return p, fmt.Errorf("invalid price %q", row.get("price"))
+	}
+	p.Price = price
+	if p.Currency == "" {
+		p.Currency = "USD"
+	}
+
+	if q := row.get("quantity"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil {
+			return p, fmt.Errorf("invalid quantity %q", q)
+		}
+		p.Quantity = &n
+	}
+	switch strings.ToLower(strings.ReplaceAll(row.get("availability"), " ", "_")) {
+	case "in_stock", "instock", "true", "yes", "available":
+		p.Availability = models.AvailabilityInStock
+	case "out_of_stock", "outofstock", "false", "no", "sold_out", "unavailable":
+		p.Availability = models.AvailabilityOutOfStock
+	case "preorder", "pre_order", "backorder":
+		p.Availability = models.AvailabilityPreorder
+	case "":
+		p.Availability = models.AvailabilityInStock
+		if p.Quantity != nil && *p.Quantity <= 0 {
+			p.Availability = models.AvailabilityOutOfStock
+		}
+	default:
+		return p, fmt.Errorf("unknown availability %q", row.get("availability"))
+	}
+
+	if p.URL != "" {
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return p, fmt.Errorf("invalid url %q", p.URL)
+		}
+	}
+	return p, nil
+}
+
+// ImportCatalog - POST /admin/projects/:id/catalog uploads a product
+// catalog as a CSV or JSON "catalog" file. Products are matched by SKU;
+// with replace=true, products missing from the file are removed.
+func ImportCatalog(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	if n, err := config.GetProjectsCollection().CountDocuments(context.Background(), bson.M{"_id": objID}); err != nil || n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("catalog")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No catalog file uploaded"})
+		return
+	}
+	if fileHeader.Size > maxCatalogFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Catalog files are limited to 20MB"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read catalog file"})
+		return
+	}
+	defer file.Close()
+
+	var rows []catalogRow
+	switch strings.ToLower(filepath.Ext(fileHeader.Filename)) {
+	case ".csv":
+		rows, err = parseCatalogCSV(file)
+	case ".json":
+		rows, err = parseCatalogJSON(file)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Catalog must be a .csv or .json file"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid catalog file", "details": err.Error()})
+		return
+	}
+
+	products := make([]models.Product, 0, len(rows))
+	bySKU := make(map[string]int)
+	var rejected []gin.H
+	duplicates := 0
+	for i, row := range rows {
+		p, err := productFromRow(row)
+		if err != nil {
+			if len(rejected) < 100 {
+				rejected = append(rejected, gin.H{"row": i + 1, "sku": p.SKU, "error": err.Error()})
+			}
+			continue
+		}
+		// A later row for the same SKU wins
+		if j, ok := bySKU[p.SKU]; ok {
+			products[j] = p
+			duplicates++
+			continue
+		}
+		bySKU[p.SKU] = len(products)
+		products = append(products, p)
+	}
+	if len(products) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid products in the catalog", "rejected": rejected})
+		return
+	}
+
+	replace := c.Query("replace") == "true"
+	result, err := repository.ImportProducts(context.Background(), objID, products, replace)
+	if err != nil {
+		log.Printf("⚠️ Catalog import failed for project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import catalog"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionCatalogImport, "project", projectID, objID, map[string]interface{}{
+		"file":     fileHeader.Filename,
+		"products": len(products),
+		"rejected": len(rows) - len(products) - duplicates,
+		"replace":  replace,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"import":   result,
+		"rejected": rejected,
+	})
+}
+
+// GetCatalog - GET /admin/projects/:id/catalog lists catalog products by
+// SKU, optionally filtered by ?category, paginated with ?page and ?limit
+func GetCatalog(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := bson.M{"project_id": objID}
+	if category := c.Query("category"); category != "" {
+		filter["category"] = category
+	}
+
+	collection := config.GetProductsCollection()
+	total, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch catalog"})
+		return
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sku", Value: 1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch catalog"})
+		return
+	}
+	products := []models.Product{}
+	if err := cursor.All(context.Background(), &products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse catalog"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"products": products,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// ClearCatalog - DELETE /admin/projects/:id/catalog removes every product
+func ClearCatalog(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	removed, err := repository.ClearCatalog(context.Background(), objID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear catalog"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionCatalogClear, "project", projectID, objID, map[string]interface{}{
+		"removed": removed,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "removed": removed})
+}
+
+// withCatalogContext appends the catalog products matching a question to
+// the project's knowledge, so prices, stock and links are quoted exactly
+// instead of recalled from text
+func withCatalogContext(project models.Project, question string) string {
+	if project.CatalogProducts == 0 {
+		return project.PDFContent
+	}
+	products, err := repository.SearchProducts(context.Background(), project.ID, question, catalogMatches)
+	if err != nil {
+		log.Printf("⚠️ Catalog search failed for project %s: %v", project.ID.Hex(), err)
+		return project.PDFContent
+	}
+	if len(products) == 0 {
+		return project.PDFContent
+	}
+
+	var b strings.Builder
+	b.WriteString(project.PDFContent)
+	b.WriteString("\n\nPRODUCT CATALOG (exact, current data - quote prices, availability and links exactly as given, and never guess values for products not listed):\n")
+	for _, p := range products {
+		fmt.Fprintf(&b, "- %s (SKU %s): %.2f %s, %s", p.Name, p.SKU, p.Price, p.Currency, strings.ReplaceAll(p.Availability, "_", " "))
+		if p.Quantity != nil && p.Availability == models.AvailabilityInStock {
+			fmt.Fprintf(&b, " (%d left)", *p.Quantity)
+		}
+		if p.URL != "" {
+			fmt.Fprintf(&b, ", %s", p.URL)
+		}
+		if p.Description != "" {
+			desc := p.Description
+			if r := []rune(desc); len(r) > 200 {
+				desc = string(r[:200]) + "..."
+			}
+			fmt.Fprintf(&b, ". %s", desc)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -2,19 +2,21 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/generative-ai-go/genai"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"google.golang.org/api/option"
 	"html"
 	"jevi-chat/config"
 	"jevi-chat/models"
+	"jevi-chat/repository"
 	"log"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -37,9 +39,10 @@ type Visitor struct {
 
 var (
 	// Rate limiters for different endpoints
-	chatRateLimiter    *RateLimiter
-	authRateLimiter    *RateLimiter
-	generalRateLimiter *RateLimiter
+	chatRateLimiter    rateLimiter
+	authRateLimiter    rateLimiter
+	generalRateLimiter rateLimiter
+	statusRateLimiter  rateLimiter
 )
 
 // NewRateLimiter creates a new rate limiter
@@ -139,14 +142,21 @@ func (rl *RateLimiter) cleanupVisitors() {
 
 // InitRateLimiters initializes rate limiters
 func InitRateLimiters() {
+	store := sharedRateLimitStore()
+	rateLimitStore = store
+
 	// Chat endpoints: 30 requests per minute
-	chatRateLimiter = NewRateLimiter(time.Minute, 30)
+	chatRateLimiter = newRateLimiter(store, "chat", time.Minute, 30)
 
 	// Auth endpoints: 10 requests per minute (more restrictive)
-	authRateLimiter = NewRateLimiter(time.Minute, 10)
+	authRateLimiter = newRateLimiter(store, "auth", time.Minute, 10)
 
 	// General endpoints: 60 requests per minute
-	generalRateLimiter = NewRateLimiter(time.Minute, 60)
+	generalRateLimiter = newRateLimiter(store, "general", time.Minute, 60)
+
+	// Rate limit status checks: 60 requests per minute, counted apart from
+	// the budgets they report
+	statusRateLimiter = newRateLimiter(store, "status", time.Minute, 60)
 }
 
 // ===== MAIN CHAT HANDLERS =====
@@ -159,6 +169,8 @@ func SendMessage(c *gin.Context) {
 	var messageData struct {
 		Message   string `json:"message"`
 		SessionID string `json:"session_id"`
+		// Issued with the session's last answer; needed to continue it
+		HistoryToken string `json:"history_token"`
 	}
 
 	if err := c.ShouldBindJSON(&messageData); err != nil {
@@ -174,22 +186,8 @@ func SendMessage(c *gin.Context) {
 	}
 
 	// Enhanced rate limiting with proper response
-	if !checkRateLimit(clientIP) {
-		remaining := 0
-		if chatRateLimiter != nil {
-			remaining = chatRateLimiter.GetRemainingRequests(clientIP)
-		}
-
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Minute).Unix()))
-		c.Header("Retry-After", "60")
-
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"error":       "Rate limit exceeded",
-			"message":     "Too many requests. Please wait before sending another message.",
-			"retry_after": 60,
-			"remaining":   remaining,
-		})
+	if st := checkRateLimit(clientIP); !st.Allowed {
+		respondRateLimited(c, st, "chat", "Too many requests. Please wait before sending another message.")
 		return
 	}
 
@@ -214,30 +212,45 @@ func SendMessage(c *gin.Context) {
 		return
 	}
 
+	messageData.SessionID, err = resolveChatSession(context.Background(), objID, messageData.SessionID,
+		sessionHistoryToken(c, messageData.HistoryToken), primitive.NilObjectID, clientIP)
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	// Let the customer's moderation webhook gate the message
+	decision := moderateMessage(project, messageData.Message, messageData.SessionID, models.ChatUser{}, clientIP)
+	if decision.Action == ModerationModify {
+		messageData.Message = decision.Message
+	}
+
 	var response string
 	var err2 error
-
-	// Check if Gemini is enabled and within limits
-	if project.GeminiEnabled && project.GeminiUsageMonth < project.GeminiMonthlyLimit && project.GeminiAPIKey != "" {
+	var limitErr *limitInfo
+
+	if decision.Action == ModerationReject {
+		response = decision.rejectionReply()
+	} else if answer, ok := answerOrderQuery(project, messageData.Message, messageData.SessionID, models.ChatUser{}); ok {
+		response = answer
+	} else if project.GeminiEnabled && project.CanUseGemini() && project.GeminiAPIKey != "" {
+		// Gemini is enabled and within limits
 		// First-message greeting logic + 4-second human-like delay
 		if isFirstMessage(objID, messageData.SessionID) {
 			time.Sleep(4 * time.Second)
 			response = project.WelcomeMessage
 		} else {
 			time.Sleep(4 * time.Second) // keep the same pause for regular replies
-			response, err2 = generateAIResponse(
-				messageData.Message,
-				project.PDFContent,
-				project.GeminiAPIKey,
-				project.Name,
-				project.GeminiModel,
-			)
-			if err2 != nil {
+			response, err2 = generateMeteredResponse(project, messageData.Message, messageData.SessionID, clientIP, models.ChatUser{})
+			if err2 == repository.ErrQuotaExceeded {
+				response = "Your limit has expired."
+				info := quotaLimitInfo(&project)
+				limitErr = &info
+			} else if err2 == nil && project.Fallback != nil && isUnanswered(response) {
+				response = project.Fallback.Message
+			} else if err2 != nil {
 				// Fallback response
 				response = fmt.Sprintf("I apologize, but I'm experiencing technical difficulties with my AI system. However, I received your message about %s and will help you as best I can. Please try rephrasing your question.", project.Name)
-			} else {
-				// Update monthly usage counter asynchronously (corrected function name)
-				go updateMonthlyGeminiUsage(objID)
 			}
 		}
 	} else {
@@ -250,47 +263,101 @@ func SendMessage(c *gin.Context) {
 		} else {
 			// ✅ NEW: Proper limit exceeded message
 			response = "Your limit has expired."
+			info := quotaLimitInfo(&project)
+			limitErr = &info
 		}
 	}
 
 	// Save chat message to database
 	chatMessage := models.ChatMessage{
-		ProjectID: objID,
-		SessionID: messageData.SessionID,
-		Message:   messageData.Message,
-		Response:  response,
-		IsUser:    false,
-		Timestamp: time.Now(),
-		IPAddress: clientIP,
+		ProjectID:        objID,
+		SessionID:        messageData.SessionID,
+		Message:          messageData.Message,
+		Response:         response,
+		IsUser:           false,
+		Timestamp:        time.Now(),
+		IPAddress:        clientIP,
+		ModerationAction: decision.Action,
 	}
 
-	chatCollection := config.DB.Collection("chat_messages")
+	chatCollection := config.GetChatMessagesCollectionFor(objID)
 	result, err := chatCollection.InsertOne(context.Background(), chatMessage)
 	if err != nil {
 		// Log error but still return response
 		fmt.Printf("Failed to save chat message: %v\n", err)
 	} else {
 		chatMessage.ID = result.InsertedID.(primitive.ObjectID)
+		go emitExchangeEvents(chatMessage)
 	}
 
 	// Add rate limit headers to response
-	if chatRateLimiter != nil {
-		remaining := chatRateLimiter.GetRemainingRequests(clientIP)
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Minute).Unix()))
-	}
+	setRateLimitHeaders(c, limiterFor("chat").Peek(clientIP))
 
-	c.JSON(http.StatusOK, gin.H{
+	reply := gin.H{
 		"response":   response,
 		"message_id": chatMessage.ID,
 		"timestamp":  chatMessage.Timestamp,
 		"session_id": messageData.SessionID,
 		"usage_info": gin.H{},
-	})
+
+		"history_token": historyToken(objID, messageData.SessionID, time.Now()),
+	}
+	if limitErr != nil {
+		reply["code"] = limitErr.Code
+		reply["limit_info"] = limitErr
+	}
+	c.JSON(http.StatusOK, reply)
 }
 
-// IframeSendMessage - For embed widget users with enhanced features
-func IframeSendMessage(c *gin.Context) {
+// widgetMessage is a widget chat message that passed every check that
+// comes before generating an answer
+type widgetMessage struct {
+	Project   models.Project
+	ChatUser  models.ChatUser
+	SessionID string
+	Message   string
+	ClientIP  string
+	Decision  moderationDecision
+	DryRun    bool
+
+	ClientMessageID string
+	// Citations are the document passages the answer drew on
+	Citations []models.Citation
+	// Clarifying is set when the response asks the visitor to clarify
+	Clarifying bool
+	// Fallback is why the response is the fallback answer, if it is
+	Fallback string
+	// Intent is the key of the project intent the message was classified
+	// as; Project is already routed for it
+	Intent string
+	// Handoff is set when the intent handed the session to a live agent
+	Handoff bool
+	// Acknowledged is the stored exchange when the widget resent a message
+	// that was already answered; nothing else is set then
+	Acknowledged *models.ChatMessage
+}
+
+// save records the exchange, flagged if it came from a dry run
+func (m *widgetMessage) save(response string) {
+	chatMessage := newChatMessage(m.Project.ID, m.Message, response, m.SessionID, m.ClientIP, m.ChatUser, m.Decision.Action)
+	chatMessage.DryRun = m.DryRun
+	chatMessage.ClientMessageID = m.ClientMessageID
+	chatMessage.Citations = m.Citations
+	chatMessage.Clarifying = m.Clarifying
+	chatMessage.Fallback = m.Fallback
+	chatMessage.Intent = m.Intent
+	insertChatMessage(chatMessage)
+	if !m.DryRun && intentEscalation(m.Project, m.Intent) != nil {
+		go escalateIntent(m.Project, m.SessionID, m.Intent, m.Handoff)
+	}
+}
+
+// prepareWidgetMessage validates a widget message and runs it through rate
+// limiting, blocks, session binding, the monthly limit and moderation. When
+// it returns false a response has already been written. A message whose
+// client_message_id was already answered comes back with Acknowledged set
+// and should be answered from that record.
+func prepareWidgetMessage(c *gin.Context) (*widgetMessage, bool) {
 	projectID := c.Param("projectId")
 
 	clientIP := c.ClientIP()
@@ -298,37 +365,35 @@ func IframeSendMessage(c *gin.Context) {
 	objID, err := primitive.ObjectIDFromHex(projectID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
-		return
+		return nil, false
 	}
 
 	var messageData struct {
 		Message   string `json:"message"`
 		SessionID string `json:"session_id"`
 		UserToken string `json:"user_token"`
+		// Issued with the session's last answer; needed to continue it
+		// unless the session is bound to the signed-in chat user
+		HistoryToken string `json:"history_token"`
+		// Intent of the quick reply the visitor picked, if any
+		Intent string `json:"intent"`
+
+		ClientMessageID string `json:"client_message_id"`
 	}
 
 	if err := c.ShouldBindJSON(&messageData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message data"})
-		return
+		return nil, false
+	}
+	if messageData.ClientMessageID != "" && !validClientMessageID(messageData.ClientMessageID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client_message_id"})
+		return nil, false
 	}
 
 	// Enhanced rate limiting with proper response
-	if !checkRateLimit(clientIP) {
-		remaining := 0
-		if chatRateLimiter != nil {
-			remaining = chatRateLimiter.GetRemainingRequests(clientIP)
-		}
-
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Minute).Unix()))
-		c.Header("Retry-After", "60")
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"error":       "Rate limit exceeded",
-			"message":     "Too many requests. Please wait before sending another message.",
-			"retry_after": 60,
-			"remaining":   remaining,
-		})
-		return
+	if st := checkRateLimit(clientIP); !st.Allowed {
+		respondRateLimited(c, st, "chat", "Too many requests. Please wait before sending another message.")
+		return nil, false
 	}
 
 	// Get project details
@@ -337,13 +402,32 @@ func IframeSendMessage(c *gin.Context) {
 	err = collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
-		return
+		return nil, false
 	}
 
 	// Check if project is active
 	if !project.IsActive {
 		c.JSON(http.StatusForbidden, gin.H{"error": "This chat is currently unavailable"})
-		return
+		return nil, false
+	}
+
+	// A message resent after the widget lost its connection is answered once
+	if messageData.ClientMessageID != "" {
+		existing, err := findAcknowledged(context.Background(), objID, messageData.ClientMessageID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check message"})
+			return nil, false
+		}
+		if existing != nil {
+			return &widgetMessage{
+				Project:         project,
+				SessionID:       existing.SessionID,
+				Message:         existing.Message,
+				ClientIP:        clientIP,
+				ClientMessageID: existing.ClientMessageID,
+				Acknowledged:    existing,
+			}, true
+		}
 	}
 
 	// Check if Gemini is enabled
@@ -352,107 +436,258 @@ func IframeSendMessage(c *gin.Context) {
 			"error":  "AI responses are currently disabled for this project",
 			"status": "gemini_disabled",
 		})
-		return
+		return nil, false
+	}
+
+	// Sessions are bound to the signed-in chat user, if any
+	var chatUser models.ChatUser
+	if messageData.UserToken != "" {
+		chatUser, err = chatUserFromToken(c, project, messageData.UserToken)
+		if err != nil {
+			respondUserTokenError(c, err)
+			return nil, false
+		}
+	}
+
+	if isBlocked(objID, chatUser.ID, chatUser.Email, clientIP) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":    "Blocked",
+			"response": blockedReply,
+			"status":   "blocked",
+		})
+		return nil, false
+	}
+
+	messageData.SessionID, err = resolveChatSession(context.Background(), objID, messageData.SessionID,
+		sessionHistoryToken(c, messageData.HistoryToken), chatUser.ID, clientIP)
+	if err != nil {
+		respondSessionError(c, err)
+		return nil, false
 	}
 
 	// ✅ MAIN CHANGE: Check monthly usage limits with "Your limit has expired" message
-if project.GeminiUsageMonth >= project.GeminiMonthlyLimit {
+if !project.CanUseGemini() {
     time.Sleep(4 * time.Second) // Consistent delay
     
-    // ✅ NEW: Create limit expired notification
-    go CreateLimitExpiredNotification(
-        objID,
-        project.Name,
-        "monthly",
-        project.GeminiUsageMonth,
-        project.GeminiMonthlyLimit,
-    )
+    go emitLimitCrossed(project, messageData.SessionID, project.GeminiUsageMonth)
     
+    info := quotaLimitInfo(&project)
     c.JSON(http.StatusOK, gin.H{
         "response": "Your limit has expired.",
         "status": "monthly_limit_exceeded",
+        "code": info.Code,
+        "limit_info": info,
         "project_id": projectID,
+        "session_id": messageData.SessionID,
         "timestamp": time.Now().Format(time.RFC3339),
         "usage_info": gin.H{
             "monthly_usage": project.GeminiUsageMonth,
             "monthly_limit": project.GeminiMonthlyLimit,
-            "resets_at": getNextMonthlyReset(),
+            "resets_at": getNextMonthlyReset(&project),
+            "overage": overageInfo(&project),
         },
     })
-    return
+    return nil, false
+}
+
+	// Let the customer's moderation webhook gate the message
+	decision := moderateMessage(project, messageData.Message, messageData.SessionID, chatUser, clientIP)
+	switch decision.Action {
+	case ModerationModify:
+		messageData.Message = decision.Message
+	case ModerationReject:
+		reply := decision.rejectionReply()
+		chatMessage := newChatMessage(objID, messageData.Message, reply, messageData.SessionID, clientIP, chatUser, decision.Action)
+		chatMessage.ClientMessageID = messageData.ClientMessageID
+		insertChatMessage(chatMessage)
+		c.JSON(http.StatusOK, gin.H{
+			"response":   reply,
+			"project_id": projectID,
+			"session_id": messageData.SessionID,
+			"status":     "moderation_rejected",
+			"timestamp":  time.Now().Format(time.RFC3339),
+		})
+		return nil, false
+	}
+
+	msg := &widgetMessage{
+		Project:   project,
+		ChatUser:  chatUser,
+		SessionID: messageData.SessionID,
+		Message:   messageData.Message,
+		ClientIP:  clientIP,
+		Decision:  decision,
+		DryRun:    isDryRun(c, project),
+
+		ClientMessageID: messageData.ClientMessageID,
+	}
+	// Dry runs stand in for Gemini, so they are not classified either
+	if !msg.DryRun {
+		msg.Intent = resolveIntent(project, messageData.Intent, msg.Message)
+		msg.Project = routeIntent(project, msg.Intent)
+	}
+	return msg, true
 }
 
+// IframeSendMessage - For embed widget users with enhanced features
+func IframeSendMessage(c *gin.Context) {
+	msg, ok := prepareWidgetMessage(c)
+	if !ok {
+		return
+	}
+	project := msg.Project
+	objID := project.ID
+
+	if ack := msg.Acknowledged; ack != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"response":          ack.Response,
+			"project_id":        objID.Hex(),
+			"session_id":        ack.SessionID,
+			"status":            "success",
+			"duplicate":         true,
+			"client_message_id": ack.ClientMessageID,
+			"clarifying":        ack.Clarifying,
+			"citations":         ack.Citations,
+			"timestamp":         ack.Timestamp.Format(time.RFC3339),
+		})
+		return
+	}
+
 	// Generate AI response and update monthly counter
 	var response string
+	var limitErr *limitInfo
+	var fallback gin.H
 	time.Sleep(4 * time.Second) // Consistent delay
 
-	if isFirstMessage(objID, messageData.SessionID) {
+	if isFirstMessage(objID, msg.SessionID) {
 		response = project.WelcomeMessage
+	} else if msg.DryRun {
+		response = dryRunResponse()
+	} else if handoff, ok := intentHandoff(msg); ok {
+		// The intent needs a person, so the bot does not answer it
+		response = handoff
+	} else if answer, ok := answerOrderQuery(project, msg.Message, msg.SessionID, msg.ChatUser); ok {
+		// Order status comes from the customer's API and costs no quota
+		response = answer
+	} else if query, clarifying := clarifyMessage(msg); clarifying != "" {
+		response = clarifying
+		msg.Clarifying = true
 	} else if project.GeminiAPIKey != "" {
-		response, err = generateAIResponse(
-			messageData.Message,
-			project.PDFContent,
-			project.GeminiAPIKey,
-			project.Name,
-			project.GeminiModel,
-		)
-		if err != nil {
-			response = "I'm having trouble answering just now. Please try again later."
+		var err error
+		response, err = generateMeteredResponse(project, query, msg.SessionID, msg.ClientIP, msg.ChatUser)
+		if err == repository.ErrQuotaExceeded {
+			// Another chat used up the last of the quota since the check above
+			go emitLimitCrossed(project, msg.SessionID, project.GeminiMonthlyLimit)
+			response = "Your limit has expired."
+			info := quotaLimitInfo(&project)
+			limitErr = &info
+		} else if err != nil {
+			response, fallback = fallbackReply(msg, models.FallbackReasonError, "I'm having trouble answering just now. Please try again later.")
+		} else if project.Fallback != nil && isUnanswered(response) {
+			response, fallback = fallbackReply(msg, models.FallbackReasonUnanswered, response)
 		} else {
-			// Update monthly usage counter
-			go updateMonthlyGeminiUsage(objID)
+			msg.Citations = findCitations(project, query, response)
 		}
 	} else {
-		response = "AI configuration is incomplete. Please contact support."
+		response, fallback = fallbackReply(msg, models.FallbackReasonError, "AI configuration is incomplete. Please contact support.")
 	}
 
 	// Save message to database
-	saveMessage(objID, messageData.Message, response, messageData.SessionID, clientIP, models.ChatUser{})
+	msg.save(response)
+
+	if limitErr != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"response":   response,
+			"project_id": objID.Hex(),
+			"session_id": msg.SessionID,
+			"status":     "monthly_limit_exceeded",
+			"code":       limitErr.Code,
+			"limit_info": limitErr,
+			"timestamp":  time.Now().Format(time.RFC3339),
+		})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
+	reply := gin.H{
 		"response":   response,
-		"project_id": projectID,
+		"project_id": objID.Hex(),
+		"session_id": msg.SessionID,
 		"status":     "success",
+		"clarifying": msg.Clarifying,
+		"citations":  msg.Citations,
 		"timestamp":  time.Now().Format(time.RFC3339),
+
+		"history_token": historyToken(objID, msg.SessionID, time.Now()),
 		"usage_info": gin.H{
 			"monthly_usage":     project.GeminiUsageMonth + 1,
 			"monthly_limit":     project.GeminiMonthlyLimit,
 			"monthly_remaining": project.GeminiMonthlyLimit - project.GeminiUsageMonth - 1,
+			"overage":           overageInfo(&project),
 		},
-	})
+	}
+	if fallback != nil {
+		reply["status"] = "fallback"
+		reply["fallback"] = fallback
+	}
+	if msg.Handoff {
+		reply["status"] = "handoff"
+		reply["handoff_requested"] = true
+	}
+	if reducedData(c, &project) {
+		// Constrained widgets only need the answer
+		delete(reply, "usage_info")
+		reply["citations"] = trimCitations(msg.Citations)
+	}
+	c.JSON(http.StatusOK, reply)
 }
 
-// updateMonthlyGeminiUsage - Simplified usage update function
-func updateMonthlyGeminiUsage(projectID primitive.ObjectID) {
-	collection := config.DB.Collection("projects")
-	_, err := collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": projectID},
-		bson.M{
-			"$inc": bson.M{
-				"gemini_usage_month": 1, // ✅ FIXED: Use correct field name
-				"total_questions":    1,
-			},
-			"$set": bson.M{
-				"last_used":  time.Now(),
-				"updated_at": time.Now(),
-			},
-		},
-	)
+// generateMeteredResponse counts the request against the project's monthly
+// quota before asking Gemini, and refunds it if no answer comes back.
+// Answered requests are logged, flagged when they drew on overage.
+// Projects with a booking integration are answered with the booking tools.
+func generateMeteredResponse(project models.Project, message, sessionID, userIP string, user models.ChatUser) (string, error) {
+	overage, err := repository.ConsumeGeminiQuota(context.Background(), project.ID)
 	if err != nil {
-		fmt.Printf("Failed to update monthly Gemini usage: %v\n", err)
+		return "", err
+	}
+	if overage > 0 {
+		go notifyOverage(project, overage)
 	}
+
+	project.PDFContent = knowledgeContext(project, message)
+	project.PDFContent = withCatalogContext(project, message)
+
+	var response string
+	if project.Booking != nil {
+		response, err = generateBookingResponse(project, message, sessionID, user)
+	} else {
+		response, err = generateAIResponse(
+			message,
+			project.PDFContent,
+			project.GeminiAPIKey,
+			project.Name,
+			project.GeminiModel,
+			withFallbackRule(project),
+		)
+	}
+	if err != nil {
+		if rerr := repository.RefundGeminiQuota(context.Background(), project.ID, overage > 0); rerr != nil {
+			log.Printf("⚠️ Failed to refund Gemini quota for project %s: %v", project.ID.Hex(), rerr)
+		}
+		return "", err
+	}
+	go logGeminiUsage(project.ID, message, response, userIP, user, overage > 0)
+	return response, nil
 }
 
-func generateAIResponse(userMessage, pdfContent, geminiKey, projectName, geminiModel string) (string, error) {
+func generateAIResponse(userMessage, pdfContent, geminiKey, projectName, geminiModel, instructions string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(geminiKey))
+	client, err := config.GeminiClientFor(ctx, geminiKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to create Gemini client: %v", err)
+		return "", err
 	}
-	defer client.Close()
 
 	// ✅ UPDATED: Use gemini-2.0-flash as default
 	if geminiModel == "" {
@@ -464,10 +699,30 @@ func generateAIResponse(userMessage, pdfContent, geminiKey, projectName, geminiM
 	model.SetTopP(0.9)
 	model.SetTopK(40)
 
-	// Enhanced prompt with assistant identity and tone control
-	prompt := fmt.Sprintf(`
-You are the official support assistant for "%s". Always speak confidently and professionally **as if you are a real human assistant working at this company**.
+	prompt := buildSupportPrompt(projectName, instructions, pdfContent, userMessage)
 
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	config.ReportGeminiResult(geminiKey, err)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate content: %v", err)
+	}
+
+	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
+		return fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0]), nil
+	}
+
+	return "I'm sorry, I couldn't generate a response at the moment. Please try again.", nil
+}
+
+// buildSupportPrompt - Enhanced prompt with assistant identity and tone control.
+// The project's own instructions, if any, come before the document.
+func buildSupportPrompt(projectName, instructions, pdfContent, userMessage string) string {
+	if instructions != "" {
+		instructions = fmt.Sprintf("\nCOMPANY INSTRUCTIONS (follow these unless they conflict with the response rules):\n%s\n", instructions)
+	}
+	return fmt.Sprintf(`
+You are the official support assistant for "%s". Always speak confidently and professionally **as if you are a real human assistant working at this company**.
+%s
 DOCUMENT CONTEXT:
 %s
 
@@ -483,18 +738,7 @@ RESPONSE RULES:
 – Never say "based on the document" or "I am an AI assistant"
 – Reply like a human would, with confidence, care, and clear communication
 
-Answer:`, projectName, pdfContent, userMessage)
-
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
-	if err != nil {
-		return "", fmt.Errorf("failed to generate content: %v", err)
-	}
-
-	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-		return fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0]), nil
-	}
-
-	return "I'm sorry, I couldn't generate a response at the moment. Please try again.", nil
+Answer:`, projectName, instructions, pdfContent, userMessage)
 }
 
 // generateGeminiResponse - Enhanced response generation for embed users
@@ -502,11 +746,10 @@ func generateGeminiResponse(project models.Project, userMessage, userIP string,
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(project.GeminiAPIKey))
+	client, err := config.GeminiClientFor(ctx, project.GeminiAPIKey)
 	if err != nil {
 		return "", err
 	}
-	defer client.Close()
 
 	// Use specified model or default
 	modelName := project.GeminiModel
@@ -551,6 +794,7 @@ RESPONSE RULES:
 Your reply:`, project.Name, userContext, project.PDFContent, userMessage)
 
 	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	config.ReportGeminiResult(project.GeminiAPIKey, err)
 	if err != nil {
 		return "", err
 	}
@@ -559,7 +803,7 @@ Your reply:`, project.Name, userContext, project.PDFContent, userMessage)
 		response := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
 
 		// Log usage asynchronously
-		go logGeminiUsage(project.ID, userMessage, response, userIP, user)
+		go logGeminiUsage(project.ID, userMessage, response, userIP, user, false)
 
 		return response, nil
 	}
@@ -572,11 +816,10 @@ func generateGeminiResponseWithTracking(project models.Project, userMessage, use
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(project.GeminiAPIKey))
+	client, err := config.GeminiClientFor(ctx, project.GeminiAPIKey)
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("failed to create Gemini client: %v", err)
+		return "", 0, 0, err
 	}
-	defer client.Close()
 
 	// Use specified model or default
 	modelName := project.GeminiModel
@@ -619,6 +862,7 @@ GUIDELINES:
 Answer:`, project.Name, userContext, project.PDFContent, userMessage)
 
 	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	config.ReportGeminiResult(project.GeminiAPIKey, err)
 	if err != nil {
 		return "", 0, 0, fmt.Errorf("failed to generate content: %v", err)
 	}
@@ -638,30 +882,76 @@ Answer:`, project.Name, userContext, project.PDFContent, userMessage)
 
 // ===== CHAT HISTORY AND ANALYTICS =====
 
-// GetChatHistory - Retrieve chat history with enhanced filtering
+// GetChatHistory - Retrieve chat history with enhanced filtering.
+// ?file_id= (with optional &page_number=) or ?chunk_id= limits it to
+// answers citing that document, page or passage.
 func GetChatHistory(c *gin.Context) {
-	projectID := c.Param("id")
-	sessionID := c.Query("session_id")
-	limit := c.DefaultQuery("limit", "50")
-	page := c.DefaultQuery("page", "1")
-
-	objID, err := primitive.ObjectIDFromHex(projectID)
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
 		return
 	}
 
+	cited := bson.M{}
+	if fileID := c.Query("file_id"); fileID != "" {
+		cited["file_id"] = fileID
+		if p := c.Query("page_number"); p != "" {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "page_number must be a number"})
+				return
+			}
+			cited["page"] = n
+		}
+	}
+	if chunkID := c.Query("chunk_id"); chunkID != "" {
+		id, err := primitive.ObjectIDFromHex(chunkID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chunk ID"})
+			return
+		}
+		cited["chunk_id"] = id
+	}
+	var extra bson.M
+	if len(cited) > 0 {
+		extra = bson.M{"citations": bson.M{"$elemMatch": cited}}
+	}
+	chatHistory(c, objID, c.Query("session_id"), extra)
+}
+
+// chatHistory writes a page of a project's chat history, limited to one
+// session unless sessionID is empty and to messages matching extra, if set
+func chatHistory(c *gin.Context, objID primitive.ObjectID, sessionID string, extra bson.M) {
+	limit := c.DefaultQuery("limit", "50")
+	page := c.DefaultQuery("page", "1")
+
 	filter := bson.M{"project_id": objID}
 	if sessionID != "" {
 		filter["session_id"] = sessionID
 	}
+	for k, v := range extra {
+		filter[k] = v
+	}
+
+	// Constrained clients get a smaller page of slimmer messages
+	var project models.Project
+	config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"widget": 1})).Decode(&project)
+	reduced := reducedData(c, &project)
+	pageSize := int64(historyPageSize)
+	if reduced {
+		pageSize = reducedHistoryPageSize
+	}
 
 	// Pagination options
 	opts := options.Find().
 		SetSort(bson.D{{"timestamp", -1}}).
-		SetLimit(50) // Max 50 messages per request
+		SetLimit(pageSize) // Max 50 messages per request
+	if reduced {
+		opts.SetProjection(bson.M{"_id": 1, "session_id": 1, "message": 1, "response": 1, "is_user": 1, "timestamp": 1})
+	}
 
-	collection := config.DB.Collection("chat_messages")
+	collection := config.GetChatMessagesCollectionFor(objID)
 	cursor, err := collection.Find(context.Background(), filter, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat history"})
@@ -678,12 +968,16 @@ func GetChatHistory(c *gin.Context) {
 	// Get total count
 	totalCount, _ := collection.CountDocuments(context.Background(), filter)
 
+	if reduced {
+		limit = strconv.FormatInt(pageSize, 10)
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"messages":    messages,
-		"count":       len(messages),
-		"total_count": totalCount,
-		"page":        page,
-		"limit":       limit,
+		"messages":     messages,
+		"count":        len(messages),
+		"total_count":  totalCount,
+		"page":         page,
+		"limit":        limit,
+		"reduced_data": reduced,
 	})
 }
 
@@ -696,14 +990,32 @@ func GetChatAnalytics(c *gin.Context) {
 		return
 	}
 
-	collection := config.DB.Collection("chat_messages")
+	loc, err := analyticsLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := projectAnalyticsSummary(context.Background(), objID, loc, seriesDays(c, 7))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// projectAnalyticsSummary is a project's chat analytics: message and
+// session counts, a daily series of the given length, the fallback rate,
+// message volumes per intent and knowledge freshness. The dashboard and analytics share links show it.
+func projectAnalyticsSummary(ctx context.Context, objID primitive.ObjectID, loc *time.Location, days int) (gin.H, error) {
+	collection := config.GetChatMessagesCollectionFor(objID)
 
 	// Get total messages count
-	totalMessages, _ := collection.CountDocuments(context.Background(), bson.M{"project_id": objID})
+	totalMessages, _ := collection.CountDocuments(ctx, bson.M{"project_id": objID})
 
-	// Get messages from last 7 days
-	weekAgo := time.Now().AddDate(0, 0, -7)
-	recentMessages, _ := collection.CountDocuments(context.Background(), bson.M{
+	// Get messages from the last 7 local days, today included
+	weekAgo := startOfDay(time.Now(), loc).AddDate(0, 0, -6)
+	recentMessages, _ := collection.CountDocuments(ctx, bson.M{
 		"project_id": objID,
 		"timestamp":  bson.M{"$gte": weekAgo},
 	})
@@ -715,9 +1027,9 @@ func GetChatAnalytics(c *gin.Context) {
 		{"$count": "unique_sessions"},
 	}
 
-	cursor, _ := collection.Aggregate(context.Background(), pipeline)
+	cursor, _ := collection.Aggregate(ctx, pipeline)
 	var result []bson.M
-	cursor.All(context.Background(), &result)
+	cursor.All(ctx, &result)
 
 	uniqueSessions := int64(0)
 	if len(result) > 0 {
@@ -726,12 +1038,37 @@ func GetChatAnalytics(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"total_messages":  totalMessages,
-		"recent_messages": recentMessages,
-		"unique_sessions": uniqueSessions,
-		"period":          "last_7_days",
-	})
+	daily, err := dailySeries(ctx, collection, bson.M{"project_id": objID}, "timestamp", days, loc, nil)
+	if err != nil {
+		return nil, errors.New("Failed to load daily messages")
+	}
+
+	fallbackRate, err := fallbackStats(ctx, objID, weekAgo, recentMessages)
+	if err != nil {
+		return nil, errors.New("Failed to load fallback rate")
+	}
+
+	freshness, err := projectFreshness(ctx, objID)
+	if err != nil {
+		return nil, errors.New("Failed to load knowledge freshness")
+	}
+
+	intents, err := intentStats(ctx, objID, weekAgo)
+	if err != nil {
+		return nil, errors.New("Failed to load intent volumes")
+	}
+
+	return gin.H{
+		"total_messages":      totalMessages,
+		"recent_messages":     recentMessages,
+		"unique_sessions":     uniqueSessions,
+		"period":              "last_7_days",
+		"timezone":            loc.String(),
+		"daily":               daily,
+		"knowledge_freshness": freshness,
+		"fallback":            fallbackRate,
+		"intents":             intents,
+	}, nil
 }
 
 // ===== UTILITY FUNCTIONS =====
@@ -739,7 +1076,7 @@ func GetChatAnalytics(c *gin.Context) {
 // isFirstMessage returns true the very first time a given session_id
 // is seen for the project. It works by counting existing chat_messages.
 func isFirstMessage(projectID primitive.ObjectID, sessionID string) bool {
-	count, _ := config.DB.Collection("chat_messages").
+	count, _ := config.GetChatMessagesCollectionFor(projectID).
 		CountDocuments(context.Background(), bson.M{
 			"project_id": projectID,
 			"session_id": sessionID,
@@ -748,15 +1085,20 @@ func isFirstMessage(projectID primitive.ObjectID, sessionID string) bool {
 }
 
 // saveMessage - Save chat message with user context
-func saveMessage(projectID primitive.ObjectID, message, response, sessionID, userIP string, user models.ChatUser) {
+func saveMessage(projectID primitive.ObjectID, message, response, sessionID, userIP string, user models.ChatUser, moderationAction string) {
+	insertChatMessage(newChatMessage(projectID, message, response, sessionID, userIP, user, moderationAction))
+}
+
+func newChatMessage(projectID primitive.ObjectID, message, response, sessionID, userIP string, user models.ChatUser, moderationAction string) models.ChatMessage {
 	chatMessage := models.ChatMessage{
-		ProjectID: projectID,
-		SessionID: sessionID,
-		Message:   message,
-		Response:  response,
-		IsUser:    false,
-		Timestamp: time.Now(),
-		IPAddress: userIP,
+		ProjectID:        projectID,
+		SessionID:        sessionID,
+		Message:          message,
+		Response:         response,
+		IsUser:           false,
+		Timestamp:        time.Now(),
+		IPAddress:        userIP,
+		ModerationAction: moderationAction,
 	}
 
 	// Add user info if available
@@ -765,12 +1107,18 @@ func saveMessage(projectID primitive.ObjectID, message, response, sessionID, use
 		chatMessage.UserName = user.Name
 		chatMessage.UserEmail = user.Email
 	}
+	return chatMessage
+}
 
-	chatCollection := config.DB.Collection("chat_messages")
-	_, err := chatCollection.InsertOne(context.Background(), chatMessage)
+func insertChatMessage(chatMessage models.ChatMessage) {
+	chatCollection := config.GetChatMessagesCollectionFor(chatMessage.ProjectID)
+	result, err := chatCollection.InsertOne(context.Background(), chatMessage)
 	if err != nil {
 		fmt.Printf("Failed to save chat message: %v\n", err)
+		return
 	}
+	chatMessage.ID = result.InsertedID.(primitive.ObjectID)
+	go emitExchangeEvents(chatMessage)
 }
 
 // updateGeminiUsage - Update usage counters
@@ -789,14 +1137,17 @@ func updateGeminiUsage(projectID primitive.ObjectID) {
 	}
 }
 
-// logGeminiUsage - Log detailed usage information
-func logGeminiUsage(projectID primitive.ObjectID, question, response, userIP string, user models.ChatUser) {
+// logGeminiUsage - Log detailed usage information. overage marks a request
+// counted against the overage allowance, as ConsumeGeminiQuota reported.
+func logGeminiUsage(projectID primitive.ObjectID, question, response, userIP string, user models.ChatUser, overage bool) {
 	log := models.GeminiUsageLog{
 		ProjectID: projectID,
 		Question:  question,
 		Response:  response,
 		Timestamp: time.Now(),
 		UserIP:    userIP,
+		Success:   true,
+		Overage:   overage,
 	}
 
 	// Add user info if available
@@ -826,33 +1177,9 @@ func sanitizeInput(input string) string {
 }
 
 // checkRateLimit - Enhanced rate limiting with proper implementation
-func checkRateLimit(userIP string) bool {
-	// Initialize rate limiters if not already done
-	if chatRateLimiter == nil {
-		InitRateLimiters()
-	}
-
+func checkRateLimit(userIP string) rateLimitState {
 	// Use chat rate limiter for message endpoints
-	return chatRateLimiter.Allow(userIP)
-}
-
-// validateUserToken - Validate user authentication token
-func validateUserToken(token string) (string, error) {
-	// Simple token validation - implement proper JWT validation in production
-	if len(token) < 24 {
-		return "", fmt.Errorf("invalid token")
-	}
-
-	// Extract user ID from token (first 24 characters should be ObjectID)
-	userID := token[:24]
-
-	// Validate if it's a valid ObjectID
-	_, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		return "", fmt.Errorf("invalid user ID in token")
-	}
-
-	return userID, nil
+	return limiterFor("chat").Take(userIP)
 }
 
 // RateMessage - Allow users to rate responses
@@ -865,7 +1192,7 @@ func RateMessage(c *gin.Context) {
 	}
 
 	var rating struct {
-		Rating   int    `json:"rating"` // 1-5 stars
+		Rating   int    `json:"rating"` // 1-5 stars, or 1 and 5 for thumbs
 		Feedback string `json:"feedback"`
 	}
 
@@ -873,14 +1200,80 @@ func RateMessage(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rating data"})
 		return
 	}
+	rating.Feedback = strings.TrimSpace(rating.Feedback)
 
-	if rating.Rating < 1 || rating.Rating > 5 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be between 1 and 5"})
+	// The chat route names the project :projectId, the dashboard route :id
+	projectParam := c.Param("projectId")
+	if projectParam == "" {
+		projectParam = c.Param("id")
+	}
+	projectObjID, err := primitive.ObjectIDFromHex(projectParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
 		return
 	}
+	collection := config.GetChatMessagesCollectionFor(projectObjID)
+	var message models.ChatMessage
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID, "project_id": projectObjID}).Decode(&message); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": message.ProjectID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Enforce the project's rating settings
+	settings := ratingSettings(project)
+	switch settings.Scale {
+	case models.RatingScaleThumbs:
+		if rating.Rating != 1 && rating.Rating != 5 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be 1 (thumbs down) or 5 (thumbs up)"})
+			return
+		}
+	default:
+		if rating.Rating < 1 || rating.Rating > 5 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be between 1 and 5"})
+			return
+		}
+	}
+	if settings.RequireComment && rating.Feedback == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A comment is required with the rating"})
+		return
+	}
+	switch settings.AskWhen {
+	case models.RatingAskAfterN:
+		position, err := collection.CountDocuments(context.Background(), bson.M{
+			"project_id": message.ProjectID,
+			"session_id": message.SessionID,
+			"timestamp":  bson.M{"$lte": message.Timestamp},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rating"})
+			return
+		}
+		if position < int64(settings.AfterMessages) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ratings are not open yet in this conversation"})
+			return
+		}
+	case models.RatingAskSessionEnd:
+		later, err := collection.CountDocuments(context.Background(), bson.M{
+			"project_id": message.ProjectID,
+			"session_id": message.SessionID,
+			"timestamp":  bson.M{"$gt": message.Timestamp},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rating"})
+			return
+		}
+		if later > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only the last answer of a conversation can be rated"})
+			return
+		}
+	}
 
 	// Update message with rating
-	collection := config.DB.Collection("chat_messages")
 	_, err = collection.UpdateOne(
 		context.Background(),
 		bson.M{"_id": objID},
@@ -927,11 +1320,10 @@ func getNextDailyReset() string {
 	return tomorrow.Format(time.RFC3339)
 }
 
-// getNextMonthlyReset - Monthly reset helper
-func getNextMonthlyReset() string {
-	now := time.Now()
-	nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
-	return nextMonth.Format(time.RFC3339)
+// getNextMonthlyReset - End of the project's current billing period
+func getNextMonthlyReset(project *models.Project) string {
+	_, end := project.BillingPeriod(time.Now())
+	return end.Format(time.RFC3339)
 }
 
 // estimateTokens - Helper function to estimate token count
@@ -950,47 +1342,12 @@ func RateLimitMiddleware(limiterType string) gin.HandlerFunc {
 			return
 		}
 
-		clientIP := c.ClientIP()
-
-		// Initialize rate limiters if not already done
-		if chatRateLimiter == nil {
-			InitRateLimiters()
-		}
-
-		var allowed bool
-		var remaining int
-
-		switch limiterType {
-		case "chat":
-			allowed = chatRateLimiter.Allow(clientIP)
-			remaining = chatRateLimiter.GetRemainingRequests(clientIP)
-		case "auth":
-			allowed = authRateLimiter.Allow(clientIP)
-			remaining = authRateLimiter.GetRemainingRequests(clientIP)
-		case "general":
-			allowed = generalRateLimiter.Allow(clientIP)
-			remaining = generalRateLimiter.GetRemainingRequests(clientIP)
-		default:
-			allowed = generalRateLimiter.Allow(clientIP)
-			remaining = generalRateLimiter.GetRemainingRequests(clientIP)
-		}
-
-		// Add rate limit headers
-		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Minute).Unix()))
-
-		if !allowed {
-			c.Header("Retry-After", "60")
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "Rate limit exceeded",
-				"message":     "Too many requests. Please wait before trying again.",
-				"retry_after": 60,
-				"remaining":   0,
-				"limit_type":  limiterType,
-			})
-			c.Abort()
+		st := limiterFor(limiterType).Take(c.ClientIP())
+		if !st.Allowed {
+			respondRateLimited(c, st, limiterType, "Too many requests. Please wait before trying again.")
 			return
 		}
+		setRateLimitHeaders(c, st)
 
 		c.Next()
 	}
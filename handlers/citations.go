@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	maxCitations     = 3
+	minCitationTerms = 2
+	citationSnippet  = 200
+)
+
+// findCitations returns the passages of the project's documents that share
+// the most topic words with the question and its answer. Each citation
+// links to the page preview at the passage's offset.
+func findCitations(project models.Project, question, answer string) []models.Citation {
+	if len(project.PDFFiles) == 0 {
+		return nil
+	}
+	files := make(map[string]string, len(project.PDFFiles))
+	for _, f := range project.PDFFiles {
+		files[f.ID] = f.FileName
+	}
+
+	chunks, err := repository.SearchChunks(context.Background(), project.ID, question+"\n"+answer, minCitationTerms, maxCitations)
+	if err != nil {
+		log.Printf("⚠️ Citation search failed for project %s: %v", project.ID.Hex(), err)
+		return nil
+	}
+
+	var citations []models.Citation
+	for _, chunk := range chunks {
+		name, ok := files[chunk.FileID]
+		if !ok {
+			continue
+		}
+		snippet := chunk.Text
+		if r := []rune(snippet); len(r) > citationSnippet {
+			snippet = string(r[:citationSnippet]) + "..."
+		}
+		citations = append(citations, models.Citation{
+			ChunkID:  chunk.ID,
+			FileID:   chunk.FileID,
+			FileName: name,
+			Page:     chunk.Page,
+			Offset:   chunk.Offset,
+			Length:   chunk.Length,
+			Snippet:  snippet,
+			PreviewURL: fmt.Sprintf("/admin/projects/%s/pdf/%s/preview?page=%d&offset=%d&length=%d",
+				project.ID.Hex(), chunk.FileID, chunk.Page, chunk.Offset, chunk.Length),
+		})
+	}
+	return citations
+}
+
+// citedPassage is how often one passage was cited over a period
+type citedPassage struct {
+	FileID      string    `json:"file_id"`
+	FileName    string    `json:"file_name"`
+	Page        int       `json:"page"`
+	Offset      int       `json:"offset"`
+	Length      int       `json:"length"`
+	ChunkID     string    `json:"chunk_id,omitempty"`
+	Snippet     string    `json:"snippet"`
+	Answers     int       `json:"answers"`
+	LastCitedAt time.Time `json:"last_cited_at"`
+	PreviewURL  string    `json:"preview_url"`
+}
+
+// GetCitationAnalytics - GET /admin/projects/:id/analytics/citations lists
+// the document passages answers drew on most over the last ?days (default
+// 30), with the share of answers that cited any passage. The answers
+// citing a passage are at GET /api/projects/:id/chat/history?file_id=.
+func GetCitationAnalytics(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	days := seriesDays(c, 30)
+	since := time.Now().AddDate(0, 0, -days)
+
+	ctx := context.Background()
+	collection := config.GetChatMessagesCollectionFor(objID)
+	match := bson.M{"project_id": objID, "timestamp": bson.M{"$gte": since}}
+	answers, err := collection.CountDocuments(ctx, match)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load citation analytics"})
+		return
+	}
+	cited, err := collection.CountDocuments(ctx, bson.M{
+		"project_id":  objID,
+		"timestamp":   bson.M{"$gte": since},
+		"citations.0": bson.M{"$exists": true},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load citation analytics"})
+		return
+	}
+
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"project_id": objID, "timestamp": bson.M{"$gte": since}, "citations.0": bson.M{"$exists": true}}},
+		{"$unwind": "$citations"},
+		{"$sort": bson.M{"timestamp": 1}},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"file_id": "$citations.file_id",
+				"page":    "$citations.page",
+				"offset":  "$citations.offset",
+				"length":  "$citations.length",
+			},
+			"file_name":     bson.M{"$last": "$citations.file_name"},
+			"snippet":       bson.M{"$last": "$citations.snippet"},
+			"chunk_id":      bson.M{"$last": "$citations.chunk_id"},
+			"answers":       bson.M{"$sum": 1},
+			"last_cited_at": bson.M{"$last": "$timestamp"},
+		}},
+		{"$sort": bson.D{{Key: "answers", Value: -1}, {Key: "last_cited_at", Value: -1}}},
+		{"$limit": 20},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load citation analytics"})
+		return
+	}
+	var rows []struct {
+		Key struct {
+			FileID string `bson:"file_id"`
+			Page   int    `bson:"page"`
+			Offset int    `bson:"offset"`
+			Length int    `bson:"length"`
+		} `bson:"_id"`
+		FileName    string             `bson:"file_name"`
+		Snippet     string             `bson:"snippet"`
+		ChunkID     primitive.ObjectID `bson:"chunk_id"`
+		Answers     int                `bson:"answers"`
+		LastCitedAt time.Time          `bson:"last_cited_at"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load citation analytics"})
+		return
+	}
+
+	passages := make([]citedPassage, 0, len(rows))
+	for _, r := range rows {
+		p := citedPassage{
+			FileID:      r.Key.FileID,
+			FileName:    r.FileName,
+			Page:        r.Key.Page,
+			Offset:      r.Key.Offset,
+			Length:      r.Key.Length,
+			Snippet:     r.Snippet,
+			Answers:     r.Answers,
+			LastCitedAt: r.LastCitedAt,
+			PreviewURL: fmt.Sprintf("/admin/projects/%s/pdf/%s/preview?page=%d&offset=%d&length=%d",
+				projectID, r.Key.FileID, r.Key.Page, r.Key.Offset, r.Key.Length),
+		}
+		if !r.ChunkID.IsZero() {
+			p.ChunkID = r.ChunkID.Hex()
+		}
+		passages = append(passages, p)
+	}
+
+	rate := 0.0
+	if answers > 0 {
+		rate = float64(cited) / float64(answers)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":    projectID,
+		"days":          days,
+		"answers":       answers,
+		"cited_answers": cited,
+		"citation_rate": rate,
+		"top_passages":  passages,
+	})
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	// Questions whose best document passage holds less than this share of
+	// their topic words may get a clarifying question
+	minRetrievalConfidence = 0.5
+	// A clarifying question left unanswered this long is forgotten
+	clarificationTimeout  = 30 * time.Minute
+	maxClarifyingQuestion = 300
+)
+
+// clarifyMessage is the clarification step of the answer pipeline. It
+// returns the text to retrieve and answer with, or a clarifying question to
+// send instead of an answer. A reply to a clarifying question is combined
+// with the question it clarifies and is never clarified again, so each
+// question gets at most one round.
+func clarifyMessage(msg *widgetMessage) (query, clarifying string) {
+	project := msg.Project
+	ctx := context.Background()
+
+	if pending := takeClarification(ctx, project.ID, msg.SessionID); pending != nil {
+		return fmt.Sprintf("%s\n(Clarification: %s)", pending.OriginalMessage, msg.Message), ""
+	}
+
+	// Only document retrieval has a confidence to go by
+	if project.GeminiAPIKey == "" || project.Booking != nil || len(project.PDFFiles) == 0 {
+		return msg.Message, ""
+	}
+	confidence, err := repository.RetrievalConfidence(ctx, project.ID, msg.Message)
+	if err != nil {
+		log.Printf("⚠️ Retrieval confidence failed for project %s: %v", project.ID.Hex(), err)
+		return msg.Message, ""
+	}
+	if confidence >= minRetrievalConfidence {
+		return msg.Message, ""
+	}
+
+	question, err := generateClarifyingQuestion(project, msg.Message, msg.ClientIP, msg.ChatUser)
+	if err != nil || question == "" {
+		return msg.Message, ""
+	}
+	_, err = config.GetChatSessionsCollectionFor(project.ID).UpdateOne(ctx,
+		bson.M{"project_id": project.ID, "session_id": msg.SessionID},
+		bson.M{"$set": bson.M{"clarification": models.SessionClarification{
+			OriginalMessage: msg.Message,
+			Question:        question,
+			AskedAt:         time.Now(),
+		}}})
+	if err != nil {
+		// Without the session state the reply could not be combined
+		log.Printf("⚠️ Failed to save clarification for session %s: %v", msg.SessionID, err)
+		return msg.Message, ""
+	}
+	return msg.Message, question
+}
+
+// takeClarification removes and returns the session's pending clarifying
+// question, if it has not timed out
+func takeClarification(ctx context.Context, projectID primitive.ObjectID, sessionID string) *models.SessionClarification {
+	var session models.ChatSession
+	err := config.GetChatSessionsCollectionFor(projectID).FindOneAndUpdate(ctx,
+		bson.M{"project_id": projectID, "session_id": sessionID, "clarification": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"clarification": ""}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"clarification": 1}),
+	).Decode(&session)
+	if err != nil || session.Clarification == nil {
+		return nil
+	}
+	if time.Since(session.Clarification.AskedAt) > clarificationTimeout {
+		return nil
+	}
+	return session.Clarification
+}
+
+// generateClarifyingQuestion asks the model for one clarifying question.
+// It returns "" when the model finds the question clear enough to answer,
+// in which case the request is refunded; an asked question counts against
+// the monthly quota like an answer.
+func generateClarifyingQuestion(project models.Project, message, userIP string, user models.ChatUser) (string, error) {
+	overage, err := repository.ConsumeGeminiQuota(context.Background(), project.ID)
+	if err != nil {
+		return "", err
+	}
+	question, err := askClarifyingQuestion(project, message)
+	if err != nil || question == "" {
+		if rerr := repository.RefundGeminiQuota(context.Background(), project.ID, overage > 0); rerr != nil {
+			log.Printf("⚠️ Failed to refund Gemini quota for project %s: %v", project.ID.Hex(), rerr)
+		}
+		return "", err
+	}
+	if overage > 0 {
+		go notifyOverage(project, overage)
+	}
+	go logGeminiUsage(project.ID, message, question, userIP, user, overage > 0)
+	return question, nil
+}
+
+func askClarifyingQuestion(project models.Project, message string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	client, err := config.GeminiClientFor(ctx, project.GeminiAPIKey)
+	if err != nil {
+		return "", err
+	}
+	modelName := project.GeminiModel
+	if modelName == "" {
+		modelName = "gemini-2.0-flash"
+	}
+	model := client.GenerativeModel(modelName)
+	model.SetTemperature(0.2)
+
+	prompt := fmt.Sprintf(`You work with the support assistant for "%s". A visitor wrote:
+
+%s
+
+The company's documents do not clearly cover this. If the message is a question that is ambiguous or is missing a detail needed to answer it, reply with ONE short, friendly clarifying question to ask the visitor. Otherwise (the question is clear, it is small talk, or asking would not help), reply with exactly NONE.`, project.Name, message)
+
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	config.ReportGeminiResult(project.GeminiAPIKey, err)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return "", nil
+	}
+
+	question := strings.Trim(strings.TrimSpace(fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])), `"`)
+	if question == "" || strings.HasPrefix(strings.ToUpper(question), "NONE") || len([]rune(question)) > maxClarifyingQuestion {
+		return "", nil
+	}
+	return question, nil
+}
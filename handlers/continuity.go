@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// HeaderUserToken carries a widget sign-in token on requests without a body
+const HeaderUserToken = "X-User-Token"
+
+// maxListedSessions caps the sessions listed for one chat user
+const maxListedSessions = 50
+
+// continuityUser loads the project and the signed-in chat user for a
+// session continuity request, writing the error response itself. The token
+// comes from the X-User-Token header or ?user_token=.
+func continuityUser(c *gin.Context) (models.Project, models.ChatUser, bool) {
+	var project models.Project
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return project, models.ChatUser{}, false
+	}
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID, "is_active": true}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return project, models.ChatUser{}, false
+	}
+	if !project.SessionContinuity {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Session continuity is not enabled for this project", "code": "continuity_disabled"})
+		return project, models.ChatUser{}, false
+	}
+
+	token := c.GetHeader(HeaderUserToken)
+	if token == "" {
+		token = c.Query("user_token")
+	}
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User token required"})
+		return project, models.ChatUser{}, false
+	}
+	user, err := chatUserFromToken(c, project, token)
+	if err != nil {
+		respondUserTokenError(c, err)
+		return project, user, false
+	}
+	if isBlocked(objID, user.ID, user.Email, c.ClientIP()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": blockedReply, "status": "blocked"})
+		return project, user, false
+	}
+	return project, user, true
+}
+
+// ListChatUserSessions - GET /embed/:projectId/sessions lists the signed-in
+// chat user's sessions in the project, most recent first, each with a
+// history token so the widget can show and resume it on another device.
+// Users who hid their history get an empty list.
+func ListChatUserSessions(c *gin.Context) {
+	project, user, ok := continuityUser(c)
+	if !ok {
+		return
+	}
+	if user.HideSessionHistory {
+		c.JSON(http.StatusOK, gin.H{"sessions": []gin.H{}, "history_hidden": true})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > maxListedSessions {
+		limit = maxListedSessions
+	}
+
+	ctx := context.Background()
+	cursor, err := config.GetChatSessionsCollectionFor(project.ID).Find(ctx, bson.M{
+		"project_id":       project.ID,
+		"user_id":          user.ID,
+		"hidden_from_user": bson.M{"$ne": true},
+	}, options.Find().SetSort(bson.D{{"last_seen_at", -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+	var sessions []models.ChatSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	summaries, err := sessionSummaries(ctx, project.ID, sessions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	now := time.Now()
+	list := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		summary := summaries[s.SessionID]
+		list = append(list, gin.H{
+			"session_id":    s.SessionID,
+			"started_at":    s.StartTime,
+			"last_seen_at":  s.LastSeenAt,
+			"message_count": summary.Count,
+			"first_message": summary.FirstMessage,
+			"history_token": historyToken(project.ID, s.SessionID, now),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": list, "history_hidden": false})
+}
+
+// sessionSummary is what a session list shows of a session's messages
+type sessionSummary struct {
+	SessionID    string `bson:"_id"`
+	Count        int    `bson:"count"`
+	FirstMessage string `bson:"first_message"`
+}
+
+// sessionSummaries counts the messages of each session and picks the first
+// question asked, to label the session in the list
+func sessionSummaries(ctx context.Context, projectID primitive.ObjectID, sessions []models.ChatSession) (map[string]sessionSummary, error) {
+	summaries := make(map[string]sessionSummary, len(sessions))
+	if len(sessions) == 0 {
+		return summaries, nil
+	}
+	ids := make([]string, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.SessionID
+	}
+
+	cursor, err := config.GetChatMessagesCollectionFor(projectID).Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"project_id": projectID, "session_id": bson.M{"$in": ids}}},
+		{"$sort": bson.M{"timestamp": 1}},
+		{"$group": bson.M{
+			"_id":           "$session_id",
+			"count":         bson.M{"$sum": 1},
+			"first_message": bson.M{"$first": "$message"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []sessionSummary
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		summaries[r.SessionID] = r
+	}
+	return summaries, nil
+}
+
+// ResumeChatUserSession - POST /embed/:projectId/sessions/:sessionId/resume
+// continues one of the signed-in user's sessions on this device. It
+// returns a fresh history token; messages sent with the session ID and the
+// user token are added to the session. A new session is started with
+// POST /embed/:projectId/session as before.
+func ResumeChatUserSession(c *gin.Context) {
+	project, user, ok := continuityUser(c)
+	if !ok {
+		return
+	}
+	sessionID := c.Param("sessionId")
+
+	result, err := config.GetChatSessionsCollectionFor(project.ID).UpdateOne(context.Background(), bson.M{
+		"project_id":       project.ID,
+		"session_id":       sessionID,
+		"user_id":          user.ID,
+		"hidden_from_user": bson.M{"$ne": true},
+	}, bson.M{"$set": bson.M{"last_seen_at": time.Now(), "is_active": true}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume session"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"session_id":    sessionID,
+		"history_token": historyToken(project.ID, sessionID, time.Now()),
+	})
+}
+
+// HideChatUserSession - DELETE /embed/:projectId/sessions/:sessionId removes
+// a session from the signed-in user's list on every device. The messages
+// stay with the project and follow its retention settings.
+func HideChatUserSession(c *gin.Context) {
+	project, user, ok := continuityUser(c)
+	if !ok {
+		return
+	}
+
+	result, err := config.GetChatSessionsCollectionFor(project.ID).UpdateOne(context.Background(), bson.M{
+		"project_id": project.ID,
+		"session_id": c.Param("sessionId"),
+		"user_id":    user.ID,
+	}, bson.M{"$set": bson.M{"hidden_from_user": true}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hide session"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// SetSessionHistoryPrivacy - PUT /embed/:projectId/sessions/privacy sets
+// whether the signed-in user's earlier sessions are listed on other
+// devices. Hiding them does not delete them.
+func SetSessionHistoryPrivacy(c *gin.Context) {
+	_, user, ok := continuityUser(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		HideHistory *bool `json:"hide_history" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	_, err := config.GetChatUsersCollection().UpdateOne(context.Background(), bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"hide_session_history": *input.HideHistory}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update privacy settings"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "hide_history": *input.HideHistory})
+}
+
+// SetSessionContinuity - PUT /admin/projects/:id/session-continuity sets
+// whether signed-in chat users can list and resume their sessions from
+// other devices
+func SetSessionContinuity(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"session_continuity": *input.Enabled, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session continuity"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionContinuity, "project", projectID, objID, map[string]interface{}{
+		"enabled": *input.Enabled,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "session_continuity": *input.Enabled})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// duplicateMessage is what uploaders are told about a skipped copy
+const duplicateMessage = "duplicate detected"
+
+// contentHash is the hex SHA-256 of data
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uploadedFileHash hashes an uploaded file without keeping it in memory
+func uploadedFileHash(file *multipart.FileHeader) (string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resolveDuplicate looks for a document with the same content as an upload
+// to the project: first among the project's own files, then in its owner's
+// library. A library copy is attached to the project, so its passages and
+// embeddings serve the project without processing the upload again. It
+// returns nil when the upload is new.
+func resolveDuplicate(ctx context.Context, project models.Project, hash string) (*models.DocumentDuplicate, error) {
+	for _, f := range project.PDFFiles {
+		if f.ContentHash == hash && f.Status == "completed" {
+			return &models.DocumentDuplicate{Source: "file", ID: f.ID, FileName: f.FileName}, nil
+		}
+	}
+
+	filter := repository.LibraryOwnerFilter(repository.LibraryOwner(project))
+	filter["content_hash"] = hash
+	filter["status"] = "completed"
+	var doc models.LibraryDocument
+	err := config.GetLibraryDocumentsCollection().FindOne(ctx, filter).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dup := &models.DocumentDuplicate{Source: "library", ID: doc.ID.Hex(), FileName: doc.FileName}
+	for _, a := range project.LibraryDocs {
+		if a.DocumentID == doc.ID && a.Enabled {
+			return dup, nil
+		}
+	}
+	if err := repository.SetLibraryAttachment(ctx, project.ID, doc.ID, true); err != nil {
+		return nil, err
+	}
+	dup.Linked = true
+	return dup, nil
+}
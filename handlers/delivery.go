@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// Chat webhook deliveries are retried on transport errors, rate limits and
+// 5xx responses, waiting deliveryMinBackoff and doubling up to
+// deliveryMaxBackoff, or as long as the service's Retry-After asks
+const (
+	deliveryMaxAttempts = 4
+	deliveryMinBackoff  = time.Second
+	deliveryMaxBackoff  = 30 * time.Second
+)
+
+// hiddenMetadata are notification metadata keys not shown as details
+var hiddenMetadata = map[string]bool{
+	"severity":       true,
+	"auto_generated": true,
+	"timestamp":      true,
+	"alert_key":      true,
+	"project_name":   true,
+}
+
+// notificationDetail is one labelled detail shown beneath a notification's
+// message in chat services
+type notificationDetail struct {
+	Label string
+	Value string
+}
+
+// notificationDetails lists up to max details of a notification: usage
+// against the limit for limit-expired notifications, otherwise its scalar
+// metadata by key
+func notificationDetails(n models.Notification, max int) []notificationDetail {
+	var details []notificationDetail
+	if n.Type == models.NotificationTypeLimitExpired {
+		if t, ok := n.Metadata["limit_type"]; ok {
+			details = append(details, notificationDetail{"Limit", fmt.Sprint(t)})
+		}
+		if limit, ok := n.Metadata["limit"]; ok {
+			details = append(details, notificationDetail{"Usage", fmt.Sprintf("%v / %v", n.Metadata["current_usage"], limit)})
+		}
+		return details
+	}
+
+	keys := make([]string, 0, len(n.Metadata))
+	for k, v := range n.Metadata {
+		switch v.(type) {
+		case string, bool, int, int32, int64, float64:
+			if !hiddenMetadata[k] {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(details) == max {
+			break
+		}
+		label := strings.ToUpper(k[:1]) + strings.ReplaceAll(k[1:], "_", " ")
+		details = append(details, notificationDetail{label, fmt.Sprint(n.Metadata[k])})
+	}
+	return details
+}
+
+// webhookError is a non-2xx response from a chat service's webhook
+type webhookError struct {
+	Service    string
+	StatusCode int
+	RetryAfter time.Duration
+	Detail     string
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("%s returned %d: %s", e.Service, e.StatusCode, e.Detail)
+}
+
+// postWebhookJSON posts a JSON payload to a webhook of the named service
+func postWebhookJSON(ctx context.Context, service, webhookURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := integrationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &webhookError{
+			Service:    service,
+			StatusCode: resp.StatusCode,
+			RetryAfter: time.Duration(retryAfter) * time.Second,
+			Detail:     strings.TrimSpace(string(detail)),
+		}
+	}
+	return nil
+}
+
+// deliverWithRetry runs post until it succeeds or fails in a way retrying
+// won't fix. It returns the number of attempts made.
+func deliverWithRetry(ctx context.Context, post func(context.Context) error) (int, error) {
+	backoff := deliveryMinBackoff
+	for attempt := 1; ; attempt++ {
+		err := post(ctx)
+		if err == nil {
+			return attempt, nil
+		}
+		wait := backoff
+		var we *webhookError
+		if errors.As(err, &we) {
+			// Bad payloads, revoked webhooks and deleted channels don't recover
+			if we.StatusCode != http.StatusTooManyRequests && we.StatusCode < 500 {
+				return attempt, err
+			}
+			if we.RetryAfter > wait {
+				wait = we.RetryAfter
+			}
+		}
+		if attempt >= deliveryMaxAttempts || wait > deliveryMaxBackoff {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// recordDeliveryFailure logs a notification the named service could not be
+// sent, and keeps the failure on the notification so it shows in the
+// dashboard
+func recordDeliveryFailure(n models.Notification, service string, attempts int, err error) {
+	log.Printf("⚠️ Failed to post %s notification %s to %s after %d attempt(s): %v", n.Type, n.ID.Hex(), service, attempts, err)
+	if n.ID.IsZero() {
+		return
+	}
+	config.GetNotificationsCollection().UpdateOne(context.Background(), bson.M{"_id": n.ID}, bson.M{
+		"$set": bson.M{
+			"metadata." + service + "_error":     err.Error(),
+			"metadata." + service + "_attempts":  attempts,
+			"metadata." + service + "_failed_at": time.Now(),
+		},
+	})
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// globalDiscordTypes are the notification types posted to the server-wide
+// DISCORD_WEBHOOK_URL for projects without Discord routing of their own
+var globalDiscordTypes = map[string]bool{
+	models.NotificationTypeLimitExpired: true,
+	models.NotificationTypeError:        true,
+	models.NotificationTypeWarning:      true,
+}
+
+// discordSeverityColors tint the side of a notification's embed
+var discordSeverityColors = map[string]int{
+	models.NotificationSeverityInfo:    0x667eea,
+	models.NotificationSeverityWarning: 0xed8936,
+	models.NotificationSeverityError:   0xe53e3e,
+}
+
+// discordDestination is where a notification is posted
+type discordDestination struct {
+	WebhookURL  string
+	Channel     string
+	ProjectName string
+}
+
+// discordDestinationFor picks the webhook for a notification the same way
+// slackDestinationFor does: the project's route for its type, then its
+// default webhook, then, for projects without routing, the server-wide
+// webhook
+func discordDestinationFor(ctx context.Context, n models.Notification) (dest discordDestination, ok bool, err error) {
+	if !n.ProjectID.IsZero() {
+		var project models.Project
+		err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": n.ProjectID},
+			options.FindOne().SetProjection(bson.M{"discord": 1, "name": 1}),
+		).Decode(&project)
+		if err == nil && project.Discord != nil {
+			encrypted, channel := project.Discord.EncryptedWebhook, project.Discord.Channel
+			for _, route := range project.Discord.Routes {
+				if containsString(route.Types, n.Type) {
+					encrypted, channel = route.EncryptedWebhook, route.Channel
+					break
+				}
+			}
+			if encrypted == "" {
+				return dest, false, nil
+			}
+			webhook, err := config.DecryptSecret(encrypted)
+			if err != nil {
+				return dest, false, err
+			}
+			return discordDestination{WebhookURL: webhook, Channel: channel, ProjectName: project.Name}, true, nil
+		}
+	}
+
+	if config.NotificationSettings == nil || config.NotificationSettings.DiscordWebhookURL == "" || !globalDiscordTypes[n.Type] {
+		return dest, false, nil
+	}
+	name, _ := n.Metadata["project_name"].(string)
+	return discordDestination{WebhookURL: config.NotificationSettings.DiscordWebhookURL, ProjectName: name}, true, nil
+}
+
+// discordPayload formats a notification as a message with one embed,
+// colored by severity, with the notification's details as inline fields
+func discordPayload(n models.Notification, dest discordDestination) map[string]interface{} {
+	severity := n.Severity
+	if severity == "" {
+		severity = models.NotificationSeverity(n.Type)
+	}
+	color, ok := discordSeverityColors[severity]
+	if !ok {
+		color = discordSeverityColors[models.NotificationSeverityInfo]
+	}
+	created := n.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	embed := map[string]interface{}{
+		"title":       slackText(n.Title, 256),
+		"description": slackText(n.Message, 4096),
+		"color":       color,
+		"timestamp":   created.UTC().Format(time.RFC3339),
+	}
+	var fields []map[string]interface{}
+	for _, d := range notificationDetails(n, 25) { // Discord's limit per embed
+		fields = append(fields, map[string]interface{}{
+			"name":   slackText(d.Label, 256),
+			"value":  slackText(d.Value, 1024),
+			"inline": true,
+		})
+	}
+	if len(fields) > 0 {
+		embed["fields"] = fields
+	}
+	footer := strings.ReplaceAll(n.Type, "_", " ")
+	if dest.ProjectName != "" {
+		footer = dest.ProjectName + " • " + footer
+	}
+	embed["footer"] = map[string]interface{}{"text": slackText(footer, 2048)}
+
+	return map[string]interface{}{
+		"embeds": []map[string]interface{}{embed},
+		// Notifications never ping anyone
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	}
+}
+
+// postDiscordMessage sends a message payload to a channel webhook
+func postDiscordMessage(ctx context.Context, webhookURL string, payload interface{}) error {
+	return postWebhookJSON(ctx, "discord", webhookURL, payload)
+}
+
+// sendDiscordNotification posts a notification to Discord, where the
+// project's routing, or the server-wide webhook, sends it
+func sendDiscordNotification(n models.Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	dest, ok, err := discordDestinationFor(ctx, n)
+	if err != nil {
+		log.Printf("⚠️ Discord routing for project %s unavailable: %v", n.ProjectID.Hex(), err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	payload := discordPayload(n, dest)
+	attempts, err := deliverWithRetry(ctx, func(ctx context.Context) error {
+		return postDiscordMessage(ctx, dest.WebhookURL, payload)
+	})
+	if err != nil {
+		recordDeliveryFailure(n, "discord", attempts, err)
+	}
+}
+
+// validDiscordWebhook accepts Discord channel webhook URLs
+func validDiscordWebhook(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || !strings.HasPrefix(u.Path, "/api/webhooks/") {
+		return false
+	}
+	switch u.Host {
+	case "discord.com", "discordapp.com", "ptb.discord.com", "canary.discord.com":
+		return true
+	}
+	return false
+}
+
+// SetDiscordRouting - PUT /admin/projects/:id/discord sets where the
+// project's notifications are posted on Discord, like SetSlackRouting: a
+// default webhook_url, and routes sending some notification types to other
+// channels. A webhook URL left out keeps the one stored for the default or
+// for the route's channel.
+func SetDiscordRouting(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		WebhookURL string `json:"webhook_url"`
+		Channel    string `json:"channel" binding:"max=100"`
+		Routes     []struct {
+			Types      []string `json:"types" binding:"required,min=1,dive,oneof=limit_expired success warning error info security"`
+			Channel    string   `json:"channel" binding:"required,max=100"`
+			WebhookURL string   `json:"webhook_url"`
+		} `json:"routes" binding:"max=20,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	collection := config.GetProjectsCollection()
+	var project models.Project
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	existing := project.Discord
+	if existing == nil {
+		existing = &models.DiscordRouting{}
+	}
+
+	// seal encrypts a new webhook URL, or keeps the stored one
+	seal := func(webhookURL, stored string) (string, bool) {
+		if webhookURL == "" {
+			return stored, true
+		}
+		if !validDiscordWebhook(webhookURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "webhook_url must be a Discord webhook URL (https://discord.com/api/webhooks/...)"})
+			return "", false
+		}
+		encrypted, err := config.EncryptSecret(webhookURL)
+		if err == config.ErrSecretsKeyMissing {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Credential encryption is not configured on this server"})
+			return "", false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store webhook URL"})
+			return "", false
+		}
+		return encrypted, true
+	}
+
+	routing := models.DiscordRouting{
+		Channel:   strings.TrimSpace(input.Channel),
+		UpdatedAt: time.Now(),
+	}
+	var ok bool
+	if routing.EncryptedWebhook, ok = seal(input.WebhookURL, existing.EncryptedWebhook); !ok {
+		return
+	}
+
+	routed := map[string]string{}
+	for _, r := range input.Routes {
+		channel := strings.TrimSpace(r.Channel)
+		stored := ""
+		for _, old := range existing.Routes {
+			if old.Channel == channel {
+				stored = old.EncryptedWebhook
+			}
+		}
+		route := models.DiscordRoute{Types: r.Types, Channel: channel}
+		if route.EncryptedWebhook, ok = seal(r.WebhookURL, stored); !ok {
+			return
+		}
+		if route.EncryptedWebhook == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "webhook_url is required for channel " + channel})
+			return
+		}
+		for _, t := range r.Types {
+			if other, dup := routed[t]; dup {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s notifications are routed to both %s and %s", t, other, channel)})
+				return
+			}
+			routed[t] = channel
+		}
+		routing.Routes = append(routing.Routes, route)
+	}
+
+	update := bson.M{"$set": bson.M{"discord": routing, "updated_at": time.Now()}}
+	if routing.EncryptedWebhook == "" && len(routing.Routes) == 0 {
+		update = bson.M{"$unset": bson.M{"discord": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update Discord routing"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionDiscordUpdate, "project", projectID, objID, map[string]interface{}{
+		"channel":         routing.Channel,
+		"routes":          routed,
+		"webhook_changed": input.WebhookURL != "",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "discord": routing})
+}
+
+// DeleteDiscordRouting - DELETE /admin/projects/:id/discord removes the
+// project's Discord routing; its notifications fall back to the
+// server-wide webhook
+func DeleteDiscordRouting(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$unset": bson.M{"discord": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove Discord routing"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionDiscordUpdate, "project", projectID, objID, map[string]interface{}{
+		"removed": true,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "discord": nil})
+}
+
+// TestDiscordRouting - POST /admin/projects/:id/discord/test posts a test
+// message for a notification type (default info) to the channel it routes
+// to, and reports the result
+func TestDiscordRouting(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	notificationType := c.DefaultQuery("type", models.NotificationTypeInfo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	test := models.Notification{ProjectID: objID, Type: notificationType}
+	dest, ok, err := discordDestinationFor(ctx, test)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Discord routing unavailable", "details": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"success": false, "type": notificationType, "message": "Notifications of this type are not posted to Discord"})
+		return
+	}
+
+	test.Title = "Test notification"
+	test.Message = strings.ReplaceAll(notificationType, "_", " ") + " notifications for this project are posted here."
+	test.Severity = models.NotificationSeverity(notificationType)
+	if err := postDiscordMessage(ctx, dest.WebhookURL, discordPayload(test, dest)); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Discord rejected the test message", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "type": notificationType, "channel": dest.Channel})
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/middleware"
+	"jevi-chat/models"
+	"jevi-chat/utils"
+)
+
+var (
+	metaTagPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttrPattern = regexp.MustCompile(`(?is)\b(name|content)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// verificationClient fetches home pages looking for a verification meta
+// tag. Customers choose the domains, so only public addresses are dialled. Redirects are followed within the domain being verified, so
+// example.com may send the check on to www.example.com.
+var verificationClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext:           utils.DialPublic,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		domain := via[0].URL.Hostname()
+		if req.URL.Scheme != "https" || !domainCovers(domain, req.URL.Hostname()) {
+			return fmt.Errorf("redirected away from %s", domain)
+		}
+		return nil
+	},
+}
+
+// domainCovers reports whether host is domain or one of its subdomains
+func domainCovers(domain, host string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// allowlistHost is the hostname an allowlist entry applies to, without any
+// wildcard or port
+func allowlistHost(entry string) string {
+	host := strings.TrimPrefix(entry, "*.")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// devHost reports whether an allowlist entry is a development host, which
+// can be allowlisted without verification
+func devHost(entry string) bool {
+	host := allowlistHost(entry)
+	return host == "localhost" || strings.HasSuffix(host, ".localhost") || net.ParseIP(host) != nil
+}
+
+// domainVerified reports whether an allowlist entry is covered by one of
+// the project's verified domains
+func domainVerified(verifications []models.DomainVerification, entry string) bool {
+	host := allowlistHost(entry)
+	for _, v := range verifications {
+		if v.Status == models.DomainVerified && domainCovers(v.Domain, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// verificationDomain validates a domain to be verified: a plain hostname
+// with no wildcard or port
+func verificationDomain(input string) (string, error) {
+	domain, err := middleware.NormalizeDomain(input)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(domain, "*.") || strings.Contains(domain, ":") {
+		return "", fmt.Errorf("verify %q without a wildcard or port; its subdomains are covered", input)
+	}
+	if devHost(domain) || !strings.Contains(domain, ".") {
+		return "", fmt.Errorf("%q is not a public domain", input)
+	}
+	return domain, nil
+}
+
+// verificationInstructions tells the owner how to prove control of the domain
+func verificationInstructions(v models.DomainVerification) gin.H {
+	return gin.H{
+		"meta": gin.H{
+			"url": "https://" + v.Domain + "/",
+			"tag": fmt.Sprintf(`<meta name="%s" content="%s">`, models.DomainVerificationName, v.Token),
+		},
+		"dns": gin.H{
+			"type":  "TXT",
+			"name":  v.Domain,
+			"value": models.DomainVerificationName + "=" + v.Token,
+		},
+	}
+}
+
+// domainVerificationView is a verification as listed to the owner, with
+// the instructions while it is not verified yet
+func domainVerificationView(v models.DomainVerification) gin.H {
+	view := gin.H{"verification": v}
+	if v.Status != models.DomainVerified {
+		view["instructions"] = verificationInstructions(v)
+	}
+	return view
+}
+
+// checkMetaTag looks for the token in a meta tag on the domain's home page
+func checkMetaTag(ctx context.Context, domain, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+domain+"/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "JeviDomainVerification/1.0")
+	resp, err := verificationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not load https://%s/: %v", domain, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("https://%s/ returned HTTP %d", domain, resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	for _, tag := range metaTagPattern.FindAllString(string(body), -1) {
+		var name, content string
+		for _, attr := range metaAttrPattern.FindAllStringSubmatch(tag, -1) {
+			value := attr[2] + attr[3]
+			if strings.EqualFold(attr[1], "name") {
+				name = value
+			} else {
+				content = value
+			}
+		}
+		if strings.EqualFold(name, models.DomainVerificationName) && strings.TrimSpace(content) == token {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s meta tag with the token on https://%s/", models.DomainVerificationName, domain)
+}
+
+// checkDNSRecord looks for the token in the domain's TXT records
+func checkDNSRecord(ctx context.Context, domain, token string) error {
+	records, err := net.DefaultResolver.LookupTXT(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("could not look up TXT records of %s: %v", domain, err)
+	}
+	want := models.DomainVerificationName + "=" + token
+	for _, record := range records {
+		if strings.TrimSpace(record) == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("no TXT record %q on %s", want, domain)
+}
+
+// AddDomain - POST /admin/projects/:id/domains starts verification of a
+// domain the owner wants to allowlist. Adding a domain that is already
+// listed returns its current verification.
+func AddDomain(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Domain string `json:"domain" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	domain, err := verificationDomain(input.Domain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain", "details": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"domain_verifications": 1})).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	for _, v := range project.DomainVerifications {
+		if v.Domain == domain {
+			c.JSON(http.StatusOK, domainVerificationView(v))
+			return
+		}
+	}
+	if len(project.DomainVerifications) >= 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At most 100 domains are allowed"})
+		return
+	}
+
+	verification := models.DomainVerification{
+		Domain:    domain,
+		Token:     randomHex(16),
+		Status:    models.DomainPending,
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: time.Now(),
+	}
+	result, err := config.GetProjectsCollection().UpdateOne(ctx,
+		bson.M{"_id": objID, "domain_verifications.domain": bson.M{"$ne": domain}},
+		bson.M{
+			"$push": bson.M{"domain_verifications": verification},
+			"$set":  bson.M{"updated_at": time.Now()},
+		})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add domain"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Domain was added by another request"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionDomainAdd, "project", projectID, objID, map[string]interface{}{
+		"domain": domain,
+	})
+
+	c.JSON(http.StatusCreated, domainVerificationView(verification))
+}
+
+// ListDomains - GET /admin/projects/:id/domains lists the project's domain
+// verifications and its current allowlist
+func ListDomains(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"domain_verifications": 1, "allowed_domains": 1})).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	domains := []gin.H{}
+	for _, v := range project.DomainVerifications {
+		domains = append(domains, domainVerificationView(v))
+	}
+	allowed := project.AllowedDomains
+	if allowed == nil {
+		allowed = []string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":      projectID,
+		"domains":         domains,
+		"allowed_domains": allowed,
+	})
+}
+
+// VerifyDomain - POST /admin/projects/:id/domains/:domain/verify checks for
+// the verification token, with ?method=meta or ?method=dns or else both.
+// A verified domain is added to the embed allowlist.
+func VerifyDomain(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	domain := strings.ToLower(c.Param("domain"))
+	method := c.Query("method")
+	if method != "" && method != models.DomainMethodMeta && method != models.DomainMethodDNS {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "method must be meta or dns"})
+		return
+	}
+
+	ctx := context.Background()
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"domain_verifications": 1})).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	var verification *models.DomainVerification
+	for i := range project.DomainVerifications {
+		if project.DomainVerifications[i].Domain == domain {
+			verification = &project.DomainVerifications[i]
+			break
+		}
+	}
+	if verification == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found; add it first"})
+		return
+	}
+	if verification.Status == models.DomainVerified {
+		c.JSON(http.StatusOK, domainVerificationView(*verification))
+		return
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+	var checkErrs []string
+	for _, m := range []string{models.DomainMethodDNS, models.DomainMethodMeta} {
+		if method != "" && m != method {
+			continue
+		}
+		check := checkDNSRecord
+		if m == models.DomainMethodMeta {
+			check = checkMetaTag
+		}
+		if err := check(checkCtx, domain, verification.Token); err != nil {
+			checkErrs = append(checkErrs, err.Error())
+			continue
+		}
+		method = m
+		checkErrs = nil
+		break
+	}
+
+	now := time.Now()
+	verification.Attempts++
+	verification.LastCheckedAt = now
+	set := bson.M{
+		"domain_verifications.$.last_checked_at": now,
+		"updated_at":                             now,
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"domain_verifications.$.attempts": 1}}
+	if len(checkErrs) > 0 {
+		verification.Status = models.DomainFailed
+		verification.LastError = strings.Join(checkErrs, "; ")
+		set["domain_verifications.$.status"] = verification.Status
+		set["domain_verifications.$.last_error"] = verification.LastError
+	} else {
+		verification.Status = models.DomainVerified
+		verification.Method = method
+		verification.VerifiedAt = now
+		verification.LastError = ""
+		set["domain_verifications.$.status"] = verification.Status
+		set["domain_verifications.$.method"] = method
+		set["domain_verifications.$.verified_at"] = now
+		update["$unset"] = bson.M{"domain_verifications.$.last_error": ""}
+		update["$addToSet"] = bson.M{"allowed_domains": domain}
+	}
+
+	_, err = config.GetProjectsCollection().UpdateOne(ctx, bson.M{
+		"_id":                         objID,
+		"domain_verifications.domain": domain,
+	}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save verification"})
+		return
+	}
+
+	if verification.Status != models.DomainVerified {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":        "Domain could not be verified",
+			"code":         "domain_unverified",
+			"details":      verification.LastError,
+			"verification": verification,
+			"instructions": verificationInstructions(*verification),
+		})
+		return
+	}
+
+	recordAudit(c, models.AuditActionDomainVerify, "project", projectID, objID, map[string]interface{}{
+		"domain": domain,
+		"method": method,
+	})
+
+	c.JSON(http.StatusOK, domainVerificationView(*verification))
+}
+
+// RemoveDomain - DELETE /admin/projects/:id/domains/:domain drops a domain
+// with its verification, and takes it and its subdomains off the allowlist
+func RemoveDomain(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	domain := strings.ToLower(c.Param("domain"))
+
+	ctx := context.Background()
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(ctx,
+		bson.M{"_id": objID, "domain_verifications.domain": domain},
+		options.FindOne().SetProjection(bson.M{"allowed_domains": 1})).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project"})
+		return
+	}
+
+	allowed := []string{}
+	for _, entry := range project.AllowedDomains {
+		if !domainCovers(domain, allowlistHost(entry)) {
+			allowed = append(allowed, entry)
+		}
+	}
+	update := bson.M{
+		"$pull": bson.M{"domain_verifications": bson.M{"domain": domain}},
+		"$set":  bson.M{"allowed_domains": allowed, "updated_at": time.Now()},
+	}
+	if len(allowed) == 0 {
+		update["$set"] = bson.M{"updated_at": time.Now()}
+		update["$unset"] = bson.M{"allowed_domains": ""}
+	}
+	if _, err := config.GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": objID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove domain"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionDomainRemove, "project", projectID, objID, map[string]interface{}{
+		"domain":          domain,
+		"allowed_domains": allowed,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "allowed_domains": allowed})
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// HeaderDryRun carries a project's dry-run key. Widget messages sent with
+// it go through rate limiting, blocks, sessions, moderation and storage as
+// usual, but Gemini is never called and no quota is used.
+const HeaderDryRun = "X-Jevi-Dry-Run"
+
+const dryRunReply = "This is a dry-run reply. No AI model was called for this message, " +
+	"which is expected while load testing. Real visitors receive a generated answer here."
+
+// isDryRun reports whether the request presents the project's dry-run key
+func isDryRun(c *gin.Context, project models.Project) bool {
+	key := c.GetHeader(HeaderDryRun)
+	if key == "" || project.DryRunKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(project.DryRunKey)) == 1
+}
+
+// dryRunLatency is the simulated Gemini response time: DRY_RUN_LATENCY
+// (default 1.5s) with ±20% jitter
+func dryRunLatency() time.Duration {
+	base, err := time.ParseDuration(os.Getenv("DRY_RUN_LATENCY"))
+	if err != nil || base < 0 {
+		base = 1500 * time.Millisecond
+	}
+	if base == 0 {
+		return 0
+	}
+	jitter := time.Duration(rand.Int63n(int64(base)*2/5+1)) - base/5
+	return base + jitter
+}
+
+// dryRunResponse stands in for generateMeteredResponse
+func dryRunResponse() string {
+	time.Sleep(dryRunLatency())
+	return dryRunReply
+}
+
+// streamDryRun stands in for the Gemini stream, sending the canned reply a
+// few words at a time over the simulated latency
+func streamDryRun(c *gin.Context, msg *widgetMessage) {
+	words := strings.SplitAfter(dryRunReply, " ")
+	const chunkWords = 4
+	chunks := (len(words) + chunkWords - 1) / chunkWords
+	pause := dryRunLatency() / time.Duration(chunks)
+
+	for i := 0; i < len(words); i += chunkWords {
+		end := i + chunkWords
+		if end > len(words) {
+			end = len(words)
+		}
+		select {
+		case <-time.After(pause):
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.SSEvent("chunk", gin.H{"text": strings.Join(words[i:end], "")})
+		c.Writer.Flush()
+	}
+
+	msg.save(dryRunReply)
+	c.SSEvent("done", gin.H{
+		"session_id": msg.SessionID,
+		"status":     "success",
+		"dry_run":    true,
+	})
+}
+
+// SetDryRunKey - PUT /admin/projects/:id/dry-run issues, rotates or revokes
+// the key load tests use to run widget chats without calling Gemini
+func SetDryRunKey(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$unset": bson.M{"dry_run_key": ""},
+	}
+	response := gin.H{
+		"success":    true,
+		"project_id": projectID,
+		"enabled":    *input.Enabled,
+	}
+	if *input.Enabled {
+		key := repository.NewDryRunKey()
+		update = bson.M{"$set": bson.M{"dry_run_key": key, "updated_at": time.Now()}}
+		response["dry_run_key"] = key
+		response["header"] = HeaderDryRun
+		response["message"] = "Send this key in the " + HeaderDryRun + " header; any previous key stops working"
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update dry-run settings"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionDryRunUpdate, "project", projectID, objID, map[string]interface{}{
+		"enabled": *input.Enabled,
+	})
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// notificationEmail is the HTML body of notification emails. Styles are
+// inline because most mail clients drop style sheets.
+var notificationEmail = template.Must(template.New("notification").Parse(`<!DOCTYPE html>
+<html>
+<body style="margin:0;padding:24px;background:#f4f5f7;font-family:Helvetica,Arial,sans-serif;color:#2d3748">
+  <table role="presentation" width="100%" cellspacing="0" cellpadding="0" style="max-width:560px;margin:0 auto;background:#ffffff;border-radius:8px;overflow:hidden">
+    <tr><td style="height:4px;background:{{.Color}}"></td></tr>
+    <tr><td style="padding:24px">
+      {{if .Project}}<p style="margin:0 0 8px;font-size:12px;color:#718096;text-transform:uppercase;letter-spacing:.05em">{{.Project}}</p>{{end}}
+      <h1 style="margin:0 0 16px;font-size:20px">{{.Title}}</h1>
+      <p style="margin:0 0 24px;font-size:15px;line-height:1.5">{{.Message}}</p>
+      {{if .Link}}<a href="{{.Link}}" style="display:inline-block;padding:10px 18px;background:#667eea;color:#ffffff;text-decoration:none;border-radius:6px;font-size:14px">Open dashboard</a>{{end}}
+    </td></tr>
+    <tr><td style="padding:16px 24px;border-top:1px solid #edf2f7;font-size:12px;color:#a0aec0">
+      You get this email because you opted in to {{.Type}} notifications. Change this in your profile settings.
+    </td></tr>
+  </table>
+</body>
+</html>
+`))
+
+// severityColors tint the top of notification emails
+var severityColors = map[string]string{
+	models.NotificationSeverityInfo:    "#667eea",
+	models.NotificationSeverityWarning: "#ed8936",
+	models.NotificationSeverityError:   "#e53e3e",
+}
+
+// sendEmailNotification emails a notification to its user, or to the
+// owner of its project, if they opted in to its type. Security notices are
+// emailed by notifySecurityEvent instead.
+func sendEmailNotification(n models.Notification) {
+	if !config.EmailEnabled() || n.Type == models.NotificationTypeSecurity {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var project struct {
+		Name   string             `bson:"name"`
+		UserID primitive.ObjectID `bson:"user_id"`
+	}
+	if !n.ProjectID.IsZero() {
+		config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": n.ProjectID},
+			options.FindOne().SetProjection(bson.M{"name": 1, "user_id": 1})).Decode(&project)
+	}
+	recipient := n.UserID
+	if recipient.IsZero() {
+		recipient = project.UserID
+	}
+	if recipient.IsZero() {
+		return
+	}
+
+	user, err := repository.GetUser(ctx, recipient)
+	if err != nil || !user.IsActive || user.Email == "" || !containsString(user.EmailNotifications, n.Type) {
+		return
+	}
+
+	link := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	switch {
+	case link == "":
+	case user.IsAdmin():
+		link += "/admin/dashboard"
+	case !n.ProjectID.IsZero():
+		link += "/user/project/" + n.ProjectID.Hex()
+	default:
+		link += "/user/dashboard"
+	}
+	color := severityColors[n.Severity]
+	if color == "" {
+		color = severityColors[models.NotificationSeverityInfo]
+	}
+
+	var html bytes.Buffer
+	err = notificationEmail.Execute(&html, map[string]string{
+		"Project": project.Name,
+		"Title":   n.Title,
+		"Message": n.Message,
+		"Link":    link,
+		"Type":    strings.ReplaceAll(n.Type, "_", " "),
+		"Color":   color,
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to render notification email: %v", err)
+		return
+	}
+	text := n.Message
+	if link != "" {
+		text += "\n\n" + link
+	}
+
+	if err := config.SendHTMLEmail(user.Email, n.Title, text, html.String()); err != nil {
+		log.Printf("⚠️ Failed to email %s notification to %s: %v", n.Type, user.Email, err)
+	}
+}
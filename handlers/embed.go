@@ -2,9 +2,6 @@ package handlers
 
 import (
 	"context"
-	"crypto/md5"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
 	"net/http"
 	"os"
@@ -15,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"jevi-chat/config"
 	"jevi-chat/models"
+	"jevi-chat/repository"
 )
 
 // GET /embed/:projectId
@@ -23,10 +21,15 @@ func EmbedChat(c *gin.Context) {
 
 	userToken := c.Query("token")
 	if userToken == "" {
+		if objID, err := primitive.ObjectIDFromHex(projectID); err == nil {
+			markWidgetEmbedded(c, objID)
+		}
+
 		// No token, show pre-auth UI
 		c.HTML(http.StatusOK, "prechat.html", gin.H{
 			"project_id": projectID,
 			"api_url":    os.Getenv("APP_URL"),
+			"api_key":    c.GetString("api_key"),
 		})
 		return
 	}
@@ -47,22 +50,18 @@ func EmbedChat(c *gin.Context) {
 		return
 	}
 
-	// Validate token
-	userID, err := validateUserToken(userToken)
+	// Validate token; a bad or revoked one goes back to sign-in
+	user, err := chatUserFromToken(c, project, userToken)
 	if err != nil {
 		c.Redirect(http.StatusFound, fmt.Sprintf("/embed/%s", projectID))
 		return
 	}
 
-	// Fetch user
-	userCollection := config.DB.Collection("chat_users")
-	var user models.ChatUser
-	userObjID, _ := primitive.ObjectIDFromHex(userID)
-	err = userCollection.FindOne(context.Background(), bson.M{"_id": userObjID}).Decode(&user)
-	if err != nil {
-		c.Redirect(http.StatusFound, fmt.Sprintf("/embed/%s", projectID))
+	if isBlocked(objID, user.ID, user.Email, c.ClientIP()) {
+		c.HTML(http.StatusForbidden, "error.html", gin.H{"error": blockedReply})
 		return
 	}
+	markWidgetEmbedded(c, objID)
 
 	// Render chat UI
 	c.HTML(http.StatusOK, "chat.html", gin.H{
@@ -71,6 +70,7 @@ func EmbedChat(c *gin.Context) {
 		"api_url":    os.Getenv("APP_URL"),
 		"user":       user,
 		"user_token": userToken,
+		"api_key":    c.GetString("api_key"),
 	})
 }
 
@@ -103,38 +103,45 @@ func EmbedAuth(c *gin.Context) {
 		return
 	}
 
+	if isBlocked(objID, primitive.NilObjectID, authData.Email, c.ClientIP()) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "status": "blocked", "message": blockedReply})
+		return
+	}
+
 	userCollection := config.DB.Collection("chat_users")
 
 	if authData.Mode == "register" {
-		// Check if user exists
-		var existingUser models.ChatUser
-		err := userCollection.FindOne(context.Background(), bson.M{
-			"project_id": projectID,
-			"email":      authData.Email,
-		}).Decode(&existingUser)
-		if err == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Email already registered"})
+		hashed, err := hashPassword(authData.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create user"})
 			return
 		}
 
-		// Create new user
+		// Create new user; the duplicate check happens in the same transaction
 		user := models.ChatUser{
-			ProjectID: projectID,
+			ProjectID: objID,
 			Name:      authData.Name,
 			Email:     authData.Email,
-			Password:  hashPassword(authData.Password),
+			Password:  hashed,
 			IsActive:  true,
 			CreatedAt: time.Now(),
 		}
 
-		result, err := userCollection.InsertOne(context.Background(), user)
+		err = repository.CreateChatUser(context.Background(), &user)
+		if err == repository.ErrEmailTaken {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Email already registered"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to create user"})
 			return
 		}
 
-		user.ID = result.InsertedID.(primitive.ObjectID)
-		token := generateUserToken(user.ID.Hex())
+		token, issued, err := issueChatUserToken(c, objID, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to sign in"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -143,7 +150,8 @@ func EmbedAuth(c *gin.Context) {
 				"name":  user.Name,
 				"email": user.Email,
 			},
-			"token": token,
+			"token":      token,
+			"expires_at": issued.ExpiresAt,
 		})
 		return
 	}
@@ -151,20 +159,36 @@ func EmbedAuth(c *gin.Context) {
 	// Login
 	var user models.ChatUser
 	err = userCollection.FindOne(context.Background(), bson.M{
-		"project_id": projectID,
+		"project_id": models.ProjectIDMatch(objID),
 		"email":      authData.Email,
 	}).Decode(&user)
-	if err != nil || !verifyPassword(authData.Password, user.Password) {
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Invalid credentials"})
 		return
 	}
+	ok, rehash := verifyPassword(authData.Password, user.Password)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Invalid credentials"})
+		return
+	}
+	if rehash {
+		upgradePasswordHash(userCollection, user.ID, user.Password, authData.Password)
+	}
 
 	if !user.IsActive {
 		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "Account deactivated"})
 		return
 	}
+	if isBlocked(objID, user.ID, user.Email, "") {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "status": "blocked", "message": blockedReply})
+		return
+	}
 
-	token := generateUserToken(user.ID.Hex())
+	token, issued, err := issueChatUserToken(c, objID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to sign in"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"user": gin.H{
@@ -172,7 +196,8 @@ func EmbedAuth(c *gin.Context) {
 			"name":  user.Name,
 			"email": user.Email,
 		},
-		"token": token,
+		"token":      token,
+		"expires_at": issued.ExpiresAt,
 	})
 }
 
@@ -198,6 +223,7 @@ func IframeChatInterface(c *gin.Context) {
         "project":     project,
         "project_id":  project.ID.Hex(),
         "api_url":     os.Getenv("APP_URL"), 
+        "api_key":     c.GetString("api_key"),
     })
 }
 
@@ -211,20 +237,40 @@ func EmbedHealth(c *gin.Context) {
 	})
 }
 
-// Utility functions
-func hashPassword(password string) string {
-	hash := md5.Sum([]byte(password + "jevi_salt"))
-	return hex.EncodeToString(hash[:])
-}
+// EmbedUsage - GET /embed/:projectId/usage reports the project's Gemini
+// usage for server-side API clients. It is only served to requests signed
+// with the project's signing key, so projects must have signing enabled.
+func EmbedUsage(c *gin.Context) {
+	if !c.GetBool("request_signed") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Usage is only available to signed requests", "code": "signing_required"})
+		return
+	}
 
-func verifyPassword(password, hash string) bool {
-	return hashPassword(password) == hash
-}
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
 
-func generateUserToken(userID string) string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return fmt.Sprintf("%s_%s_%d", userID, hex.EncodeToString(bytes), time.Now().Unix())
+	periodStart, periodEnd := project.BillingPeriod(time.Now())
+	remaining := project.GeminiMonthlyLimit - project.GeminiUsageMonth
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":        objID.Hex(),
+		"monthly_usage":     project.GeminiUsageMonth,
+		"monthly_limit":     project.GeminiMonthlyLimit,
+		"monthly_remaining": remaining,
+		"period_start":      periodStart,
+		"resets_at":         periodEnd.Format(time.RFC3339),
+		"overage":           overageInfo(&project),
+	})
 }
 
 // GET /embed/:projectId/auth - Show authentication page
@@ -258,5 +304,6 @@ func ShowEmbedAuth(c *gin.Context) {
         "project":    project,
         "project_id": projectID,
         "api_url":    os.Getenv("APP_URL"),
+        "api_key":    c.GetString("api_key"),
     })
 }
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"time"
+
+	"jevi-chat/models"
+)
+
+// Machine-readable codes for limit errors. Responses carry one in "code"
+// next to a limitInfo, so clients can branch on it instead of the English
+// message.
+const (
+	CodeMonthlyLimitExceeded = "monthly_limit_exceeded"
+	CodeOverageCapReached    = "overage_cap_reached"
+	CodeRateLimited          = "rate_limited"
+)
+
+// limitInfo is the structured detail sent with a limit error
+type limitInfo struct {
+	Code       string `json:"code"`
+	LimitType  string `json:"limit_type"` // monthly, overage, chat, auth or general
+	Limit      int    `json:"limit"`
+	Usage      int    `json:"usage"`
+	Remaining  int    `json:"remaining"`
+	ResetsAt   string `json:"resets_at"`             // RFC 3339
+	RetryAfter int    `json:"retry_after,omitempty"` // seconds, for rate limits
+}
+
+// quotaLimitInfo describes a project that has run out of Gemini requests.
+// With overage enabled the overage allowance is what ran out, and the limit
+// covers both the monthly limit and the overage cap.
+func quotaLimitInfo(project *models.Project) limitInfo {
+	info := limitInfo{
+		Code:      CodeMonthlyLimitExceeded,
+		LimitType: "monthly",
+		Limit:     project.GeminiMonthlyLimit,
+		Usage:     project.GeminiUsageMonth,
+		ResetsAt:  getNextMonthlyReset(project),
+	}
+	if project.OverageEnabled {
+		info.Code = CodeOverageCapReached
+		info.LimitType = "overage"
+		info.Limit += project.OverageCap
+		info.Usage += project.GeminiOverageMonth
+	}
+	if info.Usage < info.Limit {
+		// The stored counters lag a request that raced past the limit
+		info.Usage = info.Limit
+	}
+	return info
+}
+
+// rateLimitInfo describes a refused rate-limited request
+func rateLimitInfo(st rateLimitState, limitType string) limitInfo {
+	return limitInfo{
+		Code:       CodeRateLimited,
+		LimitType:  limitType,
+		Limit:      st.Limit,
+		Usage:      st.Limit - st.Remaining,
+		Remaining:  st.Remaining,
+		ResetsAt:   st.Reset.UTC().Format(time.RFC3339),
+		RetryAfter: retryAfterSeconds(st),
+	}
+}
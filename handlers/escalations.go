@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// defaultHandoffMessage answers a message whose intent hands the session to
+// a live agent, when the intent has no message of its own
+const defaultHandoffMessage = "Thanks for letting us know. I've passed this conversation to our team, and someone will reply to you here shortly."
+
+// intentEscalation returns the escalation rule of a project intent, if any
+func intentEscalation(project models.Project, key string) *models.IntentEscalation {
+	if intent := projectIntent(project, key); intent != nil {
+		return intent.Escalation
+	}
+	return nil
+}
+
+// intentHandoff returns the reply for a message whose intent hands the
+// session to a live agent instead of the bot answering it
+func intentHandoff(msg *widgetMessage) (string, bool) {
+	rule := intentEscalation(msg.Project, msg.Intent)
+	if rule == nil || !rule.ForceHandoff {
+		return "", false
+	}
+	msg.Handoff = true
+	if rule.HandoffMessage != "" {
+		return rule.HandoffMessage, true
+	}
+	return defaultHandoffMessage, true
+}
+
+// escalateIntent alerts the project's team the first time a session has a
+// message of an escalating intent, and starts its SLA timer
+func escalateIntent(project models.Project, sessionID, key string, handoff bool) {
+	rule := intentEscalation(project, key)
+	if rule == nil {
+		return
+	}
+	ctx := context.Background()
+	sessions := config.GetChatSessionsCollectionFor(project.ID)
+
+	now := time.Now()
+	escalation := models.SessionIntentEscalation{
+		Intent:        key,
+		TriggeredAt:   now,
+		HandoffForced: handoff,
+	}
+	if rule.SLAMinutes > 0 {
+		escalation.DueAt = now.Add(time.Duration(rule.SLAMinutes) * time.Minute)
+	}
+	result, err := sessions.UpdateOne(ctx,
+		bson.M{"project_id": project.ID, "session_id": sessionID, "intent_escalation": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"intent_escalation": escalation}})
+	if err != nil {
+		log.Printf("⚠️ Failed to escalate session %s: %v", sessionID, err)
+		return
+	}
+	if handoff {
+		sessions.UpdateOne(ctx,
+			bson.M{"project_id": project.ID, "session_id": sessionID, "handoff_requested_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"handoff_requested_at": now}})
+	}
+	if result.ModifiedCount == 0 {
+		return
+	}
+
+	name := projectIntent(project, key).Name
+	message := fmt.Sprintf("A visitor to %s raised a %s matter in session %s.", project.Name, name, sessionID)
+	if handoff {
+		message += " The bot handed the conversation over and the visitor is waiting for a person."
+	}
+	metadata := map[string]interface{}{
+		"session_id": sessionID,
+		"intent":     key,
+		"alert_key":  "escalation:" + sessionID,
+	}
+	if rule.SLAMinutes > 0 {
+		message += fmt.Sprintf(" Please respond within %d minutes.", rule.SLAMinutes)
+		metadata["sla_minutes"] = rule.SLAMinutes
+	}
+	CreateNotification(project.ID, primitive.NilObjectID, models.NotificationTypeWarning,
+		name+" conversation needs a person", message, metadata)
+}
+
+// CheckEscalationSLAs alerts the team of each escalated session whose
+// time-to-human-response SLA passed without a response, once per session
+func CheckEscalationSLAs() error {
+	ctx := context.Background()
+	cursor, err := config.GetProjectsCollection().Find(ctx,
+		bson.M{"intents.escalation.sla_minutes": bson.M{"$gt": 0}},
+		options.Find().SetProjection(bson.M{"name": 1, "intents.key": 1, "intents.name": 1}))
+	if err != nil {
+		return err
+	}
+	var projects []models.Project
+	if err := cursor.All(ctx, &projects); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, project := range projects {
+		sessions := config.GetChatSessionsCollectionFor(project.ID)
+		overdue := bson.M{
+			"project_id":                     project.ID,
+			"intent_escalation.due_at":       bson.M{"$lte": now},
+			"intent_escalation.responded_at": bson.M{"$exists": false},
+			"intent_escalation.breached_at":  bson.M{"$exists": false},
+		}
+		cursor, err := sessions.Find(ctx, overdue, options.Find().SetProjection(bson.M{"session_id": 1, "intent_escalation": 1}))
+		if err != nil {
+			log.Printf("⚠️ Failed to check escalation SLAs for project %s: %v", project.ID.Hex(), err)
+			continue
+		}
+		var late []models.ChatSession
+		if err := cursor.All(ctx, &late); err != nil {
+			continue
+		}
+
+		for _, s := range late {
+			claim := bson.M{"_id": s.ID}
+			for k, v := range overdue {
+				claim[k] = v
+			}
+			result, err := sessions.UpdateOne(ctx, claim, bson.M{"$set": bson.M{"intent_escalation.breached_at": now}})
+			if err != nil || result.ModifiedCount == 0 {
+				continue
+			}
+			name := s.IntentEscalation.Intent
+			if intent := projectIntent(project, name); intent != nil {
+				name = intent.Name
+			}
+			CreateNotification(project.ID, primitive.NilObjectID, models.NotificationTypeError,
+				"Response SLA missed - "+project.Name,
+				fmt.Sprintf("Nobody has responded to the %s conversation in session %s, escalated %s ago.",
+					name, s.SessionID, now.Sub(s.IntentEscalation.TriggeredAt).Round(time.Minute)),
+				map[string]interface{}{
+					"session_id": s.SessionID,
+					"intent":     s.IntentEscalation.Intent,
+					"alert_key":  "escalation_sla:" + s.SessionID,
+				})
+		}
+	}
+	return nil
+}
+
+// RecordHumanResponse - POST /admin/projects/:id/sessions/:sid/respond
+// records that a person has responded to an escalated session, which stops
+// its SLA timer. Responding again keeps the first response.
+func RecordHumanResponse(c *gin.Context) {
+	projectID := c.Param("id")
+	sessionID := c.Param("sid")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	ctx := context.Background()
+	sessions := config.GetChatSessionsCollectionFor(objID)
+	now := time.Now()
+	_, err = sessions.UpdateOne(ctx, bson.M{
+		"project_id":                     objID,
+		"session_id":                     sessionID,
+		"intent_escalation":              bson.M{"$exists": true},
+		"intent_escalation.responded_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{
+		"intent_escalation.responded_at": now,
+		"intent_escalation.responded_by": c.GetString("user_id"),
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record response"})
+		return
+	}
+
+	var session models.ChatSession
+	if err := sessions.FindOne(ctx, bson.M{"project_id": objID, "session_id": sessionID}).Decode(&session); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if session.IntentEscalation == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This session has not been escalated"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionSessionRespond, "session", sessionID, objID, map[string]interface{}{
+		"intent": session.IntentEscalation.Intent,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"session_id": sessionID,
+		"escalation": session.IntentEscalation,
+		"within_sla": withinSLA(session.IntentEscalation, now),
+	})
+}
+
+// withinSLA reports whether an escalation was, or still can be, responded
+// to in time. Escalations without an SLA always are.
+func withinSLA(e *models.SessionIntentEscalation, now time.Time) bool {
+	if e.DueAt.IsZero() {
+		return true
+	}
+	if !e.RespondedAt.IsZero() {
+		return !e.RespondedAt.After(e.DueAt)
+	}
+	return now.Before(e.DueAt)
+}
+
+// GetEscalationReport - GET /admin/projects/:id/escalations reports the
+// sessions escalated for an intent over the last ?days= days (default 30):
+// how many were responded to, how many missed their SLA, and the median and
+// 90th percentile time to a human response, per intent and overall
+func GetEscalationReport(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return
+	}
+
+	ctx := context.Background()
+	since := time.Now().AddDate(0, 0, -days)
+	cursor, err := config.GetChatSessionsCollectionFor(objID).Find(ctx,
+		bson.M{"project_id": objID, "intent_escalation.triggered_at": bson.M{"$gte": since}},
+		options.Find().
+			SetProjection(bson.M{"session_id": 1, "intent_escalation": 1}).
+			SetSort(bson.D{{Key: "intent_escalation.triggered_at", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load escalations"})
+		return
+	}
+	var sessions []models.ChatSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load escalations"})
+		return
+	}
+
+	type tally struct {
+		escalated, responded, open, breached, handoffs int
+		seconds                                        []int64
+	}
+	summarize := func(t *tally) gin.H {
+		sort.Slice(t.seconds, func(i, j int) bool { return t.seconds[i] < t.seconds[j] })
+		rate := 1.0
+		if t.escalated > 0 {
+			rate = 1 - float64(t.breached)/float64(t.escalated)
+		}
+		return gin.H{
+			"escalated":            t.escalated,
+			"responded":            t.responded,
+			"open":                 t.open,
+			"breached":             t.breached,
+			"handoffs":             t.handoffs,
+			"within_sla_rate":      rate,
+			"median_response_secs": percentile(t.seconds, 0.5),
+			"p90_response_secs":    percentile(t.seconds, 0.9),
+		}
+	}
+
+	now := time.Now()
+	total := &tally{}
+	byIntent := map[string]*tally{}
+	open := []gin.H{}
+	for _, s := range sessions {
+		e := s.IntentEscalation
+		t := byIntent[e.Intent]
+		if t == nil {
+			t = &tally{}
+			byIntent[e.Intent] = t
+		}
+		for _, t := range []*tally{t, total} {
+			t.escalated++
+			if e.HandoffForced {
+				t.handoffs++
+			}
+			if !withinSLA(e, now) {
+				t.breached++
+			}
+			if e.RespondedAt.IsZero() {
+				t.open++
+			} else {
+				t.responded++
+				t.seconds = append(t.seconds, int64(e.RespondedAt.Sub(e.TriggeredAt).Seconds()))
+			}
+		}
+		if e.RespondedAt.IsZero() {
+			open = append(open, gin.H{"session_id": s.SessionID, "escalation": e, "within_sla": withinSLA(e, now)})
+		}
+	}
+
+	intents := gin.H{}
+	for key, t := range byIntent {
+		intents[key] = summarize(t)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project_id": projectID,
+		"days":       days,
+		"total":      summarize(total),
+		"intents":    intents,
+		"open":       open,
+	})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// chatEventSchemaVersion is the version of the payload published to the
+// event bus. Adding a field keeps the version; renaming, removing or
+// changing the meaning of one bumps it.
+const chatEventSchemaVersion = 1
+
+// busEvent is the JSON payload of a chat event on the event bus
+type busEvent struct {
+	Schema        string                 `json:"schema"`
+	SchemaVersion int                    `json:"schema_version"`
+	Source        string                 `json:"source"`
+	ID            string                 `json:"id"`
+	Type          string                 `json:"type"`
+	ProjectID     string                 `json:"project_id"`
+	SessionID     string                 `json:"session_id,omitempty"`
+	UserID        string                 `json:"user_id,omitempty"`
+	MessageID     string                 `json:"message_id,omitempty"`
+	Data          map[string]interface{} `json:"data,omitempty"`
+	OccurredAt    time.Time              `json:"occurred_at"`
+}
+
+func newBusMessage(event models.ChatEvent) (config.BusMessage, error) {
+	payload := busEvent{
+		Schema:        "jevi.chat_event",
+		SchemaVersion: chatEventSchemaVersion,
+		Source:        "jevi-chat",
+		ID:            event.ID.Hex(),
+		Type:          event.Type,
+		ProjectID:     event.ProjectID.Hex(),
+		SessionID:     event.SessionID,
+		Data:          event.Data,
+		OccurredAt:    event.CreatedAt.UTC(),
+	}
+	if !event.UserID.IsZero() {
+		payload.UserID = event.UserID.Hex()
+	}
+	if !event.MessageID.IsZero() {
+		payload.MessageID = event.MessageID.Hex()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return config.BusMessage{}, err
+	}
+	return config.BusMessage{
+		ID:      payload.ID,
+		Type:    event.Type,
+		Key:     payload.ProjectID,
+		Payload: body,
+		Headers: map[string]string{"schema_version": strconv.Itoa(chatEventSchemaVersion)},
+	}, nil
+}
+
+// PublishChatEvents publishes chat events logged since the last run to the
+// event bus configured with EVENT_BUS_DRIVER. Like SIEM forwarding it keeps
+// a checkpoint, in event_bus_checkpoints, that only advances once the broker
+// acknowledged a batch, so delivery is at least once and consumers should
+// drop repeated event IDs. Without a checkpoint it starts from now.
+func PublishChatEvents() error {
+	if config.EventBusSettings == nil || config.EventBusSettings.Driver == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	checkpoints := config.GetCollection("event_bus_checkpoints")
+	name := "chat_events:" + config.EventBusSettings.Driver
+	upTo := primitive.NewObjectIDFromTimestamp(time.Now().Add(-siemForwardLag))
+
+	var checkpoint struct {
+		LastID primitive.ObjectID `bson:"last_id"`
+	}
+	err := checkpoints.FindOne(ctx, bson.M{"_id": name}).Decode(&checkpoint)
+	if err == mongo.ErrNoDocuments {
+		_, err = checkpoints.InsertOne(ctx, bson.M{"_id": name, "last_id": upTo, "updated_at": time.Now()})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	batchSize := config.EventBusSettings.BatchSize
+	total := 0
+	defer func() {
+		if total > 0 {
+			log.Printf("📤 Published %d chat event(s) to %s", total, config.EventBusSettings.Driver)
+		}
+	}()
+	for {
+		filter := bson.M{"_id": bson.M{"$gt": checkpoint.LastID, "$lt": upTo}}
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batchSize))
+		cursor, err := config.GetChatEventsCollection().Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		var events []models.ChatEvent
+		err = cursor.All(ctx, &events)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		messages := make([]config.BusMessage, 0, len(events))
+		for _, event := range events {
+			msg, err := newBusMessage(event)
+			if err != nil {
+				log.Printf("⚠️ Skipping unpublishable chat event %s: %v", event.ID.Hex(), err)
+				continue
+			}
+			messages = append(messages, msg)
+		}
+		if err := config.PublishBusMessages(ctx, messages); err != nil {
+			return fmt.Errorf("after %d event(s): %v", total, err)
+		}
+		total += len(messages)
+
+		checkpoint.LastID = events[len(events)-1].ID
+		if _, err := checkpoints.UpdateOne(ctx, bson.M{"_id": name}, bson.M{
+			"$set": bson.M{"last_id": checkpoint.LastID, "updated_at": time.Now()},
+		}); err != nil {
+			return err
+		}
+		if len(events) < batchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// chatEventHandler reacts to a chat event after it has been logged
+type chatEventHandler func(models.ChatEvent)
+
+var (
+	chatEventMu       sync.RWMutex
+	chatEventHandlers = map[string][]chatEventHandler{}
+)
+
+// onChatEvent subscribes fn to events of the given type, or to every event
+// with "*". Notifications, analytics and integrations subscribe here rather
+// than being called from the request handlers.
+func onChatEvent(eventType string, fn chatEventHandler) {
+	chatEventMu.Lock()
+	defer chatEventMu.Unlock()
+	chatEventHandlers[eventType] = append(chatEventHandlers[eventType], fn)
+}
+
+func init() {
+	onChatEvent(models.ChatEventLimitCrossed, notifyLimitCrossed)
+	onChatEvent(models.ChatEventLimitCrossed, webhookLimitReached)
+	onChatEvent(models.ChatEventResponseGenerated, webhookMessageCreated)
+}
+
+// emitChatEvent appends the event to chat_events and hands it to its
+// subscribers, each in its own goroutine. Subscribers still run when the
+// write fails, so a database hiccup does not swallow notifications.
+func emitChatEvent(event models.ChatEvent) {
+	event.ID = primitive.NewObjectID()
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if _, err := config.GetChatEventsCollection().InsertOne(context.Background(), event); err != nil {
+		log.Printf("⚠️ Failed to log %s event for project %s: %v", event.Type, event.ProjectID.Hex(), err)
+	}
+
+	chatEventMu.RLock()
+	handlers := append(append([]chatEventHandler{}, chatEventHandlers[event.Type]...), chatEventHandlers["*"]...)
+	chatEventMu.RUnlock()
+	for _, fn := range handlers {
+		go fn(event)
+	}
+}
+
+// emitExchangeEvents logs a stored exchange as the visitor's message
+// followed by the answer to it. Dry runs are left out: they are tests, not
+// chats.
+func emitExchangeEvents(msg models.ChatMessage) {
+	if msg.DryRun {
+		return
+	}
+	emitChatEvent(models.ChatEvent{
+		Type:      models.ChatEventMessageSent,
+		ProjectID: msg.ProjectID,
+		SessionID: msg.SessionID,
+		UserID:    msg.UserID,
+		MessageID: msg.ID,
+		Data: map[string]interface{}{
+			"length":     len([]rune(msg.Message)),
+			"moderation": msg.ModerationAction,
+		},
+		CreatedAt: msg.Timestamp,
+	})
+
+	data := map[string]interface{}{
+		"length":     len([]rune(msg.Response)),
+		"citations":  len(msg.Citations),
+		"clarifying": msg.Clarifying,
+	}
+	if msg.Fallback != "" {
+		data["fallback"] = msg.Fallback
+	}
+	emitChatEvent(models.ChatEvent{
+		Type:      models.ChatEventResponseGenerated,
+		ProjectID: msg.ProjectID,
+		SessionID: msg.SessionID,
+		UserID:    msg.UserID,
+		MessageID: msg.ID,
+		Data:      data,
+	})
+}
+
+// emitLimitCrossed logs that a project ran into its monthly Gemini limit
+func emitLimitCrossed(project models.Project, sessionID string, currentUsage int) {
+	emitChatEvent(models.ChatEvent{
+		Type:      models.ChatEventLimitCrossed,
+		ProjectID: project.ID,
+		SessionID: sessionID,
+		Data: map[string]interface{}{
+			"limit_type":    "monthly",
+			"current_usage": currentUsage,
+			"limit":         project.GeminiMonthlyLimit,
+			"project_name":  project.Name,
+		},
+	})
+}
+
+// notifyLimitCrossed raises the limit notification for a limit_crossed event
+func notifyLimitCrossed(event models.ChatEvent) {
+	name, _ := event.Data["project_name"].(string)
+	limitType, _ := event.Data["limit_type"].(string)
+	CreateLimitExpiredNotification(event.ProjectID, name, limitType, eventInt(event.Data["current_usage"]), eventInt(event.Data["limit"]))
+}
+
+// eventInt reads a number from event data, which holds int when the event
+// was just emitted and int32 or int64 once read back from the database
+func eventInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// CloseChatSession - POST /embed/:projectId/sessions/:sessionId/close ends
+// a session, e.g. when the visitor closes the widget. The session is proven
+// by its history token (X-History-Token or ?token=). Closing an already
+// closed session succeeds without logging another event.
+func CloseChatSession(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	token := c.GetHeader(HeaderHistoryToken)
+	if token == "" {
+		token = c.Query("token")
+	}
+	sessionID, err := parseHistoryToken(token, objID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if sessionID != c.Param("sessionId") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "History token is for another session"})
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	var session models.ChatSession
+	err = config.GetChatSessionsCollectionFor(objID).FindOneAndUpdate(ctx,
+		bson.M{"project_id": objID, "session_id": sessionID, "is_active": true},
+		bson.M{"$set": bson.M{"is_active": false, "end_time": now}},
+	).Decode(&session)
+	if err == nil {
+		emitChatEvent(models.ChatEvent{
+			Type:      models.ChatEventSessionClosed,
+			ProjectID: objID,
+			SessionID: sessionID,
+			UserID:    session.UserID,
+			Data: map[string]interface{}{
+				"duration_seconds": int64(now.Sub(session.StartTime).Seconds()),
+			},
+			CreatedAt: now,
+		})
+	} else if n, _ := config.GetChatSessionsCollectionFor(objID).CountDocuments(ctx, bson.M{"project_id": objID, "session_id": sessionID}); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "session_id": sessionID})
+}
+
+// GetChatEvents - GET /admin/events lists chat events oldest first. Takes
+// type, project_id, session_id, after (the last event ID seen, to read on
+// from) and limit. next_after is the cursor for the following page.
+func GetChatEvents(c *gin.Context) {
+	filter := bson.M{}
+	if eventType := c.Query("type"); eventType != "" {
+		filter["type"] = eventType
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		objID, err := primitive.ObjectIDFromHex(projectID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+		filter["project_id"] = objID
+	}
+	if sessionID := c.Query("session_id"); sessionID != "" {
+		filter["session_id"] = sessionID
+	}
+	if after := c.Query("after"); after != "" {
+		afterID, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be an event ID"})
+			return
+		}
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := config.GetChatEventsCollection().Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat events"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	events := []models.ChatEvent{}
+	if err := cursor.All(context.Background(), &events); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode chat events"})
+		return
+	}
+
+	response := gin.H{"success": true, "events": events, "count": len(events)}
+	if len(events) > 0 {
+		response["next_after"] = events[len(events)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+	"jevi-chat/utils"
+)
+
+// redactionProfile reads the ?redact= profile of an export, answering 400
+// for an unknown one
+func redactionProfile(c *gin.Context) (utils.RedactionProfile, bool) {
+	profile, ok := utils.LookupRedactionProfile(c.Query("redact"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "redact must be one of " + strings.Join(utils.RedactionProfileNames(), ", "),
+		})
+	}
+	return profile, ok
+}
+
+// ExportProjectData - GET /admin/projects/:id/export downloads the
+// project's chat users, sessions, transcripts and usage as JSON. ?redact=
+// picks a redaction profile: "pii" masks emails and phone numbers and
+// drops IP addresses; "external" also pseudonymizes names and masks
+// profanity, for sharing transcripts outside the company.
+func ExportProjectData(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	profile, ok := redactionProfile(c)
+	if !ok {
+		return
+	}
+
+	export, err := repository.ExportProject(c.Request.Context(), objID)
+	if err == repository.ErrProjectNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export project"})
+		return
+	}
+	export.Redact(profile)
+
+	recordAudit(c, models.AuditActionProjectExport, "project", projectID, objID, map[string]interface{}{
+		"redaction": profile.Name,
+		"messages":  len(export.Messages),
+	})
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="project-%s-%s.json"`, projectID, time.Now().UTC().Format("20060102T150405Z")))
+	c.JSON(http.StatusOK, export)
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"jevi-chat/models"
+)
+
+// sourceTypes maps accepted file extensions to their SourceType
+var sourceTypes = map[string]string{
+	".pdf":      models.SourceTypePDF,
+	".docx":     models.SourceTypeDOCX,
+	".txt":      models.SourceTypeText,
+	".md":       models.SourceTypeMarkdown,
+	".markdown": models.SourceTypeMarkdown,
+	".csv":      models.SourceTypeCSV,
+}
+
+const (
+	// unsupportedTypeMessage is what uploaders are told about other files
+	unsupportedTypeMessage = "only PDF, DOCX, TXT, Markdown and CSV files are supported"
+
+	// utf8BOM is dropped from the start of text files saved by Windows tools
+	utf8BOM = "\xef\xbb\xbf"
+)
+
+// sourceFileType returns the SourceType of a file name, or "" when the
+// type is not accepted
+func sourceFileType(name string) string {
+	return sourceTypes[strings.ToLower(filepath.Ext(name))]
+}
+
+// checkSourceContent checks that the start of a file looks like its type,
+// so a renamed file is turned away before it is stored
+func checkSourceContent(fileType string, data []byte) error {
+	switch fileType {
+	case models.SourceTypePDF:
+		if !bytes.HasPrefix(data, []byte("%PDF-")) {
+			return errors.New("file is not a PDF")
+		}
+	case models.SourceTypeDOCX:
+		if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+			return errors.New("file is not a Word document")
+		}
+	default:
+		if !utf8.Valid(bytes.TrimPrefix(data, []byte(utf8BOM))) {
+			return errors.New("file is not UTF-8 text")
+		}
+	}
+	return nil
+}
+
+// errGeminiDisabled is returned for a PDF of a project that does not
+// process its files with Gemini
+var errGeminiDisabled = errors.New("Gemini processing is disabled for this project")
+
+// extractSourceFile extracts the text of a saved file and sets its status.
+// It returns the content to add to the project and whether page text and
+// chunks were stored, which embedding needs. Webhooks are told the outcome.
+func extractSourceFile(project models.Project, file *models.SourceFile) (string, bool) {
+	defer func() { go webhookFileProcessed(project.ID, *file) }()
+
+	content, err := readSourceFile(project, file)
+	if errors.Is(err, errGeminiDisabled) {
+		file.Status = "completed"
+		return "PDF uploaded successfully (Gemini processing disabled)", false
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to extract %s: %v", file.FileName, err)
+		file.Status = "failed"
+		file.Error = err.Error()
+		return "Failed to process " + file.FileName, false
+	}
+
+	file.ProcessedAt = time.Now()
+	file.Status = "completed"
+	if err := storeDocumentPages(context.Background(), project.ID, file.ID, content); err != nil {
+		log.Printf("⚠️ Failed to store page text for file %s: %v", file.ID, err)
+		return content, false
+	}
+	return content, true
+}
+
+// readSourceFile returns the text of a saved file. PDFs are processed with
+// Gemini, if the project has it enabled, and scans among them with OCR; the
+// other types are read directly.
+func readSourceFile(project models.Project, file *models.SourceFile) (string, error) {
+	isPDF := file.FileType() == models.SourceTypePDF
+	if isPDF && (!project.GeminiEnabled || project.GeminiAPIKey == "") {
+		return "", errGeminiDisabled
+	}
+	path, cleanup, err := localSourceCopy(context.Background(), file)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	if !isPDF {
+		content, err := extractDocumentText(path, file.FileType())
+		file.TextChars = extractedChars(content)
+		return content, err
+	}
+	content, err := processPDFWithGemini(path, project.GeminiAPIKey)
+	if err != nil {
+		return "", err
+	}
+	return withOCRFallback(project, file, path, content)
+}
+
+// extractDocumentText reads the text of a file that needs no Gemini
+// processing
+func extractDocumentText(path, fileType string) (string, error) {
+	var text string
+	var err error
+	switch fileType {
+	case models.SourceTypeDOCX:
+		text, err = docxText(path)
+	case models.SourceTypeCSV:
+		text, err = csvText(path)
+	case models.SourceTypeText, models.SourceTypeMarkdown:
+		var data []byte
+		data, err = os.ReadFile(path)
+		text = string(bytes.TrimPrefix(data, []byte(utf8BOM)))
+	default:
+		return "", fmt.Errorf("unsupported file type %q", fileType)
+	}
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return "", errors.New("file has no text")
+	}
+	return text, nil
+}
+
+// docxText returns the paragraphs of a Word document's body, one per line
+func docxText(path string) (string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return "", errors.New("file is not a valid Word document")
+	}
+	defer archive.Close()
+
+	var body *zip.File
+	for _, f := range archive.File {
+		if f.Name == "word/document.xml" {
+			body = f
+			break
+		}
+	}
+	if body == nil {
+		return "", errors.New("file is not a valid Word document")
+	}
+	rc, err := body.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	// Reading is bounded, so a small compressed document cannot expand
+	// into a huge one
+	var b strings.Builder
+	decoder := xml.NewDecoder(io.LimitReader(rc, 4*maxSourceBytes))
+	inText := false
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.New("file is not a valid Word document")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.WriteByte('\t')
+			case "br", "cr":
+				b.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				b.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// csvText renders each row of a CSV file as "column: value" lines under its
+// row number, so a row's values stay together with their headers when the
+// text is split into passages
+func csvText(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("file is not valid CSV: %v", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], utf8BOM))
+	}
+
+	var b strings.Builder
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("file is not valid CSV: %v", err)
+		}
+		fmt.Fprintf(&b, "Row %d\n", row)
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			name := fmt.Sprintf("Column %d", i+1)
+			if i < len(header) && header[i] != "" {
+				name = header[i]
+			}
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
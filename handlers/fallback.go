@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// The model's whole reply when the documents do not cover a question, for
+// projects with a fallback
+const unansweredMarker = "NO_ANSWER"
+
+const fallbackRule = "If the document does not cover the question, reply with exactly " +
+	unansweredMarker + " and nothing else. The company has its own reply for that case."
+
+// withFallbackRule returns the project's instructions, plus the rule that
+// makes the model say when it cannot answer if the project has a fallback
+// to serve instead
+func withFallbackRule(project models.Project) string {
+	if project.Fallback == nil {
+		return project.Instructions
+	}
+	if project.Instructions == "" {
+		return fallbackRule
+	}
+	return project.Instructions + "\n" + fallbackRule
+}
+
+// isUnanswered reports whether a reply is the unanswered marker
+func isUnanswered(response string) bool {
+	return strings.EqualFold(strings.Trim(response, " \t\r\n.`*\""), unansweredMarker)
+}
+
+// couldBeUnanswered reports whether a partial streamed reply may still turn
+// out to be the unanswered marker
+func couldBeUnanswered(partial string) bool {
+	t := strings.ToUpper(strings.TrimLeft(partial, " \t\r\n`*\""))
+	return strings.HasPrefix(unansweredMarker, t) || isUnanswered(partial)
+}
+
+// fallbackReply returns the response for a message the bot could not
+// answer and what the widget needs to show with it. Projects without a
+// fallback keep the generic reply and get no fallback details.
+func fallbackReply(msg *widgetMessage, reason, generic string) (string, gin.H) {
+	msg.Fallback = reason
+	fb := msg.Project.Fallback
+	if fb == nil {
+		return generic, nil
+	}
+
+	info := gin.H{"kind": fb.Kind, "reason": reason}
+	switch fb.Kind {
+	case models.FallbackEmailForm:
+		info["form_url"] = fmt.Sprintf("/embed/%s/fallback/email", msg.Project.ID.Hex())
+	case models.FallbackHandoff:
+		info["handoff_requested"] = requestHandoff(msg.Project, msg.SessionID)
+	}
+	return fb.Message, info
+}
+
+// streamFallback ends a stream that has no answer. Projects without a
+// fallback get the generic error event.
+func streamFallback(c *gin.Context, msg *widgetMessage, reason string) {
+	const generic = "I'm having trouble answering just now. Please try again later."
+	if msg.Project.Fallback == nil {
+		c.SSEvent("error", gin.H{"message": generic})
+		return
+	}
+	response, info := fallbackReply(msg, reason, generic)
+	msg.save(response)
+	c.SSEvent("chunk", gin.H{"text": response})
+	c.SSEvent("done", gin.H{"session_id": msg.SessionID, "status": "fallback", "fallback": info})
+}
+
+// requestHandoff flags the session for a live agent and notifies the
+// project's admins, once per session. It reports whether the session has
+// been handed off.
+func requestHandoff(project models.Project, sessionID string) bool {
+	result, err := config.GetChatSessionsCollectionFor(project.ID).UpdateOne(context.Background(),
+		bson.M{"project_id": project.ID, "session_id": sessionID, "handoff_requested_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"handoff_requested_at": time.Now()}})
+	if err != nil {
+		log.Printf("⚠️ Failed to request handoff for session %s: %v", sessionID, err)
+		return false
+	}
+	if result.ModifiedCount > 0 {
+		go CreateNotification(
+			project.ID,
+			primitive.NilObjectID,
+			models.NotificationTypeWarning,
+			"Live agent requested",
+			fmt.Sprintf("The %s bot could not answer a visitor, who is waiting for a live agent in session %s.", project.Name, sessionID),
+			map[string]interface{}{
+				"session_id": sessionID,
+				"alert_key":  "handoff:" + sessionID,
+			},
+		)
+	}
+	return true
+}
+
+// fallbackStats counts the messages since the given time that got the
+// fallback answer, by reason, as a share of all messages
+func fallbackStats(ctx context.Context, projectID primitive.ObjectID, since time.Time, total int64) (gin.H, error) {
+	cursor, err := config.GetChatMessagesCollectionFor(projectID).Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"project_id": projectID,
+			"timestamp":  bson.M{"$gte": since},
+			"fallback":   bson.M{"$exists": true},
+			"dry_run":    bson.M{"$ne": true},
+		}},
+		{"$group": bson.M{"_id": "$fallback", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Reason string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	byReason := gin.H{}
+	for _, g := range groups {
+		count += g.Count
+		byReason[g.Reason] = g.Count
+	}
+	rate := 0.0
+	if total > 0 {
+		rate = float64(count) / float64(total)
+	}
+	return gin.H{"count": count, "rate": rate, "by_reason": byReason}, nil
+}
+
+// SubmitFallbackEmail - POST /embed/:projectId/fallback/email sends the
+// "email us" form of a project's fallback to the project's address
+func SubmitFallbackEmail(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		SessionID string `json:"session_id" binding:"required,max=128"`
+		Name      string `json:"name" binding:"max=100"`
+		Email     string `json:"email" binding:"required,email"`
+		Message   string `json:"message" binding:"required,max=5000"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project)
+	if err != nil || !project.IsActive {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found or inactive"})
+		return
+	}
+	if project.Fallback == nil || project.Fallback.Kind != models.FallbackEmailForm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This project does not accept email requests"})
+		return
+	}
+	sessions, err := config.GetChatSessionsCollectionFor(objID).CountDocuments(context.Background(),
+		bson.M{"project_id": objID, "session_id": input.SessionID})
+	if err != nil || sessions == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	// The name ends up in the subject, which must stay on one line
+	name := strings.Join(strings.Fields(input.Name), " ")
+	if name == "" {
+		name = "a visitor"
+	}
+	subject := fmt.Sprintf("Question from %s via the %s chat", name, project.Name)
+	body := fmt.Sprintf("A visitor could not get an answer from the %s chat and asked to be contacted.\n\n"+
+		"Name: %s\nEmail: %s\nSession: %s\n\nMessage:\n%s\n",
+		project.Name, name, input.Email, input.SessionID, strings.TrimSpace(input.Message))
+
+	if err := config.SendEmail(project.Fallback.Email, subject, body); err != nil {
+		log.Printf("⚠️ Fallback email for project %s failed: %v", objID.Hex(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send your message. Please try again later."})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// SetFallback - PUT /admin/projects/:id/fallback sets what visitors get when
+// the bot cannot answer. An empty kind removes the fallback.
+func SetFallback(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Kind    string `json:"kind" binding:"omitempty,oneof=message email_form handoff"`
+		Message string `json:"message" binding:"max=1000"`
+		Email   string `json:"email" binding:"omitempty,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	var update bson.M
+	var fallback *models.FallbackSettings
+	if input.Kind == "" {
+		update = bson.M{"$unset": bson.M{"fallback": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	} else {
+		input.Message = strings.TrimSpace(input.Message)
+		if input.Message == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A fallback message is required"})
+			return
+		}
+		if input.Kind == models.FallbackEmailForm {
+			if input.Email == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "An email address is required for the email form"})
+				return
+			}
+			if !config.EmailEnabled() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Email delivery is not configured on this server"})
+				return
+			}
+		} else {
+			input.Email = ""
+		}
+		fallback = &models.FallbackSettings{
+			Kind:      input.Kind,
+			Message:   input.Message,
+			Email:     input.Email,
+			UpdatedAt: time.Now(),
+		}
+		update = bson.M{"$set": bson.M{"fallback": fallback, "updated_at": time.Now()}}
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fallback"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionFallbackUpdate, "project", projectID, objID, map[string]interface{}{
+		"kind":  input.Kind,
+		"email": input.Email,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "fallback": fallback})
+}
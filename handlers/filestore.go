@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// errStorageUnavailable is returned when this instance has no object
+// storage, as in analytics mode
+var errStorageUnavailable = errors.New("object storage is not configured")
+
+// sourceStorageKey is where an uploaded project file is kept in object
+// storage
+func sourceStorageKey(projectID primitive.ObjectID, fileID, name string) string {
+	return fmt.Sprintf("uploads/%s/%s_%s", projectID.Hex(), fileID, filepath.Base(name))
+}
+
+// storeSourceFile writes an uploaded file to object storage under key
+func storeSourceFile(ctx context.Context, key string, r io.Reader, size int64) error {
+	if config.Storage == nil {
+		return errStorageUnavailable
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return config.Storage.Put(ctx, key, r, size, contentType)
+}
+
+// openSourceFile opens the stored copy of an uploaded file: the object
+// storage copy, or the local file of one uploaded before object storage
+func openSourceFile(ctx context.Context, storageKey, filePath string) (io.ReadCloser, error) {
+	if storageKey == "" {
+		return os.Open(filePath)
+	}
+	if config.Storage == nil {
+		return nil, errStorageUnavailable
+	}
+	return config.Storage.Get(ctx, storageKey)
+}
+
+// localSourceCopy returns a local path to an uploaded file, for extraction
+// tools that read from disk. Files in object storage are copied to a
+// temporary file, which cleanup removes.
+func localSourceCopy(ctx context.Context, file *models.SourceFile) (path string, cleanup func(), err error) {
+	if file.StorageKey == "" {
+		if _, err := os.Stat(file.FilePath); err != nil {
+			return "", nil, errors.New("the uploaded file is no longer on disk")
+		}
+		return file.FilePath, func() {}, nil
+	}
+
+	rc, err := openSourceFile(ctx, file.StorageKey, "")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read the uploaded file from storage: %v", err)
+	}
+	defer rc.Close()
+
+	// Keep the extension: Gemini infers the file's type from it
+	tmp, err := os.CreateTemp("", "source-*"+filepath.Ext(file.StorageKey))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(tmp.Name()) }
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to read the uploaded file from storage: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// removeSourceFile deletes the stored copy of an uploaded file. Failures
+// are logged: a leftover object only costs space.
+func removeSourceFile(ctx context.Context, storageKey, filePath string) {
+	if filePath != "" {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ Failed to remove %s: %v", filePath, err)
+		}
+	}
+	if storageKey != "" && config.Storage != nil {
+		if err := config.Storage.Delete(ctx, storageKey); err != nil {
+			log.Printf("⚠️ Failed to remove %s from storage: %v", storageKey, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	// A knowledge gap alert needs enough questions to mean something
+	minGapSample        = 20
+	gapAlertRatio       = 0.3
+	freshnessAlertEvery = 7 * 24 * time.Hour
+)
+
+// CheckKnowledgeFreshness warns owners of active projects whose newest
+// document is older than the project's limit, or whose recent questions are
+// often about topics missing from the knowledge base. Each alert is sent at
+// most once a week per project.
+func CheckKnowledgeFreshness() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cursor, err := config.GetProjectsCollection().Find(ctx, bson.M{
+		"is_active": true,
+		"$or": bson.A{
+			bson.M{"pdf_files.0": bson.M{"$exists": true}},
+			bson.M{"library_docs.0": bson.M{"$exists": true}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now()
+	for cursor.Next(ctx) {
+		var project models.Project
+		if err := cursor.Decode(&project); err != nil {
+			continue
+		}
+		f, err := repository.GetKnowledgeFreshness(ctx, project, now)
+		if err != nil {
+			log.Printf("⚠️ Knowledge freshness check failed for project %s: %v", project.ID.Hex(), err)
+			continue
+		}
+
+		if f.Stale && !recentlyAlerted(ctx, project.ID, "knowledge_stale", now) {
+			CreateNotification(
+				project.ID,
+				primitive.NilObjectID,
+				models.NotificationTypeWarning,
+				fmt.Sprintf("Knowledge Base Outdated - %s", project.Name),
+				fmt.Sprintf("The newest document was processed %d days ago (limit %d days). Upload current documents so the bot doesn't give outdated answers.",
+					f.AgeDays, f.MaxAgeDays),
+				map[string]interface{}{
+					"alert_key":           "knowledge_stale",
+					"age_days":            f.AgeDays,
+					"max_age_days":        f.MaxAgeDays,
+					"newest_processed_at": f.NewestProcessedAt,
+					"auto_generated":      true,
+				},
+			)
+		}
+
+		if f.QuestionsSampled >= minGapSample && f.UncoveredRatio >= gapAlertRatio &&
+			!recentlyAlerted(ctx, project.ID, "knowledge_gaps", now) {
+			CreateNotification(
+				project.ID,
+				primitive.NilObjectID,
+				models.NotificationTypeWarning,
+				fmt.Sprintf("Knowledge Gaps - %s", project.Name),
+				fmt.Sprintf("%d of the last %d questions were about topics not found in your documents. Frequent ones: %s.",
+					f.UncoveredQuestions, f.QuestionsSampled, strings.Join(f.MissingTopics, ", ")),
+				map[string]interface{}{
+					"alert_key":           "knowledge_gaps",
+					"uncovered_questions": f.UncoveredQuestions,
+					"questions_sampled":   f.QuestionsSampled,
+					"missing_topics":      f.MissingTopics,
+					"auto_generated":      true,
+				},
+			)
+		}
+	}
+	return cursor.Err()
+}
+
+// recentlyAlerted reports whether the alert went out in the last week
+func recentlyAlerted(ctx context.Context, projectID primitive.ObjectID, alertKey string, now time.Time) bool {
+	n, err := config.GetNotificationsCollection().CountDocuments(ctx, bson.M{
+		"project_id":         projectID,
+		"metadata.alert_key": alertKey,
+		"created_at":         bson.M{"$gt": now.Add(-freshnessAlertEvery)},
+	})
+	return err != nil || n > 0
+}
+
+// projectFreshness is the freshness widget shown with a project's analytics
+func projectFreshness(ctx context.Context, projectID primitive.ObjectID) (*repository.KnowledgeFreshness, error) {
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": projectID}).Decode(&project); err != nil {
+		return nil, err
+	}
+	return repository.GetKnowledgeFreshness(ctx, project, time.Now())
+}
+
+// GetKnowledgeFreshness - GET /admin/projects/:id/freshness reports the age
+// of the project's documents and the topics its knowledge base is missing
+func GetKnowledgeFreshness(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	f, err := projectFreshness(c.Request.Context(), objID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project_id": projectID, "freshness": f})
+}
+
+// SetKnowledgeFreshness - PUT /admin/projects/:id/freshness sets how old the
+// newest document may get before owners are warned. 0 restores the default.
+func SetKnowledgeFreshness(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		MaxAgeDays int `json:"max_age_days" binding:"min=0,max=3650"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"knowledge_max_age_days": input.MaxAgeDays, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update freshness settings"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionFreshnessUpdate, "project", projectID, objID, map[string]interface{}{
+		"max_age_days": input.MaxAgeDays,
+	})
+
+	effective := input.MaxAgeDays
+	if effective == 0 {
+		effective = repository.DefaultKnowledgeMaxAgeDays
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "max_age_days": effective})
+}
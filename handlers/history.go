@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// historyTokenTTL is how long a widget can read a session's history with
+// one token. Every answer comes with a fresh token.
+const historyTokenTTL = 24 * time.Hour
+
+// HeaderHistoryToken carries a history token
+const HeaderHistoryToken = "X-History-Token"
+
+var errHistoryToken = errors.New("invalid or expired history token")
+
+// historyKey signs history tokens. It is derived from JWT_SECRET but is not
+// a JWT key, so a history token can never pass as a login token.
+func historyKey() []byte {
+	sum := sha256.Sum256([]byte("chat-history-token:" + os.Getenv("JWT_SECRET")))
+	return sum[:]
+}
+
+func signHistoryPayload(payload string) string {
+	mac := hmac.New(sha256.New, historyKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// historyToken returns a token that lets its holder read one session's
+// history until it expires
+func historyToken(projectID primitive.ObjectID, sessionID string, now time.Time) string {
+	payload := fmt.Sprintf("v1.%s.%s.%d", projectID.Hex(), sessionID, now.Add(historyTokenTTL).Unix())
+	return payload + "." + signHistoryPayload(payload)
+}
+
+// parseHistoryToken checks a history token for the project and returns the
+// session it grants
+func parseHistoryToken(token string, projectID primitive.ObjectID, now time.Time) (string, error) {
+	// Session IDs never contain dots, so the token splits cleanly
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[0] != "v1" {
+		return "", errHistoryToken
+	}
+	payload := strings.Join(parts[:4], ".")
+	if !hmac.Equal([]byte(parts[4]), []byte(signHistoryPayload(payload))) {
+		return "", errHistoryToken
+	}
+	expires, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", errHistoryToken
+	}
+	if parts[1] != projectID.Hex() || !sessionIDPattern.MatchString(parts[2]) {
+		return "", errHistoryToken
+	}
+	return parts[2], nil
+}
+
+// legacyPublicHistory reports whether public history may still be read
+// without a token, as before history tokens existed
+func legacyPublicHistory() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("LEGACY_PUBLIC_HISTORY"))
+	return enabled
+}
+
+// GetPublicChatHistory - GET /user/chat/:id/history and
+// GET /chat/:projectId/history return one session's history to the widget.
+// The session comes from a history token (X-History-Token header or ?token=),
+// which the widget receives with its session and with every answer.
+//
+// With LEGACY_PUBLIC_HISTORY=true, requests without a token are still served
+// the old way and marked deprecated.
+func GetPublicChatHistory(c *gin.Context) {
+	projectID := c.Param("projectId")
+	if projectID == "" {
+		projectID = c.Param("id")
+	}
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	token := c.GetHeader(HeaderHistoryToken)
+	if token == "" {
+		token = c.Query("token")
+	}
+	if token == "" {
+		if !legacyPublicHistory() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "History token required"})
+			return
+		}
+		log.Printf("⚠️ Deprecated tokenless history request for project %s from %s", projectID, c.ClientIP())
+		c.Header("Deprecation", "true")
+		c.Header("Warning", `299 - "Chat history without a history token is deprecated"`)
+		chatHistory(c, objID, c.Query("session_id"), nil)
+		return
+	}
+
+	sessionID, err := parseHistoryToken(token, objID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	chatHistory(c, objID, sessionID, nil)
+}
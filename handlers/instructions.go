@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+	"jevi-chat/utils"
+)
+
+const diffContextLines = 3
+
+// GetInstructions - GET /admin/projects/:id/instructions returns the
+// project's current bot instructions and its revision history, newest first
+func GetInstructions(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	cursor, err := config.GetInstructionRevisionsCollection().Find(context.Background(),
+		bson.M{"project_id": objID},
+		options.Find().
+			SetSort(bson.D{{Key: "revision", Value: -1}}).
+			SetLimit(100).
+			SetProjection(bson.M{"instructions": 0}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load revisions"})
+		return
+	}
+	var revisions []models.InstructionRevision
+	if err := cursor.All(context.Background(), &revisions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load revisions"})
+		return
+	}
+
+	type revisionSummary struct {
+		Revision int       `json:"revision"`
+		Note     string    `json:"note,omitempty"`
+		AuthorID string    `json:"author_id"`
+		Comments int       `json:"comments"`
+		Created  time.Time `json:"created_at"`
+	}
+	summaries := make([]revisionSummary, len(revisions))
+	for i, r := range revisions {
+		summaries[i] = revisionSummary{r.Revision, r.Note, r.AuthorID, len(r.Comments), r.CreatedAt}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":   projectID,
+		"instructions": project.Instructions,
+		"revision":     project.InstructionsRevision,
+		"revisions":    summaries,
+	})
+}
+
+// SetInstructions - PUT /admin/projects/:id/instructions saves new bot
+// instructions as the next revision. Saving the current text again does
+// not create a revision.
+func SetInstructions(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Instructions string `json:"instructions" binding:"max=20000"`
+		Note         string `json:"note" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	input.Instructions = strings.TrimSpace(strings.ReplaceAll(input.Instructions, "\r\n", "\n"))
+
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if project.Instructions == input.Instructions {
+		c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "revision": project.InstructionsRevision, "unchanged": true})
+		return
+	}
+
+	revision, err := repository.SaveInstructions(context.Background(), objID, input.Instructions, strings.TrimSpace(input.Note), c.GetString("user_id"))
+	if errors.Is(err, repository.ErrProjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save instructions"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionInstructions, "project", projectID, objID, map[string]interface{}{
+		"revision": revision.Revision,
+		"note":     revision.Note,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "revision": revision})
+}
+
+// revisionParam reads the :rev path parameter
+func revisionParam(c *gin.Context) (primitive.ObjectID, int, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return objID, 0, false
+	}
+	rev, err := strconv.Atoi(c.Param("rev"))
+	if err != nil || rev < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return objID, 0, false
+	}
+	return objID, rev, true
+}
+
+// GetInstructionRevision - GET /admin/projects/:id/instructions/revisions/:rev
+// returns one revision with its comments
+func GetInstructionRevision(c *gin.Context) {
+	objID, rev, ok := revisionParam(c)
+	if !ok {
+		return
+	}
+	revision, err := repository.GetInstructionRevision(c.Request.Context(), objID, rev)
+	if errors.Is(err, repository.ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load revision"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revision": revision})
+}
+
+// DiffInstructionRevisions - GET /admin/projects/:id/instructions/revisions/:rev/diff
+// compares a revision with the one before it, or with ?against=N. Revision
+// 1 is compared with empty instructions.
+func DiffInstructionRevisions(c *gin.Context) {
+	objID, rev, ok := revisionParam(c)
+	if !ok {
+		return
+	}
+	against := rev - 1
+	if s := c.Query("against"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n == rev {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "against must be another revision number"})
+			return
+		}
+		against = n
+	}
+
+	ctx := c.Request.Context()
+	revision, err := repository.GetInstructionRevision(ctx, objID, rev)
+	if errors.Is(err, repository.ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load revision"})
+		return
+	}
+	var oldText string
+	if against > 0 {
+		base, err := repository.GetInstructionRevision(ctx, objID, against)
+		if errors.Is(err, repository.ErrRevisionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Revision %d not found", against)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load revision"})
+			return
+		}
+		oldText = base.Instructions
+	}
+
+	lines := utils.DiffLines(oldText, revision.Instructions)
+	added, removed := 0, 0
+	for _, l := range lines {
+		switch l.Op {
+		case utils.DiffInsert:
+			added++
+		case utils.DiffDelete:
+			removed++
+		}
+	}
+
+	unified := utils.UnifiedDiff(lines, fmt.Sprintf("revision %d", against), fmt.Sprintf("revision %d", rev), diffContextLines)
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id": objID.Hex(),
+		"from":       against,
+		"to":         rev,
+		"added":      added,
+		"removed":    removed,
+		"lines":      lines,
+		"unified":    unified,
+		"comments":   revision.Comments,
+	})
+}
+
+// AddInstructionComment - POST /admin/projects/:id/instructions/revisions/:rev/comments
+// adds a review comment to a revision
+func AddInstructionComment(c *gin.Context) {
+	objID, rev, ok := revisionParam(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Body string `json:"body" binding:"required,max=2000"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	body := strings.TrimSpace(input.Body)
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment body is required"})
+		return
+	}
+
+	comment, err := repository.AddRevisionComment(context.Background(), objID, rev, c.GetString("user_id"), body)
+	if errors.Is(err, repository.ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add comment"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionRevisionComment, "project", objID.Hex(), objID, map[string]interface{}{
+		"revision":   rev,
+		"comment_id": comment.ID.Hex(),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "revision": rev, "comment": comment})
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+const (
+	// A message is classified as the intent of its most similar example
+	// phrase, if it is at least this similar
+	intentThreshold = 0.72
+	intentTimeout   = 5 * time.Second
+)
+
+var intentKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// projectIntent returns the project's intent with the given key
+func projectIntent(project models.Project, key string) *models.Intent {
+	for i := range project.Intents {
+		if project.Intents[i].Key == key {
+			return &project.Intents[i]
+		}
+	}
+	return nil
+}
+
+// classifyIntent returns the key of the project intent a message is most
+// like, or "" when none is close enough or the project has none
+func classifyIntent(ctx context.Context, project models.Project, message string) string {
+	model := config.EmbeddingModel()
+	classifiable := false
+	for _, intent := range project.Intents {
+		classifiable = classifiable || (len(intent.ExampleVectors) > 0 && intent.EmbeddingModel == model)
+	}
+	if !classifiable || project.GeminiAPIKey == "" {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, intentTimeout)
+	defer cancel()
+	vectors, err := config.EmbedTexts(ctx, project.GeminiAPIKey, []string{message}, true)
+	if err != nil {
+		log.Printf("⚠️ Intent classification failed for project %s: %v", project.ID.Hex(), err)
+		return ""
+	}
+
+	best, bestScore := "", intentThreshold
+	for _, intent := range project.Intents {
+		if intent.EmbeddingModel != model {
+			continue
+		}
+		for _, v := range intent.ExampleVectors {
+			if score := cosineSimilarity(vectors[0], v); score >= bestScore {
+				best, bestScore = intent.Key, score
+			}
+		}
+	}
+	return best
+}
+
+// resolveIntent is the intent a widget message is answered as: the one the
+// widget named, when the visitor picked it from a quick reply, otherwise
+// the one it is classified as
+func resolveIntent(project models.Project, named, message string) string {
+	if named != "" && projectIntent(project, named) != nil {
+		return named
+	}
+	return classifyIntent(context.Background(), project, message)
+}
+
+// routeIntent returns the project as an intent's messages see it: with the
+// intent's instructions added, and without the tools it leaves out
+func routeIntent(project models.Project, key string) models.Project {
+	intent := projectIntent(project, key)
+	if intent == nil {
+		return project
+	}
+	if intent.Instructions != "" {
+		project.Instructions = strings.TrimSpace(project.Instructions + "\n\n" +
+			"INSTRUCTIONS FOR " + strings.ToUpper(intent.Name) + " QUESTIONS:\n" + intent.Instructions)
+	}
+	if len(intent.Tools) > 0 {
+		if !containsString(intent.Tools, models.IntentToolBooking) {
+			project.Booking = nil
+		}
+		if !containsString(intent.Tools, models.IntentToolCatalog) {
+			project.CatalogProducts = 0
+		}
+		if !containsString(intent.Tools, models.IntentToolOrders) {
+			project.OrderLookup = nil
+		}
+	}
+	return project
+}
+
+// SetIntents - PUT /admin/projects/:id/intents replaces the project's
+// intents. Example phrases are embedded here, with the project's Gemini
+// key; intents whose examples did not change keep their embeddings.
+func SetIntents(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Intents []struct {
+			Key          string   `json:"key" binding:"required"`
+			Name         string   `json:"name" binding:"required,max=60"`
+			Examples     []string `json:"examples" binding:"required,min=1,max=25,dive,min=2,max=300"`
+			Instructions string   `json:"instructions" binding:"max=2000"`
+			Tools        []string `json:"tools" binding:"max=3,dive,oneof=booking catalog orders"`
+			Escalation   *struct {
+				ForceHandoff   bool   `json:"force_handoff"`
+				HandoffMessage string `json:"handoff_message" binding:"max=1000"`
+				SLAMinutes     int    `json:"sla_minutes" binding:"min=0,max=10080"`
+			} `json:"escalation"`
+		} `json:"intents" binding:"max=20,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	model := config.EmbeddingModel()
+	intents := []models.Intent{}
+	seen := map[string]bool{}
+	for _, in := range input.Intents {
+		key := strings.ToLower(strings.TrimSpace(in.Key))
+		if !intentKeyPattern.MatchString(key) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Intent keys are lowercase letters, digits and underscores", "key": in.Key})
+			return
+		}
+		if seen[key] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Intent keys must be unique", "key": key})
+			return
+		}
+		seen[key] = true
+
+		intent := models.Intent{
+			Key:          key,
+			Name:         strings.TrimSpace(in.Name),
+			Instructions: strings.TrimSpace(in.Instructions),
+			Tools:        in.Tools,
+		}
+		if e := in.Escalation; e != nil {
+			intent.Escalation = &models.IntentEscalation{
+				ForceHandoff:   e.ForceHandoff,
+				HandoffMessage: strings.TrimSpace(e.HandoffMessage),
+				SLAMinutes:     e.SLAMinutes,
+			}
+		}
+		for _, e := range in.Examples {
+			intent.Examples = append(intent.Examples, strings.TrimSpace(e))
+		}
+
+		if old := projectIntent(project, key); old != nil && old.EmbeddingModel == model &&
+			strings.Join(old.Examples, "\n") == strings.Join(intent.Examples, "\n") {
+			intent.ExampleVectors, intent.EmbeddingModel = old.ExampleVectors, old.EmbeddingModel
+		} else {
+			if project.GeminiAPIKey == "" {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The project needs a Gemini API key to classify intents"})
+				return
+			}
+			vectors, err := config.EmbedTexts(ctx, project.GeminiAPIKey, intent.Examples, false)
+			if err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to embed example phrases", "details": err.Error()})
+				return
+			}
+			intent.ExampleVectors, intent.EmbeddingModel = vectors, model
+		}
+		intents = append(intents, intent)
+	}
+
+	update := bson.M{"$set": bson.M{"intents": intents, "updated_at": time.Now()}}
+	if len(intents) == 0 {
+		update = bson.M{"$unset": bson.M{"intents": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	if _, err := config.GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": objID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update intents"})
+		return
+	}
+
+	keys := make([]string, len(intents))
+	for i, intent := range intents {
+		keys[i] = intent.Key
+	}
+	recordAudit(c, models.AuditActionIntentsUpdate, "project", projectID, objID, map[string]interface{}{
+		"intents": keys,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "intents": intents})
+}
+
+// intentStats counts the project's answered messages since a time by the
+// intent they were classified as
+func intentStats(ctx context.Context, projectID primitive.ObjectID, since time.Time) (gin.H, error) {
+	cursor, err := config.GetChatMessagesCollectionFor(projectID).Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"project_id": projectID,
+			"timestamp":  bson.M{"$gte": since},
+			"dry_run":    bson.M{"$ne": true},
+		}},
+		{"$group": bson.M{"_id": "$intent", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Intent string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	var unclassified int64
+	byIntent := gin.H{}
+	for _, g := range groups {
+		if g.Intent == "" {
+			unclassified += g.Count
+			continue
+		}
+		byIntent[g.Intent] = g.Count
+	}
+	return gin.H{"by_intent": byIntent, "unclassified": unclassified}, nil
+}
+
+// GetIntentAnalytics - GET /admin/projects/:id/intents/analytics counts
+// messages per intent over the last ?days= days (default 30), with a daily
+// series for each
+func GetIntentAnalytics(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return
+	}
+	loc, err := analyticsLocation(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	since := startOfDay(time.Now(), loc).AddDate(0, 0, -(days - 1))
+	stats, err := intentStats(ctx, objID, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load intent analytics"})
+		return
+	}
+
+	daily := gin.H{}
+	collection := config.GetChatMessagesCollectionFor(objID)
+	for key := range stats["by_intent"].(gin.H) {
+		series, err := dailySeries(ctx, collection, bson.M{"project_id": objID, "intent": key, "dry_run": bson.M{"$ne": true}},
+			"timestamp", days, loc, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load intent analytics"})
+			return
+		}
+		daily[key] = series
+	}
+
+	stats["project_id"] = projectID
+	stats["days"] = days
+	stats["timezone"] = loc.String()
+	stats["daily"] = daily
+	c.JSON(http.StatusOK, stats)
+}
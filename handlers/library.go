@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// libraryScope is the filter for the library documents the caller may
+// manage: every library for super-admins, their own for tenant admins
+func libraryScope(c *gin.Context) bson.M {
+	if c.GetBool("is_super_admin") {
+		return bson.M{}
+	}
+	return repository.LibraryOwnerFilter(c.GetString("user_id"))
+}
+
+// libraryUploadOwner is the library an upload goes to: the platform library
+// for super-admins, the caller's own for tenant admins
+func libraryUploadOwner(c *gin.Context) string {
+	if c.GetBool("is_super_admin") {
+		return ""
+	}
+	return c.GetString("user_id")
+}
+
+// withLibraryContent appends the project's enabled library documents to its
+// own document content
+func withLibraryContent(project models.Project) string {
+	if len(project.LibraryDocs) == 0 {
+		return project.PDFContent
+	}
+	docs, err := repository.AttachedLibraryDocuments(context.Background(), project)
+	if err != nil {
+		log.Printf("⚠️ Failed to load library documents for project %s: %v", project.ID.Hex(), err)
+		return project.PDFContent
+	}
+
+	var b strings.Builder
+	b.WriteString(project.PDFContent)
+	for _, doc := range docs {
+		b.WriteString("\n\n")
+		b.WriteString(doc.Content)
+	}
+	return b.String()
+}
+
+// UploadLibraryDocuments - POST /admin/library uploads PDFs to the caller's
+// library. Each file is processed once with the deployment's Gemini key and
+// can then be attached to any number of the owner's projects.
+func UploadLibraryDocuments(c *gin.Context) {
+	if config.DefaultGeminiKey == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "GEMINI_API_KEY is not configured; library documents cannot be processed"})
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+		return
+	}
+	files := form.File["pdfs"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded"})
+		return
+	}
+
+	owner := libraryUploadOwner(c)
+	collection := config.GetLibraryDocumentsCollection()
+	var uploaded []models.LibraryDocument
+	var skipped []string
+	var duplicates []gin.H
+	for _, file := range files {
+		name := filepath.Base(file.Filename)
+		if !strings.HasSuffix(strings.ToLower(name), ".pdf") || file.Size > maxSourceBytes {
+			skipped = append(skipped, name)
+			continue
+		}
+
+		hash, err := uploadedFileHash(file)
+		if err != nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		var existing models.LibraryDocument
+		filter := repository.LibraryOwnerFilter(owner)
+		filter["content_hash"] = hash
+		filter["status"] = "completed"
+		err = collection.FindOne(context.Background(), filter).Decode(&existing)
+		if err == nil {
+			duplicates = append(duplicates, gin.H{
+				"file_name": name,
+				"message":   duplicateMessage,
+				"duplicate_of": models.DocumentDuplicate{
+					Source: "library", ID: existing.ID.Hex(), FileName: existing.FileName,
+				},
+			})
+			continue
+		}
+
+		doc := models.LibraryDocument{
+			ID:          primitive.NewObjectID(),
+			OwnerID:     owner,
+			FileName:    name,
+			FileSize:    file.Size,
+			UploadedAt:  time.Now(),
+			ContentHash: hash,
+		}
+		doc.StorageKey = doc.ObjectKey()
+		src, err := file.Open()
+		if err != nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		err = storeSourceFile(context.Background(), doc.StorageKey, src, file.Size)
+		src.Close()
+		if err != nil {
+			log.Printf("⚠️ Failed to store library document %s: %v", doc.ID.Hex(), err)
+			skipped = append(skipped, name)
+			continue
+		}
+
+		content, err := processLibraryDocument(context.Background(), doc)
+		if err != nil {
+			log.Printf("⚠️ Failed to process library document %s: %v", doc.ID.Hex(), err)
+			doc.Status = "failed"
+		} else {
+			doc.Content = content
+			doc.Status = "completed"
+			doc.ProcessedAt = time.Now()
+		}
+
+		if _, err := collection.InsertOne(context.Background(), doc); err != nil {
+			removeSourceFile(context.Background(), doc.StorageKey, "")
+			skipped = append(skipped, name)
+			continue
+		}
+		uploaded = append(uploaded, doc)
+		if doc.Status == "completed" {
+			embedInBackground("library document "+doc.ID.Hex(), func(ctx context.Context) (int, error) {
+				return embedLibraryDocument(ctx, doc)
+			})
+		}
+
+		recordAudit(c, models.AuditActionLibraryUpload, "library_document", doc.ID.Hex(), primitive.NilObjectID, map[string]interface{}{
+			"file_name": doc.FileName,
+			"status":    doc.Status,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Library documents uploaded",
+		"files_uploaded": len(uploaded),
+		"files":          uploaded,
+		"skipped":        skipped,
+		"duplicates":     duplicates,
+	})
+}
+
+// processLibraryDocument extracts a stored library document's text with
+// Gemini
+func processLibraryDocument(ctx context.Context, doc models.LibraryDocument) (string, error) {
+	path, cleanup, err := localSourceCopy(ctx, &models.SourceFile{StorageKey: doc.StorageKey, FilePath: doc.FilePath})
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	return processPDFWithGemini(path, config.DefaultGeminiKey)
+}
+
+// GetLibraryDocuments - GET /admin/library lists the caller's library with
+// the number of projects each document is attached to
+func GetLibraryDocuments(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	cursor, err := config.GetLibraryDocumentsCollection().Find(ctx, libraryScope(c),
+		options.Find().
+			SetSort(bson.D{{Key: "uploaded_at", Value: -1}}).
+			SetProjection(bson.M{"content": 0}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load library"})
+		return
+	}
+	var docs []models.LibraryDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load library"})
+		return
+	}
+
+	counts, err := repository.LibraryAttachmentCounts(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count library attachments"})
+		return
+	}
+
+	type libraryEntry struct {
+		models.LibraryDocument
+		Projects int `json:"projects"`
+	}
+	entries := make([]libraryEntry, len(docs))
+	for i, doc := range docs {
+		entries[i] = libraryEntry{doc, counts[doc.ID]}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": entries, "total": len(entries)})
+}
+
+// DeleteLibraryDocument - DELETE /admin/library/:docId removes a document
+// from the caller's library and from every project it is attached to
+func DeleteLibraryDocument(c *gin.Context) {
+	docID := c.Param("docId")
+	objID, err := primitive.ObjectIDFromHex(docID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	filter := libraryScope(c)
+	filter["_id"] = objID
+	var doc models.LibraryDocument
+	if err := config.GetLibraryDocumentsCollection().FindOne(context.Background(), filter).Decode(&doc); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Library document not found"})
+		return
+	}
+
+	detached, err := repository.DeleteLibraryDocument(context.Background(), objID)
+	if errors.Is(err, repository.ErrLibraryDocumentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Library document not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete library document"})
+		return
+	}
+	removeSourceFile(context.Background(), doc.StorageKey, doc.FilePath)
+
+	recordAudit(c, models.AuditActionLibraryDelete, "library_document", docID, primitive.NilObjectID, map[string]interface{}{
+		"file_name": doc.FileName,
+		"detached":  detached,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Library document deleted",
+		"document_id":       docID,
+		"projects_detached": detached,
+	})
+}
+
+// SetProjectLibraryDocument - PUT /admin/projects/:id/library/:docId
+// attaches a document from the project owner's library to the project, or
+// includes/excludes an attached one with "enabled"
+func SetProjectLibraryDocument(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	docID, err := primitive.ObjectIDFromHex(c.Param("docId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	input := struct {
+		Enabled *bool `json:"enabled"`
+	}{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+			return
+		}
+	}
+	enabled := input.Enabled == nil || *input.Enabled
+
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"user_id": 1})).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	filter := repository.LibraryOwnerFilter(repository.LibraryOwner(project))
+	filter["_id"] = docID
+	n, err := config.GetLibraryDocumentsCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load library document"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Library document not found"})
+		return
+	}
+
+	err = repository.SetLibraryAttachment(context.Background(), objID, docID, enabled)
+	if errors.Is(err, repository.ErrProjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach library document"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionLibraryAttach, "project", projectID, objID, map[string]interface{}{
+		"document_id": docID.Hex(),
+		"enabled":     enabled,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"project_id":  projectID,
+		"document_id": docID.Hex(),
+		"enabled":     enabled,
+	})
+}
+
+// RemoveProjectLibraryDocument - DELETE /admin/projects/:id/library/:docId
+// detaches a library document from the project
+func RemoveProjectLibraryDocument(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	docID, err := primitive.ObjectIDFromHex(c.Param("docId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(),
+		bson.M{"_id": objID, "library_docs.document_id": docID},
+		bson.M{
+			"$pull": bson.M{"library_docs": bson.M{"document_id": docID}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to detach library document"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document is not attached to this project"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionLibraryDetach, "project", projectID, objID, map[string]interface{}{
+		"document_id": docID.Hex(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "document_id": docID.Hex()})
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// maxRedactionReason caps the reason an admin gives for a redaction
+const maxRedactionReason = 500
+
+// messageDeleteWindow is how long after sending a chat user may delete a
+// message (MESSAGE_DELETE_WINDOW, default 15m)
+func messageDeleteWindow() time.Duration {
+	return envDuration("MESSAGE_DELETE_WINDOW", 15*time.Minute)
+}
+
+// loadChatMessage loads one of a project's chat messages, writing the error
+// response itself
+func loadChatMessage(c *gin.Context, projectID primitive.ObjectID) (models.ChatMessage, bool) {
+	var msg models.ChatMessage
+	messageID, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return msg, false
+	}
+	err = config.GetChatMessagesCollectionFor(projectID).FindOne(context.Background(), bson.M{"_id": messageID, "project_id": projectID}).Decode(&msg)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return msg, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message"})
+		return msg, false
+	}
+	if msg.Tombstone != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Message was already removed", "tombstone": msg.Tombstone})
+		return msg, false
+	}
+	return msg, true
+}
+
+// DeleteOwnMessage - DELETE /embed/:projectId/messages/:messageId lets a
+// chat user delete a message they sent within MESSAGE_DELETE_WINDOW. The
+// sender is proven by the session's history token (X-History-Token or
+// ?token=) or, for signed-in users, their user token (X-User-Token or
+// ?user_token=). The message is tombstoned: its text is removed, it is left
+// out of later answers' context, and the original is kept in the
+// audit-only message_redactions store.
+func DeleteOwnMessage(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID, "is_active": true}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	msg, ok := loadChatMessage(c, objID)
+	if !ok {
+		return
+	}
+
+	var actorID string
+	historyToken := c.GetHeader(HeaderHistoryToken)
+	if historyToken == "" {
+		historyToken = c.Query("token")
+	}
+	userToken := c.GetHeader(HeaderUserToken)
+	if userToken == "" {
+		userToken = c.Query("user_token")
+	}
+	switch {
+	case historyToken != "":
+		sessionID, err := parseHistoryToken(historyToken, objID, time.Now())
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if sessionID != msg.SessionID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own messages"})
+			return
+		}
+		actorID = "session:" + sessionID
+	case userToken != "":
+		user, err := chatUserFromToken(c, project, userToken)
+		if err != nil {
+			respondUserTokenError(c, err)
+			return
+		}
+		if msg.UserID != user.ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own messages"})
+			return
+		}
+		actorID = "chat_user:" + user.ID.Hex()
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "History token or user token required"})
+		return
+	}
+
+	window := messageDeleteWindow()
+	if time.Since(msg.Timestamp) > window {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Messages can only be deleted within " + window.String() + " of sending",
+			"code":  "delete_window_passed",
+		})
+		return
+	}
+
+	tombstone := models.MessageTombstone{By: models.TombstoneByUser, At: time.Now()}
+	if !tombstoneMessage(c, msg, tombstone, actorID) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message_id": msg.ID.Hex(), "tombstone": tombstone})
+}
+
+// RedactMessage - POST /admin/projects/:id/messages/:messageId/redact
+// removes the text of any message of the project, with an optional
+// {"reason"}. The original is kept in the audit-only message_redactions
+// store.
+func RedactMessage(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if len(input.Reason) > maxRedactionReason {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is too long", "max": maxRedactionReason})
+		return
+	}
+
+	msg, ok := loadChatMessage(c, objID)
+	if !ok {
+		return
+	}
+
+	tombstone := models.MessageTombstone{By: models.TombstoneByAdmin, Reason: input.Reason, At: time.Now()}
+	if !tombstoneMessage(c, msg, tombstone, c.GetString("user_id")) {
+		return
+	}
+
+	recordAudit(c, models.AuditActionMessageRedact, "message", msg.ID.Hex(), objID, map[string]interface{}{
+		"session_id": msg.SessionID,
+		"reason":     input.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message_id": msg.ID.Hex(), "tombstone": tombstone})
+}
+
+// tombstoneMessage removes a message's text, writing the error response
+// itself when that fails
+func tombstoneMessage(c *gin.Context, msg models.ChatMessage, tombstone models.MessageTombstone, actorID string) bool {
+	err := repository.TombstoneMessage(c.Request.Context(), msg, tombstone, actorID)
+	if errors.Is(err, repository.ErrMessageGone) {
+		c.JSON(http.StatusGone, gin.H{"error": "Message was already removed"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove message"})
+		return false
+	}
+	return true
+}
+
+// GetMessageRedactions - GET /admin/message-redactions lists the originals
+// of removed messages, newest first, for compliance review. Takes
+// project_id, message_id and limit. Platform operators only.
+func GetMessageRedactions(c *gin.Context) {
+	filter := bson.M{}
+	for _, key := range []string{"project_id", "message_id"} {
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + key})
+			return
+		}
+		filter[key] = id
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	rows, err := repository.MessageRedactions(c.Request.Context(), filter, int64(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load redactions"})
+		return
+	}
+	if rows == nil {
+		rows = []models.MessageRedaction{}
+	}
+	c.JSON(http.StatusOK, gin.H{"redactions": rows, "count": len(rows)})
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+	"jevi-chat/utils"
+)
+
+const maxMigrationArchiveSize = 4 << 30
+
+// projectCredential is one of a project's credentials encrypted with this
+// deployment's SECRETS_ENCRYPTION_KEY
+type projectCredential struct {
+	label string
+	value *string
+}
+
+// projectCredentials lists the project's encrypted credentials, which a
+// migration carries decrypted inside its own encryption and seals again
+// with the key of the deployment it lands on
+func projectCredentials(p *models.Project) []projectCredential {
+	var creds []projectCredential
+	if p.Slack != nil {
+		creds = append(creds, projectCredential{"Slack webhook", &p.Slack.EncryptedWebhook})
+		for i := range p.Slack.Routes {
+			creds = append(creds, projectCredential{"Slack webhook for " + p.Slack.Routes[i].Channel, &p.Slack.Routes[i].EncryptedWebhook})
+		}
+	}
+	if p.Discord != nil {
+		creds = append(creds, projectCredential{"Discord webhook", &p.Discord.EncryptedWebhook})
+		for i := range p.Discord.Routes {
+			creds = append(creds, projectCredential{"Discord webhook for " + p.Discord.Routes[i].Channel, &p.Discord.Routes[i].EncryptedWebhook})
+		}
+	}
+	if p.Booking != nil {
+		creds = append(creds, projectCredential{"booking credentials", &p.Booking.EncryptedCredentials})
+	}
+	if p.Ticketing != nil {
+		creds = append(creds, projectCredential{"ticketing API token", &p.Ticketing.EncryptedToken})
+	}
+	return creds
+}
+
+// unsealCredentials decrypts the project's credentials for an export.
+// Credentials that cannot be decrypted are left out.
+func unsealCredentials(p *models.Project) []string {
+	var warnings []string
+	for _, cred := range projectCredentials(p) {
+		if *cred.value == "" {
+			continue
+		}
+		plain, err := config.DecryptSecret(*cred.value)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("The %s could not be decrypted and is not included: %v", cred.label, err))
+		}
+		*cred.value = plain
+	}
+	return warnings
+}
+
+// sealCredentials encrypts an imported project's credentials with this
+// deployment's key. Without one they are dropped, and the integrations
+// need their credentials entered again.
+func sealCredentials(p *models.Project) []string {
+	var warnings []string
+	for _, cred := range projectCredentials(p) {
+		if *cred.value == "" {
+			continue
+		}
+		sealed, err := config.EncryptSecret(*cred.value)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("The %s could not be stored (%v); enter it again", cred.label, err))
+		}
+		*cred.value = sealed
+	}
+	return warnings
+}
+
+// migrationHooks connects a migration to object storage and credentials
+func migrationHooks() repository.MigrationHooks {
+	return repository.MigrationHooks{
+		PrepareProject: sealCredentials,
+		OpenFile: func(ctx context.Context, f models.SourceFile) (io.ReadCloser, error) {
+			return openSourceFile(ctx, f.StorageKey, f.FilePath)
+		},
+		StoreFile: func(ctx context.Context, projectID primitive.ObjectID, f *models.SourceFile, r io.Reader, size int64) error {
+			key := sourceStorageKey(projectID, f.ID, f.FileName)
+			if err := storeSourceFile(ctx, key, r, size); err != nil {
+				return err
+			}
+			f.StorageKey, f.FilePath = key, ""
+			return nil
+		},
+	}
+}
+
+// ExportProjectMigration - POST /admin/projects/:id/migration/export
+// downloads the whole project, to move it to another deployment: its
+// configuration, documents and their chunks and embeddings, transcripts
+// (unless "transcripts" is false) and, with "chat_users", its chat users.
+// The archive is encrypted with the given passphrase, which importing it
+// needs, and checksummed entry by entry.
+func ExportProjectMigration(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Passphrase  string `json:"passphrase" binding:"required,min=12,max=256"`
+		ChatUsers   bool   `json:"chat_users"`
+		Transcripts *bool  `json:"transcripts"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	opts := repository.MigrationOptions{
+		ChatUsers:   input.ChatUsers,
+		Transcripts: input.Transcripts == nil || *input.Transcripts,
+	}
+
+	if n, err := config.GetProjectsCollection().CountDocuments(context.Background(), bson.M{"_id": objID}); err != nil || n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="project-%s-%s.jevimig"`, projectID, time.Now().UTC().Format("20060102T150405Z")))
+	c.Status(http.StatusOK)
+
+	sealed, err := utils.NewSealedWriter(c.Writer, input.Passphrase)
+	if err != nil {
+		log.Printf("❌ Failed to start migration export of project %s: %v", projectID, err)
+		return
+	}
+	hooks := migrationHooks()
+	hooks.PrepareProject = unsealCredentials
+	manifest, err := repository.ExportProjectMigration(c.Request.Context(), sealed, objID, opts, hooks)
+	if err != nil {
+		// Without its last chunk the download fails to open, so a broken
+		// export cannot be imported by mistake
+		log.Printf("❌ Migration export of project %s failed: %v", projectID, err)
+		return
+	}
+	if err := sealed.Close(); err != nil {
+		log.Printf("❌ Migration export of project %s failed: %v", projectID, err)
+		return
+	}
+
+	recordAudit(c, models.AuditActionMigrationExport, "project", projectID, objID, map[string]interface{}{
+		"chat_users":  opts.ChatUsers,
+		"transcripts": opts.Transcripts,
+		"entries":     len(manifest.Entries),
+		"warnings":    manifest.Warnings,
+	})
+}
+
+// ImportProjectMigration - POST /admin/migrations/import creates a project
+// from an "archive" made by ExportProjectMigration on another deployment,
+// opened with "passphrase". The whole archive is checked against its
+// manifest before anything is written; with verify_only=true that is all
+// that happens. The project keeps its ID, so it must not exist here yet.
+func ImportProjectMigration(c *gin.Context) {
+	passphrase := c.PostForm("passphrase")
+	if passphrase == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "passphrase is required"})
+		return
+	}
+	fileHeader, err := c.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No archive uploaded"})
+		return
+	}
+	if fileHeader.Size > maxMigrationArchiveSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Migration archives are limited to 4GB"})
+		return
+	}
+
+	// open returns the archive's decrypted contents; it is read twice,
+	// once to verify it and once to import it
+	open := func() (io.Reader, func(), error) {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := utils.NewSealedReader(file, passphrase)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return r, func() { file.Close() }, nil
+	}
+
+	r, done, err := open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid migration archive", "details": err.Error()})
+		return
+	}
+	manifest, err := repository.VerifyProjectMigration(r)
+	done()
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, utils.ErrSealedOpen) {
+			status = http.StatusUnauthorized
+		}
+		c.JSON(status, gin.H{"error": "Migration archive failed verification", "details": err.Error()})
+		return
+	}
+	if c.Query("verify_only") == "true" {
+		c.JSON(http.StatusOK, gin.H{"success": true, "verified": true, "manifest": manifest})
+		return
+	}
+
+	r, done, err = open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid migration archive", "details": err.Error()})
+		return
+	}
+	defer done()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	result, err := repository.ImportProjectMigration(ctx, r, manifest, migrationHooks())
+	if err == repository.ErrProjectExists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Project " + manifest.ProjectID.Hex() + " already exists on this deployment"})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Migration import of project %s failed: %v", manifest.ProjectID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import project", "details": err.Error()})
+		return
+	}
+
+	recordAudit(c, models.AuditActionMigrationImport, "project", result.ProjectID.Hex(), result.ProjectID, map[string]interface{}{
+		"exported_at": manifest.ExportedAt,
+		"documents":   result.Documents,
+		"files":       result.Files,
+		"warnings":    result.Warnings,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "import": result})
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/middleware"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+	"jevi-chat/utils"
+)
+
+// Moderation webhook decisions
+const (
+	ModerationApprove = "approve"
+	ModerationReject  = "reject"
+	ModerationModify  = "modify"
+)
+
+const (
+	defaultModerationTimeout = 3 * time.Second
+	maxModerationTimeout     = 10 * time.Second
+	defaultModerationReply   = "Sorry, I can't help with that request."
+)
+
+// moderationClient calls moderation webhooks. Customers choose the URL, so
+// only public addresses are dialled; the project's timeout bounds each call.
+var moderationClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         utils.DialPublic,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	// Redirects could bounce the payload to an unvetted host
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// moderationDecision is what the customer's webhook returns
+type moderationDecision struct {
+	Action  string `json:"action"`
+	Message string `json:"message,omitempty"` // replacement text for "modify"
+	Reply   string `json:"reply,omitempty"`   // shown to the visitor on "reject"
+	Reason  string `json:"reason,omitempty"`
+}
+
+// moderateMessage asks the project's moderation webhook whether a message
+// may be answered. Without a webhook the decision has an empty Action, which
+// callers treat as approval. When the webhook fails or times out, the
+// project's fail policy decides.
+func moderateMessage(project models.Project, message, sessionID string, user models.ChatUser, ip string) moderationDecision {
+	if project.ModerationWebhookURL == "" {
+		return moderationDecision{}
+	}
+
+	decision, err := callModerationWebhook(project, message, sessionID, user, ip)
+	if err == nil {
+		return decision
+	}
+
+	log.Printf("⚠️ Moderation webhook failed for project %s: %v", project.ID.Hex(), err)
+	if project.ModerationFailPolicy == models.ModerationFailDeny {
+		return moderationDecision{Action: ModerationReject, Reason: "moderation unavailable"}
+	}
+	return moderationDecision{Action: ModerationApprove, Reason: "moderation unavailable"}
+}
+
+func callModerationWebhook(project models.Project, message, sessionID string, user models.ChatUser, ip string) (moderationDecision, error) {
+	var decision moderationDecision
+
+	payload := map[string]interface{}{
+		"project_id": project.ID.Hex(),
+		"session_id": sessionID,
+		"message":    message,
+		"ip_address": ip,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	}
+	if !user.ID.IsZero() {
+		payload["user"] = map[string]interface{}{
+			"id":    user.ID.Hex(),
+			"name":  user.Name,
+			"email": user.Email,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return decision, err
+	}
+
+	timeout := time.Duration(project.ModerationTimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultModerationTimeout
+	}
+	if timeout > maxModerationTimeout {
+		timeout = maxModerationTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, project.ModerationWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return decision, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, project.ID, project.ModerationSecret, body)
+
+	resp, err := moderationClient.Do(req)
+	if err != nil {
+		return decision, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decision, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&decision); err != nil {
+		return decision, fmt.Errorf("invalid webhook response: %v", err)
+	}
+
+	switch decision.Action {
+	case ModerationApprove, ModerationReject:
+	case ModerationModify:
+		decision.Message = sanitizeInput(decision.Message)
+		if decision.Message == "" {
+			return decision, fmt.Errorf("modify decision without a message")
+		}
+	default:
+		return decision, fmt.Errorf("unknown action %q", decision.Action)
+	}
+	return decision, nil
+}
+
+// signWebhookRequest signs a call to a customer's endpoint the same way as
+// incoming widget requests, so customers can verify the call came from us
+func signWebhookRequest(req *http.Request, projectID primitive.ObjectID, secret string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := randomHex(16)
+	signature := middleware.SignPayload(secret,
+		middleware.SignaturePayload(projectID.Hex(), timestamp, nonce, req.Method, req.URL.Path, body))
+	req.Header.Set(middleware.HeaderTimestamp, timestamp)
+	req.Header.Set(middleware.HeaderNonce, nonce)
+	req.Header.Set(middleware.HeaderSignature, signature)
+}
+
+// rejectionReply is the text shown to a visitor whose message was rejected
+func (d moderationDecision) rejectionReply() string {
+	if d.Reply != "" {
+		return d.Reply
+	}
+	return defaultModerationReply
+}
+
+// SetModerationWebhook - PUT /admin/projects/:id/moderation configures the
+// project's moderation webhook. An empty webhook_url disables moderation.
+func SetModerationWebhook(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		WebhookURL string `json:"webhook_url"`
+		TimeoutMs  int    `json:"timeout_ms" binding:"omitempty,min=100,max=10000"`
+		FailPolicy string `json:"fail_policy" binding:"omitempty,oneof=allow deny"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	if input.WebhookURL != "" {
+		u, err := url.Parse(input.WebhookURL)
+		if err != nil || u.Host == "" || u.Scheme != "https" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "webhook_url must be an absolute https URL"})
+			return
+		}
+	}
+	if input.FailPolicy == "" {
+		input.FailPolicy = models.ModerationFailAllow
+	}
+
+	collection := config.GetProjectsCollection()
+	var project models.Project
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	set := bson.M{
+		"moderation_webhook_url": input.WebhookURL,
+		"moderation_timeout_ms":  input.TimeoutMs,
+		"moderation_fail_policy": input.FailPolicy,
+		"updated_at":             time.Now(),
+	}
+	response := gin.H{
+		"success":     true,
+		"project_id":  projectID,
+		"webhook_url": input.WebhookURL,
+		"timeout_ms":  input.TimeoutMs,
+		"fail_policy": input.FailPolicy,
+	}
+	if input.WebhookURL != "" && project.ModerationSecret == "" {
+		secret := repository.NewModerationSecret()
+		set["moderation_secret"] = secret
+		response["webhook_secret"] = secret
+		response["message"] = "Store the webhook secret now; it will not be shown again"
+	}
+
+	if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": set}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update moderation settings"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionModerationUpdate, "project", projectID, objID, map[string]interface{}{
+		"webhook_url": input.WebhookURL,
+		"fail_policy": input.FailPolicy,
+	})
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"jevi-chat/models"
+)
+
+// notice is a notification title and body in one language. Bodies are
+// fmt formats whose arguments are listed per event below.
+type notice struct {
+	Title string
+	Body  string
+}
+
+// securityNotices holds the security notification texts per event and
+// locale. Every event must have a models.DefaultLocale entry.
+var securityNotices = map[string]map[string]notice{
+	// args: user agent, IP, time
+	"new_device_login": {
+		"en": {"New sign-in to your account", "Your account was signed into from a new device (%[1]s, IP %[2]s) at %[3]s. If this wasn't you, change your password now."},
+		"es": {"Nuevo inicio de sesión en tu cuenta", "Se inició sesión en tu cuenta desde un dispositivo nuevo (%[1]s, IP %[2]s) el %[3]s. Si no fuiste tú, cambia tu contraseña ahora."},
+		"fr": {"Nouvelle connexion à votre compte", "Une connexion à votre compte a eu lieu depuis un nouvel appareil (%[1]s, IP %[2]s) le %[3]s. Si ce n'était pas vous, changez votre mot de passe maintenant."},
+		"de": {"Neue Anmeldung bei Ihrem Konto", "Ihr Konto wurde am %[3]s von einem neuen Gerät angemeldet (%[1]s, IP %[2]s). Falls Sie das nicht waren, ändern Sie jetzt Ihr Passwort."},
+		"hi": {"आपके खाते में नया साइन-इन", "आपके खाते में %[3]s को एक नए डिवाइस (%[1]s, IP %[2]s) से साइन-इन किया गया। यदि यह आप नहीं थे, तो अभी अपना पासवर्ड बदलें।"},
+	},
+	// args: time, IP
+	"password_change": {
+		"en": {"Your password was changed", "The password for your account was changed at %[1]s from IP %[2]s. If this wasn't you, contact support immediately."},
+		"es": {"Tu contraseña ha cambiado", "La contraseña de tu cuenta se cambió el %[1]s desde la IP %[2]s. Si no fuiste tú, contacta con soporte de inmediato."},
+		"fr": {"Votre mot de passe a été modifié", "Le mot de passe de votre compte a été modifié le %[1]s depuis l'IP %[2]s. Si ce n'était pas vous, contactez immédiatement le support."},
+		"de": {"Ihr Passwort wurde geändert", "Das Passwort Ihres Kontos wurde am %[1]s von IP %[2]s geändert. Falls Sie das nicht waren, wenden Sie sich sofort an den Support."},
+		"hi": {"आपका पासवर्ड बदल दिया गया", "आपके खाते का पासवर्ड %[1]s को IP %[2]s से बदला गया। यदि यह आप नहीं थे, तो तुरंत सहायता से संपर्क करें।"},
+	},
+	// args: new role
+	"role_change": {
+		"en": {"Your account role was changed", "Your account role is now %[1]q. If you did not expect this change, contact support."},
+		"es": {"El rol de tu cuenta ha cambiado", "El rol de tu cuenta ahora es %[1]q. Si no esperabas este cambio, contacta con soporte."},
+		"fr": {"Le rôle de votre compte a changé", "Le rôle de votre compte est désormais %[1]q. Si vous ne vous attendiez pas à ce changement, contactez le support."},
+		"de": {"Die Rolle Ihres Kontos wurde geändert", "Ihre Kontorolle ist jetzt %[1]q. Falls Sie diese Änderung nicht erwartet haben, wenden Sie sich an den Support."},
+		"hi": {"आपके खाते की भूमिका बदल दी गई", "आपके खाते की भूमिका अब %[1]q है। यदि आपको इस बदलाव की उम्मीद नहीं थी, तो सहायता से संपर्क करें।"},
+	},
+	// args: key name, project name, requests in the hour, usual requests an
+	// hour, percentage of them that failed
+	"api_key_anomaly": {
+		"en": {"Unusual use of an API key", "API key %[1]q of project %[2]q made %[3]d requests in the last hour, against about %[4]d an hour usually, and %[5]d%% of them failed. If you don't recognise this traffic the key may have leaked: revoke it."},
+		"es": {"Uso inusual de una clave de API", "La clave de API %[1]q del proyecto %[2]q hizo %[3]d solicitudes en la última hora, frente a unas %[4]d por hora habitualmente, y el %[5]d%% falló. Si no reconoces este tráfico, la clave puede haberse filtrado: revócala."},
+		"fr": {"Utilisation inhabituelle d'une clé d'API", "La clé d'API %[1]q du projet %[2]q a fait %[3]d requêtes au cours de la dernière heure, contre environ %[4]d par heure d'habitude, et %[5]d%% ont échoué. Si vous ne reconnaissez pas ce trafic, la clé a peut-être fuité : révoquez-la."},
+		"de": {"Ungewöhnliche Nutzung eines API-Schlüssels", "Der API-Schlüssel %[1]q des Projekts %[2]q hat in der letzten Stunde %[3]d Anfragen gestellt, sonst etwa %[4]d pro Stunde, und %[5]d%% davon sind fehlgeschlagen. Wenn Sie diesen Verkehr nicht kennen, ist der Schlüssel möglicherweise durchgesickert: Widerrufen Sie ihn."},
+		"hi": {"API कुंजी का असामान्य उपयोग", "प्रोजेक्ट %[2]q की API कुंजी %[1]q ने पिछले घंटे में %[3]d अनुरोध किए, जबकि आमतौर पर लगभग %[4]d प्रति घंटा होते हैं, और इनमें से %[5]d%% विफल रहे। यदि आप इस ट्रैफ़िक को नहीं पहचानते, तो कुंजी लीक हो सकती है: इसे रद्द करें।"},
+	},
+}
+
+// localizedNotice renders an event's notice in the user's language
+func localizedNotice(user models.User, event string, args ...interface{}) (string, string) {
+	texts := securityNotices[event]
+	n, ok := texts[user.PreferredLocale()]
+	if !ok {
+		n = texts[models.DefaultLocale]
+	}
+	return n.Title, fmt.Sprintf(n.Body, args...)
+}
+
+// userTime formats t in the user's timezone for notices and reports
+func userTime(user models.User, t time.Time) string {
+	return t.In(user.Location()).Format("Mon, 02 Jan 2006 15:04 MST")
+}
@@ -4,6 +4,7 @@ import (
     "context"
     "fmt"
     "net/http"
+    "strconv"
     "time"
 
     "github.com/gin-gonic/gin"
@@ -22,12 +23,18 @@ func CreateNotification(projectID primitive.ObjectID, userID primitive.ObjectID,
         expiryTime = time.Now().Add(config.NotificationSettings.DefaultExpiry)
     }
 
+    severity := models.NotificationSeverity(notificationType)
+    if s, ok := metadata["severity"].(string); ok && models.NotificationSeverityTypes[s] != nil {
+        severity = s
+    }
+
     notification := models.Notification{
         ProjectID: projectID,
         UserID:    userID,
         Type:      notificationType,
         Title:     title,
         Message:   message,
+        Severity:  severity,
         IsRead:    false,
         CreatedAt: time.Now(),
         ExpiresAt: expiryTime,
@@ -35,11 +42,23 @@ func CreateNotification(projectID primitive.ObjectID, userID primitive.ObjectID,
     }
 
     collection := config.GetNotificationsCollection()
-    _, err := collection.InsertOne(context.Background(), notification)
+    result, err := collection.InsertOne(context.Background(), notification)
     if err != nil {
         fmt.Printf("Failed to create notification: %v\n", err)
         return err
     }
+    notification.ID, _ = result.InsertedID.(primitive.ObjectID)
+
+    // Push it to open notification streams, unless the change stream does
+    if !notificationWatching.Load() {
+        publishNotification(notification)
+    }
+    // Post to the project's Slack channels, or the server-wide webhook
+    go sendWebhookNotification(notification)
+    // ...and to its Discord channels
+    go sendDiscordNotification(notification)
+    // Email whoever opted in to this type
+    go sendEmailNotification(notification)
 
     return nil
 }
@@ -73,35 +92,57 @@ func CreateLimitExpiredNotification(projectID primitive.ObjectID, projectName st
         return
     }
 
-    // Optional: Send webhook notification
-    go sendWebhookNotification(projectID, projectName, limitType, currentUsage, limit)
-    
     fmt.Printf("✅ Limit expired notification created for project: %s (%s: %d/%d)\n", 
         projectName, limitType, currentUsage, limit)
 }
 
-// GetNotifications - Get notifications for admin/user
-func GetNotifications(c *gin.Context) {
-    // Check if user is admin or get user-specific notifications
-    isAdmin := c.GetBool("is_admin")
+// notificationScope is the filter for the notifications the caller may see:
+// all of them for super-admins, their own for everyone else
+func notificationScope(c *gin.Context) (bson.M, error) {
+    scope := bson.M{}
     userID := c.GetString("user_id")
-
-    collection := config.GetNotificationsCollection()
-    
-    // Build filter based on user role
-    filter := bson.M{
-        "expires_at": bson.M{"$gt": time.Now()}, // Only non-expired notifications
-    }
-
-    if !isAdmin && userID != "" {
-        // Regular users only see their notifications
+    if !c.GetBool("is_super_admin") && userID != "" {
         userObjID, err := primitive.ObjectIDFromHex(userID)
         if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-            return
+            return nil, err
         }
-        filter["user_id"] = userObjID
+        scope["user_id"] = userObjID
     }
+    return scope, nil
+}
+
+// severityFilter matches notifications of a severity, including older ones
+// stored before severities were, by their type
+func severityFilter(severity string) bson.M {
+    return bson.M{"$or": []bson.M{
+        {"severity": severity},
+        {"severity": bson.M{"$exists": false}, "type": bson.M{"$in": models.NotificationSeverityTypes[severity]}},
+    }}
+}
+
+// parseNotificationTime reads a from/to bound, either RFC 3339 or a
+// YYYY-MM-DD date (UTC midnight)
+func parseNotificationTime(value string) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339, value); err == nil {
+        return t, nil
+    }
+    return time.Parse("2006-01-02", value)
+}
+
+// GetNotifications - Get notifications for admin/user, newest first.
+// Filters: type, project_id, severity (info, warning, error), from and to
+// (RFC 3339 or YYYY-MM-DD), unread=true and status (inbox, the default,
+// archived or all). Pages hold limit notifications (default 50, at most
+// 200); pass next_cursor back as cursor for the next page.
+func GetNotifications(c *gin.Context) {
+    collection := config.GetNotificationsCollection()
+
+    filter, err := notificationScope(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+    filter["expires_at"] = bson.M{"$gt": time.Now()} // Only non-expired notifications
 
     // Get query parameters for filtering
     notificationType := c.Query("type")
@@ -117,10 +158,83 @@ func GetNotifications(c *gin.Context) {
         }
     }
 
-    // Sort by creation date (newest first) and limit to 50
+    severity := c.Query("severity")
+    if severity != "" {
+        if models.NotificationSeverityTypes[severity] == nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "severity must be info, warning or error"})
+            return
+        }
+        for k, v := range severityFilter(severity) {
+            filter[k] = v
+        }
+    }
+
+    created := bson.M{}
+    if from := c.Query("from"); from != "" {
+        t, err := parseNotificationTime(from)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "from must be RFC 3339 or YYYY-MM-DD"})
+            return
+        }
+        created["$gte"] = t
+    }
+    if to := c.Query("to"); to != "" {
+        t, err := parseNotificationTime(to)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "to must be RFC 3339 or YYYY-MM-DD"})
+            return
+        }
+        if len(to) == len("2006-01-02") {
+            t = t.AddDate(0, 0, 1) // the whole day
+        }
+        created["$lt"] = t
+    }
+    if len(created) > 0 {
+        filter["created_at"] = created
+    }
+
+    status := c.DefaultQuery("status", "inbox")
+    switch status {
+    case "inbox":
+        filter["is_archived"] = bson.M{"$ne": true}
+    case "archived":
+        filter["is_archived"] = true
+    case "all":
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "status must be inbox, archived or all"})
+        return
+    }
+
+    // Unread counts ignore the read and paging filters
+    unreadCount, _ := collection.CountDocuments(context.Background(), bson.M{
+        "$and": []bson.M{
+            filter,
+            {"is_read": false},
+        },
+    })
+
+    if c.Query("unread") == "true" {
+        filter["is_read"] = false
+    }
+
+    if cursorParam := c.Query("cursor"); cursorParam != "" {
+        before, err := primitive.ObjectIDFromHex(cursorParam)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+            return
+        }
+        filter["_id"] = bson.M{"$lt": before}
+    }
+
+    limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+    if limit <= 0 || limit > 200 {
+        limit = 50
+    }
+
+    // Newest first; IDs follow creation order, so they double as the cursor
     opts := options.Find().
-        SetSort(bson.D{{"created_at", -1}}).
-        SetLimit(50)
+        SetSort(bson.D{{"_id", -1}}).
+        SetLimit(int64(limit + 1))
 
     cursor, err := collection.Find(context.Background(), filter, opts)
     if err != nil {
@@ -129,30 +243,41 @@ func GetNotifications(c *gin.Context) {
     }
     defer cursor.Close(context.Background())
 
-    var notifications []models.Notification
+    notifications := []models.Notification{}
     if err := cursor.All(context.Background(), &notifications); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse notifications"})
         return
     }
 
-    // Count unread notifications
-    unreadCount, _ := collection.CountDocuments(context.Background(), bson.M{
-        "$and": []bson.M{
-            filter,
-            {"is_read": false},
-        },
-    })
+    hasMore := len(notifications) > limit
+    if hasMore {
+        notifications = notifications[:limit]
+    }
+    for i := range notifications {
+        if notifications[i].Severity == "" {
+            notifications[i].Severity = models.NotificationSeverity(notifications[i].Type)
+        }
+    }
 
-    c.JSON(http.StatusOK, gin.H{
+    response := gin.H{
         "success":       true,
         "notifications": notifications,
         "count":         len(notifications),
         "unread_count":  unreadCount,
+        "has_more":      hasMore,
         "filter_applied": gin.H{
             "type":       notificationType,
             "project_id": projectID,
+            "severity":   severity,
+            "status":     status,
+            "from":       c.Query("from"),
+            "to":         c.Query("to"),
         },
-    })
+    }
+    if hasMore {
+        response["next_cursor"] = notifications[len(notifications)-1].ID.Hex()
+    }
+    c.JSON(http.StatusOK, response)
 }
 
 // MarkNotificationAsRead - Mark notification as read
@@ -164,10 +289,17 @@ func MarkNotificationAsRead(c *gin.Context) {
         return
     }
 
+    filter, err := notificationScope(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+    filter["_id"] = objID
+
     collection := config.GetNotificationsCollection()
     result, err := collection.UpdateOne(
         context.Background(),
-        bson.M{"_id": objID},
+        filter,
         bson.M{"$set": bson.M{"is_read": true}},
     )
 
@@ -189,19 +321,12 @@ func MarkNotificationAsRead(c *gin.Context) {
 
 // MarkAllNotificationsAsRead - Mark all notifications as read for user
 func MarkAllNotificationsAsRead(c *gin.Context) {
-    isAdmin := c.GetBool("is_admin")
-    userID := c.GetString("user_id")
-
-    filter := bson.M{"is_read": false}
-    
-    if !isAdmin && userID != "" {
-        userObjID, err := primitive.ObjectIDFromHex(userID)
-        if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-            return
-        }
-        filter["user_id"] = userObjID
+    filter, err := notificationScope(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
     }
+    filter["is_read"] = false
 
     collection := config.GetNotificationsCollection()
     result, err := collection.UpdateMany(
@@ -222,6 +347,151 @@ func MarkAllNotificationsAsRead(c *gin.Context) {
     })
 }
 
+// setNotificationArchived archives or restores one notification
+func setNotificationArchived(c *gin.Context, archived bool) {
+    objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+        return
+    }
+    filter, err := notificationScope(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+    filter["_id"] = objID
+
+    update := bson.M{"$set": bson.M{"is_archived": true, "archived_at": time.Now()}}
+    if !archived {
+        update = bson.M{"$unset": bson.M{"is_archived": "", "archived_at": ""}}
+    }
+    result, err := config.GetNotificationsCollection().UpdateOne(context.Background(), filter, update)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+        return
+    }
+    if result.MatchedCount == 0 {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "success":     true,
+        "id":          objID.Hex(),
+        "is_archived": archived,
+    })
+}
+
+// ArchiveNotification - PUT /notifications/:id/archive moves a
+// notification out of the inbox without deleting it
+func ArchiveNotification(c *gin.Context) {
+    setNotificationArchived(c, true)
+}
+
+// UnarchiveNotification - PUT /notifications/:id/unarchive returns an
+// archived notification to the inbox
+func UnarchiveNotification(c *gin.Context) {
+    setNotificationArchived(c, false)
+}
+
+// ArchiveNotifications - POST /notifications/archive archives in bulk:
+// the notifications listed in ids, or every inbox notification matching
+// the given read, type, project_id, severity and before (created before;
+// RFC 3339 or YYYY-MM-DD) criteria. At least one criterion is required.
+func ArchiveNotifications(c *gin.Context) {
+    var input struct {
+        IDs       []string `json:"ids"`
+        Read      *bool    `json:"read"`
+        Type      string   `json:"type"`
+        ProjectID string   `json:"project_id"`
+        Severity  string   `json:"severity"`
+        Before    string   `json:"before"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+        return
+    }
+
+    filter, err := notificationScope(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+    filter["is_archived"] = bson.M{"$ne": true}
+
+    if len(input.IDs) > 0 {
+        if len(input.IDs) > 1000 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "At most 1000 IDs per request"})
+            return
+        }
+        ids := make([]primitive.ObjectID, len(input.IDs))
+        for i, id := range input.IDs {
+            objID, err := primitive.ObjectIDFromHex(id)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID " + id})
+                return
+            }
+            ids[i] = objID
+        }
+        filter["_id"] = bson.M{"$in": ids}
+    } else {
+        criteria := 0
+        if input.Read != nil {
+            filter["is_read"] = *input.Read
+            criteria++
+        }
+        if input.Type != "" {
+            filter["type"] = input.Type
+            criteria++
+        }
+        if input.ProjectID != "" {
+            objID, err := primitive.ObjectIDFromHex(input.ProjectID)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+                return
+            }
+            filter["project_id"] = objID
+            criteria++
+        }
+        if input.Severity != "" {
+            if models.NotificationSeverityTypes[input.Severity] == nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "severity must be info, warning or error"})
+                return
+            }
+            for k, v := range severityFilter(input.Severity) {
+                filter[k] = v
+            }
+            criteria++
+        }
+        if input.Before != "" {
+            before, err := parseNotificationTime(input.Before)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "before must be RFC 3339 or YYYY-MM-DD"})
+                return
+            }
+            filter["created_at"] = bson.M{"$lt": before}
+            criteria++
+        }
+        if criteria == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Give ids or at least one of read, type, project_id, severity or before"})
+            return
+        }
+    }
+
+    result, err := config.GetNotificationsCollection().UpdateMany(context.Background(), filter,
+        bson.M{"$set": bson.M{"is_archived": true, "archived_at": time.Now()}},
+    )
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive notifications"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "success":        true,
+        "archived_count": result.ModifiedCount,
+    })
+}
+
 // DeleteNotification - Delete a notification
 func DeleteNotification(c *gin.Context) {
     notificationID := c.Param("id")
@@ -231,8 +501,15 @@ func DeleteNotification(c *gin.Context) {
         return
     }
 
+    filter, err := notificationScope(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+    filter["_id"] = objID
+
     collection := config.GetNotificationsCollection()
-    result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objID})
+    result, err := collection.DeleteOne(context.Background(), filter)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notification"})
         return
@@ -330,20 +607,67 @@ func TestNotificationSystem(c *gin.Context) {
     })
 }
 
-// sendWebhookNotification - Optional webhook notification sender
-func sendWebhookNotification(projectID primitive.ObjectID, projectName, limitType string, currentUsage, limit int) {
-    // Skip if no webhook configuration
-    if config.NotificationSettings == nil || config.NotificationSettings.SlackWebhookURL == "" {
-        return
+// CheckTenantStorageQuotas - Warn about projects nearing or exceeding their
+// storage quota. At most one alert per threshold is active per project.
+func CheckTenantStorageQuotas() error {
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+    defer cancel()
+
+    tenants, err := config.GetTenantStorageStats(ctx)
+    if err != nil {
+        return err
     }
-    
-    // Implement Slack webhook sending logic here
-    // This is a placeholder for webhook integration
-    fmt.Printf("📢 Webhook notification would be sent: Project %s reached %s limit (%d/%d)\n", 
-        projectName, limitType, currentUsage, limit)
-    
-    // TODO: Implement actual webhook sending
-    // You can add HTTP POST request to webhook URL here
+
+    collection := config.GetNotificationsCollection()
+    for _, tenant := range tenants {
+        threshold := 0
+        switch {
+        case tenant.QuotaPercentage >= 100:
+            threshold = 100
+        case tenant.QuotaPercentage >= 80:
+            threshold = 80
+        default:
+            continue
+        }
+
+        projectID, err := primitive.ObjectIDFromHex(tenant.ProjectID)
+        if err != nil {
+            continue
+        }
+
+        alertKey := fmt.Sprintf("storage_quota_%d", threshold)
+        existing, _ := collection.CountDocuments(ctx, bson.M{
+            "project_id":         projectID,
+            "metadata.alert_key": alertKey,
+            "expires_at":         bson.M{"$gt": time.Now()},
+        })
+        if existing > 0 {
+            continue
+        }
+
+        notificationType := models.NotificationTypeWarning
+        if threshold == 100 {
+            notificationType = models.NotificationTypeError
+        }
+
+        CreateNotification(
+            projectID,
+            primitive.NilObjectID,
+            notificationType,
+            fmt.Sprintf("Storage Quota %d%% - %s", threshold, tenant.ProjectName),
+            fmt.Sprintf("Project is using %s of its %s storage quota (%.0f%%).",
+                formatFileSize(tenant.TotalBytes), formatFileSize(tenant.QuotaBytes), tenant.QuotaPercentage),
+            map[string]interface{}{
+                "alert_key":        alertKey,
+                "total_bytes":      tenant.TotalBytes,
+                "quota_bytes":      tenant.QuotaBytes,
+                "quota_percentage": tenant.QuotaPercentage,
+                "auto_generated":   true,
+            },
+        )
+    }
+
+    return nil
 }
 
 // CleanupExpiredNotifications - Background task to clean up expired notifications
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	// notificationStreamBuffer is how many notifications a slow stream may
+	// fall behind by before it is told to reload instead
+	notificationStreamBuffer = 64
+	notificationHeartbeat    = 25 * time.Second
+	// notificationReplayLimit caps the notifications replayed to a stream
+	// that reconnects with Last-Event-ID
+	notificationReplayLimit = 100
+)
+
+// notificationSubscriber is one open notification stream
+type notificationSubscriber struct {
+	ch chan models.Notification
+	// userID limits the stream to the user's notifications; zero for
+	// super-admins
+	userID primitive.ObjectID
+	lagged atomic.Bool
+}
+
+var (
+	notificationSubsMu sync.RWMutex
+	notificationSubs   = map[*notificationSubscriber]struct{}{}
+
+	// notificationWatching is set while a change stream feeds the open
+	// streams, so notifications created on any instance reach them.
+	// Otherwise CreateNotification publishes to this instance's streams.
+	notificationWatching atomic.Bool
+)
+
+func subscribeNotifications(userID primitive.ObjectID) *notificationSubscriber {
+	sub := &notificationSubscriber{ch: make(chan models.Notification, notificationStreamBuffer), userID: userID}
+	notificationSubsMu.Lock()
+	notificationSubs[sub] = struct{}{}
+	notificationSubsMu.Unlock()
+	return sub
+}
+
+func unsubscribeNotifications(sub *notificationSubscriber) {
+	notificationSubsMu.Lock()
+	delete(notificationSubs, sub)
+	notificationSubsMu.Unlock()
+}
+
+// publishNotification hands a new notification to the open streams allowed
+// to see it. Streams never block the publisher: one that is too far behind
+// misses the notification and is told to reload.
+func publishNotification(n models.Notification) {
+	notificationSubsMu.RLock()
+	defer notificationSubsMu.RUnlock()
+	for sub := range notificationSubs {
+		if !sub.userID.IsZero() && sub.userID != n.UserID {
+			continue
+		}
+		select {
+		case sub.ch <- n:
+		default:
+			sub.lagged.Store(true)
+		}
+	}
+}
+
+// WatchNotifications follows inserts into the notifications collection
+// with a change stream and publishes them to this instance's streams, so
+// an admin connected to one instance sees notifications created on
+// another. Change streams need a replica set; on a standalone server it
+// returns at once, and streams only see this instance's notifications.
+func WatchNotifications() {
+	ctx := context.Background()
+	if !repository.TransactionsSupported(ctx) {
+		log.Println("⚠️ Notification streams only carry this instance's notifications (change streams need a replica set)")
+		return
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	var resumeToken bson.Raw
+	backoff := time.Second
+	for {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+		stream, err := config.GetNotificationsCollection().Watch(ctx, pipeline, opts)
+		if err != nil {
+			if !notificationWatching.Load() && resumeToken == nil {
+				log.Printf("⚠️ Failed to watch notifications, streams carry this instance's only: %v", err)
+				return
+			}
+			log.Printf("⚠️ Notification change stream failed, retrying in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		notificationWatching.Store(true)
+		backoff = time.Second
+
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument models.Notification `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				log.Printf("⚠️ Failed to decode notification change: %v", err)
+			} else {
+				publishNotification(change.FullDocument)
+			}
+			resumeToken = stream.ResumeToken()
+		}
+		if err := stream.Err(); err != nil {
+			log.Printf("⚠️ Notification change stream ended: %v", err)
+		}
+		stream.Close(ctx)
+		time.Sleep(backoff)
+	}
+}
+
+// StreamNotifications - GET /api/notifications/stream pushes notifications
+// to the dashboard as Server-Sent Events as they are created, instead of
+// polling GET /api/notifications. Each "notification" event carries one
+// notification, with its ID as the event ID; a browser that reconnects
+// sends the last one as Last-Event-ID and is sent what it missed. A
+// "resync" event means the stream fell behind and the list should be
+// reloaded. Takes the same type, project_id and severity filters as
+// GetNotifications.
+func StreamNotifications(c *gin.Context) {
+	var userID primitive.ObjectID
+	scope, err := notificationScope(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if id, ok := scope["user_id"].(primitive.ObjectID); ok {
+		userID = id
+	}
+
+	notificationType := c.Query("type")
+	var projectID primitive.ObjectID
+	if p := c.Query("project_id"); p != "" {
+		if projectID, err = primitive.ObjectIDFromHex(p); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+	}
+	severity := c.Query("severity")
+	if severity != "" && models.NotificationSeverityTypes[severity] == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "severity must be info, warning or error"})
+		return
+	}
+	matches := func(n models.Notification) bool {
+		if notificationType != "" && n.Type != notificationType {
+			return false
+		}
+		if !projectID.IsZero() && n.ProjectID != projectID {
+			return false
+		}
+		if severity != "" {
+			s := n.Severity
+			if s == "" {
+				s = models.NotificationSeverity(n.Type)
+			}
+			if s != severity {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Subscribe before replaying, so nothing created in between is lost;
+	// replayed notifications are skipped if they also come through live
+	sub := subscribeNotifications(userID)
+	defer unsubscribeNotifications(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	replayed := map[primitive.ObjectID]bool{}
+	send := func(n models.Notification) {
+		if replayed[n.ID] || !matches(n) {
+			return
+		}
+		c.Render(-1, sse.Event{Event: "notification", Id: n.ID.Hex(), Data: n})
+	}
+
+	if last := c.GetHeader("Last-Event-ID"); last != "" {
+		if after, err := primitive.ObjectIDFromHex(last); err == nil {
+			filter := bson.M{"_id": bson.M{"$gt": after}, "expires_at": bson.M{"$gt": time.Now()}}
+			for k, v := range scope {
+				filter[k] = v
+			}
+			cursor, err := config.GetNotificationsCollection().Find(c.Request.Context(), filter,
+				options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(notificationReplayLimit))
+			var missed []models.Notification
+			if err == nil && cursor.All(c.Request.Context(), &missed) == nil {
+				for _, n := range missed {
+					send(n)
+					replayed[n.ID] = true
+				}
+				if len(missed) == notificationReplayLimit {
+					c.Render(-1, sse.Event{Event: "resync", Data: gin.H{"reason": "too many missed notifications"}})
+				}
+			}
+		}
+	}
+	c.Render(-1, sse.Event{Event: "ready", Data: gin.H{"all_instances": notificationWatching.Load()}})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(notificationHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case n := <-sub.ch:
+			send(n)
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": ping\n\n")
+		}
+		if sub.lagged.Swap(false) {
+			c.Render(-1, sse.Event{Event: "resync", Data: gin.H{"reason": "the stream fell behind"}})
+		}
+		c.Writer.Flush()
+	}
+}
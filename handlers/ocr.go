@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"jevi-chat/models"
+)
+
+const (
+	// ocrTimeout bounds running OCR over one document
+	ocrTimeout = 5 * time.Minute
+
+	ocrEngineGemini    = "gemini"
+	ocrEngineTesseract = "tesseract"
+)
+
+// ocrPrompt asks Gemini for a verbatim transcription of a scanned document
+const ocrPrompt = `This document is a scan without a text layer. Transcribe all of the
+text visible on its pages exactly as written, including tables, labels and
+handwriting you can read. Do not summarize or add anything. Keep the text
+in page order and start each page with a line of the form "--- Page N ---",
+where N is the page number.`
+
+// ocrMinChars is the least text a PDF must yield before OCR is tried
+// (OCR_MIN_CHARS, default 200; 0 turns OCR off)
+func ocrMinChars() int {
+	n, err := strconv.Atoi(os.Getenv("OCR_MIN_CHARS"))
+	if err != nil || n < 0 {
+		return 200
+	}
+	return n
+}
+
+// ocrEngine is how scanned PDFs are read: "gemini" (the default) has the
+// project's Gemini model transcribe the pages; "tesseract" renders them
+// with pdftoppm and reads them with the tesseract CLI (OCR_ENGINE)
+func ocrEngine() string {
+	if strings.EqualFold(os.Getenv("OCR_ENGINE"), ocrEngineTesseract) {
+		return ocrEngineTesseract
+	}
+	return ocrEngineGemini
+}
+
+// extractedChars counts the characters of extracted text, leaving out the
+// page markers
+func extractedChars(content string) int {
+	return utf8.RuneCountInString(strings.Join(strings.Fields(pageMarkerPattern.ReplaceAllString(content, "")), " "))
+}
+
+// withOCRFallback runs OCR over a PDF, at path on disk, whose extracted
+// content is shorter than the threshold, which is what a scan without a
+// text layer yields, and records the outcome on the file. It returns the
+// content the file is answered from, and an error only when neither
+// yielded any text.
+func withOCRFallback(project models.Project, file *models.SourceFile, path, content string) (string, error) {
+	chars := extractedChars(content)
+	file.TextChars = chars
+	threshold := ocrMinChars()
+	if threshold == 0 || chars >= threshold {
+		return content, nil
+	}
+
+	file.OCREngine = ocrEngine()
+	text, err := runOCR(file.OCREngine, path, project.GeminiAPIKey)
+	if err != nil {
+		log.Printf("⚠️ OCR of %s failed: %v", file.FileName, err)
+		file.OCRStatus = models.OCRStatusFailed
+		if chars == 0 {
+			return "", fmt.Errorf("no text found and OCR failed: %v", err)
+		}
+		return content, nil
+	}
+	if ocrChars := extractedChars(text); ocrChars > chars {
+		file.OCRStatus = models.OCRStatusApplied
+		file.TextChars = ocrChars
+		return text, nil
+	}
+	file.OCRStatus = models.OCRStatusNoText
+	if chars == 0 {
+		return "", errors.New("no text found in the document, even with OCR")
+	}
+	return content, nil
+}
+
+// runOCR reads the text of a scanned PDF with the given engine
+func runOCR(engine, path, apiKey string) (string, error) {
+	if engine == ocrEngineTesseract {
+		return tesseractOCR(path)
+	}
+	return generateFromFile(path, apiKey, ocrPrompt, ocrTimeout)
+}
+
+// tesseractOCR renders each page of a PDF to an image and reads it with
+// tesseract. OCR_LANGUAGES is passed as tesseract's -l (default "eng").
+func tesseractOCR(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ocrTimeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "ocr-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	if out, err := exec.CommandContext(ctx, "pdftoppm",
+		"-r", "300", "-gray", "-png", "-l", strconv.Itoa(maxDocumentPages),
+		path, filepath.Join(dir, "page")).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pdftoppm: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// pdftoppm names the images page-N.png, zero-padding N to the width of
+	// the last page number
+	images, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return "", err
+	}
+	type pageImage struct {
+		page int
+		path string
+	}
+	pages := make([]pageImage, 0, len(images))
+	for _, img := range images {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(img), "page-"), ".png"))
+		if err == nil {
+			pages = append(pages, pageImage{n, img})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].page < pages[j].page })
+
+	languages := os.Getenv("OCR_LANGUAGES")
+	if languages == "" {
+		languages = "eng"
+	}
+	var b strings.Builder
+	for _, p := range pages {
+		out, err := exec.CommandContext(ctx, "tesseract", p.path, "stdout", "-l", languages).Output()
+		if err != nil {
+			return "", fmt.Errorf("tesseract page %d: %v", p.page, err)
+		}
+		fmt.Fprintf(&b, "--- Page %d ---\n%s\n\n", p.page, strings.TrimSpace(string(out)))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+const (
+	// New projects get a day to set up on their own before the first
+	// nudge, and are left alone once they are a month old
+	onboardingNudgeAfter = 24 * time.Hour
+	onboardingNudgeUntil = 30 * 24 * time.Hour
+)
+
+// projectOnboarding is the setup checklist of a project, in the order the
+// steps are usually done
+func projectOnboarding(ctx context.Context, project models.Project) ([]models.OnboardingStep, error) {
+	conversations, err := config.GetChatMessagesCollectionFor(project.ID).CountDocuments(ctx, bson.M{
+		"project_id": project.ID,
+		"dry_run":    bson.M{"$ne": true},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return nil, err
+	}
+
+	return []models.OnboardingStep{
+		{
+			Key:   "documents",
+			Title: "Upload your documents",
+			Hint:  "Upload the PDFs or attach the library documents the bot should answer from.",
+			Done:  len(project.PDFFiles) > 0 || len(project.LibraryDocs) > 0,
+		},
+		{
+			Key:   "widget_embedded",
+			Title: "Embed the widget",
+			Hint:  "Add the widget script to your site so visitors can start chatting.",
+			Done:  !project.WidgetEmbeddedAt.IsZero(),
+		},
+		{
+			Key:   "domain_verified",
+			Title: "Verify your domain",
+			Hint:  "Verify your site's domain with a meta tag or DNS record so only your sites can embed the widget.",
+			Done:  project.HasVerifiedDomain(),
+		},
+		{
+			Key:   "first_conversation",
+			Title: "Have a first conversation",
+			Hint:  "Open the widget on your site and ask the bot a question.",
+			Done:  conversations > 0,
+		},
+	}, nil
+}
+
+// GetOnboarding - GET /api/projects/:id/onboarding returns the project's
+// setup checklist and how much of it is done
+func GetOnboarding(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	ctx := context.Background()
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	steps, err := projectOnboarding(ctx, project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load onboarding status"})
+		return
+	}
+	completed := 0
+	for _, step := range steps {
+		if step.Done {
+			completed++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id": projectID,
+		"steps":      steps,
+		"completed":  completed,
+		"total":      len(steps),
+		"percent":    completed * 100 / len(steps),
+		"complete":   completed == len(steps),
+	})
+}
+
+// embeddedProjects remembers which projects are known to be embedded, so
+// widget page loads don't each write to the project
+var embeddedProjects sync.Map
+
+// markWidgetEmbedded records the first load of a project's widget from a
+// page on another site. Loads from this server, such as previews from the
+// dashboard, don't count.
+func markWidgetEmbedded(c *gin.Context, projectID primitive.ObjectID) {
+	if _, ok := embeddedProjects.Load(projectID); ok {
+		return
+	}
+	referer, err := url.Parse(c.GetHeader("Referer"))
+	if err != nil || referer.Host == "" || strings.EqualFold(referer.Host, c.Request.Host) {
+		return
+	}
+	if appURL, err := url.Parse(os.Getenv("APP_URL")); err == nil && strings.EqualFold(referer.Host, appURL.Host) {
+		return
+	}
+
+	_, err = config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{
+		"_id":                projectID,
+		"widget_embedded_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{
+		"widget_embedded_at": time.Now(),
+		"widget_embedded_on": strings.ToLower(referer.Host),
+	}})
+	if err != nil {
+		log.Printf("⚠️ Failed to record widget embed for project %s: %v", projectID.Hex(), err)
+		return
+	}
+	embeddedProjects.Store(projectID, true)
+}
+
+// SendOnboardingNudges reminds owners of new projects of the first setup
+// step they haven't done. A project gets at most one nudge a week, and
+// none once its checklist is complete.
+func SendOnboardingNudges() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := config.GetProjectsCollection().Find(ctx, bson.M{
+		"is_active":  true,
+		"created_at": bson.M{"$gt": now.Add(-onboardingNudgeUntil), "$lt": now.Add(-onboardingNudgeAfter)},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var project models.Project
+		if err := cursor.Decode(&project); err != nil {
+			continue
+		}
+		if recentlyAlerted(ctx, project.ID, "onboarding", now) {
+			continue
+		}
+		steps, err := projectOnboarding(ctx, project)
+		if err != nil {
+			log.Printf("⚠️ Onboarding check failed for project %s: %v", project.ID.Hex(), err)
+			continue
+		}
+
+		for _, step := range steps {
+			if step.Done {
+				continue
+			}
+			CreateNotification(
+				project.ID,
+				primitive.NilObjectID,
+				models.NotificationTypeInfo,
+				fmt.Sprintf("Next Step: %s - %s", step.Title, project.Name),
+				onboardingNudge(step),
+				map[string]interface{}{
+					"alert_key":      "onboarding",
+					"step":           step.Key,
+					"auto_generated": true,
+				},
+			)
+			break
+		}
+	}
+	return cursor.Err()
+}
+
+// onboardingNudge is the message reminding an owner of a step
+func onboardingNudge(step models.OnboardingStep) string {
+	switch step.Key {
+	case "documents":
+		return "Your bot has no documents to answer from yet. " + step.Hint
+	case "widget_embedded":
+		return "You haven't embedded the widget yet. " + step.Hint
+	case "domain_verified":
+		return "Any site can embed your widget until you verify your domain. " + step.Hint
+	case "first_conversation":
+		return "Your bot hasn't had a conversation yet. " + step.Hint
+	}
+	return step.Hint
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	defaultOrderLookupTimeout = 3 * time.Second
+	maxOrderLookupTimeout     = 10 * time.Second
+
+	// Order numbers longer than this are refused whatever the pattern says
+	maxOrderNumberLength = 40
+	maxOrderAnswerLength = 1000
+
+	defaultOrderPattern    = `#?([A-Za-z0-9]{0,4}-?\d{4,12})`
+	defaultOrderTemplate   = `Order {{.order_number}} is {{.status}}.{{if .tracking_url}} You can track it here: {{.tracking_url}}{{end}}`
+	defaultOrderNotFound   = "I couldn't find an order with that number. Please check it and try again."
+	orderLookupUnavailable = "I can't look up orders right now. Please try again in a few minutes."
+)
+
+var defaultOrderTriggerWords = []string{"order", "tracking", "shipment", "delivery", "package", "parcel"}
+
+// Order numbers are sent to the customer's API, so only this character set
+// gets through whatever the configured pattern matches
+var orderNumberCharset = regexp.MustCompile(`^[A-Za-z0-9#_-]+$`)
+
+// orderQuery is an order lookup found in a visitor's message
+type orderQuery struct {
+	OrderNumber string
+	Email       string // the signed-in chat user's, never taken from the message
+}
+
+// findOrderQuery reports whether a message asks about an order and extracts
+// the order number. The pattern's first capture group is the number if it
+// has one, otherwise the whole match.
+func findOrderQuery(lookup *models.OrderLookup, message string, user models.ChatUser) (orderQuery, bool) {
+	words := lookup.TriggerWords
+	if len(words) == 0 {
+		words = defaultOrderTriggerWords
+	}
+	lower := strings.ToLower(message)
+	triggered := false
+	for _, w := range words {
+		if strings.Contains(lower, strings.ToLower(w)) {
+			triggered = true
+			break
+		}
+	}
+	if !triggered {
+		return orderQuery{}, false
+	}
+
+	re, err := regexp.Compile(lookup.OrderPattern)
+	if err != nil {
+		return orderQuery{}, false
+	}
+	match := re.FindStringSubmatch(message)
+	if match == nil {
+		return orderQuery{}, false
+	}
+	number := match[0]
+	if len(match) > 1 && match[1] != "" {
+		number = match[1]
+	}
+	number = strings.TrimPrefix(strings.TrimSpace(number), "#")
+	if number == "" || len(number) > maxOrderNumberLength || !orderNumberCharset.MatchString(number) {
+		return orderQuery{}, false
+	}
+	return orderQuery{OrderNumber: number, Email: user.Email}, true
+}
+
+// answerOrderQuery looks an order up and renders the project's template
+// over the result. The boolean is false when the message is not an order
+// question and should be answered normally.
+func answerOrderQuery(project models.Project, message, sessionID string, user models.ChatUser) (string, bool) {
+	lookup := project.OrderLookup
+	if lookup == nil {
+		return "", false
+	}
+	query, ok := findOrderQuery(lookup, message, user)
+	if !ok {
+		return "", false
+	}
+
+	order, found, err := callOrderLookup(project.ID, lookup, query, sessionID)
+	if err != nil {
+		// The order number stays out of the log
+		log.Printf("⚠️ Order lookup failed for project %s: %v", project.ID.Hex(), err)
+		return orderLookupUnavailable, true
+	}
+	if !found {
+		if lookup.NotFoundMessage != "" {
+			return lookup.NotFoundMessage, true
+		}
+		return defaultOrderNotFound, true
+	}
+
+	order["order_number"] = query.OrderNumber
+	answer, err := renderOrderAnswer(lookup.AnswerTemplate, order)
+	if err != nil {
+		log.Printf("⚠️ Order answer template failed for project %s: %v", project.ID.Hex(), err)
+		return orderLookupUnavailable, true
+	}
+	return answer, true
+}
+
+// callOrderLookup asks the customer's endpoint about one order. A 404 means
+// the order does not exist.
+func callOrderLookup(projectID primitive.ObjectID, lookup *models.OrderLookup, query orderQuery, sessionID string) (map[string]interface{}, bool, error) {
+	payload := map[string]interface{}{
+		"project_id":   projectID.Hex(),
+		"session_id":   sessionID,
+		"order_number": query.OrderNumber,
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+	}
+	if query.Email != "" {
+		payload["email"] = query.Email
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false, err
+	}
+
+	timeout := time.Duration(lookup.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultOrderLookupTimeout
+	}
+	if timeout > maxOrderLookupTimeout {
+		timeout = maxOrderLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lookup.EndpointURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, projectID, lookup.Secret, body)
+
+	resp, err := integrationClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	var order map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&order); err != nil {
+		return nil, false, fmt.Errorf("invalid endpoint response: %v", err)
+	}
+	return order, true, nil
+}
+
+func parseOrderTemplate(text string) (*template.Template, error) {
+	return template.New("order").Option("missingkey=zero").Parse(text)
+}
+
+// renderOrderAnswer fills the answer template with the endpoint's fields.
+// Missing fields render empty rather than failing.
+func renderOrderAnswer(text string, order map[string]interface{}) (string, error) {
+	tmpl, err := parseOrderTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, order); err != nil {
+		return "", err
+	}
+	answer := strings.TrimSpace(strings.ReplaceAll(b.String(), "<no value>", ""))
+	if r := []rune(answer); len(r) > maxOrderAnswerLength {
+		answer = string(r[:maxOrderAnswerLength])
+	}
+	if answer == "" {
+		return "", fmt.Errorf("template rendered nothing")
+	}
+	return answer, nil
+}
+
+// SetOrderLookup - PUT /admin/projects/:id/order-lookup configures the
+// project's order status endpoint. An empty endpoint_url turns lookups off.
+// The signing secret is returned once, when the endpoint is first set.
+func SetOrderLookup(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		EndpointURL     string   `json:"endpoint_url"`
+		OrderPattern    string   `json:"order_pattern" binding:"max=200"`
+		TriggerWords    []string `json:"trigger_words" binding:"max=20"`
+		AnswerTemplate  string   `json:"answer_template" binding:"max=2000"`
+		NotFoundMessage string   `json:"not_found_message" binding:"max=500"`
+		TimeoutMs       int      `json:"timeout_ms" binding:"omitempty,min=100,max=10000"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	collection := config.GetProjectsCollection()
+	var project models.Project
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	if input.EndpointURL == "" {
+		_, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+			"$unset": bson.M{"order_lookup": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order lookup settings"})
+			return
+		}
+		recordAudit(c, models.AuditActionOrderLookup, "project", projectID, objID, map[string]interface{}{
+			"endpoint_url": "",
+		})
+		c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "order_lookup": nil})
+		return
+	}
+
+	u, err := url.Parse(input.EndpointURL)
+	if err != nil || u.Host == "" || u.Scheme != "https" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endpoint_url must be an absolute https URL"})
+		return
+	}
+	if input.OrderPattern == "" {
+		input.OrderPattern = defaultOrderPattern
+	}
+	if re, err := regexp.Compile(input.OrderPattern); err != nil || re.NumSubexp() > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order_pattern must be a valid regular expression with at most one capture group"})
+		return
+	}
+	if input.AnswerTemplate == "" {
+		input.AnswerTemplate = defaultOrderTemplate
+	}
+	if _, err := renderOrderAnswer(input.AnswerTemplate, map[string]interface{}{"order_number": "1001", "status": "shipped"}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid answer_template", "details": err.Error()})
+		return
+	}
+	var triggers []string
+	for _, w := range input.TriggerWords {
+		if w = strings.TrimSpace(w); w != "" {
+			triggers = append(triggers, w)
+		}
+	}
+
+	lookup := models.OrderLookup{
+		EndpointURL:     input.EndpointURL,
+		OrderPattern:    input.OrderPattern,
+		TriggerWords:    triggers,
+		AnswerTemplate:  input.AnswerTemplate,
+		NotFoundMessage: input.NotFoundMessage,
+		TimeoutMs:       input.TimeoutMs,
+		UpdatedAt:       time.Now(),
+	}
+	response := gin.H{"success": true, "project_id": projectID}
+	if project.OrderLookup != nil && project.OrderLookup.Secret != "" {
+		lookup.Secret = project.OrderLookup.Secret
+	} else {
+		lookup.Secret = repository.NewOrderLookupSecret()
+		response["webhook_secret"] = lookup.Secret
+		response["message"] = "Store the webhook secret now; it will not be shown again"
+	}
+	response["order_lookup"] = lookup
+
+	_, err = collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"order_lookup": lookup, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order lookup settings"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionOrderLookup, "project", projectID, objID, map[string]interface{}{
+		"endpoint_url": input.EndpointURL,
+	})
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// overageInfo describes the project's overage allowance for limit responses
+func overageInfo(project *models.Project) gin.H {
+	if !project.OverageEnabled {
+		return gin.H{"enabled": false}
+	}
+	return gin.H{
+		"enabled":   true,
+		"used":      project.GeminiOverageMonth,
+		"cap":       project.OverageCap,
+		"remaining": project.OverageRemaining(),
+		"rate":      project.OverageRate,
+		"cost":      float64(project.GeminiOverageMonth) * project.OverageRate,
+	}
+}
+
+// notifyOverage tells the project owner when a request first goes past the
+// monthly limit, and again when the overage cap is used up. overage is the
+// count returned by repository.ConsumeGeminiQuota.
+func notifyOverage(project models.Project, overage int) {
+	var title, message string
+	switch overage {
+	case 1:
+		title = fmt.Sprintf("Monthly limit reached - %s", project.Name)
+		message = fmt.Sprintf("The monthly limit of %d requests is used up. Further requests are billed as overage at $%.4f each, up to %d more this period.",
+			project.GeminiMonthlyLimit, project.OverageRate, project.OverageCap)
+	case project.OverageCap:
+		title = fmt.Sprintf("Overage cap reached - %s", project.Name)
+		message = fmt.Sprintf("All %d overage requests for this period are used. Chat replies are paused until the next reset.", project.OverageCap)
+	default:
+		return
+	}
+
+	err := CreateNotification(project.ID, primitive.NilObjectID, models.NotificationTypeWarning, title, message, map[string]interface{}{
+		"limit_type":     "overage",
+		"overage_used":   overage,
+		"overage_cap":    project.OverageCap,
+		"overage_rate":   project.OverageRate,
+		"monthly_limit":  project.GeminiMonthlyLimit,
+		"project_name":   project.Name,
+		"auto_generated": true,
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to create overage notification for project %s: %v", project.ID.Hex(), err)
+	}
+}
+
+// SetOverage - PUT /admin/projects/:id/gemini/overage turns pay-as-you-go
+// overage on or off and sets its cap (requests per period) and rate (USD
+// per request)
+func SetOverage(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Enabled *bool   `json:"enabled" binding:"required"`
+		Cap     int     `json:"cap" binding:"min=0"`
+		Rate    float64 `json:"rate" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if *input.Enabled && input.Cap == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cap must be greater than 0 when overage is enabled"})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOneAndUpdate(context.Background(),
+		bson.M{"_id": objID},
+		bson.M{"$set": bson.M{
+			"overage_enabled": *input.Enabled,
+			"overage_cap":     input.Cap,
+			"overage_rate":    input.Rate,
+			"updated_at":      time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update overage settings"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionOverageUpdate, "project", projectID, objID, map[string]interface{}{
+		"enabled": *input.Enabled,
+		"cap":     input.Cap,
+		"rate":    input.Rate,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"project_id": projectID,
+		"overage":    overageInfo(&project),
+	})
+}
+
+// GetOverageCharges - GET /admin/billing/overage lists overage charges for
+// billing, optionally for one ?project_id and only ?unbilled=true ones
+func GetOverageCharges(c *gin.Context) {
+	var projectID primitive.ObjectID
+	if id := c.Query("project_id"); id != "" {
+		var err error
+		if projectID, err = primitive.ObjectIDFromHex(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+	}
+
+	charges, err := repository.ListOverageCharges(context.Background(), projectID, c.Query("unbilled") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load overage charges"})
+		return
+	}
+
+	total := 0.0
+	for _, ch := range charges {
+		total += ch.Amount
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"charges": charges,
+		"count":   len(charges),
+		"total":   total,
+	})
+}
+
+// MarkOverageBilled - POST /admin/billing/overage/:id/billed records that
+// billing has invoiced a charge
+func MarkOverageBilled(c *gin.Context) {
+	chargeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid charge ID"})
+		return
+	}
+
+	err = repository.MarkOverageChargeBilled(context.Background(), chargeID)
+	if err == repository.ErrChargeNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Charge not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update charge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "charge_id": chargeID.Hex(), "billed": true})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword hashes a password for storage with bcrypt
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// verifyPassword checks password against a stored hash. Besides bcrypt it
+// accepts the salted MD5 hashes chat users were once stored with; rehash
+// is set for those and for bcrypt hashes below the current cost, so the
+// caller can upgrade the stored hash while it has the plain password.
+func verifyPassword(password, hash string) (ok, rehash bool) {
+	if strings.HasPrefix(hash, "$2") {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, err == nil && cost < bcrypt.DefaultCost
+	}
+
+	legacy := md5.Sum([]byte(password + "jevi_salt"))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(legacy[:])), []byte(hash)) == 1 {
+		return true, true
+	}
+	return false, false
+}
+
+// upgradePasswordHash replaces a legacy hash after a successful sign-in.
+// The update only applies if the stored hash is still the one that was
+// verified, so a concurrent password change wins. Failures are logged and
+// retried at the next sign-in.
+func upgradePasswordHash(collection *mongo.Collection, id primitive.ObjectID, oldHash, password string) {
+	hashed, err := hashPassword(password)
+	if err != nil {
+		log.Printf("⚠️ Failed to rehash password for %s %s: %v", collection.Name(), id.Hex(), err)
+		return
+	}
+	_, err = collection.UpdateOne(context.Background(),
+		bson.M{"_id": id, "password": oldHash},
+		bson.M{"$set": bson.M{"password": hashed}})
+	if err != nil {
+		log.Printf("⚠️ Failed to store rehashed password for %s %s: %v", collection.Name(), id.Hex(), err)
+	}
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"jevi-chat/config"
+)
+
+// Predeploy check results, from best to worst
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// ConfigCheck validates one part of the configuration. Checks that need
+// code outside this package, such as the listener settings, are passed in
+// by main.
+type ConfigCheck struct {
+	Name  string
+	Check func() error
+}
+
+// checkResult is one line of the predeploy report
+type checkResult struct {
+	Name       string      `json:"name"`
+	Status     string      `json:"status"`
+	Detail     string      `json:"detail,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+}
+
+// PredeployCheck - POST /admin/ops/predeploy-check validates configuration,
+// dry-runs migrations, checks indexes and pings every dependency. It
+// answers 200 when nothing failed and 503 otherwise, with "status" pass or
+// fail and one entry per check, so a pipeline can gate on the status code
+// alone. Warnings do not fail the check.
+func PredeployCheck(configChecks []ConfigCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Minute)
+		defer cancel()
+
+		checks := []struct {
+			name string
+			run  func(context.Context) (string, string, interface{})
+		}{
+			{"config", func(context.Context) (string, string, interface{}) { return checkConfig(configChecks) }},
+			{"migrations", checkMigrations},
+			{"indexes", checkIndexes},
+			{"mongodb", checkMongoDB},
+			{"storage", checkStorage},
+			{"gemini", checkGemini},
+			{"redis", checkRedis},
+			{"smtp", checkSMTP},
+		}
+
+		overall := checkPass
+		results := make([]checkResult, 0, len(checks))
+		for _, check := range checks {
+			start := time.Now()
+			status, detail, data := check.run(ctx)
+			results = append(results, checkResult{
+				Name:       check.name,
+				Status:     status,
+				Detail:     detail,
+				Data:       data,
+				DurationMS: time.Since(start).Milliseconds(),
+			})
+			if status == checkFail {
+				overall = checkFail
+			}
+		}
+
+		code := http.StatusOK
+		if overall == checkFail {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, gin.H{
+			"status":     overall,
+			"checks":     results,
+			"instance":   config.InstanceID,
+			"checked_at": time.Now(),
+		})
+	}
+}
+
+func checkConfig(extra []ConfigCheck) (string, string, interface{}) {
+	var problems, warnings []string
+	for _, key := range []string{"MONGODB_URI", "JWT_SECRET"} {
+		if os.Getenv(key) == "" {
+			problems = append(problems, key+" is not set")
+		}
+	}
+	for _, key := range []string{"GEMINI_API_KEY", "SECRETS_ENCRYPTION_KEY", "APP_URL"} {
+		if os.Getenv(key) == "" {
+			warnings = append(warnings, key+" is not set")
+		}
+	}
+	if _, err := config.LoadSIEMConfig(); err != nil {
+		problems = append(problems, "siem: "+err.Error())
+	}
+	if _, err := config.LoadEventBusConfig(); err != nil {
+		problems = append(problems, "event bus: "+err.Error())
+	}
+	if _, err := config.LoadWarehouseConfig(); err != nil {
+		problems = append(problems, "warehouse: "+err.Error())
+	}
+	for _, check := range extra {
+		if err := check.Check(); err != nil {
+			problems = append(problems, check.Name+": "+err.Error())
+		}
+	}
+
+	switch {
+	case len(problems) > 0:
+		return checkFail, strings.Join(append(problems, warnings...), "; "), nil
+	case len(warnings) > 0:
+		return checkWarn, strings.Join(warnings, "; "), nil
+	}
+	return checkPass, "", nil
+}
+
+// checkMigrations reports what `jevictl migrate` would change without
+// changing it. Pending work is expected before a release; an index that
+// exists with the wrong unique flag cannot be migrated and fails.
+func checkMigrations(ctx context.Context) (string, string, interface{}) {
+	if config.DB == nil {
+		return checkFail, "database not initialized", nil
+	}
+	reports, err := config.GetIndexReport(ctx)
+	if err != nil {
+		return checkFail, err.Error(), nil
+	}
+	legacy, err := config.CountLegacyProjectIDs(ctx)
+	if err != nil {
+		return checkFail, err.Error(), nil
+	}
+
+	create := map[string][]string{}
+	var conflicts []string
+	for _, r := range reports {
+		if len(r.Missing) > 0 {
+			create[r.Collection] = r.Missing
+		}
+		for _, idx := range r.Expected {
+			if idx.UniqueMismatch {
+				conflicts = append(conflicts, r.Collection+"."+idx.Name)
+			}
+		}
+	}
+	plan := gin.H{"create_indexes": create, "backfill_project_ids": legacy}
+
+	switch {
+	case len(conflicts) > 0:
+		return checkFail, "indexes with the wrong unique flag must be fixed by hand: " + strings.Join(conflicts, ", "), plan
+	case len(create) > 0 || len(legacy) > 0:
+		return checkWarn, "migrations pending", plan
+	}
+	return checkPass, "nothing to migrate", plan
+}
+
+func checkIndexes(ctx context.Context) (string, string, interface{}) {
+	if config.DB == nil {
+		return checkFail, "database not initialized", nil
+	}
+	reports, err := config.GetIndexReport(ctx)
+	if err != nil {
+		return checkFail, err.Error(), nil
+	}
+	missing := 0
+	for _, r := range reports {
+		missing += len(r.Missing)
+	}
+	if missing > 0 {
+		return checkFail, fmt.Sprintf("%d expected index(es) missing", missing), nil
+	}
+	return checkPass, "", nil
+}
+
+func checkMongoDB(context.Context) (string, string, interface{}) {
+	if err := config.HealthCheck(); err != nil {
+		return checkFail, err.Error(), nil
+	}
+	return checkPass, "", nil
+}
+
+// checkStorage writes, reads back and removes a small probe object
+func checkStorage(ctx context.Context) (string, string, interface{}) {
+	if config.Storage == nil {
+		return checkFail, "object storage not initialized", nil
+	}
+	key := "predeploy/" + config.InstanceID
+	probe := []byte(time.Now().Format(time.RFC3339Nano))
+
+	if err := config.Storage.Put(ctx, key, bytes.NewReader(probe), int64(len(probe)), "text/plain"); err != nil {
+		return checkFail, "write failed: " + err.Error(), nil
+	}
+	defer config.Storage.Delete(ctx, key)
+
+	rc, err := config.Storage.Get(ctx, key)
+	if err != nil {
+		return checkFail, "read failed: " + err.Error(), nil
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil || !bytes.Equal(got, probe) {
+		return checkFail, "read back different content", nil
+	}
+	return checkPass, config.Storage.Driver(), nil
+}
+
+func checkGemini(ctx context.Context) (string, string, interface{}) {
+	if config.DefaultGeminiKey == "" {
+		return checkWarn, "GEMINI_API_KEY not set; only projects with their own key can chat", nil
+	}
+	health := config.WarmUpGemini(ctx, config.DefaultGeminiKey)
+	if health.Status != config.GeminiStatusHealthy {
+		return checkFail, health.LastError, nil
+	}
+	return checkPass, "", nil
+}
+
+func checkRedis(ctx context.Context) (string, string, interface{}) {
+	if os.Getenv("REDIS_ADDR") == "" {
+		return checkPass, "not configured; rate limits are per replica", nil
+	}
+	if rateLimitStore == nil {
+		return checkFail, "configured but not connected; rate limits are per replica", nil
+	}
+	if err := rateLimitStore.Ping(ctx); err != nil {
+		return checkFail, err.Error(), nil
+	}
+	return checkPass, "", nil
+}
+
+func checkSMTP(ctx context.Context) (string, string, interface{}) {
+	settings := config.NotificationSettings
+	if settings == nil || settings.SMTPHost == "" {
+		return checkWarn, "SMTP not configured; email notifications are disabled", nil
+	}
+	addr := net.JoinHostPort(settings.SMTPHost, strconv.Itoa(settings.SMTPPort))
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return checkFail, err.Error(), nil
+	}
+	conn.Close()
+	return checkPass, "", nil
+}
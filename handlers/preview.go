@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// Extraction asks Gemini to open each page with "--- Page N ---"
+var pageMarkerPattern = regexp.MustCompile(`(?m)^[ \t]*-{2,}[ \t]*Page[ \t]+(\d+)[ \t]*-{2,}[ \t]*$`)
+
+const (
+	maxDocumentPages = 2000
+
+	// Chunks are built from whole paragraphs up to about this many characters
+	chunkTargetRunes = 800
+)
+
+var paragraphBreak = regexp.MustCompile(`\n[ \t]*\n`)
+
+type textSpan struct {
+	Offset, Length int // in runes
+}
+
+// chunkPage splits a page's text into passages of whole paragraphs. A
+// paragraph longer than the target becomes a chunk on its own.
+func chunkPage(text string) []textSpan {
+	var spans []textSpan
+	runeAt := func(byteOffset int) int { return utf8.RuneCountInString(text[:byteOffset]) }
+
+	start, end := -1, 0
+	flush := func() {
+		if start >= 0 {
+			spans = append(spans, textSpan{Offset: runeAt(start), Length: utf8.RuneCountInString(text[start:end])})
+			start = -1
+		}
+	}
+
+	pos := 0
+	breaks := append(paragraphBreak.FindAllStringIndex(text, -1), []int{len(text), len(text)})
+	for _, b := range breaks {
+		para := text[pos:b[0]]
+		trimmed := strings.TrimSpace(para)
+		if trimmed != "" {
+			pStart := pos + strings.Index(para, trimmed)
+			pEnd := pStart + len(trimmed)
+			if start >= 0 && utf8.RuneCountInString(text[start:pEnd]) > chunkTargetRunes {
+				flush()
+			}
+			if start < 0 {
+				start = pStart
+			}
+			end = pEnd
+		}
+		pos = b[1]
+	}
+	flush()
+	return spans
+}
+
+// splitPages splits extracted content on its page markers. Text before the
+// first marker belongs to page 1; content without markers is one page.
+func splitPages(content string) map[int]string {
+	pages := make(map[int]string)
+	matches := pageMarkerPattern.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		if text := strings.TrimSpace(content); text != "" {
+			pages[1] = text
+		}
+		return pages
+	}
+
+	preamble := strings.TrimSpace(content[:matches[0][0]])
+	for i, m := range matches {
+		page, err := strconv.Atoi(content[m[2]:m[3]])
+		if err != nil || page < 1 || page > maxDocumentPages {
+			continue
+		}
+		end := len(content)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		text := strings.TrimSpace(content[m[1]:end])
+		if existing, ok := pages[page]; ok {
+			text = existing + "\n\n" + text
+		}
+		pages[page] = text
+	}
+	if preamble != "" {
+		pages[1] = strings.TrimSpace(preamble + "\n\n" + pages[1])
+	}
+	return pages
+}
+
+// storeDocumentPages replaces the stored page text and chunks of one
+// uploaded file
+func storeDocumentPages(ctx context.Context, projectID primitive.ObjectID, fileID, content string) error {
+	filter := bson.M{"project_id": projectID, "file_id": fileID}
+	pagesCollection := config.GetDocumentPagesCollection()
+	chunksCollection := config.GetDocumentChunksCollection()
+	if _, err := pagesCollection.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	if _, err := chunksCollection.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	pages := splitPages(content)
+	if len(pages) == 0 {
+		return nil
+	}
+	now := time.Now()
+	pageDocs := make([]interface{}, 0, len(pages))
+	var chunkDocs []interface{}
+	for page, text := range pages {
+		pageDocs = append(pageDocs, models.DocumentPage{
+			ProjectID: projectID,
+			FileID:    fileID,
+			Page:      page,
+			Text:      text,
+			CreatedAt: now,
+		})
+		runes := []rune(text)
+		for _, span := range chunkPage(text) {
+			chunkDocs = append(chunkDocs, models.DocumentChunk{
+				ProjectID: projectID,
+				FileID:    fileID,
+				Page:      page,
+				Offset:    span.Offset,
+				Length:    span.Length,
+				Text:      string(runes[span.Offset : span.Offset+span.Length]),
+			})
+		}
+	}
+	if _, err := pagesCollection.InsertMany(ctx, pageDocs); err != nil {
+		return err
+	}
+	if len(chunkDocs) > 0 {
+		if _, err := chunksCollection.InsertMany(ctx, chunkDocs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PreviewPDFPage - GET /admin/projects/:id/pdf/:fileId/preview?page= returns
+// the text extracted from one page of an uploaded PDF, which is what the bot
+// answers from. Files uploaded before page text was kept are extracted again
+// on first preview. ?format=text returns the page as plain text. Citation
+// links add ?offset=&length= (in characters) to highlight the cited passage.
+func PreviewPDFPage(c *gin.Context) {
+	projectID := c.Param("id")
+	fileID := c.Param("fileId")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 || page > maxDocumentPages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive page number"})
+		return
+	}
+
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	var file *models.SourceFile
+	for i := range project.PDFFiles {
+		if project.PDFFiles[i].ID == fileID {
+			file = &project.PDFFiles[i]
+			break
+		}
+	}
+	if file == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	collection := config.GetDocumentPagesCollection()
+	filter := bson.M{"project_id": objID, "file_id": fileID}
+	total, err := collection.CountDocuments(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load page text"})
+		return
+	}
+	if total == 0 {
+		if err := backfillDocumentPages(project, file); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "No page text is available for this file", "details": err.Error()})
+			return
+		}
+	}
+
+	var doc models.DocumentPage
+	err = collection.FindOne(c.Request.Context(), bson.M{"project_id": objID, "file_id": fileID, "page": page}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found", "total_pages": lastPage(c.Request.Context(), filter)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load page text"})
+		return
+	}
+
+	var highlight gin.H
+	if c.Query("offset") != "" {
+		runes := []rune(doc.Text)
+		offset, err := strconv.Atoi(c.Query("offset"))
+		if err != nil || offset < 0 || offset > len(runes) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset is outside the page"})
+			return
+		}
+		length, err := strconv.Atoi(c.DefaultQuery("length", "0"))
+		if err != nil || length < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "length must not be negative"})
+			return
+		}
+		if offset+length > len(runes) {
+			length = len(runes) - offset
+		}
+		highlight = gin.H{"offset": offset, "length": length, "text": string(runes[offset : offset+length])}
+	}
+
+	if c.Query("format") == "text" {
+		c.Header("Cache-Control", "no-store")
+		c.String(http.StatusOK, doc.Text)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":  projectID,
+		"file_id":     fileID,
+		"file_name":   file.FileName,
+		"page":        page,
+		"total_pages": lastPage(c.Request.Context(), filter),
+		"text":        doc.Text,
+		"highlight":   highlight,
+		"extracted":   doc.CreatedAt,
+	})
+}
+
+// lastPage returns the highest page number stored for a file
+func lastPage(ctx context.Context, filter bson.M) int {
+	var doc models.DocumentPage
+	opts := options.FindOne().SetSort(bson.D{{Key: "page", Value: -1}}).SetProjection(bson.M{"page": 1})
+	if err := config.GetDocumentPagesCollection().FindOne(ctx, filter, opts).Decode(&doc); err != nil {
+		return 0
+	}
+	return doc.Page
+}
+
+// backfillDocumentPages extracts the page text of a file uploaded before
+// pages were stored. It needs the stored file and, for a PDF, the
+// project's Gemini key.
+func backfillDocumentPages(project models.Project, file *models.SourceFile) error {
+	if file.FileType() == models.SourceTypePDF && project.GeminiAPIKey == "" {
+		return errors.New("the project has no Gemini API key to extract the file with")
+	}
+	path, cleanup, err := localSourceCopy(context.Background(), file)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var content string
+	if file.FileType() == models.SourceTypePDF {
+		content, err = processPDFWithGemini(path, project.GeminiAPIKey)
+	} else {
+		content, err = extractDocumentText(path, file.FileType())
+	}
+	if err != nil {
+		return err
+	}
+	return storeDocumentPages(context.Background(), project.ID, file.ID, content)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// A project is warmed at most this often, however many widgets load
+const prewarmInterval = 5 * time.Minute
+
+var (
+	prewarmMu   sync.Mutex
+	prewarmedAt = map[primitive.ObjectID]time.Time{}
+)
+
+// claimPrewarm reports whether this call should warm the project, and
+// records it so concurrent widget loads do not all do the work
+func claimPrewarm(projectID primitive.ObjectID, now time.Time) bool {
+	prewarmMu.Lock()
+	defer prewarmMu.Unlock()
+	if last, ok := prewarmedAt[projectID]; ok && now.Sub(last) < prewarmInterval {
+		return false
+	}
+	// Drop stale entries so the map stays the size of the active projects
+	for id, last := range prewarmedAt {
+		if now.Sub(last) >= prewarmInterval {
+			delete(prewarmedAt, id)
+		}
+	}
+	prewarmedAt[projectID] = now
+	return true
+}
+
+// warmProject creates and verifies the project's Gemini client, and reads
+// the start of its chunk and library data so the first question does not
+// pay for cold indexes
+func warmProject(project models.Project) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if project.GeminiAPIKey != "" {
+		health := config.GeminiHealthFor(project.GeminiAPIKey)
+		if health.Status != config.GeminiStatusHealthy || time.Since(health.LastSuccessAt) > prewarmInterval {
+			if health = config.WarmUpGemini(ctx, project.GeminiAPIKey); health.Status != config.GeminiStatusHealthy {
+				log.Printf("⚠️ Gemini prewarm failed for project %s: %s", project.ID.Hex(), health.LastError)
+			}
+		}
+	}
+
+	cursor, err := config.GetDocumentChunksCollection().Find(ctx,
+		bson.M{"project_id": project.ID},
+		options.Find().SetLimit(50).SetProjection(bson.M{"_id": 1}))
+	if err == nil {
+		cursor.Close(ctx)
+	}
+	withLibraryContent(project)
+}
+
+// PrewarmWidget - POST /embed/:projectId/prewarm is called when the widget
+// loads. It returns the widget config and, in the background, gets the
+// project's Gemini client and data ready for the first question.
+func PrewarmWidget(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project)
+	if err != nil || !project.IsActive {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found or inactive"})
+		return
+	}
+
+	warming := claimPrewarm(objID, time.Now())
+	if warming {
+		go warmProject(project)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"config":  widgetConfig(c, project),
+		"warming": warming,
+	})
+}
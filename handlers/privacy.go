@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// PurgeChatUserData - DELETE /api/projects/:id/chat-users/:userId/data
+// erases a chat user with their messages (archived ones included),
+// sessions, usage logs, events and notifications, for a data subject's
+// erasure request. The audit record
+// keeps only the IDs and the counts removed.
+func PurgeChatUserData(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if config.IsProjectOnLegalHold(context.Background(), objID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Project is under legal hold and its data cannot be deleted"})
+		return
+	}
+
+	purged, err := repository.PurgeChatUser(context.Background(), objID, userID)
+	if err == repository.ErrChatUserNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat user not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to purge chat user %s of project %s: %v", userID.Hex(), projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat user data"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionChatUserPurge, "chat_user", userID.Hex(), objID, map[string]interface{}{
+		"messages":           purged.Messages,
+		"sessions":           purged.Sessions,
+		"usage_logs":         purged.UsageLogs,
+		"notifications":      purged.Notifications,
+		"message_redactions": purged.Redactions,
+		"chat_user_tokens":   purged.UserTokens,
+		"chat_events":        purged.Events,
+		"archived_messages":  purged.Archived,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"project_id": projectID,
+		"user_id":    userID.Hex(),
+		"deleted":    purged,
+	})
+}
+
+// ExportChatUserData - GET /api/projects/:id/chat-users/:userId/data
+// downloads everything stored about a chat user, for a data subject's
+// access request: profile, sessions, messages, ratings, usage logs and
+// notifications. ?format=zip packages each part as its own JSON file.
+func ExportChatUserData(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
+		return
+	}
+
+	export, err := repository.ExportChatUser(c.Request.Context(), objID, userID)
+	if err == repository.ErrChatUserNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat user not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to export chat user %s of project %s: %v", userID.Hex(), projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export chat user data"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionChatUserExport, "chat_user", userID.Hex(), objID, map[string]interface{}{
+		"format":   format,
+		"messages": len(export.Messages),
+	})
+
+	filename := fmt.Sprintf("chat-user-%s-%s", userID.Hex(), time.Now().UTC().Format("20060102T150405Z"))
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	if err := writeChatUserZip(c.Writer, export); err != nil {
+		// Headers are gone; all that's left is to cut the download short
+		log.Printf("❌ Failed to write chat user export %s: %v", userID.Hex(), err)
+	}
+}
+
+// writeChatUserZip writes the export as a zip of JSON files
+func writeChatUserZip(w http.ResponseWriter, export *repository.ChatUserExport) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"export.json", gin.H{
+			"exported_at": export.ExportedAt,
+			"project_id":  export.ProjectID,
+			"user_id":     export.Profile.ID,
+			"counts": gin.H{
+				"sessions":           len(export.Sessions),
+				"messages":           len(export.Messages),
+				"ratings":            len(export.Ratings),
+				"usage_logs":         len(export.UsageLogs),
+				"notifications":      len(export.Notifications),
+				"message_redactions": len(export.Redactions),
+			},
+		}},
+		{"profile.json", export.Profile},
+		{"sessions.json", export.Sessions},
+		{"messages.json", export.Messages},
+		{"ratings.json", export.Ratings},
+		{"usage_logs.json", export.UsageLogs},
+		{"notifications.json", export.Notifications},
+		{"message_redactions.json", export.Redactions},
+	}
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
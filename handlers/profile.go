@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const maxAvatarSize = 2 << 20 // 2MB
+
+var avatarTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// profileResponse is what the dashboard shows of the signed-in user
+func profileResponse(user *models.User) gin.H {
+	profile := gin.H{
+		"id":         user.ID.Hex(),
+		"username":   user.Username,
+		"email":      user.Email,
+		"role":       user.Role,
+		"timezone":   user.Location().String(),
+		"locale":     user.PreferredLocale(),
+		"created_at": user.CreatedAt,
+		"avatar_url": nil,
+
+		"email_notifications": user.EmailNotifications,
+	}
+	if user.EmailNotifications == nil {
+		profile["email_notifications"] = []string{}
+	}
+	if user.AvatarKey != "" {
+		profile["avatar_url"] = fmt.Sprintf("/api/users/%s/avatar?v=%d", user.ID.Hex(), user.UpdatedAt.Unix())
+	}
+	return profile
+}
+
+func currentUserID(c *gin.Context) (primitive.ObjectID, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return primitive.NilObjectID, false
+	}
+	return objID, true
+}
+
+// GetUserProfile - GET /api/user/profile and /user/profile
+func GetUserProfile(c *gin.Context) {
+	objID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := repository.GetUser(context.Background(), objID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":           true,
+		"user":              profileResponse(user),
+		"supported_locales": models.SupportedLocales,
+
+		"email_notification_types": models.EmailNotificationTypes,
+	})
+}
+
+// UpdateUserProfile - PUT /api/user/profile and /user/profile changes the
+// display name, timezone (IANA name, e.g. "Asia/Kolkata") and locale. The
+// timezone and locale decide how notices and reports are written for the user.
+// email_notifications lists the notification types to be emailed about.
+func UpdateUserProfile(c *gin.Context) {
+	objID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Username *string `json:"username"`
+		Timezone *string `json:"timezone"`
+		Locale   *string `json:"locale"`
+
+		// Notification types to receive by email
+		EmailNotifications *[]string `json:"email_notifications"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	if input.Username != nil {
+		name := strings.TrimSpace(*input.Username)
+		if len(name) < 2 || len(name) > 50 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be 2-50 characters"})
+			return
+		}
+		input.Username = &name
+	}
+	if input.Timezone != nil && *input.Timezone != "" {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone", "timezone": *input.Timezone})
+			return
+		}
+	}
+	if input.Locale != nil && *input.Locale != "" {
+		supported := false
+		for _, l := range models.SupportedLocales {
+			supported = supported || l == *input.Locale
+		}
+		if !supported {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported locale", "supported_locales": models.SupportedLocales})
+			return
+		}
+	}
+
+	if input.EmailNotifications != nil {
+		types := []string{}
+		for _, t := range *input.EmailNotifications {
+			if !containsString(models.EmailNotificationTypes, t) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification type", "email_notification_types": models.EmailNotificationTypes})
+				return
+			}
+			if !containsString(types, t) {
+				types = append(types, t)
+			}
+		}
+		input.EmailNotifications = &types
+	}
+
+	user, err := repository.UpdateUserProfile(context.Background(), objID, repository.ProfileUpdate{
+		Username:           input.Username,
+		Timezone:           input.Timezone,
+		Locale:             input.Locale,
+		EmailNotifications: input.EmailNotifications,
+	})
+	if err == repository.ErrUserNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionProfileUpdate, "user", objID.Hex(), primitive.NilObjectID, map[string]interface{}{
+		"timezone":            user.Timezone,
+		"locale":              user.Locale,
+		"email_notifications": user.EmailNotifications,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Profile updated",
+		"user":    profileResponse(user),
+	})
+}
+
+// UploadAvatar - POST /api/user/avatar stores the "avatar" image (PNG,
+// JPEG, GIF or WebP, up to 2MB) in object storage
+func UploadAvatar(c *gin.Context) {
+	objID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No avatar uploaded"})
+		return
+	}
+	if file.Size > maxAvatarSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar must be 2MB or smaller"})
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read avatar"})
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxAvatarSize+1))
+	if err != nil || len(data) > maxAvatarSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read avatar"})
+		return
+	}
+
+	// Trust the bytes, not the client's declared type
+	contentType := http.DetectContentType(data)
+	ext, ok := avatarTypes[contentType]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar must be a PNG, JPEG, GIF or WebP image"})
+		return
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("avatars/%s/%s.%s", objID.Hex(), randomHex(8), ext)
+	if err := config.Storage.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store avatar"})
+		return
+	}
+
+	previous, err := repository.SetUserAvatar(ctx, objID, key, contentType)
+	if err != nil {
+		config.Storage.Delete(ctx, key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+	deleteAvatarObject(ctx, previous)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"avatar_url": fmt.Sprintf("/api/users/%s/avatar?v=%d", objID.Hex(), time.Now().Unix()),
+	})
+}
+
+// DeleteAvatar - DELETE /api/user/avatar
+func DeleteAvatar(c *gin.Context) {
+	objID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	previous, err := repository.SetUserAvatar(ctx, objID, "", "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+	deleteAvatarObject(ctx, previous)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// GetUserAvatar - GET /api/users/:id/avatar streams a user's avatar. It
+// needs no sign-in so both dashboards can show it in an <img> tag.
+func GetUserAvatar(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ctx := context.Background()
+	user, err := repository.GetUser(ctx, objID)
+	if err != nil || user.AvatarKey == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+		return
+	}
+
+	rc, err := config.Storage.Get(ctx, user.AvatarKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Avatar not found"})
+		return
+	}
+	defer rc.Close()
+
+	// The URL changes with every upload, so the image itself never does
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.DataFromReader(http.StatusOK, -1, user.AvatarContentType, rc, nil)
+}
+
+func deleteAvatarObject(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	if err := config.Storage.Delete(ctx, key); err != nil {
+		log.Printf("⚠️ Failed to delete old avatar %s: %v", key, err)
+	}
+}
@@ -3,9 +3,8 @@ package handlers
 import (
     "context"
     "fmt"
+    "log"
     "net/http"
-    "os"
-    "path/filepath"
     "strings"
     "time"
     
@@ -13,7 +12,6 @@ import (
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
     "github.com/google/generative-ai-go/genai"
-    "google.golang.org/api/option"
     "jevi-chat/config"
     "jevi-chat/models"
 )
@@ -51,60 +49,95 @@ func UploadPDF(c *gin.Context) {
         return
     }
 
-    var uploadedFiles []models.PDFFile
+    var uploadedFiles []models.SourceFile
+    var embedFiles []string
     var allContent strings.Builder
 
-    // Create uploads directory if it doesn't exist
-    os.MkdirAll("./static/uploads", 0755)
+    // Copies of documents the project already has are skipped
+    var duplicates []gin.H
+    seen := map[string]*models.DocumentDuplicate{}
 
     for _, file := range files {
         // Validate file type and size
-        if !strings.HasSuffix(strings.ToLower(file.Filename), ".pdf") {
+        fileType := sourceFileType(file.Filename)
+        if fileType == "" {
             continue
         }
-        if file.Size > 10*1024*1024 { // 10MB limit
+        if file.Size > maxSourceBytes {
             continue
         }
 
-        // Generate unique filename
-        fileID := primitive.NewObjectID().Hex()
-        fileName := fmt.Sprintf("%s_%s", fileID, file.Filename)
-        filePath := fmt.Sprintf("./static/uploads/%s", fileName)
+        hash, err := uploadedFileHash(file)
+        if err != nil {
+            continue
+        }
+        dup, ok := seen[hash]
+        if !ok {
+            dup, err = resolveDuplicate(context.Background(), project, hash)
+            if err != nil {
+                log.Printf("⚠️ Duplicate check failed for %s: %v", file.Filename, err)
+            }
+        }
+        if dup != nil {
+            if dup.Linked {
+                recordAudit(c, models.AuditActionLibraryAttach, "project", projectID, objID, map[string]interface{}{
+                    "document_id": dup.ID,
+                    "enabled":     true,
+                    "reason":      "duplicate upload",
+                })
+                seen[hash] = &models.DocumentDuplicate{Source: dup.Source, ID: dup.ID, FileName: dup.FileName}
+            }
+            duplicates = append(duplicates, gin.H{"file_name": file.Filename, "message": duplicateMessage, "duplicate_of": dup})
+            continue
+        }
 
-        // Save file
-        if err := c.SaveUploadedFile(file, filePath); err != nil {
+        // Save file to object storage under a unique key
+        fileID := primitive.NewObjectID().Hex()
+        storageKey := sourceStorageKey(objID, fileID, file.Filename)
+        src, err := file.Open()
+        if err != nil {
+            continue
+        }
+        err = storeSourceFile(context.Background(), storageKey, src, file.Size)
+        src.Close()
+        if err != nil {
+            log.Printf("⚠️ Failed to store %s: %v", file.Filename, err)
             continue
         }
 
-        pdfFile := models.PDFFile{
+        pdfFile := models.SourceFile{
             ID:         fileID,
             FileName:   file.Filename,
-            FilePath:   filePath,
+            StorageKey: storageKey,
             FileSize:   file.Size,
+            Type:       fileType,
             UploadedAt: time.Now(),
             Status:     "processing",
+            ContentHash: hash,
         }
 
-        // Process with Gemini if enabled
-        var content string
-        if project.GeminiEnabled && project.GeminiAPIKey != "" {
-            content, err = processPDFWithGemini(filePath, project.GeminiAPIKey)
-            if err == nil {
-                pdfFile.ProcessedAt = time.Now()
-                pdfFile.Status = "completed"
-            } else {
-                pdfFile.Status = "failed"
-                content = "Failed to process PDF content"
-            }
-        } else {
-            content = "PDF uploaded successfully (Gemini processing disabled)"
-            pdfFile.Status = "completed"
+        content, paged := extractSourceFile(project, &pdfFile)
+        if paged {
+            embedFiles = append(embedFiles, fileID)
+        }
+        if pdfFile.Status == "completed" {
+            seen[hash] = &models.DocumentDuplicate{Source: "file", ID: fileID, FileName: file.Filename}
         }
 
         uploadedFiles = append(uploadedFiles, pdfFile)
         allContent.WriteString(content + "\n\n")
     }
 
+    if len(uploadedFiles) == 0 {
+        c.JSON(http.StatusOK, gin.H{
+            "message":        "No new documents to process",
+            "files_uploaded": 0,
+            "files":          uploadedFiles,
+            "duplicates":     duplicates,
+        })
+        return
+    }
+
     // Update project with PDF files and content
     update := bson.M{
         "$push": bson.M{"pdf_files": bson.M{"$each": uploadedFiles}},
@@ -120,24 +153,53 @@ func UploadPDF(c *gin.Context) {
         return
     }
 
+    // Embedding needs the files recorded on the project
+    for _, fileID := range embedFiles {
+        embedInBackground("file "+fileID, func(ctx context.Context) (int, error) {
+            return embedProjectFile(ctx, project, fileID)
+        })
+    }
+
     c.JSON(http.StatusOK, gin.H{
-        "message":        "PDFs uploaded and processed successfully",
+        "message":        "Documents uploaded and processed successfully",
         "files_uploaded": len(uploadedFiles),
         "files":          uploadedFiles,
+        "duplicates":     duplicates,
     })
 }
 
 // processPDFWithGemini - Enhanced PDF processing with Gemini AI
 func processPDFWithGemini(filePath, apiKey string) (string, error) {
-    ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+    return generateFromFile(filePath, apiKey, pdfExtractionPrompt, 60*time.Second)
+}
+
+const pdfExtractionPrompt = `Extract and organize all information from this document in a structured format. 
+        Include:
+        1. Main topics and sections with clear headings
+        2. Key points and important details
+        3. Any procedures, steps, or instructions
+        4. Important facts, figures, and data
+        5. Contact information if present
+        6. Definitions and terminology
+        7. Tables and lists if any
+        
+        Format the content clearly with headings and bullet points where appropriate. 
+        This will be used as a knowledge base for answering user questions.
+        Make sure to preserve the logical structure and hierarchy of information.
+        Keep the content in page order and start the content of each page with
+        a line of the form "--- Page N ---", where N is the page number.`
+
+// generateFromFile uploads a file to Gemini and returns the model's answer
+// to prompt about it
+func generateFromFile(filePath, apiKey, prompt string, timeout time.Duration) (string, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
     defer cancel()
     
     // Create client with project-specific API key
-    client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+    client, err := config.GeminiClientFor(ctx, apiKey)
     if err != nil {
-        return "", fmt.Errorf("failed to create Gemini client: %v", err)
+        return "", err
     }
-    defer client.Close()
     
     // Upload file to Gemini
     file, err := client.UploadFileFromPath(ctx, filePath, nil)
@@ -169,19 +231,7 @@ func processPDFWithGemini(filePath, apiKey string) (string, error) {
     model := client.GenerativeModel("gemini-1.5-flash")
     resp, err := model.GenerateContent(ctx, 
         genai.FileData{URI: file.URI, MIMEType: file.MIMEType},
-        genai.Text(`Extract and organize all information from this document in a structured format. 
-        Include:
-        1. Main topics and sections with clear headings
-        2. Key points and important details
-        3. Any procedures, steps, or instructions
-        4. Important facts, figures, and data
-        5. Contact information if present
-        6. Definitions and terminology
-        7. Tables and lists if any
-        
-        Format the content clearly with headings and bullet points where appropriate. 
-        This will be used as a knowledge base for answering user questions.
-        Make sure to preserve the logical structure and hierarchy of information.`),
+        genai.Text(prompt),
     )
     
     if err != nil {
@@ -216,18 +266,16 @@ func DeletePDF(c *gin.Context) {
         return
     }
     
-    // Find and delete physical file
-    var fileToDelete models.PDFFile
+    // Find and delete the stored file
     for _, file := range project.PDFFiles {
         if file.ID == fileID {
-            fileToDelete = file
+            removeSourceFile(context.Background(), file.StorageKey, file.FilePath)
             break
         }
     }
-    
-    if fileToDelete.FilePath != "" {
-        os.Remove(fileToDelete.FilePath)
-    }
+    config.GetDocumentPagesCollection().DeleteMany(context.Background(), bson.M{"project_id": objID, "file_id": fileID})
+    config.GetDocumentChunksCollection().DeleteMany(context.Background(), bson.M{"project_id": objID, "file_id": fileID})
+    config.GetChunkEmbeddingsCollection().DeleteMany(context.Background(), bson.M{"project_id": objID, "file_id": fileID})
     
     // Remove file from array
     update := bson.M{
@@ -294,7 +342,7 @@ func ProjectDashboard(c *gin.Context) {
     }
     
     // Get additional statistics
-    chatCollection := config.DB.Collection("chat_messages")
+    chatCollection := config.GetChatMessagesCollectionFor(objID)
     messageCount, _ := chatCollection.CountDocuments(context.Background(), bson.M{"project_id": objID})
     
     c.HTML(http.StatusOK, "project/dashboard.html", gin.H{
@@ -323,7 +371,7 @@ func GetProjectInfo(c *gin.Context) {
     }
 
     // Get additional stats
-    chatCollection := config.DB.Collection("chat_messages")
+    chatCollection := config.GetChatMessagesCollectionFor(objID)
     messageCount, _ := chatCollection.CountDocuments(context.Background(), bson.M{"project_id": objID})
     
     // Get unique sessions count
@@ -409,15 +457,7 @@ func getWelcomeMessage(message string) string {
 
 // validateFileType - Validate uploaded file type
 func validateFileType(filename string) bool {
-    allowedExtensions := []string{".pdf", ".doc", ".docx", ".txt"}
-    ext := strings.ToLower(filepath.Ext(filename))
-    
-    for _, allowed := range allowedExtensions {
-        if ext == allowed {
-            return true
-        }
-    }
-    return false
+    return sourceFileType(filename) != ""
 }
 
 // formatFileSize - Format file size for display
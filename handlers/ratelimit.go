@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis_rate/v10"
+	"jevi-chat/utils"
+)
+
+// rateLimitState is a key's position in its rate limit window
+type rateLimitState struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Time     // when the budget is fully restored
+	RetryAfter time.Duration // wait before the next request is allowed; 0 when allowed
+}
+
+// rateLimiter is implemented by the in-process RateLimiter and by
+// sharedRateLimiter. Only the shared one gives the same answer on every
+// replica, so deployments behind a round-robin load balancer should set
+// REDIS_ADDR.
+type rateLimiter interface {
+	// Take counts one request for key
+	Take(key string) rateLimitState
+	// Peek reports key's state without counting a request
+	Peek(key string) rateLimitState
+}
+
+// Take counts one request against the in-process window
+func (rl *RateLimiter) Take(key string) rateLimitState {
+	allowed := rl.Allow(key)
+	st := rl.Peek(key)
+	st.Allowed = allowed
+	if !allowed {
+		st.RetryAfter = time.Until(st.Reset)
+	}
+	return st
+}
+
+// Peek reports the in-process window for key. Windows are fixed, so the
+// budget comes back all at once at the end of the current one.
+func (rl *RateLimiter) Peek(key string) rateLimitState {
+	return rateLimitState{
+		Allowed:   true,
+		Limit:     rl.burst,
+		Remaining: rl.GetRemainingRequests(key),
+		Reset:     time.Now().Truncate(rl.rate).Add(rl.rate),
+	}
+}
+
+// sharedRateLimiter keeps counters in Redis. If Redis is unreachable it
+// falls back to this replica's own counters rather than letting every
+// request through.
+type sharedRateLimiter struct {
+	store    *utils.RedisRateLimiter
+	prefix   string
+	limit    redis_rate.Limit
+	fallback *RateLimiter
+}
+
+const redisLimitTimeout = 250 * time.Millisecond
+
+func (rl *sharedRateLimiter) Take(key string) rateLimitState {
+	st, err := rl.check(key, 1)
+	if err != nil {
+		log.Printf("⚠️ Shared rate limit unavailable, using local limiter: %v", err)
+		return rl.fallback.Take(key)
+	}
+	return st
+}
+
+func (rl *sharedRateLimiter) Peek(key string) rateLimitState {
+	st, err := rl.check(key, 0)
+	if err != nil {
+		return rl.fallback.Peek(key)
+	}
+	return st
+}
+
+func (rl *sharedRateLimiter) check(key string, n int) (rateLimitState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisLimitTimeout)
+	defer cancel()
+
+	res, err := rl.store.Check(ctx, rl.prefix+key, rl.limit, n)
+	if err != nil {
+		return rateLimitState{}, err
+	}
+	st := rateLimitState{
+		Allowed:   res.Allowed >= n,
+		Limit:     rl.limit.Burst,
+		Remaining: res.Remaining,
+		Reset:     time.Now().Add(res.ResetAfter),
+	}
+	if !st.Allowed && res.RetryAfter > 0 {
+		st.RetryAfter = res.RetryAfter
+	}
+	return st, nil
+}
+
+// setRateLimitHeaders reports st in the X-RateLimit-* headers, plus
+// Retry-After when the request was refused
+func setRateLimitHeaders(c *gin.Context, st rateLimitState) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(st.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(st.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(st.Reset.Unix(), 10))
+	if !st.Allowed {
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(st)))
+	}
+}
+
+// retryAfterSeconds rounds the wait up to whole seconds, at least one
+func retryAfterSeconds(st rateLimitState) int {
+	secs := int(math.Ceil(st.RetryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// respondRateLimited aborts with the standard 429 body and headers
+func respondRateLimited(c *gin.Context, st rateLimitState, limitType, message string) {
+	setRateLimitHeaders(c, st)
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Rate limit exceeded",
+		"code":        CodeRateLimited,
+		"limit_info":  rateLimitInfo(st, limitType),
+		"message":     message,
+		"retry_after": retryAfterSeconds(st),
+		"limit":       st.Limit,
+		"remaining":   st.Remaining,
+		"reset":       st.Reset.Unix(),
+		"limit_type":  limitType,
+	})
+}
+
+// limiterFor returns the limiter behind RateLimitMiddleware's limiterType
+func limiterFor(limiterType string) rateLimiter {
+	// Initialize rate limiters if not already done
+	if chatRateLimiter == nil {
+		InitRateLimiters()
+	}
+	switch limiterType {
+	case "chat":
+		return chatRateLimiter
+	case "auth":
+		return authRateLimiter
+	case "status":
+		return statusRateLimiter
+	default:
+		return generalRateLimiter
+	}
+}
+
+// GetRateLimitStatus - GET /api/rate-limit/status reports the caller's
+// remaining budget for every limiter without using any of it, so clients
+// can check before sending a burst
+func GetRateLimitStatus(c *gin.Context) {
+	clientIP := c.ClientIP()
+	limits := gin.H{}
+	for _, name := range []string{"chat", "auth", "general"} {
+		st := limiterFor(name).Peek(clientIP)
+		limits[name] = gin.H{
+			"limit":     st.Limit,
+			"remaining": st.Remaining,
+			"reset":     st.Reset.Unix(),
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"limits":  limits,
+	})
+}
+
+// newRateLimiter returns a Redis-backed limiter when store is set, otherwise
+// an in-process one
+func newRateLimiter(store *utils.RedisRateLimiter, name string, period time.Duration, burst int) rateLimiter {
+	local := NewRateLimiter(period, burst)
+	if store == nil {
+		return local
+	}
+	return &sharedRateLimiter{
+		store:    store,
+		prefix:   "ratelimit:" + name + ":",
+		limit:    redis_rate.Limit{Rate: burst, Burst: burst, Period: period},
+		fallback: local,
+	}
+}
+
+// rateLimitStore is the Redis store behind the shared limiters, nil when
+// limits are per replica
+var rateLimitStore *utils.RedisRateLimiter
+
+// sharedRateLimitStore connects to REDIS_ADDR (with REDIS_PASSWORD and
+// REDIS_DB). It returns nil when Redis is not configured or not reachable.
+func sharedRateLimitStore() *utils.RedisRateLimiter {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		log.Println("🚦 Rate limits are per replica (REDIS_ADDR not set)")
+		return nil
+	}
+	db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+
+	store := utils.NewRedisRateLimiter(addr, os.Getenv("REDIS_PASSWORD"), db)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := store.Ping(ctx); err != nil {
+		log.Printf("⚠️ Redis at %s unreachable, rate limits are per replica: %v", addr, err)
+		store.Close()
+		return nil
+	}
+
+	log.Printf("🚦 Rate limits shared through Redis at %s", addr)
+	return store
+}
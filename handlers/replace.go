@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// chunkChanges counts how a replaced document's passages changed
+type chunkChanges struct {
+	Kept    int `json:"kept"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// ReplacePDF - PUT /admin/projects/:id/pdf/:fileId replaces the file of an
+// uploaded document with a new version in the "file" form field. The file
+// keeps its ID. Passages whose text is unchanged keep their chunk, so
+// citations of them stay valid, and their embeddings are reused; only new
+// or edited passages are embedded again.
+func ReplacePDF(c *gin.Context) {
+	projectID := c.Param("id")
+	fileID := c.Param("fileId")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	index := -1
+	for i, f := range project.PDFFiles {
+		if f.ID == fileID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	previous := project.PDFFiles[index]
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A replacement file is required in the \"file\" field"})
+		return
+	}
+	name := filepath.Base(header.Filename)
+	fileType := sourceFileType(name)
+	if fileType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": unsupportedTypeMessage})
+		return
+	}
+	if header.Size > maxSourceBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file exceeds 10 MB"})
+		return
+	}
+
+	hash, err := uploadedFileHash(header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File could not be read"})
+		return
+	}
+	if hash == previous.ContentHash && previous.Status == "completed" {
+		c.JSON(http.StatusOK, gin.H{"message": "File is unchanged", "changed": false, "file": previous})
+		return
+	}
+
+	replacement := previous
+	replacement.FileName = name
+	replacement.FilePath = ""
+	replacement.StorageKey = sourceStorageKey(objID, primitive.NewObjectID().Hex(), name)
+	replacement.FileSize = header.Size
+	replacement.Type = fileType
+	replacement.ContentHash = hash
+	replacement.UploadedAt = time.Now()
+	replacement.EmbeddedAt = time.Time{}
+	replacement.Error = ""
+	replacement.TextChars, replacement.OCRStatus, replacement.OCREngine = 0, "", ""
+	src, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File could not be read"})
+		return
+	}
+	err = storeSourceFile(ctx, replacement.StorageKey, src, header.Size)
+	src.Close()
+	if err != nil {
+		log.Printf("⚠️ Failed to store replacement of file %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	content, err := readSourceFile(project, &replacement)
+	if err != nil {
+		removeSourceFile(ctx, replacement.StorageKey, "")
+		if errors.Is(err, errGeminiDisabled) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Gemini processing is disabled for this project, so the replacement cannot be extracted"})
+			return
+		}
+		log.Printf("⚠️ Failed to extract replacement of file %s: %v", fileID, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Replacement could not be processed: " + err.Error()})
+		return
+	}
+	replacement.Status = "completed"
+	replacement.ProcessedAt = time.Now()
+
+	changes, err := syncDocumentPages(ctx, objID, fileID, content)
+	if err != nil {
+		removeSourceFile(ctx, replacement.StorageKey, "")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store the replacement's text"})
+		return
+	}
+
+	// The whole-content fallback is rebuilt from the stored pages. Files
+	// uploaded before pages were kept cannot be rebuilt, and then the new
+	// text is only appended.
+	project.PDFFiles[index] = replacement
+	knowledge, rebuilt := projectKnowledgeContent(ctx, project)
+	if !rebuilt {
+		knowledge = project.PDFContent + content + "\n\n"
+	}
+	result, err := config.GetProjectsCollection().UpdateOne(ctx,
+		bson.M{"_id": objID, "pdf_files.id": fileID},
+		bson.M{"$set": bson.M{
+			"pdf_files.$": replacement,
+			"pdf_content": knowledge,
+			"updated_at":  time.Now(),
+		}})
+	if err != nil || result.MatchedCount == 0 {
+		removeSourceFile(ctx, replacement.StorageKey, "")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
+		return
+	}
+	removeSourceFile(ctx, previous.StorageKey, previous.FilePath)
+
+	embedInBackground("replaced file "+fileID, func(ctx context.Context) (int, error) {
+		n, embedded, err := reembedProjectFile(ctx, project, fileID)
+		if err == nil {
+			log.Printf("✅ Re-embedded file %s: %d of %d passages changed", fileID, embedded, n)
+		}
+		return n, err
+	})
+
+	recordAudit(c, models.AuditActionDocumentReplace, "file", fileID, objID, map[string]interface{}{
+		"file_name":          name,
+		"previous_file_name": previous.FileName,
+		"chunks_kept":        changes.Kept,
+		"chunks_added":       changes.Added,
+		"chunks_removed":     changes.Removed,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document replaced",
+		"changed": true,
+		"file":    replacement,
+		"chunks":  changes,
+	})
+}
+
+// syncDocumentPages stores the page text of a replaced file and brings its
+// chunks in line with it. A chunk whose text is still in the file is kept,
+// with its position updated, so it keeps its ID; the others are removed and
+// new passages added.
+func syncDocumentPages(ctx context.Context, projectID primitive.ObjectID, fileID, content string) (chunkChanges, error) {
+	var changes chunkChanges
+	filter := bson.M{"project_id": projectID, "file_id": fileID}
+	pagesCollection := config.GetDocumentPagesCollection()
+	chunksCollection := config.GetDocumentChunksCollection()
+
+	cursor, err := chunksCollection.Find(ctx, filter)
+	if err != nil {
+		return changes, err
+	}
+	var existing []models.DocumentChunk
+	if err := cursor.All(ctx, &existing); err != nil {
+		return changes, err
+	}
+	byText := make(map[string][]models.DocumentChunk, len(existing))
+	for _, chunk := range existing {
+		byText[chunk.Text] = append(byText[chunk.Text], chunk)
+	}
+
+	pages := splitPages(content)
+	numbers := make([]int, 0, len(pages))
+	for page := range pages {
+		numbers = append(numbers, page)
+	}
+	sort.Ints(numbers)
+
+	err = repository.WithTransaction(ctx, func(ctx context.Context) error {
+		changes = chunkChanges{}
+		if _, err := pagesCollection.DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		unused := make(map[string][]models.DocumentChunk, len(byText))
+		for text, chunks := range byText {
+			unused[text] = chunks
+		}
+		var pageDocs, added []interface{}
+		for _, page := range numbers {
+			text := pages[page]
+			pageDocs = append(pageDocs, models.DocumentPage{
+				ProjectID: projectID,
+				FileID:    fileID,
+				Page:      page,
+				Text:      text,
+				CreatedAt: now,
+			})
+			runes := []rune(text)
+			for _, span := range chunkPage(text) {
+				chunk := models.DocumentChunk{
+					ProjectID: projectID,
+					FileID:    fileID,
+					Page:      page,
+					Offset:    span.Offset,
+					Length:    span.Length,
+					Text:      string(runes[span.Offset : span.Offset+span.Length]),
+				}
+				if same := unused[chunk.Text]; len(same) > 0 {
+					kept := same[0]
+					unused[chunk.Text] = same[1:]
+					changes.Kept++
+					if kept.Page != chunk.Page || kept.Offset != chunk.Offset || kept.Length != chunk.Length {
+						if _, err := chunksCollection.UpdateOne(ctx, bson.M{"_id": kept.ID}, bson.M{"$set": bson.M{
+							"page":   chunk.Page,
+							"offset": chunk.Offset,
+							"length": chunk.Length,
+						}}); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				added = append(added, chunk)
+			}
+		}
+
+		var stale []primitive.ObjectID
+		for _, chunks := range unused {
+			for _, chunk := range chunks {
+				stale = append(stale, chunk.ID)
+			}
+		}
+		if len(stale) > 0 {
+			if _, err := chunksCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": stale}}); err != nil {
+				return err
+			}
+		}
+		if len(pageDocs) > 0 {
+			if _, err := pagesCollection.InsertMany(ctx, pageDocs); err != nil {
+				return err
+			}
+		}
+		if len(added) > 0 {
+			if _, err := chunksCollection.InsertMany(ctx, added); err != nil {
+				return err
+			}
+		}
+		changes.Added = len(added)
+		changes.Removed = len(stale)
+		return nil
+	})
+	return changes, err
+}
+
+// projectKnowledgeContent rebuilds a project's whole content from the
+// stored page text of its files, in upload order. It reports false when a
+// processed file has no stored pages to rebuild it from.
+func projectKnowledgeContent(ctx context.Context, project models.Project) (string, bool) {
+	var b strings.Builder
+	opts := options.Find().SetSort(bson.D{{Key: "page", Value: 1}})
+	for _, f := range project.PDFFiles {
+		if f.Status != "completed" {
+			continue
+		}
+		cursor, err := config.GetDocumentPagesCollection().Find(ctx, bson.M{"project_id": project.ID, "file_id": f.ID}, opts)
+		if err != nil {
+			return "", false
+		}
+		var pages []models.DocumentPage
+		if err := cursor.All(ctx, &pages); err != nil || len(pages) == 0 {
+			return "", false
+		}
+		for _, page := range pages {
+			if f.FileType() == models.SourceTypePDF {
+				fmt.Fprintf(&b, "--- Page %d ---\n", page.Page)
+			}
+			b.WriteString(page.Text)
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String(), true
+}
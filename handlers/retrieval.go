@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	// embedTimeout bounds embedding one document in the background
+	embedTimeout = 2 * time.Minute
+
+	// maxCachedIndexes caps the projects whose embeddings are kept in memory
+	maxCachedIndexes = 200
+)
+
+// retrievalTopK is how many passages a question is answered from
+// (RETRIEVAL_TOP_K, default 5)
+func retrievalTopK() int {
+	k, err := strconv.Atoi(os.Getenv("RETRIEVAL_TOP_K"))
+	if err != nil || k <= 0 {
+		return 5
+	}
+	return k
+}
+
+// embeddingKey is the API key a project's passages and questions are
+// embedded with
+func embeddingKey(project models.Project) string {
+	if project.GeminiAPIKey != "" {
+		return project.GeminiAPIKey
+	}
+	return config.DefaultGeminiKey
+}
+
+// embedProjectFile embeds the stored passages of one of the project's
+// files, replacing earlier embeddings. It returns the number of passages.
+func embedProjectFile(ctx context.Context, project models.Project, fileID string) (int, error) {
+	n, _, err := embedFileChunks(ctx, project, fileID, nil)
+	return n, err
+}
+
+// reembedProjectFile embeds the passages of a replaced file again, reusing
+// the vector of every passage whose text is unchanged, so only new or
+// edited passages are sent to the embedding API. It returns the number of
+// passages and how many of them were embedded.
+func reembedProjectFile(ctx context.Context, project models.Project, fileID string) (int, int, error) {
+	previous, err := repository.FileEmbeddings(ctx, project.ID, fileID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return embedFileChunks(ctx, project, fileID, previous)
+}
+
+// embedFileChunks replaces the embeddings of a file's stored passages.
+// Passages with the text of one of previous keep its vector.
+func embedFileChunks(ctx context.Context, project models.Project, fileID string, previous []models.ChunkEmbedding) (int, int, error) {
+	cursor, err := config.GetDocumentChunksCollection().Find(ctx,
+		bson.M{"project_id": project.ID, "file_id": fileID},
+		options.Find().SetSort(bson.D{{"page", 1}, {"offset", 1}}))
+	if err != nil {
+		return 0, 0, err
+	}
+	var chunks []models.DocumentChunk
+	if err := cursor.All(ctx, &chunks); err != nil {
+		return 0, 0, err
+	}
+
+	known := make(map[string][]float32, len(previous))
+	for _, row := range previous {
+		known[row.Text] = row.Vector
+	}
+	vectors := make([][]float32, len(chunks))
+	var texts []string
+	var missing []int
+	for i, chunk := range chunks {
+		if v, ok := known[chunk.Text]; ok {
+			vectors[i] = v
+			continue
+		}
+		texts = append(texts, chunk.Text)
+		missing = append(missing, i)
+	}
+	if len(texts) > 0 {
+		embedded, err := config.EmbedTexts(ctx, embeddingKey(project), texts, false)
+		if err != nil {
+			return 0, 0, err
+		}
+		for j, i := range missing {
+			vectors[i] = embedded[j]
+		}
+	}
+
+	model := config.EmbeddingModel()
+	now := time.Now()
+	rows := make([]models.ChunkEmbedding, len(chunks))
+	for i, chunk := range chunks {
+		rows[i] = models.ChunkEmbedding{
+			ProjectID: project.ID,
+			FileID:    fileID,
+			Page:      chunk.Page,
+			Offset:    chunk.Offset,
+			Length:    chunk.Length,
+			Text:      chunk.Text,
+			Model:     model,
+			Vector:    vectors[i],
+			CreatedAt: now,
+		}
+	}
+	return len(rows), len(texts), repository.ReplaceFileEmbeddings(ctx, project.ID, fileID, rows)
+}
+
+// embedLibraryDocument splits a library document into passages and embeds
+// them with the deployment key. It returns the number of passages.
+func embedLibraryDocument(ctx context.Context, doc models.LibraryDocument) (int, error) {
+	runes := []rune(doc.Content)
+	spans := chunkPage(doc.Content)
+	texts := make([]string, len(spans))
+	for i, span := range spans {
+		texts[i] = string(runes[span.Offset : span.Offset+span.Length])
+	}
+	vectors, err := config.EmbedTexts(ctx, config.DefaultGeminiKey, texts, false)
+	if err != nil {
+		return 0, err
+	}
+
+	model := config.EmbeddingModel()
+	now := time.Now()
+	rows := make([]models.ChunkEmbedding, len(spans))
+	for i, span := range spans {
+		rows[i] = models.ChunkEmbedding{
+			LibraryDocumentID: doc.ID,
+			Offset:            span.Offset,
+			Length:            span.Length,
+			Text:              texts[i],
+			Model:             model,
+			Vector:            vectors[i],
+			CreatedAt:         now,
+		}
+	}
+	return len(rows), repository.ReplaceLibraryEmbeddings(ctx, doc.ID, rows)
+}
+
+// embedInBackground runs an embedding job after an upload has been
+// answered. Failures are logged; the document is then answered from its
+// full content until it is embedded again.
+func embedInBackground(what string, embed func(context.Context) (int, error)) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+		defer cancel()
+		if _, err := embed(ctx); err != nil {
+			log.Printf("⚠️ Failed to embed %s: %v", what, err)
+		}
+	}()
+}
+
+// embeddingIndex is a project's passage embeddings as of key
+type embeddingIndex struct {
+	key  string
+	rows []models.ChunkEmbedding
+}
+
+var (
+	indexMu    sync.Mutex
+	indexCache = map[primitive.ObjectID]*embeddingIndex{}
+)
+
+// loadEmbeddingIndex returns the passages of the project's files and of the
+// given library documents. They are cached until the project or the set of
+// documents changes.
+func loadEmbeddingIndex(ctx context.Context, project models.Project, library []models.LibraryDocument) ([]models.ChunkEmbedding, error) {
+	libraryIDs := make([]primitive.ObjectID, len(library))
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d", project.UpdatedAt.UnixNano())
+	for i, doc := range library {
+		libraryIDs[i] = doc.ID
+		fmt.Fprintf(&key, ":%s@%d", doc.ID.Hex(), doc.EmbeddedAt.UnixNano())
+	}
+
+	indexMu.Lock()
+	cached, ok := indexCache[project.ID]
+	indexMu.Unlock()
+	if ok && cached.key == key.String() {
+		return cached.rows, nil
+	}
+
+	rows, err := repository.KnowledgeEmbeddings(ctx, project.ID, libraryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	indexMu.Lock()
+	if len(indexCache) >= maxCachedIndexes {
+		indexCache = map[primitive.ObjectID]*embeddingIndex{}
+	}
+	indexCache[project.ID] = &embeddingIndex{key: key.String(), rows: rows}
+	indexMu.Unlock()
+	return rows, nil
+}
+
+// knowledgeContext returns the knowledge a question is answered from. Once
+// all of a project's files are embedded, only the passages most similar to
+// the question are used, together with those of embedded library
+// documents. Content that is not embedded yet is included whole, as it was
+// before retrieval existed, and so is everything if retrieval fails.
+func knowledgeContext(project models.Project, question string) string {
+	docs, err := repository.AttachedLibraryDocuments(context.Background(), project)
+	if err != nil {
+		log.Printf("⚠️ Failed to load library documents for project %s: %v", project.ID.Hex(), err)
+		return project.PDFContent
+	}
+
+	filesEmbedded := false
+	for _, f := range project.PDFFiles {
+		if f.Status != "completed" {
+			continue
+		}
+		if f.EmbeddedAt.IsZero() {
+			filesEmbedded = false
+			break
+		}
+		filesEmbedded = true
+	}
+	var embedded, whole []models.LibraryDocument
+	for _, doc := range docs {
+		if doc.EmbeddedAt.IsZero() {
+			whole = append(whole, doc)
+		} else {
+			embedded = append(embedded, doc)
+		}
+	}
+
+	var passages []models.ChunkEmbedding
+	if filesEmbedded || len(embedded) > 0 {
+		passages, err = retrievePassages(project, embedded, filesEmbedded, question)
+		if err != nil {
+			log.Printf("⚠️ Retrieval failed for project %s, using all content: %v", project.ID.Hex(), err)
+			filesEmbedded = false
+			whole = docs
+			passages = nil
+		}
+	}
+
+	var b strings.Builder
+	if !filesEmbedded {
+		b.WriteString(project.PDFContent)
+	}
+	for _, doc := range whole {
+		b.WriteString("\n\n")
+		b.WriteString(doc.Content)
+	}
+	for _, p := range passages {
+		b.WriteString("\n\n")
+		b.WriteString(p.Text)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// retrievePassages embeds the question and returns the top-k most similar
+// passages, best first. The project's own passages are only searched when
+// all its files are embedded.
+func retrievePassages(project models.Project, library []models.LibraryDocument, ownFiles bool, question string) ([]models.ChunkEmbedding, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := loadEmbeddingIndex(ctx, project, library)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]models.ChunkEmbedding, 0, len(rows))
+	for _, row := range rows {
+		if ownFiles || row.ProjectID.IsZero() {
+			candidates = append(candidates, row)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := config.EmbedTexts(ctx, embeddingKey(project), []string{question}, true)
+	if err != nil {
+		return nil, err
+	}
+	return topPassages(vectors[0], candidates, retrievalTopK()), nil
+}
+
+// topPassages ranks passages by cosine similarity to the query vector
+func topPassages(query []float32, rows []models.ChunkEmbedding, k int) []models.ChunkEmbedding {
+	type scored struct {
+		row   models.ChunkEmbedding
+		score float64
+	}
+	ranked := make([]scored, 0, len(rows))
+	for _, row := range rows {
+		ranked = append(ranked, scored{row, cosineSimilarity(query, row.Vector)})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	if len(ranked) > k {
+		ranked = ranked[:k]
+	}
+	top := make([]models.ChunkEmbedding, len(ranked))
+	for i, r := range ranked {
+		top[i] = r.row
+	}
+	return top
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// ReindexEmbeddings - POST /admin/projects/:id/embeddings embeds every
+// processed PDF of the project again, and any attached library document
+// that is not embedded yet. Projects created before retrieval existed are
+// answered from all their content until this has run.
+func ReindexEmbeddings(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
+	defer cancel()
+
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	type result struct {
+		ID       string `json:"id"`
+		Source   string `json:"source"`
+		Passages int    `json:"passages"`
+		Error    string `json:"error,omitempty"`
+	}
+	var results []result
+	failed := 0
+	record := func(id, source string, n int, err error) {
+		r := result{ID: id, Source: source, Passages: n}
+		if err != nil {
+			r.Error = err.Error()
+			failed++
+		}
+		results = append(results, r)
+	}
+
+	for _, f := range project.PDFFiles {
+		if f.Status != "completed" {
+			continue
+		}
+		n, err := embedProjectFile(ctx, project, f.ID)
+		record(f.ID, "file", n, err)
+	}
+
+	docs, err := repository.AttachedLibraryDocuments(ctx, project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load library documents"})
+		return
+	}
+	for _, doc := range docs {
+		if !doc.EmbeddedAt.IsZero() {
+			continue
+		}
+		n, err := embedLibraryDocument(ctx, doc)
+		record(doc.ID.Hex(), "library", n, err)
+	}
+
+	recordAudit(c, models.AuditActionEmbeddingsIndex, "project", projectID, objID, map[string]interface{}{
+		"documents": len(results),
+		"failed":    failed,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    failed == 0,
+		"project_id": projectID,
+		"model":      config.EmbeddingModel(),
+		"documents":  results,
+	})
+}
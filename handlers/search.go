@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// searchResult is one hit for the admin search bar
+type searchResult struct {
+	Type      string             `json:"type"` // project, user, chat_user, session
+	ID        string             `json:"id"`
+	Title     string             `json:"title"`
+	Subtitle  string             `json:"subtitle,omitempty"`
+	ProjectID primitive.ObjectID `json:"project_id,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// searchSource describes how one collection is searched and displayed
+type searchSource struct {
+	kind string
+	// collections are searched together, for collections that are spread
+	// over tenant databases
+	collections []*mongo.Collection
+	fields      []string
+	idFields    []string
+	sort        string
+	toResult    func(raw bson.Raw) (searchResult, error)
+}
+
+// AdminSearch - GET /admin/search?q= looks up projects, dashboard users,
+// chat users and chat sessions by name, email or ID in one call. Each
+// collection is queried in parallel and returns at most ?limit hits
+// (default 10, at most 50).
+func AdminSearch(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if len(q) < 2 || len(q) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be between 2 and 100 characters"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sources := searchSources()
+	hits := make([][]searchResult, len(sources))
+	errs := make([]error, len(sources))
+
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src searchSource) {
+			defer wg.Done()
+			hits[i], errs[i] = src.search(ctx, q, int64(limit))
+		}(i, src)
+	}
+	wg.Wait()
+
+	results := []searchResult{}
+	counts := gin.H{}
+	var failed []string
+	for i, src := range sources {
+		if errs[i] != nil {
+			failed = append(failed, src.kind)
+			continue
+		}
+		counts[src.kind] = len(hits[i])
+		results = append(results, hits[i]...)
+	}
+	if len(failed) == len(sources) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+	sort.SliceStable(results, func(a, b int) bool {
+		return results[a].CreatedAt.After(results[b].CreatedAt)
+	})
+
+	response := gin.H{
+		"query":   q,
+		"results": results,
+		"counts":  counts,
+		"total":   len(results),
+	}
+	if len(failed) > 0 {
+		response["failed"] = failed
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (src searchSource) search(ctx context.Context, q string, limit int64) ([]searchResult, error) {
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+	or := bson.A{}
+	for _, f := range src.fields {
+		or = append(or, bson.M{f: pattern})
+	}
+	if id, err := primitive.ObjectIDFromHex(q); err == nil {
+		for _, f := range src.idFields {
+			or = append(or, bson.M{f: id})
+		}
+	}
+
+	opts := options.Find().SetLimit(limit).SetSort(bson.D{{Key: src.sort, Value: -1}})
+	var out []searchResult
+	for _, collection := range src.collections {
+		cursor, err := collection.Find(ctx, bson.M{"$or": or}, opts)
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next(ctx) {
+			// Rows that predate the current schema are skipped, not fatal
+			if r, err := src.toResult(cursor.Current); err == nil {
+				out = append(out, r)
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(src.collections) > 1 {
+		sort.SliceStable(out, func(a, b int) bool { return out[a].CreatedAt.After(out[b].CreatedAt) })
+		if int64(len(out)) > limit {
+			out = out[:limit]
+		}
+	}
+	return out, nil
+}
+
+func searchSources() []searchSource {
+	return []searchSource{
+		{
+			kind:        "project",
+			collections: []*mongo.Collection{config.GetProjectsCollection()},
+			fields:      []string{"name", "description"},
+			idFields:    []string{"_id"},
+			sort:        "created_at",
+			toResult: func(raw bson.Raw) (searchResult, error) {
+				var p models.Project
+				err := bson.Unmarshal(raw, &p)
+				return searchResult{Type: "project", ID: p.ID.Hex(), Title: p.Name, Subtitle: p.Category, ProjectID: p.ID, CreatedAt: p.CreatedAt}, err
+			},
+		},
+		{
+			kind:        "user",
+			collections: []*mongo.Collection{config.GetUsersCollection()},
+			fields:      []string{"username", "email"},
+			idFields:    []string{"_id"},
+			sort:        "created_at",
+			toResult: func(raw bson.Raw) (searchResult, error) {
+				var u models.User
+				err := bson.Unmarshal(raw, &u)
+				return searchResult{Type: "user", ID: u.ID.Hex(), Title: u.Username, Subtitle: u.Email + " · " + u.Role, CreatedAt: u.CreatedAt}, err
+			},
+		},
+		{
+			kind:        "chat_user",
+			collections: []*mongo.Collection{config.GetChatUsersCollection()},
+			fields:      []string{"name", "email"},
+			idFields:    []string{"_id"},
+			sort:        "created_at",
+			toResult: func(raw bson.Raw) (searchResult, error) {
+				var u models.ChatUser
+				err := bson.Unmarshal(raw, &u)
+				return searchResult{Type: "chat_user", ID: u.ID.Hex(), Title: u.Name, Subtitle: u.Email, ProjectID: u.ProjectID, CreatedAt: u.CreatedAt}, err
+			},
+		},
+		{
+			kind:        "session",
+			collections: config.AllTenantCollections("chat_sessions"),
+			fields:      []string{"session_id", "ip_address", "external_session_id"},
+			idFields:    []string{"_id", "user_id", "project_id"},
+			sort:        "start_time",
+			toResult: func(raw bson.Raw) (searchResult, error) {
+				var s models.ChatSession
+				err := bson.Unmarshal(raw, &s)
+				subtitle := s.IPAddress
+				if s.ExternalSessionID != "" {
+					subtitle = "Imported as " + s.ExternalSessionID
+				}
+				return searchResult{Type: "session", ID: s.SessionID, Title: s.SessionID, Subtitle: subtitle, ProjectID: s.ProjectID, CreatedAt: s.StartTime}, err
+			},
+		},
+	}
+}
@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"jevi-chat/config"
+	"jevi-chat/middleware"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const deviceCookie = "device_id"
+
+// deviceFingerprint identifies the browser a request comes from. A random
+// device cookie is issued on first sight, so clearing cookies counts as a
+// new device.
+func deviceFingerprint(c *gin.Context) string {
+	deviceID, err := c.Cookie(deviceCookie)
+	if err != nil || len(deviceID) != 32 {
+		deviceID = randomHex(16)
+		c.SetCookie(deviceCookie, deviceID, 3600*24*365, "/", "", false, true)
+	}
+
+	sum := sha256.Sum256([]byte(deviceID + "|" + c.Request.UserAgent() + "|" + c.GetHeader("Accept-Language")))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordUserSession stores the device on the user's session list and tells
+// the user when an account they already use is signed into from a new one
+func recordUserSession(c *gin.Context, user models.User) {
+	ctx := context.Background()
+	collection := config.GetUserSessionsCollection()
+	fingerprint := deviceFingerprint(c)
+	now := time.Now()
+
+	known, err := collection.CountDocuments(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		log.Printf("⚠️ Failed to load sessions for %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"user_id": user.ID, "fingerprint": fingerprint},
+		bson.M{
+			"$set": bson.M{
+				"user_agent":   c.Request.UserAgent(),
+				"ip_address":   c.ClientIP(),
+				"last_seen_at": now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("⚠️ Failed to record session for %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	// The first device after registration is expected, not suspicious
+	if result.UpsertedCount > 0 && known > 0 {
+		notifySecurityEvent(user, "new_device_login",
+			map[string]interface{}{
+				"ip_address": c.ClientIP(),
+				"user_agent": c.Request.UserAgent(),
+			},
+			c.Request.UserAgent(), c.ClientIP(), userTime(user, now))
+	}
+}
+
+// notifySecurityEvent raises an in-app notification and, when SMTP is
+// configured, emails the user. The text comes from securityNotices in the
+// user's language, formatted with args.
+func notifySecurityEvent(user models.User, event string, metadata map[string]interface{}, args ...interface{}) {
+	notifyProjectSecurityEvent(primitive.NilObjectID, user, event, metadata, args...)
+}
+
+// notifyProjectSecurityEvent is notifySecurityEvent for an event in one of
+// the user's projects
+func notifyProjectSecurityEvent(projectID primitive.ObjectID, user models.User, event string, metadata map[string]interface{}, args ...interface{}) {
+	title, message := localizedNotice(user, event, args...)
+	metadata["event"] = event
+	CreateNotification(projectID, user.ID, models.NotificationTypeSecurity, title, message, metadata)
+
+	if config.EmailEnabled() && user.Email != "" {
+		go func() {
+			if err := config.SendEmail(user.Email, title, message); err != nil {
+				log.Printf("⚠️ Failed to email security notice to %s: %v", user.Email, err)
+			}
+		}()
+	}
+}
+
+// ChangePassword - PUT /user/password for the signed-in dashboard user
+func ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required,min=8"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	collection := config.GetUsersCollection()
+	var user models.User
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if ok, _ := verifyPassword(req.CurrentPassword, user.Password); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	_, err = collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set":   bson.M{"password": string(hashed), "updated_at": time.Now()},
+		"$unset": bson.M{"must_change_password": ""},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// Replace a session that was limited to this password change
+	if user.MustChangePassword {
+		c.SetCookie("token", generateJWT(user.ID.Hex(), user.Role, false), 3600*24, "/", "", false, true)
+	}
+
+	recordAudit(c, models.AuditActionPasswordChange, "user", user.ID.Hex(), primitive.NilObjectID, nil)
+	notifySecurityEvent(user, "password_change",
+		map[string]interface{}{
+			"ip_address": c.ClientIP(),
+		},
+		userTime(user, time.Now()), c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Password updated successfully",
+	})
+}
+
+// GetUserSessions - GET /user/sessions lists devices the user signed in from
+func GetUserSessions(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}})
+	cursor, err := config.GetUserSessionsCollection().Find(context.Background(), bson.M{"user_id": objID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var sessions []models.UserSession
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse sessions"})
+		return
+	}
+	if sessions == nil {
+		sessions = []models.UserSession{}
+	}
+
+	current := deviceFingerprint(c)
+	result := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, gin.H{
+			"id":           s.ID,
+			"user_agent":   s.UserAgent,
+			"ip_address":   s.IPAddress,
+			"created_at":   s.CreatedAt,
+			"last_seen_at": s.LastSeenAt,
+			"current":      s.Fingerprint == current,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"sessions": result,
+	})
+}
+
+// randomHex returns n random bytes hex-encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetRequestSigning - PUT /admin/projects/:id/signing turns HMAC signing of
+// widget messages on or off. Enabling (or rotate=true) issues a new key pair;
+// the secret is returned only in this response.
+func SetRequestSigning(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+		Rotate  bool  `json:"rotate"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	collection := config.GetProjectsCollection()
+	var project models.Project
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	set := bson.M{
+		"signing_required": *input.Enabled,
+		"updated_at":       time.Now(),
+	}
+	response := gin.H{
+		"success":          true,
+		"project_id":       projectID,
+		"signing_required": *input.Enabled,
+	}
+
+	issue := *input.Enabled && (input.Rotate || project.SigningSecret == "")
+	if issue {
+		token, secret := repository.NewSigningKeyPair()
+		set["signing_public_token"] = token
+		set["signing_secret"] = secret
+		response["signing_public_token"] = token
+		response["signing_secret"] = secret
+		response["message"] = "Store the signing secret now; it will not be shown again"
+	} else {
+		response["signing_public_token"] = project.SigningPublicToken
+	}
+
+	if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": set}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update signing settings"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionSigningUpdate, "project", projectID, objID, map[string]interface{}{
+		"enabled":    *input.Enabled,
+		"key_issued": issue,
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateProjectAPIKey - POST /admin/projects/:id/api-keys issues an API
+// key with the given scopes (chat:write, history:read, analytics:read,
+// webhooks:manage; chat:write alone by default) and, optionally, its own
+// rate_limit in requests per minute. A chat:write key is a publishable key
+// for the widget: once a project has one, embed and chat requests must
+// present an active key (X-Jevi-Key or ?key=). Keys with other scopes are
+// secret, for the /v1 API, and are only shown in this response.
+func CreateProjectAPIKey(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		RateLimit int      `json:"rate_limit" binding:"min=0,max=100000"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if input.Name == "" {
+		input.Name = "Default"
+	}
+	var scopes []string
+	for _, scope := range input.Scopes {
+		if !containsString(models.APIScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope, "scopes": models.APIScopes})
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	key, err := repository.CreateProjectAPIKey(context.Background(), objID, input.Name, c.GetString("user_id"), scopes, input.RateLimit)
+	if err == repository.ErrProjectNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionAPIKeyCreate, "project", projectID, objID, map[string]interface{}{
+		"key_id":     key.ID,
+		"name":       key.Name,
+		"scopes":     key.Scopes,
+		"rate_limit": key.RateLimit,
+	})
+
+	response := gin.H{"success": true, "api_key": key}
+	if key.Secret() {
+		response["message"] = "Store this key now: it is secret and will not be shown again"
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListProjectAPIKeys - GET /admin/projects/:id/api-keys lists the project's
+// keys with their scopes, rate limits, when they were last used and their
+// requests and error rate over the last 24 hours, revoked ones included.
+// Secret keys are listed by their last characters only.
+func ListProjectAPIKeys(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"api_keys": 1}),
+	).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	usage, err := repository.APIKeyUsageTotals(context.Background(), objID, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		log.Printf("⚠️ Failed to load API key usage for %s: %v", objID.Hex(), err)
+	}
+	type listedKey struct {
+		models.ProjectAPIKey
+		Usage24h  repository.APIKeyUsage `json:"usage_24h"`
+		ErrorRate float64                `json:"error_rate_24h"`
+	}
+	keys := make([]listedKey, 0, len(project.APIKeys))
+	for _, key := range project.APIKeys {
+		key.Scopes = key.GrantedScopes()
+		keys = append(keys, listedKey{ProjectAPIKey: key, Usage24h: usage[key.ID], ErrorRate: usage[key.ID].ErrorRate()})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"api_keys": keys,
+		"required": project.HasActiveAPIKey(),
+	})
+}
+
+// RevokeProjectAPIKey - DELETE /admin/projects/:id/api-keys/:keyId stops a
+// key from being accepted. Revoking the last active chat:write key makes the
+// widget routes open again.
+func RevokeProjectAPIKey(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	keyID := c.Param("keyId")
+
+	err = repository.RevokeProjectAPIKey(context.Background(), objID, keyID)
+	if err == repository.ErrAPIKeyNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionAPIKeyRevoke, "project", projectID, objID, map[string]interface{}{
+		"key_id": keyID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "key_id": keyID})
+}
+
+// SetAllowedDomains - PUT /admin/projects/:id/domains replaces the sites
+// allowed to embed the project's widget. Entries must be covered by a
+// verified domain (see AddDomain), or be development hosts such as
+// localhost. An empty list allows any site.
+func SetAllowedDomains(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Domains []string `json:"domains"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if len(input.Domains) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At most 100 domains are allowed"})
+		return
+	}
+
+	domains := []string{}
+	seen := map[string]bool{}
+	for _, d := range input.Domains {
+		domain, err := middleware.NormalizeDomain(d)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain", "details": err.Error()})
+			return
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"domain_verifications": 1})).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	// Only domains the owner has verified, and development hosts, may be
+	// allowlisted
+	unverified := []string{}
+	for _, domain := range domains {
+		if !devHost(domain) && !domainVerified(project.DomainVerifications, domain) {
+			unverified = append(unverified, domain)
+		}
+	}
+	if len(unverified) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      "Verify these domains before allowlisting them",
+			"code":       "domain_unverified",
+			"unverified": unverified,
+		})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"allowed_domains": domains, "updated_at": time.Now()}}
+	if len(domains) == 0 {
+		update = bson.M{"$unset": bson.M{"allowed_domains": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update allowed domains"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionDomainsUpdate, "project", projectID, objID, map[string]interface{}{
+		"domains": domains,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "allowed_domains": domains})
+}
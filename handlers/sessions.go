@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var (
+	errSessionForbidden = errors.New("session belongs to another user")
+	errSessionInvalid   = errors.New("invalid session ID")
+
+	// Legacy widgets generate IDs like "embed_<millis>_<random>"
+	sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,128}$`)
+)
+
+// newSessionID returns a ULID, which sorts by creation time
+func newSessionID() string {
+	return ulid.Make().String()
+}
+
+// resolveChatSession returns the session to record a message under. An empty
+// sessionID starts a new server-generated session; a provided one must be
+// unknown (and is then claimed) or belong to the caller. userID is the
+// authenticated chat user, or NilObjectID for anonymous visitors.
+//
+// A session ID alone proves nothing, so continuing a session that is not
+// bound to the signed-in caller takes the history token issued with its
+// last answer. That covers anonymous visitors and a visitor who signs in
+// mid-conversation and takes their session over.
+func resolveChatSession(ctx context.Context, projectID primitive.ObjectID, sessionID, historyToken string, userID primitive.ObjectID, ip string) (string, error) {
+	if sessionID == "" {
+		sessionID = newSessionID()
+	} else if !sessionIDPattern.MatchString(sessionID) {
+		return "", errSessionInvalid
+	}
+
+	collection := config.GetChatSessionsCollectionFor(projectID)
+	now := time.Now()
+
+	session := models.ChatSession{
+		ProjectID:  projectID,
+		SessionID:  sessionID,
+		UserID:     userID,
+		IsActive:   true,
+		StartTime:  now,
+		IPAddress:  ip,
+		LastSeenAt: now,
+	}
+	_, err := collection.InsertOne(ctx, session)
+	if err == nil {
+		return sessionID, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return "", err
+	}
+
+	// The session exists; the caller must own it
+	var existing models.ChatSession
+	if err := collection.FindOne(ctx, bson.M{
+		"project_id": projectID,
+		"session_id": sessionID,
+	}).Decode(&existing); err != nil {
+		return "", err
+	}
+
+	update := bson.M{"last_seen_at": now}
+	switch {
+	case !userID.IsZero() && existing.UserID == userID:
+	case !existing.UserID.IsZero():
+		return "", errSessionForbidden
+	default:
+		granted, err := parseHistoryToken(historyToken, projectID, now)
+		if err != nil || granted != sessionID {
+			return "", errSessionForbidden
+		}
+		if !userID.IsZero() {
+			// A visitor who signs in mid-conversation takes over their session
+			update["user_id"] = userID
+		}
+	}
+
+	collection.UpdateOne(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": update})
+	return sessionID, nil
+}
+
+// sessionHistoryToken is the history token a message was sent with, from
+// the request body or else the X-History-Token header
+func sessionHistoryToken(c *gin.Context, fromBody string) string {
+	if fromBody != "" {
+		return fromBody
+	}
+	return c.GetHeader(HeaderHistoryToken)
+}
+
+// respondSessionError writes the response for a resolveChatSession failure
+func respondSessionError(c *gin.Context, err error) {
+	switch err {
+	case errSessionInvalid:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+	case errSessionForbidden:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Session does not belong to this user"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start chat session"})
+	}
+}
+
+// CreateChatSession - POST /embed/:projectId/session starts a new session
+func CreateChatSession(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var req struct {
+		UserToken string `json:"user_token"`
+	}
+	c.ShouldBindJSON(&req)
+
+	ctx := context.Background()
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID, "is_active": true}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	userID := primitive.NilObjectID
+	if req.UserToken != "" {
+		user, err := chatUserFromToken(c, project, req.UserToken)
+		if err != nil {
+			respondUserTokenError(c, err)
+			return
+		}
+		userID = user.ID
+	}
+
+	sessionID, err := resolveChatSession(ctx, objID, "", "", userID, c.ClientIP())
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"session_id":    sessionID,
+		"history_token": historyToken(objID, sessionID, time.Now()),
+	})
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// Share links last a week unless asked otherwise, and never more than
+// maxShareLinkDays
+const (
+	defaultShareLinkDays = 7
+	maxShareLinkDays     = 90
+)
+
+// shareLinkURL is the public address of an analytics share link
+func shareLinkURL(token string) string {
+	return strings.TrimRight(os.Getenv("APP_URL"), "/") + "/share/analytics/" + token
+}
+
+// shareLinkStatus is "active", "expired" or "revoked"
+func shareLinkStatus(link models.AnalyticsShareLink, now time.Time) string {
+	switch {
+	case !link.RevokedAt.IsZero():
+		return "revoked"
+	case !now.Before(link.ExpiresAt):
+		return "expired"
+	}
+	return "active"
+}
+
+// CreateAnalyticsShareLink - POST /admin/projects/:id/share-links creates a
+// read-only link to the project's analytics. Takes label, expires_in_days
+// (default 7, at most 90), days (the daily series shown, default 30) and
+// tz. The link is only returned here.
+func CreateAnalyticsShareLink(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Label         string `json:"label"`
+		ExpiresInDays int    `json:"expires_in_days"`
+		Days          int    `json:"days"`
+		Timezone      string `json:"tz"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if input.ExpiresInDays == 0 {
+		input.ExpiresInDays = defaultShareLinkDays
+	}
+	if input.ExpiresInDays < 0 || input.ExpiresInDays > maxShareLinkDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and 90"})
+		return
+	}
+	if input.Days <= 0 {
+		input.Days = 30
+	}
+	if input.Days > maxSeriesDays {
+		input.Days = maxSeriesDays
+	}
+	if input.Timezone == "" {
+		loc, _ := analyticsLocation(c)
+		input.Timezone = loc.String()
+	}
+	if _, err := time.LoadLocation(input.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone " + input.Timezone})
+		return
+	}
+
+	if n, err := config.GetProjectsCollection().CountDocuments(context.Background(), bson.M{"_id": objID}); err != nil || n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	now := time.Now()
+	token := "shr_" + randomHex(24)
+	link := models.AnalyticsShareLink{
+		ID:        primitive.NewObjectID(),
+		ProjectID: objID,
+		Label:     input.Label,
+		TokenHash: hashHex(token),
+		Days:      input.Days,
+		Timezone:  input.Timezone,
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, input.ExpiresInDays),
+	}
+	if _, err := config.GetAnalyticsShareLinksCollection().InsertOne(context.Background(), link); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionShareCreate, "share_link", link.ID.Hex(), objID, map[string]interface{}{
+		"label":      link.Label,
+		"expires_at": link.ExpiresAt,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":    true,
+		"share_link": link,
+		"url":        shareLinkURL(token),
+		"message":    "Copy the link now; it will not be shown again",
+	})
+}
+
+// ListAnalyticsShareLinks - GET /admin/projects/:id/share-links lists the
+// project's share links, newest first, with their status and view counts
+func ListAnalyticsShareLinks(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := config.GetAnalyticsShareLinksCollection().Find(context.Background(), bson.M{"project_id": objID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch share links"})
+		return
+	}
+	var links []models.AnalyticsShareLink
+	if err := cursor.All(context.Background(), &links); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode share links"})
+		return
+	}
+
+	now := time.Now()
+	items := make([]gin.H, len(links))
+	for i, link := range links {
+		items[i] = gin.H{"share_link": link, "status": shareLinkStatus(link, now)}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "share_links": items, "count": len(items)})
+}
+
+// RevokeAnalyticsShareLink - DELETE /admin/projects/:id/share-links/:linkId
+// stops a share link from working
+func RevokeAnalyticsShareLink(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	linkID, err := primitive.ObjectIDFromHex(c.Param("linkId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+		return
+	}
+
+	result, err := config.GetAnalyticsShareLinksCollection().UpdateOne(context.Background(),
+		bson.M{"_id": linkID, "project_id": objID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		n, _ := config.GetAnalyticsShareLinksCollection().CountDocuments(context.Background(), bson.M{"_id": linkID, "project_id": objID})
+		if n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+			return
+		}
+	} else {
+		recordAudit(c, models.AuditActionShareRevoke, "share_link", linkID.Hex(), objID, nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "id": linkID.Hex(), "status": "revoked"})
+}
+
+// GetSharedAnalytics - GET /share/analytics/:token shows the analytics of
+// the project a share link belongs to. It needs no account; each view is
+// counted on the link.
+func GetSharedAnalytics(c *gin.Context) {
+	ctx := context.Background()
+	now := time.Now()
+
+	var link models.AnalyticsShareLink
+	err := config.GetAnalyticsShareLinksCollection().FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": hashHex(c.Param("token")),
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$inc": bson.M{"views": 1}, "$set": bson.M{"last_viewed_at": now}},
+	).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This share link is invalid, expired or revoked"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load share link"})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": link.ProjectID},
+		options.FindOne().SetProjection(bson.M{"name": 1}),
+	).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	loc, err := time.LoadLocation(link.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	summary, err := projectAnalyticsSummary(ctx, link.ProjectID, loc, link.Days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"project_name": project.Name,
+		"label":        link.Label,
+		"analytics":    summary,
+		"generated_at": now,
+		"expires_at":   link.ExpiresAt,
+	})
+}
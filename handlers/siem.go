@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// siemForwardLag keeps the forwarder this far behind the newest records.
+// ObjectIDs from different replicas are only ordered to the second, so a
+// record written late in the same second must not be skipped.
+const siemForwardLag = 10 * time.Second
+
+// siemStream is a collection exported to SIEMs
+type siemStream struct {
+	name       string // event_type and checkpoint name
+	collection func() *mongo.Collection
+	filter     func() bson.M
+	decode     func(*mongo.Cursor) (interface{}, error)
+}
+
+var siemStreams = []siemStream{
+	{
+		name:       "audit",
+		collection: config.GetAuditLogsCollection,
+		filter:     func() bson.M { return bson.M{} },
+		decode: func(cur *mongo.Cursor) (interface{}, error) {
+			var entry models.AuditLog
+			err := cur.Decode(&entry)
+			return entry, err
+		},
+	},
+	{
+		name:       "notification",
+		collection: config.GetNotificationsCollection,
+		filter: func() bson.M {
+			return bson.M{"type": bson.M{"$in": siemNotificationTypes()}}
+		},
+		decode: func(cur *mongo.Cursor) (interface{}, error) {
+			var n models.Notification
+			err := cur.Decode(&n)
+			return n, err
+		},
+	},
+}
+
+func siemNotificationTypes() []string {
+	if config.SIEMSettings == nil {
+		return []string{models.NotificationTypeSecurity}
+	}
+	return config.SIEMSettings.NotificationTypes
+}
+
+// ExportAuditLogsNDJSON - GET /admin/audit-logs/export streams audit log
+// entries oldest first as NDJSON, mapped for SIEM ingestion. Takes since
+// and until (RFC 3339), action and project_id.
+func ExportAuditLogsNDJSON(c *gin.Context) {
+	filter, err := siemTimeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if action := c.Query("action"); action != "" {
+		filter["action"] = action
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		objID, err := primitive.ObjectIDFromHex(projectID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+		filter["project_id"] = objID
+	}
+
+	streamSIEMExport(c, siemStreams[0], filter)
+}
+
+// ExportSecurityNotificationsNDJSON - GET /admin/notifications/export
+// streams security notifications oldest first as NDJSON. Takes since, until
+// and types (comma separated; SIEM_NOTIFICATION_TYPES by default).
+func ExportSecurityNotificationsNDJSON(c *gin.Context) {
+	filter, err := siemTimeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	types := siemNotificationTypes()
+	if t := c.Query("types"); t != "" {
+		types = strings.Split(t, ",")
+	}
+	filter["type"] = bson.M{"$in": types}
+
+	streamSIEMExport(c, siemStreams[1], filter)
+}
+
+// siemTimeFilter reads ?since and ?until into a created_at filter
+func siemTimeFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{}
+	created := bson.M{}
+	for param, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time", param)
+		}
+		created[op] = t
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	return filter, nil
+}
+
+// streamSIEMExport writes matching records as they are read, so exports of
+// any size run in constant memory
+func streamSIEMExport(c *gin.Context, stream siemStream, filter bson.M) {
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := stream.collection().Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read " + stream.name + " records"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.ndjson"`, stream.name, time.Now().UTC().Format("20060102T150405Z")))
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	written := 0
+	for cursor.Next(ctx) {
+		record, err := stream.decode(cursor)
+		if err != nil {
+			log.Printf("⚠️ Skipping undecodable %s record: %v", stream.name, err)
+			continue
+		}
+		event, err := config.SIEMEvent(stream.name, record)
+		if err != nil {
+			continue
+		}
+		if err := enc.Encode(event); err != nil {
+			return // client went away
+		}
+		if written++; written%1000 == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("⚠️ %s export stopped early: %v", stream.name, err)
+	}
+}
+
+// ForwardSecurityEvents sends audit log entries and security notifications
+// written since the last run to the configured forwarder. Each stream keeps
+// a checkpoint in siem_checkpoints and only advances it once a batch is
+// accepted, so delivery is at least once. A stream without a checkpoint
+// starts from now; older records are available from the export endpoints.
+func ForwardSecurityEvents() error {
+	if config.SIEMSettings == nil || config.SIEMSettings.Forwarder == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	for _, stream := range siemStreams {
+		n, err := forwardSIEMStream(ctx, stream)
+		if n > 0 {
+			log.Printf("📤 Forwarded %d %s event(s) to %s", n, stream.name, config.SIEMSettings.Forwarder)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", stream.name, err)
+		}
+	}
+	return nil
+}
+
+func forwardSIEMStream(ctx context.Context, stream siemStream) (int, error) {
+	checkpoints := config.GetCollection("siem_checkpoints")
+	upTo := primitive.NewObjectIDFromTimestamp(time.Now().Add(-siemForwardLag))
+
+	var checkpoint struct {
+		LastID primitive.ObjectID `bson:"last_id"`
+	}
+	err := checkpoints.FindOne(ctx, bson.M{"_id": stream.name}).Decode(&checkpoint)
+	if err == mongo.ErrNoDocuments {
+		_, err = checkpoints.InsertOne(ctx, bson.M{"_id": stream.name, "last_id": upTo, "updated_at": time.Now()})
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	batchSize := config.SIEMSettings.BatchSize
+	total := 0
+	for {
+		filter := stream.filter()
+		filter["_id"] = bson.M{"$gt": checkpoint.LastID, "$lt": upTo}
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batchSize))
+
+		cursor, err := stream.collection().Find(ctx, filter, opts)
+		if err != nil {
+			return total, err
+		}
+		var events []map[string]interface{}
+		var lastID primitive.ObjectID
+		read := 0
+		for cursor.Next(ctx) {
+			read++
+			lastID = cursor.Current.Lookup("_id").ObjectID()
+			record, err := stream.decode(cursor)
+			if err != nil {
+				continue
+			}
+			if event, err := config.SIEMEvent(stream.name, record); err == nil {
+				events = append(events, event)
+			}
+		}
+		cursor.Close(ctx)
+		if lastID.IsZero() {
+			return total, nil
+		}
+
+		if err := config.ForwardSIEMEvents(ctx, events); err != nil {
+			return total, err
+		}
+		total += len(events)
+
+		checkpoint.LastID = lastID
+		if _, err := checkpoints.UpdateOne(ctx, bson.M{"_id": stream.name}, bson.M{
+			"$set": bson.M{"last_id": lastID, "updated_at": time.Now()},
+		}); err != nil {
+			return total, err
+		}
+		if read < batchSize {
+			return total, nil
+		}
+	}
+}
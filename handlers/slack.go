@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// globalSlackTypes are the notification types posted to the server-wide
+// SLACK_WEBHOOK_URL for projects without Slack routing of their own
+var globalSlackTypes = map[string]bool{
+	models.NotificationTypeLimitExpired: true,
+	models.NotificationTypeError:        true,
+}
+
+// slackSeverityEmoji marks a message's severity in its header
+var slackSeverityEmoji = map[string]string{
+	models.NotificationSeverityInfo:    ":information_source:",
+	models.NotificationSeverityWarning: ":warning:",
+	models.NotificationSeverityError:   ":rotating_light:",
+}
+
+// slackDestination is where a notification is posted
+type slackDestination struct {
+	WebhookURL  string
+	Channel     string
+	ProjectName string
+}
+
+// slackDestinationFor picks the webhook for a notification: the project's
+// route for its type, then the project's default webhook, then, for
+// projects without routing, the server-wide webhook. ok is false when the
+// notification is not posted.
+func slackDestinationFor(ctx context.Context, n models.Notification) (dest slackDestination, ok bool, err error) {
+	if !n.ProjectID.IsZero() {
+		var project models.Project
+		err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": n.ProjectID},
+			options.FindOne().SetProjection(bson.M{"slack": 1, "name": 1}),
+		).Decode(&project)
+		if err == nil && project.Slack != nil {
+			encrypted, channel := project.Slack.EncryptedWebhook, project.Slack.Channel
+			for _, route := range project.Slack.Routes {
+				if containsString(route.Types, n.Type) {
+					encrypted, channel = route.EncryptedWebhook, route.Channel
+					break
+				}
+			}
+			if encrypted == "" {
+				return dest, false, nil
+			}
+			webhook, err := config.DecryptSecret(encrypted)
+			if err != nil {
+				return dest, false, err
+			}
+			return slackDestination{WebhookURL: webhook, Channel: channel, ProjectName: project.Name}, true, nil
+		}
+	}
+
+	if config.NotificationSettings == nil || config.NotificationSettings.SlackWebhookURL == "" || !globalSlackTypes[n.Type] {
+		return dest, false, nil
+	}
+	name, _ := n.Metadata["project_name"].(string)
+	return slackDestination{WebhookURL: config.NotificationSettings.SlackWebhookURL, ProjectName: name}, true, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// postSlackMessage sends a message payload to an incoming webhook
+func postSlackMessage(ctx context.Context, webhookURL string, payload interface{}) error {
+	return postWebhookJSON(ctx, "slack", webhookURL, payload)
+}
+
+// slackText truncates s to Slack's limit for a text object
+func slackText(s string, limit int) string {
+	if r := []rune(s); len(r) > limit {
+		return string(r[:limit-1]) + "…"
+	}
+	return s
+}
+
+// slackFields are the details shown beneath a notification's message: usage
+// against the limit for limit-expired notifications, otherwise its metadata
+func slackFields(n models.Notification) []map[string]interface{} {
+	var fields []map[string]interface{}
+	for _, d := range notificationDetails(n, 10) { // Slack's limit per section
+		fields = append(fields, map[string]interface{}{
+			"type": "mrkdwn",
+			"text": slackText(fmt.Sprintf("*%s*\n%s", d.Label, d.Value), 2000),
+		})
+	}
+	return fields
+}
+
+// slackPayload formats a notification as a Block Kit message: a header with
+// its severity, the message, its details and when and where it happened.
+// text is the fallback shown in push notifications.
+func slackPayload(n models.Notification, dest slackDestination) map[string]interface{} {
+	severity := n.Severity
+	if severity == "" {
+		severity = models.NotificationSeverity(n.Type)
+	}
+	emoji := slackSeverityEmoji[severity]
+	if emoji == "" {
+		emoji = slackSeverityEmoji[models.NotificationSeverityInfo]
+	}
+	created := n.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": slackText(n.Title, 150), "emoji": true},
+		},
+		{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": slackText(n.Message, 3000)},
+		},
+	}
+	if fields := slackFields(n); len(fields) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+
+	footer := []string{emoji + " " + strings.ReplaceAll(n.Type, "_", " ")}
+	if dest.ProjectName != "" {
+		footer = append(footer, "*"+dest.ProjectName+"*")
+	}
+	footer = append(footer, fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>",
+		created.Unix(), created.UTC().Format("2006-01-02 15:04 UTC")))
+	blocks = append(blocks, map[string]interface{}{
+		"type": "context",
+		"elements": []map[string]interface{}{
+			{"type": "mrkdwn", "text": strings.Join(footer, "  •  ")},
+		},
+	})
+
+	payload := map[string]interface{}{
+		"text":   slackText(fmt.Sprintf("%s %s: %s", emoji, n.Title, n.Message), 3000),
+		"blocks": blocks,
+	}
+	if dest.Channel != "" {
+		payload["channel"] = dest.Channel
+	}
+	return payload
+}
+
+// sendWebhookNotification posts a notification to Slack, where the project's
+// routing, or the server-wide webhook, sends it
+func sendWebhookNotification(n models.Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	dest, ok, err := slackDestinationFor(ctx, n)
+	if err != nil {
+		log.Printf("⚠️ Slack routing for project %s unavailable: %v", n.ProjectID.Hex(), err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	payload := slackPayload(n, dest)
+	attempts, err := deliverWithRetry(ctx, func(ctx context.Context) error {
+		return postSlackMessage(ctx, dest.WebhookURL, payload)
+	})
+	if err != nil {
+		recordDeliveryFailure(n, "slack", attempts, err)
+	}
+}
+
+// validSlackWebhook accepts Slack incoming webhook URLs
+func validSlackWebhook(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && (u.Host == "hooks.slack.com" || strings.HasSuffix(u.Host, ".slack.com"))
+}
+
+// SetSlackRouting - PUT /admin/projects/:id/slack sets where the project's
+// notifications are posted: webhook_url and channel for the default, and
+// routes sending some notification types to other channels. A webhook URL
+// left out keeps the one stored for the default or for the route's
+// channel.
+func SetSlackRouting(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		WebhookURL string `json:"webhook_url"`
+		Channel    string `json:"channel" binding:"max=80"`
+		Routes     []struct {
+			Types      []string `json:"types" binding:"required,min=1,dive,oneof=limit_expired success warning error info security"`
+			Channel    string   `json:"channel" binding:"required,max=80"`
+			WebhookURL string   `json:"webhook_url"`
+		} `json:"routes" binding:"max=20,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	collection := config.GetProjectsCollection()
+	var project models.Project
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	existing := project.Slack
+	if existing == nil {
+		existing = &models.SlackRouting{}
+	}
+
+	// seal encrypts a new webhook URL, or keeps the stored one
+	seal := func(webhookURL, stored string) (string, bool) {
+		if webhookURL == "" {
+			return stored, true
+		}
+		if !validSlackWebhook(webhookURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "webhook_url must be a Slack incoming webhook URL (https://hooks.slack.com/...)"})
+			return "", false
+		}
+		encrypted, err := config.EncryptSecret(webhookURL)
+		if err == config.ErrSecretsKeyMissing {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Credential encryption is not configured on this server"})
+			return "", false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store webhook URL"})
+			return "", false
+		}
+		return encrypted, true
+	}
+
+	routing := models.SlackRouting{
+		Channel:   strings.TrimSpace(input.Channel),
+		UpdatedAt: time.Now(),
+	}
+	var ok bool
+	if routing.EncryptedWebhook, ok = seal(input.WebhookURL, existing.EncryptedWebhook); !ok {
+		return
+	}
+
+	routed := map[string]string{}
+	for _, r := range input.Routes {
+		channel := strings.TrimSpace(r.Channel)
+		stored := ""
+		for _, old := range existing.Routes {
+			if old.Channel == channel {
+				stored = old.EncryptedWebhook
+			}
+		}
+		route := models.SlackRoute{Types: r.Types, Channel: channel}
+		if route.EncryptedWebhook, ok = seal(r.WebhookURL, stored); !ok {
+			return
+		}
+		if route.EncryptedWebhook == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "webhook_url is required for channel " + channel})
+			return
+		}
+		for _, t := range r.Types {
+			if other, dup := routed[t]; dup {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s notifications are routed to both %s and %s", t, other, channel)})
+				return
+			}
+			routed[t] = channel
+		}
+		routing.Routes = append(routing.Routes, route)
+	}
+
+	update := bson.M{"$set": bson.M{"slack": routing, "updated_at": time.Now()}}
+	if routing.EncryptedWebhook == "" && len(routing.Routes) == 0 {
+		update = bson.M{"$unset": bson.M{"slack": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update Slack routing"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionSlackUpdate, "project", projectID, objID, map[string]interface{}{
+		"channel":         routing.Channel,
+		"routes":          routed,
+		"webhook_changed": input.WebhookURL != "",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "slack": routing})
+}
+
+// DeleteSlackRouting - DELETE /admin/projects/:id/slack removes the
+// project's Slack routing; its notifications fall back to the server-wide
+// webhook
+func DeleteSlackRouting(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$unset": bson.M{"slack": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove Slack routing"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionSlackUpdate, "project", projectID, objID, map[string]interface{}{
+		"removed": true,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "slack": nil})
+}
+
+// TestSlackRouting - POST /admin/projects/:id/slack/test posts a test
+// message for a notification type (default info) to the channel it routes
+// to, and reports the result
+func TestSlackRouting(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	notificationType := c.DefaultQuery("type", models.NotificationTypeInfo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	test := models.Notification{ProjectID: objID, Type: notificationType}
+	dest, ok, err := slackDestinationFor(ctx, test)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Slack routing unavailable", "details": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"success": false, "type": notificationType, "message": "Notifications of this type are not posted to Slack"})
+		return
+	}
+
+	test.Title = "Test notification"
+	test.Message = strings.ReplaceAll(notificationType, "_", " ") + " notifications for this project are posted here."
+	test.Severity = models.NotificationSeverity(notificationType)
+	if err := postSlackMessage(ctx, dest.WebhookURL, slackPayload(test, dest)); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Slack rejected the test message", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "type": notificationType, "channel": dest.Channel})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// GetSLOReport - GET /admin/slo reports compliance and remaining error
+// budget per route group over the last ?days (default 7, at most 90)
+func GetSLOReport(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 1 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 90"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	report, err := config.SLOReport(ctx, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute SLO report"})
+		return
+	}
+
+	allMet := true
+	for _, s := range report {
+		allMet = allMet && s.Met
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window_days": days,
+		"since":       since.Format(time.RFC3339),
+		"all_met":     allMet,
+		"slos":        report,
+	})
+}
+
+// SendWeeklySLOSummary posts a platform notification summarising the last
+// seven days, as a warning if any objective was missed
+func SendWeeklySLOSummary() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	report, err := config.SLOReport(ctx, time.Now().Add(-7*24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	var missed []string
+	summary := make([]map[string]interface{}, 0, len(report))
+	for _, s := range report {
+		if !s.Met {
+			missed = append(missed, fmt.Sprintf("%s (availability %.2f%%, fast %.1f%%)", s.Group, s.Availability*100, s.LatencyCompliance*100))
+		}
+		summary = append(summary, map[string]interface{}{
+			"group":                         s.Group,
+			"met":                           s.Met,
+			"availability":                  s.Availability,
+			"latency_compliance":            s.LatencyCompliance,
+			"availability_budget_remaining": s.AvailabilityBudgetRemaining,
+			"latency_budget_remaining":      s.LatencyBudgetRemaining,
+		})
+	}
+
+	notificationType := models.NotificationTypeInfo
+	message := "All service level objectives were met this week."
+	if len(missed) > 0 {
+		notificationType = models.NotificationTypeWarning
+		message = "Objectives missed this week: " + strings.Join(missed, "; ")
+	}
+
+	return CreateNotification(primitive.NilObjectID, primitive.NilObjectID, notificationType, "Weekly SLO summary", message, map[string]interface{}{
+		"report":         "slo_weekly",
+		"slos":           summary,
+		"auto_generated": true,
+	})
+}
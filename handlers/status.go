@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+const (
+	// The status page covers the last day, and is recomputed at most once
+	// a minute per project however often it is polled
+	statusWindow   = 24 * time.Hour
+	statusCacheTTL = time.Minute
+	// At most this many of the window's replies are sampled for latency
+	statusSampleLimit = 50000
+)
+
+// Project status levels
+const (
+	statusOperational = "operational"
+	statusDegraded    = "degraded"
+	statusOutage      = "outage"
+	statusInactive    = "inactive"
+)
+
+// ProjectStatusHour is one hour of a project's status history
+type ProjectStatusHour struct {
+	Hour         time.Time `json:"hour"`
+	Requests     int       `json:"requests"`
+	Availability float64   `json:"availability"`
+	MedianMs     int64     `json:"median_response_ms"`
+}
+
+// ProjectStatus is what a project's public status page shows
+type ProjectStatus struct {
+	ProjectID    string              `json:"project_id"`
+	ProjectName  string              `json:"project_name"`
+	Status       string              `json:"status"`
+	Requests     int                 `json:"requests"`
+	Availability float64             `json:"availability"`
+	MedianMs     int64               `json:"median_response_ms"`
+	P95Ms        int64               `json:"p95_response_ms"`
+	Platform     float64             `json:"platform_availability"`
+	Hours        []ProjectStatusHour `json:"hours"`
+	GeneratedAt  time.Time           `json:"generated_at"`
+}
+
+// AvailabilityPercent formats the availability for the status page
+func (s *ProjectStatus) AvailabilityPercent() string {
+	return fmt.Sprintf("%.2f%%", s.Availability*100)
+}
+
+// Level rates the hour for the status page; hours without traffic count
+// as operational
+func (h ProjectStatusHour) Level() string {
+	return statusLevel(h.Availability)
+}
+
+type cachedStatus struct {
+	status  *ProjectStatus
+	expires time.Time
+}
+
+var (
+	statusCacheMu sync.Mutex
+	statusCache   = map[primitive.ObjectID]cachedStatus{}
+)
+
+// percentile returns the p-th percentile (0-1) of sorted values
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// statusLevel rates availability the way the status page shows it
+func statusLevel(availability float64) string {
+	switch {
+	case availability >= 0.99:
+		return statusOperational
+	case availability >= 0.9:
+		return statusDegraded
+	}
+	return statusOutage
+}
+
+// computeProjectStatus derives a project's availability and reply times
+// over the last day from its usage logs. The platform-wide chat
+// availability from the SLO metrics also counts, since a project with
+// little traffic may not have seen an outage everyone else did.
+func computeProjectStatus(ctx context.Context, project models.Project) (*ProjectStatus, error) {
+	now := time.Now().UTC()
+	since := now.Add(-statusWindow)
+
+	cursor, err := config.GetGeminiUsageLogsCollection().Find(ctx,
+		bson.M{"project_id": project.ID, "timestamp": bson.M{"$gte": since}},
+		options.Find().
+			SetProjection(bson.M{"timestamp": 1, "success": 1, "response_time_ms": 1}).
+			SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+			SetLimit(statusSampleLimit))
+	if err != nil {
+		return nil, err
+	}
+	var logs []models.GeminiUsageLog
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, err
+	}
+
+	status := &ProjectStatus{
+		ProjectID:    project.ID.Hex(),
+		ProjectName:  project.Name,
+		Availability: 1,
+		Platform:     1,
+		GeneratedAt:  now,
+	}
+	if report, err := config.SLOReport(ctx, since); err == nil {
+		for _, slo := range report {
+			if slo.Group == "chat" {
+				status.Platform = slo.Availability
+			}
+		}
+	}
+
+	start := now.Truncate(time.Hour).Add(-statusWindow + time.Hour)
+	hourTimes := make([][]int64, 24)
+	hourFailures := make([]int, 24)
+	var times []int64
+	failures := 0
+	for _, l := range logs {
+		i := int(l.Timestamp.Sub(start) / time.Hour)
+		if i < 0 || i >= 24 {
+			continue
+		}
+		status.Requests++
+		if !l.Success {
+			failures++
+			hourFailures[i]++
+			continue
+		}
+		times = append(times, l.ResponseTime)
+		hourTimes[i] = append(hourTimes[i], l.ResponseTime)
+	}
+
+	sort.Slice(times, func(a, b int) bool { return times[a] < times[b] })
+	status.MedianMs = percentile(times, 0.5)
+	status.P95Ms = percentile(times, 0.95)
+	if status.Requests > 0 {
+		status.Availability = 1 - float64(failures)/float64(status.Requests)
+	} else {
+		status.Availability = status.Platform
+	}
+
+	for i := 0; i < 24; i++ {
+		hour := ProjectStatusHour{Hour: start.Add(time.Duration(i) * time.Hour), Availability: 1}
+		ht := hourTimes[i]
+		hour.Requests = len(ht) + hourFailures[i]
+		if hour.Requests > 0 {
+			sort.Slice(ht, func(a, b int) bool { return ht[a] < ht[b] })
+			hour.MedianMs = percentile(ht, 0.5)
+			hour.Availability = float64(len(ht)) / float64(hour.Requests)
+		}
+		status.Hours = append(status.Hours, hour)
+	}
+
+	switch {
+	case !project.IsActive:
+		status.Status = statusInactive
+	default:
+		status.Status = statusLevel(math.Min(status.Availability, status.Platform))
+	}
+	return status, nil
+}
+
+// GetProjectStatus - GET /status/:projectId is a project's public status
+// page: bot availability and median reply time over the last 24 hours, as
+// JSON or, for browsers, as HTML. It shows nothing about conversations.
+func GetProjectStatus(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	statusCacheMu.Lock()
+	cached, ok := statusCache[objID]
+	statusCacheMu.Unlock()
+
+	status := cached.status
+	if !ok || time.Now().After(cached.expires) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var project models.Project
+		err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID},
+			options.FindOne().SetProjection(bson.M{"name": 1, "is_active": 1}),
+		).Decode(&project)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+		status, err = computeProjectStatus(ctx, project)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load status"})
+			return
+		}
+
+		statusCacheMu.Lock()
+		for id, s := range statusCache {
+			if time.Now().After(s.expires) {
+				delete(statusCache, id)
+			}
+		}
+		statusCache[objID] = cachedStatus{status: status, expires: time.Now().Add(statusCacheTTL)}
+		statusCacheMu.Unlock()
+	}
+
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		c.HTML(http.StatusOK, "status.html", status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const streamTimeout = 2 * time.Minute
+
+// IframeStreamMessage - POST /embed/:projectId/message/stream answers a
+// widget message as server-sent events: "chunk" events carry text as it is
+// generated and a final "done" or "error" event ends the stream.
+//
+// One request of the monthly quota is reserved before generation starts and
+// settled with the real token counts at the end. A generation that fails
+// before producing any text is refunded.
+func IframeStreamMessage(c *gin.Context) {
+	msg, ok := prepareWidgetMessage(c)
+	if !ok {
+		return
+	}
+	project := msg.Project
+
+	if ack := msg.Acknowledged; ack != nil {
+		c.Header("Cache-Control", "no-cache")
+		c.SSEvent("chunk", gin.H{"text": ack.Response})
+		c.SSEvent("done", gin.H{"session_id": ack.SessionID, "status": "success", "duplicate": true, "client_message_id": ack.ClientMessageID, "clarifying": ack.Clarifying, "citations": ack.Citations})
+		return
+	}
+
+	if project.GeminiAPIKey == "" && !msg.DryRun {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AI configuration is incomplete. Please contact support."})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	// The welcome message is not generated and costs no quota
+	if isFirstMessage(project.ID, msg.SessionID) {
+		msg.save(project.WelcomeMessage)
+		c.SSEvent("chunk", gin.H{"text": project.WelcomeMessage})
+		c.SSEvent("done", gin.H{"session_id": msg.SessionID, "status": "success"})
+		return
+	}
+
+	if msg.DryRun {
+		streamDryRun(c, msg)
+		return
+	}
+
+	if handoff, ok := intentHandoff(msg); ok {
+		msg.save(handoff)
+		c.SSEvent("chunk", gin.H{"text": handoff})
+		c.SSEvent("done", gin.H{"session_id": msg.SessionID, "status": "handoff", "handoff_requested": true})
+		return
+	}
+
+	if answer, ok := answerOrderQuery(project, msg.Message, msg.SessionID, msg.ChatUser); ok {
+		msg.save(answer)
+		c.SSEvent("chunk", gin.H{"text": answer})
+		c.SSEvent("done", gin.H{"session_id": msg.SessionID, "status": "success"})
+		return
+	}
+
+	if project.Booking != nil {
+		streamBookingReply(c, msg)
+		return
+	}
+
+	query, clarifying := clarifyMessage(msg)
+	if clarifying != "" {
+		msg.Clarifying = true
+		msg.save(clarifying)
+		c.SSEvent("chunk", gin.H{"text": clarifying})
+		c.SSEvent("done", gin.H{"session_id": msg.SessionID, "status": "success", "clarifying": true})
+		return
+	}
+
+	reservation, err := repository.ReserveGeminiQuota(context.Background(), project.ID)
+	if err == repository.ErrQuotaExceeded {
+		go emitLimitCrossed(project, msg.SessionID, project.GeminiMonthlyLimit)
+		c.SSEvent("chunk", gin.H{"text": "Your limit has expired."})
+		info := quotaLimitInfo(&project)
+		c.SSEvent("done", gin.H{
+			"session_id": msg.SessionID,
+			"status":     "monthly_limit_exceeded",
+			"code":       info.Code,
+			"limit_info": info,
+		})
+		return
+	}
+	if err != nil {
+		streamFallback(c, msg, models.FallbackReasonError)
+		return
+	}
+	if reservation.Overage > 0 {
+		go notifyOverage(project, reservation.Overage)
+	}
+	// Refunds the request unless it was committed below
+	defer func() {
+		if err := reservation.Release(context.Background()); err != nil {
+			log.Printf("⚠️ Failed to refund Gemini quota for project %s: %v", project.ID.Hex(), err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), streamTimeout)
+	defer cancel()
+
+	client, err := config.GeminiClientFor(ctx, project.GeminiAPIKey)
+	if err != nil {
+		streamFallback(c, msg, models.FallbackReasonError)
+		return
+	}
+
+	modelName := project.GeminiModel
+	if modelName == "" {
+		modelName = "gemini-2.0-flash"
+	}
+	model := client.GenerativeModel(modelName)
+	model.SetTemperature(0.85)
+	model.SetTopP(0.9)
+	model.SetTopK(40)
+
+	project.PDFContent = knowledgeContext(project, query)
+	prompt := buildSupportPrompt(project.Name, withFallbackRule(project), withCatalogContext(project, query), query)
+	iter := model.GenerateContentStream(ctx, genai.Text(prompt))
+
+	var answer strings.Builder
+	// The reply may be the unanswered marker, which visitors must not see,
+	// so text is held back while it still could be
+	hold := project.Fallback != nil
+	var usage *genai.UsageMetadata
+	var streamErr error
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata
+		}
+		for _, cand := range resp.Candidates {
+			if cand.Content == nil {
+				continue
+			}
+			for _, part := range cand.Content.Parts {
+				text := fmt.Sprintf("%v", part)
+				answer.WriteString(text)
+				if hold {
+					if couldBeUnanswered(answer.String()) {
+						continue
+					}
+					hold = false
+					text = answer.String()
+				}
+				c.SSEvent("chunk", gin.H{"text": text})
+			}
+		}
+		c.Writer.Flush()
+	}
+
+	// A visitor closing the widget says nothing about the key
+	if c.Request.Context().Err() == nil {
+		config.ReportGeminiResult(project.GeminiAPIKey, streamErr)
+	}
+
+	if answer.Len() == 0 {
+		if streamErr != nil {
+			log.Printf("⚠️ Gemini stream failed for project %s: %v", project.ID.Hex(), streamErr)
+		}
+		streamFallback(c, msg, models.FallbackReasonError)
+		return
+	}
+
+	// Partial answers were shown to the visitor, so they count
+	response := answer.String()
+	inputTokens, outputTokens := estimateTokens(prompt), estimateTokens(response)
+	if usage != nil {
+		inputTokens, outputTokens = int(usage.PromptTokenCount), int(usage.CandidatesTokenCount)
+	}
+	entry := models.GeminiUsageLog{
+		Question:      msg.Message,
+		Response:      response,
+		Model:         modelName,
+		TokensUsed:    inputTokens + outputTokens,
+		InputTokens:   inputTokens,
+		OutputTokens:  outputTokens,
+		EstimatedCost: calculateGeminiCost(modelName, inputTokens, outputTokens),
+		UserIP:        msg.ClientIP,
+		Success:       streamErr == nil,
+	}
+	if !msg.ChatUser.ID.IsZero() {
+		entry.UserID = msg.ChatUser.ID
+		entry.UserName = msg.ChatUser.Name
+	}
+	if err := reservation.Commit(context.Background(), entry); err != nil {
+		log.Printf("⚠️ Failed to log Gemini usage for project %s: %v", project.ID.Hex(), err)
+	}
+
+	if project.Fallback != nil && isUnanswered(response) {
+		streamFallback(c, msg, models.FallbackReasonUnanswered)
+		return
+	}
+	if hold {
+		c.SSEvent("chunk", gin.H{"text": response})
+	}
+
+	msg.Citations = findCitations(project, query, response)
+	msg.save(response)
+
+	status := "success"
+	if streamErr != nil {
+		status = "incomplete"
+	}
+	done := gin.H{
+		"session_id": msg.SessionID,
+		"status":     status,
+		"citations":  msg.Citations,
+
+		"history_token": historyToken(project.ID, msg.SessionID, time.Now()),
+		"usage_info": gin.H{
+			"input_tokens":  inputTokens,
+			"output_tokens": outputTokens,
+		},
+	}
+	if reducedData(c, &project) {
+		delete(done, "usage_info")
+		done["citations"] = trimCitations(msg.Citations)
+	}
+	c.SSEvent("done", done)
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+const (
+	// maxTranscriptMessages caps the transcript copied into a ticket; longer
+	// sessions keep their latest messages
+	maxTranscriptMessages = 200
+
+	// A pending escalation older than this is assumed to have crashed and
+	// may be claimed again
+	escalationClaimTimeout = 2 * time.Minute
+
+	ticketCreateTimeout = 20 * time.Second
+)
+
+// normalizeTicketTags lowercases tags and replaces spaces, which Zendesk
+// and Jira labels do not allow
+func normalizeTicketTags(tags []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.ToLower(tag)), "_")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// SetTicketingIntegration - PUT /admin/projects/:id/ticketing configures the
+// ticketing system chat sessions are escalated to. An empty provider removes
+// the integration. The API token may be omitted to keep the stored one.
+func SetTicketingIntegration(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Provider      string   `json:"provider" binding:"omitempty,oneof=zendesk freshdesk jira_service_management"`
+		BaseURL       string   `json:"base_url"`
+		Email         string   `json:"email"`
+		APIToken      string   `json:"api_token"`
+		ServiceDeskID string   `json:"service_desk_id"`
+		RequestTypeID string   `json:"request_type_id"`
+		Tags          []string `json:"tags" binding:"max=20"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	collection := config.GetProjectsCollection()
+	var project models.Project
+	if err := collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	if input.Provider == "" {
+		_, err := collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+			"$unset": bson.M{"ticketing": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticketing settings"})
+			return
+		}
+		recordAudit(c, models.AuditActionTicketingUpdate, "project", projectID, objID, map[string]interface{}{
+			"provider": "",
+		})
+		c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "ticketing": nil})
+		return
+	}
+
+	u, err := url.Parse(input.BaseURL)
+	if err != nil || u.Host == "" || u.Scheme != "https" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "base_url must be an absolute https URL"})
+		return
+	}
+	input.Email = strings.TrimSpace(input.Email)
+	switch input.Provider {
+	case models.TicketingZendesk:
+		if input.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required for Zendesk"})
+			return
+		}
+	case models.TicketingJiraSM:
+		if input.Email == "" || input.ServiceDeskID == "" || input.RequestTypeID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email, service_desk_id and request_type_id are required for Jira Service Management"})
+			return
+		}
+	}
+
+	integration := models.TicketingIntegration{
+		Provider:      input.Provider,
+		BaseURL:       strings.TrimRight(u.String(), "/"),
+		Email:         input.Email,
+		ServiceDeskID: input.ServiceDeskID,
+		RequestTypeID: input.RequestTypeID,
+		Tags:          normalizeTicketTags(input.Tags),
+		UpdatedAt:     time.Now(),
+	}
+	switch {
+	case input.APIToken != "":
+		integration.EncryptedToken, err = config.EncryptSecret(input.APIToken)
+		if err == config.ErrSecretsKeyMissing {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Credential encryption is not configured on this server"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store API token"})
+			return
+		}
+	case project.Ticketing != nil && project.Ticketing.Provider == input.Provider:
+		integration.EncryptedToken = project.Ticketing.EncryptedToken
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_token is required"})
+		return
+	}
+
+	_, err = collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"ticketing": integration, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticketing settings"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionTicketingUpdate, "project", projectID, objID, map[string]interface{}{
+		"provider":      input.Provider,
+		"base_url":      integration.BaseURL,
+		"token_changed": input.APIToken != "",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "ticketing": integration})
+}
+
+// EscalateSession - POST /admin/projects/:id/sessions/:sid/escalate hands a
+// chat session over to the project's ticketing system as a new ticket with
+// the transcript and the visitor's details. A session is escalated once;
+// repeating the call returns the existing ticket.
+func EscalateSession(c *gin.Context) {
+	projectID := c.Param("id")
+	sessionID := c.Param("sid")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Subject string   `json:"subject" binding:"max=200"`
+		Note    string   `json:"note" binding:"max=5000"`
+		Tags    []string `json:"tags" binding:"max=20"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+			return
+		}
+	}
+
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if project.Ticketing == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No ticketing integration is configured for this project"})
+		return
+	}
+
+	sessions := config.GetChatSessionsCollectionFor(objID)
+	var session models.ChatSession
+	err = sessions.FindOne(context.Background(), bson.M{"project_id": objID, "session_id": sessionID}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session"})
+		return
+	}
+	if session.Escalation != nil && session.Escalation.Status == models.EscalationCreated {
+		c.JSON(http.StatusOK, gin.H{
+			"success":           true,
+			"session_id":        sessionID,
+			"already_escalated": true,
+			"escalation":        session.Escalation,
+		})
+		return
+	}
+
+	// Claim the session so concurrent requests cannot open two tickets.
+	// MongoDB keeps milliseconds, and release matches on this timestamp.
+	now := time.Now().Truncate(time.Millisecond)
+	escalation := models.SessionEscalation{
+		Status:      models.EscalationPending,
+		Provider:    project.Ticketing.Provider,
+		EscalatedBy: c.GetString("user_id"),
+		EscalatedAt: now,
+	}
+	claim, err := sessions.UpdateOne(context.Background(), bson.M{
+		"_id": session.ID,
+		"$or": bson.A{
+			bson.M{"escalation": bson.M{"$exists": false}},
+			bson.M{"escalation.status": models.EscalationPending, "escalation.escalated_at": bson.M{"$lt": now.Add(-escalationClaimTimeout)}},
+		},
+	}, bson.M{"$set": bson.M{"escalation": escalation}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to escalate session"})
+		return
+	}
+	if claim.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This session is already being escalated"})
+		return
+	}
+	release := func() {
+		_, err := sessions.UpdateOne(context.Background(),
+			bson.M{"_id": session.ID, "escalation.status": models.EscalationPending, "escalation.escalated_at": now},
+			bson.M{"$unset": bson.M{"escalation": ""}})
+		if err != nil {
+			log.Printf("⚠️ Failed to release escalation of session %s: %v", sessionID, err)
+		}
+	}
+
+	token, err := config.DecryptSecret(project.Ticketing.EncryptedToken)
+	if err != nil {
+		release()
+		log.Printf("⚠️ Failed to decrypt ticketing token for project %s: %v", projectID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Ticketing credentials are unavailable; save the integration again"})
+		return
+	}
+	provider, err := newTicketProvider(project.Ticketing, token)
+	if err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ticket, err := buildTicketRequest(context.Background(), project, session, input.Subject, input.Note)
+	if err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load transcript"})
+		return
+	}
+	ticket.Tags = normalizeTicketTags(append(append([]string{"jevi_chat"}, project.Ticketing.Tags...), input.Tags...))
+
+	ctx, cancel := context.WithTimeout(context.Background(), ticketCreateTimeout)
+	defer cancel()
+	ref, err := provider.createTicket(ctx, ticket)
+	if err != nil {
+		release()
+		log.Printf("⚠️ %s ticket for session %s failed: %v", project.Ticketing.Provider, sessionID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create ticket", "details": err.Error()})
+		return
+	}
+
+	escalation.Status = models.EscalationCreated
+	escalation.TicketID = ref.ID
+	escalation.TicketURL = ref.URL
+	if _, err := sessions.UpdateOne(context.Background(), bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"escalation": escalation}}); err != nil {
+		// The ticket exists; say so rather than invite a second one
+		log.Printf("⚠️ Failed to record ticket %s for session %s: %v", ref.ID, sessionID, err)
+	}
+
+	recordAudit(c, models.AuditActionSessionEscalate, "session", sessionID, objID, map[string]interface{}{
+		"provider":  escalation.Provider,
+		"ticket_id": ref.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"session_id": sessionID,
+		"escalation": escalation,
+	})
+}
+
+// buildTicketRequest gathers the visitor's details and the transcript of a
+// session into a ticket
+func buildTicketRequest(ctx context.Context, project models.Project, session models.ChatSession, subject, note string) (ticketRequest, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(maxTranscriptMessages)
+	cursor, err := config.GetChatMessagesCollectionFor(project.ID).Find(ctx, bson.M{
+		"project_id": project.ID,
+		"session_id": session.SessionID,
+	}, opts)
+	if err != nil {
+		return ticketRequest{}, err
+	}
+	var messages []models.ChatMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return ticketRequest{}, err
+	}
+	total, err := config.GetChatMessagesCollectionFor(project.ID).CountDocuments(ctx, bson.M{
+		"project_id": project.ID,
+		"session_id": session.SessionID,
+	})
+	if err != nil {
+		return ticketRequest{}, err
+	}
+
+	t := ticketRequest{SessionID: session.SessionID}
+	if !session.UserID.IsZero() {
+		var user models.ChatUser
+		if err := config.GetChatUsersCollection().FindOne(ctx, bson.M{"_id": session.UserID}).Decode(&user); err == nil {
+			t.RequesterName, t.RequesterEmail = user.Name, user.Email
+		}
+	}
+	for _, m := range messages {
+		if t.RequesterEmail == "" && m.UserEmail != "" {
+			t.RequesterName, t.RequesterEmail = m.UserName, m.UserEmail
+		}
+	}
+
+	if subject == "" {
+		who := t.RequesterName
+		if who == "" {
+			who = "a visitor"
+		}
+		subject = fmt.Sprintf("Chat with %s on %s", who, project.Name)
+	}
+	t.Subject = subject
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Escalated from the %s chat widget.\n\n", project.Name)
+	if note != "" {
+		fmt.Fprintf(&b, "Note: %s\n\n", note)
+	}
+	if t.RequesterEmail != "" {
+		fmt.Fprintf(&b, "Visitor: %s <%s>\n", t.RequesterName, t.RequesterEmail)
+	} else {
+		b.WriteString("Visitor: anonymous\n")
+	}
+	fmt.Fprintf(&b, "Session: %s\n", session.SessionID)
+	fmt.Fprintf(&b, "Started: %s\n", session.StartTime.UTC().Format(time.RFC1123))
+	if session.IPAddress != "" {
+		fmt.Fprintf(&b, "IP address: %s\n", session.IPAddress)
+	}
+
+	b.WriteString("\nTranscript")
+	if total > int64(len(messages)) {
+		fmt.Fprintf(&b, " (last %d of %d messages)", len(messages), total)
+	}
+	b.WriteString(":\n")
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		at := m.Timestamp.UTC().Format("2006-01-02 15:04")
+		if m.Message != "" {
+			fmt.Fprintf(&b, "\n[%s] Visitor: %s", at, m.Message)
+		}
+		if m.Response != "" {
+			fmt.Fprintf(&b, "\n[%s] Bot: %s", at, m.Response)
+		}
+	}
+	if len(messages) == 0 {
+		b.WriteString("\n(no messages)")
+	}
+	t.Description = b.String()
+	return t, nil
+}
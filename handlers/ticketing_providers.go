@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"jevi-chat/models"
+	"jevi-chat/utils"
+)
+
+// integrationClient calls third-party APIs on a project's behalf. Customers
+// choose some of the endpoints, so only public addresses are dialled.
+var integrationClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:           utils.DialPublic,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+	// Redirects could send the credentials to another host
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// ticketRequest is a chat session ready to be filed as a ticket
+type ticketRequest struct {
+	Subject        string
+	Description    string // plain text: context and transcript
+	RequesterName  string
+	RequesterEmail string
+	SessionID      string
+	Tags           []string
+}
+
+// ticketRef identifies the ticket a provider created
+type ticketRef struct {
+	ID  string
+	URL string
+}
+
+type ticketProvider interface {
+	createTicket(ctx context.Context, t ticketRequest) (ticketRef, error)
+}
+
+// newTicketProvider returns the client for a project's integration. token
+// is the decrypted API token.
+func newTicketProvider(cfg *models.TicketingIntegration, token string) (ticketProvider, error) {
+	base := strings.TrimRight(cfg.BaseURL, "/")
+	switch cfg.Provider {
+	case models.TicketingZendesk:
+		return &zendeskProvider{baseURL: base, email: cfg.Email, token: token}, nil
+	case models.TicketingFreshdesk:
+		return &freshdeskProvider{baseURL: base, token: token}, nil
+	case models.TicketingJiraSM:
+		return &jiraSMProvider{baseURL: base, email: cfg.Email, token: token,
+			serviceDeskID: cfg.ServiceDeskID, requestTypeID: cfg.RequestTypeID}, nil
+	}
+	return nil, fmt.Errorf("unknown ticketing provider %q", cfg.Provider)
+}
+
+// postTicketJSON sends a JSON request with basic auth and decodes a 2xx
+// response into out
+func postTicketJSON(ctx context.Context, url, user, pass string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(user, pass)
+
+	resp, err := integrationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail := strings.TrimSpace(string(respBody))
+		if len(detail) > 300 {
+			detail = detail[:300]
+		}
+		return fmt.Errorf("provider returned %d: %s", resp.StatusCode, detail)
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("invalid provider response: %v", err)
+	}
+	return nil
+}
+
+// ===== ZENDESK =====
+
+type zendeskProvider struct {
+	baseURL, email, token string
+}
+
+func (p *zendeskProvider) createTicket(ctx context.Context, t ticketRequest) (ticketRef, error) {
+	ticket := map[string]interface{}{
+		"subject": t.Subject,
+		"comment": map[string]interface{}{"body": t.Description, "public": false},
+		"tags":    t.Tags,
+	}
+	if t.RequesterEmail != "" {
+		ticket["requester"] = map[string]string{"name": t.RequesterName, "email": t.RequesterEmail}
+	}
+
+	var out struct {
+		Ticket struct {
+			ID int64 `json:"id"`
+		} `json:"ticket"`
+	}
+	// API tokens authenticate as "<email>/token"
+	err := postTicketJSON(ctx, p.baseURL+"/api/v2/tickets.json", p.email+"/token", p.token,
+		map[string]interface{}{"ticket": ticket}, &out)
+	if err != nil {
+		return ticketRef{}, err
+	}
+	id := fmt.Sprint(out.Ticket.ID)
+	return ticketRef{ID: id, URL: p.baseURL + "/agent/tickets/" + id}, nil
+}
+
+// ===== FRESHDESK =====
+
+type freshdeskProvider struct {
+	baseURL, token string
+}
+
+func (p *freshdeskProvider) createTicket(ctx context.Context, t ticketRequest) (ticketRef, error) {
+	ticket := map[string]interface{}{
+		"subject":     t.Subject,
+		"description": strings.ReplaceAll(html.EscapeString(t.Description), "\n", "<br>"),
+		"status":      2, // open
+		"priority":    1, // low
+		"tags":        t.Tags,
+	}
+	// Freshdesk needs some requester; anonymous visitors are keyed by session
+	if t.RequesterEmail != "" {
+		ticket["email"] = t.RequesterEmail
+		ticket["name"] = t.RequesterName
+	} else {
+		ticket["unique_external_id"] = "jevi-session-" + t.SessionID
+		ticket["name"] = "Chat visitor"
+	}
+
+	var out struct {
+		ID int64 `json:"id"`
+	}
+	// API keys authenticate as the user with any password
+	if err := postTicketJSON(ctx, p.baseURL+"/api/v2/tickets", p.token, "X", ticket, &out); err != nil {
+		return ticketRef{}, err
+	}
+	id := fmt.Sprint(out.ID)
+	return ticketRef{ID: id, URL: p.baseURL + "/a/tickets/" + id}, nil
+}
+
+// ===== JIRA SERVICE MANAGEMENT =====
+
+type jiraSMProvider struct {
+	baseURL, email, token        string
+	serviceDeskID, requestTypeID string
+}
+
+func (p *jiraSMProvider) createTicket(ctx context.Context, t ticketRequest) (ticketRef, error) {
+	payload := map[string]interface{}{
+		"serviceDeskId": p.serviceDeskID,
+		"requestTypeId": p.requestTypeID,
+		"requestFieldValues": map[string]interface{}{
+			"summary":     t.Subject,
+			"description": t.Description,
+			"labels":      t.Tags,
+		},
+	}
+
+	var out struct {
+		IssueKey string `json:"issueKey"`
+		Links    struct {
+			Web   string `json:"web"`
+			Agent string `json:"agent"`
+		} `json:"_links"`
+	}
+	if err := postTicketJSON(ctx, p.baseURL+"/rest/servicedeskapi/request", p.email, p.token, payload, &out); err != nil {
+		return ticketRef{}, err
+	}
+	url := out.Links.Agent
+	if url == "" {
+		url = p.baseURL + "/browse/" + out.IssueKey
+	}
+	return ticketRef{ID: out.IssueKey, URL: url}, nil
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/repository"
+)
+
+// Raw usage logs are purged after three months, so longer series would
+// silently drop their oldest days
+const maxSeriesDays = 90
+
+// analyticsLocation is the timezone analytics are bucketed in: the ?tz
+// parameter (an IANA name), else the signed-in user's timezone, else UTC
+func analyticsLocation(c *gin.Context) (*time.Location, error) {
+	if tz := c.Query("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", tz)
+		}
+		return loc, nil
+	}
+	if userID, err := primitive.ObjectIDFromHex(c.GetString("user_id")); err == nil {
+		if user, err := repository.GetUser(context.Background(), userID); err == nil {
+			return user.Location(), nil
+		}
+	}
+	return time.UTC, nil
+}
+
+// seriesDays reads ?days for a daily series
+func seriesDays(c *gin.Context, fallback int) int {
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(fallback)))
+	if err != nil || days < 1 {
+		return fallback
+	}
+	if days > maxSeriesDays {
+		return maxSeriesDays
+	}
+	return days
+}
+
+// startOfDay is local midnight of t's day in loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// startOfMonth is local midnight on the first of t's month in loc
+func startOfMonth(t time.Time, loc *time.Location) time.Time {
+	y, m, _ := t.In(loc).Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+}
+
+// dailySeries counts documents per local day over the last days days,
+// bucketing on timeField inside MongoDB with the given timezone. sums adds
+// per-day totals of numeric fields (output name -> field). Days without
+// documents are returned with zeros, oldest first.
+func dailySeries(ctx context.Context, collection *mongo.Collection, match bson.M, timeField string, days int, loc *time.Location, sums map[string]string) ([]gin.H, error) {
+	since := startOfDay(time.Now(), loc).AddDate(0, 0, -(days - 1))
+
+	filter := bson.M{timeField: bson.M{"$gte": since}}
+	for k, v := range match {
+		filter[k] = v
+	}
+	group := bson.M{
+		"_id": bson.M{"$dateToString": bson.M{
+			"format":   "%Y-%m-%d",
+			"date":     "$" + timeField,
+			"timezone": loc.String(),
+		}},
+		"count": bson.M{"$sum": 1},
+	}
+	for name, field := range sums {
+		group[name] = bson.M{"$sum": "$" + field}
+	}
+
+	cursor, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": group},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []bson.M
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	byDate := make(map[string]bson.M, len(rows))
+	for _, r := range rows {
+		if date, ok := r["_id"].(string); ok {
+			byDate[date] = r
+		}
+	}
+
+	series := make([]gin.H, 0, days)
+	for i := 0; i < days; i++ {
+		date := since.AddDate(0, 0, i).Format("2006-01-02")
+		point := gin.H{"date": date, "count": 0}
+		for name := range sums {
+			point[name] = 0
+		}
+		if r, ok := byDate[date]; ok {
+			point["count"] = r["count"]
+			for name := range sums {
+				point[name] = r[name]
+			}
+		}
+		series = append(series, point)
+	}
+	return series, nil
+}
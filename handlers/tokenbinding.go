@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var (
+	errInvalidUserToken = errors.New("invalid user token")
+	errReauthRequired   = errors.New("token used from a different client; sign in again")
+	errUserTokenExpired = errors.New("user token expired")
+)
+
+func hashHex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// ipRange returns the network an address belongs to: its /24 for IPv4 and
+// its /48 for IPv6, so a visitor moving within their provider's network
+// keeps the same range
+func ipRange(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// tokenFingerprint hashes the client's IP range and user agent
+func tokenFingerprint(c *gin.Context) (ipRangeHash, userAgentHash string) {
+	return hashHex(ipRange(c.ClientIP())), hashHex(c.Request.UserAgent())
+}
+
+// chatTokenTTL is how long a widget sign-in token is valid (CHAT_TOKEN_TTL,
+// default 24h). An expired token can still be refreshed for
+// chatTokenRefreshWindow (CHAT_TOKEN_REFRESH_WINDOW, default 7 days).
+func chatTokenTTL() time.Duration {
+	return envDuration("CHAT_TOKEN_TTL", 24*time.Hour)
+}
+
+func chatTokenRefreshWindow() time.Duration {
+	return envDuration("CHAT_TOKEN_REFRESH_WINDOW", 7*24*time.Hour)
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// chatTokenClaims are the claims of a widget sign-in token. The ID is the
+// chat_user_tokens record, which is what revocation marks.
+type chatTokenClaims struct {
+	ProjectID string `json:"pid"`
+	Type      string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// issueChatUserToken creates a widget sign-in token bound to the client
+// that signed in, returning the token and its record
+func issueChatUserToken(c *gin.Context, projectID, userID primitive.ObjectID) (string, models.ChatUserToken, error) {
+	id := primitive.NewObjectID()
+	now := time.Now()
+	expiresAt := now.Add(chatTokenTTL())
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, chatTokenClaims{
+		ProjectID: projectID.Hex(),
+		Type:      "chat",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id.Hex(),
+			Subject:   userID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		return "", models.ChatUserToken{}, err
+	}
+
+	ipHash, uaHash := tokenFingerprint(c)
+	record := models.ChatUserToken{
+		ID:            id,
+		ProjectID:     projectID,
+		UserID:        userID,
+		TokenHash:     hashHex(token),
+		IPRangeHash:   ipHash,
+		UserAgentHash: uaHash,
+		CreatedAt:     now,
+		LastSeenAt:    now,
+		ExpiresAt:     expiresAt,
+	}
+	if _, err := config.GetChatUserTokensCollection().InsertOne(context.Background(), record); err != nil {
+		return "", record, err
+	}
+	return token, record, nil
+}
+
+// storedChatToken verifies a token and loads its record. Expired tokens
+// are rejected with errUserTokenExpired unless grace covers them, which
+// refresh uses. Tokens issued before they were JWTs are looked up by hash
+// and expire chatTokenTTL after they were created.
+func storedChatToken(project models.Project, token string, grace time.Duration) (models.ChatUserToken, error) {
+	var stored models.ChatUserToken
+	filter := bson.M{
+		"token_hash": hashHex(token),
+		"project_id": project.ID,
+		"revoked_at": bson.M{"$exists": false},
+	}
+
+	var expiresAt time.Time
+	if strings.Count(token, ".") == 2 {
+		var claims chatTokenClaims
+		parser := jwt.Parser{SkipClaimsValidation: true}
+		_, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+			}
+			return []byte(os.Getenv("JWT_SECRET")), nil
+		})
+		if err != nil || claims.Type != "chat" || claims.ProjectID != project.ID.Hex() || claims.ExpiresAt == nil {
+			return stored, errInvalidUserToken
+		}
+		id, err := primitive.ObjectIDFromHex(claims.ID)
+		if err != nil {
+			return stored, errInvalidUserToken
+		}
+		filter["_id"] = id
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	if err := config.GetChatUserTokensCollection().FindOne(context.Background(), filter).Decode(&stored); err != nil {
+		return stored, errInvalidUserToken
+	}
+	if expiresAt.IsZero() {
+		expiresAt = stored.CreatedAt.Add(chatTokenTTL())
+	}
+	if time.Now().After(expiresAt.Add(grace)) {
+		return stored, errUserTokenExpired
+	}
+	return stored, nil
+}
+
+// chatUserFromToken loads the chat user behind a widget token. Tokens for
+// another project's users are rejected.
+func chatUserFromToken(c *gin.Context, project models.Project, token string) (models.ChatUser, error) {
+	user, _, err := resolveChatUserToken(c, project, token, 0)
+	return user, err
+}
+
+// resolveChatUserToken checks a token and the client presenting it.
+//
+// A token used from a new IP range or a new browser follows the client, as
+// networks and browsers change. A change of both at once is reported to
+// the project's admins and, if the project asks for it, revokes the token
+// with errReauthRequired.
+func resolveChatUserToken(c *gin.Context, project models.Project, token string, grace time.Duration) (models.ChatUser, models.ChatUserToken, error) {
+	ctx := context.Background()
+	var user models.ChatUser
+
+	stored, err := storedChatToken(project, token, grace)
+	if err != nil {
+		return user, stored, err
+	}
+
+	tokens := config.GetChatUserTokensCollection()
+	now := time.Now()
+	ipHash, uaHash := tokenFingerprint(c)
+	set := bson.M{"last_seen_at": now}
+	switch {
+	case ipHash != stored.IPRangeHash && uaHash != stored.UserAgentHash:
+		reauth := project.ReauthOnTokenAnomaly
+		if reauth {
+			set["revoked_at"] = now
+		}
+		update := bson.M{"$set": set, "$inc": bson.M{"anomalies": 1}}
+		if _, err := tokens.UpdateOne(ctx, bson.M{"_id": stored.ID}, update); err != nil {
+			log.Printf("⚠️ Failed to flag token of chat user %s: %v", stored.UserID.Hex(), err)
+		}
+		go notifyTokenAnomaly(project, stored, c.ClientIP(), c.Request.UserAgent(), reauth)
+		if reauth {
+			return user, stored, errReauthRequired
+		}
+	case ipHash != stored.IPRangeHash:
+		set["ip_range_hash"] = ipHash
+		tokens.UpdateOne(ctx, bson.M{"_id": stored.ID}, bson.M{"$set": set})
+	case uaHash != stored.UserAgentHash:
+		set["user_agent_hash"] = uaHash
+		tokens.UpdateOne(ctx, bson.M{"_id": stored.ID}, bson.M{"$set": set})
+	default:
+		tokens.UpdateOne(ctx, bson.M{"_id": stored.ID}, bson.M{"$set": set})
+	}
+
+	err = config.GetChatUsersCollection().FindOne(ctx, bson.M{
+		"_id":        stored.UserID,
+		"project_id": models.ProjectIDMatch(project.ID),
+		"is_active":  true,
+	}).Decode(&user)
+	if err != nil {
+		return user, stored, errInvalidUserToken
+	}
+	return user, stored, nil
+}
+
+// notifyTokenAnomaly raises a security notification for the project about a
+// token reused from a very different client, once per token
+func notifyTokenAnomaly(project models.Project, token models.ChatUserToken, ip, userAgent string, revoked bool) {
+	message := fmt.Sprintf("A chat user's sign-in token for %s was used from a different network and browser than it was issued to (IP %s).", project.Name, ip)
+	if revoked {
+		message += " The token was revoked and the user must sign in again."
+	}
+	CreateNotification(
+		project.ID,
+		primitive.NilObjectID,
+		models.NotificationTypeSecurity,
+		"Widget sign-in used from a new client",
+		message,
+		map[string]interface{}{
+			"chat_user_id": token.UserID.Hex(),
+			"ip_address":   ip,
+			"user_agent":   userAgent,
+			"revoked":      revoked,
+			"alert_key":    "token_anomaly:" + token.ID.Hex(),
+		},
+	)
+}
+
+// respondUserTokenError writes the response for a chatUserFromToken failure
+func respondUserTokenError(c *gin.Context, err error) {
+	if err == errReauthRequired {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please sign in again", "code": "reauth_required"})
+		return
+	}
+	if err == errUserTokenExpired {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User token expired", "code": "token_expired"})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user token"})
+}
+
+// SetTokenBinding - PUT /admin/projects/:id/token-binding sets whether a
+// widget sign-in token reused from a very different client is revoked.
+// Such reuse is always reported.
+func SetTokenBinding(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		ReauthOnAnomaly *bool `json:"reauth_on_anomaly" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"reauth_on_token_anomaly": *input.ReauthOnAnomaly, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update token binding"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionTokenBinding, "project", projectID, objID, map[string]interface{}{
+		"reauth_on_anomaly": *input.ReauthOnAnomaly,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "reauth_on_anomaly": *input.ReauthOnAnomaly})
+}
+
+// RefreshChatUserToken - POST /embed/:projectId/auth/refresh exchanges a
+// widget sign-in token for a new one. Tokens up to the refresh window past
+// their expiry are accepted; the old token is revoked, so each token can
+// be refreshed once.
+func RefreshChatUserToken(c *gin.Context) {
+	projectID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid project ID"})
+		return
+	}
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Token is required"})
+		return
+	}
+
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": projectID, "is_active": true}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "Project not found"})
+		return
+	}
+
+	user, old, err := resolveChatUserToken(c, project, req.Token, chatTokenRefreshWindow())
+	if err != nil {
+		respondUserTokenError(c, err)
+		return
+	}
+	if isBlocked(projectID, user.ID, user.Email, c.ClientIP()) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "status": "blocked", "message": blockedReply})
+		return
+	}
+
+	token, issued, err := issueChatUserToken(c, projectID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Failed to refresh token"})
+		return
+	}
+
+	// Only one refresh of a token can win; a racing second one revokes the
+	// token it just issued
+	res, err := config.GetChatUserTokensCollection().UpdateOne(context.Background(),
+		bson.M{"_id": old.ID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now(), "replaced_by": issued.ID}})
+	if err != nil || res.ModifiedCount == 0 {
+		config.GetChatUserTokensCollection().UpdateOne(context.Background(), bson.M{"_id": issued.ID},
+			bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid user token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"user": gin.H{
+			"id":    user.ID.Hex(),
+			"name":  user.Name,
+			"email": user.Email,
+		},
+		"token":      token,
+		"expires_at": issued.ExpiresAt,
+	})
+}
+
+// RevokeChatUserToken - POST /embed/:projectId/auth/logout revokes the
+// widget sign-in token it is given
+func RevokeChatUserToken(c *gin.Context) {
+	projectID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid project ID"})
+		return
+	}
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Token is required"})
+		return
+	}
+
+	stored, err := storedChatToken(models.Project{ID: projectID}, req.Token, chatTokenRefreshWindow())
+	if err == nil {
+		config.GetChatUserTokensCollection().UpdateOne(context.Background(), bson.M{"_id": stored.ID},
+			bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	}
+	// Signing out with a token that is already invalid is not an error
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// RevokeChatUserTokens - DELETE /admin/projects/:id/chat-users/:userId/tokens
+// signs a chat user out everywhere, for a leaked or stolen token
+func RevokeChatUserTokens(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	res, err := config.GetChatUserTokensCollection().UpdateMany(context.Background(), bson.M{
+		"project_id": objID,
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionTokenRevoke, "chat_user", userID.Hex(), objID, map[string]interface{}{
+		"revoked": res.ModifiedCount,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "revoked": res.ModifiedCount})
+}
@@ -0,0 +1,504 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	maxTranscriptFileSize = 50 * 1024 * 1024
+	maxTranscriptRows     = 200000
+)
+
+// Column names accepted for each transcript field, lowercased
+var transcriptColumns = map[string][]string{
+	"session_id": {"session_id", "conversation_id", "chat_id", "thread_id", "ticket_id"},
+	"role":       {"role", "sender", "sender_type", "author", "author_type", "from", "speaker"},
+	"text":       {"message", "text", "content", "body"},
+	"timestamp":  {"timestamp", "created_at", "sent_at", "time", "date"},
+	"user_name":  {"user_name", "visitor_name", "customer_name", "name"},
+	"user_email": {"user_email", "visitor_email", "customer_email", "email"},
+}
+
+// Roles of the visitor's side of a conversation; every other accepted role
+// is the bot's or an agent's
+var (
+	transcriptUserRoles  = []string{"user", "visitor", "customer", "contact", "client", "end_user", "enduser", "human", "lead"}
+	transcriptReplyRoles = []string{"bot", "assistant", "ai", "model", "agent", "admin", "operator", "teammate", "support", "system"}
+)
+
+var transcriptTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+}
+
+// transcriptRow is one message as uploaded, before validation
+type transcriptRow map[string]string
+
+func (r transcriptRow) get(field string) string {
+	for _, col := range transcriptColumns[field] {
+		if v, ok := r[col]; ok && v != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// transcriptLine is one validated message of a conversation
+type transcriptLine struct {
+	sessionID string
+	fromUser  bool
+	text      string
+	at        time.Time
+	userName  string
+	userEmail string
+}
+
+// parseTranscriptCSV reads one message per row, with a header row
+func parseTranscriptCSV(r io.Reader) ([]transcriptRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header row: %v", err)
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	}
+
+	var rows []transcriptRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := transcriptRow{}
+		for i, v := range record {
+			if i < len(header) {
+				row[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+		if len(rows) > maxTranscriptRows {
+			return nil, fmt.Errorf("imports are limited to %d messages", maxTranscriptRows)
+		}
+	}
+	return rows, nil
+}
+
+// parseTranscriptJSON reads either a flat array of messages, laid out like
+// the CSV rows, or an array of conversations each with a "messages" array.
+// Either array may be wrapped in an object under "messages" or
+// "conversations". A conversation's own fields, such as its ID and the
+// visitor's email, apply to each of its messages.
+func parseTranscriptJSON(r io.Reader) ([]transcriptRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		var wrapped struct {
+			Conversations []map[string]interface{} `json:"conversations"`
+			Messages      []map[string]interface{} `json:"messages"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("expected an array of messages or conversations: %v", err)
+		}
+		items = append(wrapped.Conversations, wrapped.Messages...)
+	}
+
+	var rows []transcriptRow
+	for _, item := range items {
+		messages, isConversation := item["messages"].([]interface{})
+		if !isConversation {
+			rows = append(rows, transcriptFields(item))
+		} else {
+			base := transcriptFields(item)
+			if base.get("session_id") == "" && base["id"] != "" {
+				base["session_id"] = base["id"]
+			}
+			delete(base, "id")
+			for _, m := range messages {
+				m, ok := m.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("conversation %s has a message that is not an object", base.get("session_id"))
+				}
+				row := transcriptRow{}
+				for k, v := range base {
+					row[k] = v
+				}
+				for k, v := range transcriptFields(m) {
+					row[k] = v
+				}
+				rows = append(rows, row)
+			}
+		}
+		if len(rows) > maxTranscriptRows {
+			return nil, fmt.Errorf("imports are limited to %d messages", maxTranscriptRows)
+		}
+	}
+	return rows, nil
+}
+
+// transcriptFields keeps the scalar fields of a JSON object as strings
+func transcriptFields(item map[string]interface{}) transcriptRow {
+	row := transcriptRow{}
+	for k, v := range item {
+		switch v := v.(type) {
+		case string:
+			row[strings.ToLower(k)] = v
+		case float64:
+			row[strings.ToLower(k)] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			row[strings.ToLower(k)] = strconv.FormatBool(v)
+		}
+	}
+	return row
+}
+
+// parseTranscriptTime reads the timestamp formats chat vendors export:
+// RFC 3339, common date-time layouts (taken as UTC), and Unix seconds or
+// milliseconds
+func parseTranscriptTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	for _, layout := range transcriptTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// transcriptLineFromRow validates one row. Rows without a conversation ID,
+// a known role, text or a timestamp are rejected with the reason.
+func transcriptLineFromRow(row transcriptRow, now time.Time) (transcriptLine, error) {
+	line := transcriptLine{
+		sessionID: row.get("session_id"),
+		text:      row.get("text"),
+		userName:  row.get("user_name"),
+		userEmail: strings.ToLower(row.get("user_email")),
+	}
+	if line.sessionID == "" {
+		return line, fmt.Errorf("session_id is required")
+	}
+	if len(line.sessionID) > 200 {
+		return line, fmt.Errorf("session_id is longer than 200 characters")
+	}
+	if line.text == "" {
+		return line, fmt.Errorf("message text is required")
+	}
+
+	role := strings.ToLower(strings.ReplaceAll(row.get("role"), " ", "_"))
+	switch {
+	case containsString(transcriptUserRoles, role):
+		line.fromUser = true
+	case containsString(transcriptReplyRoles, role):
+	default:
+		return line, fmt.Errorf("unknown role %q", row.get("role"))
+	}
+
+	at, err := parseTranscriptTime(row.get("timestamp"))
+	if err != nil {
+		return line, err
+	}
+	if at.After(now.Add(time.Hour)) {
+		return line, fmt.Errorf("timestamp %s is in the future", at.Format(time.RFC3339))
+	}
+	line.at = at
+	return line, nil
+}
+
+// importedSessionID is the session ID a conversation from another vendor
+// is stored under. It is derived from the source and the vendor's ID, so
+// importing the same conversation again finds the session already there.
+func importedSessionID(source, externalID string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + externalID))
+	return "imp_" + hex.EncodeToString(sum[:16])
+}
+
+// buildTranscripts groups messages into sessions, in time order, and pairs
+// each visitor message with the replies that followed it, the way live
+// exchanges are stored. Consecutive visitor messages are joined, as are
+// consecutive replies; replies before the visitor's first message, such as
+// a greeting, are kept as an exchange without a message.
+func buildTranscripts(source string, lines []transcriptLine) ([]models.ChatSession, map[string][]models.ChatMessage) {
+	byConversation := map[string][]transcriptLine{}
+	var order []string
+	for _, l := range lines {
+		if _, ok := byConversation[l.sessionID]; !ok {
+			order = append(order, l.sessionID)
+		}
+		byConversation[l.sessionID] = append(byConversation[l.sessionID], l)
+	}
+
+	sessions := make([]models.ChatSession, 0, len(order))
+	messages := make(map[string][]models.ChatMessage, len(order))
+	for _, externalID := range order {
+		conv := byConversation[externalID]
+		sort.SliceStable(conv, func(i, j int) bool { return conv[i].at.Before(conv[j].at) })
+		sessionID := importedSessionID(source, externalID)
+
+		var exchanges []models.ChatMessage
+		var userName, userEmail string
+		for _, l := range conv {
+			if l.fromUser {
+				userName, userEmail = firstNonEmpty(userName, l.userName), firstNonEmpty(userEmail, l.userEmail)
+			}
+			last := len(exchanges) - 1
+			switch {
+			case l.fromUser && last >= 0 && exchanges[last].Response == "" && exchanges[last].Message != "":
+				exchanges[last].Message += "\n" + l.text
+			case l.fromUser || last < 0:
+				exchanges = append(exchanges, models.ChatMessage{SessionID: sessionID, Timestamp: l.at})
+				if l.fromUser {
+					exchanges[last+1].Message = l.text
+				} else {
+					exchanges[last+1].Response = l.text
+				}
+			case exchanges[last].Response == "":
+				exchanges[last].Response = l.text
+			default:
+				exchanges[last].Response += "\n\n" + l.text
+			}
+		}
+		for i := range exchanges {
+			exchanges[i].UserName, exchanges[i].UserEmail = userName, userEmail
+		}
+
+		sessions = append(sessions, models.ChatSession{
+			SessionID:         sessionID,
+			ExternalSessionID: externalID,
+			StartTime:         conv[0].at,
+			EndTime:           conv[len(conv)-1].at,
+			LastSeenAt:        conv[len(conv)-1].at,
+		})
+		messages[sessionID] = exchanges
+	}
+	return sessions, messages
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ImportTranscripts - POST /admin/projects/:id/transcripts/import uploads
+// historical conversations from another chat vendor as a CSV or JSON
+// "transcripts" file, one message per row with its conversation ID, role,
+// text and timestamp. "source" names the vendor. The conversations become
+// sessions and messages tagged with the import's batch ID, so they show up
+// in analytics and search alongside live traffic.
+func ImportTranscripts(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	if n, err := config.GetProjectsCollection().CountDocuments(context.Background(), bson.M{"_id": objID}); err != nil || n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	source := strings.ToLower(strings.TrimSpace(c.DefaultPostForm("source", "import")))
+	if source == "" || len(source) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be between 1 and 50 characters"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("transcripts")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No transcripts file uploaded"})
+		return
+	}
+	if fileHeader.Size > maxTranscriptFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Transcript files are limited to 50MB"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read transcripts file"})
+		return
+	}
+	defer file.Close()
+
+	var rows []transcriptRow
+	switch strings.ToLower(filepath.Ext(fileHeader.Filename)) {
+	case ".csv":
+		rows, err = parseTranscriptCSV(file)
+	case ".json":
+		rows, err = parseTranscriptJSON(file)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transcripts must be a .csv or .json file"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transcripts file", "details": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	lines := make([]transcriptLine, 0, len(rows))
+	var rejected []gin.H
+	for i, row := range rows {
+		line, err := transcriptLineFromRow(row, now)
+		if err != nil {
+			if len(rejected) < 100 {
+				rejected = append(rejected, gin.H{"row": i + 1, "session_id": line.sessionID, "error": err.Error()})
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid messages in the transcripts", "rejected": rejected})
+		return
+	}
+
+	sessions, messages := buildTranscripts(source, lines)
+	batch := &models.TranscriptImport{
+		ProjectID:    objID,
+		Source:       source,
+		FileName:     fileHeader.Filename,
+		RejectedRows: len(rows) - len(lines),
+		ImportedBy:   c.GetString("user_id"),
+		CreatedAt:    now,
+	}
+	for _, s := range sessions {
+		if batch.FirstMessageAt.IsZero() || s.StartTime.Before(batch.FirstMessageAt) {
+			batch.FirstMessageAt = s.StartTime
+		}
+		if s.EndTime.After(batch.LastMessageAt) {
+			batch.LastMessageAt = s.EndTime
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := repository.ImportTranscripts(ctx, batch, sessions, messages); err != nil {
+		log.Printf("⚠️ Transcript import failed for project %s: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import transcripts", "import": batch})
+		return
+	}
+
+	recordAudit(c, models.AuditActionTranscriptImport, "project", projectID, objID, map[string]interface{}{
+		"import_id": batch.ID.Hex(),
+		"source":    source,
+		"file":      fileHeader.Filename,
+		"sessions":  batch.Sessions,
+		"messages":  batch.Messages,
+		"skipped":   batch.SkippedSessions,
+		"rejected":  batch.RejectedRows,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"import":   batch,
+		"rejected": rejected,
+	})
+}
+
+// ListTranscriptImports - GET /admin/projects/:id/transcripts/imports lists
+// the project's transcript imports, newest first
+func ListTranscriptImports(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	ctx := context.Background()
+	cursor, err := config.GetTranscriptImportsCollection().Find(ctx, bson.M{"project_id": objID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load imports"})
+		return
+	}
+	imports := []models.TranscriptImport{}
+	if err := cursor.All(ctx, &imports); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load imports"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"imports": imports})
+}
+
+// DeleteTranscriptImport - DELETE /admin/projects/:id/transcripts/imports/:importId
+// removes an import with every session and message it brought in, to undo
+// an upload that went wrong
+func DeleteTranscriptImport(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	importID, err := primitive.ObjectIDFromHex(c.Param("importId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return
+	}
+
+	if config.IsProjectOnLegalHold(context.Background(), objID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Project is under legal hold and its data cannot be deleted"})
+		return
+	}
+
+	deleted, err := repository.DeleteTranscriptImport(context.Background(), objID, importID)
+	if err == repository.ErrTranscriptImportNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to delete transcript import %s of project %s: %v", importID.Hex(), projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete import"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionTranscriptDelete, "project", projectID, objID, map[string]interface{}{
+		"import_id": importID.Hex(),
+		"sessions":  deleted.Sessions,
+		"messages":  deleted.Messages,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "deleted": deleted})
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+const (
+	// maxSourceBytes is the largest document accepted, alone or in an archive
+	maxSourceBytes = 10 * 1024 * 1024
+
+	// maxArchiveFiles caps the entries of one archive
+	maxArchiveFiles = 200
+
+	// uploadBatchStaleAfter is how long a running batch may go without a
+	// heartbeat before another worker takes it over
+	uploadBatchStaleAfter = 15 * time.Minute
+)
+
+// archiveLimit is the largest ZIP archive accepted, and also the most its
+// files may add up to once expanded (ZIP_UPLOAD_MAX_MB, default 100)
+func archiveLimit() int64 {
+	mb, err := strconv.Atoi(os.Getenv("ZIP_UPLOAD_MAX_MB"))
+	if err != nil || mb <= 0 {
+		mb = 100
+	}
+	return int64(mb) * 1024 * 1024
+}
+
+// UploadArchive - POST /admin/projects/:id/uploads accepts a ZIP archive of
+// documents in the "archive" form field. Each file is checked for type and size
+// and queued for processing; the response is the batch, whose progress is
+// at GET /admin/projects/:id/uploads/:batchId. Folders, hidden files and
+// macOS metadata in the archive are ignored.
+func UploadArchive(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	header, err := c.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No archive uploaded"})
+		return
+	}
+	if !strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Archive must be a .zip file"})
+		return
+	}
+	limit := archiveLimit()
+	if header.Size > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Archive exceeds %d MB", limit/1024/1024)})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read archive"})
+		return
+	}
+	defer file.Close()
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Archive is not a valid ZIP file"})
+		return
+	}
+	if len(archive.File) > maxArchiveFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Archive holds more than %d files", maxArchiveFiles)})
+		return
+	}
+
+	batch := models.UploadBatch{
+		ID:          primitive.NewObjectID(),
+		ProjectID:   objID,
+		ArchiveName: path.Base(header.Filename),
+		Status:      "pending",
+		Files:       []models.UploadBatchFile{},
+		UploadedBy:  c.GetString("user_id"),
+		CreatedAt:   time.Now(),
+	}
+	var expanded int64
+	queued := 0
+	seen := map[string]*models.DocumentDuplicate{}
+	for _, entry := range archive.File {
+		name := path.Base(entry.Name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		f := models.UploadBatchFile{Name: name, Size: int64(entry.UncompressedSize64)}
+		fileType := sourceFileType(name)
+		switch {
+		case fileType == "":
+			f.Status, f.Error = models.UploadFileRejected, unsupportedTypeMessage
+		case entry.UncompressedSize64 > maxSourceBytes:
+			f.Status, f.Error = models.UploadFileRejected, "file exceeds 10 MB"
+		case expanded+int64(entry.UncompressedSize64) > limit:
+			f.Status, f.Error = models.UploadFileRejected, "archive is too large once expanded"
+		default:
+			data, err := readArchiveFile(entry, fileType)
+			if err != nil {
+				f.Status, f.Error = models.UploadFileRejected, err.Error()
+				break
+			}
+			f.Hash = contentHash(data)
+			dup, ok := seen[f.Hash]
+			if !ok {
+				if dup, err = resolveDuplicate(context.Background(), project, f.Hash); err != nil {
+					log.Printf("⚠️ Duplicate check failed for %s: %v", name, err)
+				}
+			}
+			if dup != nil {
+				f.Status, f.DuplicateOf = models.UploadFileDuplicate, dup
+				if dup.Linked {
+					recordAudit(c, models.AuditActionLibraryAttach, "project", projectID, objID, map[string]interface{}{
+						"document_id": dup.ID,
+						"enabled":     true,
+						"reason":      "duplicate upload",
+					})
+					seen[f.Hash] = &models.DocumentDuplicate{Source: dup.Source, ID: dup.ID, FileName: dup.FileName}
+				}
+				break
+			}
+
+			f.FileID = primitive.NewObjectID().Hex()
+			f.StorageKey = sourceStorageKey(objID, f.FileID, name)
+			if err := storeSourceFile(context.Background(), f.StorageKey, bytes.NewReader(data), int64(len(data))); err != nil {
+				log.Printf("⚠️ Failed to store %s: %v", name, err)
+				f.Status, f.Error, f.FileID, f.StorageKey = models.UploadFileRejected, "file could not be saved", "", ""
+				break
+			}
+			seen[f.Hash] = &models.DocumentDuplicate{Source: "file", ID: f.FileID, FileName: name}
+			expanded += int64(len(data))
+			f.Status = models.UploadFileQueued
+			queued++
+		}
+		batch.Files = append(batch.Files, f)
+	}
+	if queued == 0 {
+		batch.Status = "completed"
+		batch.CompletedAt = time.Now()
+	}
+
+	if _, err := config.GetUploadBatchesCollection().InsertOne(context.Background(), batch); err != nil {
+		for _, f := range batch.Files {
+			removeSourceFile(context.Background(), f.StorageKey, f.FilePath)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue upload"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionUploadBatch, "upload_batch", batch.ID.Hex(), objID, map[string]interface{}{
+		"archive": batch.ArchiveName,
+		"files":   len(batch.Files),
+		"queued":  queued,
+		"skipped": len(batch.Files) - queued,
+	})
+
+	if queued > 0 {
+		go ProcessUploadBatches()
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "batch_id": batch.ID.Hex(), "batch": batch})
+}
+
+// readArchiveFile reads one archive entry and checks it is of the type its
+// name says. The size in the archive header is not trusted: reading stops
+// past the limit.
+func readArchiveFile(entry *zip.File, fileType string) ([]byte, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, errors.New("file could not be read from the archive")
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxSourceBytes+1))
+	if err != nil {
+		return nil, errors.New("file could not be read from the archive")
+	}
+	if len(data) > maxSourceBytes {
+		return nil, errors.New("file exceeds 10 MB")
+	}
+	if err := checkSourceContent(fileType, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetUploadBatch - GET /admin/projects/:id/uploads/:batchId status of an
+// archive upload and of each of its files
+func GetUploadBatch(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	batchID, err := primitive.ObjectIDFromHex(c.Param("batchId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch ID"})
+		return
+	}
+
+	batch, err := repository.GetUploadBatch(context.Background(), objID, batchID)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload batch not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upload batch"})
+		return
+	}
+
+	counts := map[string]int{}
+	for _, f := range batch.Files {
+		counts[f.Status]++
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "batch": batch, "counts": counts})
+}
+
+var processingUploadBatches atomic.Bool
+
+// ProcessUploadBatches works through queued archive uploads until none are
+// left. Batches are claimed one at a time, so any number of replicas can
+// run this side by side; within a replica only one loop runs.
+func ProcessUploadBatches() {
+	if !processingUploadBatches.CompareAndSwap(false, true) {
+		return
+	}
+	defer processingUploadBatches.Store(false)
+
+	for {
+		batch, err := repository.ClaimUploadBatch(context.Background(), uploadBatchStaleAfter)
+		if err != nil {
+			log.Printf("⚠️ Failed to claim upload batch: %v", err)
+			return
+		}
+		if batch == nil {
+			return
+		}
+		processUploadBatch(batch)
+	}
+}
+
+// processUploadBatch processes the batch's queued files in order. Files
+// left processing by a worker that went away are processed again. The
+// project is reloaded for each file, so a copy of a document that was
+// added since the upload, by this batch or another, is still skipped.
+func processUploadBatch(batch *models.UploadBatch) {
+	ctx := context.Background()
+	for i, f := range batch.Files {
+		if f.Status != models.UploadFileQueued && f.Status != models.UploadFileProcessing {
+			continue
+		}
+		var project models.Project
+		if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": batch.ProjectID}).Decode(&project); err != nil {
+			repository.SetUploadFileStatus(ctx, batch.ID, i, models.UploadFileFailed, "project not found")
+			removeSourceFile(ctx, f.StorageKey, f.FilePath)
+			continue
+		}
+
+		if f.Hash != "" {
+			dup, err := resolveDuplicate(ctx, project, f.Hash)
+			if err != nil {
+				log.Printf("⚠️ Duplicate check failed for %s: %v", f.Name, err)
+			}
+			if dup != nil && dup.ID != f.FileID {
+				if dup.Linked {
+					recordJobAudit(models.AuditActionLibraryAttach, batch.UploadedBy, "project", project.ID.Hex(), map[string]interface{}{
+						"document_id": dup.ID,
+						"enabled":     true,
+						"reason":      "duplicate upload",
+					})
+				}
+				removeSourceFile(ctx, f.StorageKey, f.FilePath)
+				if err := repository.MarkUploadFileDuplicate(ctx, batch.ID, i, dup); err != nil {
+					log.Printf("⚠️ Failed to record status of %s in upload batch %s: %v", f.Name, batch.ID.Hex(), err)
+				}
+				continue
+			}
+		}
+		repository.SetUploadFileStatus(ctx, batch.ID, i, models.UploadFileProcessing, "")
+
+		status, errMsg := processBatchFile(project, batch, f)
+		if err := repository.SetUploadFileStatus(ctx, batch.ID, i, status, errMsg); err != nil {
+			log.Printf("⚠️ Failed to record status of %s in upload batch %s: %v", f.Name, batch.ID.Hex(), err)
+		}
+	}
+
+	if err := repository.CompleteUploadBatch(ctx, batch.ID); err != nil {
+		log.Printf("⚠️ Failed to complete upload batch %s: %v", batch.ID.Hex(), err)
+	}
+}
+
+// processBatchFile extracts one document of a batch, adds it to the project and
+// embeds it, returning the file's status and error
+func processBatchFile(project models.Project, batch *models.UploadBatch, f models.UploadBatchFile) (string, string) {
+	sourceFile := models.SourceFile{
+		ID:          f.FileID,
+		FileName:    f.Name,
+		FilePath:    f.FilePath,
+		StorageKey:  f.StorageKey,
+		FileSize:    f.Size,
+		Type:        sourceFileType(f.Name),
+		UploadedAt:  batch.CreatedAt,
+		Status:      "processing",
+		ContentHash: f.Hash,
+	}
+	content, paged := extractSourceFile(project, &sourceFile)
+
+	if err := repository.AddProjectFile(context.Background(), project.ID, sourceFile, content); err != nil {
+		if !errors.Is(err, repository.ErrProjectNotFound) {
+			log.Printf("⚠️ Failed to add %s to project %s: %v", f.Name, project.ID.Hex(), err)
+		}
+		removeSourceFile(context.Background(), f.StorageKey, f.FilePath)
+		return models.UploadFileFailed, "file could not be added to the project"
+	}
+	if sourceFile.Status == "failed" {
+		return models.UploadFileFailed, "document could not be processed"
+	}
+
+	if paged {
+		ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+		_, err := embedProjectFile(ctx, project, sourceFile.ID)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️ Failed to embed file %s: %v", sourceFile.ID, err)
+		}
+	}
+	return models.UploadFileCompleted, ""
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+)
+
+// userDeletionStaleAfter is how long a running deletion may go without a
+// heartbeat before another worker takes it over
+const userDeletionStaleAfter = 15 * time.Minute
+
+var processingUserDeletions atomic.Bool
+
+// ProcessUserDeletions works through queued user deletions until none are
+// left. Deletions are claimed one at a time, so any number of replicas can
+// run this side by side; within a replica only one loop runs.
+func ProcessUserDeletions() {
+	if !processingUserDeletions.CompareAndSwap(false, true) {
+		return
+	}
+	defer processingUserDeletions.Store(false)
+
+	for {
+		job, err := repository.ClaimUserDeletion(context.Background(), userDeletionStaleAfter)
+		if err != nil {
+			log.Printf("⚠️ Failed to claim user deletion: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		err = repository.RunUserDeletion(ctx, job)
+		cancel()
+
+		details := map[string]interface{}{
+			"deletion_id": job.ID.Hex(),
+			"email":       job.Email,
+			"policy":      job.Policy,
+		}
+		if err != nil {
+			log.Printf("⚠️ Deletion of user %s failed: %v", job.UserID.Hex(), err)
+			repository.FailUserDeletion(context.Background(), job.ID, err)
+			details["error"] = err.Error()
+		} else {
+			log.Printf("🗑️ Deleted user %s (%s policy)", job.UserID.Hex(), job.Policy)
+		}
+		if done, err := repository.GetUserDeletion(context.Background(), job.ID); err == nil {
+			details["status"] = done.Status
+			details["projects"] = done.ProjectsDone
+			details["counts"] = done.Counts
+		}
+		recordJobAudit(models.AuditActionUserDeleted, job.RequestedBy, "user", job.UserID.Hex(), details)
+	}
+}
+
+// GetUserDeletion - GET /admin/user-deletions/:id progress of a user deletion
+func GetUserDeletion(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deletion ID"})
+		return
+	}
+
+	job, err := repository.GetUserDeletion(context.Background(), id)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deletion not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deletion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "deletion": job})
+}
+
+// SetUserDeletionPolicy - PUT /admin/users/:id/deletion-policy choose what
+// happens to the user's projects when the account is deleted
+func SetUserDeletionPolicy(c *gin.Context) {
+	userID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input struct {
+		Policy string `json:"policy"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if input.Policy != "" && !models.ValidDeletionPolicy(input.Policy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Policy must be retain, anonymize or cascade"})
+		return
+	}
+
+	err = repository.SetDeletionPolicy(context.Background(), objID, input.Policy)
+	if err == repository.ErrUserNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update deletion policy"})
+		return
+	}
+
+	effective := input.Policy
+	if effective == "" {
+		effective = repository.DefaultDeletionPolicy()
+	}
+	recordAudit(c, models.AuditActionDeletionPolicy, "user", userID, primitive.NilObjectID, map[string]interface{}{
+		"policy": input.Policy,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"user_id": userID,
+		"policy":  effective,
+	})
+}
@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// warehouseSettle is how long after a UTC day ends before it is exported,
+// so messages still being written at midnight are counted
+const warehouseSettle = time.Hour
+
+// warehouseExport is a table of daily aggregates exported to the warehouse.
+// rows returns one row per project with activity on the day.
+type warehouseExport struct {
+	table config.WarehouseTable
+	rows  func(ctx context.Context, day time.Time, names map[primitive.ObjectID]string) ([]map[string]interface{}, error)
+}
+
+var warehouseExports = []warehouseExport{
+	{
+		table: config.WarehouseTable{Name: "daily_messages", Version: 1, Columns: []config.WarehouseColumn{
+			{Name: "date", Type: "DATE"},
+			{Name: "project_id", Type: "STRING"},
+			{Name: "project_name", Type: "STRING"},
+			{Name: "messages", Type: "INTEGER"},
+			{Name: "sessions", Type: "INTEGER"},
+			{Name: "signed_in_users", Type: "INTEGER"},
+			{Name: "fallbacks", Type: "INTEGER"},
+			{Name: "clarifying", Type: "INTEGER"},
+			{Name: "moderated", Type: "INTEGER"},
+			{Name: "rated", Type: "INTEGER"},
+			{Name: "avg_rating", Type: "FLOAT"},
+		}},
+		rows: dailyMessageRows,
+	},
+	{
+		table: config.WarehouseTable{Name: "daily_sessions", Version: 1, Columns: []config.WarehouseColumn{
+			{Name: "date", Type: "DATE"},
+			{Name: "project_id", Type: "STRING"},
+			{Name: "project_name", Type: "STRING"},
+			{Name: "sessions_started", Type: "INTEGER"},
+			{Name: "signed_in_sessions", Type: "INTEGER"},
+			{Name: "escalated", Type: "INTEGER"},
+			{Name: "handoffs_requested", Type: "INTEGER"},
+			{Name: "sessions_closed", Type: "INTEGER"},
+		}},
+		rows: dailySessionRows,
+	},
+	{
+		table: config.WarehouseTable{Name: "daily_usage", Version: 1, Columns: []config.WarehouseColumn{
+			{Name: "date", Type: "DATE"},
+			{Name: "project_id", Type: "STRING"},
+			{Name: "project_name", Type: "STRING"},
+			{Name: "requests", Type: "INTEGER"},
+			{Name: "success_count", Type: "INTEGER"},
+			{Name: "failed_count", Type: "INTEGER"},
+			{Name: "overage_requests", Type: "INTEGER"},
+			{Name: "tokens_used", Type: "INTEGER"},
+			{Name: "input_tokens", Type: "INTEGER"},
+			{Name: "output_tokens", Type: "INTEGER"},
+			{Name: "estimated_cost", Type: "FLOAT"},
+			{Name: "avg_response_time_ms", Type: "FLOAT"},
+		}},
+		rows: dailyUsageRows,
+	},
+}
+
+// warehouseWatermark is the last day of a table that was exported in full
+type warehouseWatermark struct {
+	ID        string    `bson:"_id" json:"table"`
+	LastDate  string    `bson:"last_date" json:"last_date"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+func warehouseWatermarkID(table string) string {
+	return config.WarehouseSettings.Driver + ":" + table
+}
+
+// ExportWarehouseTables exports every finished UTC day since each table's
+// watermark to the warehouse configured with WAREHOUSE_DRIVER. A table
+// without a watermark starts WAREHOUSE_BACKFILL_DAYS back. Days are
+// written whole, replacing what an interrupted run left, and the watermark
+// only advances past a day once it is written.
+func ExportWarehouseTables() error {
+	if config.WarehouseSettings == nil || config.WarehouseSettings.Driver == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	names, err := projectNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The last day that has ended and settled
+	lastDay := startOfDay(time.Now().Add(-warehouseSettle), time.UTC).AddDate(0, 0, -1)
+	watermarks := config.GetCollection("warehouse_watermarks")
+
+	for _, export := range warehouseExports {
+		if err := config.EnsureWarehouseTable(ctx, export.table); err != nil {
+			return fmt.Errorf("%s: schema update failed: %v", export.table.Name, err)
+		}
+
+		var mark warehouseWatermark
+		err := watermarks.FindOne(ctx, bson.M{"_id": warehouseWatermarkID(export.table.Name)}).Decode(&mark)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		day := lastDay.AddDate(0, 0, 1-config.WarehouseSettings.BackfillDays)
+		if mark.LastDate != "" {
+			last, err := time.Parse("2006-01-02", mark.LastDate)
+			if err != nil {
+				return fmt.Errorf("%s: invalid watermark %q", export.table.Name, mark.LastDate)
+			}
+			day = last.AddDate(0, 0, 1)
+		}
+
+		exported := 0
+		for ; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+			date := day.Format("2006-01-02")
+			rows, err := export.rows(ctx, day, names)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", export.table.Name, date, err)
+			}
+			for _, row := range rows {
+				row["date"] = date
+			}
+			if err := config.WriteWarehousePartition(ctx, export.table, date, rows); err != nil {
+				return fmt.Errorf("%s %s: %v", export.table.Name, date, err)
+			}
+			if _, err := watermarks.UpdateOne(ctx,
+				bson.M{"_id": warehouseWatermarkID(export.table.Name)},
+				bson.M{"$set": bson.M{"last_date": date, "updated_at": time.Now()}},
+				options.Update().SetUpsert(true)); err != nil {
+				return err
+			}
+			exported++
+		}
+		if exported > 0 {
+			log.Printf("🏬 Exported %d day(s) of %s to %s", exported, export.table.Name, config.WarehouseSettings.Driver)
+		}
+	}
+	return nil
+}
+
+// projectNames maps every project's ID to its name
+func projectNames(ctx context.Context) (map[primitive.ObjectID]string, error) {
+	cursor, err := config.GetProjectsCollection().Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var projects []struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Name string             `bson:"name"`
+	}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, err
+	}
+	names := make(map[primitive.ObjectID]string, len(projects))
+	for _, p := range projects {
+		names[p.ID] = p.Name
+	}
+	return names, nil
+}
+
+// aggregateTenants runs a pipeline over a collection in every tenant
+// database and decodes the results into rows, adding the project's name
+func aggregateTenants(ctx context.Context, collection string, pipeline bson.A, names map[primitive.ObjectID]string) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	for _, col := range config.AllTenantCollections(collection) {
+		cursor, err := col.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		var results []bson.M
+		if err := cursor.All(ctx, &results); err != nil {
+			return nil, err
+		}
+		for _, r := range results {
+			projectID, _ := r["_id"].(primitive.ObjectID)
+			delete(r, "_id")
+			r["project_id"] = projectID.Hex()
+			r["project_name"] = names[projectID]
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
+
+func dayRange(day time.Time) bson.M {
+	return bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}
+}
+
+func countIf(cond interface{}) bson.M {
+	return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+}
+
+func dailyMessageRows(ctx context.Context, day time.Time, names map[primitive.ObjectID]string) ([]map[string]interface{}, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"timestamp": dayRange(day), "dry_run": bson.M{"$ne": true}}},
+		bson.M{"$group": bson.M{
+			"_id":        "$project_id",
+			"messages":   bson.M{"$sum": 1},
+			"sessions":   bson.M{"$addToSet": "$session_id"},
+			"users":      bson.M{"$addToSet": "$user_id"},
+			"fallbacks":  countIf(bson.M{"$gt": bson.A{"$fallback", ""}}),
+			"clarifying": countIf(bson.M{"$eq": bson.A{"$clarifying", true}}),
+			"moderated":  countIf(bson.M{"$gt": bson.A{"$moderation_action", ""}}),
+			"rated":      countIf(bson.M{"$gt": bson.A{"$rating", 0}}),
+			"avg_rating": bson.M{"$avg": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$rating", 0}}, "$rating", nil}}},
+		}},
+		bson.M{"$set": bson.M{
+			"sessions":        bson.M{"$size": "$sessions"},
+			"signed_in_users": bson.M{"$size": "$users"},
+		}},
+		bson.M{"$unset": "users"},
+	}
+	return aggregateTenants(ctx, "chat_messages", pipeline, names)
+}
+
+func dailySessionRows(ctx context.Context, day time.Time, names map[primitive.ObjectID]string) ([]map[string]interface{}, error) {
+	started, err := aggregateTenants(ctx, "chat_sessions", bson.A{
+		bson.M{"$match": bson.M{"start_time": dayRange(day)}},
+		bson.M{"$group": bson.M{
+			"_id":                "$project_id",
+			"sessions_started":   bson.M{"$sum": 1},
+			"signed_in_sessions": countIf(bson.M{"$gt": bson.A{"$user_id", nil}}),
+			"escalated":          countIf(bson.M{"$gt": bson.A{"$escalation", nil}}),
+			"handoffs_requested": countIf(bson.M{"$gt": bson.A{"$handoff_requested_at", nil}}),
+		}},
+	}, names)
+	if err != nil {
+		return nil, err
+	}
+	closed, err := aggregateTenants(ctx, "chat_sessions", bson.A{
+		bson.M{"$match": bson.M{"end_time": dayRange(day)}},
+		bson.M{"$group": bson.M{"_id": "$project_id", "sessions_closed": bson.M{"$sum": 1}}},
+	}, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// One row per project with sessions started or closed that day
+	byProject := map[string]map[string]interface{}{}
+	for _, row := range started {
+		row["sessions_closed"] = 0
+		byProject[row["project_id"].(string)] = row
+	}
+	for _, row := range closed {
+		if existing, ok := byProject[row["project_id"].(string)]; ok {
+			existing["sessions_closed"] = row["sessions_closed"]
+			continue
+		}
+		row["sessions_started"], row["signed_in_sessions"], row["escalated"], row["handoffs_requested"] = 0, 0, 0, 0
+		started = append(started, row)
+	}
+	return started, nil
+}
+
+func dailyUsageRows(ctx context.Context, day time.Time, names map[primitive.ObjectID]string) ([]map[string]interface{}, error) {
+	cursor, err := config.GetGeminiUsageDailyCollection().Find(ctx, bson.M{"date": day.Format("2006-01-02")})
+	if err != nil {
+		return nil, err
+	}
+	var usage []models.GeminiUsageDaily
+	if err := cursor.All(ctx, &usage); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]interface{}, len(usage))
+	for i, u := range usage {
+		rows[i] = map[string]interface{}{
+			"project_id":           u.ProjectID.Hex(),
+			"project_name":         names[u.ProjectID],
+			"requests":             u.Requests,
+			"success_count":        u.SuccessCount,
+			"failed_count":         u.FailedCount,
+			"overage_requests":     u.OverageRequests,
+			"tokens_used":          u.TokensUsed,
+			"input_tokens":         u.InputTokens,
+			"output_tokens":        u.OutputTokens,
+			"estimated_cost":       u.EstimatedCost,
+			"avg_response_time_ms": u.AvgResponseTime,
+		}
+	}
+	return rows, nil
+}
+
+// GetWarehouseExportStatus - GET /admin/warehouse shows the configured
+// warehouse, the exported tables with their schemas and how far each has
+// been exported
+func GetWarehouseExportStatus(c *gin.Context) {
+	driver := ""
+	if config.WarehouseSettings != nil {
+		driver = config.WarehouseSettings.Driver
+	}
+	if driver == "" {
+		c.JSON(http.StatusOK, gin.H{"success": true, "driver": "", "enabled": false})
+		return
+	}
+
+	ids := make([]string, len(warehouseExports))
+	for i, export := range warehouseExports {
+		ids[i] = warehouseWatermarkID(export.table.Name)
+	}
+	cursor, err := config.GetCollection("warehouse_watermarks").Find(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read export watermarks"})
+		return
+	}
+	var marks []warehouseWatermark
+	if err := cursor.All(context.Background(), &marks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read export watermarks"})
+		return
+	}
+	byID := map[string]warehouseWatermark{}
+	for _, m := range marks {
+		byID[m.ID] = m
+	}
+
+	tables := make([]gin.H, len(warehouseExports))
+	for i, export := range warehouseExports {
+		mark := byID[ids[i]]
+		tables[i] = gin.H{
+			"table":          export.table.Name,
+			"schema_version": export.table.Version,
+			"columns":        export.table.Columns,
+			"last_date":      mark.LastDate,
+			"updated_at":     mark.UpdatedAt,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "driver": driver, "enabled": true, "tables": tables})
+}
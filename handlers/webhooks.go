@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/repository"
+	"jevi-chat/utils"
+)
+
+const (
+	// A failed delivery is retried after webhookMinBackoff, doubling up to
+	// webhookMaxBackoff, until it was attempted webhookMaxAttempts times
+	webhookMaxAttempts = 10
+	webhookMinBackoff  = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+	// How long a claimed delivery is kept from other workers
+	webhookClaimLease = 2 * time.Minute
+	// Webhooks per project
+	maxProjectWebhooks = 10
+)
+
+// webhookClient sends deliveries. Endpoints are chosen by customers, so
+// only public addresses are dialled and redirects are not followed.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:           utils.DialPublic,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+var processingWebhookDeliveries atomic.Bool
+
+// webhookSecret is the key deliveries are signed with, or "" when the
+// server has none and webhooks are off
+func webhookSecret() string {
+	if config.NotificationSettings == nil {
+		return ""
+	}
+	return config.NotificationSettings.WebhookSecret
+}
+
+// webhookBackoff is the wait after a delivery's attempt-th failure
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookMinBackoff << uint(attempt-1)
+	if d <= 0 || d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+// validWebhookURL accepts https URLs with a host name
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && u.Hostname() != "" && u.User == nil && len(raw) <= 2048
+}
+
+// dispatchWebhookEvent queues an event for the project's webhooks that
+// subscribe to it and makes a first attempt at each delivery. data is only
+// called when there is a webhook to send to.
+func dispatchWebhookEvent(projectID primitive.ObjectID, event string, data func() (map[string]interface{}, error)) {
+	if webhookSecret() == "" || projectID.IsZero() {
+		return
+	}
+	ctx := context.Background()
+	cursor, err := config.GetWebhooksCollection().Find(ctx, bson.M{
+		"project_id": projectID,
+		"is_active":  true,
+		"events":     event,
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to load webhooks for project %s: %v", projectID.Hex(), err)
+		return
+	}
+	var hooks []models.Webhook
+	if err := cursor.All(ctx, &hooks); err != nil || len(hooks) == 0 {
+		return
+	}
+
+	payloadData, err := data()
+	if err != nil {
+		log.Printf("⚠️ Failed to build %s webhook payload for project %s: %v", event, projectID.Hex(), err)
+		return
+	}
+
+	now := time.Now()
+	for _, hook := range hooks {
+		delivery := models.WebhookDelivery{
+			ID:        primitive.NewObjectID(),
+			WebhookID: hook.ID,
+			ProjectID: projectID,
+			Event:     event,
+			Status:    models.WebhookDeliveryPending,
+			// The first attempt is made right away, so it is claimed here
+			Attempts:      1,
+			NextAttemptAt: now.Add(webhookClaimLease),
+			CreatedAt:     now,
+		}
+		body, err := json.Marshal(gin.H{
+			"id":         delivery.ID.Hex(),
+			"event":      event,
+			"project_id": projectID.Hex(),
+			"created_at": now.UTC().Format(time.RFC3339),
+			"data":       payloadData,
+		})
+		if err != nil {
+			log.Printf("⚠️ Failed to encode %s webhook payload: %v", event, err)
+			return
+		}
+		delivery.Payload = string(body)
+		if _, err := config.GetWebhookDeliveriesCollection().InsertOne(ctx, delivery); err != nil {
+			log.Printf("⚠️ Failed to queue %s delivery for webhook %s: %v", event, hook.ID.Hex(), err)
+			continue
+		}
+		go attemptWebhookDelivery(delivery, &hook)
+	}
+}
+
+// postWebhookDelivery sends a delivery's payload, signed like our calls to
+// moderation webhooks, and returns the response status
+func postWebhookDelivery(ctx context.Context, hook *models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Jevi-Webhooks/1.0")
+	req.Header.Set("X-Jevi-Event", delivery.Event)
+	req.Header.Set("X-Jevi-Delivery", delivery.ID.Hex())
+	signWebhookRequest(req, delivery.ProjectID, webhookSecret(), body)
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return resp.StatusCode, nil
+}
+
+// attemptWebhookDelivery makes the attempt the delivery was claimed for and
+// records the outcome: succeeded, scheduled for a retry, or failed for good.
+// hook is loaded when nil.
+func attemptWebhookDelivery(delivery models.WebhookDelivery, hook *models.Webhook) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	deliveries := config.GetWebhookDeliveriesCollection()
+
+	if hook == nil {
+		hook = &models.Webhook{}
+		err := config.GetWebhooksCollection().FindOne(ctx, bson.M{"_id": delivery.WebhookID}).Decode(hook)
+		if err != nil || !hook.IsActive {
+			deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, bson.M{"$set": bson.M{
+				"status": models.WebhookDeliveryFailed,
+				"error":  "webhook was removed or disabled",
+			}, "$unset": bson.M{"next_attempt_at": ""}})
+			return
+		}
+	}
+
+	status, err := postWebhookDelivery(ctx, hook, delivery)
+	set := bson.M{"response_status": status}
+	unset := bson.M{}
+	switch {
+	case err == nil:
+		set["status"] = models.WebhookDeliverySucceeded
+		set["delivered_at"] = time.Now()
+		unset["error"] = ""
+		unset["next_attempt_at"] = ""
+	case delivery.Attempts >= webhookMaxAttempts:
+		set["status"] = models.WebhookDeliveryFailed
+		set["error"] = err.Error()
+		unset["next_attempt_at"] = ""
+		log.Printf("⚠️ Giving up on %s delivery %s to %s after %d attempts: %v",
+			delivery.Event, delivery.ID.Hex(), hook.URL, delivery.Attempts, err)
+	default:
+		set["error"] = err.Error()
+		set["next_attempt_at"] = time.Now().Add(webhookBackoff(delivery.Attempts))
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if _, err := deliveries.UpdateOne(context.Background(), bson.M{"_id": delivery.ID}, update); err != nil {
+		log.Printf("⚠️ Failed to record webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// ProcessWebhookDeliveries retries the webhook deliveries that are due,
+// including first attempts left behind by a restart
+func ProcessWebhookDeliveries() {
+	if !processingWebhookDeliveries.CompareAndSwap(false, true) {
+		return
+	}
+	defer processingWebhookDeliveries.Store(false)
+
+	for {
+		delivery, err := repository.ClaimWebhookDelivery(context.Background(), webhookClaimLease)
+		if err != nil {
+			log.Printf("⚠️ Failed to claim webhook delivery: %v", err)
+			return
+		}
+		if delivery == nil {
+			return
+		}
+		attemptWebhookDelivery(*delivery, nil)
+	}
+}
+
+// webhookMessageCreated sends message.created for an answered message
+func webhookMessageCreated(event models.ChatEvent) {
+	dispatchWebhookEvent(event.ProjectID, models.WebhookEventMessageCreated, func() (map[string]interface{}, error) {
+		var msg models.ChatMessage
+		err := config.GetChatMessagesCollectionFor(event.ProjectID).FindOne(context.Background(),
+			bson.M{"_id": event.MessageID}).Decode(&msg)
+		if err != nil {
+			return nil, err
+		}
+		data := map[string]interface{}{
+			"message_id": msg.ID.Hex(),
+			"session_id": msg.SessionID,
+			"message":    msg.Message,
+			"response":   msg.Response,
+			"timestamp":  msg.Timestamp.UTC().Format(time.RFC3339),
+		}
+		if !msg.UserID.IsZero() {
+			data["user_id"] = msg.UserID.Hex()
+		}
+		if msg.Intent != "" {
+			data["intent"] = msg.Intent
+		}
+		if msg.Fallback != "" {
+			data["fallback"] = msg.Fallback
+		}
+		return data, nil
+	})
+}
+
+// webhookLimitReached sends limit.reached when a project runs into its
+// monthly limit
+func webhookLimitReached(event models.ChatEvent) {
+	dispatchWebhookEvent(event.ProjectID, models.WebhookEventLimitReached, func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"limit_type":    event.Data["limit_type"],
+			"current_usage": eventInt(event.Data["current_usage"]),
+			"limit":         eventInt(event.Data["limit"]),
+			"session_id":    event.SessionID,
+		}, nil
+	})
+}
+
+// webhookFileProcessed sends pdf.processed once an uploaded document was
+// processed, successfully or not
+func webhookFileProcessed(projectID primitive.ObjectID, file models.SourceFile) {
+	dispatchWebhookEvent(projectID, models.WebhookEventPDFProcessed, func() (map[string]interface{}, error) {
+		data := map[string]interface{}{
+			"file_id":   file.ID,
+			"file_name": file.FileName,
+			"file_type": file.FileType(),
+			"status":    file.Status,
+		}
+		if file.Error != "" {
+			data["error"] = file.Error
+		}
+		return data, nil
+	})
+}
+
+// webhookInput is the body of the webhook create and update endpoints
+type webhookInput struct {
+	URL         *string  `json:"url"`
+	Events      []string `json:"events" binding:"omitempty,min=1,dive,oneof=message.created limit.reached pdf.processed"`
+	Description *string  `json:"description" binding:"omitempty,max=200"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// CreateWebhook - POST /admin/projects/:id/webhooks registers an endpoint
+// for some of the project's events
+func CreateWebhook(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	if webhookSecret() == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhook signing is not configured on this server"})
+		return
+	}
+
+	var input webhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if input.URL == nil || !validWebhookURL(*input.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an https URL"})
+		return
+	}
+	if len(input.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "events must name at least one of " + strings.Join(models.WebhookEvents, ", ")})
+		return
+	}
+
+	ctx := context.Background()
+	if n, err := config.GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": objID}); err != nil || n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	count, err := config.GetWebhooksCollection().CountDocuments(ctx, bson.M{"project_id": objID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	if count >= maxProjectWebhooks {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A project can have at most %d webhooks", maxProjectWebhooks)})
+		return
+	}
+
+	now := time.Now()
+	hook := models.Webhook{
+		ID:        primitive.NewObjectID(),
+		ProjectID: objID,
+		URL:       *input.URL,
+		Events:    input.Events,
+		IsActive:  input.IsActive == nil || *input.IsActive,
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if input.Description != nil {
+		hook.Description = strings.TrimSpace(*input.Description)
+	}
+	if _, err := config.GetWebhooksCollection().InsertOne(ctx, hook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionWebhookCreate, "project", projectID, objID, map[string]interface{}{
+		"webhook_id": hook.ID.Hex(),
+		"url":        hook.URL,
+		"events":     hook.Events,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "webhook": hook})
+}
+
+// ListWebhooks - GET /admin/projects/:id/webhooks lists the project's
+// webhooks
+func ListWebhooks(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	ctx := context.Background()
+	cursor, err := config.GetWebhooksCollection().Find(ctx, bson.M{"project_id": objID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load webhooks"})
+		return
+	}
+	hooks := []models.Webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "events": models.WebhookEvents})
+}
+
+// projectWebhook loads the webhook named in the path, writing a response
+// and returning false if it is not one of the project's
+func projectWebhook(c *gin.Context) (primitive.ObjectID, *models.Webhook, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return objID, nil, false
+	}
+	hookID, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return objID, nil, false
+	}
+	var hook models.Webhook
+	err = config.GetWebhooksCollection().FindOne(context.Background(), bson.M{"_id": hookID, "project_id": objID}).Decode(&hook)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return objID, nil, false
+	}
+	return objID, &hook, true
+}
+
+// UpdateWebhook - PUT /admin/projects/:id/webhooks/:webhookId changes a
+// webhook's URL, events, description or whether it is active; fields left
+// out are kept
+func UpdateWebhook(c *gin.Context) {
+	objID, hook, ok := projectWebhook(c)
+	if !ok {
+		return
+	}
+
+	var input webhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if input.URL != nil {
+		if !validWebhookURL(*input.URL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an https URL"})
+			return
+		}
+		hook.URL = *input.URL
+		set["url"] = hook.URL
+	}
+	if input.Events != nil {
+		hook.Events = input.Events
+		set["events"] = hook.Events
+	}
+	if input.Description != nil {
+		hook.Description = strings.TrimSpace(*input.Description)
+		set["description"] = hook.Description
+	}
+	if input.IsActive != nil {
+		hook.IsActive = *input.IsActive
+		set["is_active"] = hook.IsActive
+	}
+
+	if _, err := config.GetWebhooksCollection().UpdateOne(context.Background(), bson.M{"_id": hook.ID}, bson.M{"$set": set}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionWebhookUpdate, "project", objID.Hex(), objID, map[string]interface{}{
+		"webhook_id": hook.ID.Hex(),
+		"url":        hook.URL,
+		"events":     hook.Events,
+		"is_active":  hook.IsActive,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "webhook": hook})
+}
+
+// DeleteWebhook - DELETE /admin/projects/:id/webhooks/:webhookId removes a
+// webhook. Its pending deliveries fail at their next attempt; the delivery
+// log is kept until it expires.
+func DeleteWebhook(c *gin.Context) {
+	objID, hook, ok := projectWebhook(c)
+	if !ok {
+		return
+	}
+
+	if _, err := config.GetWebhooksCollection().DeleteOne(context.Background(), bson.M{"_id": hook.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionWebhookDelete, "project", objID.Hex(), objID, map[string]interface{}{
+		"webhook_id": hook.ID.Hex(),
+		"url":        hook.URL,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ListWebhookDeliveries - GET /admin/projects/:id/webhooks/:webhookId/deliveries
+// lists a webhook's latest deliveries, newest first. Takes status and
+// limit (default 50, at most 200).
+func ListWebhookDeliveries(c *gin.Context) {
+	_, hook, ok := projectWebhook(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	filter := bson.M{"webhook_id": hook.ID}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	ctx := context.Background()
+	cursor, err := config.GetWebhookDeliveriesCollection().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deliveries"})
+		return
+	}
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook_id": hook.ID.Hex(), "deliveries": deliveries})
+}
+
+// RedeliverWebhookDelivery - POST /admin/projects/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver
+// queues a delivery to be sent again, with a fresh set of attempts
+func RedeliverWebhookDelivery(c *gin.Context) {
+	_, hook, ok := projectWebhook(c)
+	if !ok {
+		return
+	}
+	deliveryID, err := primitive.ObjectIDFromHex(c.Param("deliveryId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	result, err := config.GetWebhookDeliveriesCollection().UpdateOne(context.Background(),
+		bson.M{"_id": deliveryID, "webhook_id": hook.ID, "status": bson.M{"$ne": models.WebhookDeliveryPending}},
+		bson.M{"$set": bson.M{
+			"status":          models.WebhookDeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue delivery"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found or already pending"})
+		return
+	}
+	go ProcessWebhookDeliveries()
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "delivery_id": deliveryID.Hex()})
+}
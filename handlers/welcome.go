@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// welcomeFlowConfig is the welcome flow served to the widget, or nil when
+// the project has none and the widget shows welcome_message alone
+func welcomeFlowConfig(project models.Project) *models.WelcomeFlow {
+	if project.WelcomeFlow == nil || !project.WelcomeFlow.Enabled {
+		return nil
+	}
+	flow := *project.WelcomeFlow
+	if len(flow.Messages) == 0 && project.WelcomeMessage != "" {
+		flow.Messages = []string{project.WelcomeMessage}
+	}
+	return &flow
+}
+
+// SetWelcomeFlow - PUT /admin/projects/:id/welcome-flow sets the intro
+// messages and quick-reply buttons the widget opens with. Replies keep
+// their IDs across edits so click analytics stay attached to them.
+func SetWelcomeFlow(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Enabled      bool     `json:"enabled"`
+		Messages     []string `json:"messages" binding:"max=5,dive,min=1,max=1000"`
+		QuickReplies []struct {
+			ID      string `json:"id" binding:"max=32"`
+			Label   string `json:"label" binding:"required,max=40"`
+			Action  string `json:"action" binding:"required,oneof=answer intent"`
+			Answer  string `json:"answer" binding:"max=2000"`
+			Intent  string `json:"intent" binding:"max=64"`
+			Message string `json:"message" binding:"max=500"`
+		} `json:"quick_replies" binding:"max=8,dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"intents.key": 1})).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	flow := models.WelcomeFlow{
+		Enabled:      input.Enabled,
+		Messages:     []string{},
+		QuickReplies: []models.QuickReply{},
+		UpdatedAt:    time.Now(),
+	}
+	for _, m := range input.Messages {
+		if m = strings.TrimSpace(m); m != "" {
+			flow.Messages = append(flow.Messages, m)
+		}
+	}
+	seen := map[string]bool{}
+	for _, r := range input.QuickReplies {
+		reply := models.QuickReply{
+			ID:     strings.TrimSpace(r.ID),
+			Label:  strings.TrimSpace(r.Label),
+			Action: r.Action,
+		}
+		switch r.Action {
+		case models.QuickReplyAnswer:
+			reply.Answer = strings.TrimSpace(r.Answer)
+			if reply.Answer == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Quick reply \"" + reply.Label + "\" needs an answer"})
+				return
+			}
+		case models.QuickReplyIntent:
+			reply.Intent = strings.TrimSpace(r.Intent)
+			if reply.Intent != "" && projectIntent(project, reply.Intent) == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown intent", "intent": reply.Intent})
+				return
+			}
+			reply.Message = strings.TrimSpace(r.Message)
+			if reply.Message == "" {
+				reply.Message = reply.Label
+			}
+		}
+		if reply.ID == "" {
+			reply.ID = "qr_" + randomHex(4)
+		}
+		if seen[reply.ID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quick reply IDs must be unique", "id": reply.ID})
+			return
+		}
+		seen[reply.ID] = true
+		flow.QuickReplies = append(flow.QuickReplies, reply)
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"welcome_flow": flow, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update welcome flow"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionWelcomeFlow, "project", projectID, objID, map[string]interface{}{
+		"enabled":       flow.Enabled,
+		"messages":      len(flow.Messages),
+		"quick_replies": len(flow.QuickReplies),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "welcome_flow": flow})
+}
+
+// RecordWelcomeEvent - POST /embed/:projectId/welcome/events records the
+// welcome flow being shown, or a quick reply being clicked, for the
+// click-through figures in GetWelcomeFlowAnalytics
+func RecordWelcomeEvent(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Event     string `json:"event" binding:"required,oneof=shown click"`
+		ReplyID   string `json:"reply_id" binding:"max=32"`
+		SessionID string `json:"session_id" binding:"max=100"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"welcome_flow": 1, "welcome_message": 1})).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	flow := welcomeFlowConfig(project)
+	if flow == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Welcome flow is not enabled"})
+		return
+	}
+	if input.Event == "click" {
+		found := false
+		for _, r := range flow.QuickReplies {
+			found = found || r.ID == input.ReplyID
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown quick reply"})
+			return
+		}
+	} else {
+		input.ReplyID = ""
+	}
+
+	_, err = config.GetWelcomeEventsCollection().InsertOne(ctx, models.WelcomeEvent{
+		ProjectID: objID,
+		SessionID: input.SessionID,
+		Event:     input.Event,
+		ReplyID:   input.ReplyID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetWelcomeFlowAnalytics - GET /admin/projects/:id/welcome-flow/analytics
+// counts how often the welcome flow was shown and each quick reply clicked
+// over the last ?days= days (default 30)
+func GetWelcomeFlowAnalytics(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 180 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 180"})
+		return
+	}
+
+	ctx := context.Background()
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": objID},
+		options.FindOne().SetProjection(bson.M{"welcome_flow": 1})).Decode(&project)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	cursor, err := config.GetWelcomeEventsCollection().Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"project_id": objID, "created_at": bson.M{"$gte": since}}},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"event": "$event", "reply_id": "$reply_id"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load welcome flow analytics"})
+		return
+	}
+	var rows []struct {
+		ID struct {
+			Event   string `bson:"event"`
+			ReplyID string `bson:"reply_id"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load welcome flow analytics"})
+		return
+	}
+
+	var shown, clicks int64
+	byReply := map[string]int64{}
+	for _, r := range rows {
+		if r.ID.Event == "shown" {
+			shown += r.Count
+		} else {
+			clicks += r.Count
+			byReply[r.ID.ReplyID] += r.Count
+		}
+	}
+	rate := func(n int64) float64 {
+		if shown == 0 {
+			return 0
+		}
+		return float64(n) / float64(shown)
+	}
+
+	replies := []gin.H{}
+	if project.WelcomeFlow != nil {
+		for _, r := range project.WelcomeFlow.QuickReplies {
+			replies = append(replies, gin.H{
+				"id":                 r.ID,
+				"label":              r.Label,
+				"action":             r.Action,
+				"intent":             r.Intent,
+				"clicks":             byReply[r.ID],
+				"click_through_rate": rate(byReply[r.ID]),
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":         projectID,
+		"days":               days,
+		"shown":              shown,
+		"clicks":             clicks,
+		"click_through_rate": rate(clicks),
+		"quick_replies":      replies,
+	})
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+const (
+	historyPageSize        = 50
+	reducedHistoryPageSize = 10
+)
+
+// reducedData reports whether the response should be trimmed for a
+// constrained client: the project asks for it, the browser sends
+// "Save-Data: on", or the widget passes ?reduced_data=1
+func reducedData(c *gin.Context, project *models.Project) bool {
+	// Caches must not serve a trimmed response to a full client
+	c.Writer.Header().Add("Vary", "Save-Data")
+	if project != nil && project.Widget != nil && project.Widget.ReducedData {
+		return true
+	}
+	if strings.EqualFold(strings.TrimSpace(c.GetHeader("Save-Data")), "on") {
+		return true
+	}
+	switch c.Query("reduced_data") {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// trimCitations drops the snippets, which reduced-data clients do not show
+func trimCitations(citations []models.Citation) []models.Citation {
+	trimmed := make([]models.Citation, len(citations))
+	for i, c := range citations {
+		c.Snippet = ""
+		trimmed[i] = c
+	}
+	return trimmed
+}
+
+// ratingSettings returns the project's rating prompt settings, or the
+// defaults: ask after every answer on a 1-5 scale, comment optional
+func ratingSettings(project models.Project) models.RatingSettings {
+	if project.Rating != nil {
+		return *project.Rating
+	}
+	return models.RatingSettings{AskWhen: models.RatingAskEveryMessage, Scale: models.RatingScaleStars}
+}
+
+// GetWidgetConfig - GET /embed/:projectId/config returns what the widget
+// needs to render before the first message, including the accessibility
+// and reduced-data options
+func GetWidgetConfig(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID}).Decode(&project)
+	if err != nil || !project.IsActive {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found or inactive"})
+		return
+	}
+
+	c.JSON(http.StatusOK, widgetConfig(c, project))
+}
+
+// widgetConfig is the config endpoint's payload for a project
+func widgetConfig(c *gin.Context, project models.Project) gin.H {
+	settings := models.WidgetSettings{FontScale: 1}
+	if project.Widget != nil {
+		settings = *project.Widget
+		if settings.FontScale == 0 {
+			settings.FontScale = 1
+		}
+	}
+	reduced := reducedData(c, &project)
+	pageSize := historyPageSize
+	if reduced {
+		pageSize = reducedHistoryPageSize
+	}
+
+	return gin.H{
+		"project_id":        project.ID.Hex(),
+		"name":              project.Name,
+		"welcome_message":   project.WelcomeMessage,
+		"welcome_flow":      welcomeFlowConfig(project),
+		"theme":             widgetTheme(project, settings.Theme),
+		"high_contrast":     settings.HighContrast,
+		"font_scale":        settings.FontScale,
+		"reduced_data":      reduced,
+		"show_avatars":      !reduced,
+		"animations":        !reduced,
+		"history_page_size": pageSize,
+		"rating":            ratingSettings(project),
+	}
+}
+
+// widgetTheme fills in the defaults for the theme fields a project left
+// empty, so the widget always gets a complete theme
+func widgetTheme(project models.Project, theme *models.WidgetTheme) models.WidgetTheme {
+	resolved := models.WidgetTheme{
+		PrimaryColor:    "#667eea",
+		SecondaryColor:  "#764ba2",
+		BackgroundColor: "#ffffff",
+		TextColor:       "#2d3748",
+		Position:        models.WidgetPositionBottomRight,
+		HeaderTitle:     project.Name,
+	}
+	if theme == nil {
+		return resolved
+	}
+	if theme.PrimaryColor != "" {
+		resolved.PrimaryColor = theme.PrimaryColor
+		// A single color makes a flat gradient unless the second is set
+		resolved.SecondaryColor = theme.PrimaryColor
+	}
+	if theme.SecondaryColor != "" {
+		resolved.SecondaryColor = theme.SecondaryColor
+	}
+	if theme.BackgroundColor != "" {
+		resolved.BackgroundColor = theme.BackgroundColor
+	}
+	if theme.TextColor != "" {
+		resolved.TextColor = theme.TextColor
+	}
+	if theme.Position != "" {
+		resolved.Position = theme.Position
+	}
+	if theme.HeaderTitle != "" {
+		resolved.HeaderTitle = theme.HeaderTitle
+	}
+	resolved.LogoURL = theme.LogoURL
+	resolved.HeaderSubtitle = theme.HeaderSubtitle
+	resolved.FontFamily = theme.FontFamily
+	return resolved
+}
+
+// widgetThemeInput is the theme accepted by SetWidgetSettings
+type widgetThemeInput struct {
+	PrimaryColor    string `json:"primary_color" binding:"omitempty,hexcolor"`
+	SecondaryColor  string `json:"secondary_color" binding:"omitempty,hexcolor"`
+	BackgroundColor string `json:"background_color" binding:"omitempty,hexcolor"`
+	TextColor       string `json:"text_color" binding:"omitempty,hexcolor"`
+	LogoURL         string `json:"logo_url" binding:"omitempty,url,startswith=https://,max=2048"`
+	Position        string `json:"position" binding:"omitempty,oneof=bottom-right bottom-left"`
+	HeaderTitle     string `json:"header_title" binding:"max=60"`
+	HeaderSubtitle  string `json:"header_subtitle" binding:"max=120"`
+	FontFamily      string `json:"font_family" binding:"max=100"`
+}
+
+// validFontFamily accepts CSS font-family lists such as
+// "Inter, 'Helvetica Neue', sans-serif" and nothing that could end the
+// declaration
+func validFontFamily(family string) bool {
+	for _, r := range family {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(" ,'\"-_", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SetWidgetSettings - PUT /admin/projects/:id/widget sets the widget's
+// accessibility and reduced-data options and, when theme is given, its
+// branding: colors, logo, launcher position, header text and font. A
+// request without theme keeps the current one.
+func SetWidgetSettings(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		HighContrast bool              `json:"high_contrast"`
+		FontScale    float64           `json:"font_scale" binding:"omitempty,min=0.75,max=2"`
+		ReducedData  bool              `json:"reduced_data"`
+		Theme        *widgetThemeInput `json:"theme"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if input.FontScale == 0 {
+		input.FontScale = 1
+	}
+
+	set := bson.M{
+		"widget.high_contrast": input.HighContrast,
+		"widget.font_scale":    input.FontScale,
+		"widget.reduced_data":  input.ReducedData,
+		"widget.updated_at":    time.Now(),
+		"updated_at":           time.Now(),
+	}
+	details := map[string]interface{}{
+		"high_contrast": input.HighContrast,
+		"font_scale":    input.FontScale,
+		"reduced_data":  input.ReducedData,
+	}
+	if t := input.Theme; t != nil {
+		if !validFontFamily(t.FontFamily) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "font_family may only hold font names, spaces, commas and quotes"})
+			return
+		}
+		theme := models.WidgetTheme{
+			PrimaryColor:    strings.ToLower(t.PrimaryColor),
+			SecondaryColor:  strings.ToLower(t.SecondaryColor),
+			BackgroundColor: strings.ToLower(t.BackgroundColor),
+			TextColor:       strings.ToLower(t.TextColor),
+			LogoURL:         t.LogoURL,
+			Position:        t.Position,
+			HeaderTitle:     strings.TrimSpace(t.HeaderTitle),
+			HeaderSubtitle:  strings.TrimSpace(t.HeaderSubtitle),
+			FontFamily:      strings.TrimSpace(t.FontFamily),
+		}
+		set["widget.theme"] = theme
+		details["theme"] = theme
+	}
+
+	var project models.Project
+	err = config.GetProjectsCollection().FindOneAndUpdate(context.Background(), bson.M{"_id": objID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"widget": 1}),
+	).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update widget settings"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionWidgetUpdate, "project", projectID, objID, details)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "widget": project.Widget})
+}
+
+// SetRatingSettings - PUT /admin/projects/:id/rating sets when the widget
+// asks for a rating, on which scale, and whether a comment is required
+func SetRatingSettings(c *gin.Context) {
+	projectID := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		AskWhen        string `json:"ask_when" binding:"required,oneof=every_message after_messages session_end"`
+		AfterMessages  int    `json:"after_messages" binding:"min=0,max=100"`
+		Scale          string `json:"scale" binding:"required,oneof=stars thumbs"`
+		RequireComment bool   `json:"require_comment"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if input.AskWhen == models.RatingAskAfterN && input.AfterMessages < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "after_messages must be at least 1"})
+		return
+	}
+	if input.AskWhen != models.RatingAskAfterN {
+		input.AfterMessages = 0
+	}
+
+	settings := models.RatingSettings{
+		AskWhen:        input.AskWhen,
+		AfterMessages:  input.AfterMessages,
+		Scale:          input.Scale,
+		RequireComment: input.RequireComment,
+		UpdatedAt:      time.Now(),
+	}
+	result, err := config.GetProjectsCollection().UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{"rating": settings, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rating settings"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	recordAudit(c, models.AuditActionRatingUpdate, "project", projectID, objID, map[string]interface{}{
+		"ask_when":        settings.AskWhen,
+		"after_messages":  settings.AfterMessages,
+		"scale":           settings.Scale,
+		"require_comment": settings.RequireComment,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "project_id": projectID, "rating": settings})
+}
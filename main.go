@@ -1,40 +1,102 @@
 package main
 
 import (
+    "context"
+    "flag"
+    "fmt"
+    "io/fs"
     "log"
     "net/http"
     "os"
     "time"
 
-    "github.com/gin-contrib/cors"
     "github.com/gin-gonic/gin"
     "github.com/joho/godotenv"
     "jevi-chat/config"
     "jevi-chat/handlers"
     "jevi-chat/middleware"
+    "jevi-chat/models"
+    "jevi-chat/repository"
 )
 
 func main() {
+    checkOnly := flag.Bool("check-assets", false, "validate templates and widget assets, then exit")
+    mode := flag.String("mode", envOr("SERVER_MODE", "full"), `"full", or "analytics" for a read-only reporting deployment`)
+    flag.Parse()
+
+    assets, assetSource := assetFS()
+    if *checkOnly {
+        if err := checkAssets(assets); err != nil {
+            log.Fatalf("❌ Asset check failed (%s): %v", assetSource, err)
+        }
+        log.Printf("✅ Assets OK (%s)", assetSource)
+        return
+    }
+
     // Load .env variables
     if err := godotenv.Load(); err != nil {
         log.Println("⚠️ Warning: .env file not found, using system environment variables")
     }
 
+    // Credentials from a secrets manager override .env
+    config.LoadManagedSecrets()
+
+    serverCfg, err := loadServerConfig()
+    if err != nil {
+        log.Fatalf("❌ Invalid TLS configuration: %v", err)
+    }
+
+    // An analytics deployment serves the reporting endpoints from a read
+    // replica and leaves chat traffic, writes and background jobs to the
+    // full deployment
+    var analytics bool
+    switch *mode {
+    case "full":
+    case "analytics":
+        analytics = true
+        config.ReadOnly = true
+        log.Println("📖 Starting in read-only analytics mode")
+    default:
+        log.Fatalf("❌ Unknown server mode %q (use full or analytics)", *mode)
+    }
+
     // Initialize services
     log.Println("🔗 Initializing MongoDB connection...")
     config.InitMongoDB()
     defer config.CloseMongoDB()
 
+    if !analytics {
+        if err := config.InitializeDefaultData(); err != nil {
+            log.Printf("⚠️ Default data initialization failed: %v", err)
+        }
+
+        log.Println("🗄️ Initializing object storage...")
+        config.InitStorage()
+    }
+    config.InitSecrets()
+
     // ✅ NEW: Initialize notification configuration
     log.Println("🔔 Initializing notification system...")
     config.InitNotificationConfig()
+    if err := config.InitSIEMConfig(); err != nil {
+        log.Fatalf("❌ Invalid SIEM configuration: %v", err)
+    }
+    if err := config.InitEventBusConfig(); err != nil {
+        log.Fatalf("❌ Invalid event bus configuration: %v", err)
+    }
+    if err := config.InitWarehouseConfig(); err != nil {
+        log.Fatalf("❌ Invalid warehouse configuration: %v", err)
+    }
 
-    // ✅ NEW: Start notification cleanup routine
-    go startNotificationCleanup()
+    if !analytics {
+        // ✅ NEW: Start notification cleanup routine
+        go startNotificationCleanup()
 
-    // Initialize other services
-    log.Println("🤖 Initializing Gemini...")
-    config.InitGemini()
+        // Initialize other services
+        log.Println("🤖 Initializing Gemini...")
+        config.InitGemini()
+        defer config.CloseGeminiClients()
+    }
     
     log.Println("🚦 Initializing rate limiters...")
     handlers.InitRateLimiters()
@@ -45,84 +107,130 @@ func main() {
     }
 
     r := gin.New()
+    r.UseH2C = serverCfg.cleartext
+    if err := middleware.ConfigureClientIP(r); err != nil {
+        log.Fatalf("❌ Invalid trusted proxy configuration: %v", err)
+    }
     
     // Add middleware
     r.Use(gin.Logger())
+    if !analytics {
+        // SLO metrics are flushed by the full deployment only
+        r.Use(middleware.SLO())
+    }
     r.Use(gin.Recovery())
+    r.Use(middleware.Compress())
+    r.Use(middleware.Chaos())
     
-    r.LoadHTMLGlob("templates/**/*.html")
-    r.Static("/static", "./static")
-
-    // Enhanced CORS setup
-    corsConfig := cors.Config{
-        AllowOrigins: []string{
-            "https://troikafrontend.onrender.com",
-            "http://localhost:3000",
-            "http://127.0.0.1:3000",
-            "http://localhost:3001",
-            "http://127.0.0.1:3001",
-            "http://localhost:8081",
-        },
-        AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH", "HEAD"},
-        AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "X-CSRF-Token", "Cache-Control"},
-        ExposeHeaders:    []string{"Content-Length", "Content-Type", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
-        AllowCredentials: true,
-        MaxAge:           12 * time.Hour,
+    templates, err := loadTemplates(assets)
+    if err != nil {
+        log.Printf("⚠️ Failed to load templates (%s), pages will use the fallback: %v", assetSource, err)
+    } else {
+        log.Printf("📄 Templates loaded from %s assets", assetSource)
     }
+    r.HTMLRender = fallbackRender{templates: templates}
 
-    // Add custom CORS allowed origins from environment
-    if customOrigins := os.Getenv("CORS_ALLOWED_ORIGINS"); customOrigins != "" {
-        corsConfig.AllowOrigins = append(corsConfig.AllowOrigins, customOrigins)
-    }
+    widgetJS, _ := fs.Sub(assets, "static/js")
+    widgetCSS, _ := fs.Sub(assets, "static/css")
+    static := r.Group("/static", middleware.CacheControl("public, max-age=3600"))
+    static.StaticFS("/js", http.FS(widgetJS))
+    static.StaticFS("/css", http.FS(widgetCSS))
+    static.Static("/uploads", "./static/uploads")
 
-    r.Use(cors.New(corsConfig))
-
-    // Dev-only: Allow null origin
-    if gin.Mode() == gin.DebugMode {
-        corsConfig.AllowOrigins = append(corsConfig.AllowOrigins, "null")
-        log.Println("🔍 CORS: Allowing 'null' origin for development")
-    }
+    // CORS: strict for the dashboard, permissive for the widget
+    r.Use(middleware.CORS(corsMiddlewareConfig()))
 
-    // Enhanced security headers
+    // Enhanced security headers. Widget routes replace the frame-ancestors
+    // policy with their project's allowed domains.
     r.Use(func(c *gin.Context) {
-        c.Header("X-Frame-Options", "ALLOWALL")
         c.Header("Content-Security-Policy", "frame-ancestors *")
         c.Header("X-Content-Type-Options", "nosniff")
         c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
         c.Header("X-XSS-Protection", "1; mode=block")
+        if serverCfg.mode != "" {
+            c.Header("Strict-Transport-Security", "max-age=31536000")
+        }
         c.Next()
     })
 
+    if analytics {
+        r.Use(middleware.ReadOnlyRoutes(analyticsRoutes))
+    }
+
     // Setup routes
     setupRoutes(r)
 
-    // Widget assets
-    r.GET("/widget.js", func(c *gin.Context) {
-        c.File("./static/js/jevi-chat-widget.js")
+    // Widget assets: the URLs are unversioned, so keep the cache short and
+    // let browsers revalidate cheaply with the ETag
+    widget := r.Group("/", middleware.CacheControl("public, max-age=300"), middleware.ETag())
+    widget.GET("/widget.js", func(c *gin.Context) {
+        c.FileFromFS("jevi-chat-widget.js", http.FS(widgetJS))
     })
-    r.GET("/widget.css", func(c *gin.Context) {
-        c.File("./static/css/jevi-widget.css")
+    widget.GET("/widget.css", func(c *gin.Context) {
+        c.FileFromFS("jevi-widget.css", http.FS(widgetCSS))
     })
 
-    // ✅ NEW: Start maintenance tasks
-    go startMaintenanceTasks()
-
-    // Start server
-    port := os.Getenv("PORT")
-    if port == "" || len(port) > 5 {
-        port = "8080"
+    if !analytics {
+        // ✅ NEW: Start maintenance tasks
+        go startMaintenanceTasks()
+
+        go startUsageResets()
+        go startUserDeletions()
+        go startUploadBatches()
+        go startWebhookDeliveries()
+        go startEscalationSLAs()
+        go startAPIKeyUsage()
+        go startSIEMForwarder()
+        go startEventBusPublisher()
+        go startWarehouseExporter()
+        go config.StartSLOFlusher()
+        go startSLOSummaries()
     }
+    go config.StartSecretsRefresh()
+    go config.StartTenantRoutesRefresh()
+    go handlers.WatchNotifications()
+
+    // Fault injection (staging only), armed once startup is done
+    config.InitChaos()
 
-    log.Printf("🚀 Jevi Chat Server running on port %s", port)
+    // Start server
     log.Printf("📝 Environment: %s", gin.Mode())
     log.Printf("🔔 Notification system: %s", getNotificationStatus())
-    log.Printf("🤖 Gemini model: gemini-2.0-flash")
+    log.Printf("🤖 Gemini model: gemini-2.0-flash (default key: %s)", config.GeminiStatus())
     
-    if err := http.ListenAndServe("0.0.0.0:"+port, r); err != nil {
+    if err := serve(serverCfg, r.Handler()); err != nil {
         log.Fatalf("❌ Failed to start server: %v", err)
     }
 }
 
+// analyticsRoutes are the read-only reporting endpoints an analytics
+// deployment serves; every other route answers 404 there
+var analyticsRoutes = []string{
+    "/health",
+    "/api/projects/:id/info",
+    "/api/projects/:id/chat/history",
+    "/api/projects/:id/chat/analytics",
+    "/admin/analytics/data",
+    "/admin/realtime-stats",
+    "/admin/notifications/stats",
+    "/admin/projects/:id/gemini/analytics",
+    "/admin/projects/:id/analytics/citations",
+    "/admin/projects/:id/gemini/daily",
+    "/admin/projects/:id/freshness",
+    "/admin/projects/:id/leads",
+    "/admin/projects/:id/archives",
+    "/admin/projects/limits",
+    "/admin/slo",
+    "/admin/audit-logs",
+    "/admin/audit-logs/export",
+    "/admin/notifications/export",
+    "/admin/events",
+    "/admin/warehouse",
+    "/admin/billing/overage",
+    "/admin/database/stats",
+    "/admin/database/tenants",
+}
+
 func setupRoutes(r *gin.Engine) {
     // Enhanced health check
     r.GET("/health", func(c *gin.Context) {
@@ -142,7 +250,10 @@ func setupRoutes(r *gin.Engine) {
             "iframe":       "enabled",
             "rateLimit":    "enabled",
             "notifications": getNotificationStatus(),
+            "gemini":       config.GeminiStatus(),
             "gemini_model": "gemini-2.0-flash",
+            "instance":     config.InstanceID,
+            "read_only":    config.ReadOnly,
             "timestamp":    time.Now().Format(time.RFC3339),
         })
     })
@@ -158,7 +269,7 @@ func setupRoutes(r *gin.Engine) {
 
     // Embed routes
     embed := r.Group("/embed/:projectId")
-    embed.Use(handlers.RateLimitMiddleware("general"))
+    embed.Use(handlers.RateLimitMiddleware("general"), middleware.CacheControl("no-cache"), middleware.EmbedDomain(), middleware.ProjectAPIKey(), handlers.APIKeyUsage())
     {
         embed.GET("", handlers.EmbedChat)
         embed.GET("/chat", handlers.IframeChatInterface)
@@ -168,13 +279,34 @@ func setupRoutes(r *gin.Engine) {
         {
             auth.GET("", handlers.EmbedAuth)
             auth.POST("", handlers.EmbedAuth)
+            auth.POST("/refresh", handlers.RefreshChatUserToken)
+            auth.POST("/logout", handlers.RevokeChatUserToken)
         }
 
-        embed.POST("/message", handlers.RateLimitMiddleware("chat"), handlers.IframeSendMessage)
+        embed.POST("/session", handlers.CreateChatSession)
+        embed.GET("/sessions", handlers.ListChatUserSessions)
+        embed.PUT("/sessions/privacy", handlers.SetSessionHistoryPrivacy)
+        embed.POST("/sessions/:sessionId/resume", handlers.ResumeChatUserSession)
+        embed.DELETE("/sessions/:sessionId", handlers.HideChatUserSession)
+        embed.POST("/sessions/:sessionId/close", handlers.CloseChatSession)
+        embed.DELETE("/messages/:messageId", handlers.DeleteOwnMessage)
+        embed.POST("/message", handlers.RateLimitMiddleware("chat"), middleware.EmbedSignature(), handlers.IframeSendMessage)
+        embed.POST("/message/stream", handlers.RateLimitMiddleware("chat"), middleware.EmbedSignature(), handlers.IframeStreamMessage)
+        embed.GET("/usage", middleware.EmbedSignature(), handlers.EmbedUsage)
+        embed.GET("/config", handlers.GetWidgetConfig)
+        embed.POST("/prewarm", handlers.PrewarmWidget)
+        embed.POST("/fallback/email", handlers.RateLimitMiddleware("chat"), handlers.SubmitFallbackEmail)
+        embed.POST("/welcome/events", handlers.RateLimitMiddleware("chat"), handlers.RecordWelcomeEvent)
     }
 
     r.GET("/embed/health", handlers.EmbedHealth)
 
+    // Public per-project status page
+    r.GET("/status/:projectId", handlers.RateLimitMiddleware("general"), middleware.CacheControl("public, max-age=60"), handlers.GetProjectStatus)
+
+    // Analytics share links
+    r.GET("/share/analytics/:token", handlers.RateLimitMiddleware("general"), middleware.CacheControl("no-store"), handlers.GetSharedAnalytics)
+
     // Public Auth Routes
     authRoutes := r.Group("/")
     authRoutes.Use(handlers.RateLimitMiddleware("auth"))
@@ -185,14 +317,20 @@ func setupRoutes(r *gin.Engine) {
         authRoutes.POST("/register", handlers.Register)
     }
 
+    // Budget preflight; registered outside the api group so checking does
+    // not itself use up the general limit. It has a limiter of its own and
+    // only peeks at the budgets it reports.
+    r.GET("/api/rate-limit/status", handlers.RateLimitMiddleware("status"), middleware.CacheControl("no-store"), handlers.GetRateLimitStatus)
+
     // ===== API ROUTES =====
     api := r.Group("/api")
-    api.Use(handlers.RateLimitMiddleware("general"))
+    api.Use(handlers.RateLimitMiddleware("general"), middleware.CacheControl("no-store"))
     {
         // Public auth endpoints
         api.POST("/login", handlers.Login)
         api.POST("/register", handlers.Register)
         api.POST("/logout", handlers.Logout)
+        api.GET("/users/:id/avatar", handlers.GetUserAvatar)
 
         // ✅ NEW: Public notification health check
         api.GET("/notifications/health", func(c *gin.Context) {
@@ -210,27 +348,36 @@ func setupRoutes(r *gin.Engine) {
 
         // Protected API routes
         protected := api.Group("/")
-        protected.Use(middleware.AdminAuth())
+        protected.Use(middleware.AdminAuth(), middleware.TenantScope(), middleware.ResponseShaping())
         {
             // ✅ NEW: Notification routes
             protected.GET("/notifications", handlers.GetNotifications)
+            protected.GET("/notifications/stream", handlers.StreamNotifications)
             protected.PUT("/notifications/:id/read", handlers.MarkNotificationAsRead)
             protected.PUT("/notifications/read-all", handlers.MarkAllNotificationsAsRead)
+            protected.PUT("/notifications/:id/archive", handlers.ArchiveNotification)
+            protected.PUT("/notifications/:id/unarchive", handlers.UnarchiveNotification)
+            protected.POST("/notifications/archive", handlers.ArchiveNotifications)
             protected.DELETE("/notifications/:id", handlers.DeleteNotification)
 
             // User routes
             protected.GET("/user/profile", handlers.GetUserProfile)
             protected.PUT("/user/profile", handlers.UpdateUserProfile)
+            protected.POST("/user/avatar", handlers.UploadAvatar)
+            protected.DELETE("/user/avatar", handlers.DeleteAvatar)
             protected.GET("/user/projects", handlers.GetUserProjects)
 
             // Project routes
             protected.GET("/projects/:id", handlers.ProjectDetails)
-            protected.GET("/projects/:id/info", handlers.GetProjectInfo)
-            protected.GET("/projects/:id/chat/history", handlers.GetChatHistory)
+            protected.GET("/projects/:id/info", middleware.CacheControl("private, no-cache"), middleware.ETag(), handlers.GetProjectInfo)
+            protected.GET("/projects/:id/chat/history", middleware.CacheControl("private, no-cache"), middleware.ETag(), handlers.GetChatHistory)
             protected.GET("/projects/:id/chat/analytics", handlers.GetChatAnalytics)
             protected.POST("/projects/:id/chat/send", handlers.SendMessage)
             protected.PUT("/projects/:id/chat/messages/:messageId/rate", handlers.RateMessage)
             protected.GET("/projects/:id/notifications", handlers.GetProjectNotifications)
+            protected.GET("/projects/:id/onboarding", handlers.GetOnboarding)
+            protected.GET("/projects/:id/chat-users/:userId/data", handlers.ExportChatUserData)
+            protected.DELETE("/projects/:id/chat-users/:userId/data", handlers.PurgeChatUserData)
 
             // PDF management
             protected.POST("/projects/:id/pdf/upload", handlers.UploadPDF)
@@ -240,10 +387,10 @@ func setupRoutes(r *gin.Engine) {
 
         // Legacy admin routes (keeping for backward compatibility)
         api.GET("/admin/dashboard", handlers.AdminDashboard)
-        api.GET("/admin/projects", handlers.AdminProjects)
+        api.GET("/admin/projects", middleware.AdminAuth(), middleware.SuperAdminAuth(), handlers.AdminProjects)
         api.POST("/admin/projects", handlers.CreateProject)
-        api.GET("/admin/users", handlers.AdminUsers)
-        api.DELETE("/admin/users/:id", handlers.DeleteUser)
+        api.GET("/admin/users", middleware.AdminAuth(), middleware.SuperAdminAuth(), handlers.AdminUsers)
+        api.DELETE("/admin/users/:id", middleware.AdminAuth(), middleware.SuperAdminAuth(), handlers.DeleteUser)
         api.GET("/project/:id", handlers.ProjectDetails)
         api.PUT("/project/:id", handlers.UpdateProject)
         api.DELETE("/project/:id", handlers.DeleteProject)
@@ -253,7 +400,7 @@ func setupRoutes(r *gin.Engine) {
 
     // ===== ADMIN ROUTES =====
     admin := r.Group("/admin")
-    admin.Use(handlers.RateLimitMiddleware("general"))
+    admin.Use(handlers.RateLimitMiddleware("general"), middleware.CacheControl("no-store"))
     admin.Use(func(c *gin.Context) {
         if c.Request.Method == "OPTIONS" {
             c.Next()
@@ -261,13 +408,16 @@ func setupRoutes(r *gin.Engine) {
         }
         middleware.AdminAuth()(c)
     })
+    // Tenant admins only reach their own projects; the rest is super-admin only
+    admin.Use(middleware.TenantScope())
+    // ?profile=compact trims responses for the mobile admin app
+    admin.Use(middleware.ResponseShaping())
     {
         // Dashboard
         admin.GET("/", handlers.AdminDashboard)
         admin.GET("/dashboard", handlers.AdminDashboard)
 
         // Projects management
-        admin.GET("/projects", handlers.AdminProjects)
         admin.POST("/projects", handlers.CreateProject)
         admin.GET("/projects/:id", handlers.ProjectDetails)
         admin.PUT("/projects/:id", handlers.UpdateProject)
@@ -276,26 +426,25 @@ func setupRoutes(r *gin.Engine) {
 
         // ✅ NEW: Enhanced Gemini management with notifications
         admin.PATCH("/projects/:id/gemini/toggle", handlers.ToggleGeminiStatus)
-        admin.PATCH("/projects/:id/gemini/limit", handlers.SetGeminiLimit)
-        admin.POST("/projects/:id/gemini/reset", handlers.ResetGeminiUsage)
         admin.GET("/projects/:id/gemini/analytics", handlers.GetGeminiAnalytics)
-
-        // ✅ NEW: Monthly limit management (simplified schema)
-        admin.PUT("/projects/:id/gemini/monthly-limit", handlers.SetMonthlyGeminiLimit)
-        admin.POST("/projects/:id/gemini/reset-monthly", handlers.ResetMonthlyUsage)
-        admin.GET("/projects/limits", handlers.GetProjectsWithLimits)
+        admin.GET("/projects/:id/analytics/citations", handlers.GetCitationAnalytics)
+        admin.GET("/projects/:id/gemini/daily", handlers.GetGeminiDailyUsage)
+        admin.GET("/projects/:id/gemini/health", handlers.GetGeminiHealth)
 
         // Users management
-        admin.GET("/users", handlers.AdminUsers)
         admin.GET("/users/:id", handlers.GetUserDetails)
         admin.PUT("/users/:id", handlers.UpdateUser)
         admin.DELETE("/users/:id", handlers.DeleteUser)
         admin.PUT("/users/:id/toggle", handlers.ToggleUserStatus)
+        admin.GET("/user-deletions/:id", handlers.GetUserDeletion)
 
         // ✅ NEW: Enhanced notification management
         admin.GET("/notifications", handlers.GetNotifications)
         admin.GET("/notifications/stats", handlers.GetNotificationStats)
         admin.DELETE("/notifications/:id", handlers.DeleteNotification)
+        admin.PUT("/notifications/:id/archive", handlers.ArchiveNotification)
+        admin.PUT("/notifications/:id/unarchive", handlers.UnarchiveNotification)
+        admin.POST("/notifications/archive", handlers.ArchiveNotifications)
         admin.PUT("/notifications/cleanup", func(c *gin.Context) {
             if err := handlers.CleanupExpiredNotifications(); err != nil {
                 c.JSON(http.StatusInternalServerError, gin.H{
@@ -313,28 +462,173 @@ func setupRoutes(r *gin.Engine) {
         // Analytics and settings
         admin.GET("/analytics", handlers.AdminAnalytics)
         admin.GET("/analytics/data", handlers.GetAnalyticsData)
-        admin.GET("/settings", handlers.AdminSettings)
-        admin.PUT("/settings", handlers.UpdateSettings)
         admin.GET("/realtime-stats", handlers.GetRealtimeStats)
 
         // PDF management
         admin.POST("/projects/:id/upload-pdf", handlers.UploadPDF)
         admin.DELETE("/projects/:id/pdf/:fileId", handlers.DeletePDF)
+        admin.PUT("/projects/:id/pdf/:fileId", handlers.ReplacePDF)
         admin.GET("/projects/:id/pdf/files", handlers.GetPDFFiles)
-
-        // ✅ NEW: Database management
-        admin.GET("/database/stats", func(c *gin.Context) {
-            stats := config.GetDetailedDatabaseStats()
-            c.JSON(http.StatusOK, gin.H{
-                "success": true,
-                "stats": stats,
+        admin.GET("/projects/:id/pdf/:fileId/preview", handlers.PreviewPDFPage)
+
+        // Widget request signing keys
+        admin.PUT("/projects/:id/signing", handlers.SetRequestSigning)
+        admin.PUT("/projects/:id/moderation", handlers.SetModerationWebhook)
+        admin.PUT("/projects/:id/dry-run", handlers.SetDryRunKey)
+
+        // Publishable widget API keys
+        admin.POST("/projects/:id/api-keys", handlers.CreateProjectAPIKey)
+        admin.GET("/projects/:id/api-keys", handlers.ListProjectAPIKeys)
+        admin.DELETE("/projects/:id/api-keys/:keyId", handlers.RevokeProjectAPIKey)
+        admin.GET("/projects/:id/api-keys/:keyId/usage", handlers.GetAPIKeyUsage)
+        admin.PUT("/projects/:id/domains", handlers.SetAllowedDomains)
+        admin.POST("/projects/:id/domains", handlers.AddDomain)
+        admin.GET("/projects/:id/domains", handlers.ListDomains)
+        admin.POST("/projects/:id/domains/:domain/verify", handlers.VerifyDomain)
+        admin.DELETE("/projects/:id/domains/:domain", handlers.RemoveDomain)
+
+        // Read-only analytics links for people without an account
+        admin.POST("/projects/:id/share-links", handlers.CreateAnalyticsShareLink)
+        admin.GET("/projects/:id/share-links", handlers.ListAnalyticsShareLinks)
+        admin.DELETE("/projects/:id/share-links/:linkId", handlers.RevokeAnalyticsShareLink)
+
+        // Widget sign-in tokens reused from a different client
+        admin.PUT("/projects/:id/token-binding", handlers.SetTokenBinding)
+        admin.PUT("/projects/:id/session-continuity", handlers.SetSessionContinuity)
+        admin.POST("/projects/:id/embeddings", handlers.ReindexEmbeddings)
+        admin.POST("/projects/:id/uploads", handlers.UploadArchive)
+        admin.GET("/projects/:id/uploads/:batchId", handlers.GetUploadBatch)
+        admin.DELETE("/projects/:id/chat-users/:userId/tokens", handlers.RevokeChatUserTokens)
+
+        // Ticketing handover
+        admin.PUT("/projects/:id/ticketing", handlers.SetTicketingIntegration)
+        admin.PUT("/projects/:id/slack", handlers.SetSlackRouting)
+        admin.DELETE("/projects/:id/slack", handlers.DeleteSlackRouting)
+        admin.POST("/projects/:id/slack/test", handlers.TestSlackRouting)
+        admin.PUT("/projects/:id/discord", handlers.SetDiscordRouting)
+        admin.DELETE("/projects/:id/discord", handlers.DeleteDiscordRouting)
+        admin.POST("/projects/:id/discord/test", handlers.TestDiscordRouting)
+        admin.POST("/projects/:id/webhooks", handlers.CreateWebhook)
+        admin.GET("/projects/:id/webhooks", handlers.ListWebhooks)
+        admin.PUT("/projects/:id/webhooks/:webhookId", handlers.UpdateWebhook)
+        admin.DELETE("/projects/:id/webhooks/:webhookId", handlers.DeleteWebhook)
+        admin.GET("/projects/:id/webhooks/:webhookId/deliveries", handlers.ListWebhookDeliveries)
+        admin.POST("/projects/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver", handlers.RedeliverWebhookDelivery)
+        admin.POST("/projects/:id/sessions/:sid/escalate", handlers.EscalateSession)
+        admin.POST("/projects/:id/sessions/:sid/respond", handlers.RecordHumanResponse)
+        admin.GET("/projects/:id/escalations", handlers.GetEscalationReport)
+
+        // Appointment booking
+        admin.PUT("/projects/:id/booking", handlers.SetBookingIntegration)
+        admin.GET("/projects/:id/leads", handlers.GetProjectLeads)
+        admin.GET("/projects/:id/export", handlers.ExportProjectData)
+        admin.POST("/projects/:id/migration/export", handlers.ExportProjectMigration)
+        admin.POST("/migrations/import", handlers.ImportProjectMigration)
+        admin.POST("/projects/:id/messages/:messageId/redact", handlers.RedactMessage)
+
+        // Product catalog
+        admin.POST("/projects/:id/catalog", handlers.ImportCatalog)
+        admin.GET("/projects/:id/catalog", handlers.GetCatalog)
+        admin.DELETE("/projects/:id/catalog", handlers.ClearCatalog)
+
+        // Historical transcripts from another chat vendor
+        admin.POST("/projects/:id/transcripts/import", handlers.ImportTranscripts)
+        admin.GET("/projects/:id/transcripts/imports", handlers.ListTranscriptImports)
+        admin.DELETE("/projects/:id/transcripts/imports/:importId", handlers.DeleteTranscriptImport)
+
+        // Order status lookups
+        admin.PUT("/projects/:id/order-lookup", handlers.SetOrderLookup)
+
+        // Shared knowledge library
+        admin.POST("/library", handlers.UploadLibraryDocuments)
+        admin.GET("/library", handlers.GetLibraryDocuments)
+        admin.DELETE("/library/:docId", handlers.DeleteLibraryDocument)
+        admin.PUT("/projects/:id/library/:docId", handlers.SetProjectLibraryDocument)
+        admin.DELETE("/projects/:id/library/:docId", handlers.RemoveProjectLibraryDocument)
+
+        // Widget accessibility and reduced-data options
+        admin.PUT("/projects/:id/widget", handlers.SetWidgetSettings)
+        admin.PUT("/projects/:id/rating", handlers.SetRatingSettings)
+        admin.PUT("/projects/:id/welcome-flow", handlers.SetWelcomeFlow)
+        admin.GET("/projects/:id/welcome-flow/analytics", handlers.GetWelcomeFlowAnalytics)
+        admin.PUT("/projects/:id/intents", handlers.SetIntents)
+        admin.GET("/projects/:id/intents/analytics", handlers.GetIntentAnalytics)
+
+        // What the bot serves when it cannot answer
+        admin.PUT("/projects/:id/fallback", handlers.SetFallback)
+
+        // Bot instructions and their revisions
+        admin.GET("/projects/:id/instructions", handlers.GetInstructions)
+        admin.PUT("/projects/:id/instructions", handlers.SetInstructions)
+        admin.GET("/projects/:id/instructions/revisions/:rev", handlers.GetInstructionRevision)
+        admin.GET("/projects/:id/instructions/revisions/:rev/diff", handlers.DiffInstructionRevisions)
+        admin.POST("/projects/:id/instructions/revisions/:rev/comments", handlers.AddInstructionComment)
+
+        // Knowledge freshness
+        admin.GET("/projects/:id/freshness", handlers.GetKnowledgeFreshness)
+        admin.PUT("/projects/:id/freshness", handlers.SetKnowledgeFreshness)
+
+        // End-user blocklist
+        admin.GET("/projects/:id/blocks", handlers.GetProjectBlocks)
+        admin.POST("/projects/:id/blocks", handlers.CreateProjectBlock)
+        admin.DELETE("/projects/:id/blocks/:blockId", handlers.RevokeProjectBlock)
+
+        // Chat history archives
+        admin.GET("/projects/:id/archives", handlers.GetChatArchives)
+        admin.POST("/archives/:archiveId/restore", handlers.RestoreChatArchive)
+
+        // Platform operator tier: cross-tenant listings, global settings,
+        // quotas and billing, database management and impersonation
+        platform := admin.Group("")
+        platform.Use(middleware.SuperAdminAuth())
+        {
+            // Gemini quotas and billing; customers must not raise or reset their own
+            platform.PATCH("/projects/:id/gemini/limit", handlers.SetGeminiLimit)
+            platform.POST("/projects/:id/gemini/reset", handlers.ResetGeminiUsage)
+            platform.PUT("/projects/:id/gemini/monthly-limit", handlers.SetMonthlyGeminiLimit)
+            platform.POST("/projects/:id/gemini/reset-monthly", handlers.ResetMonthlyUsage)
+            platform.PUT("/projects/:id/billing-cycle", handlers.SetBillingCycleDay)
+            platform.PUT("/projects/:id/gemini/overage", handlers.SetOverage)
+
+            platform.GET("/projects", handlers.AdminProjects)
+            platform.GET("/projects/limits", handlers.GetProjectsWithLimits)
+            platform.PUT("/projects/:id/legal-hold", handlers.SetLegalHold)
+            platform.GET("/users", handlers.AdminUsers)
+            platform.POST("/users/:id/impersonate", handlers.ImpersonateUser)
+            platform.PUT("/users/:id/deletion-policy", handlers.SetUserDeletionPolicy)
+            platform.GET("/settings", handlers.AdminSettings)
+            platform.GET("/slo", handlers.GetSLOReport)
+            platform.GET("/search", handlers.AdminSearch)
+            platform.GET("/billing/overage", handlers.GetOverageCharges)
+            platform.POST("/billing/overage/:id/billed", handlers.MarkOverageBilled)
+            platform.PUT("/settings", handlers.UpdateSettings)
+            platform.GET("/audit-logs", handlers.GetAuditLogs)
+            platform.GET("/audit-logs/export", handlers.ExportAuditLogsNDJSON)
+            platform.GET("/message-redactions", handlers.GetMessageRedactions)
+            platform.GET("/events", handlers.GetChatEvents)
+            platform.GET("/warehouse", handlers.GetWarehouseExportStatus)
+            platform.POST("/ops/predeploy-check", handlers.PredeployCheck(predeployConfigChecks))
+            platform.GET("/notifications/export", handlers.ExportSecurityNotificationsNDJSON)
+
+            // ✅ NEW: Database management
+            platform.GET("/database/stats", func(c *gin.Context) {
+                stats := config.GetDetailedDatabaseStats()
+                c.JSON(http.StatusOK, gin.H{
+                    "success": true,
+                    "stats": stats,
+                })
             })
-        })
+            platform.GET("/database/tenants", handlers.GetTenantStorageStats)
+            platform.GET("/database/indexes", handlers.GetDatabaseIndexes)
+            platform.POST("/database/indexes/repair", handlers.RepairDatabaseIndexes)
+            platform.GET("/database/orphans", handlers.GetOrphanedData)
+            platform.POST("/database/orphans/repair", handlers.RepairOrphanedData)
+        }
     }
 
     // ===== USER ROUTES =====
     user := r.Group("/user")
-    user.Use(handlers.RateLimitMiddleware("general"))
+    user.Use(handlers.RateLimitMiddleware("general"), middleware.CacheControl("no-store"))
     user.Use(func(c *gin.Context) {
         if c.Request.Method == "OPTIONS" {
             c.Next()
@@ -349,24 +643,52 @@ func setupRoutes(r *gin.Engine) {
         user.POST("/chat/:id/message", handlers.RateLimitMiddleware("chat"), handlers.SendMessage)
         user.POST("/project/:id/upload", handlers.UploadPDF)
         user.GET("/notifications", handlers.GetNotifications)
+        user.GET("/sessions", handlers.GetUserSessions)
+        user.PUT("/password", handlers.ChangePassword)
+        user.GET("/profile", handlers.GetUserProfile)
+        user.PUT("/profile", handlers.UpdateUserProfile)
+        user.POST("/avatar", handlers.UploadAvatar)
+        user.DELETE("/avatar", handlers.DeleteAvatar)
         user.GET("/projects", handlers.UserProjects)
     }
 
-    // ✅ Public Chat History Route (without auth)
-    r.GET("/user/chat/:id/history", handlers.RateLimitMiddleware("general"), handlers.GetChatHistory)
+    // Public chat history, readable with the session's history token
+    r.GET("/user/chat/:id/history", handlers.RateLimitMiddleware("general"), middleware.CacheControl("private, no-cache"), middleware.ETag(), handlers.GetPublicChatHistory)
 
     // ===== CHAT ROUTES =====
     chat := r.Group("/chat")
-    chat.Use(handlers.RateLimitMiddleware("chat"))
+    chat.Use(handlers.RateLimitMiddleware("chat"), middleware.EmbedDomain(), middleware.ProjectAPIKey(), handlers.APIKeyUsage())
     {
-        chat.POST("/:projectId/message", handlers.IframeSendMessage)
-        chat.GET("/:projectId/history", handlers.GetChatHistory)
+        chat.POST("/:projectId/message", middleware.EmbedSignature(), handlers.IframeSendMessage)
+        chat.POST("/:projectId/message/stream", middleware.EmbedSignature(), handlers.IframeStreamMessage)
+        chat.GET("/:projectId/history", middleware.CacheControl("private, no-cache"), middleware.ETag(), handlers.GetPublicChatHistory)
         chat.POST("/:projectId/rate/:messageId", handlers.RateMessage)
+        chat.GET("/:projectId/ack", middleware.CacheControl("no-store"), handlers.GetMessageAcks)
+    }
+
+    // ===== API KEY ROUTES =====
+    // Server-to-server access with a project API key carrying each route's scope
+    v1 := r.Group("/v1/projects/:id")
+    v1.Use(handlers.RateLimitMiddleware("general"), middleware.CacheControl("no-store"))
+    {
+        history := v1.Group("", middleware.APIKeyAuth(models.APIScopeHistoryRead), handlers.APIKeyUsage())
+        history.GET("/chat/history", handlers.GetChatHistory)
+
+        reports := v1.Group("", middleware.APIKeyAuth(models.APIScopeAnalyticsRead), handlers.APIKeyUsage())
+        reports.GET("/chat/analytics", handlers.GetChatAnalytics)
+
+        webhooks := v1.Group("/webhooks", middleware.APIKeyAuth(models.APIScopeWebhooksManage), handlers.APIKeyUsage())
+        webhooks.POST("", handlers.CreateWebhook)
+        webhooks.GET("", handlers.ListWebhooks)
+        webhooks.PUT("/:webhookId", handlers.UpdateWebhook)
+        webhooks.DELETE("/:webhookId", handlers.DeleteWebhook)
+        webhooks.GET("/:webhookId/deliveries", handlers.ListWebhookDeliveries)
+        webhooks.POST("/:webhookId/deliveries/:deliveryId/redeliver", handlers.RedeliverWebhookDelivery)
     }
 
     // ===== PROJECT DASHBOARD ROUTES =====
     project := r.Group("/project")
-    project.Use(middleware.AdminAuth())
+    project.Use(middleware.AdminAuth(), middleware.TenantScope())
     {
         project.GET("/:id/dashboard", handlers.ProjectDashboard)
     }
@@ -407,13 +729,18 @@ func startNotificationCleanup() {
     defer ticker.Stop()
 
     // Run cleanup immediately on startup
-    if err := handlers.CleanupExpiredNotifications(); err != nil {
-        log.Printf("⚠️ Initial notification cleanup failed: %v", err)
+    if holdsLease("notification-cleanup", interval) {
+        if err := handlers.CleanupExpiredNotifications(); err != nil {
+            log.Printf("⚠️ Initial notification cleanup failed: %v", err)
+        }
     }
 
     for {
         select {
         case <-ticker.C:
+            if !holdsLease("notification-cleanup", interval) {
+                continue
+            }
             if err := handlers.CleanupExpiredNotifications(); err != nil {
                 log.Printf("⚠️ Notification cleanup failed: %v", err)
             } else {
@@ -434,6 +761,9 @@ func startMaintenanceTasks() {
     for {
         select {
         case <-ticker.C:
+            if !holdsLease("maintenance", 6*time.Hour) {
+                continue
+            }
             log.Println("🔧 Running periodic maintenance...")
             
             // Perform database maintenance
@@ -442,8 +772,229 @@ func startMaintenanceTasks() {
             } else {
                 log.Println("✅ Maintenance completed successfully")
             }
+
+            if err := handlers.CheckTenantStorageQuotas(); err != nil {
+                log.Printf("⚠️ Storage quota check failed: %v", err)
+            }
+
+            if err := handlers.CheckKnowledgeFreshness(); err != nil {
+                log.Printf("⚠️ Knowledge freshness check failed: %v", err)
+            }
+
+            if err := handlers.SendOnboardingNudges(); err != nil {
+                log.Printf("⚠️ Onboarding nudges failed: %v", err)
+            }
+        }
+    }
+}
+
+// startUsageResets resets monthly usage as each project's billing period
+// rolls over. Periods start at midnight UTC, so an hourly check is enough.
+func startUsageResets() {
+    ticker := time.NewTicker(time.Hour)
+    defer ticker.Stop()
+
+    for {
+        if holdsLease("usage-reset", time.Hour) {
+            ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+            n, err := repository.ResetDueMonthlyUsage(ctx, time.Now())
+            cancel()
+            if err != nil {
+                log.Printf("⚠️ Monthly usage reset failed: %v", err)
+            } else if n > 0 {
+                log.Printf("🔄 Reset monthly usage for %d project(s)", n)
+            }
         }
+        <-ticker.C
+    }
+}
+
+// startUserDeletions picks up user deletions left behind by a restart or a
+// replica that went away. New deletions start as soon as they are queued.
+func startUserDeletions() {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    for {
+        handlers.ProcessUserDeletions()
+        <-ticker.C
+    }
+}
+
+// startUploadBatches picks up archive uploads left behind by a restart or a
+// replica that went away. New uploads start as soon as they are queued.
+func startUploadBatches() {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    for {
+        handlers.ProcessUploadBatches()
+        <-ticker.C
+    }
+}
+
+// startWebhookDeliveries retries failed webhook deliveries when they are
+// due, and picks up first attempts left behind by a restart
+func startWebhookDeliveries() {
+    ticker := time.NewTicker(30 * time.Second)
+    defer ticker.Stop()
+
+    for {
+        handlers.ProcessWebhookDeliveries()
+        <-ticker.C
+    }
+}
+
+// startEscalationSLAs alerts teams to escalated sessions nobody responded
+// to in time
+func startEscalationSLAs() {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        if err := handlers.CheckEscalationSLAs(); err != nil {
+            log.Printf("⚠️ Escalation SLA check failed: %v", err)
+        }
+    }
+}
+
+// startAPIKeyUsage writes API key usage every minute and, once an hour,
+// alerts owners to keys whose usage changed drastically
+func startAPIKeyUsage() {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    var checked time.Time
+    for range ticker.C {
+        if err := handlers.FlushAPIKeyUsage(); err != nil {
+            log.Printf("⚠️ Failed to flush API key usage: %v", err)
+        }
+        // Checked a few minutes into the hour, once every replica has
+        // flushed the one before
+        now := time.Now()
+        hour := now.Truncate(time.Hour)
+        if now.Sub(hour) < 5*time.Minute || !hour.After(checked) {
+            continue
+        }
+        checked = hour
+        if holdsLease("api-key-anomalies", time.Hour) {
+            if err := handlers.CheckAPIKeyAnomalies(); err != nil {
+                log.Printf("⚠️ API key anomaly check failed: %v", err)
+            }
+        }
+    }
+}
+
+// startSIEMForwarder ships new audit log entries and security notifications
+// to the SIEM forwarder configured with SIEM_FORWARD, if any
+func startSIEMForwarder() {
+    if config.SIEMSettings.Forwarder == "" {
+        return
+    }
+    interval := config.SIEMSettings.Interval
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        if !holdsLease("siem-forward", interval) {
+            continue
+        }
+        if err := handlers.ForwardSecurityEvents(); err != nil {
+            log.Printf("⚠️ SIEM forwarding failed: %v", err)
+        }
+    }
+}
+
+// startEventBusPublisher publishes new chat events to the event bus
+// configured with EVENT_BUS_DRIVER, if any
+func startEventBusPublisher() {
+    if config.EventBusSettings.Driver == "" {
+        return
+    }
+    interval := config.EventBusSettings.Interval
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        if !holdsLease("event-bus-publish", interval) {
+            continue
+        }
+        if err := handlers.PublishChatEvents(); err != nil {
+            log.Printf("⚠️ Event bus publishing failed: %v", err)
+        }
+    }
+}
+
+// startWarehouseExporter exports daily aggregates to the warehouse
+// configured with WAREHOUSE_DRIVER, if any
+func startWarehouseExporter() {
+    if config.WarehouseSettings.Driver == "" {
+        return
+    }
+    interval := config.WarehouseSettings.Interval
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        if !holdsLease("warehouse-export", interval) {
+            continue
+        }
+        if err := handlers.ExportWarehouseTables(); err != nil {
+            log.Printf("⚠️ Warehouse export failed: %v", err)
+        }
+    }
+}
+
+// startSLOSummaries sends the weekly SLO summary once per ISO week. The
+// per-week lease keeps other replicas from sending it too.
+func startSLOSummaries() {
+    ticker := time.NewTicker(time.Hour)
+    defer ticker.Stop()
+
+    sent := ""
+    for range ticker.C {
+        year, week := time.Now().ISOWeek()
+        job := fmt.Sprintf("slo-summary-%d-W%02d", year, week)
+        if job == sent {
+            continue
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        ok, err := config.AcquireLease(ctx, job, 8*24*time.Hour)
+        cancel()
+        if err != nil {
+            log.Printf("⚠️ Could not acquire %s lease: %v", job, err)
+            continue
+        }
+        sent = job
+        if !ok {
+            continue
+        }
+
+        if err := handlers.SendWeeklySLOSummary(); err != nil {
+            log.Printf("⚠️ Weekly SLO summary failed: %v", err)
+        } else {
+            log.Println("📈 Weekly SLO summary sent")
+        }
+    }
+}
+
+// holdsLease reports whether this replica should run a periodic job this
+// round. The lease is a little shorter than the interval so the next tick on
+// any replica can take over if the holder goes away.
+func holdsLease(job string, interval time.Duration) bool {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    ok, err := config.AcquireLease(ctx, job, interval*9/10)
+    if err != nil {
+        log.Printf("⚠️ Could not acquire %s lease, skipping this run: %v", job, err)
+        return false
+    }
+    if !ok {
+        log.Printf("⏭️ %s is running on another replica", job)
     }
+    return ok
 }
 
 // ✅ NEW: Helper function to get notification status
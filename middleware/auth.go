@@ -40,6 +40,15 @@ func AdminAuth() gin.HandlerFunc {
             return
         }
         
+        if mustChange, _ := claims["must_change_password"].(bool); mustChange {
+            c.JSON(http.StatusForbidden, gin.H{
+                "error": "Password change required",
+                "message": "Change your password at /user/password before continuing",
+            })
+            c.Abort()
+            return
+        }
+        
         isAdmin, ok := claims["is_admin"].(bool)
         if !ok || !isAdmin {
             c.JSON(http.StatusForbidden, gin.H{
@@ -51,8 +60,41 @@ func AdminAuth() gin.HandlerFunc {
         }
         
         // Set user info in context
+        isSuperAdmin, _ := claims["is_super_admin"].(bool)
+        role, _ := claims["role"].(string)
+        if role == "" {
+            // Tokens issued before roles were added only carry the flags
+            role = "admin"
+            if isSuperAdmin {
+                role = "super_admin"
+            }
+        }
         c.Set("user_id", claims["user_id"])
+        c.Set("role", role)
         c.Set("is_admin", true)
+        c.Set("is_super_admin", isSuperAdmin)
+        
+        c.Next()
+    }
+}
+
+// SuperAdminAuth restricts a route to platform operators. It must run after
+// AdminAuth, which parses the token and sets is_super_admin in the context.
+func SuperAdminAuth() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.Request.Method == "OPTIONS" {
+            c.Next()
+            return
+        }
+        
+        if !c.GetBool("is_super_admin") {
+            c.JSON(http.StatusForbidden, gin.H{
+                "error": "Access denied",
+                "message": "Super-admin privileges required",
+            })
+            c.Abort()
+            return
+        }
         
         c.Next()
     }
@@ -83,6 +125,17 @@ func UserAuth() gin.HandlerFunc {
             return
         }
         
+        // A session limited to the forced password change can do nothing else
+        if mustChange, _ := claims["must_change_password"].(bool); mustChange &&
+            !(c.Request.Method == http.MethodPut && c.FullPath() == "/user/password") {
+            c.JSON(http.StatusForbidden, gin.H{
+                "error": "Password change required",
+                "message": "Change your password at /user/password before continuing",
+            })
+            c.Abort()
+            return
+        }
+        
         c.Set("user_id", claims["user_id"])
         c.Next()
     }
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"jevi-chat/config"
+)
+
+// Chaos delays a share of requests when fault injection is enabled (see
+// config.ChaosConfig). Gemini and Mongo faults are injected where those
+// clients are obtained, not here.
+func Chaos() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if config.ChaosApplies(c.Request.URL.Path) && config.ChaosDelay() {
+			c.Header("X-Chaos-Delay", config.Chaos.Latency.String())
+			select {
+			case <-time.After(config.Chaos.Latency):
+			case <-c.Request.Context().Done():
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Private and loopback ranges, where a platform load balancer sits when the
+// app runs behind one (Render, Docker, Kubernetes)
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+// ConfigureClientIP makes c.ClientIP() return the real visitor address, so
+// rate limiting, usage logs, blocks and audit entries all agree on it.
+//
+//   - TRUSTED_PROXIES: comma-separated IPs/CIDRs whose X-Forwarded-For is
+//     believed; "none" trusts no proxy. Defaults to private ranges. Headers
+//     from any other peer are ignored, so visitors cannot spoof their IP.
+//   - TRUSTED_PLATFORM: "cloudflare", "google", "flyio" or a header name.
+//     That header is trusted unconditionally, so only set it when every
+//     request passes through the platform.
+//   - REMOTE_IP_HEADERS: headers read from trusted proxies, in order.
+//     Defaults to X-Forwarded-For, X-Real-IP.
+func ConfigureClientIP(r *gin.Engine) error {
+	proxies := defaultTrustedProxies
+	if v := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES")); v != "" {
+		proxies = splitList(v)
+		if v == "none" {
+			proxies = nil
+		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return err
+	}
+
+	switch platform := strings.TrimSpace(os.Getenv("TRUSTED_PLATFORM")); strings.ToLower(platform) {
+	case "":
+	case "cloudflare":
+		r.TrustedPlatform = gin.PlatformCloudflare
+	case "google", "appengine":
+		r.TrustedPlatform = gin.PlatformGoogleAppEngine
+	case "flyio", "fly":
+		r.TrustedPlatform = gin.PlatformFlyIO
+	default:
+		r.TrustedPlatform = platform
+	}
+
+	if v := os.Getenv("REMOTE_IP_HEADERS"); v != "" {
+		r.RemoteIPHeaders = splitList(v)
+	}
+
+	log.Printf("🌐 Client IP: trusted proxies %v, platform header %q", proxies, r.TrustedPlatform)
+	return nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
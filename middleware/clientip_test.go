@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConfigureClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		proxies  string
+		platform string
+		peer     string
+		headers  map[string]string
+		want     string
+	}{
+		{
+			name: "untrusted peer with forged X-Forwarded-For",
+			peer: "203.0.113.9", headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want: "203.0.113.9",
+		},
+		{
+			name: "untrusted peer with forged X-Real-IP",
+			peer: "203.0.113.9", headers: map[string]string{"X-Real-IP": "1.2.3.4"},
+			want: "203.0.113.9",
+		},
+		{
+			name: "trusted private proxy",
+			peer: "10.0.0.5", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want: "198.51.100.7",
+		},
+		{
+			name: "multiple trusted hops",
+			peer: "10.0.0.5", headers: map[string]string{"X-Forwarded-For": "198.51.100.7, 10.0.0.3, 192.168.1.2"},
+			want: "198.51.100.7",
+		},
+		{
+			name: "spoofed hop before the first untrusted address",
+			peer: "10.0.0.5", headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.0.0.3"},
+			want: "198.51.100.7",
+		},
+		{
+			name: "X-Real-IP from trusted proxy",
+			peer: "10.0.0.5", headers: map[string]string{"X-Real-IP": "198.51.100.8"},
+			want: "198.51.100.8",
+		},
+		{
+			name:    "trusted proxy CIDR",
+			proxies: "203.0.113.0/24",
+			peer:    "203.0.113.9", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want: "198.51.100.7",
+		},
+		{
+			name:    "private peer outside configured CIDR",
+			proxies: "203.0.113.0/24",
+			peer:    "10.0.0.5", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want: "10.0.0.5",
+		},
+		{
+			name:    "no trusted proxies",
+			proxies: "none",
+			peer:    "10.0.0.5", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want: "10.0.0.5",
+		},
+		{
+			name: "malformed header",
+			peer: "10.0.0.5", headers: map[string]string{"X-Forwarded-For": "not-an-ip"},
+			want: "10.0.0.5",
+		},
+		{
+			name: "malformed hop in header",
+			peer: "10.0.0.5", headers: map[string]string{"X-Forwarded-For": "198.51.100.7, garbage"},
+			want: "10.0.0.5",
+		},
+		{
+			name:     "trusted platform header",
+			platform: "cloudflare",
+			peer:     "203.0.113.9", headers: map[string]string{"CF-Connecting-IP": "198.51.100.9", "X-Forwarded-For": "1.2.3.4"},
+			want: "198.51.100.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.proxies)
+			t.Setenv("TRUSTED_PLATFORM", tt.platform)
+			t.Setenv("REMOTE_IP_HEADERS", "")
+
+			r := gin.New()
+			if err := ConfigureClientIP(r); err != nil {
+				t.Fatalf("ConfigureClientIP: %v", err)
+			}
+			r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			req.RemoteAddr = tt.peer + ":41234"
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigureClientIPRejectsInvalidProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/99")
+	if err := ConfigureClientIP(gin.New()); err == nil {
+		t.Error("ConfigureClientIP accepted an invalid CIDR")
+	}
+}
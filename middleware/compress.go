@@ -0,0 +1,256 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// Responses smaller than this are sent as-is; compressing them costs more
+// than it saves
+const minCompressSize = 512
+
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"text/javascript":        true,
+	"text/css":               true,
+	"text/html":              true,
+	"text/plain":             true,
+	"image/svg+xml":          true,
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var (
+	gzipPool   = sync.Pool{New: func() interface{} { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	brotliPool = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, 5) }}
+)
+
+// Compress encodes text responses with Brotli or gzip, whichever the
+// client prefers to accept. Server-sent events, range responses and bodies
+// that already carry an encoding pass through untouched.
+func Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.close()
+
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks br over gzip unless the client rules one out with q=0
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		accepted[name] = q > 0
+	}
+	switch {
+	case accepted["br"]:
+		return "br"
+	case accepted["gzip"]:
+		return "gzip"
+	}
+	return ""
+}
+
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	enc      encoder
+	decided  bool
+}
+
+// decide runs at the first write, once the handler has set its headers
+func (w *compressWriter) decide() {
+	w.decided = true
+
+	h := w.Header()
+	status := w.Status()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusPartialContent || status == http.StatusNotModified {
+		return
+	}
+	if h.Get("Content-Encoding") != "" {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if !compressibleTypes[mediaType] {
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+		return
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The encoded bytes differ from what a strong ETag describes
+		h.Set("ETag", "W/"+etag)
+	}
+
+	if w.encoding == "br" {
+		w.enc = brotliPool.Get().(encoder)
+	} else {
+		w.enc = gzipPool.Get().(encoder)
+	}
+	w.enc.Reset(w.ResponseWriter)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decide()
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	if !w.Written() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	return w.enc.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) close() {
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	w.enc.Reset(io.Discard)
+	if w.encoding == "br" {
+		brotliPool.Put(w.enc)
+	} else {
+		gzipPool.Put(w.enc)
+	}
+	w.enc = nil
+}
+
+// ETag buffers successful GET responses, tags them with a hash of the body
+// and answers a matching If-None-Match with 304 Not Modified, so an
+// unchanged chat history or config costs no body on the wire.
+func ETag() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		w := &etagWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.Status() != http.StatusOK || c.Writer.Written() {
+			w.flushTo(c.Writer)
+			return
+		}
+
+		sum := sha256.Sum256(w.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		c.Header("ETag", etag)
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Writer.Header().Del("Content-Length")
+			c.Status(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		w.flushTo(c.Writer)
+	}
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter holds the body back until the handler is done
+type etagWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *etagWriter) WriteHeaderNow() {}
+
+func (w *etagWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *etagWriter) Written() bool {
+	return w.body.Len() > 0 || w.status != 0
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// Flush is a no-op: streaming through a buffer is not possible
+func (w *etagWriter) Flush() {}
+
+func (w *etagWriter) flushTo(dst gin.ResponseWriter) {
+	dst.WriteHeader(w.Status())
+	dst.Write(w.body.Bytes())
+}
+
+// CacheControl sets the Cache-Control header for a route group
+func CacheControl(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", value)
+		c.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// CORSGroup is the CORS policy for the routes under some path prefixes
+type CORSGroup struct {
+	Prefixes []string
+	Config   cors.Config
+}
+
+// matches reports whether path is one of the prefixes or below one
+func (g CORSGroup) matches(path string) bool {
+	for _, p := range g.Prefixes {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS answers each request with the policy of the first group whose
+// prefixes cover its path, and with fallback otherwise. It has to run as
+// global middleware: preflight requests match no route, so middleware on
+// a route group would never see them.
+func CORS(groups []CORSGroup, fallback cors.Config) gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, len(groups))
+	for i, g := range groups {
+		handlers[i] = cors.New(g.Config)
+	}
+	fallbackHandler := cors.New(fallback)
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		for i, g := range groups {
+			if g.matches(path) {
+				handlers[i](c)
+				return
+			}
+		}
+		fallbackHandler(c)
+	}
+}
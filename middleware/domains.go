@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// widgetProject loads the widget access settings of the route's project
+// once per request, for the middleware guarding embed and chat routes. It
+// returns nil for an invalid ID or an unknown project, which the handler
+// reports.
+func widgetProject(c *gin.Context) *models.Project {
+	if cached, ok := c.Get("widget_project"); ok {
+		return cached.(*models.Project)
+	}
+
+	var project *models.Project
+	if objID, err := primitive.ObjectIDFromHex(c.Param("projectId")); err == nil {
+		var p models.Project
+		err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+			options.FindOne().SetProjection(bson.M{"api_keys": 1, "allowed_domains": 1}),
+		).Decode(&p)
+		if err == nil {
+			project = &p
+		}
+	}
+	c.Set("widget_project", project)
+	return project
+}
+
+// NormalizeDomain turns an allowlist entry such as "https://Shop.example.com/"
+// into "shop.example.com". A leading "*." allows every subdomain, and a port
+// may be given for development hosts.
+func NormalizeDomain(domain string) (string, error) {
+	d := strings.ToLower(strings.TrimSpace(domain))
+	if i := strings.Index(d, "://"); i >= 0 {
+		d = d[i+3:]
+	}
+	if i := strings.IndexAny(d, "/?#"); i >= 0 {
+		d = d[:i]
+	}
+	d = strings.TrimSuffix(d, ".")
+
+	host := strings.TrimPrefix(d, "*.")
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if port == "" || strings.Trim(port, "0123456789") != "" {
+			return "", fmt.Errorf("invalid port in %q", domain)
+		}
+		host = h
+	}
+	if host == "" || strings.Contains(host, "*") || strings.Trim(host, "abcdefghijklmnopqrstuvwxyz0123456789.-") != "" ||
+		strings.HasPrefix(host, ".") || strings.Contains(host, "..") {
+		return "", fmt.Errorf("invalid domain %q", domain)
+	}
+	return d, nil
+}
+
+// DomainAllowed reports whether a page on host (hostname with an optional
+// port) may embed the widget under the allowlist. Entries without a port
+// match any port.
+func DomainAllowed(domains []string, host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	for _, d := range domains {
+		target := hostname
+		if _, _, err := net.SplitHostPort(strings.TrimPrefix(d, "*.")); err == nil {
+			target = host
+		}
+		if strings.HasPrefix(d, "*.") {
+			if strings.HasSuffix(target, d[1:]) {
+				return true
+			}
+		} else if target == d {
+			return true
+		}
+	}
+	return false
+}
+
+// frameAncestors is the CSP sent with a project's widget routes: the
+// project's allowlist, or any site when it has none
+func frameAncestors(domains []string) string {
+	if len(domains) == 0 {
+		return "frame-ancestors *"
+	}
+	return "frame-ancestors 'self' " + strings.Join(domains, " ")
+}
+
+// ownHost reports whether host is this server, whose own widget pages call
+// the API from inside the iframe
+func ownHost(c *gin.Context, host string) bool {
+	if strings.EqualFold(host, c.Request.Host) {
+		return true
+	}
+	if appURL, err := url.Parse(os.Getenv("APP_URL")); err == nil && appURL.Host != "" {
+		return strings.EqualFold(host, appURL.Host)
+	}
+	return false
+}
+
+// EmbedDomain restricts a project's widget routes to the sites on its
+// allowlist. The calling page is taken from Origin, or Referer for page
+// loads. A request with neither (or Origin "null", as from a sandboxed
+// iframe with no referrer) cannot be placed, so it only passes with one of
+// the project's secret API keys, which server-side clients hold and pages
+// do not. Framing from other sites is also stopped by the frame-ancestors
+// policy set here. Projects without an allowlist pass through.
+func EmbedDomain() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+
+		project := widgetProject(c)
+		if project == nil {
+			c.Next()
+			return
+		}
+		c.Header("Content-Security-Policy", frameAncestors(project.AllowedDomains))
+		if len(project.AllowedDomains) == 0 {
+			c.Next()
+			return
+		}
+
+		source := c.GetHeader("Origin")
+		if source == "" || source == "null" {
+			source = c.GetHeader("Referer")
+		}
+		if source == "" {
+			presented := c.GetHeader(HeaderAPIKey)
+			if presented == "" {
+				presented = c.Query("key")
+			}
+			if key := matchAPIKey(project, presented); key != nil && key.Secret() {
+				c.Next()
+				return
+			}
+		}
+		u, err := url.Parse(source)
+		if err == nil && u.Host != "" && (ownHost(c, u.Host) || DomainAllowed(project.AllowedDomains, strings.ToLower(u.Host))) {
+			c.Next()
+			return
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "This site is not allowed to embed the chat widget", "code": "domain_not_allowed"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/models"
+)
+
+func TestEmbedDomain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	project := &models.Project{
+		ID:             primitive.NewObjectID(),
+		AllowedDomains: []string{"shop.example.com"},
+		APIKeys: []models.ProjectAPIKey{
+			{ID: "pk", Key: "pk_widget"},
+			{ID: "sk", KeyHash: models.HashAPIKey("sk_server"), Scopes: []string{models.APIScopeChatWrite, models.APIScopeHistoryRead}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "allowed origin", headers: map[string]string{"Origin": "https://shop.example.com"}, want: http.StatusOK},
+		{name: "other origin", headers: map[string]string{"Origin": "https://evil.example"}, want: http.StatusForbidden},
+		{name: "allowed referer", headers: map[string]string{"Referer": "https://shop.example.com/cart"}, want: http.StatusOK},
+		{name: "null origin with allowed referer", headers: map[string]string{"Origin": "null", "Referer": "https://shop.example.com/"}, want: http.StatusOK},
+		{name: "null origin without referer", headers: map[string]string{"Origin": "null"}, want: http.StatusForbidden},
+		{name: "no origin or referer", want: http.StatusForbidden},
+		{name: "no origin with publishable key", headers: map[string]string{HeaderAPIKey: "pk_widget"}, want: http.StatusForbidden},
+		{name: "null origin with publishable key", headers: map[string]string{"Origin": "null", HeaderAPIKey: "pk_widget"}, want: http.StatusForbidden},
+		{name: "no origin with secret key", headers: map[string]string{HeaderAPIKey: "sk_server"}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/embed/:projectId/config", func(c *gin.Context) {
+				c.Set("widget_project", project)
+			}, EmbedDomain(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/embed/"+project.ID.Hex()+"/config", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
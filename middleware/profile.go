@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderProfile selects a response profile, like ?profile=
+const HeaderProfile = "X-Jevi-Profile"
+
+// ResponseProfile trims JSON responses for constrained clients
+type ResponseProfile struct {
+	// DropFields are removed wherever they appear in the response
+	DropFields map[string]bool
+	// Longer strings are cut to MaxString characters
+	MaxString int
+	// Paging parameters (limit, per_page, page_size) are capped at
+	// MaxPageSize and default to it
+	MaxPageSize int
+}
+
+// responseProfiles are the profiles a client can ask for
+var responseProfiles = map[string]ResponseProfile{
+	// compact serves the mobile admin app: lists and summaries without
+	// metadata, audit details, citations and other heavy fields
+	"compact": {
+		DropFields: map[string]bool{
+			"metadata":            true,
+			"details":             true,
+			"citations":           true,
+			"knowledge_freshness": true,
+			"filter_applied":      true,
+			"user_agent":          true,
+			"headers":             true,
+			"snippet":             true,
+			"pdf_files":           true,
+			"source_files":        true,
+		},
+		MaxString:   280,
+		MaxPageSize: 20,
+	},
+}
+
+var pagingParams = []string{"limit", "per_page", "page_size"}
+
+// ResponseShaping applies the response profile a client asks for with
+// ?profile= or X-Jevi-Profile. Handlers need not know about profiles: page
+// sizes are capped on the way in and successful JSON responses are trimmed
+// on the way out. Event streams are left alone.
+func ResponseShaping() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Query("profile")
+		if name == "" {
+			name = c.GetHeader(HeaderProfile)
+		}
+		profile, ok := responseProfiles[name]
+		if !ok || strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			c.Next()
+			return
+		}
+		c.Set("response_profile", name)
+		c.Writer.Header().Add("Vary", HeaderProfile)
+
+		query := c.Request.URL.Query()
+		for _, param := range pagingParams {
+			if n, err := strconv.Atoi(query.Get(param)); err == nil && n > 0 && n < profile.MaxPageSize {
+				continue
+			}
+			if query.Has(param) || param == "limit" {
+				query.Set(param, strconv.Itoa(profile.MaxPageSize))
+			}
+		}
+		c.Request.URL.RawQuery = query.Encode()
+
+		w := &etagWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		mediaType, _, _ := mime.ParseMediaType(c.Writer.Header().Get("Content-Type"))
+		if w.Status() < 200 || w.Status() >= 300 || mediaType != "application/json" {
+			w.flushTo(c.Writer)
+			return
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(w.body.Bytes()))
+		decoder.UseNumber()
+		var body interface{}
+		if err := decoder.Decode(&body); err != nil {
+			w.flushTo(c.Writer)
+			return
+		}
+		shaped, err := json.Marshal(profile.shape(body))
+		if err != nil {
+			w.flushTo(c.Writer)
+			return
+		}
+
+		c.Writer.Header().Del("Content-Length")
+		c.Writer.Header().Set(HeaderProfile, name)
+		c.Writer.WriteHeader(w.Status())
+		c.Writer.Write(shaped)
+	}
+}
+
+// shape trims a decoded JSON value
+func (p ResponseProfile) shape(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if p.DropFields[key] {
+				delete(value, key)
+				continue
+			}
+			value[key] = p.shape(field)
+		}
+		return value
+	case []interface{}:
+		for i := range value {
+			value[i] = p.shape(value[i])
+		}
+		return value
+	case string:
+		if p.MaxString > 0 && len(value) > p.MaxString {
+			runes := []rune(value)
+			if len(runes) > p.MaxString {
+				return string(runes[:p.MaxString]) + "…"
+			}
+		}
+		return value
+	}
+	return v
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadOnlyRoutes limits a read-only deployment to the given routes, named by
+// their registered patterns (such as "/admin/projects/:id/gemini/daily"),
+// and to reads. Everything else is answered before its handler runs, so
+// nothing reaches the database that could write.
+func ReadOnlyRoutes(routes []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		allowed[r] = true
+	}
+	return func(c *gin.Context) {
+		if !allowed[c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error":   "Route not found",
+				"message": "This deployment only serves read-only analytics endpoints",
+				"path":    c.Request.URL.Path,
+			})
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+		default:
+			c.AbortWithStatusJSON(http.StatusMethodNotAllowed, gin.H{
+				"error":   "Method not allowed",
+				"message": "This deployment is read-only",
+			})
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+)
+
+// Signed widget requests carry these headers
+const (
+	HeaderSigningToken = "X-Jevi-Token"
+	HeaderTimestamp    = "X-Jevi-Timestamp"
+	HeaderNonce        = "X-Jevi-Nonce"
+	HeaderSignature    = "X-Jevi-Signature"
+)
+
+// signatureMaxSkew bounds how old (or early) a signed request may be. Nonces
+// are kept longer than this, so a replay inside the window is always caught.
+const signatureMaxSkew = 5 * time.Minute
+
+// SignaturePayload builds the string a widget request signature covers:
+//
+//	token \n timestamp \n nonce \n METHOD \n path \n hex(sha256(body))
+func SignaturePayload(token, timestamp, nonce, method, path string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return token + "\n" + timestamp + "\n" + nonce + "\n" + method + "\n" + path + "\n" + hex.EncodeToString(bodyHash[:])
+}
+
+// SignPayload returns the hex HMAC-SHA256 of payload under secret
+func SignPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EmbedSignature verifies HMAC-signed widget messages for projects that
+// require signing. Projects without signing enabled pass through untouched.
+func EmbedSignature() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+
+		objID, err := primitive.ObjectIDFromHex(c.Param("projectId"))
+		if err != nil {
+			// Let the handler report the invalid ID
+			c.Next()
+			return
+		}
+
+		var project struct {
+			SigningRequired bool   `bson:"signing_required"`
+			PublicToken     string `bson:"signing_public_token"`
+			Secret          string `bson:"signing_secret"`
+		}
+		err = config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": objID},
+			options.FindOne().SetProjection(bson.M{
+				"signing_required":     1,
+				"signing_public_token": 1,
+				"signing_secret":       1,
+			}),
+		).Decode(&project)
+		if err != nil || !project.SigningRequired {
+			c.Next()
+			return
+		}
+
+		reject := func(reason string) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid request signature", "details": reason})
+			c.Abort()
+		}
+
+		token := c.GetHeader(HeaderSigningToken)
+		timestamp := c.GetHeader(HeaderTimestamp)
+		nonce := c.GetHeader(HeaderNonce)
+		signature := c.GetHeader(HeaderSignature)
+		if token == "" || timestamp == "" || nonce == "" || signature == "" {
+			reject("missing signature headers")
+			return
+		}
+		if project.Secret == "" || !hmac.Equal([]byte(token), []byte(project.PublicToken)) {
+			reject("unknown signing token")
+			return
+		}
+		if len(nonce) < 16 || len(nonce) > 128 {
+			reject("nonce must be 16-128 characters")
+			return
+		}
+
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			reject("malformed timestamp")
+			return
+		}
+		if skew := time.Since(time.Unix(ts, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+			reject("timestamp outside allowed window")
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			reject("unreadable body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		payload := SignaturePayload(token, timestamp, nonce, c.Request.Method, c.Request.URL.Path, body)
+		expected := SignPayload(project.Secret, payload)
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			reject("signature mismatch")
+			return
+		}
+
+		// Only a correctly signed request may consume its nonce
+		_, err = config.GetCollection("request_nonces").InsertOne(context.Background(), bson.M{
+			"project_id": objID,
+			"nonce":      nonce,
+			"created_at": time.Now(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			reject("replayed request")
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify request"})
+			c.Abort()
+			return
+		}
+
+		c.Set("request_signed", true)
+		c.Next()
+	}
+}
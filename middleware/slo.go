@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"jevi-chat/config"
+)
+
+// SLO measures each request against its route group's objective. Register
+// it outside gin.Recovery so recovered panics count as the 500s they become.
+func SLO() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		if c.Request.Method == http.MethodOptions {
+			return
+		}
+		if group := config.RouteGroup(c.Request.URL.Path); group != "" {
+			config.RecordRequest(group, c.Writer.Status(), time.Since(start))
+		}
+	}
+}
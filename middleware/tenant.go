@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+)
+
+// tenantAdminRoutes are the routes outside a project's that a tenant admin
+// may reach; their handlers scope what they return to the caller.
+// Everything else spans tenants and is left to super-admins.
+var tenantAdminRoutes = map[string]bool{
+	"GET /admin/":                            true,
+	"GET /admin/dashboard":                   true,
+	"POST /admin/projects":                   true,
+	"POST /admin/library":                    true,
+	"GET /admin/library":                     true,
+	"DELETE /admin/library/:docId":           true,
+	"GET /admin/notifications":               true,
+	"DELETE /admin/notifications/:id":        true,
+	"PUT /admin/notifications/:id/archive":   true,
+	"PUT /admin/notifications/:id/unarchive": true,
+	"POST /admin/notifications/archive":      true,
+	"GET /api/notifications":                 true,
+	"GET /api/notifications/stream":          true,
+	"PUT /api/notifications/:id/read":        true,
+	"PUT /api/notifications/read-all":        true,
+	"PUT /api/notifications/:id/archive":     true,
+	"PUT /api/notifications/:id/unarchive":   true,
+	"POST /api/notifications/archive":        true,
+	"DELETE /api/notifications/:id":          true,
+	"GET /api/user/profile":                  true,
+	"PUT /api/user/profile":                  true,
+	"POST /api/user/avatar":                  true,
+	"DELETE /api/user/avatar":                true,
+	"GET /api/user/projects":                 true,
+}
+
+// TenantScope keeps tenant admins inside their own tenant. It must run after
+// AdminAuth. Super-admins pass through; a tenant admin reaches a project's
+// routes only for projects they own, their own account under
+// /admin/users/:id, and tenantAdminRoutes.
+func TenantScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "OPTIONS" || c.GetBool("is_super_admin") {
+			c.Next()
+			return
+		}
+
+		path := c.FullPath()
+		switch {
+		case tenantAdminRoutes[c.Request.Method+" "+path]:
+			c.Next()
+			return
+		case strings.HasPrefix(path, "/admin/projects/:id"), strings.HasPrefix(path, "/api/projects/:id"),
+			strings.HasPrefix(path, "/project/:id/"):
+			if !OwnsProject(c, c.Param("id")) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		case path == "/admin/users/:id" && (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodPut):
+			if c.Param("id") == c.GetString("user_id") {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Access denied",
+			"message": "Super-admin privileges required",
+		})
+		c.Abort()
+	}
+}
+
+// OwnsProject reports whether the caller owns the project with the given ID
+func OwnsProject(c *gin.Context, projectID string) bool {
+	projectObjID, err := primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return false
+	}
+	userObjID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	n, err := config.GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": projectObjID, "user_id": userObjID})
+	return err == nil && n > 0
+}
@@ -1,8 +1,13 @@
 package models
 
 import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
     "fmt"
+    "path"
     "time"
+    "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -16,22 +21,84 @@ type User struct {
     Role      string             `bson:"role" json:"role"`
     CreatedAt time.Time          `bson:"created_at" json:"created_at"`
     UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+    
+    // Set on seeded accounts; the user must pick a new password before
+    // anything else
+    MustChangePassword bool      `bson:"must_change_password,omitempty" json:"must_change_password,omitempty"`
+
+    // Profile preferences. Timezone is an IANA name and Locale one of
+    // SupportedLocales; both fall back to UTC and English when empty.
+    Timezone          string     `bson:"timezone,omitempty" json:"timezone,omitempty"`
+    Locale            string     `bson:"locale,omitempty" json:"locale,omitempty"`
+    AvatarKey         string     `bson:"avatar_key,omitempty" json:"-"`
+    AvatarContentType string     `bson:"avatar_content_type,omitempty" json:"-"`
+
+    // What happens to the user's projects when the account is deleted; one
+    // of the DeletionPolicy values, empty for the platform default
+    DeletionPolicy    string     `bson:"deletion_policy,omitempty" json:"deletion_policy,omitempty"`
+
+    // Notification types the user has opted in to receive by email, from
+    // EmailNotificationTypes. Security notices are always emailed.
+    EmailNotifications []string  `bson:"email_notifications,omitempty" json:"email_notifications,omitempty"`
 }
 
+// DefaultLocale is used when a user has not picked a supported locale
+const DefaultLocale = "en"
+
+// SupportedLocales are the languages notification templates exist in
+var SupportedLocales = []string{"en", "es", "fr", "de", "hi"}
+
 // ChatUser represents users who interact with embed chat widgets
 type ChatUser struct {
     ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-    ProjectID string             `bson:"project_id" json:"project_id"`
+    ProjectID primitive.ObjectID `bson:"project_id" json:"project_id"` // legacy rows hold a hex string until backfilled
     Name      string             `bson:"name" json:"name"`
     Email     string             `bson:"email" json:"email"`
     Password  string             `bson:"password" json:"-"`
     CreatedAt time.Time          `bson:"created_at" json:"created_at"`
     IsActive  bool               `bson:"is_active" json:"is_active"`
+
+    // Set when the user asked not to have their earlier sessions listed
+    // on other devices
+    HideSessionHistory bool      `bson:"hide_session_history,omitempty" json:"hide_session_history,omitempty"`
+}
+
+// ChatUserToken is a widget sign-in token: a signed JWT whose ID is this
+// record's, so it can be revoked before it expires. Only its hash is
+// stored, with hashes of the IP range and user agent it was issued to, so
+// reuse from a very different client can be spotted.
+type ChatUserToken struct {
+    ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID     primitive.ObjectID `bson:"project_id" json:"project_id"`
+    UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+    TokenHash     string             `bson:"token_hash" json:"-"`
+    IPRangeHash   string             `bson:"ip_range_hash" json:"-"`
+    UserAgentHash string             `bson:"user_agent_hash" json:"-"`
+    Anomalies     int                `bson:"anomalies" json:"anomalies"`
+    CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+    LastSeenAt    time.Time          `bson:"last_seen_at" json:"last_seen_at"`
+    ExpiresAt     time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // unset on tokens from before expiry
+    RevokedAt     time.Time          `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+    ReplacedBy    primitive.ObjectID `bson:"replaced_by,omitempty" json:"replaced_by,omitempty"` // set when refreshed
+}
+
+// UserSession is a device a dashboard user has signed in from. The
+// fingerprint combines a long-lived device cookie with browser headers.
+type UserSession struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+    Fingerprint string             `bson:"fingerprint" json:"fingerprint"`
+    UserAgent   string             `bson:"user_agent" json:"user_agent"`
+    IPAddress   string             `bson:"ip_address" json:"ip_address"`
+    CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+    LastSeenAt  time.Time          `bson:"last_seen_at" json:"last_seen_at"`
 }
 
 // Project represents a chatbot project
 type Project struct {
     ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    // UserID is the tenant that owns the project
+    UserID          primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
     Name            string             `bson:"name" json:"name"`
     Description     string             `bson:"description" json:"description"`
     Category        string             `bson:"category" json:"category"`
@@ -40,7 +107,7 @@ type Project struct {
     UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
     
     // PDF Storage Fields
-    PDFFiles        []PDFFile          `bson:"pdf_files" json:"pdf_files"`
+    PDFFiles        []SourceFile          `bson:"pdf_files" json:"pdf_files"`
     PDFContent      string             `bson:"pdf_content" json:"pdf_content"`
     
     // Simplified Gemini Configuration
@@ -52,22 +119,711 @@ type Project struct {
     GeminiUsageMonth    int       `bson:"gemini_usage_month" json:"gemini_usage_month"`
     GeminiMonthlyLimit  int       `bson:"gemini_monthly_limit" json:"gemini_monthly_limit"`
     LastMonthlyReset    time.Time `bson:"last_monthly_reset" json:"last_monthly_reset"`
+    // Day of the month (1-31) the usage window resets on; 0 means the day
+    // the project was created. See BillingPeriod.
+    BillingCycleDay     int       `bson:"billing_cycle_day,omitempty" json:"billing_cycle_day,omitempty"`
+
+    // Optional pay-as-you-go overage: once the monthly limit is used up,
+    // up to OverageCap more requests are served and counted separately in
+    // GeminiOverageMonth, charged at OverageRate (USD per request)
+    OverageEnabled      bool      `bson:"overage_enabled" json:"overage_enabled"`
+    OverageCap          int       `bson:"overage_cap,omitempty" json:"overage_cap,omitempty"`
+    OverageRate         float64   `bson:"overage_rate,omitempty" json:"overage_rate,omitempty"`
+    GeminiOverageMonth  int       `bson:"gemini_overage_month" json:"gemini_overage_month"`
     
     // Keep essential analytics
     TotalQuestions  int                `bson:"total_questions" json:"total_questions"`
     LastUsed        time.Time          `bson:"last_used" json:"last_used"`
     WelcomeMessage  string             `bson:"welcome_message" json:"welcome_message"`
+    
+    // Data retention: chat history older than ChatRetentionDays is archived
+    // to object storage (0 = deployment default). LegalHold blocks archival.
+    ChatRetentionDays int              `bson:"chat_retention_days,omitempty" json:"chat_retention_days,omitempty"`
+    LegalHold         bool             `bson:"legal_hold" json:"legal_hold"`
+    LegalHoldReason   string           `bson:"legal_hold_reason,omitempty" json:"legal_hold_reason,omitempty"`
+    LegalHoldSetBy    string           `bson:"legal_hold_set_by,omitempty" json:"legal_hold_set_by,omitempty"`
+    LegalHoldSetAt    time.Time        `bson:"legal_hold_set_at,omitempty" json:"legal_hold_set_at,omitempty"`
+    
+    // Storage quota in MB (0 = deployment default, TENANT_STORAGE_QUOTA_MB)
+    StorageQuotaMB    int              `bson:"storage_quota_mb,omitempty" json:"storage_quota_mb,omitempty"`
+    
+    // Optional HMAC signing of widget messages. The public token identifies
+    // the key; the secret never leaves the server or the customer's backend.
+    SigningRequired    bool            `bson:"signing_required" json:"signing_required"`
+    SigningPublicToken string          `bson:"signing_public_token,omitempty" json:"signing_public_token,omitempty"`
+    SigningSecret      string          `bson:"signing_secret,omitempty" json:"-"`
+
+    // Publishable keys the widget presents on embed and chat routes. Once a
+    // project has an active key, requests without one are refused.
+    APIKeys            []ProjectAPIKey `bson:"api_keys,omitempty" json:"-"`
+
+    // Sites allowed to embed the widget ("example.com", "*.example.com").
+    // Empty allows any site.
+    AllowedDomains     []string        `bson:"allowed_domains,omitempty" json:"allowed_domains,omitempty"`
+
+    // Domains the owner has proven control of, or is in the middle of
+    // proving. Only verified domains and their subdomains may be allowlisted.
+    DomainVerifications []DomainVerification `bson:"domain_verifications,omitempty" json:"domain_verifications,omitempty"`
+
+    // Revoke a widget sign-in token, forcing a new sign-in, when it is used
+    // from a different network and browser than it was issued to. Such
+    // reuse always raises a security notification.
+    ReauthOnTokenAnomaly bool          `bson:"reauth_on_token_anomaly,omitempty" json:"reauth_on_token_anomaly,omitempty"`
+
+    // Let signed-in chat users list and resume their earlier sessions from
+    // any device
+    SessionContinuity    bool          `bson:"session_continuity,omitempty" json:"session_continuity,omitempty"`
+    
+    // Optional moderation webhook consulted before a message is answered
+    ModerationWebhookURL string        `bson:"moderation_webhook_url,omitempty" json:"moderation_webhook_url,omitempty"`
+    ModerationTimeoutMs  int           `bson:"moderation_timeout_ms,omitempty" json:"moderation_timeout_ms,omitempty"`
+    ModerationFailPolicy string        `bson:"moderation_fail_policy,omitempty" json:"moderation_fail_policy,omitempty"` // "allow" (default) or "deny"
+    ModerationSecret     string        `bson:"moderation_secret,omitempty" json:"-"`
+
+    // Widget requests presenting this key run in dry-run mode: the Gemini
+    // call is replaced by a canned reply, for load tests
+    DryRunKey            string        `bson:"dry_run_key,omitempty" json:"-"`
+
+    // Optional ticketing system chat sessions can be escalated to
+    Ticketing            *TicketingIntegration `bson:"ticketing,omitempty" json:"ticketing,omitempty"`
+
+    // Optional Slack channels the project's notifications are posted to
+    Slack                *SlackRouting         `bson:"slack,omitempty" json:"slack,omitempty"`
+
+    // Optional Discord channels the project's notifications are posted to
+    Discord              *DiscordRouting       `bson:"discord,omitempty" json:"discord,omitempty"`
+
+    // Optional calendar the bot can offer appointment slots from
+    Booking              *BookingIntegration   `bson:"booking,omitempty" json:"booking,omitempty"`
+
+    // Number of products in the project's catalog; 0 skips catalog lookups
+    CatalogProducts      int                   `bson:"catalog_products,omitempty" json:"catalog_products,omitempty"`
+    CatalogUpdatedAt     time.Time             `bson:"catalog_updated_at,omitempty" json:"catalog_updated_at,omitempty"`
+
+    // Optional order status endpoint on the customer's own API
+    OrderLookup          *OrderLookup          `bson:"order_lookup,omitempty" json:"order_lookup,omitempty"`
+
+    // Owners are warned once the newest document is older than this; 0 uses the default
+    KnowledgeMaxAgeDays  int                   `bson:"knowledge_max_age_days,omitempty" json:"knowledge_max_age_days,omitempty"`
+
+    // Shared library documents attached to this project
+    LibraryDocs          []LibraryAttachment   `bson:"library_docs,omitempty" json:"library_docs,omitempty"`
+
+    // Bot instructions added to every prompt. Each change is kept as an
+    // InstructionRevision; InstructionsRevision is the current number.
+    Instructions         string                `bson:"instructions,omitempty" json:"instructions,omitempty"`
+    InstructionsRevision int                   `bson:"instructions_revision,omitempty" json:"instructions_revision,omitempty"`
+
+    // Accessibility and data-saving options served to the widget
+    Widget               *WidgetSettings       `bson:"widget,omitempty" json:"widget,omitempty"`
+
+    // First time the widget was loaded from a customer's site, and the site
+    WidgetEmbeddedAt     time.Time             `bson:"widget_embedded_at,omitempty" json:"widget_embedded_at,omitempty"`
+    WidgetEmbeddedOn     string                `bson:"widget_embedded_on,omitempty" json:"widget_embedded_on,omitempty"`
+
+    // What visitors get when the bot cannot answer
+    Fallback             *FallbackSettings     `bson:"fallback,omitempty" json:"fallback,omitempty"`
+
+    // When and how the widget asks visitors to rate answers
+    Rating               *RatingSettings       `bson:"rating,omitempty" json:"rating,omitempty"`
+
+    // Intro messages and quick-reply buttons shown when the widget opens;
+    // without one the widget shows WelcomeMessage
+    WelcomeFlow          *WelcomeFlow          `bson:"welcome_flow,omitempty" json:"welcome_flow,omitempty"`
+
+    // What visitors ask about; each message is classified into one, which
+    // can change the instructions and tools used to answer it
+    Intents              []Intent              `bson:"intents,omitempty" json:"intents,omitempty"`
+
+    // Dedicated database for the project's chat messages and sessions;
+    // empty keeps them in the main database. Changed with jevictl move-tenant.
+    Database             string                `bson:"database,omitempty" json:"database,omitempty"`
+}
+
+// OnboardingStep is one item of a project's setup checklist
+type OnboardingStep struct {
+    Key   string `json:"key"`
+    Title string `json:"title"`
+    Hint  string `json:"hint"`
+    Done  bool   `json:"done"`
+}
+
+// ProjectAPIKey is a project API key. Keys that can only chat are
+// publishable widget keys: public by design, like the project ID, so they
+// are kept as is. Keys with any other scope are secret and only their hash
+// is kept. Revoked keys stay listed.
+type ProjectAPIKey struct {
+    ID         string    `bson:"id" json:"id"`
+    Name       string    `bson:"name" json:"name"`
+    Key        string    `bson:"key,omitempty" json:"key,omitempty"`
+    KeyHash    string    `bson:"key_hash,omitempty" json:"-"`
+    KeyHint    string    `bson:"key_hint,omitempty" json:"key_hint,omitempty"` // last characters of a secret key
+    Scopes     []string  `bson:"scopes,omitempty" json:"scopes"`
+    RateLimit  int       `bson:"rate_limit,omitempty" json:"rate_limit,omitempty"` // requests per minute; 0 leaves only the route's own limits
+    CreatedBy  string    `bson:"created_by,omitempty" json:"created_by,omitempty"`
+    CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+    RevokedAt  time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+    LastUsedAt time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+    LastUsedIP string    `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+    // AnomalyAlertedAt is when owners were last warned of unusual use
+    AnomalyAlertedAt time.Time `bson:"anomaly_alerted_at,omitempty" json:"anomaly_alerted_at,omitempty"`
+}
+
+// API key scopes
+const (
+    APIScopeChatWrite      = "chat:write"      // widget and chat routes
+    APIScopeHistoryRead    = "history:read"    // chat history
+    APIScopeAnalyticsRead  = "analytics:read"  // chat analytics
+    APIScopeWebhooksManage = "webhooks:manage" // outgoing webhooks and their deliveries
+)
+
+// APIScopes lists the scopes a key can be issued with
+var APIScopes = []string{APIScopeChatWrite, APIScopeHistoryRead, APIScopeAnalyticsRead, APIScopeWebhooksManage}
+
+// Active reports whether the key is still accepted
+func (k ProjectAPIKey) Active() bool {
+    return k.RevokedAt.IsZero()
+}
+
+// Matches reports whether presented is this key, comparing in constant time
+func (k ProjectAPIKey) Matches(presented string) bool {
+    if presented == "" {
+        return false
+    }
+    if k.KeyHash != "" {
+        return subtle.ConstantTimeCompare([]byte(HashAPIKey(presented)), []byte(k.KeyHash)) == 1
+    }
+    return subtle.ConstantTimeCompare([]byte(presented), []byte(k.Key)) == 1
+}
+
+// HashAPIKey is how secret API keys are stored
+func HashAPIKey(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
+
+// GrantedScopes returns the key's scopes. Keys issued before scopes were
+// added are widget keys, which can only chat.
+func (k ProjectAPIKey) GrantedScopes() []string {
+    if len(k.Scopes) == 0 {
+        return []string{APIScopeChatWrite}
+    }
+    return k.Scopes
+}
+
+// Allows reports whether the key was issued with scope
+func (k ProjectAPIKey) Allows(scope string) bool {
+    for _, s := range k.GrantedScopes() {
+        if s == scope {
+            return true
+        }
+    }
+    return false
+}
+
+// Secret reports whether the key grants more than chatting, so it must not
+// be shown again or embedded in a page
+func (k ProjectAPIKey) Secret() bool {
+    for _, s := range k.GrantedScopes() {
+        if s != APIScopeChatWrite {
+            return true
+        }
+    }
+    return false
+}
+
+// HasActiveAPIKey reports whether widget requests must present a key,
+// which they do once the project has an active key that can chat
+func (p *Project) HasActiveAPIKey() bool {
+    for _, k := range p.APIKeys {
+        if k.Active() && k.Allows(APIScopeChatWrite) {
+            return true
+        }
+    }
+    return false
+}
+
+// DomainVerification proves a project owner controls a domain, with a
+// meta tag on its home page or a DNS TXT record carrying the token
+type DomainVerification struct {
+    Domain        string    `bson:"domain" json:"domain"`
+    Token         string    `bson:"token" json:"token"`
+    Status        string    `bson:"status" json:"status"`
+    Method        string    `bson:"method,omitempty" json:"method,omitempty"` // how it was verified
+    Attempts      int       `bson:"attempts" json:"attempts"`
+    LastError     string    `bson:"last_error,omitempty" json:"last_error,omitempty"`
+    CreatedBy     string    `bson:"created_by,omitempty" json:"created_by,omitempty"`
+    CreatedAt     time.Time `bson:"created_at" json:"created_at"`
+    LastCheckedAt time.Time `bson:"last_checked_at,omitempty" json:"last_checked_at,omitempty"`
+    VerifiedAt    time.Time `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+}
+
+// Domain verification states and methods
+const (
+    DomainPending  = "pending"
+    DomainVerified = "verified"
+    DomainFailed   = "failed" // the last check found no token; it can be retried
+
+    DomainMethodMeta = "meta"
+    DomainMethodDNS  = "dns"
+)
+
+// DomainVerificationName is the meta tag name and TXT record prefix
+// carrying a verification token
+const DomainVerificationName = "jevi-site-verification"
+
+// HasVerifiedDomain reports whether the owner has verified any domain
+func (p *Project) HasVerifiedDomain() bool {
+    for _, v := range p.DomainVerifications {
+        if v.Status == DomainVerified {
+            return true
+        }
+    }
+    return false
+}
+
+// Fallback kinds
+const (
+    FallbackMessage   = "message"    // show the custom text
+    FallbackEmailForm = "email_form" // show the text and an "email us" form
+    FallbackHandoff   = "handoff"    // show the text and call a live agent
+)
+
+// Reasons a message got the fallback answer
+const (
+    FallbackReasonUnanswered = "unanswered" // the documents did not cover it
+    FallbackReasonError      = "error"      // the answer could not be generated
+)
+
+// FallbackSettings replace the generic apology when the bot cannot answer.
+// Email is where email_form submissions are sent.
+type FallbackSettings struct {
+    Kind      string    `bson:"kind" json:"kind"`
+    Message   string    `bson:"message" json:"message"`
+    Email     string    `bson:"email,omitempty" json:"email,omitempty"`
+    UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// WidgetSettings are display options the embed widget reads from its
+// config endpoint. ReducedData also makes the server trim its payloads.
+type WidgetSettings struct {
+    HighContrast bool         `bson:"high_contrast" json:"high_contrast"`
+    FontScale    float64      `bson:"font_scale,omitempty" json:"font_scale,omitempty"` // 1 = default size
+    ReducedData  bool         `bson:"reduced_data" json:"reduced_data"`
+    Theme        *WidgetTheme `bson:"theme,omitempty" json:"theme,omitempty"`
+    UpdatedAt    time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// WidgetTheme brands the widget. Empty fields fall back to the defaults
+// the config endpoint fills in.
+type WidgetTheme struct {
+    PrimaryColor    string `bson:"primary_color,omitempty" json:"primary_color,omitempty"`
+    SecondaryColor  string `bson:"secondary_color,omitempty" json:"secondary_color,omitempty"` // end of the header and launcher gradient
+    BackgroundColor string `bson:"background_color,omitempty" json:"background_color,omitempty"`
+    TextColor       string `bson:"text_color,omitempty" json:"text_color,omitempty"`
+    LogoURL         string `bson:"logo_url,omitempty" json:"logo_url,omitempty"`
+    Position        string `bson:"position,omitempty" json:"position,omitempty"` // launcher corner
+    HeaderTitle     string `bson:"header_title,omitempty" json:"header_title,omitempty"`
+    HeaderSubtitle  string `bson:"header_subtitle,omitempty" json:"header_subtitle,omitempty"`
+    FontFamily      string `bson:"font_family,omitempty" json:"font_family,omitempty"`
+}
+
+// Widget launcher positions
+const (
+    WidgetPositionBottomRight = "bottom-right"
+    WidgetPositionBottomLeft  = "bottom-left"
+)
+
+// When the widget asks for a rating
+const (
+    RatingAskEveryMessage = "every_message"
+    RatingAskAfterN       = "after_messages" // from the AfterMessages-th answer of a session
+    RatingAskSessionEnd   = "session_end"    // only the session's last answer
+)
+
+// Rating scales. Thumbs are stored on the 1-5 scale as 1 (down) and 5 (up)
+// so ratings stay comparable when a project changes scale.
+const (
+    RatingScaleStars  = "stars"
+    RatingScaleThumbs = "thumbs"
+)
+
+// RatingSettings drive the widget's rating prompt and are enforced when a
+// rating is submitted
+type RatingSettings struct {
+    AskWhen        string    `bson:"ask_when" json:"ask_when"`
+    AfterMessages  int       `bson:"after_messages,omitempty" json:"after_messages,omitempty"`
+    Scale          string    `bson:"scale" json:"scale"`
+    RequireComment bool      `bson:"require_comment" json:"require_comment"`
+    UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// WelcomeFlow is what the widget shows before the visitor's first message:
+// intro messages in order, then quick-reply buttons
+type WelcomeFlow struct {
+    Enabled      bool         `bson:"enabled" json:"enabled"`
+    Messages     []string     `bson:"messages" json:"messages"`
+    QuickReplies []QuickReply `bson:"quick_replies" json:"quick_replies"`
+    UpdatedAt    time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// QuickReply is a welcome flow button. An answer reply shows its canned
+// Answer without calling the bot; an intent reply sends Message to the bot
+// as the visitor's question, answered as the project intent Intent names.
+type QuickReply struct {
+    ID      string `bson:"id" json:"id"`
+    Label   string `bson:"label" json:"label"`
+    Action  string `bson:"action" json:"action"`
+    Answer  string `bson:"answer,omitempty" json:"answer,omitempty"`
+    Intent  string `bson:"intent,omitempty" json:"intent,omitempty"`
+    Message string `bson:"message,omitempty" json:"message,omitempty"`
+}
+
+// Quick reply actions
+const (
+    QuickReplyAnswer = "answer"
+    QuickReplyIntent = "intent"
+)
+
+// Intent is something visitors ask about, like billing or sales. Messages
+// are classified by their similarity to the example phrases, whose
+// embeddings are kept in ExampleVectors. A classified message is answered
+// with Instructions added to the project's, and with Tools only, if set.
+type Intent struct {
+    Key            string      `bson:"key" json:"key"`
+    Name           string      `bson:"name" json:"name"`
+    Examples       []string    `bson:"examples" json:"examples"`
+    Instructions   string      `bson:"instructions,omitempty" json:"instructions,omitempty"`
+    Tools          []string    `bson:"tools,omitempty" json:"tools,omitempty"`
+    ExampleVectors [][]float32 `bson:"example_vectors,omitempty" json:"-"`
+    // Embedding model of ExampleVectors; message vectors must match it
+    EmbeddingModel string      `bson:"embedding_model,omitempty" json:"-"`
+    // Optional rule for sessions that need a person, like complaints
+    Escalation     *IntentEscalation `bson:"escalation,omitempty" json:"escalation,omitempty"`
+}
+
+// IntentEscalation alerts the project's team as soon as a session has a
+// message of the intent, and can hand the session to a live agent instead
+// of answering it. A person is expected to respond within SLAMinutes.
+type IntentEscalation struct {
+    ForceHandoff   bool   `bson:"force_handoff,omitempty" json:"force_handoff"`
+    HandoffMessage string `bson:"handoff_message,omitempty" json:"handoff_message,omitempty"`
+    SLAMinutes     int    `bson:"sla_minutes,omitempty" json:"sla_minutes,omitempty"`
+}
+
+// Tools an intent can limit its answers to
+const (
+    IntentToolBooking = "booking"
+    IntentToolCatalog = "catalog"
+    IntentToolOrders  = "orders"
+)
+
+// WelcomeEvent records a welcome flow being shown in a session, or one of
+// its quick replies being clicked
+type WelcomeEvent struct {
+    ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID primitive.ObjectID `bson:"project_id" json:"project_id"`
+    SessionID string             `bson:"session_id,omitempty" json:"session_id,omitempty"`
+    Event     string             `bson:"event" json:"event"` // "shown" or "click"
+    ReplyID   string             `bson:"reply_id,omitempty" json:"reply_id,omitempty"`
+    CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// InstructionRevision is one saved version of a project's bot instructions
+type InstructionRevision struct {
+    ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID    primitive.ObjectID `bson:"project_id" json:"project_id"`
+    Revision     int                `bson:"revision" json:"revision"`
+    Instructions string             `bson:"instructions" json:"instructions"`
+    Note         string             `bson:"note,omitempty" json:"note,omitempty"`
+    AuthorID     string             `bson:"author_id" json:"author_id"`
+    CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+    Comments     []RevisionComment  `bson:"comments" json:"comments"`
+}
+
+// RevisionComment is a reviewer's remark on an instruction revision
+type RevisionComment struct {
+    ID        primitive.ObjectID `bson:"id" json:"id"`
+    AuthorID  string             `bson:"author_id" json:"author_id"`
+    Body      string             `bson:"body" json:"body"`
+    CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 }
 
-// PDFFile represents uploaded PDF files for each project
-type PDFFile struct {
+// LibraryAttachment links a shared library document to a project. Disabled
+// attachments stay listed but are left out of the bot's knowledge.
+type LibraryAttachment struct {
+    DocumentID primitive.ObjectID `bson:"document_id" json:"document_id"`
+    Enabled    bool               `bson:"enabled" json:"enabled"`
+    AttachedAt time.Time          `bson:"attached_at" json:"attached_at"`
+}
+
+// LibraryDocument is a document shared across a tenant's projects. It is
+// processed once and its extracted content is reused by every project it
+// is attached to. OwnerID is the tenant's user ID; documents without one
+// belong to the platform library, shared by projects without an owner.
+type LibraryDocument struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    OwnerID     string             `bson:"owner_id" json:"owner_id,omitempty"`
+    FileName    string             `bson:"file_name" json:"file_name"`
+    FilePath    string             `bson:"file_path,omitempty" json:"-"` // legacy local copy
+    StorageKey  string             `bson:"storage_key,omitempty" json:"-"`
+    FileSize    int64              `bson:"file_size" json:"file_size"`
+    Content     string             `bson:"content" json:"-"`
+    Status      string             `bson:"status" json:"status"` // "completed", "failed"
+    UploadedAt  time.Time          `bson:"uploaded_at" json:"uploaded_at"`
+    ProcessedAt time.Time          `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
+    EmbeddedAt  time.Time          `bson:"embedded_at,omitempty" json:"embedded_at,omitempty"`
+    ContentHash string             `bson:"content_hash,omitempty" json:"content_hash,omitempty"`
+}
+
+// ObjectKey is where the document's file is kept in object storage
+func (d LibraryDocument) ObjectKey() string {
+    owner := d.OwnerID
+    if owner == "" {
+        owner = "platform"
+    }
+    return fmt.Sprintf("library/%s/%s_%s", owner, d.ID.Hex(), path.Base(d.FileName))
+}
+
+// OrderLookup answers order status questions from the customer's API. Only
+// an order number matching OrderPattern (and the signed-in chat user's
+// email, if any) is sent; the reply is AnswerTemplate rendered over the
+// endpoint's JSON response.
+type OrderLookup struct {
+    EndpointURL     string    `bson:"endpoint_url" json:"endpoint_url"`
+    OrderPattern    string    `bson:"order_pattern" json:"order_pattern"`
+    TriggerWords    []string  `bson:"trigger_words,omitempty" json:"trigger_words,omitempty"`
+    AnswerTemplate  string    `bson:"answer_template" json:"answer_template"`
+    NotFoundMessage string    `bson:"not_found_message,omitempty" json:"not_found_message,omitempty"`
+    TimeoutMs       int       `bson:"timeout_ms,omitempty" json:"timeout_ms,omitempty"`
+    Secret          string    `bson:"secret" json:"-"`
+    UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Product availability values
+const (
+    AvailabilityInStock    = "in_stock"
+    AvailabilityOutOfStock = "out_of_stock"
+    AvailabilityPreorder   = "preorder"
+)
+
+// Product is one item of a project's catalog, kept structured so price and
+// availability questions are answered from the exact values. SKU is unique
+// per project.
+type Product struct {
+    ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID    primitive.ObjectID `bson:"project_id" json:"project_id"`
+    SKU          string             `bson:"sku" json:"sku"`
+    Name         string             `bson:"name" json:"name"`
+    Description  string             `bson:"description,omitempty" json:"description,omitempty"`
+    Category     string             `bson:"category,omitempty" json:"category,omitempty"`
+    Price        float64            `bson:"price" json:"price"`
+    Currency     string             `bson:"currency" json:"currency"`
+    Availability string             `bson:"availability" json:"availability"`
+    Quantity     *int               `bson:"quantity,omitempty" json:"quantity,omitempty"`
+    URL          string             `bson:"url,omitempty" json:"url,omitempty"`
+    UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Booking providers
+const (
+    BookingCalendly       = "calendly"
+    BookingGoogleCalendar = "google_calendar"
+)
+
+// BookingIntegration lets the bot offer and book appointments. Calendly
+// slots are booked through their scheduling links; Google Calendar slots are
+// booked directly as events on CalendarID, within the working hours.
+type BookingIntegration struct {
+    Provider             string    `bson:"provider" json:"provider"`
+    EncryptedCredentials string    `bson:"encrypted_credentials" json:"-"` // Calendly access token or Google service account JSON
+    EventTypeURI         string    `bson:"event_type_uri,omitempty" json:"event_type_uri,omitempty"`   // Calendly
+    CalendarID           string    `bson:"calendar_id,omitempty" json:"calendar_id,omitempty"`         // Google Calendar
+    SlotMinutes          int       `bson:"slot_minutes,omitempty" json:"slot_minutes,omitempty"`       // Google Calendar, default 30
+    WorkdayStartHour     int       `bson:"workday_start_hour,omitempty" json:"workday_start_hour,omitempty"` // Google Calendar, default 9
+    WorkdayEndHour       int       `bson:"workday_end_hour,omitempty" json:"workday_end_hour,omitempty"`     // Google Calendar, default 17
+    Timezone             string    `bson:"timezone,omitempty" json:"timezone,omitempty"` // IANA name, default UTC
+    UpdatedAt            time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Lead sources and statuses
+const (
+    LeadSourceBooking = "booking"
+
+    LeadStatusBooked   = "booked"    // the appointment is on the calendar
+    LeadStatusLinkSent = "link_sent" // the visitor got a link to finish booking
+)
+
+// Lead is a visitor who showed buying intent in chat, such as booking an
+// appointment
+type Lead struct {
+    ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID     primitive.ObjectID `bson:"project_id" json:"project_id"`
+    SessionID     string             `bson:"session_id" json:"session_id"`
+    Name          string             `bson:"name" json:"name"`
+    Email         string             `bson:"email" json:"email"`
+    Source        string             `bson:"source" json:"source"`
+    Status        string             `bson:"status" json:"status"`
+    Provider      string             `bson:"provider,omitempty" json:"provider,omitempty"`
+    AppointmentAt time.Time          `bson:"appointment_at,omitempty" json:"appointment_at,omitempty"`
+    BookingURL    string             `bson:"booking_url,omitempty" json:"booking_url,omitempty"`
+    ExternalID    string             `bson:"external_id,omitempty" json:"external_id,omitempty"` // calendar event ID
+    Notes         string             `bson:"notes,omitempty" json:"notes,omitempty"`
+    CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Ticketing providers
+const (
+    TicketingZendesk   = "zendesk"
+    TicketingFreshdesk = "freshdesk"
+    TicketingJiraSM    = "jira_service_management"
+)
+
+// TicketingIntegration holds a project's ticketing credentials. The API
+// token is encrypted with config.EncryptSecret and never returned.
+type TicketingIntegration struct {
+    Provider       string    `bson:"provider" json:"provider"`
+    BaseURL        string    `bson:"base_url" json:"base_url"`                   // e.g. https://acme.zendesk.com
+    Email          string    `bson:"email,omitempty" json:"email,omitempty"`     // account the token belongs to (Zendesk, Jira)
+    EncryptedToken string    `bson:"encrypted_token" json:"-"`
+    ServiceDeskID  string    `bson:"service_desk_id,omitempty" json:"service_desk_id,omitempty"` // Jira Service Management only
+    RequestTypeID  string    `bson:"request_type_id,omitempty" json:"request_type_id,omitempty"` // Jira Service Management only
+    Tags           []string  `bson:"tags,omitempty" json:"tags,omitempty"`       // added to every ticket
+    UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// SlackRouting posts a project's notifications to its own Slack channels
+// instead of the server-wide SLACK_WEBHOOK_URL. Each route sends some
+// notification types to a channel's incoming webhook; other types go to the
+// default webhook, if any. Webhook URLs are encrypted with
+// config.EncryptSecret and never returned.
+type SlackRouting struct {
+    EncryptedWebhook string       `bson:"encrypted_webhook,omitempty" json:"-"`
+    Channel          string       `bson:"channel,omitempty" json:"channel,omitempty"` // label of the default webhook's channel
+    Routes           []SlackRoute `bson:"routes,omitempty" json:"routes,omitempty"`
+    UpdatedAt        time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// SlackRoute sends notifications of the given types to one channel
+type SlackRoute struct {
+    Types            []string `bson:"types" json:"types"`
+    Channel          string   `bson:"channel" json:"channel"` // e.g. "#billing"
+    EncryptedWebhook string   `bson:"encrypted_webhook" json:"-"`
+}
+
+// DiscordRouting posts a project's notifications to its own Discord
+// channels instead of the server-wide DISCORD_WEBHOOK_URL, the same way
+// SlackRouting does for Slack. A Discord webhook belongs to one channel, so
+// Channel is only a label.
+type DiscordRouting struct {
+    EncryptedWebhook string         `bson:"encrypted_webhook,omitempty" json:"-"`
+    Channel          string         `bson:"channel,omitempty" json:"channel,omitempty"`
+    Routes           []DiscordRoute `bson:"routes,omitempty" json:"routes,omitempty"`
+    UpdatedAt        time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// DiscordRoute sends notifications of the given types to one channel
+type DiscordRoute struct {
+    Types            []string `bson:"types" json:"types"`
+    Channel          string   `bson:"channel" json:"channel"`
+    EncryptedWebhook string   `bson:"encrypted_webhook" json:"-"`
+}
+
+// SourceFile is a document uploaded to a project's knowledge: a PDF, Word
+// document, plain text, Markdown or CSV file
+type SourceFile struct {
     ID          string    `bson:"id" json:"id"`
     FileName    string    `bson:"file_name" json:"file_name"`
+    // Local path of files uploaded before object storage; newer files have
+    // a StorageKey in config.Storage instead
     FilePath    string    `bson:"file_path" json:"file_path"`
+    StorageKey  string    `bson:"storage_key,omitempty" json:"storage_key,omitempty"`
     FileSize    int64     `bson:"file_size" json:"file_size"`
+    // One of the SourceType constants; empty on files uploaded when only
+    // PDFs were accepted
+    Type        string    `bson:"type,omitempty" json:"type,omitempty"`
     UploadedAt  time.Time `bson:"uploaded_at" json:"uploaded_at"`
     ProcessedAt time.Time `bson:"processed_at" json:"processed_at"`
     Status      string    `bson:"status" json:"status"` // "processing", "completed", "failed"
+    // Why extraction failed, when it did
+    Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+    // Characters of text extracted from the file
+    TextChars   int       `bson:"text_chars,omitempty" json:"text_chars,omitempty"`
+    // Set when a PDF yielded too little text and was run through OCR: one
+    // of the OCRStatus constants, and the engine used
+    OCRStatus   string    `bson:"ocr_status,omitempty" json:"ocr_status,omitempty"`
+    OCREngine   string    `bson:"ocr_engine,omitempty" json:"ocr_engine,omitempty"`
+    // Set once the file's passages are embedded for retrieval
+    EmbeddedAt  time.Time `bson:"embedded_at,omitempty" json:"embedded_at,omitempty"`
+    // SHA-256 of the file, to spot the same document uploaded twice
+    ContentHash string    `bson:"content_hash,omitempty" json:"content_hash,omitempty"`
+}
+
+// DocumentDuplicate is the document an upload turned out to be a copy of.
+// Source is "file" for one of the project's PDFs and "library" for a
+// shared library document; Linked is set when the library document was
+// attached to the project in place of the upload.
+type DocumentDuplicate struct {
+    Source   string `bson:"source" json:"source"`
+    ID       string `bson:"id" json:"id"`
+    FileName string `bson:"file_name" json:"file_name"`
+    Linked   bool   `bson:"linked,omitempty" json:"linked,omitempty"`
+}
+
+// DocumentPage is the text extracted from one page of an uploaded PDF, kept
+// so admins can preview what the bot sees page by page
+type DocumentPage struct {
+    ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+    ProjectID primitive.ObjectID `bson:"project_id" json:"project_id"`
+    FileID    string             `bson:"file_id" json:"file_id"`
+    Page      int                `bson:"page" json:"page"`
+    Text      string             `bson:"text" json:"text"`
+    CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// DocumentChunk is a passage of a page's extracted text. Offset and Length
+// are in characters (runes) within the page text, so a citation can point
+// at the exact passage.
+type DocumentChunk struct {
+    ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+    ProjectID primitive.ObjectID `bson:"project_id" json:"project_id"`
+    FileID    string             `bson:"file_id" json:"file_id"`
+    Page      int                `bson:"page" json:"page"`
+    Offset    int                `bson:"offset" json:"offset"`
+    Length    int                `bson:"length" json:"length"`
+    Text      string             `bson:"text" json:"text"`
+}
+
+// ChunkEmbedding is the embedding of a document passage, used to find the
+// passages relevant to a question. Passages of a project's own PDFs carry
+// its ProjectID and FileID; passages of a shared library document carry
+// LibraryDocumentID instead and serve every project it is attached to.
+type ChunkEmbedding struct {
+    ID                primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+    ProjectID         primitive.ObjectID `bson:"project_id,omitempty" json:"project_id,omitempty"`
+    LibraryDocumentID primitive.ObjectID `bson:"library_document_id,omitempty" json:"library_document_id,omitempty"`
+    FileID            string             `bson:"file_id,omitempty" json:"file_id,omitempty"`
+    Page              int                `bson:"page,omitempty" json:"page,omitempty"`
+    Offset            int                `bson:"offset" json:"offset"`
+    Length            int                `bson:"length" json:"length"`
+    Text              string             `bson:"text" json:"text"`
+    Model             string             `bson:"model" json:"model"`
+    Vector            []float32          `bson:"vector" json:"-"`
+    CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Citation points an answer at the document passage it drew on. ChunkID
+// is the cited DocumentChunk; chunks are replaced when a file is extracted
+// again, so file, page and offset identify the passage for good.
+type Citation struct {
+    ChunkID    primitive.ObjectID `bson:"chunk_id,omitempty" json:"chunk_id,omitempty"`
+    FileID     string `bson:"file_id" json:"file_id"`
+    FileName   string `bson:"file_name" json:"file_name"`
+    Page       int    `bson:"page" json:"page"`
+    Offset     int    `bson:"offset" json:"offset"`
+    Length     int    `bson:"length" json:"length"`
+    Snippet    string `bson:"snippet" json:"snippet"`
+    PreviewURL string `bson:"preview_url" json:"preview_url"`
 }
 
 // GeminiUsageLog tracks AI usage for analytics and billing
@@ -87,6 +843,39 @@ type GeminiUsageLog struct {
     EstimatedCost   float64            `bson:"estimated_cost" json:"estimated_cost"`
     ResponseTime    int64              `bson:"response_time_ms" json:"response_time_ms"`
     Success         bool               `bson:"success" json:"success"`
+    Overage         bool               `bson:"overage,omitempty" json:"overage,omitempty"` // counted against the overage allowance
+}
+
+// GeminiUsageDaily is a permanent per-project daily rollup of GeminiUsageLog
+// rows, written by the maintenance job before old logs are purged
+type GeminiUsageDaily struct {
+    ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID       primitive.ObjectID `bson:"project_id" json:"project_id"`
+    Date            string             `bson:"date" json:"date"` // YYYY-MM-DD (UTC)
+    Requests        int64              `bson:"requests" json:"requests"`
+    SuccessCount    int64              `bson:"success_count" json:"success_count"`
+    FailedCount     int64              `bson:"failed_count" json:"failed_count"`
+    OverageRequests int64              `bson:"overage_requests" json:"overage_requests"`
+    TokensUsed      int64              `bson:"tokens_used" json:"tokens_used"`
+    InputTokens     int64              `bson:"input_tokens" json:"input_tokens"`
+    OutputTokens    int64              `bson:"output_tokens" json:"output_tokens"`
+    EstimatedCost   float64            `bson:"estimated_cost" json:"estimated_cost"`
+    AvgResponseTime float64            `bson:"avg_response_time_ms" json:"avg_response_time_ms"`
+    UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// OverageCharge is the overage owed for one closed billing period, written
+// when the period's usage is reset and picked up by billing
+type OverageCharge struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID   primitive.ObjectID `bson:"project_id" json:"project_id"`
+    PeriodStart time.Time          `bson:"period_start" json:"period_start"`
+    PeriodEnd   time.Time          `bson:"period_end" json:"period_end"`
+    Requests    int                `bson:"requests" json:"requests"`
+    Rate        float64            `bson:"rate" json:"rate"`
+    Amount      float64            `bson:"amount" json:"amount"`
+    Billed      bool               `bson:"billed" json:"billed"`
+    CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
 }
 
 // ChatMessage represents individual chat messages
@@ -105,13 +894,74 @@ type ChatMessage struct {
     UserName  string             `bson:"user_name,omitempty" json:"user_name,omitempty"`
     UserEmail string             `bson:"user_email,omitempty" json:"user_email,omitempty"`
     
+    // Moderation webhook outcome, when the project uses one
+    ModerationAction string          `bson:"moderation_action,omitempty" json:"moderation_action,omitempty"`
+
+    // Set on messages answered by a dry-run (load test) request
+    DryRun           bool            `bson:"dry_run,omitempty" json:"dry_run,omitempty"`
+
+    // ID the widget generated for the message, so a resend after a dropped
+    // connection is answered from this record instead of twice
+    ClientMessageID  string          `bson:"client_message_id,omitempty" json:"client_message_id,omitempty"`
+
+    // Document passages the answer drew on
+    Citations        []Citation      `bson:"citations,omitempty" json:"citations,omitempty"`
+
+    // Set when the response is a clarifying question rather than an answer
+    Clarifying       bool            `bson:"clarifying,omitempty" json:"clarifying,omitempty"`
+
+    // Why the response is the fallback answer, if it is
+    Fallback         string          `bson:"fallback,omitempty" json:"fallback,omitempty"`
+
+    // Key of the project intent the message was classified as, if any
+    Intent           string          `bson:"intent,omitempty" json:"intent,omitempty"`
+
+    // Set on messages brought in by a transcript import
+    ImportBatchID    primitive.ObjectID `bson:"import_batch_id,omitempty" json:"import_batch_id,omitempty"`
+
+    // Set on messages restored from a cold archive; the archiver skips them
+    RestoredAt       *time.Time      `bson:"restored_at,omitempty" json:"restored_at,omitempty"`
+
+    // Set once the message's text was removed; the original is kept in
+    // message_redactions
+    Tombstone        *MessageTombstone `bson:"tombstone,omitempty" json:"tombstone,omitempty"`
+    
     // Message rating and feedback
     Rating    int                `bson:"rating,omitempty" json:"rating,omitempty"`
     Feedback  string             `bson:"feedback,omitempty" json:"feedback,omitempty"`
     RatedAt   time.Time          `bson:"rated_at,omitempty" json:"rated_at,omitempty"`
 }
 
-// ChatSession represents a chat session
+// MessageTombstone marks a chat message whose text was removed, either
+// deleted by the chat user who sent it or redacted by an admin. Tombstoned
+// messages are left out of the context of later answers.
+type MessageTombstone struct {
+    By     string    `bson:"by" json:"by"` // TombstoneByUser or TombstoneByAdmin
+    Reason string    `bson:"reason,omitempty" json:"reason,omitempty"`
+    At     time.Time `bson:"at" json:"at"`
+}
+
+// MessageRedaction is the original of a tombstoned chat message. They are
+// kept for compliance in an audit-only store that only platform operators
+// can read. ActorID is the admin, chat user or session that removed it.
+type MessageRedaction struct {
+    ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID        primitive.ObjectID `bson:"project_id" json:"project_id"`
+    MessageID        primitive.ObjectID `bson:"message_id" json:"message_id"`
+    SessionID        string             `bson:"session_id" json:"session_id"`
+    UserID           primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+    Message          string             `bson:"message" json:"message"`
+    Response         string             `bson:"response" json:"response"`
+    Citations        []Citation         `bson:"citations,omitempty" json:"citations,omitempty"`
+    MessageTimestamp time.Time          `bson:"message_timestamp" json:"message_timestamp"`
+    By               string             `bson:"by" json:"by"`
+    ActorID          string             `bson:"actor_id" json:"actor_id"`
+    Reason           string             `bson:"reason,omitempty" json:"reason,omitempty"`
+    CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ChatSession represents a chat session. SessionID is unique per project
+// and UserID binds the session to the chat user who started it.
 type ChatSession struct {
     ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
     ProjectID primitive.ObjectID `bson:"project_id" json:"project_id"`
@@ -121,30 +971,310 @@ type ChatSession struct {
     StartTime time.Time          `bson:"start_time" json:"start_time"`
     EndTime   time.Time          `bson:"end_time" json:"end_time"`
     IPAddress string             `bson:"ip_address" json:"ip_address"`
+    LastSeenAt time.Time         `bson:"last_seen_at" json:"last_seen_at"`
+
+    // Set once the session has been handed over to a ticketing system
+    Escalation *SessionEscalation `bson:"escalation,omitempty" json:"escalation,omitempty"`
+
+    // Set while the bot waits for the answer to a clarifying question
+    Clarification *SessionClarification `bson:"clarification,omitempty" json:"clarification,omitempty"`
+
+    // Set once a fallback answer asked for a live agent
+    HandoffRequestedAt time.Time `bson:"handoff_requested_at,omitempty" json:"handoff_requested_at,omitempty"`
+
+    // Set once a message of an escalating intent alerted the team
+    IntentEscalation *SessionIntentEscalation `bson:"intent_escalation,omitempty" json:"intent_escalation,omitempty"`
+
+    // Set once the signed-in user removed the session from their list
+    HiddenFromUser bool `bson:"hidden_from_user,omitempty" json:"hidden_from_user,omitempty"`
+
+    // Set on sessions brought in by a transcript import, with the
+    // conversation's ID at the previous vendor
+    ImportBatchID     primitive.ObjectID `bson:"import_batch_id,omitempty" json:"import_batch_id,omitempty"`
+    ExternalSessionID string             `bson:"external_session_id,omitempty" json:"external_session_id,omitempty"`
+}
+
+// SessionIntentEscalation tracks a session's escalation for an intent
+// against its time-to-human-response SLA
+type SessionIntentEscalation struct {
+    Intent          string    `bson:"intent" json:"intent"`
+    TriggeredAt     time.Time `bson:"triggered_at" json:"triggered_at"`
+    HandoffForced   bool      `bson:"handoff_forced,omitempty" json:"handoff_forced,omitempty"`
+    // Zero when the intent has no SLA
+    DueAt           time.Time `bson:"due_at,omitempty" json:"due_at,omitempty"`
+    RespondedAt     time.Time `bson:"responded_at,omitempty" json:"responded_at,omitempty"`
+    RespondedBy     string    `bson:"responded_by,omitempty" json:"responded_by,omitempty"`
+    // Set when the SLA passed without a response and the team was alerted
+    BreachedAt      time.Time `bson:"breached_at,omitempty" json:"breached_at,omitempty"`
+}
+
+// SessionClarification is a clarifying question the bot asked in place of
+// answering. The visitor's reply is combined with OriginalMessage.
+type SessionClarification struct {
+    OriginalMessage string    `bson:"original_message" json:"original_message"`
+    Question        string    `bson:"question" json:"question"`
+    AskedAt         time.Time `bson:"asked_at" json:"asked_at"`
+}
+
+// Session escalation states. A pending escalation is claimed before the
+// ticket is created, so two admins cannot open two tickets.
+const (
+    EscalationPending = "pending"
+    EscalationCreated = "created"
+)
+
+// SessionEscalation records the ticket a chat session was handed over to
+type SessionEscalation struct {
+    Status      string    `bson:"status" json:"status"`
+    Provider    string    `bson:"provider" json:"provider"`
+    TicketID    string    `bson:"ticket_id,omitempty" json:"ticket_id,omitempty"`
+    TicketURL   string    `bson:"ticket_url,omitempty" json:"ticket_url,omitempty"`
+    EscalatedBy string    `bson:"escalated_by" json:"escalated_by"`
+    EscalatedAt time.Time `bson:"escalated_at" json:"escalated_at"`
+}
+
+// ChatArchive records a batch of chat messages exported to object storage
+type ChatArchive struct {
+    ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID    primitive.ObjectID `bson:"project_id" json:"project_id"`
+    StorageKey   string             `bson:"storage_key" json:"storage_key"`
+    MessageCount int                `bson:"message_count" json:"message_count"`
+    SizeBytes    int64              `bson:"size_bytes" json:"size_bytes"`
+    From         time.Time          `bson:"from" json:"from"`
+    To           time.Time          `bson:"to" json:"to"`
+    CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+    RestoredAt   *time.Time         `bson:"restored_at,omitempty" json:"restored_at,omitempty"`
+}
+
+// ProjectBlock bars an end user from a project's chat widget. A block
+// matches on any of ChatUserID, Email or IPAddress that is set. Revoked
+// blocks are kept as an audit trail.
+type ProjectBlock struct {
+    ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID  primitive.ObjectID `bson:"project_id" json:"project_id"`
+    ChatUserID primitive.ObjectID `bson:"chat_user_id,omitempty" json:"chat_user_id,omitempty"`
+    Email      string             `bson:"email,omitempty" json:"email,omitempty"`
+    IPAddress  string             `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+    Reason     string             `bson:"reason,omitempty" json:"reason,omitempty"`
+    CreatedBy  string             `bson:"created_by" json:"created_by"`
+    CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+    ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+    RevokedBy  string             `bson:"revoked_by,omitempty" json:"revoked_by,omitempty"`
+    RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// IsActive reports whether the block is in force at t
+func (b *ProjectBlock) IsActive(t time.Time) bool {
+    if b.RevokedAt != nil {
+        return false
+    }
+    return b.ExpiresAt == nil || b.ExpiresAt.After(t)
+}
+
+// AuditLog is an append-only record of privileged actions
+type AuditLog struct {
+    ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+    ActorID    string                 `bson:"actor_id" json:"actor_id"`
+    ActorRole  string                 `bson:"actor_role" json:"actor_role"`
+    Action     string                 `bson:"action" json:"action"`
+    TargetType string                 `bson:"target_type" json:"target_type"`
+    TargetID   string                 `bson:"target_id" json:"target_id"`
+    ProjectID  primitive.ObjectID     `bson:"project_id,omitempty" json:"project_id,omitempty"`
+    Details    map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+    IPAddress  string                 `bson:"ip_address" json:"ip_address"`
+    CreatedAt  time.Time              `bson:"created_at" json:"created_at"`
 }
 
 type Notification struct {
     ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
     ProjectID   primitive.ObjectID `bson:"project_id,omitempty" json:"project_id,omitempty"`
     UserID      primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
-    Type        string             `bson:"type" json:"type"` // "limit_expired", "success", "warning", "error", "info"
+    Type        string             `bson:"type" json:"type"` // "limit_expired", "success", "warning", "error", "info", "security"
     Title       string             `bson:"title" json:"title"`
     Message     string             `bson:"message" json:"message"`
+    Severity    string             `bson:"severity,omitempty" json:"severity,omitempty"` // "info", "warning" or "error"; unset on older notifications
     IsRead      bool               `bson:"is_read" json:"is_read"`
+    // Archived notifications leave the inbox but are kept until they expire
+    IsArchived  bool               `bson:"is_archived,omitempty" json:"is_archived"`
+    ArchivedAt  time.Time          `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
     CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
     ExpiresAt   time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
     Metadata    map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
 }
 
+// AnalyticsShareLink lets someone without a dashboard account view a
+// project's analytics until it expires or is revoked. Only the token's hash
+// is stored; the link is shown once, when it is created.
+type AnalyticsShareLink struct {
+    ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID    primitive.ObjectID `bson:"project_id" json:"project_id"`
+    Label        string             `bson:"label,omitempty" json:"label,omitempty"`
+    TokenHash    string             `bson:"token_hash" json:"-"`
+    Days         int                `bson:"days" json:"days"`         // length of the daily series shown
+    Timezone     string             `bson:"timezone" json:"timezone"` // days are bucketed in this zone
+    CreatedBy    string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+    CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+    ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+    RevokedAt    time.Time          `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+    Views        int64              `bson:"views" json:"views"`
+    LastViewedAt time.Time          `bson:"last_viewed_at,omitempty" json:"last_viewed_at,omitempty"`
+}
+
+// ChatEvent is an append-only record of something that happened in a
+// project's chats. Events are written once and never updated; consumers
+// read them in _id order.
+type ChatEvent struct {
+    ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+    Type      string                 `bson:"type" json:"type"`
+    ProjectID primitive.ObjectID     `bson:"project_id" json:"project_id"`
+    SessionID string                 `bson:"session_id,omitempty" json:"session_id,omitempty"`
+    UserID    primitive.ObjectID     `bson:"user_id,omitempty" json:"user_id,omitempty"`
+    MessageID primitive.ObjectID     `bson:"message_id,omitempty" json:"message_id,omitempty"`
+    Data      map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+    CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// Chat event types
+const (
+    ChatEventMessageSent       = "message_sent"
+    ChatEventResponseGenerated = "response_generated"
+    ChatEventSessionClosed     = "session_closed"
+    ChatEventLimitCrossed      = "limit_crossed"
+)
+
+// Webhook is an endpoint of the customer's that is sent a project's events
+// as they happen. Deliveries are signed with the server's WEBHOOK_SECRET.
+type Webhook struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID   primitive.ObjectID `bson:"project_id" json:"project_id"`
+    URL         string             `bson:"url" json:"url"`
+    Events      []string           `bson:"events" json:"events"`
+    Description string             `bson:"description,omitempty" json:"description,omitempty"`
+    IsActive    bool               `bson:"is_active" json:"is_active"`
+    CreatedBy   string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+    CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+    UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// WebhookDelivery is one event sent, or being sent, to a webhook. Failed
+// attempts are retried at NextAttemptAt until the delivery succeeds or
+// runs out of attempts.
+type WebhookDelivery struct {
+    ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    WebhookID      primitive.ObjectID `bson:"webhook_id" json:"webhook_id"`
+    ProjectID      primitive.ObjectID `bson:"project_id" json:"project_id"`
+    Event          string             `bson:"event" json:"event"`
+    Payload        string             `bson:"payload" json:"payload"` // the JSON body sent
+    Status         string             `bson:"status" json:"status"`
+    Attempts       int                `bson:"attempts" json:"attempts"`
+    ResponseStatus int                `bson:"response_status,omitempty" json:"response_status,omitempty"`
+    Error          string             `bson:"error,omitempty" json:"error,omitempty"`
+    NextAttemptAt  time.Time          `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+    CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+    DeliveredAt    time.Time          `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}
+
+// Webhook events
+const (
+    WebhookEventMessageCreated = "message.created"
+    WebhookEventLimitReached   = "limit.reached"
+    WebhookEventPDFProcessed   = "pdf.processed"
+)
+
+// WebhookEvents are the events webhooks can subscribe to
+var WebhookEvents = []string{WebhookEventMessageCreated, WebhookEventLimitReached, WebhookEventPDFProcessed}
+
+// Webhook delivery statuses
+const (
+    WebhookDeliveryPending   = "pending"
+    WebhookDeliverySucceeded = "succeeded"
+    WebhookDeliveryFailed    = "failed"
+)
+
+// TranscriptImport is one upload of historical conversations from another
+// chat vendor. Its sessions and messages carry its ID, so a batch can be
+// told apart from live traffic and removed as a whole.
+type TranscriptImport struct {
+    ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID       primitive.ObjectID `bson:"project_id" json:"project_id"`
+    Source          string             `bson:"source" json:"source"`
+    FileName        string             `bson:"file_name" json:"file_name"`
+    Status          string             `bson:"status" json:"status"`
+    Sessions        int                `bson:"sessions" json:"sessions"`
+    Messages        int                `bson:"messages" json:"messages"`
+    // Conversations already imported by an earlier batch
+    SkippedSessions int                `bson:"skipped_sessions" json:"skipped_sessions"`
+    RejectedRows    int                `bson:"rejected_rows" json:"rejected_rows"`
+    FirstMessageAt  time.Time          `bson:"first_message_at,omitempty" json:"first_message_at,omitempty"`
+    LastMessageAt   time.Time          `bson:"last_message_at,omitempty" json:"last_message_at,omitempty"`
+    Error           string             `bson:"error,omitempty" json:"error,omitempty"`
+    ImportedBy      string             `bson:"imported_by,omitempty" json:"imported_by,omitempty"`
+    CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+    CompletedAt     time.Time          `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// Transcript import statuses
+const (
+    TranscriptImportRunning   = "importing"
+    TranscriptImportCompleted = "completed"
+    TranscriptImportFailed    = "failed"
+)
+
 
 
 // ===== HELPER METHODS =====
 
+// ParseProjectID converts a route or request parameter into the ObjectID
+// every collection stores project_id as. Never store or query the raw string.
+func ParseProjectID(id string) (primitive.ObjectID, error) {
+    objID, err := primitive.ObjectIDFromHex(id)
+    if err != nil {
+        return primitive.NilObjectID, fmt.Errorf("invalid project ID: %q", id)
+    }
+    return objID, nil
+}
+
+// ProjectIDMatch matches a project_id stored either as an ObjectID or as a
+// legacy hex string. Use it for chat_users until BackfillProjectIDs has run.
+func ProjectIDMatch(id primitive.ObjectID) bson.M {
+    return bson.M{"$in": bson.A{id, id.Hex()}}
+}
+
 // IsAdmin checks if user has admin role
 func (u *User) IsAdmin() bool {
     return u.Role == RoleAdmin
 }
 
+// IsSuperAdmin checks if user is a platform operator
+func (u *User) IsSuperAdmin() bool {
+    return u.Role == RoleSuperAdmin
+}
+
+// HasAdminAccess checks if user can use the admin dashboard
+func (u *User) HasAdminAccess() bool {
+    return u.Role == RoleAdmin || u.Role == RoleSuperAdmin
+}
+
+// Location returns the user's timezone, or UTC if unset or unknown
+func (u *User) Location() *time.Location {
+    if u.Timezone != "" {
+        if loc, err := time.LoadLocation(u.Timezone); err == nil {
+            return loc
+        }
+    }
+    return time.UTC
+}
+
+// PreferredLocale returns the user's locale, or DefaultLocale
+func (u *User) PreferredLocale() string {
+    for _, l := range SupportedLocales {
+        if u.Locale == l {
+            return l
+        }
+    }
+    return DefaultLocale
+}
+
 // IsUser checks if user has regular user role
 func (u *User) IsUser() bool {
     return u.Role == RoleUser
@@ -177,21 +1307,81 @@ func (p *Project) GetUsagePercentage() float64 {
     return float64(p.GeminiUsageMonth) / float64(p.GeminiMonthlyLimit) * 100
 }
 
-// IsProcessed checks if PDF file is successfully processed
-func (pdf *PDFFile) IsProcessed() bool {
-    return pdf.Status == "completed"
+// OverageRemaining is how many overage requests are left this period
+func (p *Project) OverageRemaining() int {
+    if !p.OverageEnabled || p.GeminiOverageMonth >= p.OverageCap {
+        return 0
+    }
+    return p.OverageCap - p.GeminiOverageMonth
 }
 
-// IsFailed checks if PDF processing failed
-func (pdf *PDFFile) IsFailed() bool {
-    return pdf.Status == "failed"
+// CanUseGemini reports whether another request fits in the monthly limit
+// or, failing that, the overage allowance
+func (p *Project) CanUseGemini() bool {
+    return p.IsWithinLimit() || p.OverageRemaining() > 0
+}
+
+// CycleDay is the day of the month the project's usage window resets on
+func (p *Project) CycleDay() int {
+    if p.BillingCycleDay >= 1 && p.BillingCycleDay <= 31 {
+        return p.BillingCycleDay
+    }
+    if !p.CreatedAt.IsZero() {
+        return p.CreatedAt.UTC().Day()
+    }
+    return 1
+}
+
+// BillingPeriod returns the usage window containing t, in UTC. Windows run
+// from the cycle day to the same day next month; in shorter months a cycle
+// day past the end of the month falls on its last day.
+func (p *Project) BillingPeriod(t time.Time) (start, end time.Time) {
+    t = t.UTC()
+    day := p.CycleDay()
+    start = cycleDate(t.Year(), t.Month(), day)
+    if t.Before(start) {
+        start = cycleDate(t.Year(), t.Month()-1, day)
+    }
+    end = cycleDate(start.Year(), start.Month()+1, day)
+    return start, end
+}
+
+// cycleDate is midnight UTC on day of the given month, clamped to its length
+func cycleDate(year int, month time.Month, day int) time.Time {
+    first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+    if last := first.AddDate(0, 1, -1).Day(); day > last {
+        day = last
+    }
+    return first.AddDate(0, 0, day-1)
+}
+
+// IsProcessed checks if the file was extracted successfully
+func (f *SourceFile) IsProcessed() bool {
+    return f.Status == "completed"
+}
+
+// IsFailed checks if extracting the file failed
+func (f *SourceFile) IsFailed() bool {
+    return f.Status == "failed"
+}
+
+// FileType is the file's SourceType; files recorded before other types were
+// accepted are PDFs
+func (f *SourceFile) FileType() string {
+    if f.Type == "" {
+        return SourceTypePDF
+    }
+    return f.Type
 }
 
 // ===== CONSTANTS =====
 
+// RoleAdmin is a customer (tenant) admin; RoleSuperAdmin is a platform
+// operator with access to cross-tenant and infrastructure endpoints
 const (
-    RoleUser  = "user"
-    RoleAdmin = "admin"
+    RoleUser       = "user"
+    RoleAdmin      = "admin"
+    RoleSuperAdmin = "super_admin"
 )
 
 // PDF Processing Status Constants
@@ -201,16 +1391,229 @@ const (
     PDFStatusFailed     = "failed"
 )
 
+// Source file types accepted as project knowledge
+const (
+    SourceTypePDF      = "pdf"
+    SourceTypeDOCX     = "docx"
+    SourceTypeText     = "txt"
+    SourceTypeMarkdown = "md"
+    SourceTypeCSV      = "csv"
+)
+
+// Who removed a tombstoned chat message
+const (
+    TombstoneByUser  = "user"
+    TombstoneByAdmin = "admin"
+)
+
+// OCR outcomes of a scanned PDF
+const (
+    OCRStatusApplied = "applied" // the OCR text is what the file is answered from
+    OCRStatusNoText  = "no_text" // OCR found no more text than extraction did
+    OCRStatusFailed  = "failed"
+)
+
 // Gemini Model Constants
 const (
     GeminiModelFlash = "gemini-1.5-flash"
     GeminiModelPro   = "gemini-1.5-pro"
 )
 
+// Audit actions
+const (
+    AuditActionLegalHoldSet     = "project.legal_hold.set"
+    AuditActionLegalHoldCleared = "project.legal_hold.cleared"
+    AuditActionImpersonate      = "user.impersonate"
+    AuditActionRoleChange       = "user.role.change"
+    AuditActionIndexRepair      = "database.indexes.repair"
+    AuditActionOrphanRepair     = "database.orphans.repair"
+    AuditActionPasswordChange   = "user.password.change"
+    AuditActionSigningUpdate    = "project.signing.update"
+    AuditActionModerationUpdate = "project.moderation.update"
+    AuditActionBlockCreate      = "project.block.create"
+    AuditActionBlockRevoke      = "project.block.revoke"
+    AuditActionUsageReset       = "project.usage.reset"
+    AuditActionUserCreate       = "user.create"
+    AuditActionDryRunUpdate     = "project.dry_run.update"
+    AuditActionProfileUpdate    = "user.profile.update"
+    AuditActionBillingCycle     = "project.billing_cycle.update"
+    AuditActionOverageUpdate    = "project.overage.update"
+    AuditActionTicketingUpdate  = "project.ticketing.update"
+    AuditActionSessionEscalate  = "session.escalate"
+    AuditActionSessionRespond   = "session.human_response"
+    AuditActionBookingUpdate    = "project.booking.update"
+    AuditActionCatalogImport    = "project.catalog.import"
+    AuditActionCatalogClear     = "project.catalog.clear"
+    AuditActionOrderLookup      = "project.order_lookup.update"
+    AuditActionFreshnessUpdate  = "project.freshness.update"
+    AuditActionLibraryUpload    = "library.upload"
+    AuditActionLibraryDelete    = "library.delete"
+    AuditActionLibraryAttach    = "project.library.attach"
+    AuditActionLibraryDetach    = "project.library.detach"
+    AuditActionInstructions     = "project.instructions.update"
+    AuditActionRevisionComment  = "project.instructions.comment"
+    AuditActionWidgetUpdate     = "project.widget.update"
+    AuditActionFallbackUpdate   = "project.fallback.update"
+    AuditActionRatingUpdate     = "project.rating.update"
+    AuditActionTokenBinding     = "project.token_binding.update"
+    AuditActionTenantMove       = "project.database.move"
+    AuditActionUserDelete       = "user.delete"
+    AuditActionUserDeleted      = "user.delete.completed"
+    AuditActionDeletionPolicy   = "user.deletion_policy.update"
+    AuditActionTokenRevoke      = "project.chat_user.tokens.revoke"
+    AuditActionContinuity       = "project.session_continuity.update"
+    AuditActionEmbeddingsIndex  = "project.embeddings.reindex"
+    AuditActionUploadBatch      = "project.upload_batch.create"
+    AuditActionDocumentReplace  = "project.document.replace"
+    AuditActionProjectExport    = "project.export"
+    AuditActionMessageRedact    = "session.message.redact"
+    AuditActionAPIKeyCreate     = "project.api_key.create"
+    AuditActionAPIKeyRevoke     = "project.api_key.revoke"
+    AuditActionDomainsUpdate    = "project.allowed_domains.update"
+    AuditActionShareCreate      = "project.share_link.create"
+    AuditActionShareRevoke      = "project.share_link.revoke"
+    AuditActionSlackUpdate      = "project.slack.update"
+    AuditActionChatUserPurge    = "project.chat_user.purge"
+    AuditActionChatUserExport   = "project.chat_user.export"
+    AuditActionWelcomeFlow      = "project.welcome_flow.update"
+    AuditActionDomainAdd        = "project.domain.add"
+    AuditActionDomainVerify     = "project.domain.verify"
+    AuditActionDomainRemove     = "project.domain.remove"
+    AuditActionDiscordUpdate    = "project.discord.update"
+    AuditActionIntentsUpdate    = "project.intents.update"
+    AuditActionWebhookCreate    = "project.webhook.create"
+    AuditActionWebhookUpdate    = "project.webhook.update"
+    AuditActionWebhookDelete    = "project.webhook.delete"
+    AuditActionTranscriptImport = "project.transcripts.import"
+    AuditActionTranscriptDelete = "project.transcripts.delete"
+    AuditActionMigrationExport  = "project.migration.export"
+    AuditActionMigrationImport  = "project.migration.import"
+)
+
+// Moderation webhook fail policies
+const (
+    ModerationFailAllow = "allow"
+    ModerationFailDeny  = "deny"
+)
+
 const (
     NotificationTypeLimitExpired = "limit_expired"
     NotificationTypeSuccess      = "success"
     NotificationTypeWarning      = "warning"
     NotificationTypeError        = "error"
     NotificationTypeInfo         = "info"
-)
\ No newline at end of file
+    NotificationTypeSecurity     = "security"
+)
+
+// EmailNotificationTypes are the notification types users can opt in to
+// receive by email
+var EmailNotificationTypes = []string{
+    NotificationTypeLimitExpired,
+    NotificationTypeError,
+    NotificationTypeWarning,
+    NotificationTypeSuccess,
+    NotificationTypeInfo,
+}
+
+// Notification severities
+const (
+    NotificationSeverityInfo    = "info"
+    NotificationSeverityWarning = "warning"
+    NotificationSeverityError   = "error"
+)
+
+// NotificationSeverityTypes maps each severity to the notification types
+// that have it by default
+var NotificationSeverityTypes = map[string][]string{
+    NotificationSeverityInfo:    {NotificationTypeSuccess, NotificationTypeInfo},
+    NotificationSeverityWarning: {NotificationTypeLimitExpired, NotificationTypeWarning},
+    NotificationSeverityError:   {NotificationTypeError, NotificationTypeSecurity},
+}
+
+// NotificationSeverity is the default severity of a notification type
+func NotificationSeverity(notificationType string) string {
+    for severity, types := range NotificationSeverityTypes {
+        for _, t := range types {
+            if t == notificationType {
+                return severity
+            }
+        }
+    }
+    return NotificationSeverityInfo
+}
+// Deletion policies decide what becomes of the projects a user owns, and the
+// chats in them, when the user is deleted
+const (
+    DeletionPolicyRetain    = "retain"    // keep everything, unassign the owner
+    DeletionPolicyAnonymize = "anonymize" // keep conversations, strip personal data
+    DeletionPolicyCascade   = "cascade"   // delete the projects with everything in them
+)
+
+// ValidDeletionPolicy reports whether p is one of the DeletionPolicy values
+func ValidDeletionPolicy(p string) bool {
+    switch p {
+    case DeletionPolicyRetain, DeletionPolicyAnonymize, DeletionPolicyCascade:
+        return true
+    }
+    return false
+}
+
+// UploadBatch is a ZIP archive of documents uploaded in one request. Each
+// accepted file is queued and processed in the background; rejected files
+// are listed with the reason.
+type UploadBatch struct {
+    ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    ProjectID   primitive.ObjectID `bson:"project_id" json:"project_id"`
+    ArchiveName string             `bson:"archive_name" json:"archive_name"`
+    Status      string             `bson:"status" json:"status"` // pending, running, completed
+    Files       []UploadBatchFile  `bson:"files" json:"files"`
+    UploadedBy  string             `bson:"uploaded_by" json:"uploaded_by"`
+    Attempts    int                `bson:"attempts" json:"attempts"`
+    HeartbeatAt time.Time          `bson:"heartbeat_at,omitempty" json:"-"`
+    CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+    CompletedAt time.Time          `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// UploadBatchFile is one file of an UploadBatch
+type UploadBatchFile struct {
+    Name     string `bson:"name" json:"name"`
+    Size     int64  `bson:"size" json:"size"`
+    Status   string `bson:"status" json:"status"` // queued, processing, completed, failed, rejected, duplicate
+    Error    string `bson:"error,omitempty" json:"error,omitempty"`
+    FileID   string `bson:"file_id,omitempty" json:"file_id,omitempty"`
+    FilePath string `bson:"file_path,omitempty" json:"-"` // batches queued before object storage
+    StorageKey string `bson:"storage_key,omitempty" json:"-"`
+    Hash     string `bson:"hash,omitempty" json:"-"`
+
+    DuplicateOf *DocumentDuplicate `bson:"duplicate_of,omitempty" json:"duplicate_of,omitempty"`
+}
+
+// Upload batch file states
+const (
+    UploadFileQueued     = "queued"
+    UploadFileProcessing = "processing"
+    UploadFileCompleted  = "completed"
+    UploadFileFailed     = "failed"
+    UploadFileRejected   = "rejected"
+    UploadFileDuplicate  = "duplicate"
+)
+
+// UserDeletion is a user deletion carried out in the background. Counts
+// holds how many documents each step removed or anonymized.
+type UserDeletion struct {
+    ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+    UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+    Email         string             `bson:"email" json:"email"`
+    Policy        string             `bson:"policy" json:"policy"`
+    Status        string             `bson:"status" json:"status"` // pending, running, completed, failed
+    RequestedBy   string             `bson:"requested_by" json:"requested_by"`
+    ProjectsTotal int                `bson:"projects_total" json:"projects_total"`
+    ProjectsDone  int                `bson:"projects_done" json:"projects_done"`
+    Counts        map[string]int64   `bson:"counts,omitempty" json:"counts,omitempty"`
+    Attempts      int                `bson:"attempts" json:"attempts"`
+    Error         string             `bson:"error,omitempty" json:"error,omitempty"`
+    HeartbeatAt   time.Time          `bson:"heartbeat_at,omitempty" json:"-"`
+    CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+    StartedAt     time.Time          `bson:"started_at,omitempty" json:"started_at,omitempty"`
+    CompletedAt   time.Time          `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
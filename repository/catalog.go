@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// CatalogImport summarizes a catalog upload
+type CatalogImport struct {
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+	Removed int64 `json:"removed"`
+	Total   int64 `json:"total"`
+}
+
+// ImportProducts upserts products by SKU. With replace, products missing
+// from the upload are removed, so the catalog matches the file exactly.
+// The project's product count is refreshed afterwards.
+func ImportProducts(ctx context.Context, projectID primitive.ObjectID, products []models.Product, replace bool) (*CatalogImport, error) {
+	collection := config.GetProductsCollection()
+	result := &CatalogImport{}
+	now := time.Now()
+
+	skus := make(bson.A, 0, len(products))
+	writes := make([]mongo.WriteModel, 0, len(products))
+	for _, p := range products {
+		p.ProjectID = projectID
+		p.UpdatedAt = now
+		p.ID = primitive.NilObjectID
+		skus = append(skus, p.SKU)
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"project_id": projectID, "sku": p.SKU}).
+			SetReplacement(p).
+			SetUpsert(true))
+	}
+
+	if len(writes) > 0 {
+		res, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return nil, err
+		}
+		result.Created = res.UpsertedCount
+		result.Updated = res.MatchedCount
+	}
+
+	if replace {
+		res, err := collection.DeleteMany(ctx, bson.M{"project_id": projectID, "sku": bson.M{"$nin": skus}})
+		if err != nil {
+			return nil, err
+		}
+		result.Removed = res.DeletedCount
+	}
+
+	total, err := refreshCatalogCount(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	result.Total = total
+	return result, nil
+}
+
+// ClearCatalog removes every product of a project
+func ClearCatalog(ctx context.Context, projectID primitive.ObjectID) (int64, error) {
+	res, err := config.GetProductsCollection().DeleteMany(ctx, bson.M{"project_id": projectID})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := refreshCatalogCount(ctx, projectID); err != nil {
+		return res.DeletedCount, err
+	}
+	return res.DeletedCount, nil
+}
+
+func refreshCatalogCount(ctx context.Context, projectID primitive.ObjectID) (int64, error) {
+	total, err := config.GetProductsCollection().CountDocuments(ctx, bson.M{"project_id": projectID})
+	if err != nil {
+		return 0, err
+	}
+	_, err = config.GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": projectID}, bson.M{
+		"$set": bson.M{"catalog_products": total, "catalog_updated_at": time.Now()},
+	})
+	return total, err
+}
+
+// Words that say nothing about which product is meant
+var catalogStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "you": true, "your": true, "have": true,
+	"has": true, "how": true, "much": true, "what": true, "does": true, "is": true,
+	"are": true, "price": true, "cost": true, "costs": true, "stock": true, "available": true,
+	"availability": true, "buy": true, "there": true, "any": true, "with": true, "this": true,
+	"that": true, "can": true, "get": true, "sell": true, "still": true, "left": true,
+}
+
+var catalogTokenPattern = regexp.MustCompile(`[\pL\pN][\pL\pN\-_.]*`)
+
+// catalogTerms returns the words of a question worth matching products on
+func catalogTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, t := range catalogTokenPattern.FindAllString(strings.ToLower(query), -1) {
+		t = strings.TrimRight(t, ".-_")
+		if len(t) < 3 || catalogStopwords[t] || seen[t] {
+			continue
+		}
+		seen[t] = true
+		terms = append(terms, t)
+	}
+	if len(terms) > 12 {
+		terms = terms[:12]
+	}
+	return terms
+}
+
+// SearchProducts returns the products that best match a visitor's
+// question: an exact SKU outranks name matches, which outrank category and
+// description matches
+func SearchProducts(ctx context.Context, projectID primitive.ObjectID, query string, limit int) ([]models.Product, error) {
+	terms := catalogTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	or := bson.A{}
+	for _, t := range terms {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(t), Options: "i"}
+		or = append(or,
+			bson.M{"sku": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(t) + "$", Options: "i"}},
+			bson.M{"name": pattern},
+			bson.M{"category": pattern},
+		)
+	}
+	cursor, err := config.GetProductsCollection().Find(ctx,
+		bson.M{"project_id": projectID, "$or": or},
+		options.Find().SetLimit(200))
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.Product
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		product models.Product
+		score   int
+	}
+	var ranked []scored
+	for _, p := range candidates {
+		name, category, description := strings.ToLower(p.Name), strings.ToLower(p.Category), strings.ToLower(p.Description)
+		score := 0
+		for _, t := range terms {
+			switch {
+			case strings.EqualFold(p.SKU, t):
+				score += 10
+			case strings.Contains(name, t):
+				score += 3
+			case strings.Contains(category, t):
+				score++
+			case strings.Contains(description, t):
+				score++
+			}
+		}
+		if score > 0 {
+			ranked = append(ranked, scored{p, score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	products := make([]models.Product, len(ranked))
+	for i, r := range ranked {
+		products[i] = r.product
+	}
+	return products, nil
+}
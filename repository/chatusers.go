@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrChatUserNotFound = errors.New("chat user not found")
+
+// ChatUserPurge summarizes what erasing a chat user's data removed
+type ChatUserPurge struct {
+	Messages      int64 `json:"messages"`
+	Sessions      int64 `json:"sessions"`
+	UsageLogs     int64 `json:"usage_logs"`
+	Notifications int64 `json:"notifications"`
+	Redactions    int64 `json:"message_redactions"`
+	UserTokens    int64 `json:"chat_user_tokens"`
+	Events        int64 `json:"chat_events"`
+	Archived      int64 `json:"archived_messages"`
+}
+
+// chatUserNotifications matches the notifications raised about a chat
+// user. Their user_id is the dashboard user notified, so the chat user is
+// found in the metadata.
+func chatUserNotifications(projectID, userID primitive.ObjectID) bson.M {
+	return bson.M{"project_id": projectID, "metadata.chat_user_id": userID.Hex()}
+}
+
+// PurgeChatUser erases a chat user of a project together with everything
+// recorded about them, for a data subject's erasure request. Their messages
+// are first removed from the archives in object storage; the database
+// writes then happen in one transaction. Without transactions the user
+// document is deleted last, so a failed run can simply be retried.
+func PurgeChatUser(ctx context.Context, projectID, userID primitive.ObjectID) (*ChatUserPurge, error) {
+	result := &ChatUserPurge{}
+
+	filter := bson.M{"_id": userID, "project_id": models.ProjectIDMatch(projectID)}
+	if err := config.GetChatUsersCollection().FindOne(ctx, filter).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrChatUserNotFound
+		}
+		return nil, err
+	}
+
+	archived, err := config.PurgeArchivedMessages(ctx, projectID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge archived messages: %v", err)
+	}
+
+	err = WithTransaction(ctx, func(ctx context.Context) error {
+		*result = ChatUserPurge{Archived: int64(archived)}
+
+		if err := config.GetChatUsersCollection().FindOne(ctx, filter).Err(); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrChatUserNotFound
+			}
+			return err
+		}
+
+		owned := bson.M{"project_id": projectID, "user_id": userID}
+		steps := []struct {
+			collection string
+			filter     bson.M
+			count      *int64
+		}{
+			{"chat_messages", owned, &result.Messages},
+			{"chat_sessions", owned, &result.Sessions},
+			{"gemini_usage_logs", owned, &result.UsageLogs},
+			{"notifications", chatUserNotifications(projectID, userID), &result.Notifications},
+			{"message_redactions", owned, &result.Redactions},
+			{"chat_user_tokens", owned, &result.UserTokens},
+			{"chat_events", owned, &result.Events},
+		}
+		for _, step := range steps {
+			res, err := config.TenantCollection(projectID, step.collection).DeleteMany(ctx, step.filter)
+			if err != nil {
+				return fmt.Errorf("failed to delete %s: %v", step.collection, err)
+			}
+			*step.count = res.DeletedCount
+		}
+
+		if _, err := config.GetChatUsersCollection().DeleteOne(ctx, filter); err != nil {
+			return fmt.Errorf("failed to delete chat user: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ChatUserExport is a copy of everything stored about one chat user, for a
+// data subject's access request. The password hash is left out.
+type ChatUserExport struct {
+	ExportedAt    time.Time                 `json:"exported_at"`
+	ProjectID     primitive.ObjectID        `json:"project_id"`
+	Profile       models.ChatUser           `json:"profile"`
+	Sessions      []models.ChatSession      `json:"sessions"`
+	Messages      []models.ChatMessage      `json:"messages"`
+	Ratings       []ChatUserRating          `json:"ratings"`
+	UsageLogs     []models.GeminiUsageLog   `json:"usage_logs"`
+	Notifications []models.Notification     `json:"notifications"`
+	Redactions    []models.MessageRedaction `json:"message_redactions"`
+}
+
+// ChatUserRating is a rating the chat user gave an answer
+type ChatUserRating struct {
+	MessageID primitive.ObjectID `json:"message_id"`
+	SessionID string             `json:"session_id"`
+	Rating    int                `json:"rating"`
+	Feedback  string             `json:"feedback,omitempty"`
+	RatedAt   time.Time          `json:"rated_at"`
+}
+
+// ExportChatUser loads a chat user of a project and everything recorded
+// about them. Messages already moved to object storage by the retention
+// job are not included.
+func ExportChatUser(ctx context.Context, projectID, userID primitive.ObjectID) (*ChatUserExport, error) {
+	export := &ChatUserExport{ExportedAt: time.Now(), ProjectID: projectID}
+
+	err := config.GetChatUsersCollection().FindOne(ctx,
+		bson.M{"_id": userID, "project_id": models.ProjectIDMatch(projectID)}).Decode(&export.Profile)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrChatUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	owned := bson.M{"project_id": projectID, "user_id": userID}
+	loads := []struct {
+		collection *mongo.Collection
+		filter     bson.M
+		sort       string
+		into       interface{}
+	}{
+		{config.GetChatSessionsCollectionFor(projectID), owned, "start_time", &export.Sessions},
+		{config.GetChatMessagesCollectionFor(projectID), owned, "timestamp", &export.Messages},
+		{config.GetGeminiUsageLogsCollection(), owned, "timestamp", &export.UsageLogs},
+		{config.GetNotificationsCollection(), chatUserNotifications(projectID, userID), "created_at", &export.Notifications},
+		{config.GetMessageRedactionsCollection(), owned, "created_at", &export.Redactions},
+	}
+	for _, l := range loads {
+		opts := options.Find().SetSort(bson.D{{Key: l.sort, Value: 1}})
+		cursor, err := l.collection.Find(ctx, l.filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", l.collection.Name(), err)
+		}
+		if err := cursor.All(ctx, l.into); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", l.collection.Name(), err)
+		}
+	}
+
+	export.Ratings = []ChatUserRating{}
+	for _, m := range export.Messages {
+		if m.Rating > 0 {
+			export.Ratings = append(export.Ratings, ChatUserRating{
+				MessageID: m.ID,
+				SessionID: m.SessionID,
+				Rating:    m.Rating,
+				Feedback:  m.Feedback,
+				RatedAt:   m.RatedAt,
+			})
+		}
+	}
+	return export, nil
+}
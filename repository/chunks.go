@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// ScoredChunk is a document chunk with the number of search terms it holds
+type ScoredChunk struct {
+	models.DocumentChunk
+	Score int
+}
+
+// RetrievalConfidence is the share of the text's topic words found in the
+// project's best matching chunk, from 0 to 1. Text without topic words
+// scores 0.
+func RetrievalConfidence(ctx context.Context, projectID primitive.ObjectID, text string) (float64, error) {
+	terms := len(questionTerms(text))
+	if terms > 16 {
+		terms = 16
+	}
+	if terms == 0 {
+		return 0, nil
+	}
+	best, err := SearchChunks(ctx, projectID, text, 1, 1)
+	if err != nil || len(best) == 0 {
+		return 0, err
+	}
+	return float64(best[0].Score) / float64(terms), nil
+}
+
+// SearchChunks returns the project's document chunks that share the most
+// topic words with the text, best first. Chunks matching fewer than
+// minScore distinct terms are left out.
+func SearchChunks(ctx context.Context, projectID primitive.ObjectID, text string, minScore, limit int) ([]ScoredChunk, error) {
+	terms := questionTerms(text)
+	if len(terms) > 16 {
+		terms = terms[:16]
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	or := bson.A{}
+	for _, t := range terms {
+		or = append(or, bson.M{"text": primitive.Regex{Pattern: regexp.QuoteMeta(t), Options: "i"}})
+	}
+	cursor, err := config.GetDocumentChunksCollection().Find(ctx,
+		bson.M{"project_id": projectID, "$or": or},
+		options.Find().SetLimit(300))
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.DocumentChunk
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	var ranked []ScoredChunk
+	for _, chunk := range candidates {
+		lower := strings.ToLower(chunk.Text)
+		score := 0
+		for _, t := range terms {
+			if strings.Contains(lower, t) {
+				score++
+			}
+		}
+		if score >= minScore {
+			ranked = append(ranked, ScoredChunk{chunk, score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, nil
+}
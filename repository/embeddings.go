@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// ReplaceFileEmbeddings replaces the passage embeddings of one of a
+// project's PDF files and marks the file embedded
+func ReplaceFileEmbeddings(ctx context.Context, projectID primitive.ObjectID, fileID string, rows []models.ChunkEmbedding) error {
+	return WithTransaction(ctx, func(ctx context.Context) error {
+		collection := config.GetChunkEmbeddingsCollection()
+		if _, err := collection.DeleteMany(ctx, bson.M{"project_id": projectID, "file_id": fileID}); err != nil {
+			return err
+		}
+		if err := insertEmbeddings(ctx, rows); err != nil {
+			return err
+		}
+		now := time.Now()
+		result, err := config.GetProjectsCollection().UpdateOne(ctx,
+			bson.M{"_id": projectID, "pdf_files.id": fileID},
+			bson.M{"$set": bson.M{"pdf_files.$.embedded_at": now, "updated_at": now}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrProjectNotFound
+		}
+		return nil
+	})
+}
+
+// ReplaceLibraryEmbeddings replaces the passage embeddings of a library
+// document and marks it embedded
+func ReplaceLibraryEmbeddings(ctx context.Context, documentID primitive.ObjectID, rows []models.ChunkEmbedding) error {
+	return WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := config.GetChunkEmbeddingsCollection().DeleteMany(ctx, bson.M{"library_document_id": documentID}); err != nil {
+			return err
+		}
+		if err := insertEmbeddings(ctx, rows); err != nil {
+			return err
+		}
+		result, err := config.GetLibraryDocumentsCollection().UpdateOne(ctx,
+			bson.M{"_id": documentID},
+			bson.M{"$set": bson.M{"embedded_at": time.Now()}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrLibraryDocumentNotFound
+		}
+		return nil
+	})
+}
+
+func insertEmbeddings(ctx context.Context, rows []models.ChunkEmbedding) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(rows))
+	for i := range rows {
+		docs[i] = rows[i]
+	}
+	_, err := config.GetChunkEmbeddingsCollection().InsertMany(ctx, docs)
+	return err
+}
+
+// KnowledgeEmbeddings returns the passage embeddings a project answers
+// from: those of its own files and of the given library documents
+func KnowledgeEmbeddings(ctx context.Context, projectID primitive.ObjectID, libraryIDs []primitive.ObjectID) ([]models.ChunkEmbedding, error) {
+	or := bson.A{bson.M{"project_id": projectID}}
+	if len(libraryIDs) > 0 {
+		or = append(or, bson.M{"library_document_id": bson.M{"$in": libraryIDs}})
+	}
+	cursor, err := config.GetChunkEmbeddingsCollection().Find(ctx, bson.M{
+		"$or":   or,
+		"model": config.EmbeddingModel(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []models.ChunkEmbedding
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// FileEmbeddings returns the passage embeddings of one of a project's
+// files made with the current embedding model
+func FileEmbeddings(ctx context.Context, projectID primitive.ObjectID, fileID string) ([]models.ChunkEmbedding, error) {
+	cursor, err := config.GetChunkEmbeddingsCollection().Find(ctx, bson.M{
+		"project_id": projectID,
+		"file_id":    fileID,
+		"model":      config.EmbeddingModel(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []models.ChunkEmbedding
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+	"jevi-chat/utils"
+)
+
+// ProjectExport is a copy of everything stored for one project. API keys,
+// secrets and password hashes are left out.
+type ProjectExport struct {
+	ExportedAt time.Time                 `json:"exported_at"`
+	Project    models.Project            `json:"project"`
+	ChatUsers  []models.ChatUser         `json:"chat_users"`
+	Sessions   []models.ChatSession      `json:"sessions"`
+	Messages   []models.ChatMessage      `json:"messages"`
+	UsageDaily []models.GeminiUsageDaily `json:"usage_daily"`
+	Archives   []models.ChatArchive      `json:"archives"`
+	// The redaction profile applied, if any
+	Redaction string `json:"redaction,omitempty"`
+}
+
+// ExportProject loads a project and its data. Messages already moved to
+// object storage are listed under Archives, not inlined.
+func ExportProject(ctx context.Context, projectID primitive.ObjectID) (*ProjectExport, error) {
+	export := &ProjectExport{ExportedAt: time.Now()}
+
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": projectID}).Decode(&export.Project); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	export.Project.GeminiAPIKey = ""
+
+	byProject := bson.M{"project_id": projectID}
+	loads := []struct {
+		collection *mongo.Collection
+		filter     bson.M
+		sort       string
+		into       interface{}
+	}{
+		{config.GetChatUsersCollection(), bson.M{"project_id": models.ProjectIDMatch(projectID)}, "created_at", &export.ChatUsers},
+		{config.GetChatSessionsCollectionFor(projectID), byProject, "start_time", &export.Sessions},
+		{config.GetChatMessagesCollectionFor(projectID), byProject, "timestamp", &export.Messages},
+		{config.GetGeminiUsageDailyCollection(), byProject, "date", &export.UsageDaily},
+		{config.GetChatArchivesCollection(), byProject, "created_at", &export.Archives},
+	}
+	for _, l := range loads {
+		opts := options.Find().SetSort(bson.D{{Key: l.sort, Value: 1}})
+		cursor, err := l.collection.Find(ctx, l.filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", l.collection.Name(), err)
+		}
+		if err := cursor.All(ctx, l.into); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", l.collection.Name(), err)
+		}
+	}
+
+	return export, nil
+}
+
+// Redact hides what the profile says from the export's people and
+// transcripts, for sharing it outside the company
+func (e *ProjectExport) Redact(profile utils.RedactionProfile) {
+	e.Redaction = profile.Name
+	r := utils.NewRedactor(profile)
+	if !r.Enabled() {
+		return
+	}
+
+	for _, u := range e.ChatUsers {
+		r.AddNames(u.Name)
+	}
+	for _, m := range e.Messages {
+		r.AddNames(m.UserName)
+	}
+	for i := range e.ChatUsers {
+		u := &e.ChatUsers[i]
+		u.Name = r.Name(u.Name)
+		u.Email = r.Email(u.Email)
+	}
+	for i := range e.Sessions {
+		e.Sessions[i].IPAddress = r.IP(e.Sessions[i].IPAddress)
+	}
+	for i := range e.Messages {
+		m := &e.Messages[i]
+		m.Message = r.Text(m.Message)
+		m.Response = r.Text(m.Response)
+		m.UserName = r.Name(m.UserName)
+		m.UserEmail = r.Email(m.UserEmail)
+		m.IPAddress = r.IP(m.IPAddress)
+	}
+}
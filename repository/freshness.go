@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+const (
+	// DefaultKnowledgeMaxAgeDays applies to projects without their own limit
+	DefaultKnowledgeMaxAgeDays = 90
+
+	freshnessSampleDays = 7
+	freshnessSampleSize = 500
+	freshnessTopTerms   = 10
+)
+
+// KnowledgeFreshness describes how current a project's knowledge base is
+// and how many recent questions it does not seem to cover
+type KnowledgeFreshness struct {
+	Documents         int       `json:"documents"`
+	NewestProcessedAt time.Time `json:"newest_processed_at,omitempty"`
+	AgeDays           int       `json:"age_days"`
+	MaxAgeDays        int       `json:"max_age_days"`
+	Stale             bool      `json:"stale"`
+
+	QuestionsSampled   int      `json:"questions_sampled"`
+	UncoveredQuestions int      `json:"uncovered_questions"`
+	UncoveredRatio     float64  `json:"uncovered_ratio"`
+	MissingTopics      []string `json:"missing_topics"`
+}
+
+// Words that appear in questions about anything
+var freshnessStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "you": true, "your": true, "have": true,
+	"has": true, "how": true, "what": true, "does": true, "are": true, "there": true,
+	"any": true, "with": true, "this": true, "that": true, "can": true, "get": true,
+	"when": true, "where": true, "why": true, "who": true, "which": true, "will": true,
+	"would": true, "could": true, "should": true, "about": true, "from": true, "please": true,
+	"hello": true, "thanks": true, "thank": true, "need": true, "want": true, "know": true,
+	"tell": true, "much": true, "many": true, "there's": true, "it's": true, "not": true,
+	"but": true, "was": true, "were": true, "been": true, "our": true, "their": true,
+}
+
+// questionTerms returns the words of a question that name its topic
+func questionTerms(question string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, t := range catalogTokenPattern.FindAllString(strings.ToLower(question), -1) {
+		t = strings.TrimRight(t, ".-_")
+		if len(t) < 4 || freshnessStopwords[t] || seen[t] {
+			continue
+		}
+		seen[t] = true
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// GetKnowledgeFreshness measures the age of a project's newest processed
+// document, shared library documents included, and samples the last week's
+// questions. A question counts as uncovered when none of its topic words
+// appear in the extracted text.
+func GetKnowledgeFreshness(ctx context.Context, project models.Project, now time.Time) (*KnowledgeFreshness, error) {
+	f := &KnowledgeFreshness{
+		MaxAgeDays:    project.KnowledgeMaxAgeDays,
+		MissingTopics: []string{},
+	}
+	if f.MaxAgeDays <= 0 {
+		f.MaxAgeDays = DefaultKnowledgeMaxAgeDays
+	}
+
+	for _, file := range project.PDFFiles {
+		if file.Status != "completed" || file.ProcessedAt.IsZero() {
+			continue
+		}
+		f.Documents++
+		if file.ProcessedAt.After(f.NewestProcessedAt) {
+			f.NewestProcessedAt = file.ProcessedAt
+		}
+	}
+	library, err := AttachedLibraryDocuments(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range library {
+		f.Documents++
+		if doc.ProcessedAt.After(f.NewestProcessedAt) {
+			f.NewestProcessedAt = doc.ProcessedAt
+		}
+	}
+	if f.Documents > 0 {
+		f.AgeDays = int(now.Sub(f.NewestProcessedAt).Hours() / 24)
+		f.Stale = f.AgeDays > f.MaxAgeDays
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(freshnessSampleSize).
+		SetProjection(bson.M{"message": 1})
+	cursor, err := config.GetChatMessagesCollectionFor(project.ID).Find(ctx, bson.M{
+		"project_id": project.ID,
+		"timestamp":  bson.M{"$gte": now.AddDate(0, 0, -freshnessSampleDays)},
+		"dry_run":    bson.M{"$ne": true},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var messages []struct {
+		Message string `bson:"message"`
+	}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	var knowledge strings.Builder
+	knowledge.WriteString(project.PDFContent)
+	for _, doc := range library {
+		knowledge.WriteString("\n\n")
+		knowledge.WriteString(doc.Content)
+	}
+	content := strings.ToLower(knowledge.String())
+	missing := make(map[string]int)
+	for _, m := range messages {
+		terms := questionTerms(m.Message)
+		if len(terms) == 0 {
+			continue
+		}
+		f.QuestionsSampled++
+
+		covered := false
+		for _, t := range terms {
+			if strings.Contains(content, t) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		f.UncoveredQuestions++
+		for _, t := range terms {
+			missing[t]++
+		}
+	}
+	if f.QuestionsSampled > 0 {
+		f.UncoveredRatio = float64(f.UncoveredQuestions) / float64(f.QuestionsSampled)
+	}
+
+	for t := range missing {
+		f.MissingTopics = append(f.MissingTopics, t)
+	}
+	sort.Slice(f.MissingTopics, func(i, j int) bool {
+		a, b := f.MissingTopics[i], f.MissingTopics[j]
+		if missing[a] != missing[b] {
+			return missing[a] > missing[b]
+		}
+		return a < b
+	})
+	if len(f.MissingTopics) > freshnessTopTerms {
+		f.MissingTopics = f.MissingTopics[:freshnessTopTerms]
+	}
+	return f, nil
+}
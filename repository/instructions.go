@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrRevisionNotFound = errors.New("instruction revision not found")
+
+// SaveInstructions sets a project's bot instructions and records them as
+// the next revision. The revision number comes from an atomic increment on
+// the project, so concurrent saves get distinct numbers.
+func SaveInstructions(ctx context.Context, projectID primitive.ObjectID, instructions, note, authorID string) (*models.InstructionRevision, error) {
+	var revision *models.InstructionRevision
+	err := WithTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		var project models.Project
+		err := config.GetProjectsCollection().FindOneAndUpdate(ctx,
+			bson.M{"_id": projectID},
+			bson.M{
+				"$set": bson.M{"instructions": instructions, "updated_at": now},
+				"$inc": bson.M{"instructions_revision": 1},
+			},
+			options.FindOneAndUpdate().
+				SetReturnDocument(options.After).
+				SetProjection(bson.M{"instructions_revision": 1}),
+		).Decode(&project)
+		if err == mongo.ErrNoDocuments {
+			return ErrProjectNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		revision = &models.InstructionRevision{
+			ProjectID:    projectID,
+			Revision:     project.InstructionsRevision,
+			Instructions: instructions,
+			Note:         note,
+			AuthorID:     authorID,
+			CreatedAt:    now,
+			Comments:     []models.RevisionComment{},
+		}
+		res, err := config.GetInstructionRevisionsCollection().InsertOne(ctx, revision)
+		if err != nil {
+			return err
+		}
+		revision.ID = res.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// GetInstructionRevision returns one revision of a project's instructions
+func GetInstructionRevision(ctx context.Context, projectID primitive.ObjectID, revision int) (*models.InstructionRevision, error) {
+	var r models.InstructionRevision
+	err := config.GetInstructionRevisionsCollection().FindOne(ctx, bson.M{"project_id": projectID, "revision": revision}).Decode(&r)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// AddRevisionComment appends a review comment to a revision
+func AddRevisionComment(ctx context.Context, projectID primitive.ObjectID, revision int, authorID, body string) (*models.RevisionComment, error) {
+	comment := models.RevisionComment{
+		ID:        primitive.NewObjectID(),
+		AuthorID:  authorID,
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+	res, err := config.GetInstructionRevisionsCollection().UpdateOne(ctx,
+		bson.M{"project_id": projectID, "revision": revision},
+		bson.M{"$push": bson.M{"comments": comment}})
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, ErrRevisionNotFound
+	}
+	return &comment, nil
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// NewSigningKeyPair returns a public token and secret for widget request
+// signing
+func NewSigningKeyPair() (token, secret string) {
+	return "pk_" + randomHex(16), "sk_" + randomHex(32)
+}
+
+// NewModerationSecret returns a secret for signing moderation webhook calls
+func NewModerationSecret() string {
+	return "whsec_" + randomHex(32)
+}
+
+// NewOrderLookupSecret returns a secret for signing order lookup calls
+func NewOrderLookupSecret() string {
+	return "whsec_" + randomHex(32)
+}
+
+// NewDryRunKey returns a key that puts widget requests in dry-run mode
+func NewDryRunKey() string {
+	return "dryrun_" + randomHex(24)
+}
+
+// NewPublishableKey returns a publishable widget API key
+func NewPublishableKey() string {
+	return "jpk_" + randomHex(24)
+}
+
+// NewSecretAPIKey returns a secret server-to-server API key
+func NewSecretAPIKey() string {
+	return "jsk_" + randomHex(24)
+}
+
+// CreateProjectAPIKey issues a new key for the project with the given
+// scopes, or chat:write only when there are none. A key that can only chat
+// is a publishable widget key; any other is secret, and only the returned
+// key carries it, since just its hash is kept.
+func CreateProjectAPIKey(ctx context.Context, projectID primitive.ObjectID, name, createdBy string, scopes []string, rateLimit int) (models.ProjectAPIKey, error) {
+	if len(scopes) == 0 {
+		scopes = []string{models.APIScopeChatWrite}
+	}
+	key := models.ProjectAPIKey{
+		ID:        "key_" + randomHex(8),
+		Name:      name,
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	stored := key
+	if key.Secret() {
+		key.Key = NewSecretAPIKey()
+		stored.KeyHash = models.HashAPIKey(key.Key)
+		stored.KeyHint = key.Key[len(key.Key)-4:]
+		key.KeyHint = stored.KeyHint
+	} else {
+		key.Key = NewPublishableKey()
+		stored.Key = key.Key
+	}
+
+	result, err := config.GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": projectID}, bson.M{
+		"$push": bson.M{"api_keys": stored},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		return models.ProjectAPIKey{}, err
+	}
+	if result.MatchedCount == 0 {
+		return models.ProjectAPIKey{}, ErrProjectNotFound
+	}
+	return key, nil
+}
+
+// TouchProjectAPIKey records that a key was just used, and from where
+func TouchProjectAPIKey(ctx context.Context, projectID primitive.ObjectID, keyID, ip string) error {
+	_, err := config.GetProjectsCollection().UpdateOne(ctx,
+		bson.M{"_id": projectID, "api_keys.id": keyID},
+		bson.M{"$set": bson.M{"api_keys.$.last_used_at": time.Now(), "api_keys.$.last_used_ip": ip}},
+	)
+	return err
+}
+
+// RevokeProjectAPIKey stops a publishable key from being accepted. Revoking
+// a key twice keeps the first revocation time.
+func RevokeProjectAPIKey(ctx context.Context, projectID primitive.ObjectID, keyID string) error {
+	result, err := config.GetProjectsCollection().UpdateOne(ctx,
+		bson.M{"_id": projectID, "api_keys": bson.M{"$elemMatch": bson.M{"id": keyID, "revoked_at": bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{"api_keys.$.revoked_at": time.Now(), "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		n, err := config.GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": projectID, "api_keys.id": keyID})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrAPIKeyNotFound
+		}
+	}
+	return nil
+}
+
+// RotateSigningKeys issues a new signing key pair and turns signing on. The
+// old secret stops working immediately.
+func RotateSigningKeys(ctx context.Context, projectID primitive.ObjectID) (token, secret string, err error) {
+	token, secret = NewSigningKeyPair()
+	result, err := config.GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": projectID}, bson.M{
+		"$set": bson.M{
+			"signing_required":     true,
+			"signing_public_token": token,
+			"signing_secret":       secret,
+			"updated_at":           time.Now(),
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if result.MatchedCount == 0 {
+		return "", "", ErrProjectNotFound
+	}
+	return token, secret, nil
+}
+
+// RotateModerationSecret replaces the secret used to sign moderation webhook
+// calls
+func RotateModerationSecret(ctx context.Context, projectID primitive.ObjectID) (string, error) {
+	secret := NewModerationSecret()
+	result, err := config.GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": projectID}, bson.M{
+		"$set": bson.M{"moderation_secret": secret, "updated_at": time.Now()},
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.MatchedCount == 0 {
+		return "", ErrProjectNotFound
+	}
+	return secret, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+)
+
+// APIKeyUsage counts the requests made with an API key. Errors are the
+// requests that ended in a 4xx or 5xx, rate-limited ones included.
+type APIKeyUsage struct {
+	Requests    int64 `bson:"requests" json:"requests"`
+	Errors      int64 `bson:"errors" json:"errors"`
+	RateLimited int64 `bson:"rate_limited" json:"rate_limited"`
+}
+
+// ErrorRate is the share of requests that failed
+func (u APIKeyUsage) ErrorRate() float64 {
+	if u.Requests == 0 {
+		return 0
+	}
+	return float64(u.Errors) / float64(u.Requests)
+}
+
+// Add returns the sum of both counts
+func (u APIKeyUsage) Add(o APIKeyUsage) APIKeyUsage {
+	return APIKeyUsage{Requests: u.Requests + o.Requests, Errors: u.Errors + o.Errors, RateLimited: u.RateLimited + o.RateLimited}
+}
+
+// APIKeyUsageBucket is a key's usage over one hour or day
+type APIKeyUsageBucket struct {
+	Start time.Time `json:"start"`
+	APIKeyUsage
+}
+
+// AddAPIKeyUsage adds counts to a key's usage in the hour starting at hour
+func AddAPIKeyUsage(ctx context.Context, projectID primitive.ObjectID, keyID string, hour time.Time, usage APIKeyUsage) error {
+	_, err := config.GetAPIKeyUsageCollection().UpdateOne(ctx,
+		bson.M{"project_id": projectID, "key_id": keyID, "hour": hour},
+		bson.M{"$inc": bson.M{"requests": usage.Requests, "errors": usage.Errors, "rate_limited": usage.RateLimited}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// APIKeyUsageTotals sums each of the project's keys' usage since the given
+// time, by key ID
+func APIKeyUsageTotals(ctx context.Context, projectID primitive.ObjectID, since time.Time) (map[string]APIKeyUsage, error) {
+	cursor, err := config.GetAPIKeyUsageCollection().Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"project_id": projectID, "hour": bson.M{"$gte": since}}},
+		bson.M{"$group": bson.M{
+			"_id":          "$key_id",
+			"requests":     bson.M{"$sum": "$requests"},
+			"errors":       bson.M{"$sum": "$errors"},
+			"rate_limited": bson.M{"$sum": "$rate_limited"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		KeyID       string `bson:"_id"`
+		APIKeyUsage `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	totals := make(map[string]APIKeyUsage, len(rows))
+	for _, r := range rows {
+		totals[r.KeyID] = r.APIKeyUsage
+	}
+	return totals, nil
+}
+
+// APIKeyUsageSeries returns a key's usage since the given time in buckets
+// of bucket (an hour or a day, in UTC), oldest first. Buckets without
+// requests are left out.
+func APIKeyUsageSeries(ctx context.Context, projectID primitive.ObjectID, keyID string, since time.Time, bucket time.Duration) ([]APIKeyUsageBucket, error) {
+	cursor, err := config.GetAPIKeyUsageCollection().Find(ctx,
+		bson.M{"project_id": projectID, "key_id": keyID, "hour": bson.M{"$gte": since}},
+		options.Find().SetSort(bson.D{{Key: "hour", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var hours []struct {
+		Hour        time.Time `bson:"hour"`
+		APIKeyUsage `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &hours); err != nil {
+		return nil, err
+	}
+
+	series := []APIKeyUsageBucket{}
+	for _, h := range hours {
+		start := h.Hour.UTC().Truncate(bucket)
+		if n := len(series); n > 0 && series[n-1].Start.Equal(start) {
+			series[n-1].APIKeyUsage = series[n-1].Add(h.APIKeyUsage)
+			continue
+		}
+		series = append(series, APIKeyUsageBucket{Start: start, APIKeyUsage: h.APIKeyUsage})
+	}
+	return series, nil
+}
+
+// APIKeyHourUsage is one key's usage in an hour, next to its usage over the
+// hours before
+type APIKeyHourUsage struct {
+	ProjectID primitive.ObjectID
+	KeyID     string
+	Hour      APIKeyUsage
+	Baseline  APIKeyUsage
+}
+
+// APIKeyUsageAgainstBaseline returns the usage of every key used in the
+// hour starting at hour, each with its total over the baseline window
+// before it
+func APIKeyUsageAgainstBaseline(ctx context.Context, hour time.Time, baseline time.Duration) ([]APIKeyHourUsage, error) {
+	collection := config.GetAPIKeyUsageCollection()
+	cursor, err := collection.Find(ctx, bson.M{"hour": hour})
+	if err != nil {
+		return nil, err
+	}
+	var current []struct {
+		ProjectID   primitive.ObjectID `bson:"project_id"`
+		KeyID       string             `bson:"key_id"`
+		APIKeyUsage `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &current); err != nil {
+		return nil, err
+	}
+	if len(current) == 0 {
+		return nil, nil
+	}
+
+	cursor, err = collection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"hour": bson.M{"$gte": hour.Add(-baseline), "$lt": hour}}},
+		bson.M{"$group": bson.M{
+			"_id":          bson.M{"project_id": "$project_id", "key_id": "$key_id"},
+			"requests":     bson.M{"$sum": "$requests"},
+			"errors":       bson.M{"$sum": "$errors"},
+			"rate_limited": bson.M{"$sum": "$rate_limited"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var before []struct {
+		ID struct {
+			ProjectID primitive.ObjectID `bson:"project_id"`
+			KeyID     string             `bson:"key_id"`
+		} `bson:"_id"`
+		APIKeyUsage `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &before); err != nil {
+		return nil, err
+	}
+	type keyRef struct {
+		projectID primitive.ObjectID
+		keyID     string
+	}
+	baselines := make(map[keyRef]APIKeyUsage, len(before))
+	for _, b := range before {
+		baselines[keyRef{b.ID.ProjectID, b.ID.KeyID}] = b.APIKeyUsage
+	}
+
+	usage := make([]APIKeyHourUsage, 0, len(current))
+	for _, c := range current {
+		usage = append(usage, APIKeyHourUsage{
+			ProjectID: c.ProjectID,
+			KeyID:     c.KeyID,
+			Hour:      c.APIKeyUsage,
+			Baseline:  baselines[keyRef{c.ProjectID, c.KeyID}],
+		})
+	}
+	return usage, nil
+}
+
+// MarkAPIKeyAnomalyAlerted records when owners were last alerted about a
+// key's usage, so one incident raises one alert
+func MarkAPIKeyAnomalyAlerted(ctx context.Context, projectID primitive.ObjectID, keyID string, at time.Time) error {
+	_, err := config.GetProjectsCollection().UpdateOne(ctx,
+		bson.M{"_id": projectID, "api_keys.id": keyID},
+		bson.M{"$set": bson.M{"api_keys.$.anomaly_alerted_at": at}},
+	)
+	return err
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrLibraryDocumentNotFound = errors.New("library document not found")
+
+// LibraryOwner is the owner of the library a project draws on: its tenant,
+// or the platform library ("") for projects without an owner
+func LibraryOwner(project models.Project) string {
+	if project.UserID.IsZero() {
+		return ""
+	}
+	return project.UserID.Hex()
+}
+
+// LibraryOwnerFilter matches the library documents of one owner. The
+// platform library includes documents uploaded before owners were recorded.
+func LibraryOwnerFilter(ownerID string) bson.M {
+	if ownerID == "" {
+		return bson.M{"owner_id": bson.M{"$in": bson.A{nil, ""}}}
+	}
+	return bson.M{"owner_id": ownerID}
+}
+
+// AttachedLibraryDocuments returns the processed library documents a
+// project has attached and enabled
+func AttachedLibraryDocuments(ctx context.Context, project models.Project) ([]models.LibraryDocument, error) {
+	var ids bson.A
+	for _, a := range project.LibraryDocs {
+		if a.Enabled {
+			ids = append(ids, a.DocumentID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := config.GetLibraryDocumentsCollection().Find(ctx, bson.M{
+		"_id":    bson.M{"$in": ids},
+		"status": "completed",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var docs []models.LibraryDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// LibraryAttachmentCounts returns how many projects each library document
+// is attached to
+func LibraryAttachmentCounts(ctx context.Context) (map[primitive.ObjectID]int, error) {
+	cursor, err := config.GetProjectsCollection().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"library_docs.0": bson.M{"$exists": true}}},
+		{"$unwind": "$library_docs"},
+		{"$group": bson.M{"_id": "$library_docs.document_id", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Count int                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := make(map[primitive.ObjectID]int, len(rows))
+	for _, r := range rows {
+		counts[r.ID] = r.Count
+	}
+	return counts, nil
+}
+
+// SetLibraryAttachment attaches a library document to a project, or updates
+// its enabled flag if it is already attached
+func SetLibraryAttachment(ctx context.Context, projectID, documentID primitive.ObjectID, enabled bool) error {
+	projects := config.GetProjectsCollection()
+	now := time.Now()
+
+	result, err := projects.UpdateOne(ctx,
+		bson.M{"_id": projectID, "library_docs.document_id": documentID},
+		bson.M{"$set": bson.M{"library_docs.$.enabled": enabled, "updated_at": now}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Not attached yet; the filter keeps a concurrent attach from adding it twice
+	result, err = projects.UpdateOne(ctx,
+		bson.M{"_id": projectID, "library_docs.document_id": bson.M{"$ne": documentID}},
+		bson.M{
+			"$push": bson.M{"library_docs": models.LibraryAttachment{DocumentID: documentID, Enabled: enabled, AttachedAt: now}},
+			"$set":  bson.M{"updated_at": now},
+		})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		// Either the project is gone or the concurrent attach won
+		n, err := projects.CountDocuments(ctx, bson.M{"_id": projectID})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrProjectNotFound
+		}
+	}
+	return nil
+}
+
+// DeleteLibraryDocument detaches a library document from every project and
+// deletes it. It returns the number of projects it was detached from.
+func DeleteLibraryDocument(ctx context.Context, documentID primitive.ObjectID) (int64, error) {
+	var detached int64
+	err := WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := config.GetProjectsCollection().UpdateMany(ctx,
+			bson.M{"library_docs.document_id": documentID},
+			bson.M{
+				"$pull": bson.M{"library_docs": bson.M{"document_id": documentID}},
+				"$set":  bson.M{"updated_at": time.Now()},
+			})
+		if err != nil {
+			return err
+		}
+		detached = result.ModifiedCount
+
+		if _, err := config.GetChunkEmbeddingsCollection().DeleteMany(ctx, bson.M{"library_document_id": documentID}); err != nil {
+			return err
+		}
+
+		deleted, err := config.GetLibraryDocumentsCollection().DeleteOne(ctx, bson.M{"_id": documentID})
+		if err != nil {
+			return err
+		}
+		if deleted.DeletedCount == 0 {
+			return ErrLibraryDocumentNotFound
+		}
+		return nil
+	})
+	return detached, err
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// ErrMessageGone is returned for a message that no longer exists or was
+// already tombstoned
+var ErrMessageGone = errors.New("message not found or already removed")
+
+// TombstoneMessage removes the text of a chat message, leaving a tombstone,
+// and keeps its original in message_redactions. The message must still be
+// as loaded, so two removals of it do not both succeed.
+func TombstoneMessage(ctx context.Context, msg models.ChatMessage, tombstone models.MessageTombstone, actorID string) error {
+	redaction := models.MessageRedaction{
+		ProjectID:        msg.ProjectID,
+		MessageID:        msg.ID,
+		SessionID:        msg.SessionID,
+		UserID:           msg.UserID,
+		Message:          msg.Message,
+		Response:         msg.Response,
+		Citations:        msg.Citations,
+		MessageTimestamp: msg.Timestamp,
+		By:               tombstone.By,
+		ActorID:          actorID,
+		Reason:           tombstone.Reason,
+		CreatedAt:        tombstone.At,
+	}
+	if redaction.CreatedAt.IsZero() {
+		redaction.CreatedAt = time.Now()
+	}
+
+	return WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := config.GetChatMessagesCollectionFor(msg.ProjectID).UpdateOne(ctx,
+			bson.M{"_id": msg.ID, "project_id": msg.ProjectID, "tombstone": bson.M{"$exists": false}},
+			bson.M{
+				"$set":   bson.M{"message": "", "response": "", "tombstone": tombstone},
+				"$unset": bson.M{"citations": ""},
+			})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrMessageGone
+		}
+		_, err = config.GetMessageRedactionsCollection().InsertOne(ctx, redaction)
+		return err
+	})
+}
+
+// MessageRedactions returns the stored originals of tombstoned messages
+// matching filter, newest first
+func MessageRedactions(ctx context.Context, filter bson.M, limit int64) ([]models.MessageRedaction, error) {
+	cursor, err := config.GetMessageRedactionsCollection().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	var rows []models.MessageRedaction
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,565 @@
+package repository
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// MigrationFormat is the version of the migration archive layout. The
+// archive is a gzipped tar of project.json, files/<file id> for each
+// uploaded document, collections/<name>.jsonl with one document per line in
+// canonical extended JSON, and manifest.json last, which lists every other
+// entry with its size and SHA-256.
+const MigrationFormat = 1
+
+const migrationBatchSize = 1000
+
+var (
+	ErrProjectExists    = errors.New("a project with this ID already exists")
+	ErrMigrationInvalid = errors.New("invalid migration archive")
+)
+
+// MigrationOptions says what a migration carries besides the project's
+// configuration and documents
+type MigrationOptions struct {
+	ChatUsers   bool `json:"chat_users"`
+	Transcripts bool `json:"transcripts"`
+}
+
+// MigrationEntry is one file of a migration archive
+type MigrationEntry struct {
+	Name      string `json:"name"`
+	Documents int64  `json:"documents,omitempty"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+// MigrationManifest describes a migration archive
+type MigrationManifest struct {
+	Format      int                `json:"format"`
+	ProjectID   primitive.ObjectID `json:"project_id"`
+	ProjectName string             `json:"project_name"`
+	ExportedAt  time.Time          `json:"exported_at"`
+	Options     MigrationOptions   `json:"options"`
+	Entries     []MigrationEntry   `json:"entries"`
+	Warnings    []string           `json:"warnings,omitempty"`
+}
+
+// MigrationHooks are what a migration needs from outside the database
+type MigrationHooks struct {
+	// PrepareProject readies the project document for the other side of
+	// the move, such as its encrypted credentials, and returns warnings
+	// for whatever it had to leave out
+	PrepareProject func(p *models.Project) []string
+	// OpenFile reads an uploaded document, on export
+	OpenFile func(ctx context.Context, f models.SourceFile) (io.ReadCloser, error)
+	// StoreFile saves an uploaded document, on import, and records where
+	StoreFile func(ctx context.Context, projectID primitive.ObjectID, f *models.SourceFile, r io.Reader, size int64) error
+}
+
+// migrationCollections are the project's collections a migration carries,
+// with the option that includes them ("" for always)
+var migrationCollections = []struct {
+	name   string
+	option string
+}{
+	{"document_pages", ""},
+	{"document_chunks", ""},
+	{"chunk_embeddings", ""},
+	{"products", ""},
+	{"instruction_revisions", ""},
+	{"project_blocks", ""},
+	{"webhooks", ""},
+	{"chat_users", "chat_users"},
+	{"chat_sessions", "transcripts"},
+	{"chat_messages", "transcripts"},
+	{"leads", "transcripts"},
+	{"gemini_usage_daily", "transcripts"},
+}
+
+func (o MigrationOptions) includes(option string) bool {
+	switch option {
+	case "chat_users":
+		return o.ChatUsers
+	case "transcripts":
+		return o.Transcripts
+	}
+	return true
+}
+
+// ExportProjectMigration writes a project's migration archive to w. The
+// archive is only complete once this returns without error; callers that
+// stream it must make sure a failed export cannot be mistaken for one,
+// which a sealed stream does by never writing its last chunk.
+func ExportProjectMigration(ctx context.Context, w io.Writer, projectID primitive.ObjectID, opts MigrationOptions, hooks MigrationHooks) (*MigrationManifest, error) {
+	var project models.Project
+	if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": projectID}).Decode(&project); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+
+	manifest := &MigrationManifest{
+		Format:      MigrationFormat,
+		ProjectID:   projectID,
+		ProjectName: project.Name,
+		ExportedAt:  time.Now(),
+		Options:     opts,
+	}
+	// Dedicated tenant databases are particular to this deployment
+	project.Database = ""
+	if hooks.PrepareProject != nil {
+		manifest.Warnings = append(manifest.Warnings, hooks.PrepareProject(&project)...)
+	}
+	if opts.Transcripts {
+		n, err := config.GetChatArchivesCollection().CountDocuments(ctx, bson.M{"project_id": projectID})
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf(
+				"%d archived conversation batches are in object storage and not included; restore them before exporting to carry them over", n))
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, fill func(w io.Writer) (int64, error)) error {
+		entry, err := writeMigrationEntry(tw, name, fill)
+		if err != nil {
+			return err
+		}
+		manifest.Entries = append(manifest.Entries, *entry)
+		return nil
+	}
+
+	if err := add("project.json", func(w io.Writer) (int64, error) {
+		return 1, writeExtJSONLine(w, project)
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, f := range project.PDFFiles {
+		rc, err := hooks.OpenFile(ctx, f)
+		if err != nil {
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("%s could not be read and is not included: %v", f.FileName, err))
+			continue
+		}
+		err = add("files/"+f.ID, func(w io.Writer) (int64, error) {
+			_, err := io.Copy(w, rc)
+			return 0, err
+		})
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %v", f.FileName, err)
+		}
+	}
+
+	for _, col := range migrationCollections {
+		if !opts.includes(col.option) {
+			continue
+		}
+		collection := config.TenantCollection(projectID, col.name)
+		err := add("collections/"+col.name+".jsonl", func(w io.Writer) (int64, error) {
+			cursor, err := collection.Find(ctx, bson.M{"project_id": models.ProjectIDMatch(projectID)})
+			if err != nil {
+				return 0, err
+			}
+			defer cursor.Close(ctx)
+			var n int64
+			for cursor.Next(ctx) {
+				if err := writeExtJSONLine(w, cursor.Current); err != nil {
+					return n, err
+				}
+				n++
+			}
+			return n, cursor.Err()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %v", col.name, err)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0o600, Size: int64(len(data)), ModTime: manifest.ExportedAt}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeExtJSONLine(w io.Writer, doc interface{}) error {
+	line, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeMigrationEntry spools an entry to a temporary file, since a tar
+// header needs the size up front, and adds it to the archive
+func writeMigrationEntry(tw *tar.Writer, name string, fill func(w io.Writer) (int64, error)) (*MigrationEntry, error) {
+	tmp, err := os.CreateTemp("", "migration-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	sum := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(tmp, sum))
+	docs, err := fill(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := buf.Flush(); err != nil {
+		return nil, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: size, ModTime: time.Now()}); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return nil, err
+	}
+	return &MigrationEntry{Name: name, Documents: docs, Size: size, SHA256: hex.EncodeToString(sum.Sum(nil))}, nil
+}
+
+// VerifyProjectMigration reads a whole archive and checks each entry
+// against the manifest, without writing anything. Archives are verified
+// before they are imported, since the manifest only comes at the end.
+func VerifyProjectMigration(r io.Reader) (*MigrationManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMigrationInvalid, err)
+	}
+	tr := tar.NewReader(gz)
+
+	seen := map[string]MigrationEntry{}
+	var manifest *MigrationManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMigrationInvalid, err)
+		}
+		if manifest != nil {
+			return nil, fmt.Errorf("%w: %s comes after the manifest", ErrMigrationInvalid, hdr.Name)
+		}
+		if hdr.Name == "manifest.json" {
+			manifest = &MigrationManifest{}
+			if err := json.NewDecoder(io.LimitReader(tr, 10<<20)).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("%w: unreadable manifest: %v", ErrMigrationInvalid, err)
+			}
+			continue
+		}
+		if _, dup := seen[hdr.Name]; dup {
+			return nil, fmt.Errorf("%w: %s appears twice", ErrMigrationInvalid, hdr.Name)
+		}
+
+		sum := sha256.New()
+		lines := &lineCounter{}
+		size, err := io.Copy(io.MultiWriter(sum, lines), tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMigrationInvalid, err)
+		}
+		entry := MigrationEntry{Name: hdr.Name, Size: size, SHA256: hex.EncodeToString(sum.Sum(nil))}
+		if strings.HasSuffix(hdr.Name, ".jsonl") || hdr.Name == "project.json" {
+			entry.Documents = lines.n
+		}
+		seen[hdr.Name] = entry
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: the manifest is missing", ErrMigrationInvalid)
+	}
+	if manifest.Format != MigrationFormat {
+		return nil, fmt.Errorf("%w: unsupported format %d", ErrMigrationInvalid, manifest.Format)
+	}
+	if manifest.ProjectID.IsZero() {
+		return nil, fmt.Errorf("%w: the manifest names no project", ErrMigrationInvalid)
+	}
+	if len(manifest.Entries) != len(seen) {
+		return nil, fmt.Errorf("%w: the manifest lists %d entries, the archive has %d", ErrMigrationInvalid, len(manifest.Entries), len(seen))
+	}
+	for _, want := range manifest.Entries {
+		got, ok := seen[want.Name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w: %s is missing", ErrMigrationInvalid, want.Name)
+		case got.Size != want.Size || got.SHA256 != want.SHA256:
+			return nil, fmt.Errorf("%w: %s does not match its checksum", ErrMigrationInvalid, want.Name)
+		case got.Documents != want.Documents:
+			return nil, fmt.Errorf("%w: %s has %d documents, expected %d", ErrMigrationInvalid, want.Name, got.Documents, want.Documents)
+		}
+	}
+	if _, ok := seen["project.json"]; !ok {
+		return nil, fmt.Errorf("%w: project.json is missing", ErrMigrationInvalid)
+	}
+	return manifest, nil
+}
+
+// lineCounter counts the newlines written to it
+type lineCounter struct{ n int64 }
+
+func (l *lineCounter) Write(p []byte) (int, error) {
+	l.n += int64(bytes.Count(p, []byte{'\n'}))
+	return len(p), nil
+}
+
+// MigrationImport summarizes what importing an archive wrote
+type MigrationImport struct {
+	ProjectID   primitive.ObjectID `json:"project_id"`
+	ProjectName string             `json:"project_name"`
+	Documents   map[string]int64   `json:"documents"`
+	Files       int                `json:"files"`
+	Warnings    []string           `json:"warnings,omitempty"`
+}
+
+// ImportProjectMigration writes the project of an archive that
+// VerifyProjectMigration accepted, keeping its ID so embed snippets keep
+// working after the move. The project document is written last: until
+// then the project does not exist here, and an interrupted import can be
+// run again, skipping the documents already written.
+func ImportProjectMigration(ctx context.Context, r io.Reader, manifest *MigrationManifest, hooks MigrationHooks) (*MigrationImport, error) {
+	projectID := manifest.ProjectID
+	if n, err := config.GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": projectID}); err != nil {
+		return nil, err
+	} else if n > 0 {
+		return nil, ErrProjectExists
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+
+	result := &MigrationImport{
+		ProjectID:   projectID,
+		ProjectName: manifest.ProjectName,
+		Documents:   map[string]int64{},
+		Warnings:    append([]string{}, manifest.Warnings...),
+	}
+	var project *models.Project
+	stored := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case hdr.Name == "project.json":
+			project = &models.Project{}
+			line, err := io.ReadAll(io.LimitReader(tr, 64<<20))
+			if err != nil {
+				return nil, err
+			}
+			if err := bson.UnmarshalExtJSON(bytes.TrimSpace(line), true, project); err != nil {
+				return nil, fmt.Errorf("%w: unreadable project.json: %v", ErrMigrationInvalid, err)
+			}
+			if project.ID != projectID {
+				return nil, fmt.Errorf("%w: project.json is for another project", ErrMigrationInvalid)
+			}
+
+		case strings.HasPrefix(hdr.Name, "files/"):
+			if project == nil {
+				return nil, fmt.Errorf("%w: %s comes before project.json", ErrMigrationInvalid, hdr.Name)
+			}
+			id := path.Base(hdr.Name)
+			var file *models.SourceFile
+			for i := range project.PDFFiles {
+				if project.PDFFiles[i].ID == id {
+					file = &project.PDFFiles[i]
+				}
+			}
+			if file == nil {
+				continue
+			}
+			if err := hooks.StoreFile(ctx, projectID, file, tr, hdr.Size); err != nil {
+				return nil, fmt.Errorf("failed to store %s: %v", file.FileName, err)
+			}
+			stored[id] = true
+			result.Files++
+
+		case strings.HasPrefix(hdr.Name, "collections/"):
+			name := strings.TrimSuffix(path.Base(hdr.Name), ".jsonl")
+			if !isMigrationCollection(name) {
+				continue
+			}
+			n, skipped, err := importMigrationCollection(ctx, projectID, name, tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to import %s: %v", name, err)
+			}
+			result.Documents[name] = n
+			if skipped > 0 {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%d %s documents were already here and were not replaced", skipped, name))
+			}
+		}
+	}
+	if project == nil {
+		return nil, fmt.Errorf("%w: project.json is missing", ErrMigrationInvalid)
+	}
+
+	for i := range project.PDFFiles {
+		f := &project.PDFFiles[i]
+		if !stored[f.ID] {
+			f.FilePath, f.StorageKey = "", ""
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s was not in the archive; upload it again to keep its original", f.FileName))
+		}
+	}
+	if len(project.LibraryDocs) > 0 {
+		kept := project.LibraryDocs[:0]
+		for _, doc := range project.LibraryDocs {
+			n, err := config.GetLibraryDocumentsCollection().CountDocuments(ctx, bson.M{"_id": doc.DocumentID})
+			if err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				kept = append(kept, doc)
+			}
+		}
+		if dropped := len(project.LibraryDocs) - len(kept); dropped > 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%d shared library documents do not exist here and were detached", dropped))
+		}
+		project.LibraryDocs = kept
+	}
+	project.Database = ""
+	if hooks.PrepareProject != nil {
+		result.Warnings = append(result.Warnings, hooks.PrepareProject(project)...)
+	}
+	project.UpdatedAt = time.Now()
+
+	if _, err := config.GetProjectsCollection().InsertOne(ctx, project); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrProjectExists
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+func isMigrationCollection(name string) bool {
+	for _, col := range migrationCollections {
+		if col.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// importMigrationCollection inserts one collection's documents, in
+// batches. Every document must belong to the project being imported.
+// Documents whose _id is already taken are skipped and counted.
+func importMigrationCollection(ctx context.Context, projectID primitive.ObjectID, name string, r io.Reader) (inserted, skipped int64, err error) {
+	collection := config.TenantCollection(projectID, name)
+	batch := make([]interface{}, 0, migrationBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n := int64(len(batch))
+		_, err := collection.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+		if err != nil {
+			bwe, ok := err.(mongo.BulkWriteException)
+			if !ok || !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+			n -= int64(len(bwe.WriteErrors))
+			skipped += int64(len(bwe.WriteErrors))
+		}
+		inserted += n
+		batch = batch[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1<<20), 32<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var doc bson.Raw
+		if err := bson.UnmarshalExtJSON(line, true, &doc); err != nil {
+			return inserted, skipped, fmt.Errorf("%w: %v", ErrMigrationInvalid, err)
+		}
+		if !ownedByProject(doc, projectID) {
+			return inserted, skipped, fmt.Errorf("%w: a document belongs to another project", ErrMigrationInvalid)
+		}
+		batch = append(batch, doc)
+		if len(batch) == migrationBatchSize {
+			if err := flush(); err != nil {
+				return inserted, skipped, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return inserted, skipped, err
+	}
+	return inserted, skipped, flush()
+}
+
+// ownedByProject reports whether a document's project_id is the project's,
+// stored as an ObjectID or a legacy hex string
+func ownedByProject(doc bson.Raw, projectID primitive.ObjectID) bool {
+	v, err := doc.LookupErr("project_id")
+	if err != nil {
+		return false
+	}
+	if id, ok := v.ObjectIDOK(); ok {
+		return id == projectID
+	}
+	if s, ok := v.StringValueOK(); ok {
+		return s == projectID.Hex()
+	}
+	return false
+}
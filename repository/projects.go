@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrProjectNotFound = errors.New("project not found")
+
+// ProjectDeletion summarizes what a cascading project delete removed
+type ProjectDeletion struct {
+	Messages       int64 `json:"messages"`
+	Sessions       int64 `json:"sessions"`
+	ChatUsers      int64 `json:"chat_users"`
+	UsageLogs      int64 `json:"usage_logs"`
+	UsageDaily     int64 `json:"usage_daily"`
+	Notifications  int64 `json:"notifications"`
+	Archives       int64 `json:"archives"`
+	Blocks         int64 `json:"blocks"`
+	Leads          int64 `json:"leads"`
+	Products       int64 `json:"products"`
+	DocumentPages  int64 `json:"document_pages"`
+	DocumentChunks int64 `json:"document_chunks"`
+	Embeddings     int64 `json:"chunk_embeddings"`
+	UploadBatches  int64 `json:"upload_batches"`
+	Redactions     int64 `json:"message_redactions"`
+	Revisions      int64 `json:"instruction_revisions"`
+	UserTokens     int64 `json:"chat_user_tokens"`
+	Webhooks       int64 `json:"webhooks"`
+	Deliveries     int64 `json:"webhook_deliveries"`
+	Imports        int64 `json:"transcript_imports"`
+	KeyUsage       int64 `json:"api_key_usage"`
+	Events         int64 `json:"chat_events"`
+	WelcomeEvents  int64 `json:"welcome_events"`
+	ShareLinks     int64 `json:"analytics_share_links"`
+	Nonces         int64 `json:"request_nonces"`
+	Files          int   `json:"files"`
+}
+
+// projectRetainedCollections are project-scoped collections a project
+// delete deliberately leaves in place, with the reason
+var projectRetainedCollections = map[string]string{
+	"audit_logs":      "the audit trail must outlive what it records",
+	"overage_charges": "billing records are kept for invoicing",
+}
+
+type projectDeletionStep struct {
+	collection string
+	filter     bson.M
+	count      *int64
+}
+
+// projectDeletionSteps lists every collection a project delete clears,
+// counting into result. Collections added with a project_id must be added
+// here or to projectRetainedCollections.
+func projectDeletionSteps(projectID primitive.ObjectID, result *ProjectDeletion) []projectDeletionStep {
+	return []projectDeletionStep{
+		{"chat_messages", bson.M{"project_id": projectID}, &result.Messages},
+		{"chat_sessions", bson.M{"project_id": projectID}, &result.Sessions},
+		{"chat_users", bson.M{"project_id": models.ProjectIDMatch(projectID)}, &result.ChatUsers},
+		{"gemini_usage_logs", bson.M{"project_id": projectID}, &result.UsageLogs},
+		{"gemini_usage_daily", bson.M{"project_id": projectID}, &result.UsageDaily},
+		{"notifications", bson.M{"project_id": projectID}, &result.Notifications},
+		{"chat_archives", bson.M{"project_id": projectID}, &result.Archives},
+		{"project_blocks", bson.M{"project_id": projectID}, &result.Blocks},
+		{"leads", bson.M{"project_id": projectID}, &result.Leads},
+		{"products", bson.M{"project_id": projectID}, &result.Products},
+		{"document_pages", bson.M{"project_id": projectID}, &result.DocumentPages},
+		{"document_chunks", bson.M{"project_id": projectID}, &result.DocumentChunks},
+		{"chunk_embeddings", bson.M{"project_id": projectID}, &result.Embeddings},
+		{"upload_batches", bson.M{"project_id": projectID}, &result.UploadBatches},
+		{"message_redactions", bson.M{"project_id": projectID}, &result.Redactions},
+		{"instruction_revisions", bson.M{"project_id": projectID}, &result.Revisions},
+		{"chat_user_tokens", bson.M{"project_id": projectID}, &result.UserTokens},
+		{"webhooks", bson.M{"project_id": projectID}, &result.Webhooks},
+		{"webhook_deliveries", bson.M{"project_id": projectID}, &result.Deliveries},
+		{"transcript_imports", bson.M{"project_id": projectID}, &result.Imports},
+		{"api_key_usage", bson.M{"project_id": projectID}, &result.KeyUsage},
+		{"chat_events", bson.M{"project_id": projectID}, &result.Events},
+		{"welcome_events", bson.M{"project_id": projectID}, &result.WelcomeEvents},
+		{"analytics_share_links", bson.M{"project_id": projectID}, &result.ShareLinks},
+		{"request_nonces", bson.M{"project_id": projectID}, &result.Nonces},
+	}
+}
+
+// DeleteProject removes a project together with everything that belongs to
+// it. Database writes happen in one transaction; PDF files and archive
+// objects are removed only after the commit, since storage cannot roll back.
+// Without transactions the project document is deleted last, so a failed
+// run leaves the project in place and can simply be retried.
+func DeleteProject(ctx context.Context, projectID primitive.ObjectID) (*ProjectDeletion, error) {
+	var project models.Project
+	var archiveKeys []string
+	result := &ProjectDeletion{}
+
+	err := WithTransaction(ctx, func(ctx context.Context) error {
+		*result = ProjectDeletion{}
+		archiveKeys = nil
+
+		if err := config.GetProjectsCollection().FindOne(ctx, bson.M{"_id": projectID}).Decode(&project); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrProjectNotFound
+			}
+			return err
+		}
+
+		cursor, err := config.GetChatArchivesCollection().Find(ctx, bson.M{"project_id": projectID})
+		if err != nil {
+			return fmt.Errorf("failed to list archives: %v", err)
+		}
+		var archives []models.ChatArchive
+		if err := cursor.All(ctx, &archives); err != nil {
+			return fmt.Errorf("failed to list archives: %v", err)
+		}
+		for _, a := range archives {
+			archiveKeys = append(archiveKeys, a.StorageKey)
+		}
+
+		for _, step := range projectDeletionSteps(projectID, result) {
+			res, err := config.TenantCollection(projectID, step.collection).DeleteMany(ctx, step.filter)
+			if err != nil {
+				return fmt.Errorf("failed to delete %s: %v", step.collection, err)
+			}
+			*step.count = res.DeletedCount
+		}
+
+		if _, err := config.GetProjectsCollection().DeleteOne(ctx, bson.M{"_id": projectID}); err != nil {
+			return fmt.Errorf("failed to delete project: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range project.PDFFiles {
+		switch {
+		case file.StorageKey != "" && config.Storage != nil:
+			if err := config.Storage.Delete(ctx, file.StorageKey); err != nil {
+				log.Printf("⚠️ Failed to remove file object %s: %v", file.StorageKey, err)
+				continue
+			}
+		case file.FilePath != "":
+			if err := os.Remove(file.FilePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("⚠️ Failed to remove PDF %s: %v", file.FilePath, err)
+				continue
+			}
+		default:
+			continue
+		}
+		result.Files++
+	}
+	for _, key := range archiveKeys {
+		if err := config.Storage.Delete(ctx, key); err != nil {
+			log.Printf("⚠️ Failed to remove archive object %s: %v", key, err)
+		}
+	}
+
+	return result, nil
+}
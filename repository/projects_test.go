@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"jevi-chat/config"
+)
+
+func TestDeleteProjectCoversProjectCollections(t *testing.T) {
+	covered := map[string]bool{}
+	for _, step := range projectDeletionSteps(primitive.NewObjectID(), &ProjectDeletion{}) {
+		if covered[step.collection] {
+			t.Errorf("%s is deleted twice", step.collection)
+		}
+		covered[step.collection] = true
+		if _, retained := projectRetainedCollections[step.collection]; retained {
+			t.Errorf("%s is both deleted and retained", step.collection)
+		}
+	}
+
+	for _, name := range config.ProjectScopedCollections() {
+		if _, retained := projectRetainedCollections[name]; !covered[name] && !retained {
+			t.Errorf("project-scoped collection %s is not cleared by DeleteProject; add it to projectDeletionSteps or projectRetainedCollections", name)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrTranscriptImportNotFound = errors.New("transcript import not found")
+
+// transcriptWriteBatch is how many documents one insert carries
+const transcriptWriteBatch = 1000
+
+// ImportTranscripts writes the sessions of an import batch and their
+// messages. Sessions whose ID an earlier batch already imported are
+// skipped with their messages, so uploading the same export twice adds
+// nothing. The batch record is written first and completed last: an
+// interrupted run leaves it "importing", and deleting it removes whatever
+// was written.
+func ImportTranscripts(ctx context.Context, batch *models.TranscriptImport, sessions []models.ChatSession, messages map[string][]models.ChatMessage) error {
+	imports := config.GetTranscriptImportsCollection()
+	batch.Status = models.TranscriptImportRunning
+	res, err := imports.InsertOne(ctx, batch)
+	if err != nil {
+		return err
+	}
+	batch.ID = res.InsertedID.(primitive.ObjectID)
+
+	err = writeTranscripts(ctx, batch, sessions, messages)
+	update := bson.M{
+		"status":           models.TranscriptImportCompleted,
+		"sessions":         batch.Sessions,
+		"messages":         batch.Messages,
+		"skipped_sessions": batch.SkippedSessions,
+		"completed_at":     time.Now(),
+	}
+	if err != nil {
+		update["status"] = models.TranscriptImportFailed
+		update["error"] = err.Error()
+	}
+	batch.Status = update["status"].(string)
+	if _, uerr := imports.UpdateOne(ctx, bson.M{"_id": batch.ID}, bson.M{"$set": update}); uerr != nil && err == nil {
+		err = uerr
+	}
+	return err
+}
+
+func writeTranscripts(ctx context.Context, batch *models.TranscriptImport, sessions []models.ChatSession, messages map[string][]models.ChatMessage) error {
+	sessionsColl := config.GetChatSessionsCollectionFor(batch.ProjectID)
+	messagesColl := config.GetChatMessagesCollectionFor(batch.ProjectID)
+
+	for start := 0; start < len(sessions); start += transcriptWriteBatch {
+		chunk := sessions[start:min(start+transcriptWriteBatch, len(sessions))]
+		ids := make(bson.A, len(chunk))
+		for i, s := range chunk {
+			ids[i] = s.SessionID
+		}
+		existing, err := sessionsColl.Distinct(ctx, "session_id", bson.M{"project_id": batch.ProjectID, "session_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		skip := map[string]bool{}
+		for _, id := range existing {
+			if id, ok := id.(string); ok {
+				skip[id] = true
+			}
+		}
+
+		var newSessions, newMessages []interface{}
+		for _, s := range chunk {
+			if skip[s.SessionID] {
+				batch.SkippedSessions++
+				continue
+			}
+			s.ProjectID, s.ImportBatchID = batch.ProjectID, batch.ID
+			newSessions = append(newSessions, s)
+			for _, m := range messages[s.SessionID] {
+				m.ProjectID, m.ImportBatchID = batch.ProjectID, batch.ID
+				newMessages = append(newMessages, m)
+			}
+		}
+		if len(newSessions) == 0 {
+			continue
+		}
+
+		// Messages go in before their sessions, so a session that exists
+		// was imported whole and a retry can rightly skip it
+		for from := 0; from < len(newMessages); from += transcriptWriteBatch {
+			part := newMessages[from:min(from+transcriptWriteBatch, len(newMessages))]
+			if _, err := messagesColl.InsertMany(ctx, part, options.InsertMany().SetOrdered(false)); err != nil {
+				return err
+			}
+		}
+		if _, err := sessionsColl.InsertMany(ctx, newSessions, options.InsertMany().SetOrdered(false)); err != nil {
+			return err
+		}
+		batch.Sessions += len(newSessions)
+		batch.Messages += len(newMessages)
+	}
+	return nil
+}
+
+// TranscriptImportDeletion summarizes what removing an import batch removed
+type TranscriptImportDeletion struct {
+	Messages int64 `json:"messages"`
+	Sessions int64 `json:"sessions"`
+}
+
+// DeleteTranscriptImport removes an import batch with every session and
+// message it brought in. The batch record goes last, so a failed run can
+// be retried.
+func DeleteTranscriptImport(ctx context.Context, projectID, batchID primitive.ObjectID) (*TranscriptImportDeletion, error) {
+	imports := config.GetTranscriptImportsCollection()
+	filter := bson.M{"_id": batchID, "project_id": projectID}
+	if n, err := imports.CountDocuments(ctx, filter); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrTranscriptImportNotFound
+	}
+
+	result := &TranscriptImportDeletion{}
+	owned := bson.M{"project_id": projectID, "import_batch_id": batchID}
+	res, err := config.GetChatMessagesCollectionFor(projectID).DeleteMany(ctx, owned)
+	if err != nil {
+		return nil, err
+	}
+	result.Messages = res.DeletedCount
+	res, err = config.GetChatSessionsCollectionFor(projectID).DeleteMany(ctx, owned)
+	if err != nil {
+		return nil, err
+	}
+	result.Sessions = res.DeletedCount
+
+	if _, err := imports.DeleteOne(ctx, filter); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
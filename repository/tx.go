@@ -0,0 +1,66 @@
+// Package repository groups multi-document writes that must succeed or fail
+// together. Handlers call into it instead of touching several collections
+// directly, so atomicity lives in one place.
+package repository
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"jevi-chat/config"
+)
+
+var (
+	txOnce      sync.Once
+	txSupported bool
+)
+
+// TransactionsSupported reports whether the connected deployment can run
+// multi-document transactions. Standalone servers cannot; replica sets and
+// sharded clusters can. The result is detected once per process.
+func TransactionsSupported(ctx context.Context) bool {
+	txOnce.Do(func() {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		err := config.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			// Servers older than 4.4.2 only know isMaster
+			err = config.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+		}
+		if err != nil {
+			log.Printf("⚠️ Could not detect transaction support, assuming none: %v", err)
+			return
+		}
+		txSupported = hello.SetName != "" || hello.Msg == "isdbgrid"
+		if !txSupported {
+			log.Println("⚠️ MongoDB is not a replica set - multi-document writes run without transactions")
+		}
+	})
+	return txSupported
+}
+
+// WithTransaction runs fn inside a multi-document transaction when the
+// deployment supports one; transient errors are retried by the driver.
+// On a standalone server fn runs directly, so callers must order their
+// writes so that an interrupted run can be safely repeated.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !TransactionsSupported(ctx) {
+		return fn(ctx)
+	}
+
+	session, err := config.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+// GetUploadBatch loads one of a project's upload batches
+func GetUploadBatch(ctx context.Context, projectID, batchID primitive.ObjectID) (*models.UploadBatch, error) {
+	var batch models.UploadBatch
+	err := config.GetUploadBatchesCollection().FindOne(ctx, bson.M{"_id": batchID, "project_id": projectID}).Decode(&batch)
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ClaimUploadBatch marks the oldest pending batch running and returns it,
+// or nil when there is none. A running batch whose heartbeat is older than
+// staleAfter is taken over, since its worker went away.
+func ClaimUploadBatch(ctx context.Context, staleAfter time.Duration) (*models.UploadBatch, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": "pending"},
+		bson.M{"status": "running", "heartbeat_at": bson.M{"$lt": now.Add(-staleAfter)}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": "running", "heartbeat_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var batch models.UploadBatch
+	err := config.GetUploadBatchesCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&batch)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// SetUploadFileStatus records the status of the batch's i-th file and
+// refreshes the batch heartbeat
+func SetUploadFileStatus(ctx context.Context, batchID primitive.ObjectID, i int, status, errMsg string) error {
+	prefix := "files." + strconv.Itoa(i) + "."
+	_, err := config.GetUploadBatchesCollection().UpdateOne(ctx, bson.M{"_id": batchID}, bson.M{
+		"$set": bson.M{
+			prefix + "status": status,
+			prefix + "error":  errMsg,
+			"heartbeat_at":    time.Now(),
+		},
+	})
+	return err
+}
+
+// MarkUploadFileDuplicate records that the batch's i-th file was skipped
+// as a copy of dup
+func MarkUploadFileDuplicate(ctx context.Context, batchID primitive.ObjectID, i int, dup *models.DocumentDuplicate) error {
+	prefix := "files." + strconv.Itoa(i) + "."
+	_, err := config.GetUploadBatchesCollection().UpdateOne(ctx, bson.M{"_id": batchID}, bson.M{
+		"$set": bson.M{
+			prefix + "status":       models.UploadFileDuplicate,
+			prefix + "duplicate_of": dup,
+			"heartbeat_at":          time.Now(),
+		},
+	})
+	return err
+}
+
+// CompleteUploadBatch marks a batch done once none of its files are queued
+func CompleteUploadBatch(ctx context.Context, batchID primitive.ObjectID) error {
+	_, err := config.GetUploadBatchesCollection().UpdateOne(ctx, bson.M{"_id": batchID}, bson.M{
+		"$set": bson.M{"status": "completed", "completed_at": time.Now()},
+	})
+	return err
+}
+
+// AddProjectFile records a processed document on its project and appends its
+// content to the project's knowledge. Values are literals, so a "$" in a
+// file name or in the content is not read as a field path. A file that is
+// already recorded is left alone, so a retried batch does not add it twice.
+func AddProjectFile(ctx context.Context, projectID primitive.ObjectID, file models.SourceFile, content string) error {
+	now := time.Now()
+	projects := config.GetProjectsCollection()
+	result, err := projects.UpdateOne(ctx, bson.M{"_id": projectID, "pdf_files.id": bson.M{"$ne": file.ID}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"pdf_files": bson.M{"$concatArrays": bson.A{
+				bson.M{"$ifNull": bson.A{"$pdf_files", bson.A{}}},
+				bson.A{bson.M{"$literal": file}},
+			}},
+			"pdf_content": bson.M{"$concat": bson.A{
+				bson.M{"$ifNull": bson.A{"$pdf_content", ""}},
+				bson.M{"$literal": content + "\n\n"},
+			}},
+			"updated_at": now,
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if n, err := projects.CountDocuments(ctx, bson.M{"_id": projectID}); err != nil || n == 0 {
+			return ErrProjectNotFound
+		}
+	}
+	return nil
+}
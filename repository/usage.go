@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrQuotaExceeded = errors.New("monthly Gemini limit reached")
+
+// ConsumeGeminiQuota counts one Gemini request against the project's monthly
+// limit. The limit check and the increment are a single conditional update,
+// so concurrent chats can neither undercount nor push usage past the limit.
+// Once the limit is used up, projects with overage enabled draw on their
+// overage allowance instead; overage is then the period's overage count
+// including this request, and 0 when the request fit within the limit.
+func ConsumeGeminiQuota(ctx context.Context, projectID primitive.ObjectID) (overage int, err error) {
+	now := time.Now()
+	result, err := config.GetProjectsCollection().UpdateOne(ctx,
+		bson.M{
+			"_id":   projectID,
+			"$expr": bson.M{"$lt": bson.A{"$gemini_usage_month", "$gemini_monthly_limit"}},
+		},
+		bson.M{
+			"$inc": bson.M{"gemini_usage_month": 1, "total_questions": 1},
+			"$set": bson.M{"last_used": now, "updated_at": now},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	if result.MatchedCount > 0 {
+		return 0, nil
+	}
+
+	var after models.Project
+	err = config.GetProjectsCollection().FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":             projectID,
+			"overage_enabled": true,
+			"$expr": bson.M{"$lt": bson.A{
+				bson.M{"$ifNull": bson.A{"$gemini_overage_month", 0}},
+				"$overage_cap",
+			}},
+		},
+		bson.M{
+			"$inc": bson.M{"gemini_overage_month": 1, "total_questions": 1},
+			"$set": bson.M{"last_used": now, "updated_at": now},
+		},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"gemini_overage_month": 1}),
+	).Decode(&after)
+	if err == nil {
+		return after.GeminiOverageMonth, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	n, err := config.GetProjectsCollection().CountDocuments(ctx, bson.M{"_id": projectID})
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrProjectNotFound
+	}
+	return 0, ErrQuotaExceeded
+}
+
+// RefundGeminiQuota returns a request consumed by ConsumeGeminiQuota, for
+// when generation fails. Usage never drops below zero, so a refund racing a
+// monthly reset is harmless.
+func RefundGeminiQuota(ctx context.Context, projectID primitive.ObjectID, overage bool) error {
+	counter := "gemini_usage_month"
+	if overage {
+		counter = "gemini_overage_month"
+	}
+	_, err := config.GetProjectsCollection().UpdateOne(ctx,
+		bson.M{"_id": projectID, counter: bson.M{"$gt": 0}},
+		bson.M{
+			"$inc": bson.M{counter: -1, "total_questions": -1},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// ResetMonthlyUsage zeroes the project's monthly Gemini counters
+func ResetMonthlyUsage(ctx context.Context, projectID primitive.ObjectID) error {
+	err := resetUsage(ctx, bson.M{"_id": projectID}, time.Now())
+	if err == mongo.ErrNoDocuments {
+		return ErrProjectNotFound
+	}
+	return err
+}
+
+// ResetDueMonthlyUsage zeroes the monthly counters of every project whose
+// billing period has rolled over since its last reset, returning how many
+// were reset. The update is conditional on the last reset, so replicas
+// racing on the same project reset it only once.
+func ResetDueMonthlyUsage(ctx context.Context, now time.Time) (int, error) {
+	cursor, err := config.GetProjectsCollection().Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"created_at": 1, "billing_cycle_day": 1, "last_monthly_reset": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var projects []models.Project
+	if err := cursor.All(ctx, &projects); err != nil {
+		return 0, err
+	}
+
+	reset := 0
+	for _, p := range projects {
+		start, _ := p.BillingPeriod(now)
+		if !p.LastMonthlyReset.Before(start) || !p.CreatedAt.Before(start) {
+			continue
+		}
+		var lastReset interface{} = p.LastMonthlyReset
+		if p.LastMonthlyReset.IsZero() {
+			// Older projects may not have the field at all
+			lastReset = bson.M{"$in": bson.A{p.LastMonthlyReset, nil}}
+		}
+		err := resetUsage(ctx, bson.M{"_id": p.ID, "last_monthly_reset": lastReset}, now)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return reset, err
+		}
+		reset++
+	}
+	return reset, nil
+}
+
+// resetUsage zeroes the counters of the project matching filter and, if it
+// used any overage, records the charge for the period that just closed
+func resetUsage(ctx context.Context, filter bson.M, now time.Time) error {
+	var before models.Project
+	err := config.GetProjectsCollection().FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{
+			"gemini_usage_month":   0,
+			"gemini_overage_month": 0,
+			"last_monthly_reset":   now,
+			"updated_at":           now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err != nil {
+		return err
+	}
+
+	if before.GeminiOverageMonth > 0 {
+		since := before.LastMonthlyReset
+		if since.Before(before.CreatedAt) {
+			since = before.CreatedAt
+		}
+		charge := models.OverageCharge{
+			ProjectID:   before.ID,
+			PeriodStart: since,
+			PeriodEnd:   now,
+			Requests:    before.GeminiOverageMonth,
+			Rate:        before.OverageRate,
+			Amount:      float64(before.GeminiOverageMonth) * before.OverageRate,
+			CreatedAt:   now,
+		}
+		if _, err := config.GetOverageChargesCollection().InsertOne(ctx, charge); err != nil {
+			// The counters are already reset; log loudly so the charge can
+			// be reconstructed from the usage logs
+			log.Printf("❌ Failed to record overage charge for project %s (%d requests): %v", before.ID.Hex(), charge.Requests, err)
+		}
+	}
+	return nil
+}
+
+// ListOverageCharges returns the recorded overage charges, newest first.
+// A zero projectID lists every project; unbilledOnly skips settled charges.
+func ListOverageCharges(ctx context.Context, projectID primitive.ObjectID, unbilledOnly bool) ([]models.OverageCharge, error) {
+	filter := bson.M{}
+	if !projectID.IsZero() {
+		filter["project_id"] = projectID
+	}
+	if unbilledOnly {
+		filter["billed"] = false
+	}
+	cursor, err := config.GetOverageChargesCollection().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "period_end", Value: -1}}).SetLimit(500))
+	if err != nil {
+		return nil, err
+	}
+	charges := []models.OverageCharge{}
+	if err := cursor.All(ctx, &charges); err != nil {
+		return nil, err
+	}
+	return charges, nil
+}
+
+// QuotaReservation holds one request against a project's monthly quota for
+// the length of a long-running generation, such as a streamed reply. The
+// request is counted when reserved, so parallel streams cannot overshoot the
+// limit; Commit records what was actually used and Release gives it back.
+type QuotaReservation struct {
+	ProjectID primitive.ObjectID
+	StartedAt time.Time
+	Overage   int // see ConsumeGeminiQuota
+	settled   bool
+}
+
+// ReserveGeminiQuota reserves one request, or returns ErrQuotaExceeded
+func ReserveGeminiQuota(ctx context.Context, projectID primitive.ObjectID) (*QuotaReservation, error) {
+	overage, err := ConsumeGeminiQuota(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &QuotaReservation{ProjectID: projectID, StartedAt: time.Now(), Overage: overage}, nil
+}
+
+// Commit settles the reservation with the generation's actual token usage.
+// The request stays counted; entry is written to the usage log.
+func (r *QuotaReservation) Commit(ctx context.Context, entry models.GeminiUsageLog) error {
+	if r.settled {
+		return nil
+	}
+	r.settled = true
+
+	entry.ProjectID = r.ProjectID
+	entry.Overage = r.Overage > 0
+	entry.ResponseTime = time.Since(r.StartedAt).Milliseconds()
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	_, err := config.GetGeminiUsageLogsCollection().InsertOne(ctx, entry)
+	return err
+}
+
+// Release refunds the reserved request after a failed generation. It is a
+// no-op once the reservation is settled, so it is safe to defer.
+func (r *QuotaReservation) Release(ctx context.Context) error {
+	if r.settled {
+		return nil
+	}
+	r.settled = true
+	return RefundGeminiQuota(ctx, r.ProjectID, r.Overage > 0)
+}
+
+var ErrChargeNotFound = errors.New("overage charge not found")
+
+// MarkOverageChargeBilled flags a charge as settled by billing
+func MarkOverageChargeBilled(ctx context.Context, chargeID primitive.ObjectID) error {
+	result, err := config.GetOverageChargesCollection().UpdateOne(ctx,
+		bson.M{"_id": chargeID},
+		bson.M{"$set": bson.M{"billed": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrChargeNotFound
+	}
+	return nil
+}
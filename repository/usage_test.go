@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+)
+
+// useTestDatabase points config.DB at a scratch database on the server in
+// TEST_MONGODB_URI, dropped when the test ends. Tests are skipped without it.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	previous := config.DB
+	config.DB = client.Database("jevi_chat_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		config.DB.Drop(context.Background())
+		config.DB = previous
+		client.Disconnect(context.Background())
+	})
+}
+
+// consumeConcurrently calls ConsumeGeminiQuota n times at once and returns
+// the overage count of every accepted request and how many were refused
+func consumeConcurrently(t *testing.T, projectID primitive.ObjectID, n int) (overages []int, refused int) {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			overage, err := ConsumeGeminiQuota(context.Background(), projectID)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, ErrQuotaExceeded):
+				refused++
+			case err != nil:
+				errs = append(errs, err)
+			default:
+				overages = append(overages, overage)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for _, err := range errs {
+		t.Errorf("ConsumeGeminiQuota: %v", err)
+	}
+	return overages, refused
+}
+
+func insertQuotaProject(t *testing.T, fields bson.M) primitive.ObjectID {
+	t.Helper()
+	id := primitive.NewObjectID()
+	doc := bson.M{"_id": id, "name": "quota test", "gemini_usage_month": 0, "total_questions": 0}
+	for k, v := range fields {
+		doc[k] = v
+	}
+	if _, err := config.GetProjectsCollection().InsertOne(context.Background(), doc); err != nil {
+		t.Fatalf("insert project: %v", err)
+	}
+	return id
+}
+
+func projectCounters(t *testing.T, id primitive.ObjectID) (usage, overage, questions int) {
+	t.Helper()
+	var p struct {
+		Usage     int `bson:"gemini_usage_month"`
+		Overage   int `bson:"gemini_overage_month"`
+		Questions int `bson:"total_questions"`
+	}
+	if err := config.GetProjectsCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&p); err != nil {
+		t.Fatalf("load project: %v", err)
+	}
+	return p.Usage, p.Overage, p.Questions
+}
+
+func TestConsumeGeminiQuotaConcurrentNeverExceedsLimit(t *testing.T) {
+	useTestDatabase(t)
+	const limit, sends = 10, 50
+	id := insertQuotaProject(t, bson.M{"gemini_monthly_limit": limit})
+
+	overages, refused := consumeConcurrently(t, id, sends)
+
+	if len(overages) != limit || refused != sends-limit {
+		t.Fatalf("accepted %d and refused %d of %d sends, want %d and %d", len(overages), refused, sends, limit, sends-limit)
+	}
+	for _, o := range overages {
+		if o != 0 {
+			t.Errorf("request within the limit reported overage %d", o)
+		}
+	}
+	usage, overage, questions := projectCounters(t, id)
+	if usage != limit || overage != 0 || questions != limit {
+		t.Errorf("counters = usage %d, overage %d, questions %d; want %d, 0, %d", usage, overage, questions, limit, limit)
+	}
+}
+
+func TestConsumeGeminiQuotaConcurrentOverageIsExact(t *testing.T) {
+	useTestDatabase(t)
+	const limit, overageCap, sends = 20, 15, 80
+	id := insertQuotaProject(t, bson.M{
+		"gemini_monthly_limit": limit,
+		"overage_enabled":      true,
+		"overage_cap":          overageCap,
+	})
+
+	overages, refused := consumeConcurrently(t, id, sends)
+
+	if len(overages) != limit+overageCap || refused != sends-limit-overageCap {
+		t.Fatalf("accepted %d and refused %d of %d sends, want %d and %d",
+			len(overages), refused, sends, limit+overageCap, sends-limit-overageCap)
+	}
+	// Each overage request sees its own position in the overage count
+	within, seen := 0, map[int]bool{}
+	for _, o := range overages {
+		if o == 0 {
+			within++
+			continue
+		}
+		if o < 1 || o > overageCap || seen[o] {
+			t.Errorf("overage count %d is out of range or repeated", o)
+		}
+		seen[o] = true
+	}
+	if within != limit || len(seen) != overageCap {
+		t.Errorf("%d requests within the limit and %d distinct overage counts, want %d and %d", within, len(seen), limit, overageCap)
+	}
+	usage, overage, questions := projectCounters(t, id)
+	if usage != limit || overage != overageCap || questions != limit+overageCap {
+		t.Errorf("counters = usage %d, overage %d, questions %d; want %d, %d, %d",
+			usage, overage, questions, limit, overageCap, limit+overageCap)
+	}
+}
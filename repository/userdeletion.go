@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrDeletionInProgress = errors.New("user deletion already in progress")
+
+// DefaultDeletionPolicy is the policy for users who have not picked one.
+// It comes from USER_DELETION_POLICY and is retain unless set otherwise,
+// so nothing is destroyed by default.
+func DefaultDeletionPolicy() string {
+	if p := os.Getenv("USER_DELETION_POLICY"); models.ValidDeletionPolicy(p) {
+		return p
+	}
+	return models.DeletionPolicyRetain
+}
+
+// DeletionPolicyFor returns the policy that applies to the user
+func DeletionPolicyFor(user *models.User) string {
+	if models.ValidDeletionPolicy(user.DeletionPolicy) {
+		return user.DeletionPolicy
+	}
+	return DefaultDeletionPolicy()
+}
+
+// SetDeletionPolicy stores the user's policy; an empty policy reverts to
+// the platform default
+func SetDeletionPolicy(ctx context.Context, id primitive.ObjectID, policy string) error {
+	update := bson.M{"$set": bson.M{"deletion_policy": policy, "updated_at": time.Now()}}
+	if policy == "" {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{"deletion_policy": ""},
+		}
+	}
+	res, err := config.GetUsersCollection().UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// StartUserDeletion queues the deletion of a user under policy. The account
+// is deactivated and signed out straight away; its data is dealt with by
+// RunUserDeletion.
+func StartUserDeletion(ctx context.Context, user *models.User, policy, requestedBy string) (*models.UserDeletion, error) {
+	collection := config.GetUserDeletionsCollection()
+
+	count, err := collection.CountDocuments(ctx, bson.M{
+		"user_id": user.ID,
+		"status":  bson.M{"$in": bson.A{"pending", "running"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrDeletionInProgress
+	}
+
+	job := &models.UserDeletion{
+		UserID:      user.ID,
+		Email:       user.Email,
+		Policy:      policy,
+		Status:      "pending",
+		RequestedBy: requestedBy,
+		CreatedAt:   time.Now(),
+	}
+	result, err := collection.InsertOne(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+
+	if _, err := config.GetUsersCollection().UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"is_active": false, "updated_at": time.Now()},
+	}); err != nil {
+		return job, fmt.Errorf("failed to deactivate user: %v", err)
+	}
+	if _, err := config.GetUserSessionsCollection().DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		return job, fmt.Errorf("failed to sign out user: %v", err)
+	}
+	return job, nil
+}
+
+// GetUserDeletion loads a deletion job by ID
+func GetUserDeletion(ctx context.Context, id primitive.ObjectID) (*models.UserDeletion, error) {
+	var job models.UserDeletion
+	if err := config.GetUserDeletionsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimUserDeletion takes the oldest pending deletion, or a running one
+// whose worker stopped sending heartbeats for staleAfter, and marks it as
+// running here. It returns nil when there is nothing to do.
+func ClaimUserDeletion(ctx context.Context, staleAfter time.Duration) (*models.UserDeletion, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": "pending"},
+		bson.M{"status": "running", "heartbeat_at": bson.M{"$lt": now.Add(-staleAfter)}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": "running", "heartbeat_at": now},
+		"$min": bson.M{"started_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.UserDeletion
+	err := config.GetUserDeletionsCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RunUserDeletion applies the job's policy to every project the user owns,
+// then removes the user with their notifications, sessions and avatar.
+// Progress is saved after each project. Each policy leaves a project no
+// longer owned by the user, so a run that was interrupted picks up with
+// the projects that are left.
+func RunUserDeletion(ctx context.Context, job *models.UserDeletion) error {
+	jobs := config.GetUserDeletionsCollection()
+
+	cursor, err := config.GetProjectsCollection().Find(ctx, bson.M{"user_id": job.UserID},
+		options.Find().SetProjection(bson.M{"legal_hold": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to list projects: %v", err)
+	}
+	var projects []struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		LegalHold bool               `bson:"legal_hold"`
+	}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return fmt.Errorf("failed to list projects: %v", err)
+	}
+
+	jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+		"projects_total": job.ProjectsDone + len(projects),
+		"heartbeat_at":   time.Now(),
+	}})
+
+	for _, p := range projects {
+		// Held projects must stay exactly as they are; they only lose
+		// their owner
+		policy := job.Policy
+		if p.LegalHold {
+			policy = models.DeletionPolicyRetain
+		}
+		counts, err := applyDeletionPolicy(ctx, policy, p.ID)
+		if err != nil {
+			return fmt.Errorf("project %s: %v", p.ID.Hex(), err)
+		}
+
+		inc := bson.M{"projects_done": 1}
+		for name, n := range counts {
+			inc["counts."+name] = n
+		}
+		jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{
+			"$inc": inc,
+			"$set": bson.M{"heartbeat_at": time.Now()},
+		})
+	}
+
+	user, err := GetUser(ctx, job.UserID)
+	if err != nil && err != ErrUserNotFound {
+		return err
+	}
+
+	res, err := config.GetNotificationsCollection().DeleteMany(ctx, bson.M{"user_id": job.UserID})
+	if err != nil {
+		return fmt.Errorf("failed to delete notifications: %v", err)
+	}
+	notifications := res.DeletedCount
+	if _, err := config.GetUserSessionsCollection().DeleteMany(ctx, bson.M{"user_id": job.UserID}); err != nil {
+		return fmt.Errorf("failed to delete sessions: %v", err)
+	}
+	if _, err := config.GetUsersCollection().DeleteOne(ctx, bson.M{"_id": job.UserID}); err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+	if user != nil && user.AvatarKey != "" && config.Storage != nil {
+		if err := config.Storage.Delete(ctx, user.AvatarKey); err != nil {
+			log.Printf("⚠️ Failed to remove avatar %s: %v", user.AvatarKey, err)
+		}
+	}
+
+	_, err = jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{
+		"$inc": bson.M{"counts.notifications": notifications},
+		"$set": bson.M{"status": "completed", "completed_at": time.Now()},
+	})
+	return err
+}
+
+// FailUserDeletion records why a deletion stopped. The user stays
+// deactivated; the job can be queued again once the cause is fixed.
+func FailUserDeletion(ctx context.Context, id primitive.ObjectID, cause error) error {
+	_, err := config.GetUserDeletionsCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"status": "failed", "error": cause.Error(), "completed_at": time.Now()},
+	})
+	return err
+}
+
+// applyDeletionPolicy deals with one of the deleted user's projects
+func applyDeletionPolicy(ctx context.Context, policy string, projectID primitive.ObjectID) (map[string]int64, error) {
+	switch policy {
+	case models.DeletionPolicyCascade:
+		deleted, err := DeleteProject(ctx, projectID)
+		if err == ErrProjectNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int64{
+			"projects_deleted": 1,
+			"messages":         deleted.Messages,
+			"sessions":         deleted.Sessions,
+			"chat_users":       deleted.ChatUsers,
+			"leads":            deleted.Leads,
+		}, nil
+
+	case models.DeletionPolicyAnonymize:
+		counts, err := anonymizeProjectChats(ctx, projectID)
+		if err != nil {
+			return nil, err
+		}
+		counts["projects_unassigned"] = 1
+		return counts, unassignProject(ctx, projectID)
+
+	default:
+		return map[string]int64{"projects_unassigned": 1}, unassignProject(ctx, projectID)
+	}
+}
+
+// anonymizeProjectChats strips personal data from a project's chats while
+// keeping the conversations themselves. Chat users keep their documents,
+// and a unique placeholder email, so message counts per user still add up.
+func anonymizeProjectChats(ctx context.Context, projectID primitive.ObjectID) (map[string]int64, error) {
+	counts := map[string]int64{}
+
+	res, err := config.GetChatUsersCollection().UpdateMany(ctx,
+		bson.M{"project_id": models.ProjectIDMatch(projectID)},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"name":      "Deleted user",
+			"email":     bson.M{"$concat": bson.A{"deleted-", bson.M{"$toString": "$_id"}, "@anonymized.invalid"}},
+			"password":  "",
+			"is_active": false,
+		}}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize chat users: %v", err)
+	}
+	counts["chat_users"] = res.ModifiedCount
+
+	scrub := bson.M{
+		"$set":   bson.M{"ip_address": ""},
+		"$unset": bson.M{"user_name": "", "user_email": ""},
+	}
+	res, err = config.GetChatMessagesCollectionFor(projectID).UpdateMany(ctx, bson.M{"project_id": projectID}, scrub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize messages: %v", err)
+	}
+	counts["messages"] = res.ModifiedCount
+
+	res, err = config.GetChatSessionsCollectionFor(projectID).UpdateMany(ctx, bson.M{"project_id": projectID}, scrub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize sessions: %v", err)
+	}
+	counts["sessions"] = res.ModifiedCount
+
+	// Tokens could still sign in as the anonymized users, and leads are
+	// nothing but contact details
+	if _, err := config.GetChatUserTokensCollection().DeleteMany(ctx, bson.M{"project_id": projectID}); err != nil {
+		return nil, fmt.Errorf("failed to delete chat user tokens: %v", err)
+	}
+	res2, err := config.GetLeadsCollection().DeleteMany(ctx, bson.M{"project_id": projectID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete leads: %v", err)
+	}
+	counts["leads"] = res2.DeletedCount
+
+	return counts, nil
+}
+
+// unassignProject detaches a project from its owner
+func unassignProject(ctx context.Context, projectID primitive.ObjectID) error {
+	_, err := config.GetProjectsCollection().UpdateOne(ctx, bson.M{"_id": projectID}, bson.M{
+		"$unset": bson.M{"user_id": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
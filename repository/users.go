@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"jevi-chat/config"
+	"jevi-chat/models"
+)
+
+var ErrEmailTaken = errors.New("email already registered")
+
+// CreateUser inserts a platform user if the email is free. The lookup and
+// insert share a transaction; without one the unique email index is what
+// rejects a concurrent duplicate.
+func CreateUser(ctx context.Context, user *models.User) error {
+	collection := config.GetUsersCollection()
+
+	err := WithTransaction(ctx, func(ctx context.Context) error {
+		count, err := collection.CountDocuments(ctx, bson.M{"email": user.Email})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+
+		result, err := collection.InsertOne(ctx, user)
+		if err != nil {
+			return err
+		}
+		user.ID = result.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// CreateChatUser registers an end user of an embedded chat widget. The
+// email only has to be unique within the project.
+func CreateChatUser(ctx context.Context, user *models.ChatUser) error {
+	collection := config.GetChatUsersCollection()
+
+	err := WithTransaction(ctx, func(ctx context.Context) error {
+		count, err := collection.CountDocuments(ctx, bson.M{
+			"project_id": models.ProjectIDMatch(user.ProjectID),
+			"email":      user.Email,
+		})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+
+		result, err := collection.InsertOne(ctx, user)
+		if err != nil {
+			return err
+		}
+		user.ID = result.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+var ErrUserNotFound = errors.New("user not found")
+
+// GetUser loads a dashboard user by ID
+func GetUser(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	var user models.User
+	err := config.GetUsersCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ProfileUpdate holds the profile fields a user may change; nil fields are
+// left as they are
+type ProfileUpdate struct {
+	Username           *string
+	Timezone           *string
+	Locale             *string
+	EmailNotifications *[]string
+}
+
+// UpdateUserProfile applies a profile update and returns the updated user
+func UpdateUserProfile(ctx context.Context, id primitive.ObjectID, update ProfileUpdate) (*models.User, error) {
+	set := bson.M{"updated_at": time.Now()}
+	if update.Username != nil {
+		set["username"] = *update.Username
+	}
+	if update.Timezone != nil {
+		set["timezone"] = *update.Timezone
+	}
+	if update.Locale != nil {
+		set["locale"] = *update.Locale
+	}
+	if update.EmailNotifications != nil {
+		set["email_notifications"] = *update.EmailNotifications
+	}
+
+	var user models.User
+	err := config.GetUsersCollection().FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserAvatar points the user at a new avatar object (or none, with an
+// empty key) and returns the key it replaced, for the caller to delete
+func SetUserAvatar(ctx context.Context, id primitive.ObjectID, key, contentType string) (string, error) {
+	update := bson.M{"$set": bson.M{"avatar_key": key, "avatar_content_type": contentType, "updated_at": time.Now()}}
+	if key == "" {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{"avatar_key": "", "avatar_content_type": ""},
+		}
+	}
+
+	var previous models.User
+	err := config.GetUsersCollection().FindOneAndUpdate(ctx, bson.M{"_id": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return previous.AvatarKey, nil
+}
+
+// AccountUpdate holds the account fields an administrator may change; nil
+// fields are left as they are. Role is only set by super-admins.
+type AccountUpdate struct {
+	Username *string
+	Email    *string
+	Role     *string
+}
+
+// UpdateUserAccount applies an account update and returns the updated user.
+// A new email must not belong to another user; as in CreateUser, the unique
+// email index backs up the check.
+func UpdateUserAccount(ctx context.Context, id primitive.ObjectID, update AccountUpdate) (*models.User, error) {
+	set := bson.M{"updated_at": time.Now()}
+	if update.Username != nil {
+		set["username"] = *update.Username
+	}
+	if update.Email != nil {
+		set["email"] = *update.Email
+	}
+	if update.Role != nil {
+		set["role"] = *update.Role
+	}
+
+	collection := config.GetUsersCollection()
+	var user models.User
+	err := WithTransaction(ctx, func(ctx context.Context) error {
+		if update.Email != nil {
+			count, err := collection.CountDocuments(ctx, bson.M{"email": *update.Email, "_id": bson.M{"$ne": id}})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrEmailTaken
+			}
+		}
+		return collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrEmailTaken
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
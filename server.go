@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"jevi-chat/handlers"
+)
+
+// Built-in TLS for self-hosted deployments without a fronting proxy. With
+// TLS_MODE unset the server listens on PORT in plain HTTP, as before.
+//
+//   - TLS_MODE: "autocert" (Let's Encrypt) or "files" (TLS_CERT_FILE and
+//     TLS_KEY_FILE)
+//   - TLS_DOMAINS: comma-separated host names autocert may issue for
+//   - TLS_EMAIL: contact address for the ACME account (optional)
+//   - TLS_CACHE_DIR: where issued certificates are kept (default ./certs)
+//   - TLS_PORT / HTTP_PORT: listeners for HTTPS and the HTTP redirect
+//     (default 443 and 80); HTTP_PORT=off disables the redirect listener
+//   - HTTP2_CLEARTEXT: "true" serves h2c on the plain listener, for proxies
+//     that speak HTTP/2 to the backend
+//
+// HTTP/2 is negotiated automatically over TLS.
+type serverConfig struct {
+	mode      string
+	port      string
+	tlsPort   string
+	httpPort  string
+	domains   []string
+	email     string
+	cacheDir  string
+	certFile  string
+	keyFile   string
+	cleartext bool
+}
+
+func loadServerConfig() (serverConfig, error) {
+	cfg := serverConfig{
+		mode:      strings.ToLower(strings.TrimSpace(os.Getenv("TLS_MODE"))),
+		port:      os.Getenv("PORT"),
+		tlsPort:   envOr("TLS_PORT", "443"),
+		httpPort:  envOr("HTTP_PORT", "80"),
+		email:     os.Getenv("TLS_EMAIL"),
+		cacheDir:  envOr("TLS_CACHE_DIR", "./certs"),
+		certFile:  os.Getenv("TLS_CERT_FILE"),
+		keyFile:   os.Getenv("TLS_KEY_FILE"),
+		cleartext: os.Getenv("HTTP2_CLEARTEXT") == "true",
+	}
+	if cfg.port == "" || len(cfg.port) > 5 {
+		cfg.port = "8080"
+	}
+	for _, d := range strings.Split(os.Getenv("TLS_DOMAINS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			cfg.domains = append(cfg.domains, d)
+		}
+	}
+
+	switch cfg.mode {
+	case "", "off":
+		cfg.mode = ""
+	case "autocert":
+		if len(cfg.domains) == 0 {
+			return cfg, fmt.Errorf("TLS_MODE=autocert requires TLS_DOMAINS")
+		}
+	case "files":
+		if cfg.certFile == "" || cfg.keyFile == "" {
+			return cfg, fmt.Errorf("TLS_MODE=files requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+	default:
+		return cfg, fmt.Errorf("unknown TLS_MODE %q (use autocert or files)", cfg.mode)
+	}
+	return cfg, nil
+}
+
+// predeployConfigChecks are the settings the predeploy check validates
+// that only package main can parse
+var predeployConfigChecks = []handlers.ConfigCheck{
+	{Name: "tls", Check: func() error {
+		_, err := loadServerConfig()
+		return err
+	}},
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// serve blocks running the configured listeners
+func serve(cfg serverConfig, handler http.Handler) error {
+	if cfg.mode == "" {
+		log.Printf("🚀 Jevi Chat Server running on port %s (h2c: %v)", cfg.port, cfg.cleartext)
+		return http.ListenAndServe("0.0.0.0:"+cfg.port, handler)
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.tlsPort,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+
+	redirect := httpsRedirect(cfg.tlsPort)
+	if cfg.mode == "autocert" {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.domains...),
+			Cache:      autocert.DirCache(cfg.cacheDir),
+			Email:      cfg.email,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		// Answers HTTP-01 challenges and redirects everything else
+		redirect = manager.HTTPHandler(redirect)
+	}
+
+	if cfg.httpPort != "off" {
+		go func() {
+			log.Printf("↪️ Redirecting HTTP on port %s to HTTPS", cfg.httpPort)
+			plain := &http.Server{Addr: ":" + cfg.httpPort, Handler: redirect, ReadHeaderTimeout: 10 * time.Second}
+			if err := plain.ListenAndServe(); err != nil {
+				log.Printf("❌ HTTP redirect listener stopped: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("🔒 Jevi Chat Server running on port %s with TLS (%s, HTTP/2 enabled)", cfg.tlsPort, cfg.mode)
+	return srv.ListenAndServeTLS(cfg.certFile, cfg.keyFile)
+}
+
+// httpsRedirect sends plain HTTP requests to the same URL over HTTPS.
+// Non-GET requests get 308 so the method and body survive the redirect.
+func httpsRedirect(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
@@ -3,8 +3,9 @@
     class JeviChatWidget {
         constructor(config) {
             this.projectId = config.projectId;
+            this.apiKey = config.apiKey || '';
             this.apiUrl = (config.apiUrl || 'https://geminiback-nxqj.onrender.com').replace(/\/+$/, ''); // ✅ remove trailing slash
-            this.position = config.position || 'bottom-right';
+            this.position = config.position || ''; // set: overrides the project's theme
             this.theme = config.theme || 'light';
             this.width = config.width || '400px';
             this.height = config.height || '600px';
@@ -15,13 +16,41 @@
         init() {
             this.createWidget();
             this.attachEvents();
+            this.loadTheme();
+        }
+
+        // Brand the launcher with the theme set for the project in the
+        // dashboard; the defaults stay if it cannot be loaded
+        async loadTheme() {
+            try {
+                const headers = this.apiKey ? { 'X-Jevi-Key': this.apiKey } : {};
+                const res = await fetch(`${this.apiUrl}/embed/${this.projectId}/config`, { headers });
+                if (!res.ok) return;
+                const { theme } = await res.json();
+                if (!theme) return;
+
+                if (!this.position && theme.position) {
+                    this.widgetContainer.classList.remove('bottom-right', 'bottom-left');
+                    this.widgetContainer.classList.add(theme.position);
+                    if (theme.position === 'bottom-left') {
+                        this.iframeContainer.style.right = 'auto';
+                        this.iframeContainer.style.left = '0';
+                    }
+                }
+                this.chatButton.style.background = `linear-gradient(135deg, ${theme.primary_color} 0%, ${theme.secondary_color} 100%)`;
+                if (theme.font_family) {
+                    this.widgetContainer.style.fontFamily = theme.font_family;
+                }
+            } catch (e) {
+                console.warn('Jevi Chat: theme unavailable', e);
+            }
         }
 
         createWidget() {
             // Create widget container
             const widgetContainer = document.createElement('div');
             widgetContainer.id = 'jevi-chat-widget';
-            widgetContainer.className = `jevi-widget ${this.position} ${this.theme}`;
+            widgetContainer.className = `jevi-widget ${this.position || 'bottom-right'} ${this.theme}`;
 
             // Create chat button
             const chatButton = document.createElement('div');
@@ -36,6 +65,9 @@
             // Create iframe
             const iframe = document.createElement('iframe');
             iframe.src = `${this.apiUrl}/embed/${this.projectId}`; // ✅ clean path
+            if (this.apiKey) {
+                iframe.src += `?key=${encodeURIComponent(this.apiKey)}`;
+            }
             iframe.style.width = this.width;
             iframe.style.height = this.height;
             iframe.style.border = 'none';
@@ -94,6 +126,7 @@
     if (autoInit) {
         const config = {
             projectId: autoInit.getAttribute('data-jevi-project-id'),
+            apiKey: autoInit.getAttribute('data-jevi-key'),
             apiUrl: autoInit.getAttribute('data-jevi-api-url'),
             position: autoInit.getAttribute('data-jevi-position'),
             theme: autoInit.getAttribute('data-jevi-theme'),
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff operations
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffLine is one line of a line-based diff. OldLine and NewLine are
+// 1-based line numbers, 0 where the line does not exist on that side.
+type DiffLine struct {
+	Op      string `json:"op"`
+	Text    string `json:"text"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+}
+
+// DiffLines computes a line diff of two texts from their longest common
+// subsequence. It is quadratic, which is fine for prompt-sized inputs.
+func DiffLines(oldText, newText string) []DiffLine {
+	a, b := splitLines(oldText), splitLines(newText)
+
+	// lcs[i][j] is the common subsequence length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []DiffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, DiffLine{Op: DiffEqual, Text: a[i], OldLine: i + 1, NewLine: j + 1})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, DiffLine{Op: DiffDelete, Text: a[i], OldLine: i + 1})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: DiffInsert, Text: b[j], NewLine: j + 1})
+			j++
+		}
+	}
+	return lines
+}
+
+// UnifiedDiff renders a diff in unified format with the given number of
+// context lines around each change. Identical texts give an empty string.
+func UnifiedDiff(lines []DiffLine, oldName, newName string, context int) string {
+	var changed []int
+	for k, l := range lines {
+		if l.Op != DiffEqual {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for k := 0; k < len(changed); {
+		start := max(changed[k]-context, 0)
+		end := changed[k] + context + 1
+		// Merge changes whose context overlaps into one hunk
+		for k++; k < len(changed) && changed[k]-context <= end; k++ {
+			end = changed[k] + context + 1
+		}
+		end = min(end, len(lines))
+
+		oldStart, newStart, oldCount, newCount := 0, 0, 0, 0
+		for _, l := range lines[start:end] {
+			if l.Op != DiffInsert {
+				if oldStart == 0 {
+					oldStart = l.OldLine
+				}
+				oldCount++
+			}
+			if l.Op != DiffDelete {
+				if newStart == 0 {
+					newStart = l.NewLine
+				}
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, l := range lines[start:end] {
+			prefix := " "
+			switch l.Op {
+			case DiffInsert:
+				prefix = "+"
+			case DiffDelete:
+				prefix = "-"
+			}
+			b.WriteString(prefix + l.Text + "\n")
+		}
+	}
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
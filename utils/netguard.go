@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// publicDialer connects to the addresses DialPublic picks
+var publicDialer = &net.Dialer{Timeout: 5 * time.Second}
+
+// DialPublic connects to the first public address of addr's host, and
+// refuses hosts that only resolve inside our own network. Clients calling
+// hosts chosen by customers or settings, like webhooks, dial with it.
+func DialPublic(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if PublicIP(ip.IP) {
+			return publicDialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		}
+	}
+	return nil, fmt.Errorf("%s does not resolve to a public address", host)
+}
+
+// PublicIP reports whether ip is reachable on the internet
+func PublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redaction profiles an export can be made with
+const (
+	RedactNone     = "none"
+	RedactPII      = "pii"
+	RedactExternal = "external"
+)
+
+// RedactionProfile says what is hidden from an export
+type RedactionProfile struct {
+	Name              string `json:"name"`
+	MaskEmails        bool   `json:"mask_emails"`
+	MaskPhones        bool   `json:"mask_phones"`
+	DropIPs           bool   `json:"drop_ips"`
+	PseudonymizeNames bool   `json:"pseudonymize_names"`
+	MaskProfanity     bool   `json:"mask_profanity"`
+}
+
+// redactionProfiles are the profiles exports are made with: "pii" hides
+// contact details; "external" is meant for sharing transcripts outside the
+// company and also replaces names and masks profanity
+var redactionProfiles = map[string]RedactionProfile{
+	RedactNone: {Name: RedactNone},
+	RedactPII:  {Name: RedactPII, MaskEmails: true, MaskPhones: true, DropIPs: true},
+	RedactExternal: {
+		Name:              RedactExternal,
+		MaskEmails:        true,
+		MaskPhones:        true,
+		DropIPs:           true,
+		PseudonymizeNames: true,
+		MaskProfanity:     true,
+	},
+}
+
+// LookupRedactionProfile returns the named profile; "" is RedactNone
+func LookupRedactionProfile(name string) (RedactionProfile, bool) {
+	if name == "" {
+		name = RedactNone
+	}
+	p, ok := redactionProfiles[strings.ToLower(name)]
+	return p, ok
+}
+
+// RedactionProfileNames lists the profiles, for error messages
+func RedactionProfileNames() []string {
+	names := make([]string, 0, len(redactionProfiles))
+	for name := range redactionProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// Seven or more digits, optionally with a leading + and separators
+	phonePattern = regexp.MustCompile(`\+?\d[\d\s().\-]{5,}\d`)
+
+	// defaultProfanity is masked by the "external" profile;
+	// REDACT_PROFANITY_WORDS adds comma-separated words
+	defaultProfanity = []string{"fuck", "fucking", "shit", "bitch", "bastard", "asshole", "cunt", "dick", "crap", "damn", "wanker", "bollocks"}
+)
+
+// Redactor applies a profile to the values of one export. Names are
+// replaced by "Person N", the same name always by the same pseudonym, so a
+// transcript stays readable.
+type Redactor struct {
+	Profile   RedactionProfile
+	names     map[string]string
+	namesText *regexp.Regexp
+	profanity *regexp.Regexp
+}
+
+// NewRedactor returns a redactor for profile
+func NewRedactor(profile RedactionProfile) *Redactor {
+	r := &Redactor{Profile: profile, names: map[string]string{}}
+	if profile.MaskProfanity {
+		words := append([]string{}, defaultProfanity...)
+		for _, w := range strings.Split(os.Getenv("REDACT_PROFANITY_WORDS"), ",") {
+			if w = strings.TrimSpace(w); w != "" {
+				words = append(words, w)
+			}
+		}
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			quoted[i] = regexp.QuoteMeta(w)
+		}
+		r.profanity = regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return r
+}
+
+// Enabled reports whether the redactor changes anything
+func (r *Redactor) Enabled() bool {
+	p := r.Profile
+	return p.MaskEmails || p.MaskPhones || p.DropIPs || p.PseudonymizeNames || p.MaskProfanity
+}
+
+// AddNames registers people's names, so mentions of them in free text are
+// pseudonymized too. Call it before Text.
+func (r *Redactor) AddNames(names ...string) {
+	if !r.Profile.PseudonymizeNames {
+		return
+	}
+	for _, name := range names {
+		r.Name(name)
+	}
+}
+
+// Name returns the pseudonym of a person's name
+func (r *Redactor) Name(name string) string {
+	name = strings.TrimSpace(name)
+	if !r.Profile.PseudonymizeNames || name == "" {
+		return name
+	}
+	key := strings.ToLower(name)
+	if alias, ok := r.names[key]; ok {
+		return alias
+	}
+	alias := fmt.Sprintf("Person %d", len(r.names)+1)
+	r.names[key] = alias
+	r.namesText = nil
+	return alias
+}
+
+// Email masks an email address, keeping its domain
+func (r *Redactor) Email(email string) string {
+	if !r.Profile.MaskEmails || email == "" {
+		return email
+	}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		return string([]rune(email)[:1]) + "***" + email[at:]
+	}
+	return "***"
+}
+
+// IP drops an IP address when the profile says so
+func (r *Redactor) IP(ip string) string {
+	if r.Profile.DropIPs {
+		return ""
+	}
+	return ip
+}
+
+// Text redacts free text: email addresses, phone numbers, registered names
+// and profanity, as the profile says
+func (r *Redactor) Text(s string) string {
+	if s == "" || !r.Enabled() {
+		return s
+	}
+	if r.Profile.MaskEmails {
+		s = emailPattern.ReplaceAllStringFunc(s, r.Email)
+	}
+	if r.Profile.MaskPhones {
+		s = phonePattern.ReplaceAllString(s, "[phone]")
+	}
+	if r.Profile.PseudonymizeNames && len(r.names) > 0 {
+		if r.namesText == nil {
+			r.namesText = namesPattern(r.names)
+		}
+		s = r.namesText.ReplaceAllStringFunc(s, func(m string) string { return r.names[strings.ToLower(m)] })
+	}
+	if r.profanity != nil {
+		s = r.profanity.ReplaceAllStringFunc(s, func(m string) string {
+			runes := []rune(m)
+			return string(runes[:1]) + strings.Repeat("*", len(runes)-1)
+		})
+	}
+	return s
+}
+
+// namesPattern matches any of the names as whole words, longest first so a
+// full name wins over a first name that is also registered. Names of one
+// or two letters would match ordinary words and are left out.
+func namesPattern(names map[string]string) *regexp.Regexp {
+	keys := make([]string, 0, len(names))
+	for name := range names {
+		if len([]rune(name)) > 2 {
+			keys = append(keys, name)
+		}
+	}
+	if len(keys) == 0 {
+		return regexp.MustCompile(`$^`)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for i, k := range keys {
+		keys[i] = regexp.QuoteMeta(k)
+	}
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(keys, "|") + `)\b`)
+}
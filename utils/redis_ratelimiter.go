@@ -41,6 +41,18 @@ func (rl *RedisRateLimiter) Allow(ctx context.Context, key string, limit redis_r
     return true, nil
 }
 
+// Check takes n requests from key's budget (n=0 only peeks). The request
+// was allowed if res.Allowed >= n; unlike Allow, a request that uses up the
+// last slot is allowed.
+func (rl *RedisRateLimiter) Check(ctx context.Context, key string, limit redis_rate.Limit, n int) (*redis_rate.Result, error) {
+    return rl.limiter.AllowN(ctx, key, limit, n)
+}
+
+// Ping verifies the Redis connection
+func (rl *RedisRateLimiter) Ping(ctx context.Context) error {
+    return rl.client.Ping(ctx).Err()
+}
+
 // Close closes the Redis connection
 func (rl *RedisRateLimiter) Close() error {
     return rl.client.Close()
@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// A sealed stream is encrypted with a key derived from a passphrase, so it
+// can be opened on another deployment that does not share this one's
+// SECRETS_ENCRYPTION_KEY. It is written as a header followed by chunks of up
+// to sealedChunkSize bytes, each sealed with AES-256-GCM. A chunk's nonce
+// carries its number and whether it is the last one, so chunks that were
+// reordered, dropped or cut off fail to open.
+const (
+	sealedMagic     = "JEVISEAL"
+	sealedVersion   = 1
+	sealedChunkSize = 64 * 1024
+	sealedSaltSize  = 16
+	sealedPrefix    = 7 // random nonce bytes; the other five count chunks
+
+	// Argon2id parameters for new streams; readers take them from the header
+	sealedTime    = 3
+	sealedMemory  = 64 * 1024 // KiB
+	sealedThreads = 4
+
+	// header: magic, version, time, memory, threads, salt, nonce prefix
+	sealedHeaderSize = len(sealedMagic) + 1 + 4 + 4 + 1 + sealedSaltSize + sealedPrefix
+)
+
+var (
+	// ErrSealedFormat is returned for data that is not a sealed stream
+	ErrSealedFormat = errors.New("not a sealed archive")
+	// ErrSealedOpen is returned when a chunk fails to open: the passphrase
+	// is wrong, or the data was changed
+	ErrSealedOpen = errors.New("wrong passphrase, or the archive was modified")
+	// ErrSealedTruncated is returned when the stream ends before its last chunk
+	ErrSealedTruncated = errors.New("the archive is incomplete")
+)
+
+func sealedKey(passphrase string, salt []byte, time, memory uint32, threads uint8) (cipher.AEAD, error) {
+	if time == 0 || time > 10 || memory < 8*1024 || memory > 1024*1024 || threads == 0 {
+		return nil, ErrSealedFormat
+	}
+	key := argon2.IDKey([]byte(passphrase), salt, time, memory, threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealedNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[sealedPrefix:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// SealedWriter encrypts what is written to it. Close must be called to
+// write the last chunk; it does not close the underlying writer.
+type SealedWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewSealedWriter writes the stream header to w and returns a writer that
+// encrypts with a key derived from passphrase
+func NewSealedWriter(w io.Writer, passphrase string) (*SealedWriter, error) {
+	header := make([]byte, 0, sealedHeaderSize)
+	header = append(header, sealedMagic...)
+	header = append(header, sealedVersion)
+	header = binary.BigEndian.AppendUint32(header, sealedTime)
+	header = binary.BigEndian.AppendUint32(header, sealedMemory)
+	header = append(header, sealedThreads)
+	random := make([]byte, sealedSaltSize+sealedPrefix)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	header = append(header, random...)
+
+	aead, err := sealedKey(passphrase, random[:sealedSaltSize], sealedTime, sealedMemory, sealedThreads)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &SealedWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: random[sealedSaltSize:],
+		buf:    make([]byte, 0, sealedChunkSize),
+	}, nil
+}
+
+func (s *SealedWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write to closed sealed writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, since the
+		// last chunk has to be marked as such
+		if len(s.buf) == sealedChunkSize {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(s.buf[len(s.buf):sealedChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (s *SealedWriter) flush(last bool) error {
+	if s.counter == ^uint32(0) {
+		return errors.New("sealed stream is too long")
+	}
+	sealed := s.aead.Seal(nil, sealedNonce(s.prefix, s.counter, last), s.buf, s.header)
+	s.counter++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+// Close seals the last chunk
+func (s *SealedWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+// sealedReader decrypts a sealed stream chunk by chunk
+type sealedReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	chunk   []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// NewSealedReader reads the stream header from r and returns a reader of
+// the decrypted data. Wrong passphrases are only detected on the first
+// read, when the first chunk fails to open with ErrSealedOpen.
+func NewSealedReader(r io.Reader, passphrase string) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, sealedChunkSize+64)
+	header := make([]byte, sealedHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, ErrSealedFormat
+	}
+	if string(header[:len(sealedMagic)]) != sealedMagic {
+		return nil, ErrSealedFormat
+	}
+	p := header[len(sealedMagic):]
+	if p[0] != sealedVersion {
+		return nil, fmt.Errorf("unsupported sealed archive version %d", p[0])
+	}
+	time := binary.BigEndian.Uint32(p[1:5])
+	memory := binary.BigEndian.Uint32(p[5:9])
+	threads := p[9]
+	salt := p[10 : 10+sealedSaltSize]
+
+	aead, err := sealedKey(passphrase, salt, time, memory, threads)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedReader{
+		r:      br,
+		aead:   aead,
+		header: header,
+		prefix: p[10+sealedSaltSize:],
+		chunk:  make([]byte, sealedChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (s *sealedReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+func (s *sealedReader) next() error {
+	n, err := io.ReadFull(s.r, s.chunk)
+	if err == io.EOF {
+		return ErrSealedTruncated
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	// A short chunk, or a full one with nothing after it, is the last
+	last := n < len(s.chunk)
+	if !last {
+		if _, err := s.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+	plain, err := s.aead.Open(s.chunk[:0:0], sealedNonce(s.prefix, s.counter, last), s.chunk[:n], s.header)
+	if err != nil {
+		if last && s.counter > 0 {
+			return ErrSealedTruncated
+		}
+		return ErrSealedOpen
+	}
+	s.counter++
+	s.plain = plain
+	s.done = last
+	return nil
+}